@@ -0,0 +1,117 @@
+// advisor.go
+package main
+
+import (
+	"fmt"
+	"github.com/mariadb-corporation/replication-manager/pkg/cluster"
+	"os"
+	"sort"
+	"strings"
+)
+
+/* Suggested manual remediation step, with the data supporting it */
+type Advice struct {
+	Priority int
+	Action   string
+	Data     string
+}
+
+/* Slave considered for a manual promotion, with its progress */
+type candidate struct {
+	sm  *ServerMonitor
+	seq uint64
+}
+
+/* Builds the prioritized remediation steps after an automatic failover aborted, from a fresh look at each slave */
+func (master *ServerMonitor) adviseRecovery(reason string) []Advice {
+	var l []Advice
+	var candidates []candidate
+	for _, sl := range master.cluster.slaves {
+		if (sl.Conn == nil && sl.db == nil) || sl.backend().Ping() != nil {
+			l = append(l, Advice{3, fmt.Sprintf("Restore connectivity to slave %s, or check whether the host is down", sl.label()), "Server does not answer"})
+			continue
+		}
+		rows, err := sl.query("SHOW SLAVE STATUS")
+		if err != nil || len(rows) == 0 {
+			l = append(l, Advice{4, fmt.Sprintf("Check the replication configuration of %s", sl.label()), "No slave status"})
+			continue
+		}
+		r := rows[0]
+		if r["Slave_SQL_Running"] != "Yes" && r["Last_SQL_Error"] != "" {
+			l = append(l, Advice{2, fmt.Sprintf("Fix the SQL thread error on %s, then restart replication with START SLAVE", sl.label()),
+				fmt.Sprintf("Last_SQL_Errno %s: %s", r["Last_SQL_Errno"], r["Last_SQL_Error"])})
+		}
+		if sl.inMaintenance() {
+			continue
+		}
+		if sl.ignored() {
+			l = append(l, Advice{4, fmt.Sprintf("Consider promoting %s although it is in the ignore list", sl.label()), "Listed in -ignore-servers"})
+			continue
+		}
+		if sl.isDelayed() {
+			l = append(l, Advice{4, fmt.Sprintf("Consider promoting %s once it applied its relay logs with CHANGE MASTER TO MASTER_DELAY=0", sl.label()), fmt.Sprintf("Delayed replica, MASTER_DELAY=%d", sl.SQLDelay)})
+			continue
+		}
+		candidates = append(candidates, candidate{sl, master.cluster.safeSeq(sl)})
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].seq > candidates[j].seq })
+	var positions []string
+	for _, c := range candidates {
+		positions = append(positions, fmt.Sprintf("%s at %s (sequence %d)", c.sm.URL, c.sm.CurrentGtid, c.seq))
+	}
+	if len(candidates) > 0 {
+		best := candidates[0].sm
+		if master.cluster.anyPositional() == false && best.Flavor == FLAVOR_MARIADB {
+			// With several GTID domains the highest sequence sum may miss transactions of another slave, the slave covering all others is promoted
+			best = nil
+			for _, c := range candidates {
+				if coversAll(c.sm, candidates) {
+					best = c.sm
+					break
+				}
+			}
+		}
+		if best == nil {
+			l = append(l, Advice{1, "Resolve the GTID divergence before any promotion: each slave holds transactions that another lacks, compare them with mysqlbinlog and apply the missing ones or rebuild the slaves from the chosen one", strings.Join(positions, "; ")})
+		} else {
+			l = append(l, Advice{1, fmt.Sprintf("Promote the most advanced slave %s manually: %s -prefmaster %s -failover force", best.label(), os.Args[0], best.URL), strings.Join(positions, "; ")})
+			if len(candidates) > 1 && candidates[0].seq != candidates[len(candidates)-1].seq {
+				l = append(l, Advice{3, fmt.Sprintf("Check that the slaves behind %s catch up once they replicate from it", best.label()), strings.Join(positions, "; ")})
+			}
+		}
+	}
+	l = append(l, Advice{5, fmt.Sprintf("Confirm that master %s is really down before any promotion, to avoid two writable masters", master.label()), "Failover aborted: " + reason})
+	sort.SliceStable(l, func(i, j int) bool { return l[i].Priority < l[j].Priority })
+	return l
+}
+
+/* True when the GTID position of the slave is at or ahead of the positions of all the candidates in every domain */
+func coversAll(sm *ServerMonitor, candidates []candidate) bool {
+	for _, c := range candidates {
+		if cluster.GTIDCovers(sm.CurrentGtid, c.sm.CurrentGtid) == false {
+			return false
+		}
+	}
+	return true
+}
+
+/* Returns the progress of a slave for ranking, without failing on positions getSeqFromGtid cannot parse */
+func (c *Cluster) safeSeq(sm *ServerMonitor) uint64 {
+	if c.anyPositional() {
+		return sm.positionSeq()
+	}
+	return cluster.GTIDCount(sm.Flavor, sm.CurrentGtid)
+}
+
+/* Logs the remediation steps and sends them in an alert */
+func (master *ServerMonitor) reportAbort(reason string) {
+	advice := master.adviseRecovery(reason)
+	var b strings.Builder
+	fmt.Fprintf(&b, "Automatic failover of %s aborted: %s. Suggested steps:", master.label(), reason)
+	logprintf("ERROR: Failover aborted: %s", reason)
+	for i, a := range advice {
+		logprintf("INFO : Suggested step %d. %s [%s]", i+1, a.Action, a.Data)
+		fmt.Fprintf(&b, "\n%d. %s\n   %s", i+1, a.Action, a.Data)
+	}
+	master.cluster.alert(ALERT_ABORTED, master.URL, "%s", b.String())
+}
@@ -0,0 +1,105 @@
+// advisor_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdviseRecovery(t *testing.T) {
+	tests := []struct {
+		name   string
+		specs  []simSpec
+		change func(sims map[string]*simServer)
+		want   []string // priority and start of the action of each step
+	}{
+		{"most advanced slave", simTopology(), nil, []string{
+			"1 Promote the most advanced slave db3:3306 manually",
+			"3 Check that the slaves behind db3:3306 catch up",
+			"5 Confirm that master db1:3306 is really down"}},
+		{"slaves down", simChange(simTopology(), func(sp *simSpec) { sp.down = true }), nil, []string{
+			"3 Restore connectivity to slave db2:3306",
+			"3 Restore connectivity to slave db3:3306",
+			"5 Confirm that master db1:3306 is really down"}},
+		{"SQL thread error", simChange(simTopology(), func(sp *simSpec) { sp.stopped = sp.id == 3 }), func(sims map[string]*simServer) {
+			sims["db3:3306"].status.Last_SQL_Errno, sims["db3:3306"].status.Last_SQL_Error = 1062, "Duplicate entry '4' for key 'PRIMARY'"
+		}, []string{
+			"1 Promote the most advanced slave db3:3306 manually",
+			"2 Fix the SQL thread error on db3:3306",
+			"3 Check that the slaves behind db3:3306 catch up",
+			"5 Confirm that master db1:3306 is really down"}},
+		{"GTID divergence", simChange(simTopology(), func(sp *simSpec) {
+			if sp.id == 2 {
+				sp.gtid = "0-1-110,1-2-10"
+			}
+		}), nil, []string{
+			"1 Resolve the GTID divergence before any promotion",
+			"5 Confirm that master db1:3306 is really down"}},
+		{"delayed slave", simChange(simTopology(), func(sp *simSpec) {
+			if sp.id == 3 {
+				sp.sqlDelay = 3600
+			}
+		}), nil, []string{
+			"1 Promote the most advanced slave db2:3306 manually",
+			"4 Consider promoting db3:3306 once it applied its relay logs",
+			"5 Confirm that master db1:3306 is really down"}},
+	}
+	for _, tt := range tests {
+		sims := simCluster(t, tt.specs)
+		if tt.change != nil {
+			tt.change(sims)
+		}
+		for _, sl := range current.slaves {
+			sl.refresh()
+		}
+		advice := simServerByURL("db1:3306").adviseRecovery("no viable candidate")
+		var got []string
+		for _, a := range advice {
+			got = append(got, fmt.Sprintf("%d %s", a.Priority, a.Action))
+		}
+		ok := len(got) == len(tt.want)
+		for i := 0; ok && i < len(got); i++ {
+			ok = strings.HasPrefix(got[i], tt.want[i])
+		}
+		if ok == false {
+			t.Errorf("%s: advice\n%s\nwant\n%s", tt.name, strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+		}
+	}
+}
+
+func TestAbortAlert(t *testing.T) {
+	defer func(f, u string) { *failover, *webhookURL = f, u }(*failover, *webhookURL)
+	posts := make(chan map[string]interface{}, 8)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		posts <- payload
+	}))
+	defer srv.Close()
+	*failover, *webhookURL = "force", srv.URL
+	simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.down = true }))
+	current.master = current.findMaster(false)
+	if nmUrl, _ := current.Failover(context.Background()); nmUrl != "" {
+		t.Fatalf("Failover() promoted %s without a viable candidate", nmUrl)
+	}
+	alertFlush()
+	for len(posts) > 0 {
+		p := <-posts
+		if p["event"] != ALERT_ABORTED {
+			continue
+		}
+		text, _ := p["text"].(string)
+		for _, want := range []string{"Automatic failover of db1:3306 aborted: no viable candidate. Suggested steps:", "1. Restore connectivity to slave db2:3306", "3. Confirm that master db1:3306 is really down"} {
+			if strings.Contains(text, want) == false {
+				t.Errorf("abort alert does not contain %q:\n%s", want, text)
+			}
+		}
+		return
+	}
+	t.Error("no failover-aborted alert posted")
+}
@@ -0,0 +1,351 @@
+// alert.go
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/nsf/termbox-go"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ALERT_SERVER_FAILED   string = "server-failed"
+	ALERT_FAILOVER        string = "failover-started"
+	ALERT_FAILOVER_DONE   string = "failover-complete"
+	ALERT_SWITCHOVER      string = "switchover-started"
+	ALERT_SWITCHOVER_DONE string = "switchover-complete"
+	ALERT_DELAY           string = "replication-delay"
+	ALERT_REJOINED        string = "slave-rejoined"
+	ALERT_ABORTED         string = "failover-aborted"
+	ALERT_STALLED         string = "monitor-stalled"
+	ALERT_REATTACHED      string = "slaves-reattached"
+	ALERT_REPL_ERROR      string = "replication-error"
+	ALERT_ROLLBACK        string = "switchover-rolled-back"
+	ALERT_ADMIN_FAILED    string = "admin-connection-failed"
+)
+
+const (
+	SEVERITY_INFO     string = "info"
+	SEVERITY_WARNING  string = "warning"
+	SEVERITY_CRITICAL string = "critical"
+)
+
+/* Planned operations are informational, emergencies are critical */
+var alertSeverity = map[string]string{
+	ALERT_SERVER_FAILED:   SEVERITY_CRITICAL,
+	ALERT_FAILOVER:        SEVERITY_CRITICAL,
+	ALERT_FAILOVER_DONE:   SEVERITY_CRITICAL,
+	ALERT_SWITCHOVER:      SEVERITY_INFO,
+	ALERT_SWITCHOVER_DONE: SEVERITY_INFO,
+	ALERT_DELAY:           SEVERITY_WARNING,
+	ALERT_REJOINED:        SEVERITY_INFO,
+	ALERT_ABORTED:         SEVERITY_CRITICAL,
+	ALERT_STALLED:         SEVERITY_CRITICAL,
+	ALERT_REATTACHED:      SEVERITY_WARNING,
+	ALERT_REPL_ERROR:      SEVERITY_CRITICAL,
+	ALERT_ROLLBACK:        SEVERITY_CRITICAL,
+	ALERT_ADMIN_FAILED:    SEVERITY_WARNING,
+}
+
+/* Events about the state of a single server, suppressed while it is in maintenance */
+var serverEvents = map[string]bool{
+	ALERT_SERVER_FAILED: true,
+	ALERT_DELAY:         true,
+	ALERT_REJOINED:      true,
+	ALERT_REPL_ERROR:    true,
+}
+
+type Alert struct {
+	Event    string
+	Severity string
+	Server   string
+	Name     string
+	Message  string
+	Tags     []string
+	Time     time.Time
+}
+
+/* A routing rule sends the alerts matching all of its criteria to its channels */
+type AlertRoute struct {
+	Event    string
+	Severity string
+	Tag      string
+	Channels []string
+}
+
+var alertRoutes []AlertRoute
+
+/* A send of an alert or metrics, to the target named in the logs */
+type delivery struct {
+	target string
+	send   func() error
+}
+
+/* Deliveries run in order by the alert worker, so that a slow SMTP relay or webhook cannot stall the monitor or an operation. Their failures are reported by the monitor loop. */
+var (
+	alertQueue   = make(chan delivery, 256)
+	alertWorker  sync.Once
+	alertPending sync.WaitGroup
+	alertMutex   sync.Mutex
+	alertErrors  []string
+)
+
+/* Builds an alert and sends it to the channels of the matching routing rules, or to the default channels if no rule matches */
+func (c *Cluster) alert(event string, server string, format string, args ...interface{}) {
+	if serverEvents[event] && c.inMaintenance(server) {
+		alertLog("INFO : Server %s is in maintenance, %s alert suppressed", server, event)
+		return
+	}
+	a := Alert{Event: event, Severity: alertSeverity[event], Server: server, Name: aliasOf(server), Message: fmt.Sprintf(format, args...), Tags: c.clusterTags, Time: time.Now()}
+	if *dryRun {
+		alertLog("DRY-RUN: would send %s alert: %s", a.Event, a.Message)
+		return
+	}
+	c.metricEvent(event)
+	c.publishEvent(a)
+	a.dispatch()
+}
+
+/* Queues the alert for the channels of the matching routing rules, or the default channels */
+func (a Alert) dispatch() {
+	channels := a.route()
+	if len(channels) == 0 {
+		if *mailTo != "" {
+			channels = append(channels, "mail:"+*mailTo)
+		}
+		if *webhookURL != "" {
+			channels = append(channels, "webhook:"+*webhookURL)
+		}
+	}
+	for _, ch := range channels {
+		ch := ch
+		deliver("alert to "+ch, func() error { return a.send(ch) })
+	}
+}
+
+/* Queues a send for the alert worker, started on the first one. A send is dropped when the queue is full. */
+func deliver(target string, send func() error) {
+	alertWorker.Do(func() {
+		go func() {
+			for d := range alertQueue {
+				err := d.send()
+				if err != nil {
+					alertMutex.Lock()
+					alertErrors = append(alertErrors, fmt.Sprintf("Could not send %s: %s", d.target, err))
+					alertMutex.Unlock()
+				}
+				alertPending.Done()
+			}
+		}()
+	})
+	alertPending.Add(1)
+	select {
+	case alertQueue <- delivery{target: target, send: send}:
+	default:
+		alertPending.Done()
+		alertLog("WARN : Alert queue full, %s dropped", target)
+	}
+}
+
+/* Sends the metric events counted since the last check and reports the sends that failed */
+func alertCheck() {
+	metricEventsFlush()
+	alertMutex.Lock()
+	errs := alertErrors
+	alertErrors = nil
+	alertMutex.Unlock()
+	for _, e := range errs {
+		alertLog("WARN : %s", e)
+	}
+}
+
+/* Waits for the queued sends and reports them, before a one-shot command exits */
+func alertFlush() {
+	metricEventsFlush()
+	alertPending.Wait()
+	alertCheck()
+}
+
+/* Returns the channels of all routing rules matching the alert, without duplicates */
+func (a Alert) route() []string {
+	var channels []string
+	for _, r := range alertRoutes {
+		if r.Event != "" {
+			if ok, _ := path.Match(r.Event, a.Event); ok == false {
+				continue
+			}
+		}
+		if r.Severity != "" && r.Severity != a.Severity {
+			continue
+		}
+		if r.Tag != "" && contains(a.Tags, r.Tag) == false {
+			continue
+		}
+		for _, ch := range r.Channels {
+			if contains(channels, ch) == false {
+				channels = append(channels, ch)
+			}
+		}
+	}
+	return channels
+}
+
+func (a Alert) send(channel string) error {
+	items := strings.SplitN(channel, ":", 2)
+	switch items[0] {
+	case "mail":
+		return a.email(items[1])
+	case "webhook":
+		return a.post(items[1])
+	case "pagerduty":
+		return a.page(items[1])
+	}
+	return errors.New("Unknown channel type " + items[0])
+}
+
+/* Sends the alert through the configured SMTP relay. The whole exchange is bounded by a deadline so that a black-holed relay cannot stall the monitor. */
+func (a Alert) email(to string) error {
+	conn, err := net.DialTimeout("tcp", *mailSMTP, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	host, _, _ := net.SplitHostPort(*mailSMTP)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if err = c.Mail(*mailFrom); err != nil {
+		return err
+	}
+	rcpts := strings.Split(to, ",")
+	for _, rcpt := range rcpts {
+		if err = c.Rcpt(strings.TrimSpace(rcpt)); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	hostname, _ := os.Hostname()
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: [repmgr] [%s] %s: %s\r\nDate: %s\r\n\r\n%s\r\n\r\nSent by replication-manager %s on %s at %s\r\n",
+		*mailFrom, to, a.Severity, a.Event, a.Name, a.Time.Format(time.RFC1123Z), a.Message, repmgrVersion, hostname, a.Time.Format("2006-01-02 15:04:05"))
+	if _, err = w.Write([]byte(msg)); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+/* Posts the alert to a webhook as a Slack compatible JSON payload. The alert fields are added for receivers other than Slack. */
+func (a Alert) post(url string) error {
+	return postJSON(url, map[string]interface{}{
+		"username": "replication-manager",
+		"text":     fmt.Sprintf("*%s* [%s]: %s", a.Event, a.Severity, a.Message),
+		"event":    a.Event,
+		"severity": a.Severity,
+		"server":   a.Server,
+		"name":     a.Name,
+		"tags":     a.Tags,
+		"time":     a.Time.Format(time.RFC3339),
+	})
+}
+
+/* Triggers a PagerDuty incident through the Events API, deduplicated per event and server */
+func (a Alert) page(key string) error {
+	return postJSON("https://events.pagerduty.com/v2/enqueue", map[string]interface{}{
+		"routing_key":  key,
+		"event_action": "trigger",
+		"dedup_key":    a.Event + "/" + a.Server,
+		"payload": map[string]interface{}{
+			"summary":   a.Message,
+			"source":    a.Name,
+			"severity":  a.Severity,
+			"component": "replication-manager",
+			"group":     strings.Join(a.Tags, ","),
+			"class":     a.Event,
+			"timestamp": a.Time.Format(time.RFC3339),
+		},
+	})
+}
+
+func postJSON(url string, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return errors.New(fmt.Sprintf("%s returned %s", url, resp.Status))
+	}
+	return nil
+}
+
+/* Loads routing rules, one per line, made of criteria (event=<glob>, severity=<level>, tag=<tag>, or * to match everything) followed by channels (mail:<addresses>, webhook:<url>, pagerduty:<routing key>) */
+func loadAlertRoutes(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var r AlertRoute
+		for _, tok := range strings.Fields(line) {
+			switch {
+			case tok == "*":
+			case strings.HasPrefix(tok, "event="):
+				r.Event = strings.TrimPrefix(tok, "event=")
+			case strings.HasPrefix(tok, "severity="):
+				r.Severity = strings.TrimPrefix(tok, "severity=")
+			case strings.HasPrefix(tok, "tag="):
+				r.Tag = strings.TrimPrefix(tok, "tag=")
+			case strings.HasPrefix(tok, "mail:"), strings.HasPrefix(tok, "webhook:"), strings.HasPrefix(tok, "pagerduty:"):
+				r.Channels = append(r.Channels, tok)
+			default:
+				return errors.New(fmt.Sprintf("%s line %d: unknown token %s", file, n, tok))
+			}
+		}
+		if len(r.Channels) == 0 {
+			return errors.New(fmt.Sprintf("%s line %d: rule has no channel", file, n))
+		}
+		alertRoutes = append(alertRoutes, r)
+	}
+	return scanner.Err()
+}
+
+/* Alerts can be raised while the console is up or during a command line failover, log to whichever is active */
+func alertLog(format string, args ...interface{}) {
+	if termbox.IsInit {
+		tlog.Add(fmt.Sprintf(format, args...))
+	} else {
+		log.Printf(format, args...)
+	}
+}
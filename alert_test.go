@@ -0,0 +1,250 @@
+// alert_test.go
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+/* SMTP relay accepting every message, each sent on the channel as its headers and body */
+func simSMTP(t *testing.T) (string, chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mails := make(chan string, 16)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			r := bufio.NewReader(conn)
+			conn.Write([]byte("220 sim\r\n"))
+			var data []string
+			inData := false
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					break
+				}
+				line = strings.TrimRight(line, "\r\n")
+				if inData {
+					if line == "." {
+						inData = false
+						mails <- strings.Join(data, "\n")
+						conn.Write([]byte("250 queued\r\n"))
+					} else {
+						data = append(data, line)
+					}
+					continue
+				}
+				cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+				switch cmd {
+				case "DATA":
+					inData = true
+					conn.Write([]byte("354 go ahead\r\n"))
+				case "QUIT":
+					conn.Write([]byte("221 bye\r\n"))
+				default:
+					conn.Write([]byte("250 ok\r\n"))
+				}
+				if cmd == "QUIT" {
+					break
+				}
+			}
+			conn.Close()
+		}
+	}()
+	return l.Addr().String(), mails
+}
+
+func TestMasterFailureMail(t *testing.T) {
+	defer func(s, to string) { *mailSMTP, *mailTo = s, to }(*mailSMTP, *mailTo)
+	addr, mails := simSMTP(t)
+	*mailSMTP, *mailTo = addr, "dba@example.com"
+	sims := simCluster(t, simTopology())
+	current.master = current.findMaster(true)
+	current.master.State = STATE_MASTER
+	sims["db1:3306"].down = true
+	for i := 0; i < *maxFail; i++ {
+		current.refreshTopology(context.Background())
+	}
+	alertFlush()
+	select {
+	case m := <-mails:
+		for _, want := range []string{"To: dba@example.com", "Subject: [repmgr] [critical] server-failed: db1:3306", "Server db1:3306 is unreachable"} {
+			if strings.Contains(m, want) == false {
+				t.Errorf("mail does not contain %q:\n%s", want, m)
+			}
+		}
+	default:
+		t.Fatal("no mail sent on master failure")
+	}
+	select {
+	case m := <-mails:
+		t.Errorf("unexpected mail:\n%s", m)
+	default:
+	}
+}
+
+func TestAlertSendFailure(t *testing.T) {
+	defer func(s, to string) { *mailSMTP, *mailTo = s, to }(*mailSMTP, *mailTo)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The relay is gone, the send fails and is reported at the next check
+	*mailSMTP, *mailTo = l.Addr().String(), "dba@example.com"
+	l.Close()
+	simCluster(t, simTopology())
+	current.alert(ALERT_FAILOVER, "db1:3306", "Failover started on master %s", "db1:3306")
+	metricEventsFlush()
+	alertPending.Wait()
+	alertMutex.Lock()
+	errs := alertErrors
+	alertMutex.Unlock()
+	if len(errs) != 1 || strings.HasPrefix(errs[0], "Could not send alert to mail:dba@example.com") == false {
+		t.Errorf("send errors %q, want one for mail:dba@example.com", errs)
+	}
+	alertCheck()
+	if len(alertErrors) != 0 {
+		t.Errorf("send errors %q left after the check", alertErrors)
+	}
+}
+
+func TestWebhookAlert(t *testing.T) {
+	defer func(u string) { *webhookURL = u }(*webhookURL)
+	posts := make(chan map[string]interface{}, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		if r.Header.Get("Content-Type") != "application/json" || json.NewDecoder(r.Body).Decode(&payload) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		posts <- payload
+	}))
+	defer srv.Close()
+	*webhookURL = srv.URL
+	simCluster(t, simTopology())
+	current.clusterTags = []string{"sim"}
+	current.alert(ALERT_FAILOVER_DONE, "db2:3306", "Failover complete, %s has been promoted to replace %s", "db2:3306", "db1:3306")
+	alertFlush()
+	select {
+	case p := <-posts:
+		want := map[string]string{"username": "replication-manager", "event": ALERT_FAILOVER_DONE, "severity": SEVERITY_CRITICAL, "server": "db2:3306",
+			"text": "*failover-complete* [critical]: Failover complete, db2:3306 has been promoted to replace db1:3306"}
+		for k, v := range want {
+			if p[k] != v {
+				t.Errorf("payload %s = %v, want %q", k, p[k], v)
+			}
+		}
+		if tags, _ := p["tags"].([]interface{}); len(tags) != 1 || tags[0] != "sim" {
+			t.Errorf("payload tags = %v, want [sim]", p["tags"])
+		}
+	default:
+		t.Fatal("no webhook posted")
+	}
+}
+
+func TestWebhookError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid token", http.StatusForbidden)
+	}))
+	defer srv.Close()
+	err := Alert{Event: ALERT_FAILOVER}.post(srv.URL)
+	if err == nil || strings.Contains(err.Error(), "403") == false {
+		t.Errorf("post() = %v, want the 403 status", err)
+	}
+}
+
+func TestAlertRoutes(t *testing.T) {
+	defer func(s, to, u string) { *mailSMTP, *mailTo, *webhookURL, alertRoutes = s, to, u, nil }(*mailSMTP, *mailTo, *webhookURL)
+	*mailTo, *webhookURL = "", ""
+	hook := func(events chan string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var payload map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&payload)
+			events <- payload["event"].(string)
+		}))
+	}
+	planned, oncall := make(chan string, 8), make(chan string, 8)
+	ops, pager := hook(planned), hook(oncall)
+	defer ops.Close()
+	defer pager.Close()
+	file := filepath.Join(t.TempDir(), "routes")
+	rules := "# planned operations\nevent=switchover-* webhook:" + ops.URL + "\n\nseverity=critical tag=prod webhook:" + pager.URL + "\n"
+	if err := ioutil.WriteFile(file, []byte(rules), 0600); err != nil {
+		t.Fatal(err)
+	}
+	alertRoutes = nil
+	if err := loadAlertRoutes(file); err != nil {
+		t.Fatalf("loadAlertRoutes() = %s", err)
+	}
+	simCluster(t, simTopology())
+	tests := []struct {
+		name    string
+		event   string
+		tags    []string
+		planned []string
+		oncall  []string
+	}{
+		{"planned operation", ALERT_SWITCHOVER, []string{"prod"}, []string{ALERT_SWITCHOVER}, nil},
+		{"emergency on production", ALERT_FAILOVER, []string{"prod"}, nil, []string{ALERT_FAILOVER}},
+		{"emergency elsewhere", ALERT_FAILOVER, []string{"staging"}, nil, nil},
+		{"warning on production", ALERT_DELAY, []string{"prod"}, nil, nil},
+	}
+	drain := func(c chan string) []string {
+		var l []string
+		for {
+			select {
+			case e := <-c:
+				l = append(l, e)
+			default:
+				return l
+			}
+		}
+	}
+	for _, tt := range tests {
+		current.clusterTags = tt.tags
+		current.alert(tt.event, "db1:3306", "Event on %s", "db1:3306")
+		alertFlush()
+		if got := drain(planned); reflect.DeepEqual(got, tt.planned) == false {
+			t.Errorf("%s: planned channel got %q, want %q", tt.name, got, tt.planned)
+		}
+		if got := drain(oncall); reflect.DeepEqual(got, tt.oncall) == false {
+			t.Errorf("%s: on-call channel got %q, want %q", tt.name, got, tt.oncall)
+		}
+	}
+	current.clusterTags = nil
+}
+
+func TestLoadAlertRoutesErrors(t *testing.T) {
+	defer func() { alertRoutes = nil }()
+	tests := []struct {
+		name  string
+		rules string
+	}{
+		{"unknown token", "event=failover-* sms:0600000000\n"},
+		{"rule without channel", "severity=critical\n"},
+	}
+	for _, tt := range tests {
+		file := filepath.Join(t.TempDir(), "routes")
+		if err := ioutil.WriteFile(file, []byte(tt.rules), 0600); err != nil {
+			t.Fatal(err)
+		}
+		alertRoutes = nil
+		if err := loadAlertRoutes(file); err == nil || strings.Contains(err.Error(), "line 1") == false {
+			t.Errorf("%s: loadAlertRoutes() = %v, want an error on line 1", tt.name, err)
+		}
+	}
+}
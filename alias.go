@@ -0,0 +1,45 @@
+// alias.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+/* Parses a comma separated list of host:[port]=name pairs */
+func parseAliases(s string) (map[string]string, error) {
+	m := make(map[string]string)
+	for _, item := range strings.Split(s, ",") {
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, errors.New(fmt.Sprintf("invalid host alias %s, expected host:[port]=name", item))
+		}
+		m[hostKey(strings.TrimSpace(kv[0]))] = strings.TrimSpace(kv[1])
+	}
+	return m, nil
+}
+
+/* Returns the alias of a server URL, or the URL itself if it has none */
+func aliasOf(url string) string {
+	if name, ok := hostAliases[hostKey(url)]; ok {
+		return name
+	}
+	return url
+}
+
+/* Returns the short name shown in the host columns of the console */
+func (sm *ServerMonitor) displayHost() string {
+	if sm.Name != "" {
+		return sm.Name
+	}
+	return sm.Host
+}
+
+/* Returns the alias followed by the address, for messages meant for people */
+func (sm *ServerMonitor) label() string {
+	if sm.Name != "" {
+		return sm.Name + " (" + sm.URL + ")"
+	}
+	return sm.URL
+}
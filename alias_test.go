@@ -0,0 +1,66 @@
+// alias_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseAliases(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want map[string]string // nil for an error
+	}{
+		{"aliases", "db1:3306=db-primary-eu1, db2=db-replica-eu1", map[string]string{"db1:3306": "db-primary-eu1", "db2:3306": "db-replica-eu1"}},
+		{"other port", "db1:3307=db-primary-eu1", map[string]string{"db1:3307": "db-primary-eu1"}},
+		{"missing name", "db1:3306=", nil},
+		{"missing host", "=db-primary-eu1", nil},
+		{"missing separator", "db1:3306", nil},
+	}
+	for _, tt := range tests {
+		got, err := parseAliases(tt.s)
+		if tt.want == nil && err == nil || tt.want != nil && reflect.DeepEqual(got, tt.want) == false {
+			t.Errorf("%s: parseAliases(%q) = %v, %v, want %v", tt.name, tt.s, got, err, tt.want)
+		}
+	}
+}
+
+func TestAliasInFailoverAlert(t *testing.T) {
+	defer func(f, u string) { *failover, *webhookURL, hostAliases = f, u, nil }(*failover, *webhookURL)
+	posts := make(chan map[string]interface{}, 8)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		posts <- payload
+	}))
+	defer srv.Close()
+	*failover, *webhookURL = "force", srv.URL
+	hostAliases = map[string]string{"db1:3306": "db-primary-eu1", "db3:3306": "db-replica-eu2"}
+	simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 1 }))
+	if db3 := simServerByURL("db3:3306"); db3.displayHost() != "db-replica-eu2" || simServerByURL("db2:3306").displayHost() != "db2" {
+		t.Errorf("console hosts %q and %q, want the alias and the host", db3.displayHost(), simServerByURL("db2:3306").displayHost())
+	}
+	current.master = current.findMaster(false)
+	if _, err := current.Failover(context.Background()); err != nil {
+		t.Fatalf("Failover() = %s", err)
+	}
+	alertFlush()
+	var done map[string]interface{}
+	for len(posts) > 0 {
+		if p := <-posts; p["event"] == ALERT_FAILOVER_DONE {
+			done = p
+		}
+	}
+	if done == nil {
+		t.Fatal("no failover-complete alert posted")
+	}
+	want := "*failover-complete* [critical]: Failover complete, db-replica-eu2 (db3:3306) has been promoted to replace db-primary-eu1 (db1:3306)"
+	if done["text"] != want || done["name"] != "db-replica-eu2" || done["server"] != "db3:3306" {
+		t.Errorf("alert text %q, name %q, server %q, want %q for db-replica-eu2 on db3:3306", done["text"], done["name"], done["server"], want)
+	}
+}
@@ -0,0 +1,222 @@
+// api.go
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"runtime"
+	"strings"
+)
+
+/* Whitelisted diagnostic queries, served through the manager's own connections so that clients need no database credentials */
+var diagQueries = map[string]string{
+	"slave-status":  "SHOW SLAVE STATUS",
+	"master-status": "SHOW MASTER STATUS",
+	"binary-logs":   "SHOW BINARY LOGS",
+	"slave-hosts":   "SHOW SLAVE HOSTS",
+	"status": "SHOW GLOBAL STATUS WHERE Variable_name IN ('Uptime', 'Threads_connected', 'Threads_running', 'Slave_running', " +
+		"'Slave_open_temp_tables', 'Slave_retried_transactions', 'Slaves_connected', 'Binlog_commits', 'Binlog_bytes_written', " +
+		"'Rpl_semi_sync_master_status', 'Rpl_semi_sync_slave_status', 'Rpl_semi_sync_master_clients')",
+	"variables": "SHOW GLOBAL VARIABLES WHERE Variable_name IN ('server_id', 'read_only', 'super_read_only', 'log_bin', 'log_slave_updates', " +
+		"'binlog_format', 'sync_binlog', 'gtid_domain_id', 'gtid_strict_mode', 'gtid_current_pos', 'gtid_slave_pos', 'gtid_binlog_pos', " +
+		"'slave_parallel_threads', 'slave_net_timeout', 'version')",
+}
+
+type apiServer struct {
+	URL           string
+	Host          string
+	Port          string
+	State         string
+	Delay         int64
+	Maintenance   bool `json:",omitempty"`
+	ReadOnly      string
+	SuperReadOnly string            `json:",omitempty"`
+	ReadOnlyIssue string            `json:",omitempty"`
+	IOError       string            `json:",omitempty"`
+	SQLError      string            `json:",omitempty"`
+	Channels      []Channel         `json:",omitempty"`
+	Labels        map[string]string `json:",omitempty"`
+}
+
+/* Returns the handler of the HTTP API routes, behind the network, authentication and CSRF checks */
+func apiHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/clusters", apiClusters)
+	mux.HandleFunc("/api/vote", clusterHandler((*Cluster).apiVote))
+	mux.HandleFunc("/api/version", apiVersion)
+	mux.HandleFunc("/api/leader", apiLeader)
+	mux.HandleFunc("/api/log", apiLog)
+	mux.HandleFunc("/api/external", clusterHandler((*Cluster).apiExternal))
+	mux.HandleFunc("/api/failovers", clusterHandler((*Cluster).apiFailovers))
+	mux.HandleFunc("/api/servers", clusterHandler((*Cluster).apiServers))
+	mux.HandleFunc("/api/servers/", clusterHandler((*Cluster).apiServerQuery))
+	mux.HandleFunc("/api/topology", clusterHandler((*Cluster).apiTopology))
+	mux.HandleFunc("/api/status", clusterHandler((*Cluster).apiStatus))
+	if *httpUI {
+		mux.HandleFunc("/", webDashboard)
+	}
+	if *httpUI || *httpControl {
+		mux.HandleFunc("/api/switchover", clusterHandler(apiAction("switchover")))
+		mux.HandleFunc("/api/failover", clusterHandler(apiAction("failover")))
+		// The running operation holds the cluster, the abort does not wait for it
+		mux.HandleFunc("/api/abort", apiAbort)
+		mux.HandleFunc("/api/panic", apiPanic)
+	}
+	return allowHandler(authHandler(csrfHandler(mux)))
+}
+
+/* Starts the HTTP API. It is meant to run in its own goroutine. */
+func apiServe() {
+	cfg, err := listenerTLS()
+	if err != nil {
+		log.Printf("ERROR: HTTP API not started, invalid TLS settings: %s", err)
+		return
+	}
+	srv := &http.Server{Addr: *httpAddr, Handler: apiHandler(), TLSConfig: cfg}
+	log.Printf("INFO : Starting HTTP API on %s", *httpAddr)
+	if cfg != nil {
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err != nil {
+		log.Printf("ERROR: HTTP API stopped: %s", err)
+	}
+}
+
+func (c *Cluster) apiServers(w http.ResponseWriter, r *http.Request) {
+	var res []apiServer
+	for _, s := range c.knownServers() {
+		res = append(res, apiServer{URL: s.URL, Host: s.Host, Port: s.Port, State: s.State, Delay: s.Delay.Int64, Maintenance: s.inMaintenance(), ReadOnly: s.ReadOnly, SuperReadOnly: s.SuperReadOnly,
+			ReadOnlyIssue: s.readonlyIssue(), IOError: s.IOError, SQLError: s.SQLError, Channels: s.sources(), Labels: hostLabels[hostKey(s.URL)]})
+	}
+	apiWrite(w, res)
+}
+
+func (c *Cluster) apiFailovers(w http.ResponseWriter, r *http.Request) {
+	apiWrite(w, c.stateData.History)
+}
+
+func apiVersion(w http.ResponseWriter, r *http.Request) {
+	apiWrite(w, map[string]string{
+		"version":   repmgrVersion,
+		"commit":    repmgrCommit,
+		"buildDate": repmgrBuildDate,
+		"goVersion": runtime.Version(),
+		"platform":  runtime.GOOS + "/" + runtime.GOARCH,
+	})
+}
+
+func apiClusters(w http.ResponseWriter, r *http.Request) {
+	var res []ClusterHealth
+	for _, c := range clusters {
+		withCluster(c, func() {
+			res = append(res, c.clusterHealth())
+		})
+	}
+	apiWrite(w, res)
+}
+
+/* Returns the cluster selected by the cluster parameter, which is optional when a single cluster is monitored. Writes the error if there is none. */
+func requestCluster(w http.ResponseWriter, r *http.Request) *Cluster {
+	c := clusters[0]
+	if name := r.URL.Query().Get("cluster"); name != "" || len(clusters) > 1 {
+		c = findCluster(clusters, name)
+	}
+	if c == nil {
+		http.Error(w, "Unknown cluster "+r.URL.Query().Get("cluster"), http.StatusNotFound)
+	}
+	return c
+}
+
+/* Serves the request on the cluster selected by the cluster parameter */
+func clusterHandler(h func(c *Cluster, w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c := requestCluster(w, r)
+		if c == nil {
+			return
+		}
+		withCluster(c, func() {
+			h(c, w, r)
+		})
+	}
+}
+
+func (c *Cluster) apiExternal(w http.ResponseWriter, r *http.Request) {
+	apiWrite(w, c.externalNodes)
+}
+
+/* Serves /api/servers/<host:port>/<query> */
+func (c *Cluster) apiServerQuery(w http.ResponseWriter, r *http.Request) {
+	items := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/servers/"), "/")
+	if len(items) != 2 {
+		http.Error(w, "Expected /api/servers/<host:port>/<query>", http.StatusNotFound)
+		return
+	}
+	if items[1] == "history" {
+		apiWrite(w, serverHistory(items[0]))
+		return
+	}
+	s := c.findServer(items[0])
+	if s == nil {
+		http.Error(w, "Unknown server "+items[0], http.StatusNotFound)
+		return
+	}
+	if items[1] == "maintenance" {
+		c.apiMaintenance(w, r, s)
+		return
+	}
+	q, ok := diagQueries[items[1]]
+	if ok == false {
+		http.Error(w, "Unknown query "+items[1], http.StatusNotFound)
+		return
+	}
+	if (s.Conn == nil && s.db == nil) || s.State == STATE_FAILED {
+		http.Error(w, "Server "+s.URL+" is not reachable", http.StatusServiceUnavailable)
+		return
+	}
+	rows, err := s.query(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	apiWrite(w, rows)
+}
+
+func apiWrite(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+/* Returns the current master and slave objects followed by the other servers of the hosts list. The master and slave objects are reinstanced on topology changes, so they take precedence over the ones built at startup. */
+func (c *Cluster) knownServers() []*ServerMonitor {
+	var l []*ServerMonitor
+	seen := make(map[string]bool)
+	add := func(s *ServerMonitor) {
+		if s != nil && seen[s.URL] == false {
+			seen[s.URL] = true
+			l = append(l, s)
+		}
+	}
+	add(c.master)
+	for _, s := range c.slaves {
+		add(s)
+	}
+	for _, s := range c.servers {
+		add(s)
+	}
+	return l
+}
+
+func (c *Cluster) findServer(url string) *ServerMonitor {
+	for _, s := range c.knownServers() {
+		if s.URL == url {
+			return s
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+// api_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIServerQuery(t *testing.T) {
+	sims := simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 3 }))
+	sims["db1:3306"].rows = map[string][]map[string]string{
+		"SHOW MASTER STATUS": {{"File": "mysql-bin.000042", "Position": "1024"}},
+	}
+	tests := []struct {
+		name   string
+		path   string
+		status int
+	}{
+		{"master status", "/api/servers/db1:3306/master-status", http.StatusOK},
+		{"query outside the whitelist", "/api/servers/db1:3306/processlist", http.StatusNotFound},
+		{"unknown server", "/api/servers/db9:3306/master-status", http.StatusNotFound},
+		{"failed server", "/api/servers/db3:3306/slave-status", http.StatusServiceUnavailable},
+		{"missing query", "/api/servers/db1:3306", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		current.apiServerQuery(w, httptest.NewRequest("GET", tt.path, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s: GET %s = %d, want %d", tt.name, tt.path, w.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		var rows []map[string]string
+		if err := json.NewDecoder(w.Body).Decode(&rows); err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		if len(rows) != 1 || rows[0]["File"] != "mysql-bin.000042" || rows[0]["Position"] != "1024" {
+			t.Errorf("%s: rows %v, want the binary log position", tt.name, rows)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+// apiauth.go
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* Roles of the HTTP API clients: viewers read, operators also change the cluster */
+const (
+	ROLE_VIEWER   = 1
+	ROLE_OPERATOR = 2
+)
+
+/* Credentials of the HTTP API clients, keyed by token or user:password pair */
+var (
+	apiTokens = map[string]apiClient{}
+	apiUsers  = map[string]apiClient{}
+)
+
+/* Identity of an authenticated HTTP API client */
+type apiClient struct {
+	Name string
+	Role int
+}
+
+type apiClientKey struct{}
+
+/* Loads the HTTP API credentials from the -http-auth-file, one per line in the <viewer|operator> <token|basic> <token|user:password> format. Tokens and passwords may reference secrets like the user option. */
+func loadAPIAuth(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		items := strings.Fields(line)
+		if len(items) != 3 {
+			return errors.New(fmt.Sprintf("line %d: expected <viewer|operator> <token|basic> <credential>", n))
+		}
+		role := roleRank(items[0])
+		if role == 0 {
+			return errors.New(fmt.Sprintf("line %d: unknown role %s", n, items[0]))
+		}
+		switch items[1] {
+		case "token":
+			token, err := resolveSecret(items[2])
+			if err != nil {
+				return errors.New(fmt.Sprintf("line %d: %s", n, err))
+			}
+			apiTokens[token] = apiClient{Name: fmt.Sprintf("token of line %d", n), Role: role}
+		case "basic":
+			pair, err := resolveCredentials(items[2])
+			if err != nil {
+				return errors.New(fmt.Sprintf("line %d: %s", n, err))
+			}
+			u, _ := splitCredentials(pair)
+			apiUsers[pair] = apiClient{Name: u, Role: role}
+		default:
+			return errors.New(fmt.Sprintf("line %d: unknown credential kind %s", n, items[1]))
+		}
+	}
+	return scanner.Err()
+}
+
+func roleRank(role string) int {
+	switch role {
+	case "viewer":
+		return ROLE_VIEWER
+	case "operator":
+		return ROLE_OPERATOR
+	}
+	return 0
+}
+
+/* Returns true if the HTTP API requires authentication */
+func apiAuthEnabled() bool {
+	return len(apiTokens) > 0 || len(apiUsers) > 0 || *oidcIssuer != ""
+}
+
+/* Returns the client presenting the credentials of the request, false if they are missing or invalid */
+func authenticate(r *http.Request) (apiClient, bool) {
+	if u, p, ok := r.BasicAuth(); ok {
+		for pair, c := range apiUsers {
+			if subtle.ConstantTimeCompare([]byte(pair), []byte(u+":"+p)) == 1 {
+				return c, true
+			}
+		}
+		return apiClient{}, false
+	}
+	auth := r.Header.Get("Authorization")
+	if strings.HasPrefix(auth, "Bearer ") == false {
+		return apiClient{}, false
+	}
+	token := strings.TrimSpace(auth[7:])
+	for t, c := range apiTokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return c, true
+		}
+	}
+	if *oidcIssuer != "" && strings.Count(token, ".") == 2 {
+		c, err := oidcClient(token)
+		if err == nil {
+			return c, true
+		}
+	}
+	return apiClient{}, false
+}
+
+/* Rejects the requests without valid credentials when authentication is enabled. Reading requires the viewer role and any other method the operator role. */
+func authHandler(h http.Handler) http.Handler {
+	if apiAuthEnabled() == false {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, ok := authenticate(r)
+		if ok == false {
+			if len(apiUsers) > 0 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="replication-manager"`)
+			} else {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+			}
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != "GET" && r.Method != "HEAD" && c.Role < ROLE_OPERATOR {
+			http.Error(w, "The operator role is required", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiClientKey{}, c)))
+	})
+}
+
+/* Returns who sent a request, for the audit file and the failover history: the authenticated client and its address */
+func requestUser(r *http.Request) string {
+	if c, ok := r.Context().Value(apiClientKey{}).(apiClient); ok {
+		return c.Name + " from " + r.RemoteAddr
+	}
+	return r.RemoteAddr
+}
+
+/* Public keys of the OIDC provider, keyed by key id, fetched again at most once a minute when a token is signed by an unknown key */
+var oidcKeys struct {
+	sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+}
+
+/* Fetches the signing keys of the OIDC provider from the JWKS of its discovery document */
+func fetchOIDCKeys() error {
+	client := &http.Client{Timeout: 5 * time.Second}
+	var doc struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	resp, err := client.Get(strings.TrimSuffix(*oidcIssuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return err
+	}
+	err = json.NewDecoder(resp.Body).Decode(&doc)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	var jwks struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	resp, err = client.Get(doc.JWKSURI)
+	if err != nil {
+		return err
+	}
+	err = json.NewDecoder(resp.Body).Decode(&jwks)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+		e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	oidcKeys.keys = keys
+	return nil
+}
+
+/* Returns the public key of the OIDC provider with the given id */
+func oidcKey(kid string) (*rsa.PublicKey, error) {
+	oidcKeys.Lock()
+	defer oidcKeys.Unlock()
+	if k, ok := oidcKeys.keys[kid]; ok {
+		return k, nil
+	}
+	if time.Since(oidcKeys.fetched) < time.Minute {
+		return nil, errors.New("unknown key " + kid)
+	}
+	oidcKeys.fetched = time.Now()
+	err := fetchOIDCKeys()
+	if err != nil {
+		return nil, err
+	}
+	if k, ok := oidcKeys.keys[kid]; ok {
+		return k, nil
+	}
+	return nil, errors.New("unknown key " + kid)
+}
+
+/* Verifies an RS256 ID or access token of the OIDC provider: its signature, issuer, audience and expiry. Clients in the -http-oidc-operator-group of the groups claim are operators, the others viewers. */
+func oidcClient(token string) (apiClient, error) {
+	parts := strings.Split(token, ".")
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	var claims struct {
+		Iss    string      `json:"iss"`
+		Sub    string      `json:"sub"`
+		Email  string      `json:"email"`
+		Aud    interface{} `json:"aud"`
+		Exp    int64       `json:"exp"`
+		Groups []string    `json:"groups"`
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err == nil {
+		err = json.Unmarshal(b, &header)
+	}
+	if err != nil || header.Alg != "RS256" {
+		return apiClient{}, errors.New("unsupported token")
+	}
+	key, err := oidcKey(header.Kid)
+	if err != nil {
+		return apiClient{}, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return apiClient{}, err
+	}
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err = rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig); err != nil {
+		return apiClient{}, err
+	}
+	b, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err == nil {
+		err = json.Unmarshal(b, &claims)
+	}
+	if err != nil {
+		return apiClient{}, err
+	}
+	if strings.TrimSuffix(claims.Iss, "/") != strings.TrimSuffix(*oidcIssuer, "/") || time.Now().Unix() >= claims.Exp {
+		return apiClient{}, errors.New("token expired or from another issuer")
+	}
+	if *oidcAudience != "" && audienceHas(claims.Aud, *oidcAudience) == false {
+		return apiClient{}, errors.New("token for another audience")
+	}
+	c := apiClient{Name: claims.Sub, Role: ROLE_VIEWER}
+	if claims.Email != "" {
+		c.Name = claims.Email
+	}
+	if *oidcOperator != "" && contains(claims.Groups, *oidcOperator) {
+		c.Role = ROLE_OPERATOR
+	}
+	return c, nil
+}
+
+/* Returns true if the aud claim, a string or a list, holds the audience */
+func audienceHas(aud interface{}, audience string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == audience
+	case []interface{}:
+		for _, v := range a {
+			if v == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,220 @@
+// apiauth_test.go
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+/* Writes an HTTP API credentials file and loads it */
+func simAPIAuth(t *testing.T, content string) error {
+	apiTokens, apiUsers = map[string]apiClient{}, map[string]apiClient{}
+	path := filepath.Join(t.TempDir(), "auth")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return loadAPIAuth(path)
+}
+
+func TestLoadAPIAuth(t *testing.T) {
+	defer func() { apiTokens, apiUsers = map[string]apiClient{}, map[string]apiClient{} }()
+	defer os.Unsetenv("SIM_API_TOKEN")
+	os.Setenv("SIM_API_TOKEN", "t0ken")
+	err := simAPIAuth(t, "# clients\n\nviewer token env:SIM_API_TOKEN\noperator basic admin:secret\n")
+	if err != nil {
+		t.Fatalf("loadAPIAuth() = %s", err)
+	}
+	if c, ok := apiTokens["t0ken"]; ok == false || c.Role != ROLE_VIEWER || c.Name != "token of line 3" {
+		t.Errorf("tokens %v, want the viewer token of line 3", apiTokens)
+	}
+	if c, ok := apiUsers["admin:secret"]; ok == false || c.Role != ROLE_OPERATOR || c.Name != "admin" {
+		t.Errorf("users %v, want the operator admin", apiUsers)
+	}
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{"missing field", "viewer token\n", "line 1: expected <viewer|operator> <token|basic> <credential>"},
+		{"unknown role", "admin token abc\n", "line 1: unknown role admin"},
+		{"unknown kind", "viewer cert abc\n", "line 1: unknown credential kind cert"},
+		{"unset secret", "viewer token abc\noperator token env:SIM_API_UNSET\n", "line 2: could not read secret from env"},
+		{"unset password", "operator basic admin:env:SIM_API_UNSET\n", "line 1: could not read password of admin"},
+	}
+	for _, tt := range tests {
+		err := simAPIAuth(t, tt.content)
+		if err == nil || strings.HasPrefix(err.Error(), tt.err) == false {
+			t.Errorf("%s: loadAPIAuth() = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+	if err := loadAPIAuth(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("loadAPIAuth() of a missing file succeeded")
+	}
+}
+
+func TestAuthHandler(t *testing.T) {
+	defer func() { apiTokens, apiUsers = map[string]apiClient{}, map[string]apiClient{} }()
+	var user string
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { user = requestUser(r) })
+	if err := simAPIAuth(t, ""); err != nil || apiAuthEnabled() {
+		t.Fatalf("loadAPIAuth() = %v, enabled %v, want no authentication", err, apiAuthEnabled())
+	}
+	r := httptest.NewRequest("POST", "/api/switchover", nil)
+	w := httptest.NewRecorder()
+	authHandler(h).ServeHTTP(w, r)
+	if w.Code != http.StatusOK || user != r.RemoteAddr {
+		t.Errorf("request without authentication = %d by %q, want 200 by %s", w.Code, user, r.RemoteAddr)
+	}
+	if err := simAPIAuth(t, "viewer token view\noperator token oper\nviewer basic ro:pass\noperator basic rw:pass\n"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		method string
+		token  string
+		user   string // user:password of the basic authentication
+		code   int
+		by     string
+	}{
+		{"anonymous", "GET", "", "", http.StatusUnauthorized, ""},
+		{"wrong token", "GET", "nope", "", http.StatusUnauthorized, ""},
+		{"wrong password", "GET", "", "ro:nope", http.StatusUnauthorized, ""},
+		{"viewer token reads", "GET", "view", "", http.StatusOK, "token of line 1"},
+		{"viewer token changes", "POST", "view", "", http.StatusForbidden, ""},
+		{"operator token changes", "POST", "oper", "", http.StatusOK, "token of line 2"},
+		{"viewer user reads", "HEAD", "", "ro:pass", http.StatusOK, "ro"},
+		{"viewer user changes", "PUT", "", "ro:pass", http.StatusForbidden, ""},
+		{"operator user changes", "DELETE", "", "rw:pass", http.StatusOK, "rw"},
+	}
+	for _, tt := range tests {
+		user = ""
+		r := httptest.NewRequest(tt.method, "/api/switchover", nil)
+		if tt.token != "" {
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		if tt.user != "" {
+			u, p := splitCredentials(tt.user)
+			r.SetBasicAuth(u, p)
+		}
+		w := httptest.NewRecorder()
+		authHandler(h).ServeHTTP(w, r)
+		if w.Code != tt.code {
+			t.Errorf("%s: %s = %d, want %d", tt.name, tt.method, w.Code, tt.code)
+		}
+		if tt.by != "" && user != tt.by+" from "+r.RemoteAddr {
+			t.Errorf("%s: request by %q, want %s", tt.name, user, tt.by)
+		}
+		if tt.code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != `Basic realm="replication-manager"` {
+			t.Errorf("%s: challenge %q, want Basic", tt.name, w.Header().Get("WWW-Authenticate"))
+		}
+	}
+}
+
+/* Starts an OIDC provider publishing the key with id k1, returns it and the key signing its tokens */
+func simOIDC(t *testing.T) (*httptest.Server, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]string{"jwks_uri": srv.URL + "/keys"})
+		case "/keys":
+			json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+				"kid": "k1",
+				"kty": "RSA",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return srv, key
+}
+
+/* Returns an RS256 token with the claims, signed by the key with the given id */
+func simToken(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	h, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid})
+	c, _ := json.Marshal(claims)
+	data := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	sum := sha256.Sum256([]byte(data))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestOIDCClient(t *testing.T) {
+	defer func(i, a, o string) { *oidcIssuer, *oidcAudience, *oidcOperator = i, a, o }(*oidcIssuer, *oidcAudience, *oidcOperator)
+	defer func() { oidcKeys.keys, oidcKeys.fetched = nil, time.Time{} }()
+	srv, key := simOIDC(t)
+	defer srv.Close()
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	*oidcIssuer, *oidcAudience, *oidcOperator = srv.URL+"/", "repmgr", "dba"
+	oidcKeys.keys, oidcKeys.fetched = nil, time.Time{}
+	exp := time.Now().Add(time.Hour).Unix()
+	claims := func(change func(c map[string]interface{})) map[string]interface{} {
+		c := map[string]interface{}{"iss": srv.URL, "sub": "u1", "aud": "repmgr", "exp": exp}
+		if change != nil {
+			change(c)
+		}
+		return c
+	}
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+		want  apiClient
+	}{
+		{"viewer", simToken(t, key, "k1", claims(nil)), true, apiClient{Name: "u1", Role: ROLE_VIEWER}},
+		{"operator", simToken(t, key, "k1", claims(func(c map[string]interface{}) {
+			c["email"], c["groups"], c["aud"] = "u1@example.com", []string{"dev", "dba"}, []string{"web", "repmgr"}
+		})), true, apiClient{Name: "u1@example.com", Role: ROLE_OPERATOR}},
+		{"expired", simToken(t, key, "k1", claims(func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Minute).Unix() })), false, apiClient{}},
+		{"other issuer", simToken(t, key, "k1", claims(func(c map[string]interface{}) { c["iss"] = "https://idp.example.com" })), false, apiClient{}},
+		{"other audience", simToken(t, key, "k1", claims(func(c map[string]interface{}) { c["aud"] = []string{"web"} })), false, apiClient{}},
+		{"forged", simToken(t, other, "k1", claims(nil)), false, apiClient{}},
+		{"unknown key", simToken(t, key, "k2", claims(nil)), false, apiClient{}},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/status", nil)
+		r.Header.Set("Authorization", "Bearer "+tt.token)
+		c, ok := authenticate(r)
+		if ok != tt.ok || c != tt.want {
+			t.Errorf("%s: authenticate() = %v, %v, want %v, %v", tt.name, c, ok, tt.want, tt.ok)
+		}
+	}
+	// The keys are fetched once, the unknown key does not fetch them again within a minute
+	if len(oidcKeys.keys) != 1 || time.Since(oidcKeys.fetched) > time.Minute {
+		t.Errorf("OIDC keys %v fetched at %s, want the key k1", oidcKeys.keys, oidcKeys.fetched)
+	}
+	// Without the operator group of the tokens, the clients are viewers
+	*oidcOperator = ""
+	r := httptest.NewRequest("POST", "/api/switchover", nil)
+	r.Header.Set("Authorization", "Bearer "+tests[1].token)
+	w := httptest.NewRecorder()
+	authHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("POST by an OIDC viewer = %d, want 403", w.Code)
+	}
+}
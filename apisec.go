@@ -0,0 +1,123 @@
+// apisec.go
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+/* Networks allowed to reach the control plane listeners, all if empty */
+var allowNets []*net.IPNet
+
+/* Parses a comma separated list of networks in CIDR format. Bare addresses are accepted as single host networks. */
+func parseNets(s string) ([]*net.IPNet, error) {
+	var l []*net.IPNet
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if strings.Contains(item, "/") == false {
+			if strings.Contains(item, ":") {
+				item += "/128"
+			} else {
+				item += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, err
+		}
+		l = append(l, n)
+	}
+	return l, nil
+}
+
+/* Returns true if the address, with or without port, belongs to one of the networks */
+func inNets(nets []*net.IPNet, addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func allowed(remoteAddr string) bool {
+	return len(allowNets) == 0 || inNets(allowNets, remoteAddr)
+}
+
+/* Rejects the requests coming from outside the allowed networks */
+func allowHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if allowed(r.RemoteAddr) == false {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+/* Header the dashboard and the client commands send with the requests changing the cluster. A cross-site form cannot set it, and a cross-site script cannot without a CORS preflight, which the API never grants. */
+const csrfHeader = "X-Replication-Manager"
+
+/* Rejects the requests other than GET and HEAD lacking the CSRF header or coming from a page of another origin, so that a page opened in the browser of an authenticated operator cannot trigger a switchover */
+func csrfHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" || r.Method == "HEAD" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if r.Header.Get(csrfHeader) == "" {
+			http.Error(w, "Forbidden, the "+csrfHeader+" header is required", http.StatusForbidden)
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" {
+			u, err := url.Parse(origin)
+			if err != nil || u.Host != r.Host {
+				http.Error(w, "Forbidden, cross-origin request", http.StatusForbidden)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+/* Returns the server TLS configuration of the control plane listeners, or nil to serve plain HTTP. Client certificates signed by the client CA are required when one is set. */
+func listenerTLS() (*tls.Config, error) {
+	if *httpCert == "" && *httpKey == "" && *httpClientCA == "" {
+		return nil, nil
+	}
+	if *httpCert == "" || *httpKey == "" {
+		return nil, errors.New("a server certificate and key are required to serve TLS")
+	}
+	cert, err := tls.LoadX509KeyPair(*httpCert, *httpKey)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if *httpClientCA != "" {
+		pem, err := ioutil.ReadFile(*httpClientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if pool.AppendCertsFromPEM(pem) == false {
+			return nil, errors.New("no certificate found in " + *httpClientCA)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
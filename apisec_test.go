@@ -0,0 +1,85 @@
+// apisec_test.go
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAllowHandler(t *testing.T) {
+	defer func() { allowNets = nil }()
+	var err error
+	allowNets, err = parseNets("10.1.0.0/16, 192.168.1.7, fd00::/8")
+	if err != nil {
+		t.Fatalf("parseNets() = %s", err)
+	}
+	if _, err := parseNets("10.1.0.0/33"); err == nil {
+		t.Error("parseNets() accepted an invalid network")
+	}
+	tests := []struct {
+		remote string
+		status int
+	}{
+		{"10.1.20.3:50412", http.StatusOK},
+		{"10.2.0.1:50412", http.StatusForbidden},
+		{"192.168.1.7:50412", http.StatusOK},
+		{"192.168.1.8:50412", http.StatusForbidden},
+		{"[fd00::12]:50412", http.StatusOK},
+		{"[fe80::1]:50412", http.StatusForbidden},
+		{"@", http.StatusForbidden},
+	}
+	h := allowHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/status", nil)
+		r.RemoteAddr = tt.remote
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("request from %s = %d, want %d", tt.remote, w.Code, tt.status)
+		}
+	}
+}
+
+func TestListenerClientCertificate(t *testing.T) {
+	defer func(c, k, ca string) { *httpCert, *httpKey, *httpClientCA = c, k, ca }(*httpCert, *httpKey, *httpClientCA)
+	cert, key := simCert(t, t.TempDir())
+	*httpCert, *httpKey, *httpClientCA = cert, key, cert
+	cfg, err := listenerTLS()
+	if err != nil {
+		t.Fatalf("listenerTLS() = %s", err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = cfg
+	srv.StartTLS()
+	defer srv.Close()
+	pair, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		certs []tls.Certificate
+		ok    bool
+	}{
+		{"client with certificate", []tls.Certificate{pair}, true},
+		{"client without certificate", nil, false},
+	}
+	for _, tt := range tests {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true, Certificates: tt.certs}}}
+		resp, err := client.Get(srv.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: GET = %v, want success %v", tt.name, err, tt.ok)
+		}
+	}
+
+	// A client CA needs a server certificate
+	*httpCert, *httpKey = "", ""
+	if _, err := listenerTLS(); err == nil {
+		t.Error("listenerTLS() accepted a client CA without server certificate")
+	}
+}
@@ -0,0 +1,131 @@
+// arbitrator.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+/* Opinion of a replication-manager instance on the master, served to its peers */
+type masterVote struct {
+	Master string
+	Failed bool
+}
+
+/* Returns true if automatic failover may proceed. The failover limits must not be reached, a majority of slaves must have lost the master with -failover-check-slaves, and the failure must be confirmed by a majority of the replication-manager instances, this one included, granted by the external arbitrator if one is set, and not vetoed by the failover check script. Instances that cannot be reached do not vote, so a monitor partitioned from the others cannot fail over alone. */
+func (c *Cluster) arbitrate() bool {
+	ok, reason := c.failoverLimits()
+	if ok && *checkSlaves {
+		if seen, why := c.slavesSeeMaster(); seen {
+			ok, reason = false, why
+		}
+	}
+	if ok && *arbPeers == "" && *arbURL == "" && *checkScript == "" {
+		c.arbitrationDenied = false
+		return true
+	}
+	if ok {
+		ok, reason = c.quorum()
+	}
+	if ok && *arbURL != "" {
+		err := c.arbitratorGrant()
+		if err != nil {
+			ok, reason = false, fmt.Sprintf("arbitrator denied failover: %s", err)
+		}
+	}
+	if ok && *checkScript != "" {
+		out, err := Hook{Event: "failover-check", Path: *checkScript, Timeout: time.Duration(*checkTimeout) * time.Second}.run(hookContext{Cluster: c.clusterName(), OldMaster: c.master})
+		if err != nil {
+			ok, reason = false, fmt.Sprintf("failover check script vetoed failover: %s %s", err, strings.TrimSpace(string(out)))
+		}
+	}
+	if ok == false {
+		if c.arbitrationDenied == false {
+			alertLog("WARN : Master %s failed but failover is not allowed: %s", c.master.URL, reason)
+			c.arbitrationDenied = true
+		}
+		return false
+	}
+	c.arbitrationDenied = false
+	return true
+}
+
+/* Counts the instances seeing the master as failed */
+func (c *Cluster) quorum() (bool, string) {
+	if *arbPeers == "" {
+		return true, ""
+	}
+	peers := strings.Split(*arbPeers, ",")
+	votes := 1
+	client := &http.Client{Timeout: 3 * time.Second}
+	for _, p := range peers {
+		v, err := c.peerVote(client, strings.TrimSpace(p))
+		if err != nil {
+			alertLog("WARN : Could not get vote of peer %s: %s", p, err)
+			continue
+		}
+		if v.Master == c.master.URL && v.Failed {
+			votes++
+		}
+	}
+	need := (len(peers)+1)/2 + 1
+	if votes < need {
+		return false, fmt.Sprintf("only %d of %d instances see it failed, %d required", votes, len(peers)+1, need)
+	}
+	return true, ""
+}
+
+func (c *Cluster) peerVote(client *http.Client, peer string) (masterVote, error) {
+	var v masterVote
+	u := strings.TrimSuffix(peer, "/") + "/api/vote"
+	if len(clusters) > 1 {
+		u += "?cluster=" + url.QueryEscape(c.clusterName())
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return v, err
+	}
+	if *arbToken != "" {
+		token, err := resolveSecret(*arbToken)
+		if err != nil {
+			return v, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return v, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return v, errors.New(resp.Status)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&v)
+	return v, err
+}
+
+/* Asks the external arbitrator for permission to fail over. The arbitrator grants it with a 200 response and denies it with any other status, typically because another instance already holds the grant for this cluster. */
+func (c *Cluster) arbitratorGrant() error {
+	hostname, _ := os.Hostname()
+	return postJSON(*arbURL, map[string]interface{}{
+		"cluster":  c.clusterName(),
+		"master":   c.master.URL,
+		"instance": hostname,
+		"time":     time.Now().Format(time.RFC3339),
+	})
+}
+
+func (c *Cluster) apiVote(w http.ResponseWriter, r *http.Request) {
+	v := masterVote{}
+	if c.master != nil {
+		v.Master = c.master.URL
+		v.Failed = c.master.State == STATE_FAILED
+	}
+	apiWrite(w, v)
+}
@@ -0,0 +1,121 @@
+// arbitrator_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/* Instance of replication-manager answering votes on the master, to clients presenting the token */
+func simPeer(v masterVote) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/vote" || r.Header.Get("Authorization") != "Bearer s3cret" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(v)
+	}))
+}
+
+func TestArbitrate(t *testing.T) {
+	defer func(p, u, tk string) { *arbPeers, *arbURL, *arbToken = p, u, tk }(*arbPeers, *arbURL, *arbToken)
+	failed := simPeer(masterVote{Master: "db1:3306", Failed: true})
+	defer failed.Close()
+	running := simPeer(masterVote{Master: "db1:3306"})
+	defer running.Close()
+	other := simPeer(masterVote{Master: "db2:3306", Failed: true})
+	defer other.Close()
+	down := simPeer(masterVote{})
+	down.Close()
+	grant := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer grant.Close()
+	deny := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "granted to another instance", http.StatusConflict)
+	}))
+	defer deny.Close()
+	tests := []struct {
+		name   string
+		peers  []string
+		arbURL string
+		token  string
+		ok     bool
+	}{
+		{"no arbitration", nil, "", "s3cret", true},
+		{"all instances see the failure", []string{failed.URL, failed.URL}, "", "s3cret", true},
+		{"majority sees the failure", []string{failed.URL, running.URL}, "", "s3cret", true},
+		{"minority sees the failure", []string{running.URL, running.URL}, "", "s3cret", false},
+		{"peer unreachable", []string{down.URL, running.URL}, "", "s3cret", false},
+		{"peers unreachable", []string{down.URL, down.URL}, "", "s3cret", false},
+		{"peer with another master", []string{other.URL, running.URL}, "", "s3cret", false},
+		{"wrong token", []string{failed.URL, failed.URL}, "", "guess", false},
+		{"arbitrator grants", nil, grant.URL, "s3cret", true},
+		{"arbitrator denies", []string{failed.URL}, deny.URL, "s3cret", false},
+	}
+	for _, tt := range tests {
+		simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 1 }))
+		current.master = current.findMaster(false)
+		*arbPeers, *arbURL, *arbToken = strings.Join(tt.peers, ","), tt.arbURL, tt.token
+		if got := current.arbitrate(); got != tt.ok {
+			t.Errorf("%s: arbitrate() = %v, want %v", tt.name, got, tt.ok)
+		}
+	}
+}
+
+func TestAPIVote(t *testing.T) {
+	simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 1 }))
+	current.master = current.findMaster(false)
+	w := httptest.NewRecorder()
+	current.apiVote(w, httptest.NewRequest("GET", "/api/vote", nil))
+	var v masterVote
+	if err := json.NewDecoder(w.Body).Decode(&v); err != nil || v.Master != "db1:3306" || v.Failed == false {
+		t.Errorf("vote %+v (%v), want db1:3306 failed", v, err)
+	}
+}
+
+func TestFailoverCheckScript(t *testing.T) {
+	defer func(s string, to int64) { *checkScript, *checkTimeout = s, to }(*checkScript, *checkTimeout)
+	*checkTimeout = 1
+	tests := []struct {
+		name   string
+		script string
+		ok     bool
+		reason string
+	}{
+		{"master unreachable", "exit 0", true, ""},
+		{"master reachable", "echo \"$REPMGR_OLD_MASTER_HOST reachable over the backup link\"\nexit 1", false, "failover check script vetoed failover: exit status 1 db1 reachable over the backup link"},
+		{"script hanging", "exec sleep 5", false, "failover check script vetoed failover: timeout after 1s"},
+	}
+	for _, tt := range tests {
+		file := filepath.Join(t.TempDir(), "check.sh")
+		if err := ioutil.WriteFile(file, []byte("#!/bin/sh\n"+tt.script+"\n"), 0700); err != nil {
+			t.Fatal(err)
+		}
+		*checkScript = file
+		simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 1 }))
+		var out bytes.Buffer
+		log.SetOutput(&out)
+		current.master = current.findMaster(false)
+		current.arbitrationDenied = false
+		if got := current.arbitrate(); got != tt.ok {
+			t.Errorf("%s: arbitrate() = %v, want %v", tt.name, got, tt.ok)
+		}
+		if strings.Contains(out.String(), tt.reason) == false {
+			t.Errorf("%s: output %q does not contain %q", tt.name, out.String(), tt.reason)
+		}
+		// The veto is only reported once while the master stays failed
+		if tt.ok == false {
+			out.Reset()
+			current.arbitrate()
+			if out.Len() != 0 {
+				t.Errorf("%s: veto reported again: %q", tt.name, out.String())
+			}
+		}
+	}
+}
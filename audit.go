@@ -0,0 +1,95 @@
+// audit.go
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	osuser "os/user"
+	"strings"
+	"time"
+)
+
+/* Appends an entry to the audit file, stamped with the time and the system user running the manager */
+func audit(format string, args ...interface{}) {
+	if *auditFile == "" {
+		return
+	}
+	f, err := os.OpenFile(*auditFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		alertLog("WARN : Could not open audit file: %s", err)
+		return
+	}
+	defer f.Close()
+	who := "unknown"
+	if u, err := osuser.Current(); err == nil {
+		who = u.Username
+	}
+	fmt.Fprintf(f, "%s [%s] %s\n", time.Now().Format("2006-01-02 15:04:05"), who, fmt.Sprintf(format, args...))
+}
+
+/* Failover or switchover in progress, nil otherwise */
+var opRecord *FailoverEvent
+
+/* Starts recording a failover or switchover in the history and the audit file. Each logged step is added to the record until the returned function ends it. Dry runs are not recorded. */
+func (c *Cluster) recordOperation(kind string) func() {
+	if *dryRun {
+		return func() {}
+	}
+	who := "unknown"
+	if u, err := osuser.Current(); err == nil {
+		who = u.Username
+	}
+	opRecord = &FailoverEvent{Time: time.Now(), Type: kind, OldMaster: c.master.URL, Trigger: c.opTrigger, User: who}
+	if c.master.State != STATE_FAILED {
+		opRecord.OldPos = c.master.position()
+	}
+	audit("%s of master %s started by %s", strings.Title(kind), c.master.URL, c.opTrigger)
+	log.SetOutput(io.MultiWriter(logWriter, stepWriter{}))
+	return func() {
+		log.SetOutput(logWriter)
+		e := opRecord
+		opRecord = nil
+		if e.Result == "" {
+			e.Result = "aborted"
+			audit("%s of master %s aborted", strings.Title(kind), e.OldMaster)
+		} else {
+			audit("%s of master %s %s, new master %s at %s", strings.Title(kind), e.OldMaster, e.Result, e.NewMaster, e.NewPos)
+		}
+		c.stateData.History = append(c.stateData.History, *e)
+		c.saveState()
+	}
+}
+
+/* Marks the recorded operation as complete */
+func (e *FailoverEvent) complete(newMaster *ServerMonitor) {
+	if e == nil {
+		return
+	}
+	e.NewMaster = newMaster.URL
+	e.NewPos = newMaster.position()
+	e.Result = "complete"
+}
+
+/* Adds a logged line to the steps of the recorded operation */
+func recordStep(s string) {
+	if opRecord == nil {
+		return
+	}
+	s = strings.TrimSpace(s)
+	opRecord.Steps = append(opRecord.Steps, time.Now().Format("15:04:05")+" "+s)
+	audit("  %s", s)
+}
+
+/* Records the lines written by the log package, without their date prefix */
+type stepWriter struct{}
+
+func (w stepWriter) Write(p []byte) (int, error) {
+	s := string(p)
+	if log.Flags() == log.LstdFlags && len(s) > 20 {
+		s = s[20:]
+	}
+	recordStep(s)
+	return len(p), nil
+}
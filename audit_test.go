@@ -0,0 +1,82 @@
+// audit_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFailoverRecord(t *testing.T) {
+	defer func(f, a, s string, d bool) { *failover, *auditFile, *stateFile, *dryRun = f, a, s, d }(*failover, *auditFile, *stateFile, *dryRun)
+	*failover, *stateFile = "force", ""
+	tests := []struct {
+		name   string
+		specs  []simSpec
+		dryRun bool
+		result string
+		master string
+		step   string
+		audit  []string
+	}{
+		{"failover complete", simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 1 }), false, "complete", "db3:3306", "INFO : Slave db3:3306 has been elected as a new master", []string{
+			"Failover of master db1:3306 started by command line",
+			"  INFO : Slave db3:3306 has been elected as a new master",
+			"Failover of master db1:3306 complete, new master db3:3306 at 0-1-115"}},
+		{"failover aborted", simChange(simTopology(), func(sp *simSpec) { sp.down = true }), false, "aborted", "", "ERROR: Failover aborted: no viable candidate", []string{
+			"Failover of master db1:3306 started by command line",
+			"  ERROR: Failover aborted: no viable candidate",
+			"Failover of master db1:3306 aborted"}},
+		{"dry run", simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 1 }), true, "", "", "", nil},
+	}
+	for _, tt := range tests {
+		*auditFile, *dryRun = filepath.Join(t.TempDir(), "audit.log"), false
+		simCluster(t, tt.specs)
+		current.master = current.findMaster(false)
+		*dryRun = tt.dryRun
+		current.Failover(context.Background())
+		if tt.result == "" {
+			if len(current.stateData.History) > 0 {
+				t.Errorf("%s: %d operations recorded, want none", tt.name, len(current.stateData.History))
+			}
+			continue
+		}
+		if len(current.stateData.History) != 1 {
+			t.Errorf("%s: %d operations recorded, want 1", tt.name, len(current.stateData.History))
+			continue
+		}
+		e := current.stateData.History[0]
+		if e.Type != "failover" || e.OldMaster != "db1:3306" || e.NewMaster != tt.master || e.Trigger != "command line" || e.Result != tt.result || e.User == "" {
+			t.Errorf("%s: recorded %+v, want a %s failover of db1:3306 to %q", tt.name, e, tt.result, tt.master)
+		}
+		found := false
+		for _, s := range e.Steps {
+			found = found || strings.HasSuffix(s, tt.step)
+		}
+		if found == false {
+			t.Errorf("%s: step %q not recorded in %q", tt.name, tt.step, e.Steps)
+		}
+		b, _ := ioutil.ReadFile(*auditFile)
+		var lines []string
+		for _, l := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+			// Lines start with the time and the user
+			l = l[strings.Index(l, "] ")+2:]
+			if strings.HasPrefix(l, "  ") == false || contains(tt.audit, l) {
+				lines = append(lines, l)
+			}
+		}
+		if strings.Join(lines, "\n") != strings.Join(tt.audit, "\n") {
+			t.Errorf("%s: audit file\n%s\nwant\n%s", tt.name, strings.Join(lines, "\n"), strings.Join(tt.audit, "\n"))
+		}
+		w := httptest.NewRecorder()
+		current.apiFailovers(w, httptest.NewRequest("GET", "/api/failovers", nil))
+		var res []FailoverEvent
+		if err := json.NewDecoder(w.Body).Decode(&res); err != nil || len(res) != 1 || res[0].NewMaster != tt.master {
+			t.Errorf("%s: GET /api/failovers = %+v, %v, want the recorded failover", tt.name, res, err)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+// autoskip.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+/* Replication errors skipped automatically, and how many skips a slave is allowed per period */
+type SkipPolicy struct {
+	Errnos map[uint]bool
+	Max    int
+	Period time.Duration
+	Limit  string
+}
+
+var skipPolicy *SkipPolicy
+
+var skipPeriods = map[string]time.Duration{"second": time.Second, "minute": time.Minute, "hour": time.Hour, "day": 24 * time.Hour}
+
+/* GTID of the transaction a MySQL applier failed on, as quoted in the error message */
+var failedTrxRe = regexp.MustCompile(`transaction '([0-9a-fA-F-]+:[0-9]+)'`)
+
+/* Parses an error skipping policy, errno,...[:max=<count>/<second|minute|hour|day>]. Without limit, a slave may skip 10 errors per hour. */
+func parseSkipPolicy(s string) (*SkipPolicy, error) {
+	p := &SkipPolicy{Errnos: make(map[uint]bool), Max: 10, Period: time.Hour, Limit: "10/hour"}
+	if i := strings.Index(s, ":"); i >= 0 {
+		limit := s[i+1:]
+		s = s[:i]
+		var count, unit string
+		if strings.HasPrefix(limit, "max=") {
+			count, unit = splitPair(strings.Replace(limit[4:], "/", ":", 1))
+		}
+		n, err := strconv.Atoi(count)
+		if err != nil || n < 1 || skipPeriods[unit] == 0 {
+			return nil, errors.New(fmt.Sprintf("invalid error skipping limit %s, expected max=<count>/<second|minute|hour|day>", limit))
+		}
+		p.Max, p.Period, p.Limit = n, skipPeriods[unit], limit[4:]
+	}
+	for _, item := range strings.Split(s, ",") {
+		n, err := strconv.ParseUint(strings.TrimSpace(item), 10, 0)
+		if err != nil || n == 0 {
+			return nil, errors.New(fmt.Sprintf("invalid replication error number %s", item))
+		}
+		p.Errnos[uint(n)] = true
+	}
+	return p, nil
+}
+
+/* Skips the replication errors of the slaves, chained ones included, once per monitor cycle and never while a switchover or failover runs */
+func (c *Cluster) skipCheck() {
+	if atomic.LoadInt32(&inOperation) == 1 {
+		return
+	}
+	for _, sl := range append(append([]*ServerMonitor{}, c.slaves...), c.chained...) {
+		if sl.inMaintenance() == false {
+			sl.autoSkip()
+		}
+	}
+}
+
+/* Skips the transaction the SQL thread of the slave stopped on when its error is covered by the policy and the slave is within its skip limit. GTID based MySQL slaves commit an empty transaction in place of the failed one, other slaves use the skip counter. Each skip is logged and recorded in the audit file. */
+func (sm *ServerMonitor) autoSkip() {
+	if skipPolicy == nil || sm.SQLThread != "No" || skipPolicy.Errnos[sm.SQLErrno] == false || automationFrozen() || *dryRun {
+		return
+	}
+	var recent []time.Time
+	for _, t := range sm.skipped {
+		if time.Since(t) < skipPolicy.Period {
+			recent = append(recent, t)
+		}
+	}
+	sm.skipped = recent
+	if len(sm.skipped) >= skipPolicy.Max {
+		if sm.skipLimited == false {
+			logprintf("WARN : Slave %s reached its limit of %s skipped errors, error %d left for manual repair", sm.label(), skipPolicy.Limit, sm.SQLErrno)
+			sm.skipLimited = true
+		}
+		return
+	}
+	sm.skipLimited = false
+	var err error
+	what := "skip counter"
+	if sm.Flavor == FLAVOR_MYSQL && sm.UsingGtid == "Auto_Position" {
+		m := failedTrxRe.FindStringSubmatch(sm.SQLError)
+		if m == nil {
+			logprintf("WARN : Could not find the failed transaction of slave %s in error: %s", sm.label(), sm.SQLError)
+			return
+		}
+		what = "empty transaction " + m[1]
+		err = sm.injectEmpty(m[1])
+	} else if sm.Channel != "" {
+		// The skip counter applies to the default connection of the session
+		err = sm.execLocal("SET SESSION default_master_connection='"+sm.Channel+"'", "SET GLOBAL sql_slave_skip_counter=1")
+	} else {
+		err = sm.exec("SET GLOBAL sql_slave_skip_counter=1")
+	}
+	if err == nil {
+		err = sm.exec("START SLAVE SQL_THREAD")
+	}
+	if err != nil {
+		logprintf("ERROR: Could not skip error %d on slave %s: %s", sm.SQLErrno, sm.label(), err)
+		return
+	}
+	sm.skipped = append(sm.skipped, time.Now())
+	logprintf("WARN : Skipped replication error %d on slave %s with %s: %s", sm.SQLErrno, sm.label(), what, sm.SQLError)
+	audit("Skipped replication error %d on %s with %s: %s", sm.SQLErrno, sm.URL, what, sm.SQLError)
+}
+
+/* Commits an empty transaction with the given GTID, so that the applier considers it executed */
+func (sm *ServerMonitor) injectEmpty(gtid string) error {
+	stmts := []string{"SET SESSION gtid_next='" + gtid + "'", "BEGIN", "COMMIT", "SET SESSION gtid_next='AUTOMATIC'"}
+	if sm.db != nil {
+		for _, stmt := range stmts {
+			err := sm.db.Exec(stmt)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	conn, err := sm.Conn.DB.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	for _, stmt := range stmts {
+		_, err = conn.ExecContext(context.Background(), stmt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+// autoskip_test.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseSkipPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		want   *SkipPolicy
+	}{
+		{"default limit", "1062,1032", &SkipPolicy{Errnos: map[uint]bool{1062: true, 1032: true}, Max: 10, Period: time.Hour, Limit: "10/hour"}},
+		{"limit", "1062:max=5/minute", &SkipPolicy{Errnos: map[uint]bool{1062: true}, Max: 5, Period: time.Minute, Limit: "5/minute"}},
+		{"invalid error", "1062,dup", nil},
+		{"zero error", "0", nil},
+		{"invalid unit", "1062:max=5/week", nil},
+		{"invalid count", "1062:max=0/hour", nil},
+		{"invalid limit", "1062:5/hour", nil},
+	}
+	for _, tt := range tests {
+		got, err := parseSkipPolicy(tt.policy)
+		if (err == nil) != (tt.want != nil) || reflect.DeepEqual(got, tt.want) == false {
+			t.Errorf("%s: parseSkipPolicy(%q) = %+v, %v, want %+v", tt.name, tt.policy, got, err, tt.want)
+		}
+	}
+}
+
+func TestAutoSkip(t *testing.T) {
+	defer func(p *SkipPolicy, d bool) { skipPolicy, *dryRun = p, d }(skipPolicy, *dryRun)
+	uuid := "3e11fa47-71ca-11e1-9e33-c80aa9429562"
+	tests := []struct {
+		name    string
+		errno   uint
+		error   string
+		flavor  string
+		gtid    string
+		channel string
+		dryRun  bool
+		want    []string
+	}{
+		{"skip counter", 1062, "Duplicate entry", FLAVOR_MARIADB, "Slave_Pos", "", false, []string{"SET GLOBAL sql_slave_skip_counter=1", "START SLAVE SQL_THREAD"}},
+		{"error outside of the policy", 1146, "Table doesn't exist", FLAVOR_MARIADB, "Slave_Pos", "", false, nil},
+		{"replication channel", 1062, "Duplicate entry", FLAVOR_MARIADB, "Slave_Pos", "ch1", false, []string{"SET SESSION default_master_connection='ch1'", "SET GLOBAL sql_slave_skip_counter=1", "START SLAVE 'ch1' SQL_THREAD"}},
+		{"empty transaction", 1062, "Worker 1 failed executing transaction '" + uuid + ":42' at master log", FLAVOR_MYSQL, "Auto_Position", "", false, []string{"SET SESSION gtid_next='" + uuid + ":42'", "BEGIN", "COMMIT", "SET SESSION gtid_next='AUTOMATIC'", "START SLAVE SQL_THREAD"}},
+		{"transaction not found", 1062, "Duplicate entry", FLAVOR_MYSQL, "Auto_Position", "", false, nil},
+		{"dry run", 1062, "Duplicate entry", FLAVOR_MARIADB, "Slave_Pos", "", true, nil},
+	}
+	for _, tt := range tests {
+		skipPolicy, _ = parseSkipPolicy("1062,1032")
+		*dryRun = tt.dryRun
+		sims := simCluster(t, simTopology())
+		sm := simServerByURL("db2:3306")
+		sm.SQLThread, sm.SQLErrno, sm.SQLError = "No", tt.errno, tt.error
+		sm.Flavor, sm.UsingGtid, sm.Channel = tt.flavor, tt.gtid, tt.channel
+		sm.autoSkip()
+		if reflect.DeepEqual(sims["db2:3306"].execs, tt.want) == false {
+			t.Errorf("%s: statements %q, want %q", tt.name, sims["db2:3306"].execs, tt.want)
+		}
+		skips := 0
+		if tt.want != nil {
+			skips = 1
+		}
+		if len(sm.skipped) != skips {
+			t.Errorf("%s: %d skips recorded, want %d", tt.name, len(sm.skipped), skips)
+		}
+	}
+}
+
+func TestAutoSkipLimit(t *testing.T) {
+	defer func(p *SkipPolicy) { skipPolicy = p }(skipPolicy)
+	skipPolicy, _ = parseSkipPolicy("1062:max=2/hour")
+	sims := simCluster(t, simTopology())
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	logWriter.out = &out
+	sm := simServerByURL("db2:3306")
+	sm.SQLThread, sm.SQLErrno, sm.SQLError = "No", 1062, "Duplicate entry"
+	// A skip older than the period does not count
+	sm.skipped = []time.Time{time.Now().Add(-2 * time.Hour)}
+	for i := 0; i < 4; i++ {
+		sm.autoSkip()
+	}
+	n := 0
+	for _, stmt := range sims["db2:3306"].execs {
+		if stmt == "SET GLOBAL sql_slave_skip_counter=1" {
+			n++
+		}
+	}
+	if n != 2 {
+		t.Errorf("%d errors skipped, want 2", n)
+	}
+	if c := strings.Count(out.String(), "Slave db2:3306 reached its limit of 2/hour skipped errors, error 1062 left for manual repair"); c != 1 {
+		t.Errorf("limit reported %d times, want once:\n%s", c, out.String())
+	}
+}
+
+func TestSkipCheck(t *testing.T) {
+	defer func(p *SkipPolicy, l []*Cluster, i bool) { skipPolicy, clusters, *interactive = p, l, i }(skipPolicy, clusters, *interactive)
+	defer atomic.StoreInt32(&inOperation, 0)
+	skipPolicy, _ = parseSkipPolicy("1062")
+	*interactive = false
+	sims := simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.stopped = sp.id == 2 }))
+	sims["db2:3306"].status.Last_SQL_Errno = 1062
+	current.master = current.findMaster(true)
+	clusters = []*Cluster{current}
+	skips := func() int {
+		n := 0
+		for _, stmt := range sims["db2:3306"].execs {
+			if stmt == "SET GLOBAL sql_slave_skip_counter=1" {
+				n++
+			}
+		}
+		return n
+	}
+	// Refreshes of the console and of the operations do not skip
+	current.refreshTopology(context.Background())
+	if n := skips(); n != 0 {
+		t.Errorf("%d errors skipped by a refresh, want none", n)
+	}
+	// The monitor skips once per tick however often it refreshes
+	refresh := func(c *Cluster) {
+		c.refreshTopology(context.Background())
+		c.refreshTopology(context.Background())
+	}
+	monitorTick(refresh)
+	if n := skips(); n != 1 {
+		t.Errorf("%d errors skipped in a tick, want 1", n)
+	}
+	atomic.StoreInt32(&inOperation, 1)
+	monitorTick(refresh)
+	if n := skips(); n != 1 {
+		t.Errorf("%d errors skipped while an operation runs, want 1", n)
+	}
+}
@@ -0,0 +1,125 @@
+// backend.go
+package main
+
+import (
+	"context"
+	"github.com/jmoiron/sqlx"
+	"github.com/mariadb-corporation/replication-manager/pkg/cluster"
+	"github.com/tanji/mariadb-tools/dbhelper"
+	"strings"
+)
+
+/* Backend is the access of the monitor to the state of a database server, read at each refresh and during elections and changed by the operations. The default backend queries the server through its connection; tests substitute a simulated one answering from memory, so that master detection, election, failure detection and the steps of the operations run without servers. */
+type Backend interface {
+	Ping() error
+	Variables() (map[string]string, error)
+	Variable(name string) string
+	SlaveStatus(channel string) (dbhelper.SlaveStatus, error)
+	Query(query string, args ...interface{}) ([]map[string]string, error)
+	Exec(stmt string) error
+}
+
+/* Implemented by the backends connecting as another user in place, as the simulated ones do instead of a new connection */
+type userBackend interface {
+	SwitchUser(user string, pass string) error
+}
+
+/* Simulated backends by server URL, replacing the connections of the servers the monitor opens, nil outside of tests */
+var simBackends map[string]Backend
+
+/* Queries the server through its connection, each query bounded by the context */
+type mysqlBackend struct {
+	conn *sqlx.DB
+	ctx  context.Context
+}
+
+/* Returns the backend of the server, the simulated one if set or else its current connection */
+func (sm *ServerMonitor) backend() Backend {
+	if sm.db != nil {
+		return sm.db
+	}
+	ctx := sm.refreshCtx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return mysqlBackend{conn: sm.Conn, ctx: ctx}
+}
+
+/* Runs a query on the server through its backend */
+func (sm *ServerMonitor) query(query string, args ...interface{}) ([]map[string]string, error) {
+	return sm.backend().Query(query, args...)
+}
+
+func (b mysqlBackend) Ping() error {
+	return b.conn.PingContext(b.ctx)
+}
+
+func (b mysqlBackend) Variables() (map[string]string, error) {
+	rows, err := queryRowsContext(b.ctx, b.conn, "SELECT UPPER(Variable_Name) AS VARIABLE_NAME, UPPER(Variable_Value) AS VALUE FROM information_schema.GLOBAL_VARIABLES")
+	if err != nil {
+		return nil, err
+	}
+	vars := make(map[string]string, len(rows))
+	for _, r := range rows {
+		vars[r["VARIABLE_NAME"]] = r["VALUE"]
+	}
+	return vars, nil
+}
+
+func (b mysqlBackend) Variable(name string) string {
+	var value string
+	b.conn.GetContext(b.ctx, &value, "SELECT UPPER(Variable_Value) FROM information_schema.GLOBAL_VARIABLES WHERE Variable_Name = ?", name)
+	return value
+}
+
+/* Returns the slave status of the default connection, or of the named connection of a MariaDB multi-source slave */
+func (b mysqlBackend) SlaveStatus(channel string) (dbhelper.SlaveStatus, error) {
+	query := "SHOW SLAVE STATUS"
+	if channel != "" {
+		query = "SHOW SLAVE '" + channel + "' STATUS"
+	}
+	ss := dbhelper.SlaveStatus{}
+	b.conn.MapperFunc(strings.Title)
+	err := b.conn.Unsafe().GetContext(b.ctx, &ss, query)
+	return ss, err
+}
+
+func (b mysqlBackend) Query(query string, args ...interface{}) ([]map[string]string, error) {
+	return queryRowsContext(b.ctx, b.conn, query, args...)
+}
+
+func (b mysqlBackend) Exec(stmt string) error {
+	_, err := b.conn.ExecContext(b.ctx, stmt)
+	return err
+}
+
+/* The server as a node of the operations of the cluster library. Its statements run within the step timeout and in dry-run mode are only logged, like those of the monitor. */
+type serverNode struct {
+	sm *ServerMonitor
+}
+
+/* Returns the server as a node of the cluster library */
+func (sm *ServerMonitor) node() cluster.Node {
+	return serverNode{sm}
+}
+
+/* Returns the replication account of the operations, logging the failures they tolerate */
+func (c *Cluster) replication(logf func(format string, args ...interface{})) cluster.Replication {
+	return cluster.Replication{User: c.rplUser, Password: c.rplPass, Logf: logf}
+}
+
+func (n serverNode) Name() string   { return n.sm.URL }
+func (n serverNode) Host() string   { return n.sm.IP }
+func (n serverNode) Port() string   { return n.sm.Port }
+func (n serverNode) Flavor() string { return n.sm.Flavor }
+
+func (n serverNode) Exec(ctx context.Context, stmt string) error {
+	return n.sm.execContext(ctx, stmt)
+}
+
+func (n serverNode) Query(ctx context.Context, query string) ([]map[string]string, error) {
+	if n.sm.db != nil {
+		return n.sm.db.Query(query)
+	}
+	return mysqlBackend{conn: n.sm.Conn, ctx: ctx}.Query(query)
+}
@@ -0,0 +1,316 @@
+// backend_test.go
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/mariadb-corporation/replication-manager/pkg/cluster"
+	"github.com/tanji/mariadb-tools/dbhelper"
+	"io/ioutil"
+	"log"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+/* Server simulated in memory, answering the monitor from its fields */
+type simServer struct {
+	down   bool
+	vars   map[string]string
+	status *dbhelper.SlaveStatus          // nil for a server that is not a slave
+	delay  int64                          // MASTER_DELAY of the slave
+	execs  []string                       // statements run on the server, in order
+	fail   string                         // prefix of the statements failing
+	rows   map[string][]map[string]string // answers of the queries starting with each key, taking precedence over the simulated ones
+	wait   time.Duration                  // time taken by a ping, as on a slow network
+	users  map[string]string              // passwords of the users allowed to connect, any user connecting when nil
+	logins []string                       // users the backend was switched to, in order
+}
+
+var errSimDown = errors.New("simulated server is down")
+
+func (s *simServer) Ping() error {
+	time.Sleep(s.wait)
+	if s.down {
+		return errSimDown
+	}
+	return nil
+}
+
+func (s *simServer) Variables() (map[string]string, error) {
+	if s.down {
+		return nil, errSimDown
+	}
+	sv := make(map[string]string)
+	for k, v := range s.vars {
+		sv[k] = v
+	}
+	return sv, nil
+}
+
+func (s *simServer) Variable(name string) string {
+	return s.vars[name]
+}
+
+func (s *simServer) SlaveStatus(channel string) (dbhelper.SlaveStatus, error) {
+	if s.down {
+		return dbhelper.SlaveStatus{}, errSimDown
+	}
+	if s.status == nil {
+		return dbhelper.SlaveStatus{}, sql.ErrNoRows
+	}
+	return *s.status, nil
+}
+
+func (s *simServer) Query(query string, args ...interface{}) ([]map[string]string, error) {
+	if s.down {
+		return nil, errSimDown
+	}
+	for q, rows := range s.rows {
+		if strings.HasPrefix(query, q) {
+			return rows, nil
+		}
+	}
+	switch query {
+	case "SHOW ALL SLAVES STATUS", "SHOW SLAVE STATUS":
+		if s.status == nil {
+			return nil, nil
+		}
+		s.applyRelay()
+		delay := "NULL"
+		if s.status.Seconds_Behind_Master.Valid {
+			delay = strconv.FormatInt(s.status.Seconds_Behind_Master.Int64, 10)
+		}
+		return []map[string]string{{
+			"Connection_name":       "",
+			"Master_Host":           s.status.Master_Host,
+			"Master_Port":           strconv.Itoa(int(s.status.Master_Port)),
+			"Slave_IO_Running":      s.status.Slave_IO_Running,
+			"Slave_SQL_Running":     s.status.Slave_SQL_Running,
+			"Seconds_Behind_Master": delay,
+			"SQL_Delay":             strconv.FormatInt(s.delay, 10),
+			"Using_Gtid":            s.status.Using_Gtid,
+			"Master_Log_File":       s.status.Master_Log_File,
+			"Read_Master_Log_Pos":   strconv.Itoa(int(s.status.Read_Master_Log_Pos)),
+			"Relay_Master_Log_File": s.status.Relay_Master_Log_File,
+			"Exec_Master_Log_Pos":   strconv.Itoa(int(s.status.Exec_Master_Log_Pos)),
+			"Last_SQL_Errno":        strconv.Itoa(int(s.status.Last_SQL_Errno)),
+			"Last_SQL_Error":        s.status.Last_SQL_Error,
+		}}, nil
+	}
+	if m := simPosWaitRe.FindStringSubmatch(query); m != nil {
+		return s.waitPos(m[1], m[2], m[3]), nil
+	}
+	if m := simWaitRe.FindStringSubmatch(query); m != nil {
+		return s.waitGtid(m[1], m[2]), nil
+	}
+	if m := simShowVarsRe.FindStringSubmatch(query); m != nil {
+		var rows []map[string]string
+		for _, name := range strings.Split(m[1], "','") {
+			if v, ok := s.vars[strings.ToUpper(name)]; ok {
+				rows = append(rows, map[string]string{"Variable_name": name, "Value": v})
+			}
+		}
+		return rows, nil
+	}
+	if m := simVarRe.FindStringSubmatch(query); m != nil {
+		if v, ok := s.vars[strings.ToUpper(m[1])]; ok {
+			return []map[string]string{{"value": v}}, nil
+		}
+		return nil, errors.New("unknown system variable " + m[1])
+	}
+	return nil, nil
+}
+
+/* Answers MASTER_GTID_WAIT: a slave whose SQL thread runs applies the position at once, any other server behind it waits for the timeout */
+func (s *simServer) waitGtid(pos string, timeout string) []map[string]string {
+	cur := s.vars["GTID_CURRENT_POS"]
+	if cluster.GTIDCovers(cur, pos) {
+		return []map[string]string{{"res": "0"}}
+	}
+	if s.status != nil && s.status.Slave_SQL_Running == "Yes" {
+		s.vars["GTID_CURRENT_POS"] = cluster.GTIDUnion(cur, pos)
+		return []map[string]string{{"res": "0"}}
+	}
+	n, _ := strconv.Atoi(timeout)
+	time.Sleep(time.Duration(n) * time.Second)
+	return []map[string]string{{"res": "-1"}}
+}
+
+/* A slave whose SQL thread runs executes at once the events its IO thread received */
+func (s *simServer) applyRelay() {
+	if s.status != nil && s.status.Slave_SQL_Running == "Yes" {
+		s.status.Relay_Master_Log_File, s.status.Exec_Master_Log_Pos = s.status.Master_Log_File, s.status.Read_Master_Log_Pos
+	}
+}
+
+/* Answers MASTER_POS_WAIT: NULL without a running SQL thread, otherwise the slave applies its relay log and waits for the timeout if it is still behind */
+func (s *simServer) waitPos(file string, pos string, timeout string) []map[string]string {
+	if s.status == nil || s.status.Slave_SQL_Running != "Yes" {
+		return []map[string]string{{"res": ""}}
+	}
+	s.applyRelay()
+	p, _ := strconv.Atoi(pos)
+	if s.status.Relay_Master_Log_File > file || (s.status.Relay_Master_Log_File == file && int(s.status.Exec_Master_Log_Pos) >= p) {
+		return []map[string]string{{"res": "0"}}
+	}
+	n, _ := strconv.Atoi(timeout)
+	time.Sleep(time.Duration(n) * time.Second)
+	return []map[string]string{{"res": "-1"}}
+}
+
+var (
+	errSimFailed  = errors.New("simulated statement failure")
+	simChangeRe   = regexp.MustCompile(`master_host='([^']*)', master_port=([0-9]+)`)
+	simVarRe      = regexp.MustCompile(`^SELECT @@GLOBAL\.([a-z_]+) AS value$`)
+	simShowVarsRe = regexp.MustCompile(`^SHOW GLOBAL VARIABLES WHERE Variable_name IN \('(.*)'\)$`)
+	simSetRe      = regexp.MustCompile(`^SET GLOBAL ([a-z_]+) ?= ?'?([^']*)'?$`)
+	simPosWaitRe  = regexp.MustCompile(`MASTER_POS_WAIT\('([^']*)', ([0-9]+), ([0-9]+)\)`)
+	simWaitRe     = regexp.MustCompile(`MASTER_GTID_WAIT\('([^']*)', ([0-9]+)\)`)
+	simChannelRe  = regexp.MustCompile(`^(CHANGE MASTER|START SLAVE|STOP SLAVE|RESET SLAVE) '[^']*'`)
+)
+
+/* Switches the connection to another user, refused when down or when the user is not allowed */
+func (s *simServer) SwitchUser(user string, pass string) error {
+	if s.down {
+		return errSimDown
+	}
+	if p, ok := s.users[user]; s.users != nil && (ok == false || p != pass) {
+		return errors.New("Access denied for user '" + user + "'")
+	}
+	s.logins = append(s.logins, user)
+	return nil
+}
+
+/* Records the statement and applies the effects of those changing the replication state */
+func (s *simServer) Exec(stmt string) error {
+	if s.down {
+		return errSimDown
+	}
+	s.execs = append(s.execs, stmt)
+	if s.fail != "" && strings.HasPrefix(stmt, s.fail) {
+		return errSimFailed
+	}
+	// The connection of a multi-source slave is the simulated one
+	stmt = simChannelRe.ReplaceAllString(stmt, "$1")
+	switch {
+	case stmt == "SET GLOBAL read_only=0":
+		s.vars["READ_ONLY"] = "OFF"
+	case stmt == "SET GLOBAL read_only=1":
+		s.vars["READ_ONLY"] = "ON"
+	case stmt == "RESET SLAVE ALL":
+		s.status = nil
+	case stmt == "STOP SLAVE" && s.status != nil:
+		s.status.Slave_IO_Running, s.status.Slave_SQL_Running = "No", "No"
+	case stmt == "START SLAVE" && s.status != nil:
+		s.status.Slave_IO_Running, s.status.Slave_SQL_Running = "Yes", "Yes"
+	case strings.HasPrefix(stmt, "CHANGE MASTER TO"):
+		m := simChangeRe.FindStringSubmatch(stmt)
+		if m == nil {
+			break
+		}
+		if s.status == nil {
+			s.status = &dbhelper.SlaveStatus{Using_Gtid: "Current_Pos", Slave_IO_Running: "No", Slave_SQL_Running: "No"}
+		}
+		p, _ := strconv.Atoi(m[2])
+		s.status.Master_Host, s.status.Master_Port = m[1], uint(p)
+	case simSetRe.MatchString(stmt):
+		m := simSetRe.FindStringSubmatch(stmt)
+		s.vars[strings.ToUpper(m[1])] = m[2]
+	}
+	return nil
+}
+
+/* Returns true if the statement was run on the server */
+func (s *simServer) ran(stmt string) bool {
+	for _, e := range s.execs {
+		if e == stmt || strings.HasPrefix(e, stmt) {
+			return true
+		}
+	}
+	return false
+}
+
+/* Server of a simulated topology */
+type simSpec struct {
+	url      string
+	id       uint
+	master   uint   // server id of the master, 0 for a server that is not a slave
+	gtid     string // GTID_CURRENT_POS
+	down     bool
+	stopped  bool  // replication threads stopped
+	sqlDelay int64 // MASTER_DELAY
+}
+
+/* Cluster built by the last simCluster call, which the tests work on, also the only monitored and displayed cluster */
+var current *Cluster
+
+/* Builds the servers of a simulated topology and loads them as the monitored cluster, the way connectServers does: servers replicating are slaves, a slave down is kept failed, the other servers are unconnected until the master is detected */
+func simCluster(t *testing.T, specs []simSpec) map[string]*simServer {
+	log.SetOutput(ioutil.Discard)
+	logWriter.out = ioutil.Discard
+	tlog = NewTermLog(20)
+	current = &Cluster{Name: "sim", weights: make(map[string]int), opTrigger: "command line"}
+	shown, clusters = current, []*Cluster{current}
+	monitorInterval = time.Second
+	*compatCheck, *durabilityCheck, *maxFail = "off", "off", 3
+	sims := make(map[string]*simServer)
+	simBackends = make(map[string]Backend)
+	urls := make(map[uint]string)
+	for _, sp := range specs {
+		urls[sp.id] = sp.url
+	}
+	for _, sp := range specs {
+		sim := &simServer{down: sp.down, delay: sp.sqlDelay, vars: map[string]string{
+			"SERVER_ID":        strconv.Itoa(int(sp.id)),
+			"GTID_CURRENT_POS": sp.gtid,
+			"GTID_BINLOG_POS":  sp.gtid,
+			"READ_ONLY":        "OFF",
+		}}
+		if sp.master != 0 {
+			sim.vars["READ_ONLY"] = "ON"
+			threads := "Yes"
+			delay := sql.NullInt64{Valid: true}
+			if sp.stopped {
+				threads, delay = "No", sql.NullInt64{}
+			}
+			mhost, mport, _ := net.SplitHostPort(urls[sp.master])
+			p, _ := strconv.Atoi(mport)
+			sim.status = &dbhelper.SlaveStatus{Master_Host: mhost, Master_Port: uint(p), Master_Server_Id: sp.master, Using_Gtid: "Slave_Pos", Slave_IO_Running: threads, Slave_SQL_Running: threads, Seconds_Behind_Master: delay}
+		}
+		sims[sp.url], simBackends[sp.url] = sim, sim
+		sm, err := current.newServerMonitor(sp.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		current.servers = append(current.servers, sm)
+		current.hostList = append(current.hostList, sp.url)
+	}
+	for _, sm := range current.servers {
+		err := sm.refresh()
+		if err != nil && err != sql.ErrNoRows {
+			sm.State = STATE_FAILED
+		}
+		if sims[sm.URL].status != nil {
+			if sm.State != STATE_FAILED {
+				sm.State = STATE_SLAVE
+			}
+			sm.MasterServerId, sm.MasterHost = sims[sm.URL].status.Master_Server_Id, sims[sm.URL].status.Master_Host
+			current.slaves = append(current.slaves, sm)
+		}
+	}
+	return sims
+}
+
+/* Returns the monitored server with the URL */
+func simServerByURL(url string) *ServerMonitor {
+	for _, s := range current.servers {
+		if s.URL == url {
+			return s
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+// backoff.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var errBackoff = errors.New("server is unreachable, waiting before the next connection attempt")
+
+/* Connects again to a failed server. Attempts are spaced with an exponential backoff, from the monitor interval up to the maximum retry interval, so that dead servers do not slow down every refresh with connection timeouts. */
+func (sm *ServerMonitor) probe() error {
+	if time.Now().Before(sm.retryAt) {
+		return errBackoff
+	}
+	err := sm.reconnect()
+	if err != nil {
+		sm.retryDelay *= 2
+		if sm.retryDelay < monitorInterval {
+			sm.retryDelay = monitorInterval
+		}
+		if max := time.Duration(*maxRetry) * time.Second; sm.retryDelay > max {
+			sm.retryDelay = max
+		}
+		sm.retryAt = time.Now().Add(sm.retryDelay)
+		return err
+	}
+	sm.retryDelay, sm.retryAt = 0, time.Time{}
+	return nil
+}
+
+/* Brings back the failed servers that are neither the master, a slave nor the failed master waiting to rejoin, e.g. servers unreachable at startup, once they answer again. Servers replicating from the master become slaves, the others standalone servers. */
+func (c *Cluster) recoveryCheck() {
+	var l []*ServerMonitor
+	for _, s := range c.servers {
+		if s.State != STATE_FAILED || s == c.master || s.URL == c.failedMasterURL || c.isSlave(s.URL) || c.isChained(s.URL) {
+			continue
+		}
+		l = append(l, s)
+	}
+	if len(l) == 0 {
+		return
+	}
+	errs := refreshAll(context.Background(), l)
+	for k, s := range l {
+		if errs[k] != nil && errs[k] != sql.ErrNoRows {
+			continue
+		}
+		if errs[k] == nil && s.UsingGtid != "" && c.master != nil && s.MasterServerId == c.master.ServerId {
+			logprintf("INFO : Server %s is reachable again and replicates from master, adding it to the slaves", s.label())
+			s.setState(STATE_SLAVE)
+			c.slaves = append(c.slaves, s)
+			continue
+		}
+		logprintf("INFO : Server %s is reachable again", s.label())
+		s.setState(STATE_UNCONN)
+	}
+}
@@ -0,0 +1,69 @@
+// backoff_test.go
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProbeBackoff(t *testing.T) {
+	defer func(m int64) { *maxRetry = m }(*maxRetry)
+	*maxRetry = 3
+	sims := simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 2 }))
+	sm := simServerByURL("db2:3306")
+	// Each failed attempt doubles the delay, from the monitor interval up to the maximum retry interval
+	for _, want := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		sm.retryAt = time.Time{}
+		if err := sm.probe(); err != errSimDown {
+			t.Fatalf("probe() = %v, want %v", err, errSimDown)
+		}
+		if sm.retryDelay != want || time.Until(sm.retryAt) > want || time.Until(sm.retryAt) < want-time.Second {
+			t.Errorf("retry in %s (at %s), want %s", sm.retryDelay, sm.retryAt, want)
+		}
+		// The server is not contacted before the retry time
+		if err := sm.probe(); err != errBackoff {
+			t.Errorf("probe() before the retry time = %v, want %v", err, errBackoff)
+		}
+	}
+	sims["db2:3306"].down = false
+	sm.retryAt = time.Time{}
+	if err := sm.probe(); err != nil || sm.retryDelay != 0 || sm.retryAt.IsZero() == false {
+		t.Errorf("probe() of a recovered server = %v, retry in %s, want the backoff reset", err, sm.retryDelay)
+	}
+}
+
+func TestRecoveryCheck(t *testing.T) {
+	tests := []struct {
+		name      string
+		replicate bool
+		state     string
+		slaves    int
+	}{
+		{"standalone server", false, STATE_UNCONN, 2},
+		{"server replicating from the master", true, STATE_SLAVE, 3},
+	}
+	for _, tt := range tests {
+		// db4 was unreachable at startup
+		sims := simCluster(t, append(simTopology(), simSpec{url: "db4:3306", id: 4, gtid: "0-1-120", down: true}))
+		current.master = current.findMaster(true)
+		current.master.State = STATE_MASTER
+		sm := simServerByURL("db4:3306")
+		if sm.State != STATE_FAILED {
+			t.Fatalf("%s: db4:3306 is %s at startup, want %s", tt.name, sm.State, STATE_FAILED)
+		}
+		current.recoveryCheck()
+		if sm.State != STATE_FAILED {
+			t.Errorf("%s: db4:3306 is %s while down, want %s", tt.name, sm.State, STATE_FAILED)
+		}
+		sims["db4:3306"].down = false
+		if tt.replicate {
+			sims["db4:3306"].Exec("CHANGE MASTER TO master_host='db1', master_port=3306")
+			sims["db4:3306"].Exec("START SLAVE")
+			sims["db4:3306"].status.Master_Server_Id = 1
+		}
+		current.recoveryCheck()
+		if sm.State != tt.state || len(current.slaves) != tt.slaves {
+			t.Errorf("%s: db4:3306 is %s with %d slaves, want %s with %d", tt.name, sm.State, len(current.slaves), tt.state, tt.slaves)
+		}
+	}
+}
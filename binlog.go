@@ -0,0 +1,54 @@
+// binlog.go
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+/* Rotates the binary logs of the master when the flush interval has elapsed, and purges the files beyond retention once a minute */
+func (c *Cluster) binlogCheck() {
+	if c.master == nil || c.master.State == STATE_FAILED || automationFrozen() || *dryRun {
+		return
+	}
+	if *binlogFlush > 0 {
+		if c.lastFlush.IsZero() {
+			c.lastFlush = time.Now()
+		} else if time.Since(c.lastFlush) >= time.Duration(*binlogFlush)*time.Second {
+			c.lastFlush = time.Now()
+			err := c.master.exec("FLUSH BINARY LOGS")
+			if err != nil {
+				alertLog("WARN : Could not flush binary logs on master %s: %s", c.master.URL, err)
+			}
+		}
+	}
+	if *binlogKeep > 0 && time.Since(c.lastPurge) >= time.Minute {
+		c.lastPurge = time.Now()
+		err := c.master.purgeBinlogs()
+		if err != nil {
+			alertLog("WARN : Could not purge binary logs on master %s: %s", c.master.URL, err)
+		}
+	}
+}
+
+/* Purges the binary logs older than the last binlog-keep-files ones, sparing the files slaves of the server still have to read */
+func (sm *ServerMonitor) purgeBinlogs() error {
+	files := sm.binlogFiles
+	if len(files) <= *binlogKeep {
+		return nil
+	}
+	keep := binlogPos{File: files[len(files)-*binlogKeep].Name}
+	for _, sl := range sm.cluster.slaves {
+		if sl.URL == sm.URL || sl.ReadFile == "" || sl.MasterServerId != sm.ServerId {
+			continue
+		}
+		if p := (binlogPos{File: sl.ReadFile}); p.seq() < keep.seq() {
+			keep = p
+		}
+	}
+	if keep.File == files[0].Name {
+		return nil
+	}
+	alertLog("INFO : Purging binary logs of %s up to %s", sm.URL, keep.File)
+	return sm.exec(fmt.Sprintf("PURGE BINARY LOGS TO '%s'", keep.File))
+}
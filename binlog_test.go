@@ -0,0 +1,74 @@
+// binlog_test.go
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPurgeBinlogs(t *testing.T) {
+	defer func(k int) { *binlogKeep = k }(*binlogKeep)
+	*binlogKeep = 2
+	tests := []struct {
+		name  string
+		files int
+		read  map[string]string // binlog file read by each slave
+		other bool              // db3 replicates from another master
+		want  string
+	}{
+		{"slaves up to date", 5, map[string]string{"db2:3306": "mysql-bin.000005", "db3:3306": "mysql-bin.000004"}, false, "PURGE BINARY LOGS TO 'mysql-bin.000004'"},
+		{"slave behind retention", 5, map[string]string{"db2:3306": "mysql-bin.000005", "db3:3306": "mysql-bin.000002"}, false, "PURGE BINARY LOGS TO 'mysql-bin.000002'"},
+		{"slave on the first file", 5, map[string]string{"db2:3306": "mysql-bin.000001", "db3:3306": "mysql-bin.000005"}, false, ""},
+		{"slave of another master", 5, map[string]string{"db2:3306": "mysql-bin.000005", "db3:3306": "mysql-bin.000001"}, true, "PURGE BINARY LOGS TO 'mysql-bin.000004'"},
+		{"within retention", 2, map[string]string{"db2:3306": "mysql-bin.000001", "db3:3306": "mysql-bin.000001"}, false, ""},
+	}
+	for _, tt := range tests {
+		sims := simCluster(t, simTopology())
+		master := simServerByURL("db1:3306")
+		master.binlogFiles = nil
+		for _, r := range simBinlogs(make([]string, tt.files)...) {
+			master.binlogFiles = append(master.binlogFiles, binlogFile{Name: r["Log_name"]})
+		}
+		for url, file := range tt.read {
+			simServerByURL(url).ReadFile = file
+		}
+		if tt.other {
+			simServerByURL("db3:3306").MasterServerId = 9
+		}
+		if err := master.purgeBinlogs(); err != nil {
+			t.Errorf("%s: purgeBinlogs() = %s", tt.name, err)
+		}
+		if got := sims["db1:3306"].execs; tt.want == "" && len(got) > 0 || tt.want != "" && (len(got) != 1 || got[0] != tt.want) {
+			t.Errorf("%s: master ran %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBinlogFlush(t *testing.T) {
+	defer func(f int64, k int, d bool) { *binlogFlush, *binlogKeep, *dryRun = f, k, d }(*binlogFlush, *binlogKeep, *dryRun)
+	*binlogFlush, *binlogKeep = 60, 0
+	tests := []struct {
+		name      string
+		lastFlush time.Time
+		dryRun    bool
+		want      bool
+	}{
+		{"first check", time.Time{}, false, false},
+		{"interval not elapsed", time.Now().Add(-30 * time.Second), false, false},
+		{"interval elapsed", time.Now().Add(-90 * time.Second), false, true},
+		{"dry run", time.Now().Add(-90 * time.Second), true, false},
+	}
+	for _, tt := range tests {
+		*dryRun = false
+		sims := simCluster(t, simTopology())
+		current.master = current.findMaster(true)
+		current.lastFlush, *dryRun = tt.lastFlush, tt.dryRun
+		current.binlogCheck()
+		if got := sims["db1:3306"].ran("FLUSH BINARY LOGS"); got != tt.want {
+			t.Errorf("%s: binary logs flushed %t, want %t", tt.name, got, tt.want)
+		}
+		if tt.lastFlush.IsZero() && current.lastFlush.IsZero() {
+			t.Errorf("%s: first flush not scheduled", tt.name)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// bootstrap.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/tanji/mariadb-tools/dbhelper"
+	"log"
+	"strings"
+)
+
+/* Builds replication between the blank servers of the cluster. The preferred master with the highest weight, or the first host, becomes the master and gets the replication user; the other servers replicate from it with GTID from the start of its binary logs and are set read-only. */
+func (c *Cluster) bootstrap() error {
+	if strings.ContainsAny(c.rplUser+c.rplPass, "'\\") {
+		return errors.New("The replication credentials cannot contain quotes or backslashes")
+	}
+	for _, s := range c.servers {
+		if s.State == STATE_FAILED {
+			return errors.New(fmt.Sprintf("Server %s is not reachable, cannot bootstrap", s.URL))
+		}
+		if s.UsingGtid != "" {
+			return errors.New(fmt.Sprintf("Server %s is already configured as a slave, cannot bootstrap", s.URL))
+		}
+	}
+	m := c.servers[0]
+	best := 0
+	for _, s := range c.servers {
+		if c.weights[s.URL] > best {
+			m, best = s, c.weights[s.URL]
+		}
+	}
+	if m.LogBin != "ON" {
+		return errors.New(fmt.Sprintf("Binary logging is not enabled on %s, it cannot be a master", m.URL))
+	}
+	log.Printf("INFO : Bootstrapping cluster with master %s", m.URL)
+	audit("Bootstrap started with master %s", m.URL)
+	err := m.run("SET GLOBAL read_only=0", setReadOnly(false))
+	if err != nil {
+		return errors.New(fmt.Sprintf("Could not make %s writable: %s", m.URL, err))
+	}
+	// The user is created through the binary log, slaves replicating from the start get it too
+	for _, stmt := range []string{
+		fmt.Sprintf("CREATE USER IF NOT EXISTS '%s'@'%%' IDENTIFIED BY '%s'", c.rplUser, c.rplPass),
+		fmt.Sprintf("GRANT REPLICATION SLAVE ON *.* TO '%s'@'%%'", c.rplUser),
+	} {
+		err = m.exec(stmt)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Could not create replication user on %s: %s", m.URL, err))
+		}
+	}
+	m.State = STATE_MASTER
+	c.master = m
+	failed := 0
+	for _, s := range c.servers {
+		if s == m {
+			continue
+		}
+		err = s.bootstrapSlave(m)
+		if err != nil {
+			log.Printf("ERROR: Could not set up %s as a slave: %s", s.URL, err)
+			audit("Bootstrap of slave %s failed: %s", s.URL, err)
+			failed++
+			continue
+		}
+		log.Printf("INFO : %-21s replicates from %s", s.URL, m.URL)
+		audit("Bootstrapped slave %s of master %s", s.URL, m.URL)
+		s.State = STATE_SLAVE
+		c.slaves = append(c.slaves, s)
+	}
+	c.saveState()
+	if failed > 0 {
+		return errors.New(fmt.Sprintf("%d servers could not be set up as slaves", failed))
+	}
+	log.Printf("INFO : Bootstrap complete, %d slaves replicate from %s", len(c.slaves), m.URL)
+	return nil
+}
+
+/* Points a blank server at the master from the start of its binary logs, then starts replication and sets the server read-only */
+func (sm *ServerMonitor) bootstrapSlave(m *ServerMonitor) error {
+	sm.run("STOP SLAVE", dbhelper.StopSlave)
+	if sm.Flavor != FLAVOR_MYSQL {
+		err := sm.exec("SET GLOBAL gtid_slave_pos=''")
+		if err != nil {
+			return err
+		}
+	}
+	err := sm.exec("CHANGE MASTER TO master_host='" + m.IP + "', master_port=" + m.Port + ", master_user='" + sm.cluster.rplUser + "', master_password='" + sm.cluster.rplPass + "'" + sm.gtidMasterOpt("slave_pos"))
+	if err != nil {
+		return err
+	}
+	err = sm.run("START SLAVE", dbhelper.StartSlave)
+	if err != nil {
+		return err
+	}
+	err = sm.run("SET GLOBAL read_only=1", setReadOnly(true))
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		return nil
+	}
+	return sm.waitReplicating(rotateWait)
+}
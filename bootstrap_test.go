@@ -0,0 +1,85 @@
+// bootstrap_test.go
+package main
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+)
+
+/* Blank servers with binary logging enabled */
+func simBlank(t *testing.T) map[string]*simServer {
+	specs := []simSpec{{url: "db1:3306", id: 1}, {url: "db2:3306", id: 2}, {url: "db3:3306", id: 3}}
+	sims := simCluster(t, specs)
+	for _, s := range current.servers {
+		sims[s.URL].vars["LOG_BIN"] = "ON"
+		s.refresh()
+	}
+	return sims
+}
+
+func TestBootstrap(t *testing.T) {
+	defer func(s string) { *stateFile = s }(*stateFile)
+	*stateFile = ""
+	tests := []struct {
+		name    string
+		user    string
+		weights map[string]int
+		change  func(sims map[string]*simServer)
+		master  string
+		slaves  int
+		error   string
+	}{
+		{"first host", "repl", nil, nil, "db1:3306", 2, ""},
+		{"preferred master", "repl", map[string]int{"db2:3306": 1, "db3:3306": 5}, nil, "db3:3306", 2, ""},
+		{"quote in credentials", "re'pl", nil, nil, "", 0, "The replication credentials cannot contain quotes or backslashes"},
+		{"server down", "repl", nil, func(sims map[string]*simServer) { sims["db2:3306"].down = true }, "", 0, "Server db2:3306 is not reachable, cannot bootstrap"},
+		{"server already a slave", "repl", nil, func(sims map[string]*simServer) {
+			sims["db3:3306"].Exec("CHANGE MASTER TO master_host='db9', master_port=3306")
+		}, "", 0, "Server db3:3306 is already configured as a slave, cannot bootstrap"},
+		{"master without binary log", "repl", nil, func(sims map[string]*simServer) { sims["db1:3306"].vars["LOG_BIN"] = "OFF" }, "", 0, "Binary logging is not enabled on db1:3306, it cannot be a master"},
+		{"slave failing", "repl", nil, func(sims map[string]*simServer) { sims["db3:3306"].fail = "CHANGE MASTER" }, "db1:3306", 1, "1 servers could not be set up as slaves"},
+	}
+	for _, tt := range tests {
+		sims := simBlank(t)
+		current.rplUser, current.rplPass = tt.user, "s3cret"
+		if tt.weights != nil {
+			current.weights = tt.weights
+		}
+		if tt.change != nil {
+			tt.change(sims)
+			for _, s := range current.servers {
+				if err := s.refresh(); err != nil && err != sql.ErrNoRows {
+					s.State = STATE_FAILED
+				}
+			}
+		}
+		err := current.bootstrap()
+		if (err == nil && tt.error != "") || (err != nil && err.Error() != tt.error) {
+			t.Errorf("%s: bootstrap() = %v, want %q", tt.name, err, tt.error)
+		}
+		got := ""
+		if current.master != nil {
+			got = current.master.URL
+		}
+		if got != tt.master || len(current.slaves) != tt.slaves {
+			t.Errorf("%s: master %q with %d slaves, want %q with %d", tt.name, got, len(current.slaves), tt.master, tt.slaves)
+		}
+		if tt.master == "" {
+			continue
+		}
+		m := sims[tt.master]
+		if m.ran("CREATE USER IF NOT EXISTS 'repl'@'%' IDENTIFIED BY 's3cret'") == false || m.vars["READ_ONLY"] != "OFF" {
+			t.Errorf("%s: master not set up: %q", tt.name, m.execs)
+		}
+		for _, sl := range current.slaves {
+			sim := sims[sl.URL]
+			if sim.status == nil || sim.status.Master_Host+":3306" != tt.master || sim.vars["GTID_SLAVE_POS"] != "" || sim.vars["READ_ONLY"] != "ON" || sl.State != STATE_SLAVE {
+				t.Errorf("%s: slave %s not set up: %q", tt.name, sl.URL, sim.execs)
+			}
+			if strings.Contains(strings.Join(sim.execs, ";"), "master_user='repl', master_password='s3cret'") == false {
+				t.Errorf("%s: slave %s without the replication user: %q", tt.name, sl.URL, sim.execs)
+			}
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// cancel.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+/* Cancellation of the failover or switchover in progress on a cluster. An operation can be aborted from the API, the web dashboard or the daemon client until it reaches its point of no return, when the new master stops replicating; after that, its steps only stop on the step timeout, so that an abort never leaves the topology half rewired. */
+
+var errNoOperation = errors.New("No failover or switchover is in progress")
+
+/* Returns the context of a new failover or switchover, which abortOperation cancels, and the function marking its end */
+func (c *Cluster) beginOperation(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	c.opLock.Lock()
+	c.opCancel, c.opAbortedBy = cancel, ""
+	c.opLock.Unlock()
+	return ctx, func() {
+		c.opLock.Lock()
+		c.opCancel = nil
+		c.opLock.Unlock()
+		cancel()
+	}
+}
+
+/* Marks the point of no return of the operation. Returns the context of its remaining steps, which an abort no longer cancels, or the error of an abort requested before. */
+func (c *Cluster) commitOperation(ctx context.Context) (context.Context, error) {
+	c.opLock.Lock()
+	if ctx.Err() == nil {
+		c.opCancel = nil
+	}
+	c.opLock.Unlock()
+	if ctx.Err() != nil {
+		return ctx, c.abortError(ctx)
+	}
+	return context.Background(), nil
+}
+
+/* Cancels the operation in progress on behalf of who */
+func (c *Cluster) abortOperation(who string) error {
+	c.opLock.Lock()
+	defer c.opLock.Unlock()
+	if c.opCancel == nil {
+		if atomic.LoadInt32(&inOperation) == 1 {
+			return errors.New("The operation in progress is past its point of no return and cannot be aborted")
+		}
+		return errNoOperation
+	}
+	alertLog("WARN : Abort of the operation in progress requested by %s", who)
+	c.opAbortedBy = who
+	c.opCancel()
+	c.opCancel = nil
+	return nil
+}
+
+/* Describes why the context of an operation ended */
+func (c *Cluster) abortError(ctx context.Context) error {
+	c.opLock.Lock()
+	who := c.opAbortedBy
+	c.opLock.Unlock()
+	if ctx.Err() == context.Canceled && who != "" {
+		return errors.New("aborted by " + who)
+	}
+	return ctx.Err()
+}
+
+/* Returns the context of one step of an operation, bounded by the step timeout */
+func stepContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if *stepTimeout > 0 {
+		return context.WithTimeout(ctx, time.Duration(*stepTimeout)*time.Second)
+	}
+	return context.WithCancel(ctx)
+}
+
+/* Executes a state changing statement on the server within a step of an operation, or only logs it in dry-run mode. The statement runs on a connection of its own, so that a statement still running when the step times out or is aborted is killed on the server rather than left to complete behind the operation. */
+func (sm *ServerMonitor) execContext(ctx context.Context, stmt string) error {
+	stmt = sm.channelStmt(stmt)
+	if *dryRun {
+		alertLog("DRY-RUN: [%s] %s", sm.URL, redactStmt(stmt))
+		return nil
+	}
+	if sm.db != nil {
+		return sm.db.Exec(stmt)
+	}
+	ctx, cancel := stepContext(ctx)
+	defer cancel()
+	conn, err := sm.Conn.Conn(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return sm.cluster.abortError(ctx)
+		}
+		return err
+	}
+	defer conn.Close()
+	var id int64
+	err = conn.QueryRowContext(ctx, "SELECT CONNECTION_ID()").Scan(&id)
+	if err == nil {
+		_, err = conn.ExecContext(ctx, stmt)
+	}
+	if err != nil && ctx.Err() != nil {
+		logprintf("WARN : %s on %s did not complete: %s", redactStmt(stmt), sm.URL, sm.cluster.abortError(ctx))
+		if id > 0 {
+			sm.killQuery(id)
+		}
+		return sm.cluster.abortError(ctx)
+	}
+	return err
+}
+
+/* Kills the statement running on a connection of the manager, from another connection */
+func (sm *ServerMonitor) killQuery(id int64) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := sm.Conn.ExecContext(ctx, fmt.Sprintf("KILL QUERY %d", id))
+	if err != nil {
+		logprintf("ERROR: Could not kill query %d on %s: %s", id, sm.URL, err)
+	}
+}
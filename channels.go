@@ -0,0 +1,78 @@
+// channels.go
+package main
+
+import (
+	"github.com/tanji/mariadb-tools/dbhelper"
+	"net"
+	"strconv"
+	"strings"
+)
+
+/* A replication connection of a MariaDB multi-source slave */
+type Channel struct {
+	Name      string
+	Master    string
+	IOThread  string
+	SQLThread string
+	Delay     string
+}
+
+/* Statements taking the connection name of a multi-source slave after their first words */
+var channelStmts = []string{"CHANGE MASTER", "START SLAVE", "STOP SLAVE", "RESET SLAVE"}
+
+/* Reads the replication connections of the server with SHOW ALL SLAVES STATUS and selects the one replicating from the cluster, the connection from the master or else from another monitored server. The default connection is used when none of them does, and always on MySQL, which has no such statement. The MASTER_DELAY of the selected connection is read from the same rows. */
+func (sm *ServerMonitor) readChannels() {
+	sm.Channels, sm.Channel = nil, ""
+	if sm.Flavor != FLAVOR_MARIADB {
+		return
+	}
+	sm.SQLDelay = 0
+	rows, err := sm.query("SHOW ALL SLAVES STATUS")
+	if err != nil {
+		return
+	}
+	found := false
+	for _, r := range rows {
+		ch := Channel{Name: r["Connection_name"], Master: net.JoinHostPort(r["Master_Host"], r["Master_Port"]), IOThread: r["Slave_IO_Running"], SQLThread: r["Slave_SQL_Running"], Delay: r["Seconds_Behind_Master"]}
+		sm.Channels = append(sm.Channels, ch)
+		for _, s := range sm.cluster.servers {
+			if s == sm || s.Port != r["Master_Port"] || (s.Host != r["Master_Host"] && s.IP != r["Master_Host"]) {
+				continue
+			}
+			if s == sm.cluster.master || !found {
+				sm.Channel, found = ch.Name, true
+			}
+		}
+	}
+	for _, r := range rows {
+		if r["Connection_name"] == sm.Channel {
+			sm.SQLDelay, _ = strconv.ParseInt(r["SQL_Delay"], 10, 64)
+		}
+	}
+}
+
+/* Returns the replication connections of a multi-source slave, nil for a slave of a single master */
+func (sm *ServerMonitor) sources() []Channel {
+	if len(sm.Channels) < 2 {
+		return nil
+	}
+	return sm.Channels
+}
+
+/* Returns the slave status of the connection replicating from the cluster */
+func (sm *ServerMonitor) slaveStatus() (dbhelper.SlaveStatus, error) {
+	return sm.backend().SlaveStatus(sm.Channel)
+}
+
+/* Returns the statement applying to the connection replicating from the cluster on a multi-source slave, so that failover and switchover only rewire that connection and leave the other sources intact */
+func (sm *ServerMonitor) channelStmt(stmt string) string {
+	if sm.Channel == "" {
+		return stmt
+	}
+	for _, prefix := range channelStmts {
+		if strings.HasPrefix(stmt, prefix) {
+			return prefix + " '" + sm.Channel + "'" + stmt[len(prefix):]
+		}
+	}
+	return stmt
+}
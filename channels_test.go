@@ -0,0 +1,95 @@
+// channels_test.go
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+/* Returns a row of SHOW ALL SLAVES STATUS for a running connection */
+func simChannel(name string, host string, delay string) map[string]string {
+	return map[string]string{"Connection_name": name, "Master_Host": host, "Master_Port": "3306", "Slave_IO_Running": "Yes", "Slave_SQL_Running": "Yes", "Seconds_Behind_Master": "0", "SQL_Delay": delay}
+}
+
+func TestReadChannels(t *testing.T) {
+	tests := []struct {
+		name     string
+		flavor   string
+		rows     []map[string]string
+		channel  string
+		sources  []string
+		sqlDelay int64
+	}{
+		{"single master", FLAVOR_MARIADB, nil, "", nil, 0},
+		{"external source", FLAVOR_MARIADB, []map[string]string{simChannel("", "ext1", "0"), simChannel("cluster", "db1", "60")}, "cluster", []string{"ext1:3306", "db1:3306"}, 60},
+		{"master first", FLAVOR_MARIADB, []map[string]string{simChannel("a", "db3", "0"), simChannel("b", "db1", "0")}, "b", []string{"db3:3306", "db1:3306"}, 0},
+		{"monitored server", FLAVOR_MARIADB, []map[string]string{simChannel("a", "ext1", "0"), simChannel("b", "db3", "30")}, "b", []string{"ext1:3306", "db3:3306"}, 30},
+		{"no monitored source", FLAVOR_MARIADB, []map[string]string{simChannel("a", "ext1", "0"), simChannel("b", "ext2", "0")}, "", []string{"ext1:3306", "ext2:3306"}, 0},
+		{"MySQL", FLAVOR_MYSQL, []map[string]string{simChannel("", "ext1", "0"), simChannel("cluster", "db1", "0")}, "", nil, 0},
+	}
+	for _, tt := range tests {
+		sims := simCluster(t, simTopology())
+		current.master = current.findMaster(true)
+		if tt.rows != nil {
+			sims["db2:3306"].rows = map[string][]map[string]string{"SHOW ALL SLAVES STATUS": tt.rows}
+		}
+		sm := simServerByURL("db2:3306")
+		sm.Flavor = tt.flavor
+		sm.readChannels()
+		var sources []string
+		for _, ch := range sm.sources() {
+			sources = append(sources, ch.Master)
+		}
+		if sm.Channel != tt.channel || reflect.DeepEqual(sources, tt.sources) == false || sm.SQLDelay != tt.sqlDelay {
+			t.Errorf("%s: channel %q, sources %q, delay %d, want %q, %q, %d", tt.name, sm.Channel, sources, sm.SQLDelay, tt.channel, tt.sources, tt.sqlDelay)
+		}
+	}
+}
+
+func TestChannelStmt(t *testing.T) {
+	sm := &ServerMonitor{Channel: "cluster"}
+	tests := []struct {
+		stmt string
+		want string
+	}{
+		{"STOP SLAVE", "STOP SLAVE 'cluster'"},
+		{"RESET SLAVE ALL", "RESET SLAVE 'cluster' ALL"},
+		{"CHANGE MASTER TO master_host='db3', master_port=3306", "CHANGE MASTER 'cluster' TO master_host='db3', master_port=3306"},
+		{"START SLAVE", "START SLAVE 'cluster'"},
+		{"SET GLOBAL read_only=1", "SET GLOBAL read_only=1"},
+	}
+	for _, tt := range tests {
+		if got := sm.channelStmt(tt.stmt); got != tt.want {
+			t.Errorf("channelStmt(%q) = %q, want %q", tt.stmt, got, tt.want)
+		}
+	}
+	if got := (&ServerMonitor{}).channelStmt("STOP SLAVE"); got != "STOP SLAVE" {
+		t.Errorf("channelStmt() of the default connection = %q", got)
+	}
+}
+
+func TestMultiSourceFailover(t *testing.T) {
+	defer func(f, s string) { *failover, *stateFile = f, s }(*failover, *stateFile)
+	*failover, *stateFile = "force", ""
+	sims := simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 1 }))
+	// db2 also replicates from a server outside of the cluster, on its default connection
+	sims["db2:3306"].rows = map[string][]map[string]string{"SHOW ALL SLAVES STATUS": {simChannel("", "ext1", "0"), simChannel("cluster", "db1", "0")}}
+	simServerByURL("db2:3306").refresh()
+	current.master = current.findMaster(false)
+	if nmUrl, err := current.Failover(context.Background()); nmUrl != "db3:3306" {
+		t.Fatalf("Failover() = %q, %v, want db3:3306", nmUrl, err)
+	}
+	if sims["db2:3306"].ran("CHANGE MASTER 'cluster' TO master_host='db3', master_port=3306") == false {
+		t.Errorf("cluster connection not repointed: %q", sims["db2:3306"].execs)
+	}
+	// Only the connection from the failed master is rewired
+	for _, stmt := range sims["db2:3306"].execs {
+		for _, prefix := range channelStmts {
+			if strings.HasPrefix(stmt, prefix) && strings.HasPrefix(stmt, prefix+" 'cluster'") == false {
+				t.Errorf("%q run on the other connections", stmt)
+			}
+		}
+	}
+}
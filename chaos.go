@@ -0,0 +1,348 @@
+// chaos.go
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* Scenarios of the test command, run in this order by default. Stopping the master comes last since it changes the master. */
+var chaosScenarios = []string{"lag-slave", "break-slave", "stop-master"}
+
+/* Schema holding the marker rows written on the master to check that the slaves replicate */
+const chaosSchema = "replication_manager_test"
+
+/* Outcome of a test scenario */
+type chaosResult struct {
+	Scenario string
+	Passed   bool
+	Detail   string
+	Duration time.Duration
+}
+
+/* Runs failure scenarios against a sandbox cluster and checks that the monitor detects them and that failover behaves correctly, the servers being repaired after each scenario. It is meant to be run against a disposable topology before upgrading, never against production servers. */
+func (c *Cluster) runChaos(names []string) error {
+	if len(names) == 0 {
+		names = chaosScenarios
+	}
+	for _, n := range names {
+		if contains(chaosScenarios, n) == false {
+			return errors.New(fmt.Sprintf("Unknown test scenario %s, expected %s", n, strings.Join(chaosScenarios, ", ")))
+		}
+	}
+	if contains(names, "stop-master") && (*testStop == "" || *testStart == "") {
+		return errors.New("The stop-master scenario requires the test-stop-command and test-start-command options")
+	}
+	if *dryRun {
+		return errors.New("The test command breaks the servers and cannot run in dry-run mode")
+	}
+	if *interactive {
+		fmt.Printf("Run the %s scenarios against master %s? They stop and break servers of the cluster. [y/N] ", strings.Join(names, ", "), c.master.URL)
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if strings.ToLower(strings.TrimSpace(answer)) != "y" {
+			return errors.New("Cancelled")
+		}
+	}
+	var results []chaosResult
+	failed := 0
+	for _, n := range names {
+		start := time.Now()
+		log.Printf("INFO : Running test scenario %s", n)
+		detail, err := c.chaosHealthy()
+		if err == nil {
+			switch n {
+			case "lag-slave":
+				detail, err = c.chaosLagSlave()
+			case "break-slave":
+				detail, err = c.chaosBreakSlave()
+			case "stop-master":
+				detail, err = c.chaosStopMaster()
+			}
+		}
+		r := chaosResult{Scenario: n, Passed: err == nil, Detail: detail, Duration: time.Since(start)}
+		if err != nil {
+			r.Detail = err.Error()
+			failed++
+			log.Printf("ERROR: Test scenario %s failed: %s", n, err)
+		}
+		results = append(results, r)
+	}
+	fmt.Println()
+	for _, r := range results {
+		status := "PASS"
+		if r.Passed == false {
+			status = "FAIL"
+		}
+		fmt.Printf("%s %-12s %6.1fs  %s\n", status, r.Scenario, r.Duration.Seconds(), r.Detail)
+	}
+	if failed > 0 {
+		return errors.New(fmt.Sprintf("%d of %d test scenarios failed", failed, len(results)))
+	}
+	return nil
+}
+
+/* Checks that the sandbox replicates before a scenario: the master is up, each slave runs both replication threads and applies a marker written on the master */
+func (c *Cluster) chaosHealthy() (string, error) {
+	c.refreshTopology(context.Background())
+	if c.master.State == STATE_FAILED {
+		return "", errors.New(fmt.Sprintf("master %s is down before the scenario", c.master.URL))
+	}
+	if len(c.slaves) == 0 {
+		return "", errors.New("the sandbox has no slave")
+	}
+	for _, s := range c.slaves {
+		if s.State == STATE_FAILED || s.IOThread != "Yes" || s.SQLThread != "Yes" {
+			return "", errors.New(fmt.Sprintf("slave %s does not replicate before the scenario: %s", s.URL, s.healthCheck()))
+		}
+	}
+	id, err := c.chaosWrite("health")
+	if err == nil {
+		err = chaosReplicated(id, c.slaves)
+	}
+	return "", err
+}
+
+/* Makes the slave that would be elected fall behind while both its replication threads run: a session of the slave locks the marker table, on which its SQL thread then waits, and the master writes markers until the slave is more than maxdelay seconds behind. Checks that the slave is reported, no longer elected, and catches up once the table is unlocked. */
+func (c *Cluster) chaosLagSlave() (string, error) {
+	victim := c.chaosVictim()
+	ctx := context.Background()
+	lock, err := victim.Conn.Conn(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer lock.Close()
+	_, err = lock.ExecContext(ctx, "LOCK TABLES "+chaosSchema+".markers WRITE")
+	if err != nil {
+		return "", err
+	}
+	var id int64
+	err = chaosWait(func() (bool, string) {
+		id, err = c.chaosWrite("lag-slave")
+		if err != nil {
+			return false, fmt.Sprintf("could not write on master %s: %s", c.master.URL, err)
+		}
+		victim.refresh()
+		return victim.SQLThread == "Yes" && victim.lag() > *maxDelay, fmt.Sprintf("slave %s did not fall more than %d seconds behind", victim.URL, *maxDelay)
+	})
+	if err != nil {
+		lock.ExecContext(ctx, "UNLOCK TABLES")
+		return "", err
+	}
+	c.refreshTopology(ctx)
+	issue := victim.healthCheck()
+	key := c.master.electCandidate(c.slaves)
+	_, err = lock.ExecContext(ctx, "UNLOCK TABLES")
+	if err != nil {
+		return "", err
+	}
+	if issue == "Running OK" {
+		return "", errors.New(fmt.Sprintf("slave %s lagging behind was reported healthy", victim.URL))
+	}
+	if key >= 0 && c.slaves[key] == victim {
+		return "", errors.New(fmt.Sprintf("slave %s lagging behind was elected", victim.URL))
+	}
+	err = chaosReplicated(id, c.slaves)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s reported (%s), not elected, caught up once unlocked", victim.URL, issue), nil
+}
+
+/* Makes the SQL thread of the slave that would be elected fail on a duplicate key, and checks that the error is reported and the slave no longer elected, then repairs it */
+func (c *Cluster) chaosBreakSlave() (string, error) {
+	victim := c.chaosVictim()
+	id, err := c.chaosWrite("break-slave")
+	if err != nil {
+		return "", err
+	}
+	// A row written on the slave only conflicts with the next write of the same key on the master
+	conflict := id + 1000000
+	err = victim.execLocal(fmt.Sprintf("INSERT INTO %s.markers (id, scenario, created) VALUES (%d, 'conflict', NOW())", chaosSchema, conflict))
+	if err == nil {
+		err = c.master.backend().Exec(fmt.Sprintf("INSERT INTO %s.markers (id, scenario, created) VALUES (%d, 'break-slave', NOW())", chaosSchema, conflict))
+	}
+	if err != nil {
+		return "", err
+	}
+	err = chaosWait(func() (bool, string) {
+		victim.refresh()
+		return victim.SQLThread == "No" && victim.SQLErrno != 0, fmt.Sprintf("SQL thread of slave %s did not stop", victim.URL)
+	})
+	if err != nil {
+		return "", err
+	}
+	c.refreshTopology(context.Background())
+	issue := victim.healthCheck()
+	key := c.master.electCandidate(c.slaves)
+	err = victim.execLocal(fmt.Sprintf("DELETE FROM %s.markers WHERE id = %d", chaosSchema, conflict))
+	if err == nil {
+		err = victim.exec("START SLAVE SQL_THREAD")
+	}
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("could not repair slave %s: %s", victim.URL, err))
+	}
+	if issue == "Running OK" {
+		return "", errors.New(fmt.Sprintf("slave %s with a broken SQL thread was reported healthy", victim.URL))
+	}
+	if key >= 0 && c.slaves[key] == victim {
+		return "", errors.New(fmt.Sprintf("slave %s with a broken SQL thread was elected", victim.URL))
+	}
+	err = chaosReplicated(conflict, c.slaves)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s reported (%s), not elected, repaired", victim.URL, issue), nil
+}
+
+/* Stops the master and checks that it is declared failed, that a slave is promoted and made writable, and that the other slaves replicate from it. The old master is then started and rejoined as a slave of the new one. */
+func (c *Cluster) chaosStopMaster() (string, error) {
+	old := c.master
+	err := chaosCommand(*testStop, old.Host)
+	if err != nil {
+		return "", err
+	}
+	err = chaosWait(func() (bool, string) {
+		c.refreshTopology(context.Background())
+		return c.master.State == STATE_FAILED, fmt.Sprintf("master %s was not declared failed", old.URL)
+	})
+	if err == nil {
+		err = c.chaosFailover()
+	}
+	if err == nil {
+		c.master.refresh()
+		if c.master.ReadOnly != "OFF" {
+			err = errors.New(fmt.Sprintf("new master %s is read-only", c.master.URL))
+		}
+	}
+	if err != nil {
+		// The old master is started again so that the next run finds the sandbox complete
+		chaosCommand(*testStart, old.Host)
+		return "", err
+	}
+	err = chaosWait(func() (bool, string) {
+		for _, s := range c.slaves {
+			s.refresh()
+			if s.MasterServerId != c.master.ServerId || s.IOThread != "Yes" || s.SQLThread != "Yes" {
+				return false, fmt.Sprintf("slave %s does not replicate from new master %s", s.URL, c.master.URL)
+			}
+		}
+		return true, ""
+	})
+	if err == nil {
+		var id int64
+		id, err = c.chaosWrite("stop-master")
+		if err == nil {
+			err = chaosReplicated(id, c.slaves)
+		}
+	}
+	if err != nil {
+		chaosCommand(*testStart, old.Host)
+		return "", err
+	}
+	err = chaosCommand(*testStart, old.Host)
+	if err == nil {
+		err = chaosWait(func() (bool, string) {
+			return old.reconnect() == nil, fmt.Sprintf("old master %s did not come back", old.URL)
+		})
+	}
+	if err == nil {
+		err = old.rejoin()
+	}
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("%s promoted, but old master %s could not rejoin: %s", c.master.URL, old.URL, err))
+	}
+	c.failedMasterURL = ""
+	c.saveState()
+	id, err := c.chaosWrite("stop-master")
+	if err == nil {
+		err = chaosReplicated(id, c.slaves)
+	}
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s promoted, %s rejoined as a slave", c.master.URL, old.URL), nil
+}
+
+/* Fails over the stopped master in force mode, whose election skips the switchover checks that a slave which lost its master fails. The failover mode option is restored however the failover ends. */
+func (c *Cluster) chaosFailover() error {
+	mode := *failover
+	defer func() { *failover = mode }()
+	*failover = "force"
+	_, err := c.Failover(context.Background())
+	return err
+}
+
+/* Returns the slave a switchover would elect, or the first slave */
+func (c *Cluster) chaosVictim() *ServerMonitor {
+	key := c.master.electCandidate(c.slaves)
+	if key < 0 {
+		key = 0
+	}
+	return c.slaves[key]
+}
+
+/* Writes a marker row on the master and returns its id, the highest of the scenario since the test command is the only writer of its schema */
+func (c *Cluster) chaosWrite(scenario string) (int64, error) {
+	for _, stmt := range []string{"CREATE DATABASE IF NOT EXISTS " + chaosSchema,
+		"CREATE TABLE IF NOT EXISTS " + chaosSchema + ".markers (id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY, scenario VARCHAR(64) NOT NULL, created DATETIME NOT NULL)",
+		"INSERT INTO " + chaosSchema + ".markers (scenario, created) VALUES ('" + scenario + "', NOW())"} {
+		err := c.master.backend().Exec(stmt)
+		if err != nil {
+			return 0, err
+		}
+	}
+	rows, err := c.master.query("SELECT MAX(id) AS id FROM "+chaosSchema+".markers WHERE scenario = ?", scenario)
+	if err != nil {
+		return 0, err
+	}
+	if len(rows) == 0 {
+		return 0, errors.New(fmt.Sprintf("marker of %s not found on master %s", scenario, c.master.URL))
+	}
+	return strconv.ParseInt(rows[0]["id"], 10, 64)
+}
+
+/* Waits until the slaves applied the marker row */
+func chaosReplicated(id int64, l []*ServerMonitor) error {
+	return chaosWait(func() (bool, string) {
+		for _, s := range l {
+			rows, err := s.query("SELECT COUNT(*) AS n FROM "+chaosSchema+".markers WHERE id = ?", id)
+			if err != nil || len(rows) == 0 || rows[0]["n"] == "0" {
+				return false, fmt.Sprintf("slave %s did not apply marker %d", s.URL, id)
+			}
+		}
+		return true, ""
+	})
+}
+
+/* Polls the condition every second until it holds, or the test timeout expires with the reason it returned */
+func chaosWait(cond func() (bool, string)) error {
+	deadline := time.Now().Add(time.Duration(*testTimeout) * time.Second)
+	for {
+		ok, reason := cond()
+		if ok {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New(fmt.Sprintf("%s after %d seconds", reason, *testTimeout))
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+/* Runs a stop or start command of the sandbox with the host as last argument */
+func chaosCommand(cmd string, host string) error {
+	log.Printf("INFO : Running %s %s", cmd, host)
+	out, err := exec.Command("sh", "-c", cmd+" "+host).CombinedOutput()
+	if err != nil {
+		return errors.New(fmt.Sprintf("%s %s: %s: %s", cmd, host, err, strings.TrimSpace(string(out))))
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+// chaos_test.go
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+)
+
+/* Master of a test whose writes of the conflicting marker stop the SQL thread of the slave on a duplicate key, the slave no longer reporting its delay */
+type simConflict struct {
+	*simServer
+	slave *simServer
+}
+
+func (s simConflict) Exec(stmt string) error {
+	err := s.simServer.Exec(stmt)
+	if err == nil && strings.HasPrefix(stmt, "INSERT INTO "+chaosSchema+".markers (id,") {
+		st := s.slave.status
+		st.Slave_SQL_Running, st.Last_SQL_Errno, st.Last_SQL_Error, st.Seconds_Behind_Master = "No", 1062, "Duplicate entry", sql.NullInt64{}
+	}
+	return err
+}
+
+/* Loads the default topology, whose master wrote marker 7 and whose slaves applied the markers */
+func simChaos(t *testing.T) map[string]*simServer {
+	sims := simCluster(t, simTopology())
+	current.master = current.findMaster(true)
+	current.master.State = STATE_MASTER
+	for url, s := range sims {
+		s.rows = map[string][]map[string]string{"SELECT COUNT(*) AS n FROM " + chaosSchema: {{"n": "1"}}}
+		if url == "db1:3306" {
+			s.rows = map[string][]map[string]string{"SELECT MAX(id) AS id FROM " + chaosSchema: {{"id": "7"}}}
+		}
+	}
+	return sims
+}
+
+func TestRunChaosChecks(t *testing.T) {
+	defer func(i, d bool, stop, start string) { *interactive, *dryRun, *testStop, *testStart = i, d, stop, start }(*interactive, *dryRun, *testStop, *testStart)
+	tests := []struct {
+		name        string
+		scenarios   []string
+		commands    bool // stop and start commands set
+		dryRun      bool
+		interactive bool
+		answer      string
+		err         string
+	}{
+		{"unknown scenario", []string{"lag-slave", "kill-slave"}, true, false, false, "", "Unknown test scenario kill-slave, expected lag-slave, break-slave, stop-master"},
+		{"all scenarios without commands", nil, false, false, false, "", "The stop-master scenario requires the test-stop-command and test-start-command options"},
+		{"dry run", []string{"break-slave"}, false, true, false, "", "The test command breaks the servers and cannot run in dry-run mode"},
+		{"cancelled", []string{"break-slave"}, false, false, true, "n\n", "Cancelled"},
+	}
+	for _, tt := range tests {
+		sims := simChaos(t)
+		*testStop, *testStart = "", ""
+		if tt.commands {
+			*testStop, *testStart = "true", "true"
+		}
+		*dryRun, *interactive = tt.dryRun, tt.interactive
+		simStdin(t, tt.answer)
+		var err error
+		simStdout(t, func() { err = current.runChaos(tt.scenarios) })
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%s: runChaos() = %v, want %q", tt.name, err, tt.err)
+		}
+		// Nothing is written before the scenarios are confirmed
+		if len(sims["db1:3306"].execs) != 0 {
+			t.Errorf("%s: master ran %q", tt.name, sims["db1:3306"].execs)
+		}
+	}
+}
+
+func TestChaosHealthy(t *testing.T) {
+	defer func(n int64) { *testTimeout = n }(*testTimeout)
+	*testTimeout = 0
+	tests := []struct {
+		name string
+		prep func(sims map[string]*simServer)
+		err  string
+	}{
+		{"healthy", func(sims map[string]*simServer) {}, ""},
+		{"master down", func(sims map[string]*simServer) {
+			sims["db1:3306"].down = true
+			*maxFail = 1
+		}, "master db1:3306 is down before the scenario"},
+		{"slave stopped", func(sims map[string]*simServer) {
+			sims["db2:3306"].status.Slave_SQL_Running = "No"
+		}, "slave db2:3306 does not replicate before the scenario"},
+		{"marker not written", func(sims map[string]*simServer) {
+			sims["db1:3306"].fail = "INSERT"
+		}, errSimFailed.Error()},
+		{"marker not applied", func(sims map[string]*simServer) {
+			sims["db3:3306"].rows = nil
+		}, "slave db3:3306 did not apply marker 7 after 0 seconds"},
+	}
+	for _, tt := range tests {
+		sims := simChaos(t)
+		tt.prep(sims)
+		_, err := current.chaosHealthy()
+		if (tt.err == "" && err != nil) || (tt.err != "" && (err == nil || strings.HasPrefix(err.Error(), tt.err) == false)) {
+			t.Errorf("%s: chaosHealthy() = %v, want %q", tt.name, err, tt.err)
+		}
+		if tt.err == "" && sims["db1:3306"].ran("INSERT INTO "+chaosSchema+".markers (scenario, created) VALUES ('health', NOW())") == false {
+			t.Errorf("%s: master ran %q, want the health marker", tt.name, sims["db1:3306"].execs)
+		}
+	}
+}
+
+func TestChaosBreakSlave(t *testing.T) {
+	defer func(i bool, n int64) { *interactive, *testTimeout = i, n }(*interactive, *testTimeout)
+	*interactive, *testTimeout = false, 0
+	sims := simChaos(t)
+	simServerByURL("db1:3306").db = simConflict{sims["db1:3306"], sims["db3:3306"]}
+	var err error
+	out := simStdout(t, func() { err = current.runChaos([]string{"break-slave"}) })
+	if err != nil {
+		t.Fatalf("runChaos() = %s:\n%s", err, out)
+	}
+	// The slave a switchover would elect is broken, reported and repaired
+	if strings.Contains(out, "PASS break-slave") == false || strings.Contains(out, "db3:3306 reported (") == false || strings.Contains(out, "not elected, repaired") == false {
+		t.Errorf("output %q, want db3:3306 broken and repaired", out)
+	}
+	for _, stmt := range []string{"INSERT INTO " + chaosSchema + ".markers (id, scenario, created) VALUES (1000007, 'conflict', NOW())", "DELETE FROM " + chaosSchema + ".markers WHERE id = 1000007", "START SLAVE SQL_THREAD"} {
+		if sims["db3:3306"].ran(stmt) == false {
+			t.Errorf("slave db3:3306 did not run %q", stmt)
+		}
+	}
+	if sims["db1:3306"].ran("INSERT INTO "+chaosSchema+".markers (id, scenario, created) VALUES (1000007, 'break-slave', NOW())") == false {
+		t.Errorf("master ran %q, want the conflicting marker", sims["db1:3306"].execs)
+	}
+	// A slave whose SQL thread does not stop fails the scenario
+	simChaos(t)
+	out = simStdout(t, func() { err = current.runChaos([]string{"break-slave"}) })
+	if err == nil || err.Error() != "1 of 1 test scenarios failed" || strings.Contains(out, "FAIL break-slave") == false || strings.Contains(out, "SQL thread of slave db3:3306 did not stop after 0 seconds") == false {
+		t.Errorf("runChaos() = %v:\n%s\nwant the scenario failed", err, out)
+	}
+}
+
+func TestChaosStopMaster(t *testing.T) {
+	defer func(i bool, stop, start string) { *interactive, *testStop, *testStart = i, stop, start }(*interactive, *testStop, *testStart)
+	// A stop command failing fails the scenario with its output
+	*interactive, *testStop, *testStart = false, "echo cannot stop; false", "true"
+	sims := simChaos(t)
+	var err error
+	out := simStdout(t, func() { err = current.runChaos([]string{"stop-master"}) })
+	if err == nil || strings.Contains(out, "FAIL stop-master") == false || strings.Contains(out, "echo cannot stop; false db1: exit status 1: cannot stop") == false {
+		t.Errorf("runChaos() = %v:\n%s\nwant the stop command failed", err, out)
+	}
+	if current.master.URL != "db1:3306" || len(sims["db3:3306"].execs) != 0 {
+		t.Errorf("master %s, db3:3306 ran %q, want no failover", current.master.URL, sims["db3:3306"].execs)
+	}
+}
+
+func TestChaosFailover(t *testing.T) {
+	defer func(f, s string) { *failover, *stateFile = f, s }(*failover, *stateFile)
+	*failover, *stateFile = "monitor", ""
+	simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 1 }))
+	current.master = current.findMaster(false)
+	current.master.State = STATE_FAILED
+	if current.chaosVictim().URL != "db3:3306" {
+		t.Errorf("chaosVictim() = %s, want db3:3306", current.chaosVictim().URL)
+	}
+	if err := current.chaosFailover(); err != nil || current.master.URL != "db3:3306" {
+		t.Errorf("chaosFailover() = %v, master %s, want db3:3306", err, current.master.URL)
+	}
+	// The failover mode is restored
+	if *failover != "monitor" {
+		t.Errorf("failover mode %s after the test failover, want monitor", *failover)
+	}
+}
+
+func TestChaosWait(t *testing.T) {
+	defer func(n int64) { *testTimeout = n }(*testTimeout)
+	*testTimeout = 0
+	checks := 0
+	err := chaosWait(func() (bool, string) {
+		checks++
+		return false, "slave db2:3306 did not stop"
+	})
+	if err == nil || err.Error() != "slave db2:3306 did not stop after 0 seconds" || checks != 1 {
+		t.Errorf("chaosWait() = %v after %d checks, want the reason after one check", err, checks)
+	}
+	// The condition is polled until it holds
+	*testTimeout, checks = 2, 0
+	err = chaosWait(func() (bool, string) {
+		checks++
+		return checks == 2, "not yet"
+	})
+	if err != nil || checks != 2 {
+		t.Errorf("chaosWait() = %v after %d checks, want success after two checks", err, checks)
+	}
+}
+
+func TestChaosCommand(t *testing.T) {
+	tests := []struct {
+		cmd string
+		err error
+	}{
+		{"echo stopping", nil},
+		{"echo cannot stop; false", errors.New("echo cannot stop; false db1: exit status 1: cannot stop")},
+	}
+	for _, tt := range tests {
+		err := chaosCommand(tt.cmd, "db1")
+		if (err == nil) != (tt.err == nil) || (err != nil && err.Error() != tt.err.Error()) {
+			t.Errorf("chaosCommand(%q) = %v, want %v", tt.cmd, err, tt.err)
+		}
+	}
+}
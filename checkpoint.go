@@ -0,0 +1,204 @@
+// checkpoint.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+/* Steps of a failover or switchover, in order. Until the new master is promoted an interrupted operation is rolled back, afterwards it is carried forward. */
+const (
+	STEP_ELECTED   = "elected"   // the candidate is elected, nothing changed on the servers
+	STEP_FROZEN    = "frozen"    // switchover only, the old master starts rejecting writes
+	STEP_PROMOTED  = "promoted"  // the new master stopped replicating, the point of no return
+	STEP_DEMOTED   = "demoted"   // switchover only, the old master replicates from the new master
+	STEP_COMPLETED = "completed" // every slave was handled
+)
+
+/* Progress of the failover or switchover in progress, persisted in the state file at each step so that an operation interrupted by a crash of the manager is resumed on the next start */
+type Checkpoint struct {
+	Type          string
+	OldMaster     string
+	NewMaster     string
+	Step          string
+	Time          time.Time
+	Repointed     []string // slaves already replicating from the new master
+	Scheduler     string   // event scheduler of the old master before the switchover
+	StoppedEvents []string
+	BlockedUsers  []string
+}
+
+/* Starts the checkpoints of an operation once its candidate is elected. The returned function clears them when the operation ends, whatever its result; a crash leaves them in the state file. */
+func (c *Cluster) beginCheckpoint(kind string, oldMaster *ServerMonitor, newMaster *ServerMonitor) func() {
+	if *dryRun {
+		return func() {}
+	}
+	c.stateData.Operation = &Checkpoint{Type: kind, OldMaster: oldMaster.URL, NewMaster: newMaster.URL, Scheduler: oldMaster.EventScheduler}
+	c.checkpoint(STEP_ELECTED)
+	return func() {
+		c.stateData.Operation = nil
+		c.saveState()
+	}
+}
+
+/* Records that the operation in progress reached a step */
+func (c *Cluster) checkpoint(step string) {
+	cp := c.stateData.Operation
+	if cp == nil || *dryRun {
+		return
+	}
+	cp.Step, cp.Time = step, time.Now()
+	c.saveState()
+}
+
+/* Records the events and accounts of the old master disabled so far, each time one is, so that the switchover interrupted while freezing it restores them */
+func (c *Cluster) checkpointFrozen() {
+	cp := c.stateData.Operation
+	if cp == nil || *dryRun || cp.promoted() {
+		return
+	}
+	cp.StoppedEvents, cp.BlockedUsers = append([]string(nil), c.stoppedEvents...), append([]string(nil), c.blockedUsers...)
+	c.saveState()
+}
+
+/* Records that a slave replicates from the new master */
+func (c *Cluster) checkpointSlave(url string) {
+	cp := c.stateData.Operation
+	if cp == nil || *dryRun {
+		return
+	}
+	cp.Repointed = append(cp.Repointed, url)
+	c.saveState()
+}
+
+/* True once the operation passed its point of no return */
+func (cp *Checkpoint) promoted() bool {
+	return cp.Step != STEP_ELECTED && cp.Step != STEP_FROZEN
+}
+
+/* Finishes the operation interrupted at the last checkpoint of the state file, on the connected servers, before the master is detected: it is rolled back before the promotion of the new master and carried forward after it. The checkpoint is cleared unless the servers needed are unreachable. */
+func (c *Cluster) resumeOperation() error {
+	cp := c.stateData.Operation
+	if cp == nil {
+		return nil
+	}
+	logprintf("WARN : %s of master %s to %s was interrupted at step %s on %s", cp.Type, cp.OldMaster, cp.NewMaster, cp.Step, cp.Time.Format("2006-01-02 15:04:05"))
+	if *dryRun {
+		logprint("INFO : Dry run, the interrupted operation is left as is")
+		return nil
+	}
+	var err error
+	if cp.promoted() {
+		err = cp.rollForward(c.connectedServer(cp.NewMaster))
+	} else {
+		err = cp.rollBack(c.connectedServer(cp.OldMaster))
+	}
+	if err != nil {
+		return err
+	}
+	audit("Interrupted %s of master %s to %s resumed from step %s", cp.Type, cp.OldMaster, cp.NewMaster, cp.Step)
+	c.stateData.Operation = nil
+	c.saveState()
+	return nil
+}
+
+/* Restores the old master of a switchover interrupted before the promotion. A failover changed nothing on the servers yet. */
+func (cp *Checkpoint) rollBack(oldMaster *ServerMonitor) error {
+	if cp.Type != "switchover" || cp.Step != STEP_FROZEN {
+		logprintf("INFO : The interrupted %s did not change the servers, it is abandoned", cp.Type)
+		return nil
+	}
+	if oldMaster == nil {
+		return errors.New(fmt.Sprintf("Old master %s of the interrupted switchover is unreachable, it cannot be made writable again", cp.OldMaster))
+	}
+	logprintf("INFO : Rolling back the interrupted switchover on %s", oldMaster.URL)
+	// The write lock was released with the connection of the manager, the rest of the freeze is undone
+	oldMaster.EventScheduler = cp.Scheduler
+	oldMaster.cluster.stoppedEvents, oldMaster.cluster.blockedUsers = cp.StoppedEvents, cp.BlockedUsers
+	oldMaster.unfreeze()
+	return nil
+}
+
+/* Completes a failover or switchover interrupted after the promotion: the new master is made writable and runs the scheduled jobs, the accounts of the old master locked by a switchover are unlocked, and the old master, for a switchover, and the slaves not repointed yet replicate from the new master */
+func (cp *Checkpoint) rollForward(newMaster *ServerMonitor) error {
+	if newMaster == nil {
+		return errors.New(fmt.Sprintf("New master %s of the interrupted %s is unreachable, it cannot be completed", cp.NewMaster, cp.Type))
+	}
+	c := newMaster.cluster
+	logprintf("INFO : Completing the interrupted %s, %s is the new master", cp.Type, newMaster.URL)
+	if cp.Step == STEP_PROMOTED {
+		err := newMaster.run("RESET SLAVE ALL", resetSlave(true))
+		if err != nil {
+			logprintf("WARN : Reset slave failed on new master: %s", err)
+		}
+		err = newMaster.run("SET GLOBAL read_only=0", setReadOnly(false))
+		if err != nil {
+			return errors.New(fmt.Sprintf("Could not set new master %s as read-write: %s", newMaster.URL, err))
+		}
+		newMaster.startEvents(&ServerMonitor{URL: cp.OldMaster, EventScheduler: cp.Scheduler, cluster: c}, logprintf)
+		if cp.Type == "switchover" {
+			cp.unblock(c)
+		}
+		if c.vip != nil {
+			err = c.vip.Add(newMaster.Host)
+			if err != nil {
+				logprintf("ERROR: Could not add virtual IP to new master: %s", err)
+			}
+		}
+		c.dnsMove(newMaster, logprintf)
+	}
+	c.master = newMaster
+	if cp.Type == "failover" {
+		c.failedMasterURL = cp.OldMaster
+	}
+	if cp.Step == STEP_COMPLETED {
+		return nil
+	}
+	for _, s := range c.servers {
+		if s.State == STATE_FAILED || s.URL == newMaster.URL || contains(cp.Repointed, s.URL) {
+			continue
+		}
+		// The failed master rejoins when it is back, the old master of a switchover may already be demoted
+		if s.URL == cp.OldMaster && (cp.Type == "failover" || cp.Step != STEP_PROMOTED) {
+			continue
+		}
+		// Without GTID the position of the new master when the slaves were stopped is lost
+		if s.UsingGtid == "No" {
+			logprintf("ERROR: Slave %s replicates without GTID and must be repointed to %s manually", s.URL, newMaster.URL)
+			continue
+		}
+		err := s.rejoin()
+		if err != nil {
+			logprintf("ERROR: Could not repoint %s to the new master: %s", s.URL, err)
+			continue
+		}
+		cp.Repointed = append(cp.Repointed, s.URL)
+	}
+	return nil
+}
+
+/* Restores the accounts and events of the old master frozen by the switchover, and unlocks the accounts the way the demotion does. The events stay disabled on the old master, which becomes a slave. */
+func (cp *Checkpoint) unblock(c *Cluster) {
+	c.stoppedEvents, c.blockedUsers = cp.StoppedEvents, cp.BlockedUsers
+	oldMaster := c.connectedServer(cp.OldMaster)
+	if oldMaster == nil {
+		if len(c.blockedUsers) > 0 {
+			logprintf("ERROR: Old master %s is unreachable, accounts %s must be unlocked on it manually", cp.OldMaster, strings.Join(c.blockedUsers, ", "))
+		}
+		c.blockedUsers = nil
+		return
+	}
+	oldMaster.unblockUsers()
+}
+
+/* Returns the server of the topology with the URL if the manager is connected to it */
+func (c *Cluster) connectedServer(url string) *ServerMonitor {
+	for _, s := range c.servers {
+		if s.URL == url && s.State != STATE_FAILED {
+			return s
+		}
+	}
+	return nil
+}
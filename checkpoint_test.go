@@ -0,0 +1,152 @@
+// checkpoint_test.go
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestResumeOperation(t *testing.T) {
+	oldDown := simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 1 })
+	tests := []struct {
+		name   string
+		specs  []simSpec
+		cp     Checkpoint
+		master string              // master once resumed, empty if not set
+		ran    map[string][]string // statements run, by server
+		notRan map[string][]string // statements not run, by server, an empty one standing for any
+	}{
+		{"switchover elected", simTopology(), Checkpoint{Type: "switchover", Step: STEP_ELECTED}, "",
+			nil, map[string][]string{"db1:3306": {""}, "db2:3306": {""}, "db3:3306": {""}}},
+		{"switchover frozen", simTopology(), Checkpoint{Type: "switchover", Step: STEP_FROZEN}, "",
+			map[string][]string{"db1:3306": {"ALTER USER 'app'@'%' ACCOUNT UNLOCK", "SET GLOBAL read_only=0", "ALTER EVENT app.purge ENABLE"}},
+			map[string][]string{"db2:3306": {""}, "db3:3306": {""}}},
+		{"switchover promoted", simTopology(), Checkpoint{Type: "switchover", Step: STEP_PROMOTED}, "db2:3306",
+			map[string][]string{"db1:3306": {"ALTER USER 'app'@'%' ACCOUNT UNLOCK", "CHANGE MASTER TO master_host='db2'"},
+				"db2:3306": {"RESET SLAVE ALL", "SET GLOBAL read_only=0"}, "db3:3306": {"CHANGE MASTER TO master_host='db2'"}},
+			map[string][]string{"db1:3306": {"ALTER EVENT"}}},
+		{"switchover demoted", simTopology(), Checkpoint{Type: "switchover", Step: STEP_DEMOTED}, "db2:3306",
+			map[string][]string{"db3:3306": {"CHANGE MASTER TO master_host='db2'"}},
+			map[string][]string{"db1:3306": {""}, "db2:3306": {""}}},
+		{"switchover demoted with slave repointed", simTopology(), Checkpoint{Type: "switchover", Step: STEP_DEMOTED, Repointed: []string{"db3:3306"}}, "db2:3306",
+			nil, map[string][]string{"db1:3306": {""}, "db2:3306": {""}, "db3:3306": {""}}},
+		{"switchover completed", simTopology(), Checkpoint{Type: "switchover", Step: STEP_COMPLETED}, "db2:3306",
+			nil, map[string][]string{"db1:3306": {""}, "db2:3306": {""}, "db3:3306": {""}}},
+		{"failover elected", oldDown, Checkpoint{Type: "failover", Step: STEP_ELECTED}, "",
+			nil, map[string][]string{"db2:3306": {""}, "db3:3306": {""}}},
+		{"failover promoted", oldDown, Checkpoint{Type: "failover", Step: STEP_PROMOTED}, "db2:3306",
+			map[string][]string{"db2:3306": {"RESET SLAVE ALL", "SET GLOBAL read_only=0"}, "db3:3306": {"CHANGE MASTER TO master_host='db2'"}},
+			map[string][]string{"db1:3306": {""}}},
+		{"failover completed", oldDown, Checkpoint{Type: "failover", Step: STEP_COMPLETED}, "db2:3306",
+			nil, map[string][]string{"db2:3306": {""}, "db3:3306": {""}}},
+	}
+	for _, tt := range tests {
+		sims := simCluster(t, tt.specs)
+		cp := tt.cp
+		cp.OldMaster, cp.NewMaster = "db1:3306", "db2:3306"
+		cp.BlockedUsers, cp.StoppedEvents = []string{"'app'@'%'"}, []string{"app.purge"}
+		current.stateData.Operation = &cp
+		err := current.resumeOperation()
+		if err != nil {
+			t.Errorf("%s: resumeOperation() = %s", tt.name, err)
+			continue
+		}
+		if current.stateData.Operation != nil {
+			t.Errorf("%s: checkpoint not cleared", tt.name)
+		}
+		got := ""
+		if current.master != nil {
+			got = current.master.URL
+		}
+		if got != tt.master {
+			t.Errorf("%s: master = %q, want %q", tt.name, got, tt.master)
+		}
+		for url, stmts := range tt.ran {
+			for _, stmt := range stmts {
+				if sims[url].ran(stmt) == false {
+					t.Errorf("%s: %s not run on %s, ran %q", tt.name, stmt, url, sims[url].execs)
+				}
+			}
+		}
+		for url, stmts := range tt.notRan {
+			for _, stmt := range stmts {
+				if sims[url].ran(stmt) {
+					t.Errorf("%s: %q run on %s, ran %q", tt.name, stmt, url, sims[url].execs)
+				}
+			}
+		}
+		if tt.cp.Type == "failover" && tt.master != "" && current.failedMasterURL != "db1:3306" {
+			t.Errorf("%s: failed master = %q, want db1:3306", tt.name, current.failedMasterURL)
+		}
+	}
+}
+
+func TestResumeOperationUnreachable(t *testing.T) {
+	tests := []struct {
+		name string
+		down uint
+		step string
+	}{
+		{"old master down before the promotion", 1, STEP_FROZEN},
+		{"new master down after the promotion", 2, STEP_PROMOTED},
+	}
+	for _, tt := range tests {
+		simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == tt.down }))
+		current.stateData.Operation = &Checkpoint{Type: "switchover", OldMaster: "db1:3306", NewMaster: "db2:3306", Step: tt.step}
+		if current.resumeOperation() == nil {
+			t.Errorf("%s: resumeOperation() succeeded", tt.name)
+		}
+		if current.stateData.Operation == nil {
+			t.Errorf("%s: checkpoint cleared", tt.name)
+		}
+	}
+}
+
+/* Old master of a test saving the checkpoint of the switchover when it is set read-only, as a crash at that point would leave it */
+type simCrash struct {
+	*simServer
+	saved *Checkpoint
+}
+
+func (s *simCrash) Exec(stmt string) error {
+	if stmt == "SET GLOBAL read_only=1" && s.saved == nil {
+		cp := *current.stateData.Operation
+		s.saved = &cp
+	}
+	return s.simServer.Exec(stmt)
+}
+
+func TestResumeFreeze(t *testing.T) {
+	defer func(f, s, sw, bu string, m bool) {
+		*failover, *stateFile, *switchover, *swBlockUsers, *migrateEvents = f, s, sw, bu, m
+	}(*failover, *stateFile, *switchover, *swBlockUsers, *migrateEvents)
+	*failover, *stateFile, *switchover, *swBlockUsers, *migrateEvents = "force", "", "kill", "app", true
+	sims := simCluster(t, simTopology())
+	sims["db1:3306"].rows = map[string][]map[string]string{
+		"SELECT Host FROM mysql.user": {{"Host": "%"}},
+		"SELECT EVENT_SCHEMA":         {{"EVENT_SCHEMA": "app", "EVENT_NAME": "purge"}},
+	}
+	current.master = current.findMaster(true)
+	crash := &simCrash{simServer: sims["db1:3306"]}
+	current.master.db = crash
+	current.Switchover(context.Background())
+	// The events and accounts disabled before the crash are in the state file with the step
+	cp := crash.saved
+	if cp == nil || cp.Step != STEP_FROZEN || len(cp.StoppedEvents) != 1 || len(cp.BlockedUsers) != 1 {
+		t.Fatalf("checkpoint %+v when the old master was set read-only, want the frozen step with its event and account", cp)
+	}
+	sims = simCluster(t, simTopology())
+	sims["db1:3306"].vars["READ_ONLY"] = "ON"
+	current.stateData.Operation = cp
+	if err := current.resumeOperation(); err != nil {
+		t.Fatalf("resumeOperation() = %s", err)
+	}
+	for _, stmt := range []string{"ALTER USER 'app'@'%' ACCOUNT UNLOCK", "SET GLOBAL read_only=0", "ALTER EVENT `app`.`purge` ENABLE"} {
+		if sims["db1:3306"].ran(stmt) == false {
+			t.Errorf("%q not run on the old master, ran %q", stmt, sims["db1:3306"].execs)
+		}
+	}
+	if sims["db1:3306"].vars["READ_ONLY"] != "OFF" {
+		t.Error("old master left read-only")
+	}
+}
@@ -0,0 +1,279 @@
+// client.go
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* Commands run against the HTTP API of a running daemon instead of connecting to the servers, so that operators do not start processes competing with the daemon */
+var clientCommands = []string{"status", "switchover", "failover", "abort", "maintenance", "panic"}
+
+/* Connection of a client command to the daemon */
+type daemonClient struct {
+	base    string
+	cluster string
+	token   string
+	basic   string
+	client  *http.Client
+}
+
+/* Runs a client command, args being the command followed by its options and arguments */
+func runClient(args []string) error {
+	fs := flag.NewFlagSet(args[0], flag.ExitOnError)
+	addr := fs.String("daemon", os.Getenv("REPMGR_DAEMON"), "URL of the HTTP API of the daemon, e.g. https://db-manager:10001 (defaults to REPMGR_DAEMON)")
+	cluster := fs.String("cluster", *clusterSel, "Name of the cluster to operate on when the daemon monitors several ones")
+	token := fs.String("token", os.Getenv("REPMGR_TOKEN"), "Bearer token presented to the daemon, possibly referencing a secret like the user option (defaults to REPMGR_TOKEN)")
+	basic := fs.String("api-user", os.Getenv("REPMGR_API_USER"), "User and password presented to the daemon, in user:password format (defaults to REPMGR_API_USER)")
+	ca := fs.String("ca", "", "Path of the CA certificate the daemon certificate must be signed by, the system ones if empty")
+	yes := fs.Bool("yes", false, "Do not ask for confirmation")
+	asJSON := fs.Bool("json", false, "Print the responses of the daemon in JSON")
+	duration := fs.Int64("duration", 0, "Seconds the panic command suspends automatic actions for, the panic-duration of the daemon if 0")
+	fs.Parse(args[1:])
+	if *addr == "" && *httpAddr != "" {
+		*addr = "http://" + *httpAddr
+	}
+	if *addr == "" {
+		return errors.New("No daemon address, set the daemon option or REPMGR_DAEMON")
+	}
+	if strings.Contains(*addr, "://") == false {
+		*addr = "http://" + *addr
+	}
+	dc := &daemonClient{base: strings.TrimSuffix(*addr, "/"), cluster: *cluster, client: &http.Client{Timeout: 10 * time.Second}}
+	var err error
+	if *token != "" {
+		dc.token, err = resolveSecret(*token)
+		if err != nil {
+			return err
+		}
+	}
+	if *basic != "" {
+		dc.basic, err = resolveCredentials(*basic)
+		if err != nil {
+			return err
+		}
+	}
+	if *ca != "" {
+		pem, err := ioutil.ReadFile(*ca)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if pool.AppendCertsFromPEM(pem) == false {
+			return errors.New("no certificate found in " + *ca)
+		}
+		dc.client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	}
+	switch args[0] {
+	case "status":
+		return dc.status(*asJSON)
+	case "switchover", "failover":
+		return dc.action(args[0], *yes, *asJSON)
+	case "abort":
+		res := make(map[string]bool)
+		err = dc.call("POST", "/api/abort", nil, &res)
+		if err != nil || *asJSON == false {
+			return err
+		}
+		return json.NewEncoder(os.Stdout).Encode(res)
+	case "maintenance":
+		if fs.NArg() != 2 || (fs.Arg(1) != "on" && fs.Arg(1) != "off") {
+			return errors.New("Usage: maintenance [options] <host:port> on|off")
+		}
+		return dc.maintenance(fs.Arg(0), fs.Arg(1) == "on", *asJSON)
+	case "panic":
+		if fs.NArg() != 1 || (fs.Arg(0) != "on" && fs.Arg(0) != "off") || *duration < 0 {
+			return errors.New("Usage: panic [options] on|off")
+		}
+		return dc.panicButton(fs.Arg(0) == "on", *duration, *asJSON)
+	}
+	return nil
+}
+
+/* Sends a request to the daemon on the selected cluster and decodes its JSON response into v */
+func (dc *daemonClient) call(method string, path string, params url.Values, v interface{}) error {
+	if params == nil {
+		params = url.Values{}
+	}
+	if dc.cluster != "" {
+		params.Set("cluster", dc.cluster)
+	}
+	u := dc.base + path
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(csrfHeader, "client")
+	if dc.token != "" {
+		req.Header.Set("Authorization", "Bearer "+dc.token)
+	} else if dc.basic != "" {
+		user, pass := splitCredentials(dc.basic)
+		req.SetBasicAuth(user, pass)
+	}
+	resp, err := dc.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return errors.New(fmt.Sprintf("%s: %s", resp.Status, strings.TrimSpace(string(body))))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+/* Prints the health of the clusters of the daemon, and the servers of the selected cluster or of the only one */
+func (dc *daemonClient) status(asJSON bool) error {
+	var health []ClusterHealth
+	if dc.cluster == "" {
+		err := dc.call("GET", "/api/clusters", nil, &health)
+		if err != nil {
+			return err
+		}
+		if len(health) > 1 {
+			if asJSON {
+				return json.NewEncoder(os.Stdout).Encode(health)
+			}
+			for _, h := range health {
+				printHealth(h)
+			}
+			return nil
+		}
+	}
+	var r Report
+	err := dc.call("GET", "/api/status", nil, &r)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		return json.NewEncoder(os.Stdout).Encode(r)
+	}
+	printHealth(r.Health)
+	fmt.Printf("\n%-25s %-12s %-7s %-25s %s\n", "Server", "State", "Delay", "GTID", "IO/SQL")
+	for _, s := range r.Servers {
+		delay := "-"
+		if s.Delay != nil {
+			delay = fmt.Sprintf("%ds", *s.Delay)
+		}
+		gtid := s.SlaveGtid
+		if s.URL == r.Master || gtid == "" {
+			gtid = s.CurrentGtid
+		}
+		threads := ""
+		if s.URL != r.Master && s.IOThread != "" {
+			threads = s.IOThread + "/" + s.SQLThread
+		}
+		fmt.Printf("%-25s %-12s %-7s %-25s %s\n", s.URL, s.State, delay, gtid, threads)
+		for _, e := range []string{s.IOError, s.SQLError, s.ReadOnlyIssue} {
+			if e != "" {
+				fmt.Printf("  %s\n", e)
+			}
+		}
+	}
+	return nil
+}
+
+func printHealth(h ClusterHealth) {
+	master := h.Master
+	if master == "" {
+		master = "down"
+	}
+	fmt.Printf("Cluster %s: master %s, %d slaves, %d candidates, health %d\n", h.Name, master, h.Slaves, h.Candidates, h.Score)
+	for _, i := range h.Issues {
+		fmt.Printf("  %s\n", i)
+	}
+}
+
+/* Asks the daemon to switch over or fail over the master it currently sees, after confirmation */
+func (dc *daemonClient) action(kind string, yes bool, asJSON bool) error {
+	var r Report
+	err := dc.call("GET", "/api/status", nil, &r)
+	if err != nil {
+		return err
+	}
+	if r.Master == "" {
+		return errors.New("The daemon sees no master")
+	}
+	if yes == false {
+		fmt.Printf("Run a %s of master %s on cluster %s? [y/N] ", kind, r.Master, r.Health.Name)
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if strings.ToLower(strings.TrimSpace(answer)) != "y" {
+			return errors.New("Cancelled")
+		}
+	}
+	// The daemon answers when the switchover or failover is complete
+	dc.client.Timeout = 10 * time.Minute
+	res := make(map[string]string)
+	err = dc.call("POST", "/api/"+kind, url.Values{"confirm": {r.Master}}, &res)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		return json.NewEncoder(os.Stdout).Encode(res)
+	}
+	fmt.Printf("Master is now %s\n", res["master"])
+	return nil
+}
+
+/* Puts a server in maintenance or takes it out */
+func (dc *daemonClient) maintenance(server string, on bool, asJSON bool) error {
+	method := "DELETE"
+	if on {
+		method = "POST"
+	}
+	res := make(map[string]bool)
+	err := dc.call(method, "/api/servers/"+server+"/maintenance", nil, &res)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		return json.NewEncoder(os.Stdout).Encode(res)
+	}
+	if res["Maintenance"] {
+		fmt.Printf("Server %s is in maintenance\n", server)
+	} else {
+		fmt.Printf("Server %s is not in maintenance\n", server)
+	}
+	return nil
+}
+
+/* Suspends the automatic actions of the daemon for duration seconds, its default if 0, or resumes them */
+func (dc *daemonClient) panicButton(on bool, duration int64, asJSON bool) error {
+	method := "DELETE"
+	params := url.Values{}
+	if on {
+		method = "POST"
+		if duration > 0 {
+			params.Set("duration", strconv.FormatInt(duration, 10))
+		}
+	}
+	var res panicState
+	err := dc.call(method, "/api/panic", params, &res)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		return json.NewEncoder(os.Stdout).Encode(res)
+	}
+	if res.Suspended {
+		fmt.Printf("Automatic actions suspended until %s\n", res.Until.Format("15:04:05"))
+	} else {
+		fmt.Println("Automatic actions are not suspended")
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+// client_test.go
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+/* Serves the HTTP API of the simulated cluster, named default, with the switchover and failover routes */
+func simDaemon(t *testing.T) string {
+	defer func(c bool) { *httpControl = c }(*httpControl)
+	*httpControl = true
+	current.Name = "default"
+	clusters = []*Cluster{current}
+	srv := httptest.NewServer(apiHandler())
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+/* Answers the next confirmation asked on stdin */
+func simStdin(t *testing.T, answer string) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteString(answer)
+	w.Close()
+	stdin := os.Stdin
+	os.Stdin = r
+	t.Cleanup(func() { os.Stdin = stdin })
+}
+
+func TestClientStatus(t *testing.T) {
+	defer func(l []*Cluster) { clusters = l }(clusters)
+	simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.stopped = sp.id == 2 }))
+	current.master = current.findMaster(true)
+	current.master.State = STATE_MASTER
+	addr := simDaemon(t)
+	var err error
+	out := simStdout(t, func() { err = runClient([]string{"status", "-daemon", addr}) })
+	if err != nil {
+		t.Fatalf("status = %s", err)
+	}
+	for _, want := range []string{"Cluster default: master db1:3306, 2 slaves", "db2:3306", "No/No", "db3:3306", "Yes/Yes"} {
+		if strings.Contains(out, want) == false {
+			t.Errorf("status output does not contain %q:\n%s", want, out)
+		}
+	}
+	out = simStdout(t, func() { err = runClient([]string{"status", "-daemon", strings.TrimPrefix(addr, "http://"), "-json"}) })
+	if err != nil || strings.HasPrefix(out, `{`) == false || strings.Contains(out, `"Master":"db1:3306"`) == false {
+		t.Errorf("status in JSON = %v:\n%s", err, out)
+	}
+	err = runClient([]string{"status", "-daemon", addr, "-cluster", "prod"})
+	if err == nil || err.Error() != "404 Not Found: Unknown cluster prod" {
+		t.Errorf("status of an unknown cluster = %v, want 404", err)
+	}
+}
+
+func TestClientDaemonAddress(t *testing.T) {
+	defer func(a string) { *httpAddr = a }(*httpAddr)
+	defer func(d string, ok bool) {
+		if ok {
+			os.Setenv("REPMGR_DAEMON", d)
+		}
+	}(os.LookupEnv("REPMGR_DAEMON"))
+	os.Unsetenv("REPMGR_DAEMON")
+	*httpAddr = ""
+	err := runClient([]string{"status"})
+	if err == nil || err.Error() != "No daemon address, set the daemon option or REPMGR_DAEMON" {
+		t.Errorf("status without daemon = %v, want the missing address", err)
+	}
+}
+
+func TestClientActions(t *testing.T) {
+	defer func(l []*Cluster, f, s string) { clusters, *failover, *stateFile = l, f, s }(clusters, *failover, *stateFile)
+	*failover, *stateFile = "force", ""
+	tests := []struct {
+		name   string
+		args   []string
+		answer string // to the confirmation
+		err    string
+		out    string
+		master string // master after the command
+	}{
+		{"switchover", []string{"switchover", "-yes"}, "", "", "Master is now db3:3306\n", "db3:3306"},
+		{"confirmed", []string{"switchover"}, "y\n", "", "Master is now db3:3306\n", "db3:3306"},
+		{"cancelled", []string{"switchover"}, "n\n", "Cancelled", "", "db1:3306"},
+		{"failover of a running master", []string{"failover", "-yes"}, "", "409 Conflict: The master is not failed, switch over instead", "", "db1:3306"},
+		{"switchover in JSON", []string{"switchover", "-yes", "-json"}, "", "", `{"master":"db3:3306"}` + "\n", "db3:3306"},
+	}
+	for _, tt := range tests {
+		simCluster(t, simTopology())
+		current.master = current.findMaster(true)
+		current.master.State = STATE_MASTER
+		addr := simDaemon(t)
+		simStdin(t, tt.answer)
+		var err error
+		out := simStdout(t, func() { err = runClient(append(tt.args, "-daemon", addr)) })
+		if (err == nil && tt.err != "") || (err != nil && err.Error() != tt.err) {
+			t.Errorf("%s: %v, want %q", tt.name, err, tt.err)
+		}
+		if tt.out != "" && strings.HasSuffix(out, tt.out) == false {
+			t.Errorf("%s: output %q, want %q", tt.name, out, tt.out)
+		}
+		if current.master.URL != tt.master {
+			t.Errorf("%s: master %s, want %s", tt.name, current.master.URL, tt.master)
+		}
+	}
+}
+
+func TestClientMaintenance(t *testing.T) {
+	defer func(l []*Cluster) { clusters = l }(clusters)
+	simCluster(t, simTopology())
+	current.master = current.findMaster(true)
+	addr := simDaemon(t)
+	var err error
+	out := simStdout(t, func() { err = runClient([]string{"maintenance", "-daemon", addr, "db2:3306", "on"}) })
+	if err != nil || out != "Server db2:3306 is in maintenance\n" || simServerByURL("db2:3306").inMaintenance() == false {
+		t.Errorf("maintenance on = %v %q, want db2:3306 in maintenance", err, out)
+	}
+	out = simStdout(t, func() { err = runClient([]string{"maintenance", "-daemon", addr, "db2:3306", "off"}) })
+	if err != nil || out != "Server db2:3306 is not in maintenance\n" || simServerByURL("db2:3306").inMaintenance() {
+		t.Errorf("maintenance off = %v %q, want db2:3306 out of maintenance", err, out)
+	}
+	err = runClient([]string{"maintenance", "-daemon", addr, "db2:3306"})
+	if err == nil || strings.HasPrefix(err.Error(), "Usage: maintenance") == false {
+		t.Errorf("maintenance without state = %v, want the usage", err)
+	}
+}
+
+func TestClientCredentials(t *testing.T) {
+	defer func(l []*Cluster) { clusters = l }(clusters)
+	defer func() { apiTokens, apiUsers = map[string]apiClient{}, map[string]apiClient{} }()
+	defer os.Unsetenv("SIM_REPMGR_TOKEN")
+	simCluster(t, simTopology())
+	current.master = current.findMaster(true)
+	apiTokens = map[string]apiClient{"t0ken": {Name: "operator", Role: ROLE_OPERATOR}}
+	apiUsers = map[string]apiClient{"ro:pass": {Name: "ro", Role: ROLE_VIEWER}}
+	addr := simDaemon(t)
+	os.Setenv("SIM_REPMGR_TOKEN", "t0ken")
+	tests := []struct {
+		name string
+		args []string
+		err  string
+	}{
+		{"anonymous", []string{"maintenance", "db2:3306", "on"}, "401 Unauthorized: Unauthorized"},
+		{"token secret", []string{"maintenance", "-token", "env:SIM_REPMGR_TOKEN", "db2:3306", "on"}, ""},
+		{"unset token secret", []string{"maintenance", "-token", "env:SIM_REPMGR_UNSET", "db2:3306", "on"}, "could not read secret from env: environment variable SIM_REPMGR_UNSET is not set"},
+		{"viewer", []string{"maintenance", "-api-user", "ro:pass", "db2:3306", "off"}, "403 Forbidden: The operator role is required"},
+	}
+	for _, tt := range tests {
+		var err error
+		simStdout(t, func() { err = runClient(append(tt.args[:1:1], append([]string{"-daemon", addr}, tt.args[1:]...)...)) })
+		if (err == nil && tt.err != "") || (err != nil && err.Error() != tt.err) {
+			t.Errorf("%s: %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
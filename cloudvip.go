@@ -0,0 +1,444 @@
+// cloudvip.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/tanji/mariadb-tools/dbhelper"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+/* Reassociates an Elastic IP, given by its address or allocation id, with the EC2 instance of the host */
+type awsVIP struct {
+	eip    string
+	region string
+}
+
+/* Moves an alias IP range between the network interfaces of the GCE instances, the floating IP pattern of GCP networks where ARP announcements are ignored */
+type gcpVIP struct {
+	cidr    string
+	zone    string
+	project string
+}
+
+/* Associates a Neutron floating IP with the port of the host, authenticating to Keystone with the OS_* environment variables */
+type openstackVIP struct {
+	fip      string
+	token    string
+	endpoint string
+	expires  time.Time
+}
+
+/* Returns the provider of a cloud VIP provider, or nil if the provider is not a cloud one */
+func newCloudVIP(addr string) (VIPProvider, error) {
+	switch *vipProvider {
+	case "aws":
+		region := *vipRegion
+		if region == "" {
+			region = os.Getenv("AWS_REGION")
+		}
+		if region == "" {
+			return nil, errors.New("The aws VIP provider requires the vip-cloud-region option or the AWS_REGION environment variable")
+		}
+		if os.Getenv("AWS_ACCESS_KEY_ID") == "" || os.Getenv("AWS_SECRET_ACCESS_KEY") == "" {
+			return nil, errors.New("The aws VIP provider requires the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables")
+		}
+		return &awsVIP{eip: strings.Split(addr, "/")[0], region: region}, nil
+	case "gcp":
+		if strings.Contains(addr, "/") == false {
+			addr += "/32"
+		}
+		return &gcpVIP{cidr: addr, zone: *vipRegion, project: *vipProject}, nil
+	case "openstack":
+		if os.Getenv("OS_AUTH_URL") == "" {
+			return nil, errors.New("The openstack VIP provider requires the OS_AUTH_URL, OS_USERNAME, OS_PASSWORD and OS_PROJECT_NAME environment variables")
+		}
+		return &openstackVIP{fip: strings.Split(addr, "/")[0]}, nil
+	}
+	return nil, nil
+}
+
+/* Returns the cloud instance of a database host set with the vip-cloud-instances option, or an empty string */
+func cloudInstance(host string) string {
+	for _, m := range strings.Split(*vipHosts, ",") {
+		kv := strings.SplitN(strings.TrimSpace(m), "=", 2)
+		if len(kv) == 2 && kv[0] == host {
+			return kv[1]
+		}
+	}
+	return ""
+}
+
+func (p *awsVIP) Add(host string) error {
+	instance, err := p.instance(host)
+	if err != nil {
+		return err
+	}
+	params := p.address("AssociateAddress", "")
+	params.Set("InstanceId", instance)
+	params.Set("AllowReassociation", "true")
+	return p.call(params, &struct{}{})
+}
+
+/* Disassociates the address only if it is still associated with the instance of the host, as the new master may already hold it */
+func (p *awsVIP) Remove(host string) error {
+	instance, err := p.instance(host)
+	if err != nil {
+		return err
+	}
+	var res struct {
+		Addresses []struct {
+			InstanceId    string `xml:"instanceId"`
+			AssociationId string `xml:"associationId"`
+		} `xml:"addressesSet>item"`
+	}
+	err = p.call(p.address("DescribeAddresses", ".1"), &res)
+	if err != nil {
+		return err
+	}
+	if len(res.Addresses) == 0 || res.Addresses[0].InstanceId != instance {
+		return nil
+	}
+	params := url.Values{"Action": {"DisassociateAddress"}}
+	if res.Addresses[0].AssociationId != "" {
+		params.Set("AssociationId", res.Addresses[0].AssociationId)
+	} else {
+		params.Set("PublicIp", p.eip)
+	}
+	return p.call(params, &struct{}{})
+}
+
+/* Returns the parameters of an action on the address, the suffix numbering list parameters */
+func (p *awsVIP) address(action string, suffix string) url.Values {
+	params := url.Values{"Action": {action}}
+	if strings.HasPrefix(p.eip, "eipalloc-") {
+		params.Set("AllocationId"+suffix, p.eip)
+	} else {
+		params.Set("PublicIp"+suffix, p.eip)
+	}
+	return params
+}
+
+/* Returns the instance id of the host, looked up by private address unless mapped with the vip-cloud-instances option */
+func (p *awsVIP) instance(host string) (string, error) {
+	if id := cloudInstance(host); id != "" {
+		return id, nil
+	}
+	ip, err := dbhelper.CheckHostAddr(host)
+	if err != nil {
+		return "", err
+	}
+	var res struct {
+		Instances []string `xml:"reservationSet>item>instancesSet>item>instanceId"`
+	}
+	err = p.call(url.Values{"Action": {"DescribeInstances"}, "Filter.1.Name": {"private-ip-address"}, "Filter.1.Value.1": {ip}}, &res)
+	if err != nil {
+		return "", err
+	}
+	if len(res.Instances) == 0 {
+		return "", errors.New(fmt.Sprintf("no EC2 instance with private address %s in %s", ip, p.region))
+	}
+	return res.Instances[0], nil
+}
+
+func (p *awsVIP) call(params url.Values, v interface{}) error {
+	params.Set("Version", "2016-11-15")
+	return awsRequest("POST", "ec2."+p.region+".amazonaws.com", "/", p.region, "ec2", "application/x-www-form-urlencoded; charset=utf-8", params.Encode(), v)
+}
+
+func (p *gcpVIP) Add(host string) error {
+	return p.update(host, true)
+}
+
+func (p *gcpVIP) Remove(host string) error {
+	return p.update(host, false)
+}
+
+/* Adds the alias range to the first network interface of the instance of the host or removes it, and waits for the operation to complete. GCP refuses a range held by another instance, so the range must be removed from the old master first. */
+func (p *gcpVIP) update(host string, add bool) error {
+	token, err := gcpMetadata("instance/service-accounts/default/token")
+	if err != nil {
+		return err
+	}
+	var auth struct {
+		AccessToken string `json:"access_token"`
+	}
+	json.Unmarshal([]byte(token), &auth)
+	header := http.Header{"Authorization": {"Bearer " + auth.AccessToken}}
+	project := p.project
+	if project == "" {
+		project, err = gcpMetadata("project/project-id")
+		if err != nil {
+			return err
+		}
+	}
+	zone, name := p.zone, strings.Split(host, ".")[0]
+	if m := cloudInstance(host); m != "" {
+		name = m
+		if i := strings.Index(m, "/"); i > 0 {
+			zone, name = m[:i], m[i+1:]
+		}
+	}
+	if zone == "" {
+		zone, err = gcpMetadata("instance/zone")
+		if err != nil {
+			return err
+		}
+		zone = zone[strings.LastIndex(zone, "/")+1:]
+	}
+	base := "https://compute.googleapis.com/compute/v1/projects/" + project + "/zones/" + zone
+	type aliasRange struct {
+		IpCidrRange         string `json:"ipCidrRange"`
+		SubnetworkRangeName string `json:"subnetworkRangeName,omitempty"`
+	}
+	var inst struct {
+		NetworkInterfaces []struct {
+			Name          string
+			Fingerprint   string
+			AliasIpRanges []aliasRange
+		}
+	}
+	_, err = cloudRequest("GET", base+"/instances/"+name, header, nil, &inst)
+	if err != nil {
+		return err
+	}
+	if len(inst.NetworkInterfaces) == 0 {
+		return errors.New(fmt.Sprintf("instance %s has no network interface", name))
+	}
+	nic := inst.NetworkInterfaces[0]
+	ranges, found := []aliasRange{}, false
+	for _, r := range nic.AliasIpRanges {
+		if r.IpCidrRange == p.cidr {
+			found = true
+			if add == false {
+				continue
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	if found == add {
+		return nil
+	}
+	if add {
+		ranges = append(ranges, aliasRange{IpCidrRange: p.cidr})
+	}
+	var op struct{ Name, Status string }
+	_, err = cloudRequest("PATCH", base+"/instances/"+name+"/updateNetworkInterface?networkInterface="+nic.Name, header,
+		map[string]interface{}{"aliasIpRanges": ranges, "fingerprint": nic.Fingerprint}, &op)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(time.Minute)
+	for op.Status != "DONE" {
+		if time.Now().After(deadline) {
+			return errors.New(fmt.Sprintf("operation %s still %s after a minute", op.Name, op.Status))
+		}
+		time.Sleep(2 * time.Second)
+		_, err = cloudRequest("GET", base+"/operations/"+op.Name, header, nil, &op)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/* Reads a value of the metadata server of the GCE instance running replication-manager */
+func gcpMetadata(path string) (string, error) {
+	req, err := http.NewRequest("GET", "http://metadata.google.internal/computeMetadata/v1/"+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New(fmt.Sprintf("metadata %s returned %s", path, resp.Status))
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (p *openstackVIP) Add(host string) error {
+	port, err := p.port(host)
+	if err != nil {
+		return err
+	}
+	id, _, err := p.floatingIP()
+	if err != nil {
+		return err
+	}
+	return p.call("PUT", "/v2.0/floatingips/"+id, map[string]interface{}{"floatingip": map[string]interface{}{"port_id": port}}, &struct{}{})
+}
+
+/* Disassociates the floating IP only if it is still associated with the port of the host */
+func (p *openstackVIP) Remove(host string) error {
+	port, err := p.port(host)
+	if err != nil {
+		return err
+	}
+	id, current, err := p.floatingIP()
+	if err != nil || current != port {
+		return err
+	}
+	return p.call("PUT", "/v2.0/floatingips/"+id, map[string]interface{}{"floatingip": map[string]interface{}{"port_id": nil}}, &struct{}{})
+}
+
+/* Returns the id of the floating IP and of the port it is associated with */
+func (p *openstackVIP) floatingIP() (string, string, error) {
+	var res struct {
+		FloatingIPs []struct {
+			ID     string `json:"id"`
+			PortID string `json:"port_id"`
+		} `json:"floatingips"`
+	}
+	err := p.call("GET", "/v2.0/floatingips?floating_ip_address="+url.QueryEscape(p.fip), nil, &res)
+	if err != nil {
+		return "", "", err
+	}
+	if len(res.FloatingIPs) == 0 {
+		return "", "", errors.New(fmt.Sprintf("no floating IP %s", p.fip))
+	}
+	return res.FloatingIPs[0].ID, res.FloatingIPs[0].PortID, nil
+}
+
+/* Returns the port of the host, looked up by fixed address unless mapped with the vip-cloud-instances option */
+func (p *openstackVIP) port(host string) (string, error) {
+	if id := cloudInstance(host); id != "" {
+		return id, nil
+	}
+	ip, err := dbhelper.CheckHostAddr(host)
+	if err != nil {
+		return "", err
+	}
+	var res struct {
+		Ports []struct {
+			ID string `json:"id"`
+		} `json:"ports"`
+	}
+	err = p.call("GET", "/v2.0/ports?fixed_ips="+url.QueryEscape("ip_address="+ip), nil, &res)
+	if err != nil {
+		return "", err
+	}
+	if len(res.Ports) == 0 {
+		return "", errors.New(fmt.Sprintf("no port with fixed address %s", ip))
+	}
+	return res.Ports[0].ID, nil
+}
+
+/* Sends a request to the Neutron API, authenticating to Keystone first if the token is missing or about to expire */
+func (p *openstackVIP) call(method string, path string, in interface{}, v interface{}) error {
+	if p.token == "" || time.Now().Add(time.Minute).After(p.expires) {
+		err := p.authenticate()
+		if err != nil {
+			return err
+		}
+	}
+	_, err := cloudRequest(method, p.endpoint+path, http.Header{"X-Auth-Token": {p.token}}, in, v)
+	return err
+}
+
+/* Gets a token scoped to the project and the network endpoint from the service catalog */
+func (p *openstackVIP) authenticate() error {
+	env := func(name string, def string) string {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+		return def
+	}
+	auth := map[string]interface{}{"auth": map[string]interface{}{
+		"identity": map[string]interface{}{
+			"methods": []string{"password"},
+			"password": map[string]interface{}{"user": map[string]interface{}{
+				"name": os.Getenv("OS_USERNAME"), "password": os.Getenv("OS_PASSWORD"),
+				"domain": map[string]string{"name": env("OS_USER_DOMAIN_NAME", "Default")},
+			}},
+		},
+		"scope": map[string]interface{}{"project": map[string]interface{}{
+			"name": os.Getenv("OS_PROJECT_NAME"), "domain": map[string]string{"name": env("OS_PROJECT_DOMAIN_NAME", "Default")},
+		}},
+	}}
+	var res struct {
+		Token struct {
+			ExpiresAt time.Time `json:"expires_at"`
+			Catalog   []struct {
+				Type      string
+				Endpoints []struct {
+					Interface string
+					Region    string
+					URL       string
+				}
+			}
+		}
+	}
+	header, err := cloudRequest("POST", strings.TrimSuffix(os.Getenv("OS_AUTH_URL"), "/")+"/auth/tokens", nil, auth, &res)
+	if err != nil {
+		return err
+	}
+	region := *vipRegion
+	if region == "" {
+		region = os.Getenv("OS_REGION_NAME")
+	}
+	p.endpoint = ""
+	for _, s := range res.Token.Catalog {
+		if s.Type != "network" {
+			continue
+		}
+		for _, e := range s.Endpoints {
+			if e.Interface == env("OS_INTERFACE", "public") && (region == "" || e.Region == region) {
+				p.endpoint = strings.TrimSuffix(e.URL, "/")
+			}
+		}
+	}
+	if p.endpoint == "" {
+		return errors.New("no network endpoint in the OpenStack service catalog")
+	}
+	p.token, p.expires = header.Get("X-Subject-Token"), res.Token.ExpiresAt
+	return nil
+}
+
+/* Sends a JSON request to a cloud API, returning the response headers and decoding its JSON response into v */
+func cloudRequest(method string, u string, header http.Header, in interface{}, v interface{}) (http.Header, error) {
+	var body bytes.Buffer
+	if in != nil {
+		json.NewEncoder(&body).Encode(in)
+	}
+	req, err := http.NewRequest(method, u, &body)
+	if err != nil {
+		return nil, err
+	}
+	for k, vals := range header {
+		req.Header[k] = vals
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, errors.New(fmt.Sprintf("%s %s returned %s: %s", method, u, resp.Status, strings.TrimSpace(string(data))))
+	}
+	if v == nil || len(data) == 0 {
+		return resp.Header, nil
+	}
+	return resp.Header, json.Unmarshal(data, v)
+}
@@ -0,0 +1,268 @@
+// cloudvip_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+/* Sends the requests of the cloud APIs to the test server, the host they were sent to in the X-Sim-Host header */
+type simTransport struct {
+	addr string
+	next http.RoundTripper
+}
+
+func (st simTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.Header.Set("X-Sim-Host", r.URL.Host)
+	r.URL.Scheme, r.URL.Host = "http", st.addr
+	return st.next.RoundTrip(r)
+}
+
+/* Serves the cloud APIs with the handler, returns the requests it received as "<method> <host><path>" */
+func simCloud(t *testing.T, h func(w http.ResponseWriter, r *http.Request)) *[]string {
+	var calls []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.Method+" "+r.Header.Get("X-Sim-Host")+r.URL.Path)
+		h(w, r)
+	}))
+	next := http.DefaultTransport
+	http.DefaultTransport = simTransport{addr: srv.Listener.Addr().String(), next: next}
+	t.Cleanup(func() {
+		http.DefaultTransport = next
+		srv.Close()
+	})
+	return &calls
+}
+
+/* Sets the environment variables for the test */
+func simEnv(t *testing.T, vars map[string]string) {
+	for k, v := range vars {
+		old, ok := os.LookupEnv(k)
+		os.Setenv(k, v)
+		k := k
+		t.Cleanup(func() {
+			if ok {
+				os.Setenv(k, old)
+			} else {
+				os.Unsetenv(k)
+			}
+		})
+	}
+}
+
+func TestNewCloudVIP(t *testing.T) {
+	defer func(p, r string) { *vipProvider, *vipRegion = p, r }(*vipProvider, *vipRegion)
+	simEnv(t, map[string]string{"AWS_REGION": "", "AWS_ACCESS_KEY_ID": "AKID", "AWS_SECRET_ACCESS_KEY": "secret", "OS_AUTH_URL": ""})
+	tests := []struct {
+		name     string
+		provider string
+		region   string
+		addr     string
+		want     VIPProvider
+		err      string
+	}{
+		{"aws", "aws", "eu-west-1", "52.1.2.3/32", &awsVIP{eip: "52.1.2.3", region: "eu-west-1"}, ""},
+		{"aws without region", "aws", "", "52.1.2.3", nil, "The aws VIP provider requires the vip-cloud-region option or the AWS_REGION environment variable"},
+		{"gcp", "gcp", "europe-west1-b", "10.0.0.100", &gcpVIP{cidr: "10.0.0.100/32", zone: "europe-west1-b"}, ""},
+		{"openstack without credentials", "openstack", "", "203.0.113.10", nil, "The openstack VIP provider requires the OS_AUTH_URL, OS_USERNAME, OS_PASSWORD and OS_PROJECT_NAME environment variables"},
+		{"not a cloud", "ip", "", "10.0.0.100/24", nil, ""},
+	}
+	for _, tt := range tests {
+		*vipProvider, *vipRegion = tt.provider, tt.region
+		p, err := newCloudVIP(tt.addr)
+		if (err == nil && tt.err != "") || (err != nil && err.Error() != tt.err) || reflect.DeepEqual(p, tt.want) == false {
+			t.Errorf("%s: newCloudVIP(%s) = %#v, %v, want %#v, %q", tt.name, tt.addr, p, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestAWSVIPFailover(t *testing.T) {
+	defer func(f, h string) { *failover, *vipHosts = f, h }(*failover, *vipHosts)
+	simEnv(t, map[string]string{"AWS_ACCESS_KEY_ID": "AKID", "AWS_SECRET_ACCESS_KEY": "secret"})
+	*failover, *vipHosts = "force", "db1=i-111, db3=i-333"
+	holder := "i-111" // instance the address is associated with
+	var actions []string
+	simCloud(t, func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("AllocationId") != "eipalloc-1" && r.Form.Get("AllocationId.1") != "eipalloc-1" && r.Form.Get("AssociationId") != "eipassoc-1" {
+			http.Error(w, "<Response><Errors><Error><Code>InvalidAllocationID.NotFound</Code></Error></Errors></Response>", http.StatusBadRequest)
+			return
+		}
+		actions = append(actions, r.Form.Get("Action")+" "+r.Form.Get("InstanceId"))
+		switch r.Form.Get("Action") {
+		case "DescribeAddresses":
+			w.Write([]byte(`<DescribeAddressesResponse><addressesSet><item><instanceId>` + holder + `</instanceId><associationId>eipassoc-1</associationId></item></addressesSet></DescribeAddressesResponse>`))
+		case "DisassociateAddress":
+			holder = ""
+			w.Write([]byte(`<DisassociateAddressResponse><return>true</return></DisassociateAddressResponse>`))
+		case "AssociateAddress":
+			holder = r.Form.Get("InstanceId")
+			w.Write([]byte(`<AssociateAddressResponse><return>true</return></AssociateAddressResponse>`))
+		}
+	})
+	simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 1 }))
+	current.master = current.findMaster(false)
+	current.vip = &awsVIP{eip: "eipalloc-1", region: "eu-west-1"}
+	if nmUrl, err := current.Failover(context.Background()); nmUrl != "db3:3306" {
+		t.Fatalf("Failover() = %q, %v, want db3:3306", nmUrl, err)
+	}
+	if want := []string{"DescribeAddresses ", "DisassociateAddress ", "AssociateAddress i-333"}; reflect.DeepEqual(actions, want) == false {
+		t.Errorf("EC2 actions %q, want %q", actions, want)
+	}
+	// The address already moved to the new master is left there
+	actions = nil
+	if err := current.vip.Remove("db1"); err != nil || reflect.DeepEqual(actions, []string{"DescribeAddresses "}) == false {
+		t.Errorf("Remove() = %v, actions %q, want the address left on i-333", err, actions)
+	}
+	if err := (&awsVIP{eip: "eipalloc-2", region: "eu-west-1"}).Add("db3"); err == nil || strings.Contains(err.Error(), "400 Bad Request") == false {
+		t.Errorf("Add() of an unknown address = %v, want the API error", err)
+	}
+}
+
+func TestGCPVIP(t *testing.T) {
+	defer func(h string) { *vipHosts = h }(*vipHosts)
+	*vipHosts = "db3=europe-west1-c/db3-vm"
+	ranges := map[string][]string{"db1": {"10.0.0.100/32"}, "db3-vm": {"10.0.1.0/28"}}
+	var patched []string
+	calls := simCloud(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/computeMetadata/v1/instance/service-accounts/default/token":
+			w.Write([]byte(`{"access_token":"ya29.sim","expires_in":3599}`))
+		case r.URL.Path == "/computeMetadata/v1/project/project-id":
+			w.Write([]byte("sim-project\n"))
+		case r.URL.Path == "/computeMetadata/v1/instance/zone":
+			w.Write([]byte("projects/123/zones/europe-west1-b\n"))
+		case r.Header.Get("Authorization") != "Bearer ya29.sim":
+			http.Error(w, "{}", http.StatusUnauthorized)
+		case r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/compute/v1/projects/sim-project/zones/") && strings.Contains(r.URL.Path, "/instances/"):
+			name := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+			var aliases []map[string]string
+			for _, c := range ranges[name] {
+				aliases = append(aliases, map[string]string{"ipCidrRange": c})
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"networkInterfaces": []map[string]interface{}{{"name": "nic0", "fingerprint": "fp-" + name, "aliasIpRanges": aliases}}})
+		case r.Method == "PATCH" && strings.HasSuffix(r.URL.Path, "/updateNetworkInterface"):
+			var req struct {
+				AliasIpRanges []struct{ IpCidrRange string }
+				Fingerprint   string
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			name := strings.Split(r.URL.Path, "/")[8]
+			ranges[name] = nil
+			for _, a := range req.AliasIpRanges {
+				ranges[name] = append(ranges[name], a.IpCidrRange)
+			}
+			patched = append(patched, name+" "+req.Fingerprint+" "+r.URL.Query().Get("networkInterface"))
+			json.NewEncoder(w).Encode(map[string]string{"name": "op-1", "status": "DONE"})
+		default:
+			http.Error(w, "{}", http.StatusNotFound)
+		}
+	})
+	p := &gcpVIP{cidr: "10.0.0.100/32"}
+	if err := p.Remove("db1.example.com"); err != nil {
+		t.Fatalf("Remove() = %s", err)
+	}
+	if err := p.Add("db3"); err != nil {
+		t.Fatalf("Add() = %s", err)
+	}
+	if want := []string{"db1 fp-db1 nic0", "db3-vm fp-db3-vm nic0"}; reflect.DeepEqual(patched, want) == false {
+		t.Errorf("patched interfaces %q, want %q", patched, want)
+	}
+	if len(ranges["db1"]) != 0 || reflect.DeepEqual(ranges["db3-vm"], []string{"10.0.1.0/28", "10.0.0.100/32"}) == false {
+		t.Errorf("alias ranges %q, want the address moved to db3-vm", ranges)
+	}
+	// The instance of a mapped host is in its zone, the others in the zone of the monitor
+	for _, want := range []string{"GET compute.googleapis.com/compute/v1/projects/sim-project/zones/europe-west1-b/instances/db1", "GET compute.googleapis.com/compute/v1/projects/sim-project/zones/europe-west1-c/instances/db3-vm"} {
+		if contains(*calls, want) == false {
+			t.Errorf("%q not requested: %q", want, *calls)
+		}
+	}
+	// An interface already holding the range is not updated
+	patched = nil
+	if err := p.Add("db3"); err != nil || len(patched) != 0 {
+		t.Errorf("Add() = %v, patched %q, want no update", err, patched)
+	}
+}
+
+func TestOpenstackVIP(t *testing.T) {
+	defer func(h, r string) { *vipHosts, *vipRegion = h, r }(*vipHosts, *vipRegion)
+	*vipHosts, *vipRegion = "db1=port-1,db3=port-3", ""
+	simEnv(t, map[string]string{"OS_AUTH_URL": "https://keystone.example.com:5000/v3/", "OS_USERNAME": "repmgr", "OS_PASSWORD": "pw", "OS_PROJECT_NAME": "db",
+		"OS_REGION_NAME": "RegionTwo", "OS_INTERFACE": ""})
+	port, auths := "port-1", 0 // port the floating IP is associated with, and number of authentications
+	var user string
+	calls := simCloud(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/v3/auth/tokens":
+			auths++
+			var req struct {
+				Auth struct {
+					Identity struct {
+						Password struct {
+							User struct{ Name, Password string }
+						}
+					}
+				}
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			user = req.Auth.Identity.Password.User.Name + ":" + req.Auth.Identity.Password.User.Password
+			w.Header().Set("X-Subject-Token", "tok-1")
+			json.NewEncoder(w).Encode(map[string]interface{}{"token": map[string]interface{}{"expires_at": time.Now().Add(time.Hour), "catalog": []map[string]interface{}{
+				{"type": "compute", "endpoints": []map[string]string{{"interface": "public", "region": "RegionTwo", "url": "https://nova.example.com"}}},
+				{"type": "network", "endpoints": []map[string]string{
+					{"interface": "internal", "region": "RegionTwo", "url": "https://neutron.internal"},
+					{"interface": "public", "region": "RegionOne", "url": "https://neutron1.example.com"},
+					{"interface": "public", "region": "RegionTwo", "url": "https://neutron2.example.com/"},
+				}},
+			}}})
+		case r.Header.Get("X-Auth-Token") != "tok-1":
+			http.Error(w, "{}", http.StatusUnauthorized)
+		case r.Method == "GET" && r.URL.Path == "/v2.0/floatingips" && r.URL.Query().Get("floating_ip_address") == "203.0.113.10":
+			json.NewEncoder(w).Encode(map[string]interface{}{"floatingips": []map[string]interface{}{{"id": "fip-1", "port_id": port}}})
+		case r.Method == "PUT" && r.URL.Path == "/v2.0/floatingips/fip-1":
+			var req struct {
+				FloatingIP struct {
+					PortID *string `json:"port_id"`
+				} `json:"floatingip"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			port = ""
+			if req.FloatingIP.PortID != nil {
+				port = *req.FloatingIP.PortID
+			}
+			w.Write([]byte("{}"))
+		default:
+			http.Error(w, "{}", http.StatusNotFound)
+		}
+	})
+	p := &openstackVIP{fip: "203.0.113.10"}
+	if err := p.Remove("db1"); err != nil || port != "" {
+		t.Fatalf("Remove() = %v, floating IP on %q, want it disassociated", err, port)
+	}
+	if err := p.Add("db3"); err != nil || port != "port-3" {
+		t.Fatalf("Add() = %v, floating IP on %q, want port-3", err, port)
+	}
+	// The floating IP on another port is left there
+	if err := p.Remove("db1"); err != nil || port != "port-3" {
+		t.Errorf("Remove() = %v, floating IP on %q, want it left on port-3", err, port)
+	}
+	if auths != 1 || user != "repmgr:pw" || p.endpoint != "https://neutron2.example.com" {
+		t.Errorf("%d authentications as %s, endpoint %s, want one as repmgr:pw with the public endpoint of RegionTwo", auths, user, p.endpoint)
+	}
+	if (*calls)[0] != "POST keystone.example.com:5000/v3/auth/tokens" || (*calls)[1] != "GET neutron2.example.com/v2.0/floatingips" {
+		t.Errorf("requests %q, want the authentication then the floating IP", *calls)
+	}
+	// An expired token is renewed
+	p.expires = time.Now()
+	if err := p.Add("db3"); err != nil || auths != 2 {
+		t.Errorf("Add() = %v with %d authentications, want a new token", err, auths)
+	}
+}
@@ -0,0 +1,254 @@
+// cluster.go
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* A replication cluster monitored by this process, with its definition, topology and monitor state */
+type Cluster struct {
+	Name          string
+	Hosts         string
+	User          string
+	AdminUser     string
+	RplUser       string
+	PrefMaster    string
+	IgnoreServers string
+	Tags          string
+	Vip           string
+	DNS           string
+	PromotionSQL  string
+
+	vip               VIPProvider
+	weights           map[string]int // promotion weights by server URL, 0 if missing
+	hostList          []string
+	ignoreList        []string
+	clusterTags       []string
+	dbUser            string
+	dbPass            string
+	adminUser         string
+	adminPass         string
+	rplUser           string
+	rplPass           string
+	servers           []*ServerMonitor
+	slaves            []*ServerMonitor
+	chained           []*ServerMonitor
+	reattached        map[string]bool // failed intermediate masters whose slaves were already reattached
+	master            *ServerMonitor
+	failCount         int
+	failedMasterURL   string // master replaced by the last failover, waiting to come back online
+	positional        bool   // slaves replicate with binlog file and position instead of GTID
+	stateData         StateFile
+	externalNodes     []*ExternalNode
+	published         string // topology last published to the service registry
+	lastDiscovery     time.Time
+	lastHeartbeat     time.Time
+	lastFlush         time.Time
+	lastPurge         time.Time
+	arbitrationDenied bool
+	stoppedEvents     []string  // events disabled on the old master by the running switchover
+	blockedUsers      []string  // accounts locked on the old master by the running switchover
+	writeLock         *sql.Conn // session holding the write lock of the old master, outside of the pool
+	writeUnlock       string
+	scheduledAt       time.Time       // start of the scheduled switchover window, zero if none
+	scheduleDone      bool            // true once the scheduled switchover ran or its window ended
+	scheduleLate      bool            // true once a delayed scheduled switchover was logged
+	kubePublished     string          // master last published to the writer service
+	suspended         bool            // failure of the master reported while automatic actions are suspended
+	domainWarnings    map[string]bool // GTID domain warnings logged by the last check, each logged again only after it cleared
+	opTrigger         string          // what starts the next failover or switchover: automation, console or command line
+	opLock            sync.Mutex
+	opCancel          context.CancelFunc // cancels the operation in progress, nil when there is none or past its point of no return
+	opAbortedBy       string
+}
+
+var (
+	clusters    []*Cluster
+	shown       *Cluster // cluster displayed by the console
+	clusterLock sync.Mutex
+)
+
+/* Loads cluster definitions, one per line: <name> hosts=<host:[port],...> user=<user:password> rpluser=<user:password> [admin-user=<user:password>] [prefmaster=<host:[port][:weight],...>] [ignore-servers=<host:[port],...>] [tags=<tag,...>] [vip=<address/prefix>] [dns=<record>] [post-promotion-sql=<path>], the post-promotion SQL file defaulting to -post-promotion-sql */
+func loadClusters(file string) ([]*Cluster, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var l []*Cluster
+	scanner := bufio.NewScanner(f)
+	n := 0
+	for scanner.Scan() {
+		n++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if strings.Contains(fields[0], "=") || findCluster(l, fields[0]) != nil {
+			return nil, errors.New(fmt.Sprintf("%s line %d: expected a unique cluster name, got %s", file, n, fields[0]))
+		}
+		c := &Cluster{Name: fields[0], Tags: fields[0], PromotionSQL: *promotionSQL}
+		for _, opt := range fields[1:] {
+			kv := strings.SplitN(opt, "=", 2)
+			if len(kv) != 2 {
+				return nil, errors.New(fmt.Sprintf("%s line %d: expected key=value, got %s", file, n, opt))
+			}
+			switch kv[0] {
+			case "hosts":
+				c.Hosts = kv[1]
+			case "user":
+				c.User = kv[1]
+			case "admin-user":
+				c.AdminUser = kv[1]
+			case "rpluser":
+				c.RplUser = kv[1]
+			case "prefmaster":
+				c.PrefMaster = kv[1]
+			case "ignore-servers":
+				if err := checkIgnoreList(kv[1]); err != nil {
+					return nil, errors.New(fmt.Sprintf("%s line %d: %s", file, n, err))
+				}
+				c.IgnoreServers = kv[1]
+			case "tags":
+				c.Tags += "," + kv[1]
+			case "vip":
+				c.Vip = kv[1]
+			case "dns":
+				c.DNS = kv[1]
+			case "post-promotion-sql":
+				c.PromotionSQL = kv[1]
+			default:
+				return nil, errors.New(fmt.Sprintf("%s line %d: unknown option %s", file, n, kv[0]))
+			}
+		}
+		if c.Hosts == "" || c.User == "" || c.RplUser == "" {
+			return nil, errors.New(fmt.Sprintf("%s line %d: cluster %s requires the hosts, user and rpluser options", file, n, c.Name))
+		}
+		l = append(l, c)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(l) == 0 {
+		return nil, errors.New(fmt.Sprintf("%s defines no cluster", file))
+	}
+	return l, nil
+}
+
+/* Returns the cluster with the given name */
+func findCluster(l []*Cluster, name string) *Cluster {
+	for _, c := range l {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+/* Sets up the credentials, ignored servers and tags of the cluster from its definition */
+func (c *Cluster) configure() {
+	c.dbUser, c.dbPass = splitCredentials(c.User)
+	c.adminUser, c.adminPass = splitCredentials(c.AdminUser)
+	if adminOnly && c.adminUser != "" {
+		c.dbUser, c.dbPass = c.adminUser, c.adminPass
+	}
+	c.rplUser, c.rplPass = splitCredentials(c.RplUser)
+	c.ignoreList, c.clusterTags = nil, nil
+	if c.IgnoreServers != "" {
+		c.ignoreList = strings.Split(c.IgnoreServers, ",")
+	}
+	if c.Tags != "" {
+		c.clusterTags = strings.Split(c.Tags, ",")
+	}
+	c.opTrigger = "command line"
+}
+
+/* Connects to the hosts of the cluster and finds its master, from the state file or else from the topology */
+func (c *Cluster) Discover(ctx context.Context) error {
+	logWriter.attribute(c.clusterName())
+	err := c.connectServers(ctx)
+	if err != nil {
+		return err
+	}
+	if c.stateData.Operation != nil {
+		err = c.resumeOperation()
+		if err != nil {
+			return err
+		}
+		// The roles changed, the servers are checked again
+		err = c.connectServers(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return c.detectMaster()
+}
+
+/* Checks the servers of the cluster and updates their states. The master is declared failed after maxfail consecutive failed checks. */
+func (c *Cluster) Refresh(ctx context.Context) {
+	logWriter.attribute(c.clusterName())
+	c.refreshTopology(ctx)
+}
+
+/* Promotes a slave of the cluster in place of its failed master. Returns the URL of the new master. The failover can be cancelled through the context until the new master stops replicating. */
+func (c *Cluster) Failover(ctx context.Context) (string, error) {
+	logWriter.attribute(c.clusterName())
+	nmUrl, nmKey := c.master.failover(ctx)
+	if nmUrl == "" {
+		return "", errors.New("The failover did not complete, see the log")
+	}
+	c.promoted(nmUrl, nmKey)
+	return nmUrl, nil
+}
+
+/* Switches the master of the cluster over to a slave and reinstances both servers. Returns the URL of the new master. The switchover can be cancelled through the context until the new master stops replicating, writes being restored on the old master. */
+func (c *Cluster) Switchover(ctx context.Context) (string, error) {
+	logWriter.attribute(c.clusterName())
+	nmUrl := c.runSwitchover(ctx)
+	if nmUrl == "" {
+		return "", errors.New("The switchover did not complete, see the log")
+	}
+	return nmUrl, nil
+}
+
+/* Returns the master of the cluster, nil before discovery */
+func (c *Cluster) Master() *ServerMonitor {
+	return c.master
+}
+
+/* Returns the direct slaves of the master of the cluster */
+func (c *Cluster) Slaves() []*ServerMonitor {
+	return c.slaves
+}
+
+/* Returns all the servers of the cluster, in the order of its hosts */
+func (c *Cluster) Servers() []*ServerMonitor {
+	return c.servers
+}
+
+/* Runs fn on the cluster from a goroutine other than the monitor loop, which works on the clusters in turn */
+func withCluster(c *Cluster, fn func()) {
+	clusterLock.Lock()
+	defer clusterLock.Unlock()
+	defer logWriter.attribute(logWriter.attribute(c.clusterName()))
+	fn()
+}
+
+/* Displays the next cluster in the console */
+func showNext() {
+	for k, c := range clusters {
+		if c == shown {
+			shown = clusters[(k+1)%len(clusters)]
+			break
+		}
+	}
+	selected, detailed = 0, false
+}
@@ -0,0 +1,141 @@
+// cluster_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadClusters(t *testing.T) {
+	defer func(p string) { *promotionSQL = p }(*promotionSQL)
+	*promotionSQL = "/etc/repmgr/promotion.sql"
+	tests := []struct {
+		name  string
+		conf  string
+		want  []*Cluster
+		error string
+	}{
+		{"two clusters", "# production\neu hosts=db1,db2 user=repmgr:secret rpluser=repl:secret prefmaster=db2:3306:10 tags=prod post-promotion-sql=/etc/repmgr/eu.sql\n\nus hosts=db4,db5 user=repmgr:secret rpluser=repl:secret vip=10.0.0.10/24\n",
+			[]*Cluster{{Name: "eu", Hosts: "db1,db2", User: "repmgr:secret", RplUser: "repl:secret", PrefMaster: "db2:3306:10", Tags: "eu,prod", PromotionSQL: "/etc/repmgr/eu.sql"},
+				{Name: "us", Hosts: "db4,db5", User: "repmgr:secret", RplUser: "repl:secret", Tags: "us", Vip: "10.0.0.10/24", PromotionSQL: "/etc/repmgr/promotion.sql"}}, ""},
+		{"duplicate name", "eu hosts=db1 user=u:p rpluser=r:p\neu hosts=db4 user=u:p rpluser=r:p\n", nil, "line 2"},
+		{"missing name", "hosts=db1 user=u:p rpluser=r:p\n", nil, "line 1"},
+		{"unknown option", "eu hosts=db1 user=u:p rpluser=r:p maxfail=3\n", nil, "unknown option maxfail"},
+		{"missing hosts", "eu user=u:p rpluser=r:p\n", nil, "requires the hosts"},
+		{"no cluster", "# empty\n", nil, "defines no cluster"},
+	}
+	for _, tt := range tests {
+		file := filepath.Join(t.TempDir(), "clusters")
+		if err := ioutil.WriteFile(file, []byte(tt.conf), 0600); err != nil {
+			t.Fatal(err)
+		}
+		l, err := loadClusters(file)
+		if tt.error != "" {
+			if err == nil || strings.Contains(err.Error(), tt.error) == false {
+				t.Errorf("%s: loadClusters() = %v, want an error with %q", tt.name, err, tt.error)
+			}
+			continue
+		}
+		if err != nil || len(l) != len(tt.want) {
+			t.Errorf("%s: loadClusters() = %v, %v, want %d clusters", tt.name, l, err, len(tt.want))
+			continue
+		}
+		for i, c := range l {
+			w := tt.want[i]
+			if c.Name != w.Name || c.Hosts != w.Hosts || c.User != w.User || c.RplUser != w.RplUser || c.PrefMaster != w.PrefMaster || c.Tags != w.Tags || c.Vip != w.Vip || c.PromotionSQL != w.PromotionSQL {
+				t.Errorf("%s: cluster %d = %s hosts=%s user=%s rpluser=%s prefmaster=%s tags=%s vip=%s post-promotion-sql=%s, want %s hosts=%s user=%s rpluser=%s prefmaster=%s tags=%s vip=%s post-promotion-sql=%s", tt.name, i,
+					c.Name, c.Hosts, c.User, c.RplUser, c.PrefMaster, c.Tags, c.Vip, c.PromotionSQL, w.Name, w.Hosts, w.User, w.RplUser, w.PrefMaster, w.Tags, w.Vip, w.PromotionSQL)
+			}
+		}
+	}
+}
+
+/* Loads two simulated clusters: eu with its master db1 down and us, healthy */
+func simClusters(t *testing.T) (*Cluster, *Cluster) {
+	simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 1 }))
+	eu := current
+	eu.Name, eu.User, eu.Tags = "eu", "repmgr-eu:secret", "eu,prod"
+	eu.configure()
+	eu.master = current.findMaster(false)
+	backends := simBackends
+	simCluster(t, []simSpec{
+		{url: "db4:3306", id: 4, gtid: "0-4-50"},
+		{url: "db5:3306", id: 5, master: 4, gtid: "0-4-50"},
+	})
+	us := current
+	us.Name, us.User, us.Tags = "us", "repmgr-us:secret", "us"
+	us.configure()
+	us.master = current.findMaster(true)
+	for url, b := range backends {
+		simBackends[url] = b
+	}
+	clusters = []*Cluster{eu, us}
+	return eu, us
+}
+
+func TestClusterIsolation(t *testing.T) {
+	defer func(f string) { *failover, clusters = f, nil }(*failover)
+	*failover = "force"
+	eu, us := simClusters(t)
+	for i := 1; i <= 2; i++ {
+		for _, c := range clusters {
+			c.Refresh(context.Background())
+		}
+	}
+	if us.failCount != 0 || us.dbUser != "repmgr-us" || strings.Join(us.clusterTags, ",") != "us" {
+		t.Errorf("us cluster has %d failed checks, user %s and tags %v, want none, repmgr-us and us", us.failCount, us.dbUser, us.clusterTags)
+	}
+	if eu.failCount != 2 || eu.dbUser != "repmgr-eu" || strings.Join(eu.clusterTags, ",") != "eu,prod" {
+		t.Errorf("eu cluster has %d failed checks, user %s and tags %v, want 2, repmgr-eu and eu,prod", eu.failCount, eu.dbUser, eu.clusterTags)
+	}
+	if nmUrl, err := eu.Failover(context.Background()); nmUrl != "db3:3306" {
+		t.Fatalf("eu Failover() = %q, %v, want db3:3306", nmUrl, err)
+	}
+	if us.master.URL != "db4:3306" || len(us.slaves) != 1 || us.slaves[0].URL != "db5:3306" {
+		t.Errorf("us topology changed by the eu failover: master %s, slaves %v", us.master.URL, us.slaves)
+	}
+	if eu.master.URL != "db3:3306" {
+		t.Errorf("eu master %s after its failover, want db3:3306", eu.master.URL)
+	}
+}
+
+func TestClusterHandler(t *testing.T) {
+	defer func() { clusters = nil }()
+	eu, us := simClusters(t)
+	var served *Cluster
+	h := clusterHandler(func(c *Cluster, w http.ResponseWriter, r *http.Request) { served = c })
+	tests := []struct {
+		name   string
+		path   string
+		status int
+		want   *Cluster
+	}{
+		{"selected cluster", "/api/status?cluster=eu", http.StatusOK, eu},
+		{"other cluster", "/api/status?cluster=us", http.StatusOK, us},
+		{"unknown cluster", "/api/status?cluster=asia", http.StatusNotFound, nil},
+		{"cluster required", "/api/status", http.StatusNotFound, nil},
+	}
+	for _, tt := range tests {
+		served = nil
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest("GET", tt.path, nil))
+		if w.Code != tt.status || served != tt.want {
+			t.Errorf("%s: GET %s = %d on %v, want %d on %v", tt.name, tt.path, w.Code, served, tt.status, tt.want)
+		}
+	}
+	w := httptest.NewRecorder()
+	apiClusters(w, httptest.NewRequest("GET", "/api/clusters", nil))
+	var res []ClusterHealth
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 2 || res[0].Name != "eu" || res[1].Name != "us" || res[1].Master != "db4:3306" {
+		t.Errorf("GET /api/clusters = %+v, want the health of eu and us", res)
+	}
+}
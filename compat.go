@@ -0,0 +1,119 @@
+// compat.go
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/* Returns the numeric major, minor and patch levels of a server version string, e.g. 10.1.14-MariaDB-log */
+func parseVersion(v string) [3]int {
+	var res [3]int
+	v = strings.SplitN(v, "-", 2)[0]
+	for i, item := range strings.SplitN(v, ".", 3) {
+		res[i], _ = strconv.Atoi(item)
+	}
+	return res
+}
+
+/* Returns -1, 0 or 1 if version a is lower, equal or higher than b */
+func compareVersion(a [3]int, b [3]int) int {
+	for i := 0; i < 3; i++ {
+		if a[i] < b[i] {
+			return -1
+		}
+		if a[i] > b[i] {
+			return 1
+		}
+	}
+	return 0
+}
+
+/* Returns the requested global variables of a server, keyed by lower case name */
+func (sm *ServerMonitor) getVariables(names ...string) (map[string]string, error) {
+	rows, err := sm.query("SHOW GLOBAL VARIABLES WHERE Variable_name IN ('" + strings.Join(names, "','") + "')")
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]string)
+	for _, r := range rows {
+		res[strings.ToLower(r["Variable_name"])] = r["Value"]
+	}
+	return res, nil
+}
+
+/* Lists the binlog compatibility issues that would appear if the candidate became the master of the other slaves. Replication from a newer to an older server is the risky direction: the slaves may not understand the candidate's checksums, row images or event types. The binlog format and GTID domain of the candidate are also checked against every slave. */
+func (candidate *ServerMonitor) binlogCompatIssues(l []*ServerMonitor) []string {
+	var issues []string
+	vars := []string{"binlog_checksum", "binlog_row_image", "binlog_format", "log_bin", "log_slave_updates", "gtid_domain_id"}
+	cv, err := candidate.getVariables(vars...)
+	if err != nil {
+		return []string{fmt.Sprintf("Could not read binlog settings of %s: %s", candidate.URL, err)}
+	}
+	for _, sl := range l {
+		if sl.URL == candidate.URL || sl.State == STATE_FAILED || sl.Version == "" {
+			continue
+		}
+		if sl.Flavor != candidate.Flavor {
+			issues = append(issues, fmt.Sprintf("%s runs %s but slave %s runs %s", candidate.URL, candidate.Flavor, sl.URL, sl.Flavor))
+		}
+		// An unreachable server, such as a dead master, cannot be checked
+		sv, err := sl.getVariables(vars...)
+		if err != nil {
+			continue
+		}
+		issues = append(issues, candidate.formatIssues(cv, sl, sv)...)
+		if compareVersion(parseVersion(candidate.Version), parseVersion(sl.Version)) <= 0 {
+			continue
+		}
+		cvn, svn := parseVersion(candidate.Version), parseVersion(sl.Version)
+		if cvn[0] != svn[0] || cvn[1] != svn[1] {
+			issues = append(issues, fmt.Sprintf("%s (%s) is a newer major version than slave %s (%s) and may write event types it cannot apply", candidate.URL, candidate.Version, sl.URL, sl.Version))
+		}
+		if _, ok := sv["binlog_checksum"]; ok == false && cv["binlog_checksum"] != "" && cv["binlog_checksum"] != "NONE" {
+			issues = append(issues, fmt.Sprintf("%s writes %s binlog checksums but slave %s does not support checksums", candidate.URL, cv["binlog_checksum"], sl.URL))
+		}
+		if _, ok := sv["binlog_row_image"]; ok == false && cv["binlog_row_image"] != "" && cv["binlog_row_image"] != "FULL" {
+			issues = append(issues, fmt.Sprintf("%s writes %s row images but slave %s only supports full row images", candidate.URL, cv["binlog_row_image"], sl.URL))
+		}
+	}
+	return issues
+}
+
+/* Lists the binlog format and GTID domain differences between the candidate and a server that would replicate from it */
+func (candidate *ServerMonitor) formatIssues(cv map[string]string, sl *ServerMonitor, sv map[string]string) []string {
+	var issues []string
+	cf, sf := strings.ToUpper(cv["binlog_format"]), strings.ToUpper(sv["binlog_format"])
+	// A slave logging its updates in statement format stops on the first row event it has to log
+	if cf != "STATEMENT" && sf == "STATEMENT" && sv["log_bin"] == "ON" && sv["log_slave_updates"] == "ON" {
+		issues = append(issues, fmt.Sprintf("%s writes %s binlogs but slave %s logs its updates in STATEMENT format and would stop on row events", candidate.URL, cf, sl.URL))
+	}
+	if candidate.cluster.master == nil || sl.URL != candidate.cluster.master.URL {
+		return issues
+	}
+	if cf != sf {
+		issues = append(issues, fmt.Sprintf("%s writes %s binlogs but master %s writes %s binlogs, the writes of the applications would be logged differently after the switch", candidate.URL, cf, sl.URL, sf))
+	}
+	if cv["gtid_domain_id"] != sv["gtid_domain_id"] {
+		issues = append(issues, fmt.Sprintf("%s has gtid_domain_id %s but master %s has %s, writes would go to another GTID domain after the switch", candidate.URL, cv["gtid_domain_id"], sl.URL, sv["gtid_domain_id"]))
+	}
+	return issues
+}
+
+/* Logs the binlog compatibility issues of each slave as a candidate, when a switchover finds no candidate it can promote */
+func (c *Cluster) compatReport(l []*ServerMonitor) {
+	logprint("ERROR: Compatibility report of the candidates:")
+	for _, sl := range l {
+		if sl.State == STATE_FAILED {
+			continue
+		}
+		issues := sl.binlogCompatIssues(append([]*ServerMonitor{c.master}, l...))
+		if len(issues) == 0 {
+			logprintf("ERROR:   %s: no compatibility issue", sl.URL)
+		}
+		for _, issue := range issues {
+			logprintf("ERROR:   %s: %s", sl.URL, issue)
+		}
+	}
+}
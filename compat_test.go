@@ -0,0 +1,87 @@
+// compat_test.go
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+/* Gives the servers of a simulated cluster the same version and binlog settings */
+func simBinlogSettings(sims map[string]*simServer) {
+	for url, sim := range sims {
+		simServerByURL(url).Version = "10.1.14-MariaDB-log"
+		for k, v := range map[string]string{"BINLOG_CHECKSUM": "CRC32", "BINLOG_ROW_IMAGE": "FULL", "BINLOG_FORMAT": "ROW", "LOG_BIN": "ON", "LOG_SLAVE_UPDATES": "ON", "GTID_DOMAIN_ID": "0"} {
+			sim.vars[k] = v
+		}
+	}
+}
+
+func TestBinlogCompatIssues(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(sims map[string]*simServer)
+		want   []string // part of each issue
+	}{
+		{"same settings", func(sims map[string]*simServer) {}, nil},
+		{"older candidate", func(sims map[string]*simServer) { simServerByURL("db2:3306").Version = "10.2.6-MariaDB-log" }, nil},
+		{"newer major version", func(sims map[string]*simServer) { simServerByURL("db3:3306").Version = "10.2.6-MariaDB-log" }, []string{"newer major version than slave db1:3306", "newer major version than slave db2:3306"}},
+		{"slave without checksums", func(sims map[string]*simServer) {
+			simServerByURL("db2:3306").Version = "10.1.2-MariaDB-log"
+			delete(sims["db2:3306"].vars, "BINLOG_CHECKSUM")
+		}, []string{"slave db2:3306 does not support checksums"}},
+		{"slave with full row images only", func(sims map[string]*simServer) {
+			simServerByURL("db2:3306").Version = "10.1.2-MariaDB-log"
+			delete(sims["db2:3306"].vars, "BINLOG_ROW_IMAGE")
+			sims["db3:3306"].vars["BINLOG_ROW_IMAGE"] = "MINIMAL"
+		}, []string{"writes MINIMAL row images but slave db2:3306"}},
+		{"slave logging statements", func(sims map[string]*simServer) { sims["db2:3306"].vars["BINLOG_FORMAT"] = "STATEMENT" }, []string{"slave db2:3306 logs its updates in STATEMENT format"}},
+		{"master in another format", func(sims map[string]*simServer) { sims["db1:3306"].vars["BINLOG_FORMAT"] = "MIXED" }, []string{"master db1:3306 writes MIXED binlogs"}},
+		{"master in another domain", func(sims map[string]*simServer) { sims["db3:3306"].vars["GTID_DOMAIN_ID"] = "3" }, []string{"has gtid_domain_id 3 but master db1:3306 has 0"}},
+		{"failed slave", func(sims map[string]*simServer) {
+			sims["db2:3306"].vars["BINLOG_FORMAT"] = "STATEMENT"
+			simServerByURL("db2:3306").State = STATE_FAILED
+		}, nil},
+	}
+	for _, tt := range tests {
+		sims := simCluster(t, simTopology())
+		current.master = current.findMaster(true)
+		current.master.State = STATE_MASTER
+		simBinlogSettings(sims)
+		tt.change(sims)
+		issues := simServerByURL("db3:3306").binlogCompatIssues(current.servers)
+		if len(issues) != len(tt.want) {
+			t.Errorf("%s: issues %q, want %d", tt.name, issues, len(tt.want))
+			continue
+		}
+		for i, w := range tt.want {
+			if strings.Contains(issues[i], w) == false {
+				t.Errorf("%s: issue %q, want %q", tt.name, issues[i], w)
+			}
+		}
+	}
+}
+
+func TestFailoverCompatGate(t *testing.T) {
+	defer func(f string) { *failover, *compatCheck = f, "off" }(*failover)
+	*failover = "force"
+	tests := []struct {
+		mode string
+		want string
+	}{
+		{"warn", "db3:3306"},
+		{"block", "db2:3306"},
+	}
+	for _, tt := range tests {
+		sims := simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 1 }))
+		simBinlogSettings(sims)
+		// The most advanced slave is a newer major version than the other one
+		simServerByURL("db3:3306").Version = "10.2.6-MariaDB-log"
+		*compatCheck = tt.mode
+		current.master = current.findMaster(false)
+		nmUrl, err := current.Failover(context.Background())
+		if err != nil || nmUrl != tt.want {
+			t.Errorf("%s: Failover() = %q, %v, want %s", tt.mode, nmUrl, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// dashboard.go
+package main
+
+import (
+	"fmt"
+	"github.com/mariadb-corporation/replication-manager/pkg/cluster"
+	"github.com/nsf/termbox-go"
+	"sort"
+	"strings"
+)
+
+var (
+	sortBy   string // column the slave rows are sorted by, empty for the order of the hosts list
+	scroll   int    // first slave row shown when they do not all fit on the screen
+	showHelp bool   // true while the console shows the list of keys
+)
+
+/* Columns the slave rows can be sorted by, cycled with the o key */
+var sortColumns = []string{"", "delay", "gtid", "state", "host"}
+
+/* Keys of the monitor console and what they do */
+var consoleKeys = [][2]string{
+	{"Ctrl-Q", "Quit"},
+	{"Ctrl-S", "Switch over to the elected candidate"},
+	{"Ctrl-F", "Fail over the failed master"},
+	{"Ctrl-P", "Suspend or resume automation (panic mode)"},
+	{"Up/Down", "Select a server"},
+	{"PgUp/PgDn", "Select a server one page up or down, scrolling the slave rows"},
+	{"Enter", "Show the details of the selected server, Esc to go back"},
+	{"i", "Ignore the selected slave in promotion, or stop ignoring it"},
+	{"p", "Prefer the selected slave in promotion, or clear its preference"},
+	{"m", "Put the selected server in maintenance, or take it out of it"},
+	{"o", "Sort the slave rows by delay, GTID, state, host or the hosts list order"},
+	{"h", "Show the failover history"},
+	{"d", "Dump the console log to a file"},
+	{"s", "Redraw the screen"},
+	{"Tab", "Display the next cluster"},
+	{"?", "Show this list of keys"},
+}
+
+/* Returns the slaves in the order of the console rows */
+func shownSlaves() []*ServerMonitor {
+	l := append([]*ServerMonitor{}, shown.slaves...)
+	switch sortBy {
+	case "delay":
+		sort.SliceStable(l, func(i, j int) bool { return l[i].lag() > l[j].lag() })
+	case "gtid":
+		sort.SliceStable(l, func(i, j int) bool {
+			return cluster.GTIDCount(l[i].Flavor, l[i].CurrentGtid) > cluster.GTIDCount(l[j].Flavor, l[j].CurrentGtid)
+		})
+	case "state":
+		sort.SliceStable(l, func(i, j int) bool { return stateRank(l[i]) < stateRank(l[j]) })
+	case "host":
+		sort.SliceStable(l, func(i, j int) bool { return l[i].label() < l[j].label() })
+	}
+	return l
+}
+
+/* Ranks the slaves by state, failed ones first, then the ones with a replication issue */
+func stateRank(sm *ServerMonitor) int {
+	switch {
+	case sm.State == STATE_FAILED:
+		return 0
+	case strings.HasPrefix(sm.healthCheck(), "NOT OK"):
+		return 1
+	case sm.lag() > 0:
+		return 2
+	}
+	return 3
+}
+
+/* Sorts the slave rows by the next column */
+func sortNext() {
+	for k, c := range sortColumns {
+		if c == sortBy {
+			sortBy = sortColumns[(k+1)%len(sortColumns)]
+			return
+		}
+	}
+	sortBy = ""
+}
+
+/* Returns the number of slave rows that fit in the upper half of the screen */
+func slaveWindow() int {
+	_, h := termbox.Size()
+	if n := h/2 - 6; n > 3 {
+		return n
+	}
+	return 3
+}
+
+/* Scrolls the slave rows so that the selected one is shown */
+func scrollToSelection() {
+	n := slaveWindow()
+	if selected > 0 && selected-1 < scroll {
+		scroll = selected - 1
+	}
+	if selected-1 >= scroll+n {
+		scroll = selected - n
+	}
+	if scroll > len(shown.slaves)-n {
+		scroll = len(shown.slaves) - n
+	}
+	if scroll < 0 {
+		scroll = 0
+	}
+}
+
+/* Returns the status line of the console: master, slaves and failover counters */
+func statusLine() string {
+	running, failed := 0, 0
+	for _, s := range shown.slaves {
+		if s.IOThread == "Yes" && s.SQLThread == "Yes" {
+			running++
+		}
+	}
+	for _, s := range shown.servers {
+		if s.State == STATE_FAILED {
+			failed++
+		}
+	}
+	failovers, switchovers, automatic := 0, 0, 0
+	for _, e := range shown.stateData.History {
+		if e.Result != "complete" {
+			continue
+		}
+		if e.Type == "failover" {
+			failovers++
+			if e.Trigger == "automation" {
+				automatic++
+			}
+		} else {
+			switchovers++
+		}
+	}
+	s := fmt.Sprintf(" Master: %s %s | Slaves running: %d/%d | Failed: %d | Master checks failed: %d/%d | Failovers: %d (%d automatic) | Switchovers: %d", shown.master.label(), shown.master.State, running, len(shown.slaves), failed, shown.failCount, *maxFail, failovers, automatic, switchovers)
+	if n := len(shown.stateData.History); n > 0 {
+		last := shown.stateData.History[n-1]
+		s += fmt.Sprintf(" | Last %s: %s %s", last.Type, last.Time.Format("2006-01-02 15:04"), last.Result)
+	}
+	return s
+}
+
+/* Prints the list of keys of the console */
+func displayHelp(y int) {
+	printTb(0, y, termbox.ColorWhite|termbox.AttrBold, termbox.ColorBlack, " Keys of the monitor console, ? to go back")
+	y += 2
+	for _, k := range consoleKeys {
+		printfTb(0, y, termbox.ColorWhite, termbox.ColorBlack, " %10s  %s", k[0], k[1])
+		y++
+	}
+}
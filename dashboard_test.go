@@ -0,0 +1,118 @@
+// dashboard_test.go
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+/* Builds a topology of five slaves: db4 stopped, db5 down, db2 and db6 lagging */
+func simDashboard(t *testing.T) {
+	simCluster(t, []simSpec{
+		{url: "db1:3306", id: 1, gtid: "0-1-120"},
+		{url: "db6:3306", id: 6, master: 1, gtid: "0-1-118"},
+		{url: "db2:3306", id: 2, master: 1, gtid: "0-1-110"},
+		{url: "db3:3306", id: 3, master: 1, gtid: "0-1-115"},
+		{url: "db4:3306", id: 4, master: 1, gtid: "0-1-112", stopped: true},
+		{url: "db5:3306", id: 5, master: 1, gtid: "0-1-100", down: true},
+	})
+	current.master = current.findMaster(true)
+	current.master.State = STATE_MASTER
+	simServerByURL("db2:3306").Delay.Int64 = 5
+	simServerByURL("db6:3306").Delay.Int64 = 30
+}
+
+func TestShownSlaves(t *testing.T) {
+	defer func(s string) { sortBy = s }(sortBy)
+	simDashboard(t)
+	tests := []struct {
+		sort string
+		want string
+	}{
+		{"", "db6 db2 db3 db4 db5"},
+		{"delay", "db6 db2 db3 db4 db5"},
+		{"gtid", "db6 db3 db4 db2 db5"},
+		{"state", "db5 db4 db6 db2 db3"},
+		{"host", "db2 db3 db4 db5 db6"},
+	}
+	for _, tt := range tests {
+		sortBy = tt.sort
+		var got []string
+		for _, sl := range shownSlaves() {
+			got = append(got, sl.Host)
+		}
+		if strings.Join(got, " ") != tt.want {
+			t.Errorf("sorted by %q: %q, want %s", tt.sort, got, tt.want)
+		}
+	}
+	// Sorting does not change the order of the slaves of the cluster
+	if current.slaves[0].URL != "db6:3306" {
+		t.Errorf("slaves reordered: first %s", current.slaves[0].URL)
+	}
+}
+
+func TestSortNext(t *testing.T) {
+	defer func(s string) { sortBy = s }(sortBy)
+	sortBy = ""
+	var got []string
+	for i := 0; i < len(sortColumns); i++ {
+		sortNext()
+		got = append(got, sortBy)
+	}
+	if strings.Join(got, ",") != "delay,gtid,state,host," {
+		t.Errorf("sort columns cycled %q", got)
+	}
+}
+
+func TestScrollToSelection(t *testing.T) {
+	defer func(s, sc int) { selected, scroll = s, sc }(selected, scroll)
+	simDashboard(t)
+	// Without a terminal, three slave rows fit on the screen
+	if n := slaveWindow(); n != 3 {
+		t.Fatalf("slaveWindow() = %d, want 3", n)
+	}
+	tests := []struct {
+		selected int
+		scroll   int
+	}{
+		{0, 0},
+		{3, 0},
+		{4, 1},
+		{5, 2},
+		{2, 1},
+		{1, 0},
+		{0, 0},
+	}
+	scroll = 0
+	for _, tt := range tests {
+		selected = tt.selected
+		scrollToSelection()
+		if scroll != tt.scroll {
+			t.Errorf("row %d selected: scroll %d, want %d", tt.selected, scroll, tt.scroll)
+		}
+	}
+	// The rows are scrolled back when slaves are removed
+	scroll, selected = 2, 0
+	current.slaves = current.slaves[:3]
+	scrollToSelection()
+	if scroll != 0 {
+		t.Errorf("scroll %d with three slaves, want 0", scroll)
+	}
+}
+
+func TestStatusLine(t *testing.T) {
+	simDashboard(t)
+	current.failCount = 1
+	at := time.Date(2024, 5, 1, 2, 0, 0, 0, time.Local)
+	current.stateData.History = []FailoverEvent{
+		{Type: "failover", Trigger: "automation", Result: "complete"},
+		{Type: "failover", Trigger: "user", Result: "complete"},
+		{Type: "failover", Trigger: "user", Result: "aborted"},
+		{Time: at, Type: "switchover", Trigger: "user", Result: "complete"},
+	}
+	want := " Master: db1:3306 Master | Slaves running: 3/5 | Failed: 1 | Master checks failed: 1/3 | Failovers: 2 (1 automatic) | Switchovers: 1 | Last switchover: 2024-05-01 02:00 complete"
+	if got := statusLine(); got != want {
+		t.Errorf("statusLine() = %q, want %q", got, want)
+	}
+}
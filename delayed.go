@@ -0,0 +1,27 @@
+// delayed.go
+package main
+
+import (
+	"fmt"
+)
+
+/* True when the slave intentionally applies events late, which makes it unfit for promotion */
+func (sm *ServerMonitor) isDelayed() bool {
+	return sm.SQLDelay > 0
+}
+
+/* Returns the replication delay not explained by MASTER_DELAY */
+func (sm *ServerMonitor) lag() int64 {
+	if sm.Delay.Int64 <= sm.SQLDelay {
+		return 0
+	}
+	return sm.Delay.Int64 - sm.SQLDelay
+}
+
+/* Returns the delay shown in the console, the lag followed by MASTER_DELAY for delayed slaves */
+func (sm *ServerMonitor) delayLabel() string {
+	if sm.isDelayed() {
+		return fmt.Sprintf("%d+%d", sm.lag(), sm.SQLDelay)
+	}
+	return fmt.Sprintf("%d", sm.Delay.Int64)
+}
@@ -0,0 +1,82 @@
+// delayed_test.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+)
+
+func TestDelayedSlave(t *testing.T) {
+	defer func(d int64) { *alertDelay = d }(*alertDelay)
+	*alertDelay = 60
+	tests := []struct {
+		name     string
+		sqlDelay int64
+		behind   int64
+		lag      int64
+		label    string
+		health   string
+		alerted  bool
+	}{
+		{"up to date", 0, 0, 0, "0", "Running OK", false},
+		{"lagging", 0, 120, 120, "120", "Behind master", true},
+		{"delayed on schedule", 3600, 3600, 0, "0+3600", "Running OK", false},
+		{"delayed and lagging", 3600, 3700, 100, "100+3600", "Behind master", true},
+	}
+	for _, tt := range tests {
+		sims := simCluster(t, simChange(simTopology(), func(sp *simSpec) {
+			if sp.id == 3 {
+				sp.sqlDelay = tt.sqlDelay
+			}
+		}))
+		sims["db3:3306"].status.Seconds_Behind_Master = sql.NullInt64{Int64: tt.behind, Valid: true}
+		sm := simServerByURL("db3:3306")
+		sm.refresh()
+		sm.checkDelay()
+		if sm.isDelayed() != (tt.sqlDelay > 0) || sm.lag() != tt.lag || sm.delayLabel() != tt.label || sm.healthCheck() != tt.health || sm.delayAlerted != tt.alerted {
+			t.Errorf("%s: delayed %t, lag %d, label %q, health %q, alerted %t, want lag %d, label %q, health %q, alerted %t",
+				tt.name, sm.isDelayed(), sm.lag(), sm.delayLabel(), sm.healthCheck(), sm.delayAlerted, tt.lag, tt.label, tt.health, tt.alerted)
+		}
+	}
+}
+
+func TestDelayedFailover(t *testing.T) {
+	defer func(f string) { *failover = f }(*failover)
+	*failover = "force"
+	tests := []struct {
+		name       string
+		positional bool
+		repointed  bool
+	}{
+		{"GTID", false, true},
+		{"binlog coordinates", true, false},
+	}
+	for _, tt := range tests {
+		// db3 is the most advanced slave, but applies events an hour late
+		sims := simCluster(t, simChange(simTopology(), func(sp *simSpec) {
+			sp.down = sp.id == 1
+			if sp.id == 3 {
+				sp.sqlDelay = 3600
+			}
+		}))
+		if tt.positional {
+			simPositions(sims["db2:3306"], "mysql-bin.000003", 900, 900)
+			simPositions(sims["db3:3306"], "mysql-bin.000003", 1000, 1000)
+			sims["db2:3306"].rows = map[string][]map[string]string{"SHOW MASTER STATUS": {{"File": "db2-bin.000001", "Position": "4000"}}}
+			for _, sl := range current.slaves {
+				sl.refresh()
+			}
+		}
+		current.master = current.findMaster(false)
+		nmUrl, _ := current.Failover(context.Background())
+		if nmUrl != "db2:3306" {
+			t.Errorf("%s: Failover() promoted %q, want db2:3306", tt.name, nmUrl)
+			continue
+		}
+		if got := sims["db3:3306"].ran("CHANGE MASTER TO master_host='db2'"); got != tt.repointed {
+			t.Errorf("%s: delayed slave repointed %t, want %t: %s", tt.name, got, tt.repointed, strings.Join(sims["db3:3306"].execs, "; "))
+		}
+	}
+}
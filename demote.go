@@ -0,0 +1,117 @@
+// demote.go
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+/* Returns the accounts of the block list in 'user'@'host' format, a user without host standing for all its accounts on the server */
+func (server *ServerMonitor) blockAccounts() ([]string, error) {
+	var l []string
+	for _, item := range strings.Split(*swBlockUsers, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if i := strings.LastIndex(item, "@"); i > 0 {
+			l = append(l, fmt.Sprintf("'%s'@'%s'", item[:i], item[i+1:]))
+			continue
+		}
+		rows, err := server.query("SELECT Host FROM mysql.user WHERE User = ?", item)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range rows {
+			l = append(l, fmt.Sprintf("'%s'@'%s'", item, r["Host"]))
+		}
+	}
+	return l, nil
+}
+
+/* Locks the application accounts on the old master, so that killed sessions cannot reconnect and write. The statements are not written to the binary log, the accounts remaining usable on the other servers. */
+func (server *ServerMonitor) blockUsers() {
+	server.cluster.blockedUsers = nil
+	if *swBlockUsers == "" {
+		return
+	}
+	accounts, err := server.blockAccounts()
+	if err != nil {
+		logprintf("WARN : Could not list the accounts to block on %s: %s", server.URL, err)
+		return
+	}
+	for _, a := range accounts {
+		err = server.execLocal("ALTER USER " + a + " ACCOUNT LOCK")
+		if err != nil {
+			logprintf("WARN : Could not lock account %s on %s: %s", a, server.URL, err)
+			continue
+		}
+		server.cluster.blockedUsers = append(server.cluster.blockedUsers, a)
+		server.cluster.checkpointFrozen()
+	}
+	if len(server.cluster.blockedUsers) > 0 {
+		logprintf("INFO : Locked %d accounts on %s", len(server.cluster.blockedUsers), server.URL)
+		audit("Locked accounts %s on %s during switchover", strings.Join(server.cluster.blockedUsers, ", "), server.URL)
+	}
+}
+
+/* Unlocks the accounts locked by blockUsers */
+func (server *ServerMonitor) unblockUsers() {
+	for _, a := range server.cluster.blockedUsers {
+		err := server.execLocal("ALTER USER " + a + " ACCOUNT UNLOCK")
+		if err != nil {
+			logprintf("ERROR: Could not unlock account %s on %s: %s", a, server.URL, err)
+		}
+	}
+	if len(server.cluster.blockedUsers) > 0 {
+		logprintf("INFO : Unlocked %d accounts on %s", len(server.cluster.blockedUsers), server.URL)
+		audit("Unlocked accounts %s on %s", strings.Join(server.cluster.blockedUsers, ", "), server.URL)
+	}
+	server.cluster.blockedUsers = nil
+}
+
+/* Kills every client thread of the server, including idle ones, until none is left or the kill wait expires, since pooled connections may reconnect in between. Replication, system and replication-manager's own threads, of the monitoring and administration users, are left alone. */
+func (server *ServerMonitor) killClients() {
+	deadline := time.Now().Add(time.Duration(*waitKill) * time.Millisecond)
+	for {
+		rows, err := server.query("SELECT ID, USER, HOST FROM information_schema.PROCESSLIST WHERE USER NOT IN ('system user', 'event_scheduler', ?, ?) AND COMMAND NOT IN ('Binlog Dump', 'Binlog Dump GTID', 'Daemon') AND ID != CONNECTION_ID()", server.cluster.dbUser, server.cluster.adminUser)
+		if err != nil {
+			logprintf("WARN : Could not list threads on %s: %s", server.URL, err)
+			return
+		}
+		if len(rows) == 0 || *dryRun {
+			return
+		}
+		if time.Now().After(deadline) {
+			logprintf("WARN : %d client threads still connected to %s", len(rows), server.URL)
+			return
+		}
+		for _, r := range rows {
+			err = server.exec("KILL " + r["ID"])
+			if err != nil && *verbose {
+				logprintf("DEBUG: Could not kill thread %s of %s@%s on %s: %s", r["ID"], r["USER"], r["HOST"], server.URL, err)
+			}
+		}
+		logprintf("INFO : Killed %d client threads on %s", len(rows), server.URL)
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+/* Sets super_read_only on the old master, so that accounts with the SUPER privilege cannot write either. MariaDB has no such variable. */
+func (server *ServerMonitor) setSuperReadOnly(on bool) {
+	if server.Flavor != FLAVOR_MYSQL {
+		if on {
+			logprintf("WARN : super_read_only is not supported by %s (%s)", server.URL, server.Version)
+		}
+		return
+	}
+	val := "0"
+	if on {
+		val = "1"
+	}
+	err := server.exec("SET GLOBAL super_read_only=" + val)
+	if err != nil {
+		logprintf("WARN : Could not set super_read_only=%s on %s: %s", val, server.URL, err)
+	}
+}
@@ -0,0 +1,88 @@
+// demote_test.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"strings"
+	"testing"
+)
+
+/* Returns the position of the first statement run on the server starting with stmt, -1 if none */
+func (s *simServer) ranAt(stmt string) int {
+	for i, e := range s.execs {
+		if strings.HasPrefix(e, stmt) {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestKillSwitchover(t *testing.T) {
+	defer func(f, s, sw, bu string, sro, ka, ro bool, wk int64) {
+		*failover, *stateFile, *switchover, *swBlockUsers, *swSuperRO, *swKillAll, *readonly, *waitKill = f, s, sw, bu, sro, ka, ro, wk
+	}(*failover, *stateFile, *switchover, *swBlockUsers, *swSuperRO, *swKillAll, *readonly, *waitKill)
+	*failover, *stateFile, *switchover, *swBlockUsers, *swKillAll, *waitKill = "force", "", "kill", "app, report@10.0.%", true, 300
+	locks := []string{"ALTER USER 'app'@'%' ACCOUNT LOCK", "ALTER USER 'app'@'localhost' ACCOUNT LOCK", "ALTER USER 'report'@'10.0.%' ACCOUNT LOCK"}
+	tests := []struct {
+		name     string
+		flavor   string
+		superRO  bool
+		readonly bool   // slaves read-only after the switchover
+		fail     string // statement failing on the old master
+		master   string
+		ran      []string // on the old master, in this order
+		never    []string
+		log      string
+	}{
+		{"kill", FLAVOR_MYSQL, true, true, "", "db3:3306",
+			[]string{locks[0], "SET GLOBAL super_read_only=1", "KILL 77", "ALTER USER 'app'@'%' ACCOUNT UNLOCK"}, []string{"SET GLOBAL super_read_only=0"}, "Locked 3 accounts on db1:3306"},
+		{"read-write slaves", FLAVOR_MYSQL, true, false, "", "db3:3306",
+			[]string{locks[2], "SET GLOBAL super_read_only=1", "KILL 77", "SET GLOBAL super_read_only=0", "ALTER USER 'report'@'10.0.%' ACCOUNT UNLOCK"}, nil, "Unlocked 3 accounts on db1:3306"},
+		{"aborted", FLAVOR_MYSQL, true, true, "FLUSH TABLES WITH READ LOCK", "",
+			[]string{locks[1], "SET GLOBAL super_read_only=1", "SET GLOBAL super_read_only=0", "ALTER USER 'app'@'localhost' ACCOUNT UNLOCK", "SET GLOBAL read_only=0"}, nil, "Unlocked 3 accounts on db1:3306"},
+		{"MariaDB", FLAVOR_MARIADB, true, true, "", "db3:3306", []string{locks[0], "KILL 77"}, []string{"SET GLOBAL super_read_only"}, "super_read_only is not supported by db1:3306"},
+		{"no super_read_only", FLAVOR_MYSQL, false, true, "", "db3:3306", []string{locks[0], "KILL 77"}, []string{"SET GLOBAL super_read_only"}, "client threads still connected to db1:3306"},
+	}
+	for _, tt := range tests {
+		*swSuperRO, *readonly = tt.superRO, tt.readonly
+		sims := simCluster(t, simTopology())
+		sims["db1:3306"].rows = map[string][]map[string]string{
+			"SELECT Host FROM mysql.user":            {{"Host": "%"}, {"Host": "localhost"}},
+			"SELECT ID, USER, HOST FROM information": {{"ID": "77", "USER": "app", "HOST": "10.0.0.5:41210"}},
+		}
+		sims["db1:3306"].fail = tt.fail
+		current.master = current.findMaster(true)
+		current.master.Flavor = tt.flavor
+		var out bytes.Buffer
+		log.SetOutput(&out)
+		logWriter.out = &out
+		nmUrl, _ := current.Switchover(context.Background())
+		log.SetOutput(ioutil.Discard)
+		logWriter.out = ioutil.Discard
+		if nmUrl != tt.master {
+			t.Errorf("%s: Switchover() = %q, want %q", tt.name, nmUrl, tt.master)
+		}
+		last := -1
+		for _, stmt := range tt.ran {
+			i := sims["db1:3306"].ranAt(stmt)
+			if i <= last {
+				t.Errorf("%s: %q not run on the old master after the previous statements: %q", tt.name, stmt, sims["db1:3306"].execs)
+			}
+			last = i
+		}
+		for _, stmt := range tt.never {
+			if sims["db1:3306"].ran(stmt) {
+				t.Errorf("%s: %q run on the old master", tt.name, stmt)
+			}
+		}
+		if strings.Contains(out.String(), tt.log) == false {
+			t.Errorf("%s: log does not contain %q:\n%s", tt.name, tt.log, out.String())
+		}
+		if len(current.blockedUsers) != 0 {
+			t.Errorf("%s: accounts %q left locked", tt.name, current.blockedUsers)
+		}
+	}
+}
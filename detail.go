@@ -0,0 +1,244 @@
+// detail.go
+package main
+
+import (
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/nsf/termbox-go"
+	"strings"
+)
+
+var (
+	selected    int  // console row of the selected server, the master being 0 and slaves following
+	detailed    bool // true while the console shows the detail pane of the selected server
+	showHistory bool // true while the console shows the failover history
+)
+
+/* Returns the server of the selected console row */
+func selectedServer() *ServerMonitor {
+	if selected > 0 && selected <= len(shown.slaves) {
+		return shownSlaves()[selected-1]
+	}
+	return shown.master
+}
+
+/* Moves the console selection by n rows */
+func selectMove(n int) {
+	selected += n
+	if selected > len(shown.slaves) {
+		selected = len(shown.slaves)
+	}
+	if selected < 0 {
+		selected = 0
+	}
+	scrollToSelection()
+}
+
+/* Adds the selected slave to the servers ignored in promotion, or removes it if it is already ignored. A slave ignored through a pattern or a role keyword stays ignored. */
+func toggleIgnored() {
+	sm := selectedServer()
+	if sm == shown.master {
+		return
+	}
+	if contains(shown.ignoreList, sm.URL) == false && sm.ignored() {
+		logprintf("WARN : Slave %s is ignored through a pattern or role of the ignore list, which must be changed to promote it", sm.label())
+		return
+	}
+	if contains(shown.ignoreList, sm.URL) {
+		for k, url := range shown.ignoreList {
+			if url == sm.URL {
+				shown.ignoreList = append(shown.ignoreList[:k], shown.ignoreList[k+1:]...)
+				break
+			}
+		}
+		logprintf("INFO : Slave %s is no longer ignored in promotion", sm.label())
+		audit("Slave %s no longer ignored in promotion", sm.URL)
+	} else {
+		shown.ignoreList = append(shown.ignoreList, sm.URL)
+		logprintf("INFO : Slave %s is now ignored in promotion", sm.label())
+		audit("Slave %s ignored in promotion", sm.URL)
+	}
+	shown.IgnoreServers = strings.Join(shown.ignoreList, ",")
+}
+
+/* Makes the selected slave the preferred master candidate, weighted above the others, or clears its preference if it is already preferred */
+func togglePreferred() {
+	sm := selectedServer()
+	if sm == shown.master {
+		return
+	}
+	if shown.weights[sm.URL] > 0 {
+		delete(shown.weights, sm.URL)
+		logprintf("INFO : Slave %s is no longer a preferred master", sm.label())
+		audit("Preferred master %s cleared", sm.URL)
+	} else {
+		shown.weights[sm.URL] = shown.maxWeight() + 1
+		logprintf("INFO : Slave %s is now the preferred master with weight %d", sm.label(), shown.weights[sm.URL])
+		audit("Preferred master set to %s with weight %d", sm.URL, shown.weights[sm.URL])
+	}
+}
+
+/* Returns the promotion marks of a slave, P when preferred, I when ignored, D when delayed and M in maintenance */
+func promotionMarks(sm *ServerMonitor) string {
+	m := ""
+	if shown.weights[sm.URL] > 0 {
+		m += "P"
+	}
+	if sm.ignored() {
+		m += "I"
+	}
+	if sm.isDelayed() {
+		m += "D"
+	}
+	if sm.inMaintenance() {
+		m += "M"
+	}
+	return m
+}
+
+/* Returns the attributes of a console server row, reversed when it is selected */
+func rowAttr(row int) termbox.Attribute {
+	if row == selected {
+		return termbox.ColorWhite | termbox.AttrReverse
+	}
+	return termbox.ColorWhite
+}
+
+/* Returns the columns of the first row of a SHOW statement as name and value pairs, in the server order */
+func showRow(db *sqlx.DB, query string) ([][2]string, error) {
+	rows, err := db.Queryx(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil || rows.Next() == false {
+		return nil, err
+	}
+	vals, err := rows.SliceScan()
+	if err != nil {
+		return nil, err
+	}
+	res := make([][2]string, len(cols))
+	for i, c := range cols {
+		res[i][0] = c
+		if b, ok := vals[i].([]byte); ok {
+			res[i][1] = string(b)
+		} else if vals[i] != nil {
+			res[i][1] = fmt.Sprint(vals[i])
+		}
+	}
+	return res, rows.Err()
+}
+
+/* Draws the full replication status, settings and last errors of the selected server from line y */
+func displayDetail(y int) {
+	sm := selectedServer()
+	w, h := termbox.Size()
+	printfTb(0, y, termbox.ColorWhite|termbox.AttrBold, termbox.ColorBlack, " Server %s (%s) %s, Esc to go back, Up/Down for the other servers", sm.label(), sm.State, sm.labelString())
+	y += 2
+	if sm.Conn == nil || sm.State == STATE_FAILED {
+		printTb(0, y, termbox.ColorRed, termbox.ColorBlack, " Server is not reachable")
+		return
+	}
+	status, err := showRow(sm.Conn, "SHOW SLAVE STATUS")
+	if err != nil {
+		printfTb(0, y, termbox.ColorRed, termbox.ColorBlack, " Could not read slave status: %s", err)
+		return
+	}
+	// Slave status on the left half, settings and errors on the right half
+	half := w / 2
+	ly := y
+	if len(status) == 0 {
+		printTb(0, ly, termbox.ColorWhite, termbox.ColorBlack, " Not configured as a slave")
+	}
+	for _, kv := range status {
+		if ly >= h {
+			break
+		}
+		detailLine(0, ly, half, kv[0], kv[1])
+		ly++
+	}
+	ry := y
+	printTb(half, ry, termbox.ColorWhite|termbox.AttrBold, termbox.ColorBlack, "Variables")
+	ry++
+	vars, err := queryRows(sm.Conn, diagQueries["variables"])
+	if err != nil {
+		printfTb(half, ry, termbox.ColorRed, termbox.ColorBlack, "Could not read variables: %s", err)
+		ry++
+	}
+	for _, r := range vars {
+		detailLine(half, ry, w-half, r["Variable_name"], r["Value"])
+		ry++
+	}
+	ry++
+	printTb(half, ry, termbox.ColorWhite|termbox.AttrBold, termbox.ColorBlack, "Last errors")
+	ry++
+	errs := detailErrors(status, w-half)
+	for _, l := range errs {
+		if ry >= h {
+			break
+		}
+		printTb(half, ry, termbox.ColorRed, termbox.ColorBlack, l)
+		ry++
+	}
+	if len(errs) == 0 {
+		printTb(half, ry, termbox.ColorWhite, termbox.ColorBlack, "None")
+	}
+}
+
+/* Returns the lines of the last IO and SQL errors of a slave status, wrapped to the width rather than truncated */
+func detailErrors(status [][2]string, width int) []string {
+	var l []string
+	for _, kv := range status {
+		if (kv[0] != "Last_IO_Error" && kv[0] != "Last_SQL_Error") || kv[1] == "" {
+			continue
+		}
+		msg := kv[0] + ": " + kv[1]
+		for len(msg) > width {
+			l = append(l, msg[:width])
+			msg = msg[width:]
+		}
+		l = append(l, msg)
+	}
+	return l
+}
+
+/* Draws the failover and switchover history from line y, most recent first, with the steps of the last operation */
+func displayHistory(y int) {
+	_, h := termbox.Size()
+	printTb(0, y, termbox.ColorWhite|termbox.AttrBold, termbox.ColorBlack, " Failover history, h to go back")
+	y += 2
+	events := shown.stateData.History
+	if len(events) == 0 {
+		printTb(0, y, termbox.ColorWhite, termbox.ColorBlack, " No failover or switchover recorded")
+		return
+	}
+	printfTb(0, y, termbox.ColorWhite|termbox.AttrBold, termbox.ColorBlack, "%20s %10s %21s %21s %12s %10s %s", "Time", "Type", "Old Master", "New Master", "Trigger", "Result", "New Position")
+	y++
+	for k := len(events) - 1; k >= 0 && y < h/2; k-- {
+		e := events[k]
+		printfTb(0, y, termbox.ColorWhite, termbox.ColorBlack, "%20s %10s %21s %21s %12s %10s %s", e.Time.Format("2006-01-02 15:04:05"), e.Type, e.OldMaster, e.NewMaster, e.Trigger, e.Result, e.NewPos)
+		y++
+	}
+	y++
+	last := events[len(events)-1]
+	printfTb(0, y, termbox.ColorWhite|termbox.AttrBold, termbox.ColorBlack, " Steps of the last %s, started by %s (%s)", last.Type, last.Trigger, last.User)
+	y++
+	for _, s := range last.Steps {
+		if y >= h {
+			break
+		}
+		printTb(0, y, termbox.ColorWhite, termbox.ColorBlack, " "+s)
+		y++
+	}
+}
+
+/* Prints a name and value line truncated to the width */
+func detailLine(x, y, width int, name string, value string) {
+	s := fmt.Sprintf(" %30s: %s", name, value)
+	if len(s) > width-1 {
+		s = s[:width-1]
+	}
+	printTb(x, y, termbox.ColorWhite, termbox.ColorBlack, s)
+}
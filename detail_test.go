@@ -0,0 +1,58 @@
+// detail_test.go
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSelectServer(t *testing.T) {
+	defer func(s string) { sortBy, selected, scroll = s, 0, 0 }(sortBy)
+	specs := simTopology()
+	specs = append(specs, simSpec{url: "db4:3306", id: 4, master: 1, gtid: "0-1-105"}, simSpec{url: "db5:3306", id: 5, master: 1, gtid: "0-1-100"})
+	simCluster(t, specs)
+	current.master = current.findMaster(true)
+	tests := []struct {
+		name   string
+		sortBy string
+		moves  []int
+		want   string
+		scroll int
+	}{
+		{"master row", "", nil, "db1:3306", 0},
+		{"first slave", "", []int{1}, "db2:3306", 0},
+		{"last slave shown", "", []int{1, 1, 1}, "db4:3306", 0},
+		{"scrolled down", "", []int{4}, "db5:3306", 1},
+		{"below the last slave", "", []int{9}, "db5:3306", 1},
+		{"back above the window", "", []int{4, -3}, "db2:3306", 0},
+		{"above the master", "", []int{-2}, "db1:3306", 0},
+		{"sorted rows", "gtid", []int{1}, "db3:3306", 0},
+	}
+	for _, tt := range tests {
+		sortBy, selected, scroll = tt.sortBy, 0, 0
+		for _, n := range tt.moves {
+			selectMove(n)
+		}
+		if got := selectedServer(); got.URL != tt.want || scroll != tt.scroll {
+			t.Errorf("%s: selected %s scrolled to %d, want %s scrolled to %d", tt.name, got.URL, scroll, tt.want, tt.scroll)
+		}
+	}
+}
+
+func TestDetailErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status [][2]string
+		want   []string
+	}{
+		{"no error", [][2]string{{"Slave_IO_Running", "Yes"}, {"Last_IO_Error", ""}, {"Last_SQL_Error", ""}}, nil},
+		{"SQL error", [][2]string{{"Last_Errno", "1062"}, {"Last_SQL_Error", "Duplicate key"}}, []string{"Last_SQL_Error: Duplicate key"}},
+		{"wrapped errors", [][2]string{{"Last_IO_Error", "error connecting to master"}, {"Last_SQL_Error", "Duplicate"}},
+			[]string{"Last_IO_Error: error connectin", "g to master", "Last_SQL_Error: Duplicate"}},
+	}
+	for _, tt := range tests {
+		if got := detailErrors(tt.status, 30); reflect.DeepEqual(got, tt.want) == false {
+			t.Errorf("%s: detailErrors() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
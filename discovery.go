@@ -0,0 +1,78 @@
+// discovery.go
+package main
+
+import (
+	"database/sql"
+	"net"
+	"time"
+)
+
+/* Runs topology discovery when the discovery interval has elapsed */
+func (c *Cluster) discoveryCheck() {
+	if *discInterval <= 0 || time.Since(c.lastDiscovery) < time.Duration(*discInterval)*time.Second {
+		return
+	}
+	c.lastDiscovery = time.Now()
+	c.discover()
+	c.probeCheck()
+}
+
+/* Updates the slave list with the servers that started or stopped replicating from the current master since startup, and records the servers connected to the master that are missing from the hosts list, to be probed later. Slaves only appear in SHOW SLAVE HOSTS when they set report_host. */
+func (c *Cluster) discover() {
+	if c.master == nil || c.master.State == STATE_FAILED {
+		return
+	}
+	for _, s := range c.servers {
+		if s.URL == c.master.URL || s.State != STATE_UNCONN || c.isSlave(s.URL) || c.isChained(s.URL) {
+			continue
+		}
+		if s.refresh() != nil || s.UsingGtid == "" {
+			continue
+		}
+		if s.MasterServerId == c.master.ServerId {
+			logprintf("INFO : Server %s now replicates from master, adding it to the slaves", s.label())
+			s.setState(STATE_SLAVE)
+			c.slaves = append(c.slaves, s)
+		} else if up := s.upstream(); up != nil {
+			logprintf("INFO : Server %s now replicates from intermediate master %s, adding it to the chained slaves", s.label(), up.label())
+			s.setState(STATE_SLAVE)
+			c.chained = append(c.chained, s)
+		}
+	}
+	for k := 0; k < len(c.slaves); k++ {
+		sl := c.slaves[k]
+		err := sl.refresh()
+		if err == sql.ErrNoRows || (err == nil && sl.MasterServerId != c.master.ServerId) {
+			logprintf("INFO : Server %s no longer replicates from master, removing it from the slaves", sl.label())
+			sl.UsingGtid = ""
+			sl.setState(STATE_UNCONN)
+			c.slaves = append(c.slaves[:k], c.slaves[k+1:]...)
+			k--
+		}
+	}
+	rows, err := c.master.query("SHOW SLAVE HOSTS")
+	if err != nil {
+		logprintf("WARN : Could not list slave hosts on master: %s", err)
+		return
+	}
+	for _, r := range rows {
+		if r["Host"] == "" {
+			continue
+		}
+		url := net.JoinHostPort(r["Host"], r["Port"])
+		if c.findServer(url) != nil {
+			continue
+		}
+		c.noteExternal(url, "slave-hosts")
+	}
+	c.master.externalDumpHosts()
+}
+
+func (c *Cluster) isSlave(url string) bool {
+	for _, sl := range c.slaves {
+		if sl.URL == url {
+			return true
+		}
+	}
+	return false
+}
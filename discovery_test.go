@@ -0,0 +1,50 @@
+// discovery_test.go
+package main
+
+import (
+	"database/sql"
+	"github.com/tanji/mariadb-tools/dbhelper"
+	"reflect"
+	"testing"
+)
+
+func TestDiscover(t *testing.T) {
+	sims := simCluster(t, []simSpec{
+		{url: "db1:3306", id: 1, gtid: "0-1-120"},
+		{url: "db2:3306", id: 2, master: 1, gtid: "0-1-120"},
+		{url: "db3:3306", id: 3, gtid: "0-1-100"},
+	})
+	current.master = current.findMaster(true)
+	current.master.State = STATE_MASTER
+	// db3 was provisioned as a new slave and db2 was detached since startup
+	sims["db3:3306"].status = &dbhelper.SlaveStatus{Master_Host: "db1", Master_Port: 3306, Master_Server_Id: 1, Using_Gtid: "Slave_Pos", Slave_IO_Running: "Yes", Slave_SQL_Running: "Yes", Seconds_Behind_Master: sql.NullInt64{Valid: true}}
+	sims["db2:3306"].status = nil
+	sims["db1:3306"].rows = map[string][]map[string]string{
+		"SHOW SLAVE HOSTS": {{"Host": "db3", "Port": "3306"}, {"Host": "10.0.0.4", "Port": "3306"}, {"Host": "", "Port": "3306"}},
+		"SELECT HOST FROM information_schema.PROCESSLIST WHERE COMMAND LIKE 'Binlog Dump%'": {{"HOST": "db3:51234"}, {"HOST": "10.0.0.5:40022"}},
+	}
+	current.discover()
+	var slaves []string
+	for _, sl := range current.slaves {
+		slaves = append(slaves, sl.URL)
+	}
+	if reflect.DeepEqual(slaves, []string{"db3:3306"}) == false {
+		t.Errorf("slaves %q after discovery, want [db3:3306]", slaves)
+	}
+	if db2, db3 := simServerByURL("db2:3306"), simServerByURL("db3:3306"); db2.State != STATE_UNCONN || db3.State != STATE_SLAVE {
+		t.Errorf("db2 %s and db3 %s after discovery, want %s and %s", db2.State, db3.State, STATE_UNCONN, STATE_SLAVE)
+	}
+	var external []string
+	for _, n := range current.externalNodes {
+		external = append(external, n.Addr+" "+n.Source)
+	}
+	if want := []string{"10.0.0.4:3306 slave-hosts", "10.0.0.5:3306 processlist"}; reflect.DeepEqual(external, want) == false {
+		t.Errorf("external nodes %q, want %q", external, want)
+	}
+
+	// Known nodes are not recorded twice
+	current.discover()
+	if len(current.externalNodes) != 2 {
+		t.Errorf("%d external nodes after a second discovery, want 2", len(current.externalNodes))
+	}
+}
@@ -2,13 +2,17 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"github.com/nsf/termbox-go"
 	"log"
+	"net"
 )
 
 func display() {
+	// Logs of the other clusters redraw the screen too, the refresh of the selected one is attributed to it
+	defer logWriter.attribute(logWriter.attribute(shown.clusterName()))
 	termbox.Clear(termbox.ColorWhite, termbox.ColorBlack)
 	headstr := fmt.Sprintf(" MariaDB Replication Monitor and Health Checker version %s ", repmgrVersion)
 	if *failover != "" {
@@ -16,31 +20,101 @@ func display() {
 	} else {
 		headstr += " |  Mode: Switchover "
 	}
-	printfTb(0, 0, termbox.ColorWhite, termbox.ColorBlack|termbox.AttrReverse|termbox.AttrBold, headstr)
-	printfTb(0, 5, termbox.ColorWhite|termbox.AttrBold, termbox.ColorBlack, "%15s %6s %7s %12s %20s %20s %20s %6s %3s", "Slave Host", "Port", "Binlog", "Using GTID", "Current GTID", "Slave GTID", "Replication Health", "Delay", "RO")
-	// Check Master Status and print it out to terminal. Increment failure counter if needed.
-	err := master.refresh()
-	if err != nil && err != sql.ErrNoRows && failCount < 4 {
-		failCount++
-		tlog.Add(fmt.Sprintf("Master Failure detected! Retry %d/3", failCount))
-		if failCount > 3 {
-			tlog.Add("Declaring master as failed")
-			master.State = STATE_FAILED
-			master.CurrentGtid = "MASTER FAILED"
-			master.BinlogPos = "MASTER FAILED"
-		}
-		termbox.Sync()
+	if len(clusters) > 1 {
+		headstr += fmt.Sprintf(" |  Cluster: %s ", shown.Name)
+	}
+	headstr += fmt.Sprintf(" |  Health: %d/100 ", shown.clusterHealth().Score)
+	if isStandby() {
+		headstr += fmt.Sprintf(" |  STANDBY: active instance %s ", leaderHolder())
+	} else if automationFrozen() {
+		headstr += fmt.Sprintf(" |  PANIC: automation suspended for %s ", panicRemaining())
+	}
+	printfTb(0, 0, termbox.ColorWhite, termbox.ColorBlack|termbox.AttrReverse|termbox.AttrBold, "%s", headstr)
+	shown.refreshTopology(context.Background())
+	printTb(0, 1, termbox.ColorWhite, termbox.ColorBlack, statusLine())
+	if showHelp {
+		displayHelp(3)
+		termbox.Flush()
+		return
+	}
+	if showHistory {
+		displayHistory(2)
+		termbox.Flush()
+		return
+	}
+	if detailed {
+		displayDetail(2)
+		termbox.Flush()
+		return
+	}
+	printfTb(0, 5, termbox.ColorWhite|termbox.AttrBold, termbox.ColorBlack, "%15s %6s %7s %12s %20s %20s %20s %11s %3s %10s %5s", "Slave Host", "Port", "Binlog", "Using GTID", "Current GTID", "Slave GTID", "Replication Health", "Delay", "RO", "Apply kB/s", "Marks")
+	printfTb(0, 2, termbox.ColorWhite|termbox.AttrBold, termbox.ColorBlack, "%15s %6s %41s %20s %12s %11s", "Master Host", "Port", "Current GTID", "Binlog Position", "Strict Mode", "Binlog kB/s")
+	printfTb(0, 3, serverAttr(0, shown.master), termbox.ColorBlack, "%15s %6s %41s %20s %12s %11.1f", shown.master.displayHost(), shown.master.Port, shown.master.CurrentGtid, shown.master.BinlogPos, shown.master.Strict, shown.master.BinlogRate/1024)
+	if e := shown.master.readonlyIssue(); e != "" {
+		printfTb(0, 4, termbox.ColorYellow, termbox.ColorBlack, "%15s %s", "", e)
+	} else if g := shown.master.galeraLabel() + shown.master.groupLabel(); g != "" {
+		printfTb(0, 4, termbox.ColorCyan, termbox.ColorBlack, "%15s %s", "", g)
 	}
-	printfTb(0, 2, termbox.ColorWhite|termbox.AttrBold, termbox.ColorBlack, "%15s %6s %41s %20s %12s", "Master Host", "Port", "Current GTID", "Binlog Position", "Strict Mode")
-	printfTb(0, 3, termbox.ColorWhite, termbox.ColorBlack, "%15s %6s %41s %20s %12s", master.Host, master.Port, master.CurrentGtid, master.BinlogPos, master.Strict)
 	vy = 6
-	for _, slave := range slaves {
-		slave.refresh()
-		printfTb(0, vy, termbox.ColorWhite, termbox.ColorBlack, "%15s %6s %7s %12s %20s %20s %20s %6d %3s", slave.Host, slave.Port, slave.LogBin, slave.UsingGtid, slave.CurrentGtid, slave.SlaveGtid, slave.healthCheck(), slave.Delay.Int64, slave.ReadOnly)
+	rows := shownSlaves()
+	scrollToSelection()
+	if scroll > 0 {
+		printfTb(0, vy, termbox.ColorWhite, termbox.ColorBlack, "%15s %d more slaves above, PgUp to scroll", "", scroll)
+		vy++
+	}
+	for k := scroll; k < len(rows) && k < scroll+slaveWindow(); k++ {
+		slave := rows[k]
+		printfTb(0, vy, serverAttr(k+1, slave), termbox.ColorBlack, "%15s %6s %7s %12s %20s %20s %20s %11s %3s %10.1f %5s", slave.displayHost(), slave.Port, slave.LogBin, slave.UsingGtid, slave.CurrentGtid, slave.SlaveGtid, slave.healthCheck(), slave.delayLabel(), slave.readonlyLabel(), slave.ApplyRate/1024, promotionMarks(slave))
+		vy++
+		if e := slave.readonlyIssue(); e != "" {
+			printfTb(0, vy, termbox.ColorYellow, termbox.ColorBlack, "%15s %s", "", e)
+			vy++
+		}
+		if e := slave.replError(); e != "" {
+			printfTb(0, vy, termbox.ColorRed, termbox.ColorBlack, "%15s %s", "", e)
+			vy++
+		}
+		if g := slave.galeraLabel() + slave.groupLabel(); g != "" {
+			printfTb(0, vy, termbox.ColorCyan, termbox.ColorBlack, "%15s %s", "", g)
+			vy++
+		}
+		for _, ch := range slave.sources() {
+			if ch.Name != slave.Channel {
+				printfTb(0, vy, termbox.ColorCyan, termbox.ColorBlack, "%15s Connection '%s' from %s, IO %s, SQL %s, delay %s", "", ch.Name, ch.Master, ch.IOThread, ch.SQLThread, ch.Delay)
+				vy++
+			}
+		}
+	}
+	if n := len(rows) - scroll - slaveWindow(); n > 0 {
+		printfTb(0, vy, termbox.ColorWhite, termbox.ColorBlack, "%15s %d more slaves below, PgDn to scroll", "", n)
 		vy++
 	}
 	vy++
-	for _, server := range servers {
+	if len(shown.chained) > 0 {
+		printfTb(0, vy, termbox.ColorWhite|termbox.AttrBold, termbox.ColorBlack, "%15s %6s %21s %12s %20s %20s %11s", "Chained Host", "Port", "Intermediate Master", "Using GTID", "Slave GTID", "Replication Health", "Delay")
+		vy++
+		for _, s := range shown.chained {
+			up := "unknown"
+			if u := s.upstream(); u != nil {
+				up = net.JoinHostPort(u.displayHost(), u.Port)
+			}
+			printfTb(0, vy, termbox.ColorWhite, termbox.ColorBlack, "%15s %6s %21s %12s %20s %20s %11s", s.displayHost(), s.Port, up, s.UsingGtid, s.SlaveGtid, s.healthCheck(), s.delayLabel())
+			vy++
+			if e := s.replError(); e != "" {
+				printfTb(0, vy, termbox.ColorRed, termbox.ColorBlack, "%15s %s", "", e)
+				vy++
+			}
+		}
+		vy++
+	}
+	var standalone []*ServerMonitor
+	for _, server := range shown.servers {
+		if server.State == STATE_UNCONN {
+			standalone = append(standalone, server)
+		}
+	}
+	refreshAll(context.Background(), standalone)
+	for _, server := range shown.servers {
 		f := false
 		if server.State == STATE_UNCONN {
 			if f == false {
@@ -48,24 +122,86 @@ func display() {
 				f = true
 				vy++
 			}
-			server.refresh()
 			printfTb(0, vy, termbox.ColorWhite|termbox.AttrBold, termbox.ColorBlack, "%15s %6s %41s %20s %12s", "Master Host", "Port", "Current GTID", "Binlog Position", "Strict Mode")
-			printfTb(0, vy, termbox.ColorWhite, termbox.ColorBlack, "%15s %6s %41s %20s %12s", server.Host, server.Port, server.CurrentGtid, server.BinlogPos, server.Strict)
+			printfTb(0, vy, termbox.ColorWhite, termbox.ColorBlack, "%15s %6s %41s %20s %12s", server.displayHost(), server.Port, server.CurrentGtid, server.BinlogPos, server.Strict)
 			vy++
+			if g := server.galeraLabel() + server.groupLabel(); g != "" {
+				printfTb(0, vy, termbox.ColorCyan, termbox.ColorBlack, "%15s %s", "", g)
+				vy++
+			}
 		}
 
 	}
+	if len(shown.externalNodes) > 0 {
+		vy++
+		printfTb(0, vy, termbox.ColorWhite|termbox.AttrBold, termbox.ColorBlack, "%21s %16s %s", "External Host", "Kind", "Detail")
+		vy++
+		for _, n := range shown.externalNodes {
+			printfTb(0, vy, termbox.ColorWhite, termbox.ColorBlack, "%21s %16s %s", n.Addr, n.Kind, n.Detail)
+			vy++
+		}
+	}
 	vy++
-	if master.CurrentGtid != "MASTER FAILED" {
-		printTb(0, vy, termbox.ColorWhite, termbox.ColorBlack, " Ctrl-Q to quit, Ctrl-S to switchover")
+	if shown.master.CurrentGtid != "MASTER FAILED" {
+		printTb(0, vy, termbox.ColorWhite, termbox.ColorBlack, " Ctrl-Q to quit, Ctrl-S to switchover, Ctrl-P to toggle panic mode")
 	} else {
-		printTb(0, vy, termbox.ColorWhite, termbox.ColorBlack, " Ctrl-Q to quit, Ctrl-F to failover")
+		printTb(0, vy, termbox.ColorWhite, termbox.ColorBlack, " Ctrl-Q to quit, Ctrl-F to failover, Ctrl-P to toggle panic mode")
 	}
+	vy++
+	order := "the hosts list"
+	if sortBy != "" {
+		order = sortBy
+	}
+	printfTb(0, vy, termbox.ColorWhite, termbox.ColorBlack, " Up/Down to select a server, Enter for its details, o to sort the slaves (by %s), ? for the list of keys", order)
 	vy = vy + 3
 	tlog.Print()
 	termbox.Flush()
 }
 
+/* Refreshes the master and slaves concurrently. Increments the master failure counter if needed. */
+func (c *Cluster) refreshTopology(ctx context.Context) {
+	errs := refreshAll(ctx, append([]*ServerMonitor{c.master}, c.slaves...))
+	// A cancelled check tells nothing about the servers
+	if ctx.Err() != nil {
+		return
+	}
+	err := errs[0]
+	if err != nil && err != sql.ErrNoRows && c.failCount < *maxFail && c.master.inMaintenance() == false {
+		c.failCount++
+		alertLog("Master Failure detected! Retry %d/%d", c.failCount, *maxFail)
+		if c.failCount >= *maxFail {
+			alertLog("Declaring master as failed")
+			c.master.setState(STATE_FAILED)
+			c.master.CurrentGtid = "MASTER FAILED"
+			c.master.BinlogPos = "MASTER FAILED"
+		}
+		if termbox.IsInit {
+			termbox.Sync()
+		}
+	} else if (err == nil || err == sql.ErrNoRows) && c.master.State != STATE_FAILED && c.failCount > 0 {
+		// Only consecutive failed checks count
+		alertLog("INFO : Master %s answers again after %d failed checks", c.master.label(), c.failCount)
+		c.failCount = 0
+	}
+	for k, slave := range c.slaves {
+		if slave.inMaintenance() {
+			continue
+		}
+		err = errs[k+1]
+		if err != nil && err != sql.ErrNoRows {
+			slave.setState(STATE_FAILED)
+		} else if slave.State == STATE_FAILED {
+			slave.setState(STATE_SLAVE)
+		}
+		if lag, ok := slave.heartbeatLag(); ok && slave.Delay.Valid {
+			slave.Delay.Int64 = lag
+		}
+		slave.checkDelay()
+		slave.checkErrors()
+	}
+	c.domainCheck()
+}
+
 func printTb(x, y int, fg, bg termbox.Attribute, msg string) {
 	for _, c := range msg {
 		termbox.SetCell(x, y, c, fg, bg)
@@ -79,7 +215,7 @@ func printfTb(x, y int, fg, bg termbox.Attribute, format string, args ...interfa
 }
 
 func logprint(msg ...interface{}) {
-	if *interactive == true || *failover == "monitor" {
+	if termbox.IsInit {
 		tlog.Add(fmt.Sprintln(msg...))
 		display()
 	} else {
@@ -88,7 +224,7 @@ func logprint(msg ...interface{}) {
 }
 
 func logprintf(format string, args ...interface{}) {
-	if *interactive == true || *failover == "monitor" {
+	if termbox.IsInit {
 		tlog.Add(fmt.Sprintf(format, args...))
 		display()
 	} else {
@@ -0,0 +1,289 @@
+// dns.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* A DNSProvider points a record at a host, with a CNAME for a host name and an A or AAAA record for an address. Update submits the change and returns its identifier, Wait waits until the provider applied it. */
+type DNSProvider interface {
+	Update(name string, host string) (string, error)
+	Wait(change string) error
+}
+
+/* Updates the record with an RFC 2136 dynamic update sent by nsupdate(1) */
+type nsupdateProvider struct {
+	server string
+	key    string
+}
+
+/* Updates the record through the Route53 API and waits for the change to be in sync on its name servers */
+type route53Provider struct {
+	zone string
+}
+
+var (
+	dnsProvider  DNSProvider
+	dnsMutex     sync.Mutex
+	dnsEvents    []func()       // results of the verifications, reported by the monitor loop
+	dnsVerifying sync.WaitGroup // verifications in progress
+)
+
+/* Returns the provider selected by the dns-provider option */
+func newDNSProvider() (DNSProvider, error) {
+	switch *dnsKind {
+	case "nsupdate":
+		if *dnsServer == "" {
+			return nil, errors.New("The nsupdate DNS provider requires the dns-server option")
+		}
+		return &nsupdateProvider{server: *dnsServer, key: *dnsKey}, nil
+	case "route53":
+		if *dnsZone == "" {
+			return nil, errors.New("The route53 DNS provider requires the dns-zone option")
+		}
+		if os.Getenv("AWS_ACCESS_KEY_ID") == "" || os.Getenv("AWS_SECRET_ACCESS_KEY") == "" {
+			return nil, errors.New("The route53 DNS provider requires the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables")
+		}
+		return &route53Provider{zone: *dnsZone}, nil
+	}
+	return nil, errors.New(fmt.Sprintf("Unknown DNS provider: %s", *dnsKind))
+}
+
+/* Returns the type and value of the record pointing at the host */
+func dnsTarget(host string) (string, string) {
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.To4() != nil {
+			return "A", ip.String()
+		}
+		return "AAAA", ip.String()
+	}
+	return "CNAME", strings.TrimSuffix(host, ".") + "."
+}
+
+/* Points the record of the cluster at the new master. Only the change is submitted within the operation, which may hold the old master frozen; whether the provider applied it and the name server answers with it is verified in the background. Clients that resolved the record before keep the old master until their cache expires, after the TTL the record had, so the TTL is kept short. */
+func (c *Cluster) dnsMove(newMaster *ServerMonitor, logf func(string, ...interface{})) {
+	if c.DNS == "" || dnsProvider == nil {
+		return
+	}
+	if *dryRun {
+		alertLog("DRY-RUN: would point DNS record %s at %s with a TTL of %d seconds", c.DNS, newMaster.Host, *dnsTTL)
+		return
+	}
+	logf("INFO : Pointing DNS record %s at %s (new master)", c.DNS, newMaster.Host)
+	change, err := dnsProvider.Update(c.DNS, newMaster.Host)
+	if err != nil {
+		logf("ERROR: Could not update DNS record %s: %s", c.DNS, err)
+		return
+	}
+	audit("Pointed DNS record %s at %s", c.DNS, newMaster.Host)
+	dnsVerifying.Add(1)
+	go dnsConfirm(dnsProvider, change, c.DNS, newMaster.Host)
+}
+
+/* Waits for the provider to apply the change of the record, then for the name server to answer it with the host */
+func dnsConfirm(p DNSProvider, change string, name string, host string) {
+	defer dnsVerifying.Done()
+	err := p.Wait(change)
+	if err != nil {
+		dnsReport(func() { logprintf("WARN : DNS record %s: %s", name, err) })
+		return
+	}
+	if *dnsServer == "" {
+		return
+	}
+	err = verifyDNS(name, host)
+	if err != nil {
+		dnsReport(func() { logprintf("WARN : DNS record %s: %s", name, err) })
+		return
+	}
+	dnsReport(func() { logprintf("INFO : Name server %s answers %s for %s", *dnsServer, host, name) })
+}
+
+/* Queues a result of a verification for the monitor loop */
+func dnsReport(e func()) {
+	dnsMutex.Lock()
+	dnsEvents = append(dnsEvents, e)
+	dnsMutex.Unlock()
+}
+
+/* Reports the results of the DNS verifications completed since the last check */
+func dnsCheck() {
+	dnsMutex.Lock()
+	events := dnsEvents
+	dnsEvents = nil
+	dnsMutex.Unlock()
+	for _, e := range events {
+		e()
+	}
+}
+
+/* Waits for the DNS verifications in progress and reports them, before a one-shot operation exits */
+func dnsWait() {
+	dnsVerifying.Wait()
+	dnsCheck()
+}
+
+/* Queries the DNS server until it answers the record with the host, or the verification timeout expires */
+func verifyDNS(name string, host string) error {
+	r := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, net.JoinHostPort(*dnsServer, "53"))
+		},
+	}
+	kind, value := dnsTarget(host)
+	deadline := time.Now().Add(time.Duration(*dnsVerify) * time.Second)
+	var got string
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		if kind == "CNAME" {
+			got, _ = r.LookupCNAME(ctx, name)
+		} else if addrs, err := r.LookupHost(ctx, name); err == nil {
+			got = strings.Join(addrs, ",")
+		}
+		cancel()
+		if got == value || (kind != "CNAME" && contains(strings.Split(got, ","), value)) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New(fmt.Sprintf("name server %s still answers %q after %d seconds", *dnsServer, got, *dnsVerify))
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func (p *nsupdateProvider) Update(name string, host string) (string, error) {
+	kind, value := dnsTarget(host)
+	var script bytes.Buffer
+	fmt.Fprintf(&script, "server %s\n", p.server)
+	for _, t := range []string{"A", "AAAA", "CNAME"} {
+		fmt.Fprintf(&script, "update delete %s %s\n", name, t)
+	}
+	fmt.Fprintf(&script, "update add %s %d %s %s\nsend\n", name, *dnsTTL, kind, value)
+	var args []string
+	if p.key != "" {
+		args = append(args, "-k", p.key)
+	}
+	cmd := exec.Command("nsupdate", args...)
+	cmd.Stdin = &script
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("%s: %s", err, strings.TrimSpace(string(out))))
+	}
+	return "", nil
+}
+
+/* The update is applied by the primary server once nsupdate returns */
+func (p *nsupdateProvider) Wait(change string) error {
+	return nil
+}
+
+type route53ChangeInfo struct {
+	Id     string
+	Status string
+}
+
+func (p *route53Provider) Update(name string, host string) (string, error) {
+	kind, value := dnsTarget(host)
+	body := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<ChangeResourceRecordSetsRequest xmlns="https://route53.amazonaws.com/doc/2013-04-01/"><ChangeBatch><Comment>replication-manager master %s</Comment><Changes><Change><Action>UPSERT</Action>`+
+		`<ResourceRecordSet><Name>%s</Name><Type>%s</Type><TTL>%d</TTL><ResourceRecords><ResourceRecord><Value>%s</Value></ResourceRecord></ResourceRecords></ResourceRecordSet>`+
+		`</Change></Changes></ChangeBatch></ChangeResourceRecordSetsRequest>`, host, name, kind, *dnsTTL, value)
+	var res struct {
+		ChangeInfo route53ChangeInfo
+	}
+	err := route53Request("POST", "/2013-04-01/hostedzone/"+strings.TrimPrefix(p.zone, "/hostedzone/")+"/rrset", body, &res)
+	if err != nil {
+		return "", err
+	}
+	return res.ChangeInfo.Id, nil
+}
+
+/* Polls the change until it is in sync on the Route53 name servers */
+func (p *route53Provider) Wait(change string) error {
+	var res struct {
+		ChangeInfo route53ChangeInfo
+	}
+	deadline := time.Now().Add(time.Duration(*dnsVerify) * time.Second)
+	for {
+		err := route53Request("GET", "/2013-04-01"+change, "", &res)
+		if err != nil {
+			return err
+		}
+		if res.ChangeInfo.Status == "INSYNC" {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New(fmt.Sprintf("change %s still %s after %d seconds", change, res.ChangeInfo.Status, *dnsVerify))
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+/* Sends a request to the Route53 API and decodes its XML response into v */
+func route53Request(method string, path string, body string, v interface{}) error {
+	return awsRequest(method, "route53.amazonaws.com", path, "us-east-1", "route53", "", body, v)
+}
+
+/* Sends a request to an AWS API signed with AWS Signature Version 4, using the credentials of the AWS environment variables, and decodes its XML response into v */
+func awsRequest(method string, host string, path string, region string, service string, contentType string, body string, v interface{}) error {
+	now := time.Now().UTC()
+	amzDate, day := now.Format("20060102T150405Z"), now.Format("20060102")
+	req, err := http.NewRequest(method, "https://"+host+path, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("X-Amz-Date", amzDate)
+	headers := "host:" + host + "\nx-amz-date:" + amzDate + "\n"
+	signed := "host;x-amz-date"
+	if token := os.Getenv("AWS_SESSION_TOKEN"); token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+		headers += "x-amz-security-token:" + token + "\n"
+		signed += ";x-amz-security-token"
+	}
+	sum := sha256.Sum256([]byte(body))
+	canonical := strings.Join([]string{method, path, "", headers, signed, hex.EncodeToString(sum[:])}, "\n")
+	sum = sha256.Sum256([]byte(canonical))
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+	key := []byte("AWS4" + os.Getenv("AWS_SECRET_ACCESS_KEY"))
+	for _, s := range []string{day, region, service, "aws4_request", toSign} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(s))
+		key = mac.Sum(nil)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", os.Getenv("AWS_ACCESS_KEY_ID"), scope, signed, hex.EncodeToString(key)))
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		return errors.New(fmt.Sprintf("%s %s returned %s: %s", method, path, resp.Status, strings.TrimSpace(string(data))))
+	}
+	return xml.Unmarshal(data, v)
+}
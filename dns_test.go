@@ -0,0 +1,201 @@
+// dns_test.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/* DNS provider of a test, recording the updates */
+type simDNS struct {
+	updates []string // name=host
+	waitErr error
+}
+
+func (p *simDNS) Update(name string, host string) (string, error) {
+	p.updates = append(p.updates, name+"="+host)
+	return "c1", nil
+}
+
+func (p *simDNS) Wait(change string) error {
+	return p.waitErr
+}
+
+func TestDNSTarget(t *testing.T) {
+	tests := []struct {
+		host  string
+		kind  string
+		value string
+	}{
+		{"db3.example.com", "CNAME", "db3.example.com."},
+		{"db3.example.com.", "CNAME", "db3.example.com."},
+		{"10.0.0.3", "A", "10.0.0.3"},
+		{"fd00::3", "AAAA", "fd00::3"},
+	}
+	for _, tt := range tests {
+		if kind, value := dnsTarget(tt.host); kind != tt.kind || value != tt.value {
+			t.Errorf("dnsTarget(%s) = %s %s, want %s %s", tt.host, kind, value, tt.kind, tt.value)
+		}
+	}
+}
+
+func TestNewDNSProvider(t *testing.T) {
+	defer func(k, s, z string) { *dnsKind, *dnsServer, *dnsZone = k, s, z }(*dnsKind, *dnsServer, *dnsZone)
+	defer func(id, secret string) {
+		os.Setenv("AWS_ACCESS_KEY_ID", id)
+		os.Setenv("AWS_SECRET_ACCESS_KEY", secret)
+	}(os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"))
+	tests := []struct {
+		kind   string
+		server string
+		zone   string
+		keys   bool // AWS credentials set
+		err    string
+	}{
+		{"nsupdate", "ns1.example.com", "", false, ""},
+		{"nsupdate", "", "", false, "The nsupdate DNS provider requires the dns-server option"},
+		{"route53", "", "Z123", true, ""},
+		{"route53", "", "", true, "The route53 DNS provider requires the dns-zone option"},
+		{"route53", "", "Z123", false, "The route53 DNS provider requires the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables"},
+		{"bind", "", "", false, "Unknown DNS provider: bind"},
+	}
+	for _, tt := range tests {
+		*dnsKind, *dnsServer, *dnsZone = tt.kind, tt.server, tt.zone
+		os.Unsetenv("AWS_ACCESS_KEY_ID")
+		os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+		if tt.keys {
+			os.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+			os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+		}
+		_, err := newDNSProvider()
+		if (err == nil && tt.err != "") || (err != nil && err.Error() != tt.err) {
+			t.Errorf("%s: newDNSProvider() = %v, want %q", tt.kind, err, tt.err)
+		}
+	}
+}
+
+func TestNsupdate(t *testing.T) {
+	defer func(p string) { os.Setenv("PATH", p) }(os.Getenv("PATH"))
+	dir := t.TempDir()
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	sent := filepath.Join(dir, "sent")
+	script := "#!/bin/sh\necho \"$@\" >" + sent + "\ncat >>" + sent + "\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "nsupdate"), []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	p := &nsupdateProvider{server: "ns1.example.com", key: "/etc/rndc.key"}
+	if _, err := p.Update("writer.db.example.com", "10.0.0.3"); err != nil {
+		t.Fatalf("Update() = %s", err)
+	}
+	b, _ := ioutil.ReadFile(sent)
+	want := "-k /etc/rndc.key\nserver ns1.example.com\nupdate delete writer.db.example.com A\nupdate delete writer.db.example.com AAAA\nupdate delete writer.db.example.com CNAME\n" +
+		"update add writer.db.example.com 30 A 10.0.0.3\nsend\n"
+	if string(b) != want {
+		t.Errorf("nsupdate received %q, want %q", b, want)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "nsupdate"), []byte("#!/bin/sh\necho 'update failed: REFUSED'\nexit 2\n"), 0700)
+	if _, err := p.Update("writer.db.example.com", "db3.example.com"); err == nil || err.Error() != "exit status 2: update failed: REFUSED" {
+		t.Errorf("Update() = %v, want the nsupdate failure", err)
+	}
+}
+
+func TestRoute53(t *testing.T) {
+	defer func(tr http.RoundTripper, v int64) { http.DefaultTransport, *dnsVerify = tr, v }(http.DefaultTransport, *dnsVerify)
+	defer func(id, secret string) {
+		os.Setenv("AWS_ACCESS_KEY_ID", id)
+		os.Setenv("AWS_SECRET_ACCESS_KEY", secret)
+	}(os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"))
+	os.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	var body, auth, status string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/2013-04-01/hostedzone/Z123/rrset":
+			b, _ := ioutil.ReadAll(r.Body)
+			body, auth = string(b), r.Header.Get("Authorization")
+			w.Write([]byte(`<ChangeResourceRecordSetsResponse><ChangeInfo><Id>/change/C1</Id><Status>PENDING</Status></ChangeInfo></ChangeResourceRecordSetsResponse>`))
+		case r.Method == "GET" && r.URL.Path == "/2013-04-01/change/C1":
+			w.Write([]byte(`<GetChangeResponse><ChangeInfo><Id>/change/C1</Id><Status>` + status + `</Status></ChangeInfo></GetChangeResponse>`))
+		default:
+			http.Error(w, "<Error><Message>unexpected request</Message></Error>", http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+	// The requests to the Route53 API reach the test server
+	http.DefaultTransport = &http.Transport{
+		DialTLSContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return tls.Dial("tcp", srv.Listener.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+		},
+	}
+	p := &route53Provider{zone: "/hostedzone/Z123"}
+	change, err := p.Update("writer.db.example.com", "db3.example.com")
+	if err != nil || change != "/change/C1" {
+		t.Fatalf("Update() = %q, %v, want /change/C1", change, err)
+	}
+	if strings.Contains(body, "<Action>UPSERT</Action><ResourceRecordSet><Name>writer.db.example.com</Name><Type>CNAME</Type><TTL>30</TTL><ResourceRecords><ResourceRecord><Value>db3.example.com.</Value>") == false {
+		t.Errorf("change request %q, want an UPSERT of the CNAME", body)
+	}
+	if strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") == false || strings.Contains(auth, "/us-east-1/route53/aws4_request, SignedHeaders=host;x-amz-date, Signature=") == false {
+		t.Errorf("authorization %q, want an AWS Signature Version 4 of route53", auth)
+	}
+	status = "INSYNC"
+	if err := p.Wait(change); err != nil {
+		t.Errorf("Wait() = %s", err)
+	}
+	status, *dnsVerify = "PENDING", 0
+	if err := p.Wait(change); err == nil || err.Error() != "change /change/C1 still PENDING after 0 seconds" {
+		t.Errorf("Wait() = %v, want the change still pending", err)
+	}
+	if _, err := (&route53Provider{zone: "Z999"}).Update("writer.db.example.com", "db3"); err == nil || strings.Contains(err.Error(), "400 Bad Request") == false {
+		t.Errorf("Update() in an unknown zone = %v, want the API error", err)
+	}
+}
+
+func TestFailoverDNS(t *testing.T) {
+	defer func(f, s, ds string, d bool) { *failover, *stateFile, *dnsServer, *dryRun = f, s, ds, d }(*failover, *stateFile, *dnsServer, *dryRun)
+	defer func() { dnsProvider = nil }()
+	*failover, *stateFile, *dnsServer = "force", "", ""
+	tests := []struct {
+		name    string
+		record  string
+		dryRun  bool
+		waitErr error
+		updates []string
+		log     string
+	}{
+		{"no record", "", false, nil, nil, ""},
+		{"moved", "writer.db.example.com", false, nil, []string{"writer.db.example.com=db3"}, "Pointing DNS record writer.db.example.com at db3 (new master)"},
+		{"change not applied", "writer.db.example.com", false, errors.New("change c1 still PENDING after 60 seconds"), []string{"writer.db.example.com=db3"}, "WARN : DNS record writer.db.example.com: change c1 still PENDING after 60 seconds"},
+		{"dry run", "writer.db.example.com", true, nil, nil, "DRY-RUN: would point DNS record writer.db.example.com at db3 with a TTL of 30 seconds"},
+	}
+	for _, tt := range tests {
+		simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 1 }))
+		current.master = current.findMaster(false)
+		p := &simDNS{waitErr: tt.waitErr}
+		dnsProvider, current.DNS, *dryRun = p, tt.record, tt.dryRun
+		var out bytes.Buffer
+		log.SetOutput(&out)
+		logWriter.out = &out
+		current.Failover(context.Background())
+		dnsWait()
+		log.SetOutput(ioutil.Discard)
+		logWriter.out = ioutil.Discard
+		if strings.Join(p.updates, ",") != strings.Join(tt.updates, ",") {
+			t.Errorf("%s: updates %q, want %q", tt.name, p.updates, tt.updates)
+		}
+		if strings.Contains(out.String(), tt.log) == false {
+			t.Errorf("%s: log does not contain %q:\n%s", tt.name, tt.log, out.String())
+		}
+	}
+}
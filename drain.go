@@ -0,0 +1,80 @@
+// drain.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+/* Counts the active client connections of a server, leaving out replication, system and replication-manager's own threads. An idle connection, such as one kept by a connection pool, is only counted while it holds an open transaction. */
+func (server *ServerMonitor) clientConnections() (int, error) {
+	rows, err := server.query("SELECT COUNT(*) AS n FROM information_schema.PROCESSLIST WHERE USER NOT IN ('system user', 'event_scheduler', ?) AND COMMAND NOT IN ('Binlog Dump', 'Binlog Dump GTID', 'Daemon') AND (COMMAND != 'Sleep' OR ID IN (SELECT trx_mysql_thread_id FROM information_schema.INNODB_TRX))", server.cluster.dbUser)
+	if err != nil || len(rows) == 0 {
+		return 0, err
+	}
+	return strconv.Atoi(rows[0]["n"])
+}
+
+/* Counts the writes running on the server for at least secs seconds, as statements other than SELECT or as open InnoDB transactions */
+func (server *ServerMonitor) longRunningWrites(secs int) (int, error) {
+	rows, err := server.query("SELECT SUM(ct) AS n FROM (SELECT COUNT(*) AS ct FROM information_schema.PROCESSLIST WHERE COMMAND = 'Query' AND TIME >= ? AND INFO NOT LIKE 'select%' UNION ALL SELECT COUNT(*) AS ct FROM information_schema.INNODB_TRX WHERE trx_started < CURRENT_TIMESTAMP - INTERVAL ? SECOND) w", secs, secs)
+	if err != nil || len(rows) == 0 {
+		return 0, err
+	}
+	return strconv.Atoi(rows[0]["n"])
+}
+
+/* Waits until the client connections of the server drop to the drain threshold, or the drain timeout expires. Applications are expected to disconnect once the virtual IP or the service registry moved away. */
+func (server *ServerMonitor) drain(ctx context.Context) {
+	if *dryRun {
+		alertLog("DRY-RUN: [%s] would wait up to %d seconds for client connections to drop to %d", server.URL, *drainTimeout, *drainThreshold)
+		return
+	}
+	deadline := time.Now().Add(time.Duration(*drainTimeout) * time.Second)
+	last := -1
+	for {
+		n, err := server.clientConnections()
+		if err != nil {
+			logprintf("WARN : Could not count connections on %s: %s", server.URL, err)
+			return
+		}
+		if n <= *drainThreshold {
+			logprintf("INFO : Connections drained on %s (%d left)", server.URL, n)
+			return
+		}
+		if time.Now().After(deadline) {
+			logprintf("WARN : %d connections still open on %s after %d seconds, proceeding", n, server.URL, *drainTimeout)
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if n != last {
+			logprintf("INFO : Waiting for %d connections to drain on %s", n, server.URL)
+			last = n
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+/* Handles the client queries running on the server for longer than the switchover maximum query time, which would block the flush and the read lock of the switchover. They are killed with their connection, so that their transaction is rolled back and its locks released, or the switchover is aborted. */
+func (server *ServerMonitor) killLongQueries() error {
+	rows, err := server.query("SELECT ID, USER, TIME, INFO FROM information_schema.PROCESSLIST WHERE USER NOT IN ('system user', 'event_scheduler', ?) AND COMMAND = 'Query' AND TIME > ? AND ID != CONNECTION_ID()", server.cluster.dbUser, *swMaxQueryTime)
+	if err != nil {
+		return err
+	}
+	if len(rows) > 0 && *swLongQuery == "abort" {
+		return errors.New(fmt.Sprintf("%d queries running for more than %d seconds", len(rows), *swMaxQueryTime))
+	}
+	for _, r := range rows {
+		logprintf("INFO : Killing query %s of user %s running for %s seconds on %s: %.80s", r["ID"], r["USER"], r["TIME"], server.URL, r["INFO"])
+		err = server.exec("KILL " + r["ID"])
+		if err != nil {
+			logprintf("WARN : Could not kill thread %s on %s: %s", r["ID"], server.URL, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+// drain_test.go
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDrain(t *testing.T) {
+	defer func(d int64, n int) { *drainTimeout, *drainThreshold = d, n }(*drainTimeout, *drainThreshold)
+	*drainTimeout, *drainThreshold = 1, 2
+	tests := []struct {
+		name string
+		rows []map[string]string
+		down bool
+		min  time.Duration
+		max  time.Duration
+	}{
+		{"drained", []map[string]string{{"n": "2"}}, false, 0, 500 * time.Millisecond},
+		{"timeout", []map[string]string{{"n": "5"}}, false, time.Second, 2 * time.Second},
+		{"count failed", nil, true, 0, 500 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		sims := simCluster(t, simTopology())
+		sims["db1:3306"].rows = map[string][]map[string]string{"SELECT COUNT(*) AS n FROM information_schema.PROCESSLIST": tt.rows}
+		sims["db1:3306"].down = tt.down
+		start := time.Now()
+		simServerByURL("db1:3306").drain(context.Background())
+		if d := time.Since(start); d < tt.min || d > tt.max {
+			t.Errorf("%s: drain() returned after %s, want between %s and %s", tt.name, d, tt.min, tt.max)
+		}
+	}
+}
+
+func TestClientConnections(t *testing.T) {
+	sims := simCluster(t, simTopology())
+	sims["db1:3306"].rows = map[string][]map[string]string{"SELECT COUNT(*) AS n FROM information_schema.PROCESSLIST": {{"n": "7"}}}
+	if n, err := simServerByURL("db1:3306").clientConnections(); n != 7 || err != nil {
+		t.Errorf("clientConnections() = %d, %v, want 7", n, err)
+	}
+}
+
+func TestSwitchoverLongQueries(t *testing.T) {
+	defer func(f, s, l string, m int64) { *failover, *stateFile, *swLongQuery, *swMaxQueryTime = f, s, l, m }(*failover, *stateFile, *swLongQuery, *swMaxQueryTime)
+	*failover, *stateFile = "force", ""
+	long := []map[string]string{{"ID": "42", "USER": "app", "TIME": "900", "INFO": "UPDATE orders SET archived = 1"}}
+	tests := []struct {
+		name    string
+		maxTime int64
+		action  string
+		rows    []map[string]string
+		killed  bool
+		master  string
+	}{
+		{"disabled", 0, "kill", long, false, "db3:3306"},
+		{"no long query", 60, "abort", nil, false, "db3:3306"},
+		{"long query killed", 60, "kill", long, true, "db3:3306"},
+		{"long query aborts", 60, "abort", long, false, ""},
+	}
+	for _, tt := range tests {
+		*swMaxQueryTime, *swLongQuery = tt.maxTime, tt.action
+		sims := simCluster(t, simTopology())
+		sims["db1:3306"].rows = map[string][]map[string]string{"SELECT ID, USER, TIME, INFO": tt.rows}
+		current.master = current.findMaster(true)
+		nmUrl, _ := current.Switchover(context.Background())
+		if nmUrl != tt.master {
+			t.Errorf("%s: Switchover() = %q, want %q", tt.name, nmUrl, tt.master)
+		}
+		if sims["db1:3306"].ran("KILL 42") != tt.killed {
+			t.Errorf("%s: query killed %v, want %v", tt.name, sims["db1:3306"].ran("KILL 42"), tt.killed)
+		}
+		// The switchover aborts before any change on the master
+		if tt.master == "" && len(sims["db1:3306"].execs) > 0 {
+			t.Errorf("%s: statements run on the master: %q", tt.name, sims["db1:3306"].execs)
+		}
+	}
+}
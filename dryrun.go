@@ -0,0 +1,73 @@
+// dryrun.go
+package main
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/tanji/mariadb-tools/dbhelper"
+	"regexp"
+)
+
+/* Passwords of the statements changing replication or accounts */
+var secretRe = regexp.MustCompile(`(?i)(master_password\s*=\s*|IDENTIFIED BY\s+)'(?:[^'\\]|\\.|'')*'`)
+
+/* Returns the statement with its passwords masked, to be logged */
+func redactStmt(stmt string) string {
+	return secretRe.ReplaceAllString(stmt, "${1}'****'")
+}
+
+/* Runs a state changing helper on the server, or only logs the equivalent statement in dry-run mode */
+func (sm *ServerMonitor) run(stmt string, f func(*sqlx.DB) error) error {
+	if sm.channelStmt(stmt) != stmt {
+		return sm.exec(stmt)
+	}
+	if *dryRun {
+		alertLog("DRY-RUN: [%s] %s", sm.URL, redactStmt(stmt))
+		return nil
+	}
+	if sm.db != nil {
+		return sm.db.Exec(stmt)
+	}
+	return f(sm.Conn)
+}
+
+/* Executes a state changing statement on the server, or only logs it in dry-run mode */
+func (sm *ServerMonitor) exec(stmt string) error {
+	stmt = sm.channelStmt(stmt)
+	if *dryRun {
+		alertLog("DRY-RUN: [%s] %s", sm.URL, redactStmt(stmt))
+		return nil
+	}
+	return sm.backend().Exec(stmt)
+}
+
+func setReadOnly(flag bool) func(*sqlx.DB) error {
+	return func(db *sqlx.DB) error {
+		return dbhelper.SetReadOnly(db, flag)
+	}
+}
+
+func resetSlave(all bool) func(*sqlx.DB) error {
+	return func(db *sqlx.DB) error {
+		return dbhelper.ResetSlave(db, all)
+	}
+}
+
+func killThreads(db *sqlx.DB) error {
+	dbhelper.KillThreads(db)
+	return nil
+}
+
+/* Virtual IP provider logging the moves instead of performing them */
+type dryVIP struct {
+	vip string
+}
+
+func (p dryVIP) Add(host string) error {
+	alertLog("DRY-RUN: would add virtual IP %s to %s", p.vip, host)
+	return nil
+}
+
+func (p dryVIP) Remove(host string) error {
+	alertLog("DRY-RUN: would remove virtual IP %s from %s", p.vip, host)
+	return nil
+}
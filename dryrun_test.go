@@ -0,0 +1,59 @@
+// dryrun_test.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestDryRunFailover(t *testing.T) {
+	defer func(f string, d bool) { *failover, *dryRun = f, d }(*failover, *dryRun)
+	*failover = "force"
+	sims := simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 1 }))
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	logWriter.out = &out
+	*dryRun = true
+	current.rplUser, current.rplPass = "repl", "s3cret"
+	current.master = current.findMaster(false)
+	// Nothing was promoted, the dry run reports an incomplete failover
+	if nmUrl, _ := current.Failover(context.Background()); nmUrl != "" {
+		t.Errorf("Failover() = %q in dry-run mode, want no promotion", nmUrl)
+	}
+	for url, sim := range sims {
+		if len(sim.execs) > 0 {
+			t.Errorf("statements run on %s in dry-run mode: %q", url, sim.execs)
+		}
+	}
+	if sims["db3:3306"].vars["READ_ONLY"] != "ON" || sims["db2:3306"].status.Master_Host != "db1" {
+		t.Error("topology changed in dry-run mode")
+	}
+	for _, want := range []string{"DRY-RUN: [db3:3306] STOP SLAVE", "DRY-RUN: [db3:3306] RESET SLAVE ALL", "DRY-RUN: [db3:3306] SET GLOBAL read_only=0", "DRY-RUN: [db2:3306] CHANGE MASTER TO master_host='db3'", "Dry run of failover complete"} {
+		if strings.Contains(out.String(), want) == false {
+			t.Errorf("dry-run output does not contain %q", want)
+		}
+	}
+	if strings.Contains(out.String(), "s3cret") {
+		t.Error("dry-run output contains the replication password")
+	}
+}
+
+func TestRedactStmt(t *testing.T) {
+	tests := []struct {
+		stmt string
+		want string
+	}{
+		{"CHANGE MASTER TO master_host='db3', master_port=3306, master_user='repl', master_password='s3cret', master_use_gtid=current_pos", "CHANGE MASTER TO master_host='db3', master_port=3306, master_user='repl', master_password='****', master_use_gtid=current_pos"},
+		{"ALTER USER 'repl'@'%' IDENTIFIED BY 'it''s s3cret'", "ALTER USER 'repl'@'%' IDENTIFIED BY '****'"},
+		{"CHANGE MASTER TO MASTER_PASSWORD = 'a\\'b'", "CHANGE MASTER TO MASTER_PASSWORD = '****'"},
+		{"STOP SLAVE", "STOP SLAVE"},
+	}
+	for _, tt := range tests {
+		if got := redactStmt(tt.stmt); got != tt.want {
+			t.Errorf("redactStmt(%q) = %q, want %q", tt.stmt, got, tt.want)
+		}
+	}
+}
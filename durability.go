@@ -0,0 +1,25 @@
+// durability.go
+package main
+
+import (
+	"fmt"
+)
+
+/* Lists the settings that make a candidate unsafe as a master: a crash could lose committed transactions, or leave the binary log out of sync with InnoDB, and slaves could not replicate the transactions it received as a slave. */
+func (candidate *ServerMonitor) durabilityIssues() []string {
+	v, err := candidate.getVariables("sync_binlog", "innodb_flush_log_at_trx_commit", "log_slave_updates")
+	if err != nil {
+		return []string{fmt.Sprintf("Could not read durability settings of %s: %s", candidate.URL, err)}
+	}
+	var issues []string
+	if v["sync_binlog"] == "0" {
+		issues = append(issues, fmt.Sprintf("%s has sync_binlog=0 and may lose binary log events on crash", candidate.URL))
+	}
+	if v["innodb_flush_log_at_trx_commit"] != "" && v["innodb_flush_log_at_trx_commit"] != "1" {
+		issues = append(issues, fmt.Sprintf("%s has innodb_flush_log_at_trx_commit=%s and may lose committed transactions on crash", candidate.URL, v["innodb_flush_log_at_trx_commit"]))
+	}
+	if v["log_slave_updates"] != "ON" {
+		issues = append(issues, fmt.Sprintf("%s has log_slave_updates=OFF, the transactions it replicated are missing from its binary log", candidate.URL))
+	}
+	return issues
+}
@@ -0,0 +1,79 @@
+// durability_test.go
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+/* Gives every server of the simulated topology durable settings */
+func simDurable(sims map[string]*simServer) {
+	for _, sim := range sims {
+		sim.vars["SYNC_BINLOG"], sim.vars["INNODB_FLUSH_LOG_AT_TRX_COMMIT"], sim.vars["LOG_SLAVE_UPDATES"] = "1", "1", "ON"
+	}
+}
+
+func TestDurabilityIssues(t *testing.T) {
+	tests := []struct {
+		name string
+		vars map[string]string
+		want []string
+	}{
+		{"durable", nil, nil},
+		{"sync_binlog off", map[string]string{"SYNC_BINLOG": "0"}, []string{"sync_binlog=0"}},
+		{"flush once per second", map[string]string{"INNODB_FLUSH_LOG_AT_TRX_COMMIT": "2"}, []string{"innodb_flush_log_at_trx_commit=2"}},
+		{"no slave updates", map[string]string{"LOG_SLAVE_UPDATES": "OFF"}, []string{"log_slave_updates=OFF"}},
+		{"all unsafe", map[string]string{"SYNC_BINLOG": "0", "INNODB_FLUSH_LOG_AT_TRX_COMMIT": "0", "LOG_SLAVE_UPDATES": "OFF"}, []string{"sync_binlog=0", "innodb_flush_log_at_trx_commit=0", "log_slave_updates=OFF"}},
+	}
+	for _, tt := range tests {
+		sims := simCluster(t, simTopology())
+		simDurable(sims)
+		for k, v := range tt.vars {
+			sims["db3:3306"].vars[k] = v
+		}
+		issues := simServerByURL("db3:3306").durabilityIssues()
+		if len(issues) != len(tt.want) {
+			t.Errorf("%s: issues %q, want %d", tt.name, issues, len(tt.want))
+			continue
+		}
+		for i, w := range tt.want {
+			if strings.HasPrefix(issues[i], "db3:3306 has "+w) == false {
+				t.Errorf("%s: issue %q, want %q", tt.name, issues[i], w)
+			}
+		}
+	}
+	// Settings that cannot be read are an issue of their own
+	sims := simCluster(t, simTopology())
+	sims["db3:3306"].down = true
+	if issues := simServerByURL("db3:3306").durabilityIssues(); len(issues) != 1 || strings.HasPrefix(issues[0], "Could not read durability settings of db3:3306") == false {
+		t.Errorf("issues of an unreachable server %q, want the read failure", issues)
+	}
+}
+
+func TestFailoverDurabilityGate(t *testing.T) {
+	defer func(f, d string, p bool) { *failover, *durabilityCheck, *forcePromote = f, d, p }(*failover, *durabilityCheck, *forcePromote)
+	*failover = "force"
+	tests := []struct {
+		mode  string
+		force bool
+		want  string
+	}{
+		{"off", false, "db3:3306"},
+		{"warn", false, "db3:3306"},
+		{"block", false, "db2:3306"},
+		{"block", true, "db3:3306"},
+	}
+	for _, tt := range tests {
+		sims := simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 1 }))
+		simDurable(sims)
+		// The most advanced slave does not sync its binary log
+		sims["db3:3306"].vars["SYNC_BINLOG"] = "0"
+		*durabilityCheck, *forcePromote = tt.mode, tt.force
+		current.master = current.findMaster(false)
+		nmUrl, err := current.Failover(context.Background())
+		if err != nil || nmUrl != tt.want {
+			t.Errorf("%s (force %v): Failover() = %q, %v, want %s", tt.mode, tt.force, nmUrl, err, tt.want)
+		}
+	}
+}
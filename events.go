@@ -0,0 +1,132 @@
+// events.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+/* Returns the events of the server in the given states, as quoted schema.name identifiers */
+func (sm *ServerMonitor) listEvents(states ...interface{}) ([]string, error) {
+	query := "SELECT EVENT_SCHEMA, EVENT_NAME FROM information_schema.EVENTS WHERE STATUS IN (?" + strings.Repeat(", ?", len(states)-1) + ")"
+	rows, err := sm.query(query, states...)
+	if err != nil {
+		return nil, err
+	}
+	var l []string
+	for _, r := range rows {
+		l = append(l, fmt.Sprintf("`%s`.`%s`", r["EVENT_SCHEMA"], r["EVENT_NAME"]))
+	}
+	return l, nil
+}
+
+/* Executes statements in a single session without writing them to the binary log, so that they do not replicate nor create errant transactions, or only logs them in dry-run mode */
+func (sm *ServerMonitor) execLocal(stmts ...string) error {
+	if *dryRun {
+		for _, stmt := range stmts {
+			alertLog("DRY-RUN: [%s] %s", sm.URL, redactStmt(stmt))
+		}
+		return nil
+	}
+	if sm.db != nil {
+		for _, stmt := range stmts {
+			err := sm.db.Exec(stmt)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	conn, err := sm.Conn.DB.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.ExecContext(context.Background(), "SET SESSION sql_log_bin=0")
+	if err != nil {
+		return err
+	}
+	for _, stmt := range stmts {
+		_, err = conn.ExecContext(context.Background(), stmt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/* Stops scheduled jobs on a master losing its role. With -migrate-events, its enabled events are also marked DISABLE ON SLAVE. */
+func (sm *ServerMonitor) stopEvents(logf func(string, ...interface{})) {
+	sm.cluster.stoppedEvents = nil
+	if sm.EventScheduler == "ON" {
+		logf("INFO : Stopping the event scheduler on %s", sm.URL)
+		err := sm.exec("SET GLOBAL event_scheduler=OFF")
+		if err != nil {
+			logf("WARN : Could not stop the event scheduler on %s: %s", sm.URL, err)
+		}
+	}
+	if *migrateEvents == false {
+		return
+	}
+	events, err := sm.listEvents("ENABLED")
+	if err != nil {
+		logf("WARN : Could not list events on %s: %s", sm.URL, err)
+		return
+	}
+	for _, ev := range events {
+		err = sm.execLocal("ALTER EVENT " + ev + " DISABLE ON SLAVE")
+		if err != nil {
+			logf("WARN : Could not disable event %s on %s: %s", ev, sm.URL, err)
+			continue
+		}
+		sm.cluster.stoppedEvents = append(sm.cluster.stoppedEvents, ev)
+		sm.cluster.checkpointFrozen()
+	}
+	if len(sm.cluster.stoppedEvents) > 0 {
+		logf("INFO : Disabled %d events on %s", len(sm.cluster.stoppedEvents), sm.URL)
+	}
+}
+
+/* Enables again the events and the scheduler stopped by stopEvents, when a switchover is aborted */
+func (sm *ServerMonitor) restoreEvents(logf func(string, ...interface{})) {
+	for _, ev := range sm.cluster.stoppedEvents {
+		err := sm.execLocal("ALTER EVENT " + ev + " ENABLE")
+		if err != nil {
+			logf("WARN : Could not enable event %s on %s: %s", ev, sm.URL, err)
+		}
+	}
+	sm.cluster.stoppedEvents = nil
+	if sm.EventScheduler == "ON" {
+		err := sm.exec("SET GLOBAL event_scheduler=ON")
+		if err != nil {
+			logf("WARN : Could not start the event scheduler on %s: %s", sm.URL, err)
+		}
+	}
+}
+
+/* Starts scheduled jobs on a promoted master when the old master ran them. With -migrate-events, the events replicated as DISABLE ON SLAVE are enabled first. */
+func (newMaster *ServerMonitor) startEvents(oldMaster *ServerMonitor, logf func(string, ...interface{})) {
+	if *migrateEvents {
+		events, err := newMaster.listEvents("SLAVESIDE_DISABLED", "REPLICA_SIDE_DISABLED")
+		if err != nil {
+			logf("WARN : Could not list events on %s: %s", newMaster.URL, err)
+		}
+		for _, ev := range events {
+			err = newMaster.execLocal("ALTER EVENT " + ev + " ENABLE")
+			if err != nil {
+				logf("WARN : Could not enable event %s on %s: %s", ev, newMaster.URL, err)
+			}
+		}
+		if len(events) > 0 {
+			logf("INFO : Enabled %d events on %s", len(events), newMaster.URL)
+		}
+	}
+	if oldMaster.EventScheduler == "ON" {
+		logf("INFO : Starting the event scheduler on %s", newMaster.URL)
+		err := newMaster.exec("SET GLOBAL event_scheduler=ON")
+		if err != nil {
+			logf("WARN : Could not start the event scheduler on %s: %s", newMaster.URL, err)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// events_test.go
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSwitchoverEvents(t *testing.T) {
+	defer func(f, s string, m bool) { *failover, *stateFile, *migrateEvents = f, s, m }(*failover, *stateFile, *migrateEvents)
+	*failover, *stateFile = "force", ""
+	tests := []struct {
+		name      string
+		scheduler string
+		migrate   bool
+		fail      string   // statement failing on the old master
+		oldMaster []string // statements expected on the old master
+		newMaster []string // statements expected on the new master
+		never     []string // statements not expected on the new master
+	}{
+		{"scheduler moved", "ON", false, "", []string{"SET GLOBAL event_scheduler=OFF"}, []string{"SET GLOBAL event_scheduler=ON"}, []string{"ALTER EVENT"}},
+		{"scheduler off", "OFF", false, "", nil, nil, []string{"SET GLOBAL event_scheduler", "ALTER EVENT"}},
+		{"events migrated", "ON", true, "", []string{"SET GLOBAL event_scheduler=OFF", "ALTER EVENT `app`.`purge` DISABLE ON SLAVE"}, []string{"ALTER EVENT `app`.`rollup` ENABLE", "SET GLOBAL event_scheduler=ON"}, nil},
+		{"switchover aborted", "ON", true, "FLUSH TABLES WITH READ LOCK", []string{"SET GLOBAL event_scheduler=OFF", "ALTER EVENT `app`.`purge` DISABLE ON SLAVE", "ALTER EVENT `app`.`purge` ENABLE", "SET GLOBAL event_scheduler=ON"}, nil, []string{"SET GLOBAL event_scheduler", "ALTER EVENT"}},
+	}
+	for _, tt := range tests {
+		*migrateEvents = tt.migrate
+		sims := simCluster(t, simTopology())
+		sims["db1:3306"].rows = map[string][]map[string]string{"SELECT EVENT_SCHEMA": {{"EVENT_SCHEMA": "app", "EVENT_NAME": "purge"}}}
+		sims["db3:3306"].rows = map[string][]map[string]string{"SELECT EVENT_SCHEMA": {{"EVENT_SCHEMA": "app", "EVENT_NAME": "rollup"}}}
+		sims["db1:3306"].fail = tt.fail
+		current.master = current.findMaster(true)
+		current.master.EventScheduler = tt.scheduler
+		current.Switchover(context.Background())
+		for _, stmt := range tt.oldMaster {
+			if sims["db1:3306"].ran(stmt) == false {
+				t.Errorf("%s: %q not run on the old master: %q", tt.name, stmt, sims["db1:3306"].execs)
+			}
+		}
+		for _, stmt := range tt.newMaster {
+			if sims["db3:3306"].ran(stmt) == false {
+				t.Errorf("%s: %q not run on the new master: %q", tt.name, stmt, sims["db3:3306"].execs)
+			}
+		}
+		for _, stmt := range tt.never {
+			if sims["db3:3306"].ran(stmt) {
+				t.Errorf("%s: %q run on the new master", tt.name, stmt)
+			}
+		}
+		if tt.fail != "" && len(current.stoppedEvents) != 0 {
+			t.Errorf("%s: stopped events %q left after the abort", tt.name, current.stoppedEvents)
+		}
+	}
+}
+
+func TestFailoverEvents(t *testing.T) {
+	defer func(f, s string, m bool) { *failover, *stateFile, *migrateEvents = f, s, m }(*failover, *stateFile, *migrateEvents)
+	*failover, *stateFile, *migrateEvents = "force", "", true
+	sims := simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 1 }))
+	sims["db3:3306"].rows = map[string][]map[string]string{"SELECT EVENT_SCHEMA": {{"EVENT_SCHEMA": "app", "EVENT_NAME": "rollup"}}}
+	current.master = current.findMaster(false)
+	// The scheduler of the failed master is known from its last refresh
+	current.master.EventScheduler = "ON"
+	if nmUrl, err := current.Failover(context.Background()); nmUrl != "db3:3306" {
+		t.Fatalf("Failover() = %q, %v, want db3:3306", nmUrl, err)
+	}
+	for _, stmt := range []string{"ALTER EVENT `app`.`rollup` ENABLE", "SET GLOBAL event_scheduler=ON"} {
+		if sims["db3:3306"].ran(stmt) == false {
+			t.Errorf("%q not run on the new master: %q", stmt, sims["db3:3306"].execs)
+		}
+	}
+}
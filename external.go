@@ -0,0 +1,204 @@
+// external.go
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"github.com/tanji/mariadb-tools/dbhelper"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* Server seen around the cluster that is not managed, e.g. a replica of another environment or a backup tool streaming binlogs */
+type ExternalNode struct {
+	Addr   string
+	Source string
+	Kind   string
+	Detail string
+	Probed time.Time
+}
+
+/* Bytes of the handshake following the server version: connection id, first part of the scramble, filler and lower capability flags */
+const handshakeFixed = 4 + 8 + 1 + 2
+
+var (
+	probeAllow []*net.IPNet
+	probeDeny  []*net.IPNet
+)
+
+/* Records a server that is not managed, seen in SHOW SLAVE HOSTS or in the processlist of the master */
+func (c *Cluster) noteExternal(addr string, source string) {
+	for _, n := range c.externalNodes {
+		if n.Addr == addr {
+			return
+		}
+	}
+	// Managed servers may be reported under another name than in the hosts list
+	host, port := splitHostPort(addr)
+	ip, err := dbhelper.CheckHostAddr(host)
+	if err == nil {
+		for _, s := range c.knownServers() {
+			if s.IP == ip && s.Port == port {
+				return
+			}
+		}
+	}
+	c.externalNodes = append(c.externalNodes, &ExternalNode{Addr: addr, Source: source, Kind: "unknown"})
+}
+
+/* Lists the binlog dump threads of the master coming from hosts that are not managed. The port of a dump thread is the client port of the connection, the node is assumed to listen on the port of the master like the rest of the cluster. */
+func (sm *ServerMonitor) externalDumpHosts() {
+	rows, err := sm.query("SELECT HOST FROM information_schema.PROCESSLIST WHERE COMMAND LIKE 'Binlog Dump%'")
+	if err != nil {
+		return
+	}
+	known := make(map[string]bool)
+	for _, s := range sm.cluster.knownServers() {
+		known[s.IP] = true
+		known[s.Host] = true
+	}
+	for _, r := range rows {
+		host, _, err := net.SplitHostPort(r["HOST"])
+		if err != nil {
+			host = r["HOST"]
+		}
+		if host == "" || known[host] {
+			continue
+		}
+		sm.cluster.noteExternal(net.JoinHostPort(host, sm.Port), "processlist")
+	}
+}
+
+/* Probes the least recently probed external node, if it has not been probed within the probe interval. Only one node is probed per call so that probing stays at a low rate. */
+func (c *Cluster) probeCheck() {
+	if *probeInterval <= 0 {
+		return
+	}
+	var next *ExternalNode
+	for _, n := range c.externalNodes {
+		if time.Since(n.Probed) < time.Duration(*probeInterval)*time.Second {
+			continue
+		}
+		if next == nil || n.Probed.Before(next.Probed) {
+			next = n
+		}
+	}
+	if next != nil {
+		next.probe(c)
+	}
+}
+
+/* Classifies the node. The server greeting is read before authenticating, so that credentials are only sent to servers speaking the MySQL protocol. Replicas of the current master are adopted as managed slaves. */
+func (n *ExternalNode) probe(c *Cluster) {
+	n.Probed = time.Now()
+	addr, err := resolveAddr(n.Addr)
+	if err != nil {
+		n.Kind, n.Detail = "unreachable", err.Error()
+		return
+	}
+	if inNets(probeDeny, addr) || (len(probeAllow) > 0 && inNets(probeAllow, addr) == false) {
+		n.Kind, n.Detail = "not probed", "outside the probe allowlist"
+		return
+	}
+	version, err := greeting(addr)
+	if err != nil {
+		if n.Source == "processlist" {
+			n.Kind, n.Detail = "binlog streamer", "no MySQL server on "+n.Addr+", likely a backup or binlog tool"
+		} else {
+			n.Kind, n.Detail = "unreachable", err.Error()
+		}
+		return
+	}
+	if strings.HasPrefix(version, "refused: ") {
+		n.Kind, n.Detail = "server", version
+		return
+	}
+	host, port := splitHostPort(addr)
+	conn, err := c.dbConnect(host, port)
+	if err != nil {
+		n.Kind, n.Detail = "server", version+", login refused"
+		return
+	}
+	defer conn.Close()
+	rows, err := queryRows(conn, "SHOW SLAVE STATUS")
+	if err != nil || len(rows) == 0 {
+		n.Kind, n.Detail = "standalone", version
+		return
+	}
+	r := rows[0]
+	if c.master != nil && r["Master_Server_Id"] == strconv.FormatUint(uint64(c.master.ServerId), 10) {
+		n.adopt(c)
+		return
+	}
+	n.Kind, n.Detail = "foreign replica", version+", replicates from "+r["Master_Host"]+", another environment?"
+}
+
+/* Manages a replica of the current master found by probing */
+func (n *ExternalNode) adopt(c *Cluster) {
+	s, err := c.newServerMonitor(n.Addr)
+	if err != nil || s.refresh() != nil || s.UsingGtid == "" {
+		n.Kind, n.Detail = "unmanaged replica", "replicates from the master but cannot be monitored"
+		return
+	}
+	logprintf("INFO : Discovered new slave %s", s.label())
+	s.setState(STATE_SLAVE)
+	c.servers = append(c.servers, s)
+	c.slaves = append(c.slaves, s)
+	c.hostList = append(c.hostList, n.Addr)
+	for k, e := range c.externalNodes {
+		if e == n {
+			c.externalNodes = append(c.externalNodes[:k], c.externalNodes[k+1:]...)
+			break
+		}
+	}
+}
+
+/* Returns the address with its host name resolved to an IP, so that the probe allowlist applies to the address actually probed */
+func resolveAddr(addr string) (string, error) {
+	host, port := splitHostPort(addr)
+	if net.ParseIP(host) != nil {
+		return net.JoinHostPort(host, port), nil
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return "", err
+	}
+	if len(ips) == 0 {
+		return "", errors.New("no address for " + host)
+	}
+	return net.JoinHostPort(ips[0].String(), port), nil
+}
+
+/* Reads the version from the handshake packet a MySQL server sends on connection. A greeting too short to hold the connection id, the scramble and the capabilities after the version is refused, the driver failing on it otherwise. */
+func greeting(addr string) (string, error) {
+	conn, err := net.DialTimeout("tcp", addr, time.Duration(*connectTimeout)*time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	r := bufio.NewReader(conn)
+	head := make([]byte, 4)
+	if _, err = io.ReadFull(r, head); err != nil {
+		return "", err
+	}
+	payload := make([]byte, int(head[0])|int(head[1])<<8|int(head[2])<<16)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return "", err
+	}
+	if len(payload) > 3 && payload[0] == 0xff {
+		return "refused: " + string(payload[3:]), nil
+	}
+	if len(payload) < 2 || payload[0] != 10 {
+		return "", errors.New("malformed server greeting")
+	}
+	end := bytes.IndexByte(payload[1:], 0)
+	if end < 0 || len(payload) < 1+end+1+handshakeFixed {
+		return "", errors.New("malformed server greeting")
+	}
+	return string(payload[1 : 1+end]), nil
+}
@@ -0,0 +1,121 @@
+// external_test.go
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+/* Server sending the handshake payload to every connection, then closing it */
+func simGreeter(t *testing.T, payload []byte) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write(append([]byte{byte(len(payload)), byte(len(payload) >> 8), byte(len(payload) >> 16), 0}, payload...))
+			conn.Close()
+		}
+	}()
+	return l.Addr().String()
+}
+
+/* Returns a complete protocol 10 handshake of the server version, offering the native password authentication without TLS */
+func simHandshake(version string) []byte {
+	p := append([]byte{10}, version+"\x00"...)
+	p = append(p, 1, 0, 0, 0)                             // connection id
+	p = append(p, "abcdefgh"...)                          // scramble, first part
+	p = append(p, 0, 0xff, 0xf7, 8, 2, 0, 0xff, 0x81, 21) // filler, capabilities, charset, status, capabilities, scramble length
+	p = append(p, make([]byte, 10)...)
+	p = append(p, "ijklmnopqrst\x00"...)
+	return append(p, "mysql_native_password\x00"...)
+}
+
+/* Address of a closed port */
+func simClosed(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	return l.Addr().String()
+}
+
+func TestProbe(t *testing.T) {
+	defer func() { probeAllow, probeDeny = nil, nil }()
+	mariadb := simGreeter(t, simHandshake("10.1.14-MariaDB-log"))
+	truncated := simGreeter(t, append([]byte{10}, "10.1.14-MariaDB-log\x00\x01\x00"...))
+	refused := simGreeter(t, append([]byte{0xff, 0x6a, 0x04}, "Host '127.0.0.1' is not allowed to connect"...))
+	closed := simClosed(t)
+	denied, _ := parseNets("127.0.0.0/8")
+	tests := []struct {
+		name   string
+		addr   string
+		source string
+		deny   []*net.IPNet
+		kind   string
+		detail string
+	}{
+		{"MySQL server", mariadb, "slave-hosts", nil, "server", "10.1.14-MariaDB-log, login refused"},
+		{"truncated greeting", truncated, "slave-hosts", nil, "unreachable", "malformed server greeting"},
+		{"dump thread with truncated greeting", truncated, "processlist", nil, "binlog streamer", "likely a backup or binlog tool"},
+		{"host refused", refused, "slave-hosts", nil, "server", "refused: Host '127.0.0.1' is not allowed to connect"},
+		{"dump thread without server", closed, "processlist", nil, "binlog streamer", "likely a backup or binlog tool"},
+		{"slave host down", closed, "slave-hosts", nil, "unreachable", ""},
+		{"denied network", mariadb, "slave-hosts", denied, "not probed", "outside the probe allowlist"},
+	}
+	simCluster(t, simTopology())
+	current.master = current.findMaster(true)
+	for _, tt := range tests {
+		probeDeny = tt.deny
+		n := &ExternalNode{Addr: tt.addr, Source: tt.source, Kind: "unknown"}
+		n.probe(current)
+		if n.Kind != tt.kind || strings.Contains(n.Detail, tt.detail) == false {
+			t.Errorf("%s: probed as %s (%s), want %s (%s)", tt.name, n.Kind, n.Detail, tt.kind, tt.detail)
+		}
+	}
+}
+
+func TestProbeRate(t *testing.T) {
+	defer func(i int64) { *probeInterval = i }(*probeInterval)
+	*probeInterval = 300
+	a, b := simClosed(t), simClosed(t)
+	c := &Cluster{}
+	c.externalNodes = []*ExternalNode{
+		{Addr: a, Source: "slave-hosts", Kind: "unknown", Probed: time.Now().Add(-10 * time.Minute)},
+		{Addr: b, Source: "slave-hosts", Kind: "unknown", Probed: time.Now().Add(-20 * time.Minute)},
+	}
+	// One node per check, the least recently probed first, then none until the interval elapses
+	want := [][]string{{"unknown", "unreachable"}, {"unreachable", "unreachable"}}
+	for i, w := range want {
+		c.probeCheck()
+		if c.externalNodes[0].Kind != w[0] || c.externalNodes[1].Kind != w[1] {
+			t.Errorf("check %d: nodes %s and %s, want %q", i+1, c.externalNodes[0].Kind, c.externalNodes[1].Kind, w)
+		}
+	}
+	probed := c.externalNodes[0].Probed
+	c.probeCheck()
+	if c.externalNodes[0].Probed != probed {
+		t.Error("node probed again within the probe interval")
+	}
+}
+
+func TestAdoptReplica(t *testing.T) {
+	simCluster(t, append(simTopology(), simSpec{url: "db4:3306", id: 4, master: 1, gtid: "0-1-118"}))
+	// db4 replicates from the master but is missing from the hosts list
+	current.servers, current.slaves, current.hostList = current.servers[:3], current.slaves[:2], current.hostList[:3]
+	current.master = current.findMaster(true)
+	n := &ExternalNode{Addr: "db4:3306", Source: "slave-hosts", Kind: "unknown"}
+	current.externalNodes = []*ExternalNode{n}
+	n.adopt(current)
+	if len(current.externalNodes) != 0 || len(current.slaves) != 3 || current.slaves[2].URL != "db4:3306" || current.slaves[2].State != STATE_SLAVE || contains(current.hostList, "db4:3306") == false {
+		t.Errorf("db4:3306 not adopted as a slave, external nodes %d, slaves %d", len(current.externalNodes), len(current.slaves))
+	}
+}
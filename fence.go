@@ -0,0 +1,38 @@
+// fence.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+/* Fences the failed master before a slave is promoted, so that it cannot come back writable next to the new master. The fence command, e.g. an ssh shutdown, an IPMI power-off or a cloud API stop, must succeed and the server must stop answering within the fence timeout, otherwise the failover is aborted. */
+func (master *ServerMonitor) fence() error {
+	if *fenceScript == "" {
+		return nil
+	}
+	if *dryRun {
+		alertLog("DRY-RUN: would fence %s with %s", master.URL, *fenceScript)
+		return nil
+	}
+	timeout := time.Duration(*fenceTimeout) * time.Second
+	log.Printf("INFO : Fencing failed master %s with %s", master.URL, *fenceScript)
+	start := time.Now()
+	out, err := Hook{Event: "fence", Path: *fenceScript, Timeout: timeout}.run(hookContext{Cluster: master.cluster.clusterName(), OldMaster: master})
+	if err != nil {
+		return errors.New(fmt.Sprintf("fence command failed: %s %s", err, strings.TrimSpace(string(out))))
+	}
+	log.Printf("INFO : Fence command complete: %s", strings.TrimSpace(string(out)))
+	// A power-off or a stop requested through an API may take effect after the command returns
+	for (master.Conn != nil || master.db != nil) && master.backend().Ping() == nil {
+		if time.Since(start) > timeout {
+			return errors.New(fmt.Sprintf("%s still answers %s after the fence command", master.URL, timeout))
+		}
+		time.Sleep(time.Second)
+	}
+	audit("Fenced failed master %s with %s", master.URL, *fenceScript)
+	return nil
+}
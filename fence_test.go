@@ -0,0 +1,69 @@
+// fence_test.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFailoverFence(t *testing.T) {
+	defer func(f, s, fs string, ft int64, d bool) {
+		*failover, *stateFile, *fenceScript, *fenceTimeout, *dryRun = f, s, fs, ft, d
+	}(*failover, *stateFile, *fenceScript, *fenceTimeout, *dryRun)
+	*failover, *stateFile, *fenceTimeout = "force", "", 1
+	dir := t.TempDir()
+	fenced := filepath.Join(dir, "fenced")
+	tests := []struct {
+		name     string
+		script   string // body of the fence script, none if empty
+		answers  bool   // the failed master still answers after the fence command
+		dryRun   bool
+		promoted string
+		fenced   string // arguments of the fence script, not run if empty
+		log      string
+	}{
+		{"no fencing", "", false, false, "db3:3306", "", ""},
+		{"fenced", "echo \"$@\" >" + fenced + "\necho powered off", false, false, "db3:3306", "db1", "Fence command complete: powered off"},
+		{"fence command failed", "echo \"$@\" >" + fenced + "\necho power-off refused\nexit 1", false, false, "", "db1", "could not fence the failed master, fence command failed: exit status 1 power-off refused"},
+		{"still answering", "echo \"$@\" >" + fenced, true, false, "", "db1", "could not fence the failed master, db1:3306 still answers 1s after the fence command"},
+		{"dry run", "echo \"$@\" >" + fenced, false, true, "", "", "DRY-RUN: would fence db1:3306 with "},
+	}
+	for _, tt := range tests {
+		*fenceScript, *dryRun = "", tt.dryRun
+		if tt.script != "" {
+			*fenceScript = filepath.Join(dir, "fence")
+			if err := ioutil.WriteFile(*fenceScript, []byte("#!/bin/sh\n"+tt.script+"\n"), 0700); err != nil {
+				t.Fatal(err)
+			}
+		}
+		sims := simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 1 }))
+		current.master = current.findMaster(false)
+		// The master is failed for the monitor, but does not stop answering by itself
+		sims["db1:3306"].down = tt.answers == false
+		var out bytes.Buffer
+		log.SetOutput(&out)
+		logWriter.out = &out
+		nmUrl, _ := current.Failover(context.Background())
+		log.SetOutput(ioutil.Discard)
+		logWriter.out = ioutil.Discard
+		if nmUrl != tt.promoted {
+			t.Errorf("%s: Failover() promoted %q, want %q", tt.name, nmUrl, tt.promoted)
+		}
+		b, _ := ioutil.ReadFile(fenced)
+		if strings.TrimSpace(string(b)) != tt.fenced {
+			t.Errorf("%s: fenced %q, want %q", tt.name, b, tt.fenced)
+		}
+		if strings.Contains(out.String(), tt.log) == false {
+			t.Errorf("%s: log does not contain %q:\n%s", tt.name, tt.log, out.String())
+		}
+		if tt.promoted == "" && sims["db2:3306"].ran("CHANGE MASTER") {
+			t.Errorf("%s: db2 repointed without fencing", tt.name)
+		}
+		ioutil.WriteFile(fenced, nil, 0600)
+	}
+}
@@ -0,0 +1,35 @@
+// flap.go
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+/* Start of the process, automatic failovers being counted from it */
+var processStart = time.Now()
+
+/* Checks the automatic failover limits against the failover history of the cluster: the number of automatic failovers since the process started, and the time since the last failover, so that a flapping master cannot trigger a series of promotions back and forth */
+func (c *Cluster) failoverLimits() (bool, string) {
+	var count int
+	var last time.Time
+	for _, e := range c.stateData.History {
+		if e.Type != "failover" || e.Result != "complete" {
+			continue
+		}
+		if e.Trigger == "automation" && e.Time.After(processStart) {
+			count++
+		}
+		if e.Time.After(last) {
+			last = e.Time
+		}
+	}
+	if *failoverLimit > 0 && count >= *failoverLimit {
+		return false, fmt.Sprintf("%d automatic failovers already performed, reaching the failover limit", count)
+	}
+	cooldown := time.Duration(*failoverTime) * time.Second
+	if cooldown > 0 && last.IsZero() == false && time.Since(last) < cooldown {
+		return false, fmt.Sprintf("last failover at %s, less than %s ago", last.Format("2006-01-02 15:04:05"), cooldown)
+	}
+	return true, ""
+}
@@ -0,0 +1,59 @@
+// flap_test.go
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFailoverLimits(t *testing.T) {
+	defer func(l int, c int64, s time.Time) { *failoverLimit, *failoverTime, processStart = l, c, s }(*failoverLimit, *failoverTime, processStart)
+	processStart = time.Now().Add(-24 * time.Hour)
+	auto := func(ago time.Duration) FailoverEvent {
+		return FailoverEvent{Time: time.Now().Add(-ago), Type: "failover", Trigger: "automation", Result: "complete"}
+	}
+	tests := []struct {
+		name     string
+		limit    int
+		cooldown int64
+		history  []FailoverEvent
+		reason   string // prefix of the denial, empty when allowed
+	}{
+		{"no limits", 0, 0, []FailoverEvent{auto(time.Minute), auto(time.Hour)}, ""},
+		{"under the limit", 2, 0, []FailoverEvent{auto(time.Hour)}, ""},
+		{"limit reached", 2, 0, []FailoverEvent{auto(time.Minute), auto(time.Hour)}, "2 automatic failovers already performed"},
+		{"failovers before the start", 1, 0, []FailoverEvent{auto(48 * time.Hour)}, ""},
+		{"manual failovers", 1, 0, []FailoverEvent{{Time: time.Now(), Type: "failover", Trigger: "user", Result: "complete"}}, ""},
+		{"aborted failovers", 1, 0, []FailoverEvent{{Time: time.Now(), Type: "failover", Trigger: "automation", Result: "aborted"}}, ""},
+		{"switchovers", 1, 3600, []FailoverEvent{{Time: time.Now(), Type: "switchover", Trigger: "user", Result: "complete"}}, ""},
+		{"inside the cooldown", 0, 3600, []FailoverEvent{auto(time.Minute)}, "last failover at"},
+		{"manual failover inside the cooldown", 0, 3600, []FailoverEvent{{Time: time.Now().Add(-time.Minute), Type: "failover", Trigger: "user", Result: "complete"}}, "last failover at"},
+		{"after the cooldown", 0, 3600, []FailoverEvent{auto(2 * time.Hour)}, ""},
+	}
+	for _, tt := range tests {
+		*failoverLimit, *failoverTime = tt.limit, tt.cooldown
+		c := &Cluster{}
+		c.stateData.History = tt.history
+		ok, reason := c.failoverLimits()
+		if ok != (tt.reason == "") || strings.HasPrefix(reason, tt.reason) == false {
+			t.Errorf("%s: failoverLimits() = %v, %q, want %q", tt.name, ok, reason, tt.reason)
+		}
+	}
+}
+
+func TestFailoverCooldown(t *testing.T) {
+	defer func(c int64) { *failoverTime = c }(*failoverTime)
+	*failoverTime = 3600
+	// The master flaps back after an automatic failover and fails again: the cooldown blocks the second promotion
+	simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 1 }))
+	current.master = current.findMaster(false)
+	current.stateData.History = []FailoverEvent{{Time: time.Now().Add(-time.Minute), Type: "failover", Trigger: "automation", Result: "complete"}}
+	if current.arbitrate() || current.arbitrationDenied == false {
+		t.Errorf("arbitrate() granted a failover inside the cooldown")
+	}
+	current.stateData.History[0].Time = time.Now().Add(-2 * time.Hour)
+	if current.arbitrate() == false || current.arbitrationDenied {
+		t.Errorf("arbitrate() denied a failover after the cooldown")
+	}
+}
@@ -0,0 +1,118 @@
+// galera.go
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/tanji/mariadb-tools/dbhelper"
+	"log"
+	"strconv"
+)
+
+/* Reads the Galera state of the server when wsrep is enabled, so that nodes of a Galera cluster replicating asynchronously to or from standalone servers are recognized */
+func (sm *ServerMonitor) readWsrep(sv map[string]string) {
+	sm.WsrepCluster, sm.WsrepState, sm.WsrepStatus, sm.WsrepSize = "", "", "", 0
+	if sv["WSREP_ON"] != "ON" {
+		return
+	}
+	rows, err := sm.query("SELECT UPPER(VARIABLE_NAME) AS name, VARIABLE_VALUE AS value FROM information_schema.GLOBAL_STATUS WHERE VARIABLE_NAME IN ('wsrep_cluster_state_uuid', 'wsrep_local_state_comment', 'wsrep_cluster_status', 'wsrep_cluster_size')")
+	if err != nil {
+		return
+	}
+	for _, r := range rows {
+		switch r["name"] {
+		case "WSREP_CLUSTER_STATE_UUID":
+			sm.WsrepCluster = r["value"]
+		case "WSREP_LOCAL_STATE_COMMENT":
+			sm.WsrepState = r["value"]
+		case "WSREP_CLUSTER_STATUS":
+			sm.WsrepStatus = r["value"]
+		case "WSREP_CLUSTER_SIZE":
+			sm.WsrepSize, _ = strconv.Atoi(r["value"])
+		}
+	}
+}
+
+/* Returns true if the server is a Galera node */
+func (sm *ServerMonitor) isGalera() bool {
+	return sm.WsrepCluster != ""
+}
+
+/* Returns true if the server is a Galera node able to take writes, synced in the primary component */
+func (sm *ServerMonitor) galeraSynced() bool {
+	return sm.WsrepState == "Synced" && sm.WsrepStatus == "Primary"
+}
+
+/* Returns the Galera state line of the console, empty for standalone servers */
+func (sm *ServerMonitor) galeraLabel() string {
+	if sm.isGalera() == false || sm.State == STATE_FAILED {
+		return ""
+	}
+	return fmt.Sprintf("Galera node %s, %s component of %d nodes", sm.WsrepState, sm.WsrepStatus, sm.WsrepSize)
+}
+
+/* Returns a reachable node of the Galera cluster of the failed master, synced in the primary component, or nil if the master is not a Galera node or none of its peers is monitored */
+func (master *ServerMonitor) galeraPeer() *ServerMonitor {
+	if master.isGalera() == false {
+		return nil
+	}
+	for _, s := range master.cluster.servers {
+		if s.URL == master.URL || s.WsrepCluster != master.WsrepCluster || s.inMaintenance() {
+			continue
+		}
+		// Peers outside of the asynchronous topology have no slave status
+		if err := s.refresh(); (err == nil || err == sql.ErrNoRows) && s.galeraSynced() {
+			return s
+		}
+	}
+	return nil
+}
+
+/* Fails over the asynchronous leg of a Galera cluster or replication group: the slaves of the failed node are repointed with GTID to a peer of its group, which already has its writes, instead of promoting a slave. The group is left untouched. Returns the URL of the peer. */
+func (master *ServerMonitor) peerFailover(peer *ServerMonitor) string {
+	log.Printf("INFO : Master %s is a node of a %s, moving its slaves to the node %s", master.URL, master.peerGroup(), peer.URL)
+	newMaster, err := master.cluster.newServerMonitor(peer.URL)
+	if err != nil {
+		log.Printf("ERROR: %s. Aborting failover", err)
+		return ""
+	}
+	err = runHooks(HOOK_PRE_FAILOVER, hookContext{Cluster: master.cluster.clusterName(), OldMaster: master, NewMaster: newMaster})
+	if err != nil {
+		log.Printf("ERROR: %s. Aborting failover", err)
+		return ""
+	}
+	if master.cluster.vip != nil {
+		log.Printf("INFO : Moving virtual IP %s from %s to %s", master.cluster.Vip, master.Host, newMaster.Host)
+		master.cluster.vip.Remove(master.Host)
+		err = master.cluster.vip.Add(newMaster.Host)
+		if err != nil {
+			log.Printf("ERROR: Could not add virtual IP to new master: %s", err)
+		}
+	}
+	master.cluster.dnsMove(newMaster, log.Printf)
+	cm := "CHANGE MASTER TO master_host='" + newMaster.IP + "', master_port=" + newMaster.Port + ", master_user='" + master.cluster.rplUser + "', master_password='" + master.cluster.rplPass + "'"
+	for _, sl := range master.cluster.slaves {
+		if sl.usesPositions() {
+			log.Printf("ERROR: Slave %s does not use GTID and must be repointed to %s manually", sl.URL, newMaster.URL)
+			continue
+		}
+		log.Printf("INFO : Change master on slave %s", sl.URL)
+		sl.run("STOP SLAVE", dbhelper.StopSlave)
+		err = sl.exec(cm + sl.gtidMasterOpt("slave_pos"))
+		if err == nil {
+			err = sl.run("START SLAVE", dbhelper.StartSlave)
+		}
+		if err != nil {
+			log.Printf("ERROR: Could not repoint slave %s, %s", sl.URL, err)
+		}
+	}
+	runHooks(HOOK_POST_FAILOVER, hookContext{Cluster: master.cluster.clusterName(), OldMaster: master, NewMaster: newMaster})
+	if *dryRun {
+		log.Println("INFO : Dry run of failover complete, nothing was changed")
+		return ""
+	}
+	log.Println("INFO : Failover complete")
+	opRecord.complete(newMaster)
+	master.cluster.alert(ALERT_FAILOVER_DONE, newMaster.URL, "Failover complete, slaves of %s now replicate from %s of its %s", master.label(), newMaster.label(), master.peerGroup())
+	return newMaster.URL
+}
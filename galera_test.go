@@ -0,0 +1,97 @@
+// galera_test.go
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+const simWsrepQuery = "SELECT UPPER(VARIABLE_NAME) AS name, VARIABLE_VALUE AS value FROM information_schema.GLOBAL_STATUS"
+
+/* Builds a hybrid topology: the Galera nodes g1 and g2, g1 being the master of the asynchronous slaves db3 and db4. The state of g2 is given as its local state comment. */
+func simGalera(t *testing.T, peerState string) map[string]*simServer {
+	sims := simCluster(t, []simSpec{
+		{url: "g1:3306", id: 1, gtid: "0-1-120"},
+		{url: "g2:3306", id: 2, gtid: "0-1-120"},
+		{url: "db3:3306", id: 3, master: 1, gtid: "0-1-118"},
+		{url: "db4:3306", id: 4, master: 1, gtid: "0-1-115"},
+	})
+	for url, state := range map[string]string{"g1:3306": "Synced", "g2:3306": peerState} {
+		sims[url].vars["WSREP_ON"] = "ON"
+		sims[url].rows = map[string][]map[string]string{simWsrepQuery: {
+			{"name": "WSREP_CLUSTER_STATE_UUID", "value": "6c5e1c8a-0f3e-11ef-9a4b-0242ac120002"},
+			{"name": "WSREP_LOCAL_STATE_COMMENT", "value": state},
+			{"name": "WSREP_CLUSTER_STATUS", "value": "Primary"},
+			{"name": "WSREP_CLUSTER_SIZE", "value": "2"},
+		}}
+		simServerByURL(url).refresh()
+	}
+	return sims
+}
+
+func TestReadWsrep(t *testing.T) {
+	simGalera(t, "Donor/Desynced")
+	tests := []struct {
+		url    string
+		galera bool
+		synced bool
+		label  string
+	}{
+		{"g1:3306", true, true, "Galera node Synced, Primary component of 2 nodes"},
+		{"g2:3306", true, false, "Galera node Donor/Desynced, Primary component of 2 nodes"},
+		{"db3:3306", false, false, ""},
+	}
+	for _, tt := range tests {
+		sm := simServerByURL(tt.url)
+		if sm.isGalera() != tt.galera || sm.galeraSynced() != tt.synced || sm.galeraLabel() != tt.label {
+			t.Errorf("%s: galera %v, synced %v, label %q, want %v, %v, %q", tt.url, sm.isGalera(), sm.galeraSynced(), sm.galeraLabel(), tt.galera, tt.synced, tt.label)
+		}
+	}
+}
+
+func TestGaleraFailover(t *testing.T) {
+	defer func(f, s string) { *failover, *stateFile = f, s }(*failover, *stateFile)
+	*failover, *stateFile = "force", ""
+	tests := []struct {
+		name        string
+		peerState   string
+		maintenance bool
+		want        string
+	}{
+		{"synced peer", "Synced", false, "g2:3306"},
+		{"peer not synced", "Donor/Desynced", false, "db3:3306"},
+		{"peer in maintenance", "Synced", true, "db3:3306"},
+	}
+	for _, tt := range tests {
+		sims := simGalera(t, tt.peerState)
+		current.master = current.findMaster(true)
+		if current.master == nil || current.master.URL != "g1:3306" {
+			t.Fatalf("%s: master %v, want g1:3306", tt.name, current.master)
+		}
+		if tt.maintenance {
+			current.stateData.Maintenance = []string{"g2:3306"}
+		}
+		sims["g1:3306"].down = true
+		current.master.State = STATE_FAILED
+		nmUrl, err := current.Failover(context.Background())
+		if nmUrl != tt.want {
+			t.Errorf("%s: Failover() = %q, %v, want %s", tt.name, nmUrl, err, tt.want)
+			continue
+		}
+		if tt.want != "g2:3306" {
+			continue
+		}
+		// The slaves follow the peer, which already has the writes of the failed node, and the group is left untouched
+		for _, url := range []string{"db3:3306", "db4:3306"} {
+			if sims[url].ran("CHANGE MASTER TO master_host='g2', master_port=3306") == false || sims[url].status.Slave_IO_Running != "Yes" {
+				t.Errorf("%s: %s not repointed to the peer: %q", tt.name, url, sims[url].execs)
+			}
+			if sims[url].ran("RESET SLAVE") {
+				t.Errorf("%s: slave %s promoted", tt.name, url)
+			}
+		}
+		if len(sims["g2:3306"].execs) != 0 {
+			t.Errorf("%s: peer changed: %q", tt.name, sims["g2:3306"].execs)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// group.go
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+)
+
+/* Reads the Group Replication membership of the server from performance_schema.replication_group_members with -group-replication, so that members of a MySQL group replicating asynchronously to standalone replicas are recognized */
+func (sm *ServerMonitor) readGroup(sv map[string]string) {
+	sm.GroupName, sm.GroupRole, sm.GroupState, sm.GroupOnline, sm.GroupSize = "", "", "", 0, 0
+	if *groupRepl == false || sv["GROUP_REPLICATION_GROUP_NAME"] == "" {
+		return
+	}
+	rows, err := sm.query("SELECT MEMBER_ID, MEMBER_STATE, MEMBER_ROLE FROM performance_schema.replication_group_members")
+	if err != nil {
+		// MySQL 5.7 reports the primary in a status variable
+		var primary string
+		status, _ := sm.query("SELECT VARIABLE_VALUE FROM performance_schema.global_status WHERE VARIABLE_NAME='group_replication_primary_member'")
+		if len(status) > 0 {
+			primary = status[0]["VARIABLE_VALUE"]
+		}
+		rows, err = sm.query("SELECT MEMBER_ID, MEMBER_STATE, IF(MEMBER_ID=?, 'PRIMARY', 'SECONDARY') AS MEMBER_ROLE FROM performance_schema.replication_group_members", primary)
+		if err != nil {
+			return
+		}
+	}
+	for _, r := range rows {
+		if r["MEMBER_STATE"] == "ONLINE" {
+			sm.GroupOnline++
+		}
+		if r["MEMBER_ID"] == sv["SERVER_UUID"] {
+			sm.GroupRole, sm.GroupState = r["MEMBER_ROLE"], r["MEMBER_STATE"]
+		}
+	}
+	sm.GroupName, sm.GroupSize = sv["GROUP_REPLICATION_GROUP_NAME"], len(rows)
+}
+
+/* Returns true if the server is a Group Replication member */
+func (sm *ServerMonitor) isGroupMember() bool {
+	return sm.GroupName != "" && sm.GroupState != ""
+}
+
+/* Returns true if the server is the online primary of its group */
+func (sm *ServerMonitor) groupPrimary() bool {
+	return sm.GroupRole == "PRIMARY" && sm.GroupState == "ONLINE"
+}
+
+/* Returns the Group Replication line of the console, empty for servers outside of a group */
+func (sm *ServerMonitor) groupLabel() string {
+	if sm.isGroupMember() == false || sm.State == STATE_FAILED {
+		return ""
+	}
+	return fmt.Sprintf("Group Replication %s %s, %d of %d members online", sm.GroupRole, sm.GroupState, sm.GroupOnline, sm.GroupSize)
+}
+
+/* Returns the member the group elected primary after the failure of the master, or nil if the master was not a group member or the new primary is not monitored yet */
+func (master *ServerMonitor) groupPeer() *ServerMonitor {
+	if master.GroupName == "" {
+		return nil
+	}
+	for _, s := range master.cluster.servers {
+		if s.URL == master.URL || s.GroupName != master.GroupName || s.inMaintenance() {
+			continue
+		}
+		if err := s.refresh(); (err == nil || err == sql.ErrNoRows) && s.groupPrimary() {
+			return s
+		}
+	}
+	return nil
+}
+
+/* Returns the label of the group of a Galera node or Group Replication member */
+func (sm *ServerMonitor) peerGroup() string {
+	if sm.isGalera() {
+		return "Galera cluster"
+	}
+	return "replication group " + strconv.Quote(sm.GroupName)
+}
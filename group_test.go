@@ -0,0 +1,123 @@
+// group_test.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const simMembersQuery = "SELECT MEMBER_ID, MEMBER_STATE, MEMBER_ROLE FROM performance_schema.replication_group_members"
+
+/* Returns the rows of replication_group_members for the members m1 to m3, as role:state items */
+func simMembers(members ...string) []map[string]string {
+	var rows []map[string]string
+	for i, m := range members {
+		role, state := splitPair(m)
+		rows = append(rows, map[string]string{"MEMBER_ID": "uuid-" + strconv.Itoa(i+1), "MEMBER_ROLE": role, "MEMBER_STATE": state})
+	}
+	return rows
+}
+
+/* Builds a MySQL group of the members m1 to m3, m1 being the primary and the master of the asynchronous replica r4 */
+func simGroup(t *testing.T) map[string]*simServer {
+	sims := simCluster(t, []simSpec{
+		{url: "m1:3306", id: 1, gtid: "0-1-120"},
+		{url: "m2:3306", id: 2, gtid: "0-1-120"},
+		{url: "m3:3306", id: 3, gtid: "0-1-120"},
+		{url: "r4:3306", id: 4, master: 1, gtid: "0-1-118"},
+	})
+	current.master = current.findMaster(true)
+	for i, url := range []string{"m1:3306", "m2:3306", "m3:3306"} {
+		sims[url].rows = map[string][]map[string]string{
+			"SHOW GLOBAL VARIABLES": {
+				{"Variable_name": "server_id", "Value": strconv.Itoa(i + 1)},
+				{"Variable_name": "server_uuid", "Value": "uuid-" + strconv.Itoa(i+1)},
+				{"Variable_name": "group_replication_group_name", "Value": "8a94f357-aab4-11df-86ab-c80aa9429562"},
+			},
+			simMembersQuery: simMembers("PRIMARY:ONLINE", "SECONDARY:ONLINE", "SECONDARY:ONLINE"),
+		}
+		sm := simServerByURL(url)
+		sm.Flavor = FLAVOR_MYSQL
+		sm.refresh()
+	}
+	return sims
+}
+
+func TestReadGroup(t *testing.T) {
+	defer func(g bool) { *groupRepl = g }(*groupRepl)
+	*groupRepl = true
+	simGroup(t)
+	tests := []struct {
+		url     string
+		member  bool
+		primary bool
+		label   string
+	}{
+		{"m1:3306", true, true, "Group Replication PRIMARY ONLINE, 3 of 3 members online"},
+		{"m2:3306", true, false, "Group Replication SECONDARY ONLINE, 3 of 3 members online"},
+		{"r4:3306", false, false, ""},
+	}
+	for _, tt := range tests {
+		sm := simServerByURL(tt.url)
+		if sm.isGroupMember() != tt.member || sm.groupPrimary() != tt.primary || sm.groupLabel() != tt.label {
+			t.Errorf("%s: member %v, primary %v, label %q, want %v, %v, %q", tt.url, sm.isGroupMember(), sm.groupPrimary(), sm.groupLabel(), tt.member, tt.primary, tt.label)
+		}
+	}
+	// The membership is only read with -group-replication
+	*groupRepl = false
+	sm := simServerByURL("m1:3306")
+	sm.refresh()
+	if sm.isGroupMember() {
+		t.Error("group membership read without -group-replication")
+	}
+}
+
+func TestGroupFailover(t *testing.T) {
+	defer func(f, s string, g bool) { *failover, *stateFile, *groupRepl = f, s, g }(*failover, *stateFile, *groupRepl)
+	*failover, *stateFile = "force", ""
+	tests := []struct {
+		name    string
+		group   bool
+		members []string // replication_group_members seen by the surviving members
+		want    string
+		output  string
+	}{
+		{"new primary", true, []string{"PRIMARY:UNREACHABLE", "PRIMARY:ONLINE", "SECONDARY:ONLINE"}, "m2:3306", ""},
+		{"no primary yet", true, []string{"PRIMARY:UNREACHABLE", "SECONDARY:RECOVERING", "SECONDARY:ONLINE"}, "r4:3306", "No online primary found in the replication group of m1:3306"},
+		{"group replication off", false, []string{"PRIMARY:UNREACHABLE", "PRIMARY:ONLINE", "SECONDARY:ONLINE"}, "r4:3306", ""},
+	}
+	for _, tt := range tests {
+		*groupRepl = tt.group
+		sims := simGroup(t)
+		var out bytes.Buffer
+		log.SetOutput(&out)
+		logWriter.out = &out
+		for _, url := range []string{"m2:3306", "m3:3306"} {
+			sims[url].rows[simMembersQuery] = simMembers(tt.members...)
+		}
+		sims["m1:3306"].down = true
+		current.master.State = STATE_FAILED
+		nmUrl, err := current.Failover(context.Background())
+		if nmUrl != tt.want {
+			t.Errorf("%s: Failover() = %q, %v, want %s", tt.name, nmUrl, err, tt.want)
+		}
+		if tt.output != "" && strings.Contains(out.String(), tt.output) == false {
+			t.Errorf("%s: output does not contain %q", tt.name, tt.output)
+		}
+		if tt.want == "m2:3306" {
+			if sims["r4:3306"].ran("CHANGE MASTER TO master_host='m2', master_port=3306") == false || sims["r4:3306"].ran("RESET SLAVE") {
+				t.Errorf("%s: replica not repointed to the new primary: %q", tt.name, sims["r4:3306"].execs)
+			}
+			// The group elects its primary itself
+			for _, url := range []string{"m2:3306", "m3:3306"} {
+				if len(sims[url].execs) != 0 {
+					t.Errorf("%s: member %s changed: %q", tt.name, url, sims[url].execs)
+				}
+			}
+		}
+	}
+}
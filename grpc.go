@@ -0,0 +1,281 @@
+// grpc.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/mariadb-corporation/replication-manager/pkg/repmgrpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+)
+
+/* The gRPC control service, whose messages and stubs are generated from pkg/repmgrpb/repmgr.proto */
+type grpcServer struct {
+	repmgrpb.UnimplementedReplicationManagerServer
+}
+
+/* An alert streamed by the Watch method */
+type WatchEvent struct {
+	Cluster string
+	Alert
+}
+
+/* Codec of the json content subtype, encoding the messages with the protobuf JSON mapping, for clients without generated code */
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if ok == false {
+		return nil, errors.New(fmt.Sprintf("json codec: %T is not a protobuf message", v))
+	}
+	return protojson.Marshal(m)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if ok == false {
+		return errors.New(fmt.Sprintf("json codec: %T is not a protobuf message", v))
+	}
+	return protojson.Unmarshal(data, m)
+}
+
+func (jsonCodec) Name() string { return "json" }
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+/* Subscribers of the Watch method, with the cluster they watch, all if empty */
+var watchers = struct {
+	sync.Mutex
+	chans map[chan WatchEvent]string
+}{chans: map[chan WatchEvent]string{}}
+
+/* Sends an alert to the Watch subscribers. Slow subscribers miss the events their buffer cannot hold. */
+func (c *Cluster) publishEvent(a Alert) {
+	e := WatchEvent{Cluster: c.clusterName(), Alert: a}
+	watchers.Lock()
+	defer watchers.Unlock()
+	for ch, name := range watchers.chans {
+		if name != "" && name != e.Cluster {
+			continue
+		}
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+/* Checks the network, credentials and role of a gRPC client like the HTTP API does, the credentials being passed in the authorization metadata. Returns who the client is. */
+func grpcAuthorize(ctx context.Context, role int) (string, error) {
+	who := "unknown"
+	if p, ok := peer.FromContext(ctx); ok {
+		who = p.Addr.String()
+		if allowed(who) == false {
+			return "", status.Error(codes.PermissionDenied, "Forbidden")
+		}
+	}
+	if apiAuthEnabled() == false {
+		return who, nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	r := &http.Request{Header: http.Header{}}
+	for _, v := range md.Get("authorization") {
+		r.Header.Set("Authorization", v)
+	}
+	c, ok := authenticate(r)
+	if ok == false {
+		return "", status.Error(codes.Unauthenticated, "Unauthorized")
+	}
+	if c.Role < role {
+		return "", status.Error(codes.PermissionDenied, "The operator role is required")
+	}
+	return c.Name + " from " + who, nil
+}
+
+/* Returns the named cluster, which is optional when a single cluster is monitored */
+func grpcFind(name string) (*Cluster, error) {
+	c := clusters[0]
+	if name != "" || len(clusters) > 1 {
+		c = findCluster(clusters, name)
+	}
+	if c == nil {
+		return nil, status.Error(codes.NotFound, "Unknown cluster "+name)
+	}
+	return c, nil
+}
+
+/* Runs fn on the named cluster */
+func grpcCluster(name string, fn func(c *Cluster) error) error {
+	c, err := grpcFind(name)
+	if err != nil {
+		return err
+	}
+	withCluster(c, func() {
+		err = fn(c)
+	})
+	return err
+}
+
+/* Converts the report of a cluster to its message */
+func topologyMessage(r Report) *repmgrpb.Topology {
+	h := r.Health
+	t := &repmgrpb.Topology{Time: timestamppb.New(r.Time), Master: r.Master,
+		Health: &repmgrpb.Health{Name: h.Name, Master: h.Master, Score: int32(h.Score), Slaves: int32(h.Slaves), Candidates: int32(h.Candidates), Issues: h.Issues}}
+	for _, s := range r.Servers {
+		m := &repmgrpb.Server{Url: s.URL, Name: s.Name, State: s.State, Delay: s.Delay, UsingGtid: s.UsingGtid, CurrentGtid: s.CurrentGtid, SlaveGtid: s.SlaveGtid,
+			BinlogPos: s.BinlogPos, IoThread: s.IOThread, SqlThread: s.SQLThread, IoError: s.IOError, SqlError: s.SQLError, ReadOnly: s.ReadOnly,
+			SuperReadOnly: s.SuperReadOnly, ReadOnlyIssue: s.ReadOnlyIssue}
+		for _, c := range s.Channels {
+			m.Channels = append(m.Channels, &repmgrpb.Channel{Name: c.Name, Master: c.Master, IoThread: c.IOThread, SqlThread: c.SQLThread, Delay: c.Delay})
+		}
+		t.Servers = append(t.Servers, m)
+	}
+	return t
+}
+
+func (grpcServer) GetTopology(ctx context.Context, req *repmgrpb.ClusterRequest) (*repmgrpb.Topology, error) {
+	_, err := grpcAuthorize(ctx, ROLE_VIEWER)
+	if err != nil {
+		return nil, err
+	}
+	var t *repmgrpb.Topology
+	err = grpcCluster(req.GetCluster(), func(c *Cluster) error {
+		t = topologyMessage(c.buildReport())
+		return nil
+	})
+	return t, err
+}
+
+func (grpcServer) Switchover(ctx context.Context, req *repmgrpb.ActionRequest) (*repmgrpb.ActionReply, error) {
+	return grpcAction(ctx, "switchover", req)
+}
+
+func (grpcServer) Failover(ctx context.Context, req *repmgrpb.ActionRequest) (*repmgrpb.ActionReply, error) {
+	return grpcAction(ctx, "failover", req)
+}
+
+func grpcAction(ctx context.Context, kind string, req *repmgrpb.ActionRequest) (*repmgrpb.ActionReply, error) {
+	who, err := grpcAuthorize(ctx, ROLE_OPERATOR)
+	if err != nil {
+		return nil, err
+	}
+	var reply *repmgrpb.ActionReply
+	err = grpcCluster(req.GetCluster(), func(c *Cluster) error {
+		nmUrl, conflict, err := c.controlAction(kind, req.GetConfirm(), "grpc "+who)
+		if conflict {
+			return status.Error(codes.FailedPrecondition, err.Error())
+		}
+		if err != nil {
+			return status.Error(codes.Aborted, err.Error())
+		}
+		reply = &repmgrpb.ActionReply{Master: nmUrl}
+		return nil
+	})
+	return reply, err
+}
+
+/* Aborts the switchover or failover in progress, which holds its cluster, so the cluster of the request is not waited for */
+func (grpcServer) Abort(ctx context.Context, req *repmgrpb.ClusterRequest) (*repmgrpb.ActionReply, error) {
+	who, err := grpcAuthorize(ctx, ROLE_OPERATOR)
+	if err != nil {
+		return nil, err
+	}
+	c, err := grpcFind(req.GetCluster())
+	if err != nil {
+		return nil, err
+	}
+	err = c.abortOperation("grpc " + who)
+	if err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return &repmgrpb.ActionReply{}, nil
+}
+
+func (grpcServer) SetMaintenance(ctx context.Context, req *repmgrpb.MaintenanceRequest) (*repmgrpb.MaintenanceRequest, error) {
+	who, err := grpcAuthorize(ctx, ROLE_OPERATOR)
+	if err != nil {
+		return nil, err
+	}
+	var reply *repmgrpb.MaintenanceRequest
+	err = grpcCluster(req.GetCluster(), func(c *Cluster) error {
+		sm := c.findServer(req.GetServer())
+		if sm == nil {
+			return status.Error(codes.NotFound, "Unknown server "+req.GetServer())
+		}
+		c.setMaintenance(sm, req.GetMaintenance(), "gRPC client "+who)
+		reply = &repmgrpb.MaintenanceRequest{Cluster: req.GetCluster(), Server: sm.URL, Maintenance: sm.inMaintenance()}
+		return nil
+	})
+	return reply, err
+}
+
+/* Streams the alerts of the requested cluster, or of all clusters, until the client cancels */
+func (grpcServer) Watch(req *repmgrpb.ClusterRequest, stream repmgrpb.ReplicationManager_WatchServer) error {
+	if _, err := grpcAuthorize(stream.Context(), ROLE_VIEWER); err != nil {
+		return err
+	}
+	if req.GetCluster() != "" && findCluster(clusters, req.GetCluster()) == nil {
+		return status.Error(codes.NotFound, "Unknown cluster "+req.GetCluster())
+	}
+	ch := make(chan WatchEvent, 64)
+	watchers.Lock()
+	watchers.chans[ch] = req.GetCluster()
+	watchers.Unlock()
+	defer func() {
+		watchers.Lock()
+		delete(watchers.chans, ch)
+		watchers.Unlock()
+	}()
+	for {
+		select {
+		case e := <-ch:
+			err := stream.Send(&repmgrpb.WatchEvent{Cluster: e.Cluster, Event: e.Event, Severity: e.Severity, Server: e.Server, Name: e.Name,
+				Message: e.Message, Tags: e.Tags, Time: timestamppb.New(e.Time)})
+			if err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+/* Starts the gRPC service, with the TLS settings of the HTTP API. It is meant to run in its own goroutine. */
+func grpcServe() {
+	cfg, err := listenerTLS()
+	if err != nil {
+		log.Printf("ERROR: gRPC service not started, invalid TLS settings: %s", err)
+		return
+	}
+	var opts []grpc.ServerOption
+	if cfg != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(cfg)))
+	}
+	lis, err := net.Listen("tcp", *grpcAddr)
+	if err != nil {
+		log.Printf("ERROR: gRPC service not started: %s", err)
+		return
+	}
+	srv := grpc.NewServer(opts...)
+	repmgrpb.RegisterReplicationManagerServer(srv, grpcServer{})
+	log.Printf("INFO : Starting gRPC service on %s", *grpcAddr)
+	err = srv.Serve(lis)
+	if err != nil {
+		log.Printf("ERROR: gRPC service stopped: %s", err)
+	}
+}
@@ -0,0 +1,199 @@
+// grpc_test.go
+package main
+
+import (
+	"context"
+	"github.com/mariadb-corporation/replication-manager/pkg/repmgrpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+/* Serves the gRPC service for the simulated cluster, named default, and returns a client of it */
+func simGRPC(t *testing.T) repmgrpb.ReplicationManagerClient {
+	current.Name = "default"
+	clusters = []*Cluster{current}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	repmgrpb.RegisterReplicationManagerServer(srv, grpcServer{})
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return repmgrpb.NewReplicationManagerClient(conn)
+}
+
+func TestGRPCTopology(t *testing.T) {
+	defer func(l []*Cluster) { clusters = l }(clusters)
+	simCluster(t, simTopology())
+	current.master = current.findMaster(true)
+	current.master.State = STATE_MASTER
+	client := simGRPC(t)
+	for _, subtype := range []string{"proto", "json"} {
+		topo, err := client.GetTopology(context.Background(), &repmgrpb.ClusterRequest{}, grpc.CallContentSubtype(subtype))
+		if err != nil {
+			t.Errorf("%s: GetTopology() = %s", subtype, err)
+			continue
+		}
+		if topo.GetMaster() != "db1:3306" || len(topo.GetServers()) != 3 || topo.GetHealth().GetName() != "default" {
+			t.Errorf("%s: topology %v, want the 3 servers of master db1:3306", subtype, topo)
+		}
+		if s := topo.GetServers()[2]; s.GetUrl() != "db3:3306" || s.GetState() != STATE_SLAVE || s.GetIoThread() != "Yes" {
+			t.Errorf("%s: server %v, want the running slave db3:3306", subtype, s)
+		}
+	}
+	_, err := client.GetTopology(context.Background(), &repmgrpb.ClusterRequest{Cluster: "prod"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("GetTopology() of an unknown cluster = %v, want NotFound", err)
+	}
+}
+
+func TestGRPCActions(t *testing.T) {
+	defer func(l []*Cluster, f, s string) { clusters, *failover, *stateFile = l, f, s }(clusters, *failover, *stateFile)
+	*failover, *stateFile = "force", ""
+	tests := []struct {
+		name    string
+		kind    string
+		failed  bool // master down
+		confirm string
+		code    codes.Code
+		master  string
+	}{
+		{"switchover", "switchover", false, "db1:3306", codes.OK, "db3:3306"},
+		{"failover", "failover", true, "db1:3306", codes.OK, "db3:3306"},
+		{"no confirmation", "switchover", false, "", codes.FailedPrecondition, ""},
+		{"failover of a running master", "failover", false, "db1:3306", codes.FailedPrecondition, ""},
+		{"switchover refused", "switchover", false, "db1:3306", codes.Aborted, ""},
+	}
+	for _, tt := range tests {
+		sims := simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.down = tt.failed && sp.id == 1 }))
+		current.master = current.findMaster(tt.failed == false)
+		if tt.failed {
+			current.master.State = STATE_FAILED
+		}
+		if tt.code == codes.Aborted {
+			sims["db1:3306"].fail = "FLUSH TABLES WITH READ LOCK"
+		}
+		client := simGRPC(t)
+		req := &repmgrpb.ActionRequest{Confirm: tt.confirm}
+		var reply *repmgrpb.ActionReply
+		var err error
+		if tt.kind == "switchover" {
+			reply, err = client.Switchover(context.Background(), req)
+		} else {
+			reply, err = client.Failover(context.Background(), req)
+		}
+		if status.Code(err) != tt.code || reply.GetMaster() != tt.master {
+			t.Errorf("%s: %s() = %v, %v, want %s %s", tt.name, tt.kind, reply, err, tt.code, tt.master)
+			continue
+		}
+		// The operation is recorded as triggered from a gRPC client
+		if tt.code == codes.OK && (len(current.stateData.History) != 1 || strings.HasPrefix(current.stateData.History[0].Trigger, "grpc ") == false) {
+			t.Errorf("%s: history %+v, want a %s triggered from gRPC", tt.name, current.stateData.History, tt.kind)
+		}
+	}
+}
+
+func TestGRPCMaintenance(t *testing.T) {
+	defer func(l []*Cluster) { clusters = l }(clusters)
+	simCluster(t, simTopology())
+	current.master = current.findMaster(true)
+	client := simGRPC(t)
+	reply, err := client.SetMaintenance(context.Background(), &repmgrpb.MaintenanceRequest{Cluster: "default", Server: "db2:3306", Maintenance: true})
+	if err != nil || reply.GetMaintenance() == false || simServerByURL("db2:3306").inMaintenance() == false {
+		t.Errorf("SetMaintenance() = %v, %v, want db2:3306 in maintenance", reply, err)
+	}
+	reply, err = client.SetMaintenance(context.Background(), &repmgrpb.MaintenanceRequest{Server: "db2:3306"})
+	if err != nil || reply.GetMaintenance() || simServerByURL("db2:3306").inMaintenance() {
+		t.Errorf("SetMaintenance() = %v, %v, want db2:3306 out of maintenance", reply, err)
+	}
+	_, err = client.SetMaintenance(context.Background(), &repmgrpb.MaintenanceRequest{Server: "db9:3306", Maintenance: true})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("SetMaintenance() of an unknown server = %v, want NotFound", err)
+	}
+}
+
+func TestGRPCWatch(t *testing.T) {
+	defer func(l []*Cluster) { clusters = l }(clusters)
+	simCluster(t, simTopology())
+	client := simGRPC(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.Watch(ctx, &repmgrpb.ClusterRequest{Cluster: "default"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The subscription is registered once the stream is served
+	for i := 0; i < 100; i++ {
+		watchers.Lock()
+		n := len(watchers.chans)
+		watchers.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	current.publishEvent(Alert{Event: "failover", Severity: "critical", Server: "db1:3306", Message: "Master db1:3306 failed", Time: time.Now()})
+	e, err := stream.Recv()
+	if err != nil || e.GetCluster() != "default" || e.GetEvent() != "failover" || e.GetServer() != "db1:3306" || e.GetMessage() != "Master db1:3306 failed" {
+		t.Errorf("Recv() = %v, %v, want the failover of db1:3306", e, err)
+	}
+	stream, err = client.Watch(context.Background(), &repmgrpb.ClusterRequest{Cluster: "prod"})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Watch() of an unknown cluster = %v, want NotFound", err)
+	}
+}
+
+func TestGRPCAuthorize(t *testing.T) {
+	defer func(l []*Cluster) { clusters = l }(clusters)
+	defer func() { apiTokens, apiUsers, allowNets = map[string]apiClient{}, map[string]apiClient{}, nil }()
+	simCluster(t, simTopology())
+	current.master = current.findMaster(true)
+	client := simGRPC(t)
+	apiTokens = map[string]apiClient{"view": {Name: "viewer", Role: ROLE_VIEWER}, "oper": {Name: "operator", Role: ROLE_OPERATOR}}
+	tests := []struct {
+		name  string
+		token string
+		code  codes.Code // of the maintenance request, which requires the operator role
+	}{
+		{"anonymous", "", codes.Unauthenticated},
+		{"wrong token", "nope", codes.Unauthenticated},
+		{"viewer", "view", codes.PermissionDenied},
+		{"operator", "oper", codes.OK},
+	}
+	for _, tt := range tests {
+		ctx := context.Background()
+		if tt.token != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+tt.token)
+		}
+		_, err := client.SetMaintenance(ctx, &repmgrpb.MaintenanceRequest{Server: "db2:3306"})
+		if status.Code(err) != tt.code {
+			t.Errorf("%s: SetMaintenance() = %v, want %s", tt.name, err, tt.code)
+		}
+	}
+	// Viewers read the topology
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer view")
+	if _, err := client.GetTopology(ctx, &repmgrpb.ClusterRequest{}); err != nil {
+		t.Errorf("GetTopology() by a viewer = %s", err)
+	}
+	// Clients outside of the allowed networks are refused before their credentials are checked
+	allowNets, _ = parseNets("10.0.0.0/8")
+	if _, err := client.GetTopology(ctx, &repmgrpb.ClusterRequest{}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("GetTopology() from a refused network = %v, want PermissionDenied", err)
+	}
+}
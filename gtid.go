@@ -0,0 +1,259 @@
+// gtid.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/mariadb-corporation/replication-manager/pkg/cluster"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FLAVOR_MARIADB string = cluster.FlavorMariaDB
+	FLAVOR_MYSQL   string = cluster.FlavorMySQL
+)
+
+/* Detects whether the server runs MariaDB or Oracle MySQL, which implement GTID differently */
+func (sm *ServerMonitor) detectFlavor() error {
+	err := sm.Conn.Get(&sm.Version, "SELECT @@version")
+	if err != nil {
+		return err
+	}
+	if strings.Contains(sm.Version, "MariaDB") {
+		sm.Flavor = FLAVOR_MARIADB
+	} else {
+		sm.Flavor = FLAVOR_MYSQL
+	}
+	return nil
+}
+
+/* Refresh a MySQL server object. Variables and slave status are read with SHOW statements since MySQL 5.7 hides information_schema variables and its slave status columns differ from MariaDB. */
+func (sm *ServerMonitor) refreshMySQL() error {
+	rows, err := sm.query("SHOW GLOBAL VARIABLES")
+	if err != nil {
+		return err
+	}
+	sv := make(map[string]string)
+	for _, r := range rows {
+		sv[strings.ToUpper(r["Variable_name"])] = r["Value"]
+	}
+	sm.CurrentGtid = strings.Replace(sv["GTID_EXECUTED"], "\n", "", -1)
+	sm.BinlogPos = sm.CurrentGtid
+	sm.Strict = sv["ENFORCE_GTID_CONSISTENCY"]
+	sm.LogBin = sv["LOG_BIN"]
+	sm.ReadOnly = sv["READ_ONLY"]
+	sm.SuperReadOnly = sv["SUPER_READ_ONLY"]
+	sm.EventScheduler = sv["EVENT_SCHEDULER"]
+	sid, _ := strconv.ParseUint(sv["SERVER_ID"], 10, 0)
+	sm.ServerId = uint(sid)
+	sm.sampleBinlogRate()
+	sm.readGroup(sv)
+	rows, err = sm.query("SHOW SLAVE STATUS")
+	if err != nil {
+		return err
+	}
+	// The channels of Group Replication are not asynchronous replication
+	var ss map[string]string
+	for _, r := range rows {
+		if strings.HasPrefix(r["Channel_Name"], "group_replication_") == false {
+			ss = r
+			break
+		}
+	}
+	if ss == nil {
+		sm.UsingGtid = ""
+		return sql.ErrNoRows
+	}
+	if ss["Auto_Position"] == "1" {
+		sm.UsingGtid = "Auto_Position"
+	} else {
+		sm.UsingGtid = "No"
+	}
+	sm.SlaveGtid = strings.Replace(ss["Retrieved_Gtid_Set"], "\n", "", -1)
+	sm.IOThread = ss["Slave_IO_Running"]
+	sm.SQLThread = ss["Slave_SQL_Running"]
+	errno, _ := strconv.ParseUint(ss["Last_IO_Errno"], 10, 0)
+	sm.IOErrno, sm.IOError = uint(errno), ss["Last_IO_Error"]
+	errno, _ = strconv.ParseUint(ss["Last_SQL_Errno"], 10, 0)
+	sm.SQLErrno, sm.SQLError = uint(errno), ss["Last_SQL_Error"]
+	delay, err := strconv.ParseInt(ss["Seconds_Behind_Master"], 10, 64)
+	sm.Delay = sql.NullInt64{Int64: delay, Valid: err == nil}
+	sm.SQLDelay, _ = strconv.ParseInt(ss["SQL_Delay"], 10, 64)
+	msid, _ := strconv.ParseUint(ss["Master_Server_Id"], 10, 0)
+	sm.MasterServerId = uint(msid)
+	sm.MasterHost = ss["Master_Host"]
+	pos, _ := strconv.ParseUint(ss["Read_Master_Log_Pos"], 10, 64)
+	sm.trackRead(ss["Master_Log_File"], pos)
+	pos, _ = strconv.ParseUint(ss["Exec_Master_Log_Pos"], 10, 64)
+	sm.sampleApplyRate(ss["Relay_Master_Log_File"], pos)
+	return nil
+}
+
+/* Returns the GTID position of everything written to the binary logs, which slaves must reach to be in sync */
+func (sm *ServerMonitor) binlogGtid() string {
+	if sm.Flavor == FLAVOR_MYSQL {
+		rows, err := sm.query("SELECT @@GLOBAL.gtid_executed AS value")
+		if err != nil || len(rows) == 0 {
+			return ""
+		}
+		return strings.Replace(rows[0]["value"], "\n", "", -1)
+	}
+	return sm.backend().Variable("GTID_BINLOG_POS")
+}
+
+/* Returns a number that grows with the transactions applied by the server, used to compare candidates. MariaDB uses the sum of the sequence numbers of the domains of its position, MySQL the number of transactions in its executed set. */
+func (sm *ServerMonitor) gtidSeq() uint64 {
+	if sm.Flavor == FLAVOR_MYSQL {
+		return cluster.GTIDSetCount(sm.binlogGtid())
+	}
+	return cluster.GTIDCount(FLAVOR_MARIADB, sm.backend().Variable("GTID_CURRENT_POS"))
+}
+
+/* Returns the CHANGE MASTER option making a demoted master replicate from its own GTID position */
+func (sm *ServerMonitor) gtidMasterOpt(pos string) string {
+	if sm.Flavor == FLAVOR_MYSQL {
+		return ", master_auto_position=1"
+	}
+	return ", master_use_gtid=" + pos
+}
+
+/* Waits until a MariaDB candidate applied the transactions received by any of the slaves in every GTID domain, using MASTER_GTID_WAIT on the union of its applied position and the received position of each replication connection of the slaves. A candidate missing transactions another slave received times out. Returns the position waited for and the time spent waiting. */
+func (sm *ServerMonitor) waitReceivedGtid(ctx context.Context, l []*ServerMonitor, timeout int64) (string, time.Duration, error) {
+	pos := sm.backend().Variable("GTID_SLAVE_POS")
+	for _, s := range append([]*ServerMonitor{sm}, l...) {
+		if s.State == STATE_FAILED || s.Flavor != FLAVOR_MARIADB || s.isDelayed() {
+			continue
+		}
+		rows, err := s.query("SHOW ALL SLAVES STATUS")
+		if err != nil {
+			if s == sm {
+				return "", 0, err
+			}
+			continue
+		}
+		for _, r := range rows {
+			pos = cluster.GTIDUnion(pos, r["Gtid_IO_Pos"])
+		}
+	}
+	if pos == "" {
+		return pos, 0, nil
+	}
+	if *dryRun {
+		alertLog("DRY-RUN: [%s] would wait up to %d seconds for position %s", sm.URL, timeout, pos)
+		return pos, 0, nil
+	}
+	wt, err := sm.waitGtid(ctx, pos, timeout)
+	return pos, wt, err
+}
+
+/* Waits until the server has applied the given GTID position, using MASTER_GTID_WAIT on MariaDB or WAIT_FOR_EXECUTED_GTID_SET on MySQL with a timeout in seconds. Returns the time spent waiting. */
+func (server *ServerMonitor) waitGtid(ctx context.Context, gtid string, timeout int64) (time.Duration, error) {
+	start := time.Now()
+	query := fmt.Sprintf("SELECT MASTER_GTID_WAIT('%s', %d) AS res", gtid, timeout)
+	if server.Flavor == FLAVOR_MYSQL {
+		query = fmt.Sprintf("SELECT WAIT_FOR_EXECUTED_GTID_SET('%s', %d) AS res", gtid, timeout)
+	}
+	rows, err := server.node().Query(ctx, query)
+	wt := time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		return wt, err
+	}
+	if len(rows) == 0 || rows[0]["res"] != "0" {
+		return wt, errSyncTimeout
+	}
+	return wt, nil
+}
+
+/* Warns about GTID domains written by several servers of a MariaDB cluster, at each monitor cycle. Each server taking writes, the master and any slave left writable, needs its own gtid_domain_id: writers of one domain interleave their sequence numbers, so that slaves skip or reject transactions. A slave holding a domain the master does not know received writes of its own. */
+func (c *Cluster) domainCheck() {
+	if c.master == nil || c.master.Flavor != FLAVOR_MARIADB || c.master.State == STATE_FAILED {
+		return
+	}
+	var warnings []string
+	writers := make(map[string]string)
+	for _, s := range c.servers {
+		if s.State == STATE_FAILED || s.Flavor != FLAVOR_MARIADB || s.isGalera() {
+			continue
+		}
+		if s != c.master && s.ReadOnly != "OFF" {
+			continue
+		}
+		if w, ok := writers[s.GtidDomain]; ok {
+			warnings = append(warnings, fmt.Sprintf("WARN : Servers %s and %s both take writes in GTID domain %s, set a distinct gtid_domain_id on each", w, s.URL, s.GtidDomain))
+			continue
+		}
+		writers[s.GtidDomain] = s.URL
+	}
+	known := make(map[uint32]bool)
+	for _, d := range c.master.Domains {
+		known[d] = true
+	}
+	for _, sl := range c.slaves {
+		for _, d := range sl.Domains {
+			if known[d] == false {
+				warnings = append(warnings, fmt.Sprintf("WARN : Slave %s applied transactions in GTID domain %d that master %s does not have", sl.URL, d, c.master.URL))
+			}
+		}
+	}
+	logged := make(map[string]bool)
+	for _, w := range warnings {
+		if c.domainWarnings[w] == false {
+			alertLog("%s", w)
+		}
+		logged[w] = true
+	}
+	c.domainWarnings = logged
+}
+
+/* True when the MariaDB slaves hold transactions of several GTID domains, whose sums of sequence numbers do not order the slaves */
+func multiDomain(l []*ServerMonitor) bool {
+	var positions []string
+	for _, sl := range l {
+		if sl.Flavor != FLAVOR_MARIADB {
+			return false
+		}
+		if sl.State != STATE_FAILED {
+			positions = append(positions, sl.backend().Variable("GTID_CURRENT_POS"))
+		}
+	}
+	return len(cluster.GTIDDomains(cluster.GTIDUnion(positions...))) > 1
+}
+
+/* Keeps the candidates at or ahead of every other slave in each GTID domain, since promoting a slave behind another in one domain loses the transactions of that domain. Delayed replicas, behind by design, are not compared. Logs the positions of the slaves when no candidate is left. */
+func coveringCandidates(l []*ServerMonitor, candidates []cluster.Candidate, keys []int) ([]cluster.Candidate, []int) {
+	pos := make(map[string]string)
+	for _, sl := range l {
+		if sl.State != STATE_FAILED && sl.isDelayed() == false {
+			pos[sl.URL] = sl.backend().Variable("GTID_CURRENT_POS")
+		}
+	}
+	var kept []cluster.Candidate
+	var keptKeys []int
+	for i, c := range candidates {
+		behind := ""
+		for _, sl := range l {
+			p, ok := pos[sl.URL]
+			if ok && sl.URL != c.URL && cluster.GTIDCovers(pos[c.URL], p) == false {
+				behind = sl.URL
+				break
+			}
+		}
+		if behind != "" {
+			logprintf("WARN : Slave %s at %s is behind %s at %s in a GTID domain. Skipping", c.URL, pos[c.URL], behind, pos[behind])
+			continue
+		}
+		kept, keptKeys = append(kept, c), append(keptKeys, keys[i])
+	}
+	if len(kept) == 0 {
+		logprint("ERROR: No slave is at or ahead of all others in every GTID domain, promoting one would lose transactions:")
+		for _, sl := range l {
+			if p, ok := pos[sl.URL]; ok {
+				logprintf("ERROR:   %s at %s", sl.URL, p)
+			}
+		}
+	}
+	return kept, keptKeys
+}
@@ -0,0 +1,198 @@
+// gtid_test.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestWaitSync(t *testing.T) {
+	tests := []struct {
+		name  string
+		specs []simSpec
+		ok    bool
+	}{
+		{"candidate at the position", simChange(simTopology(), func(sp *simSpec) { sp.gtid = "0-1-120" }), true},
+		{"candidate applying", simTopology(), true},
+		{"candidate with replication stopped", simChange(simTopology(), func(sp *simSpec) { sp.stopped = sp.id == 2 }), false},
+		{"candidate ahead in another domain only", simChange(simTopology(), func(sp *simSpec) {
+			if sp.id == 2 {
+				sp.gtid, sp.stopped = "1-2-10", true
+			}
+		}), false},
+	}
+	for _, tt := range tests {
+		sims := simCluster(t, tt.specs)
+		master, candidate := simServerByURL("db1:3306"), simServerByURL("db2:3306")
+		sp, err := master.syncPoint()
+		if err != nil {
+			t.Fatalf("%s: syncPoint() = %s", tt.name, err)
+		}
+		_, err = candidate.waitSync(context.Background(), sp, 1)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: waitSync(%s) = %v, want success %v", tt.name, sp, err, tt.ok)
+		}
+		if err == nil && sims["db2:3306"].vars["GTID_CURRENT_POS"] != "0-1-120" {
+			t.Errorf("%s: candidate at %s after the wait, want 0-1-120", tt.name, sims["db2:3306"].vars["GTID_CURRENT_POS"])
+		}
+	}
+}
+
+func TestWaitSyncAborted(t *testing.T) {
+	simCluster(t, simTopology())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := simServerByURL("db2:3306").waitSync(ctx, syncPoint{Gtid: "0-1-120"}, 30)
+	if err != context.Canceled {
+		t.Errorf("waitSync() = %v, want %v", err, context.Canceled)
+	}
+}
+
+/* Turns a simulated server into a MySQL server at the executed GTID set, reporting its variables and slave status the way MySQL does */
+func simMySQL(sim *simServer, executed string) {
+	sim.vars["GTID_EXECUTED"] = executed
+	sim.rows = map[string][]map[string]string{
+		"SHOW GLOBAL VARIABLES": {
+			{"Variable_name": "server_id", "Value": sim.vars["SERVER_ID"]},
+			{"Variable_name": "gtid_executed", "Value": executed},
+			{"Variable_name": "read_only", "Value": sim.vars["READ_ONLY"]},
+			{"Variable_name": "log_bin", "Value": "ON"},
+		},
+		"SHOW SLAVE STATUS": nil,
+	}
+	if sim.status != nil {
+		sim.rows["SHOW SLAVE STATUS"] = []map[string]string{{
+			"Channel_Name":          "",
+			"Master_Host":           sim.status.Master_Host,
+			"Master_Server_Id":      strconv.Itoa(int(sim.status.Master_Server_Id)),
+			"Auto_Position":         "1",
+			"Retrieved_Gtid_Set":    executed,
+			"Slave_IO_Running":      "Yes",
+			"Slave_SQL_Running":     "Yes",
+			"Seconds_Behind_Master": "0",
+		}}
+	}
+}
+
+func TestMySQLGTID(t *testing.T) {
+	defer func(f string) { *failover = f }(*failover)
+	*failover = "force"
+	const u1, u2 = "3e11fa47-71ca-11e1-9e33-c80aa9429562", "4e11fa47-71ca-11e1-9e33-c80aa9429562"
+	sims := simCluster(t, simTopology())
+	simMySQL(sims["db1:3306"], u1+":1-120")
+	simMySQL(sims["db2:3306"], u1+":1-110")
+	// Long sets are wrapped by the server
+	simMySQL(sims["db3:3306"], u1+":1-100,\n"+u2+":1-15")
+	for _, s := range current.servers {
+		s.Flavor = FLAVOR_MYSQL
+		s.refresh()
+	}
+	db3 := simServerByURL("db3:3306")
+	if db3.UsingGtid != "Auto_Position" || db3.MasterServerId != 1 || db3.CurrentGtid != u1+":1-100,"+u2+":1-15" {
+		t.Errorf("refreshed slave using %q, master %d, position %q", db3.UsingGtid, db3.MasterServerId, db3.CurrentGtid)
+	}
+	if seq := db3.gtidSeq(); seq != 115 {
+		t.Errorf("gtidSeq() = %d, want 115", seq)
+	}
+	current.master = current.findMaster(true)
+	if key := current.master.electCandidate(current.slaves); key < 0 || current.slaves[key] != db3 {
+		t.Errorf("electCandidate() = %d, want db3:3306", key)
+	}
+	if opt := db3.gtidMasterOpt(""); opt != ", master_auto_position=1" {
+		t.Errorf("gtidMasterOpt() = %q, want auto position", opt)
+	}
+	wait := "SELECT WAIT_FOR_EXECUTED_GTID_SET('" + u1 + ":1-120', 1) AS res"
+	sims["db3:3306"].rows[wait] = []map[string]string{{"res": "0"}}
+	if _, err := db3.waitGtid(context.Background(), u1+":1-120", 1); err != nil {
+		t.Errorf("waitGtid() = %s", err)
+	}
+	sims["db3:3306"].rows[wait] = []map[string]string{{"res": "1"}}
+	if _, err := db3.waitGtid(context.Background(), u1+":1-120", 1); err != errSyncTimeout {
+		t.Errorf("waitGtid() = %v after a timeout, want %v", err, errSyncTimeout)
+	}
+}
+
+func TestSyncProgress(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		exec uint
+		sp   syncPoint
+		want string
+	}{
+		{"GTID", "", 0, syncPoint{Gtid: "0-1-120"}, "5 transactions behind"},
+		{"same binlog", "mysql-bin.000003", 400, syncPoint{Pos: binlogPos{File: "mysql-bin.000003", Pos: 1000}}, "600 bytes behind"},
+		{"older binlog", "mysql-bin.000002", 400, syncPoint{Pos: binlogPos{File: "mysql-bin.000003", Pos: 1000}}, "executed mysql-bin.000002:400"},
+	}
+	for _, tt := range tests {
+		sims := simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.stopped = sp.id == 3 }))
+		if tt.file != "" {
+			simPositions(sims["db3:3306"], tt.file, tt.exec, tt.exec)
+			simServerByURL("db3:3306").refresh()
+		}
+		if got := simServerByURL("db3:3306").syncProgress(tt.sp); got != tt.want {
+			t.Errorf("%s: syncProgress() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSwitchoverCatchupTimeout(t *testing.T) {
+	defer func(f, s string, w int64) { *failover, *stateFile, *gtidWaitTimeout = f, s, w }(*failover, *stateFile, *gtidWaitTimeout)
+	*failover, *stateFile, *gtidWaitTimeout = "force", "", 1
+	// The most advanced slave is elected but does not apply the master position
+	sims := simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.stopped = sp.id == 3 }))
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	logWriter.out = &out
+	current.master = current.findMaster(true)
+	if nmUrl, err := current.Switchover(context.Background()); nmUrl != "" || err == nil {
+		t.Errorf("Switchover() = %q, %v, want an abort", nmUrl, err)
+	}
+	for _, want := range []string{"Candidate master db3:3306 did not reach position 0-1-120 after 1", "timeout after 1 seconds, 5 transactions behind. Aborting switchover"} {
+		if strings.Contains(out.String(), want) == false {
+			t.Errorf("output does not contain %q:\n%s", want, out.String())
+		}
+	}
+	if sims["db3:3306"].ran("RESET SLAVE") || sims["db1:3306"].vars["READ_ONLY"] != "OFF" || sims["db1:3306"].ran("UNLOCK TABLES") == false {
+		t.Errorf("switchover not reverted: old master %q, candidate %q", sims["db1:3306"].execs, sims["db3:3306"].execs)
+	}
+}
+
+func TestDomainCheckPerCluster(t *testing.T) {
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(ioutil.Discard)
+	// Each cluster has a writable slave sharing the domain of its master
+	build := func(name string) *Cluster {
+		c := &Cluster{Name: name}
+		c.master = &ServerMonitor{URL: name + "-db1:3306", Flavor: FLAVOR_MARIADB, GtidDomain: "0", ReadOnly: "OFF"}
+		sl := &ServerMonitor{URL: name + "-db2:3306", Flavor: FLAVOR_MARIADB, GtidDomain: "0", ReadOnly: "OFF"}
+		c.servers, c.slaves = []*ServerMonitor{c.master, sl}, []*ServerMonitor{sl}
+		return c
+	}
+	a, b := build("a"), build("b")
+	for i := 0; i < 3; i++ {
+		a.domainCheck()
+		b.domainCheck()
+	}
+	for _, c := range []*Cluster{a, b} {
+		want := "Servers " + c.master.URL + " and " + c.slaves[0].URL + " both take writes in GTID domain 0"
+		if n := strings.Count(out.String(), want); n != 1 {
+			t.Errorf("cluster %s: warning logged %d times, want once:\n%s", c.Name, n, out.String())
+		}
+	}
+	// A warning cleared on one cluster is logged again when it comes back, the other cluster staying quiet
+	a.slaves[0].ReadOnly = "ON"
+	a.domainCheck()
+	a.slaves[0].ReadOnly = "OFF"
+	a.domainCheck()
+	b.domainCheck()
+	if n := strings.Count(out.String(), "both take writes"); n != 3 {
+		t.Errorf("warnings logged %d times, want 3:\n%s", n, out.String())
+	}
+}
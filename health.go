@@ -0,0 +1,100 @@
+// health.go
+package main
+
+import (
+	"fmt"
+)
+
+/* Summary of the cluster health, scored from 100 (healthy) down to 0 (master down) */
+type ClusterHealth struct {
+	Name       string
+	Master     string
+	Score      int
+	Slaves     int
+	Candidates int
+	Issues     []string
+}
+
+/* Returns the name of the cluster, which is its first tag if any */
+func (c *Cluster) clusterName() string {
+	if len(c.clusterTags) > 0 {
+		return c.clusterTags[0]
+	}
+	return "default"
+}
+
+/* Scores the cluster from the last refreshed state. Redundancy weighs the most: a cluster without a viable candidate cannot survive a master failure. Lag, broken replicas and configuration drift then take off points, each category being capped. */
+func (c *Cluster) clusterHealth() ClusterHealth {
+	h := ClusterHealth{Name: c.clusterName(), Score: 100}
+	if c.master == nil || c.master.State == STATE_FAILED {
+		h.Score = 0
+		h.Issues = append(h.Issues, "master is down")
+		return h
+	}
+	h.Master = c.master.URL
+	h.Slaves = len(c.slaves)
+	var broken, lag, drift int
+	if e := c.master.readonlyIssue(); e != "" {
+		drift += 5
+		h.Issues = append(h.Issues, e)
+	}
+	var maxLag int64
+	for _, sl := range c.slaves {
+		if sl.inMaintenance() {
+			continue
+		}
+		if sl.State == STATE_FAILED {
+			broken += 10
+			h.Issues = append(h.Issues, fmt.Sprintf("slave %s is down", sl.label()))
+			continue
+		}
+		if sl.IOThread != "Yes" || sl.SQLThread != "Yes" {
+			broken += 10
+			h.Issues = append(h.Issues, fmt.Sprintf("slave %s: %s", sl.label(), sl.healthCheck()))
+			continue
+		}
+		if sl.lag() > maxLag {
+			maxLag = sl.lag()
+		}
+		if sl.ReadOnly != "ON" {
+			drift += 5
+			h.Issues = append(h.Issues, fmt.Sprintf("slave %s is writable", sl.label()))
+		}
+		if sl.MasterServerId != c.master.ServerId {
+			drift += 5
+			h.Issues = append(h.Issues, fmt.Sprintf("slave %s replicates from server id %d instead of the master", sl.label(), sl.MasterServerId))
+			continue
+		}
+		if sl.ignored() || sl.isDelayed() || (*maxDelay > 0 && sl.Delay.Int64 > *maxDelay) {
+			continue
+		}
+		h.Candidates++
+	}
+	switch h.Candidates {
+	case 0:
+		h.Score -= 40
+		h.Issues = append(h.Issues, "no viable candidate master")
+	case 1:
+		h.Score -= 15
+		h.Issues = append(h.Issues, "only one viable candidate master")
+	}
+	if maxLag > 0 {
+		// One point per 30 seconds of lag
+		lag = int(maxLag / 30)
+		if lag > 0 {
+			h.Issues = append(h.Issues, fmt.Sprintf("maximum replication lag is %d seconds", maxLag))
+		}
+	}
+	h.Score -= capScore(broken, 30) + capScore(lag, 20) + capScore(drift, 15)
+	if h.Score < 1 {
+		h.Score = 1
+	}
+	return h
+}
+
+func capScore(n int, max int) int {
+	if n > max {
+		return max
+	}
+	return n
+}
@@ -0,0 +1,53 @@
+// health_test.go
+package main
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+)
+
+func TestClusterHealth(t *testing.T) {
+	defer func(d int64) { *maxDelay = d }(*maxDelay)
+	*maxDelay = 30
+	tests := []struct {
+		name   string
+		specs  []simSpec
+		change func(sims map[string]*simServer)
+		score  int
+		issues []string
+	}{
+		{"healthy", simTopology(), nil, 100, nil},
+		{"slave down", simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 3 }), nil, 75, []string{"slave db3:3306 is down", "only one viable candidate master"}},
+		{"slaves down", simChange(simTopology(), func(sp *simSpec) { sp.down = sp.master != 0 }), nil, 40, []string{"slave db2:3306 is down", "slave db3:3306 is down", "no viable candidate master"}},
+		{"replication stopped", simChange(simTopology(), func(sp *simSpec) { sp.stopped = sp.id == 2 }), nil, 75, []string{"slave db2:3306", "only one viable candidate master"}},
+		{"writable slave", simTopology(), func(sims map[string]*simServer) { sims["db2:3306"].vars["READ_ONLY"] = "OFF" }, 95, []string{"slave db2:3306 is writable"}},
+		{"lagging slave", simTopology(), func(sims map[string]*simServer) {
+			sims["db3:3306"].status.Seconds_Behind_Master = sql.NullInt64{Int64: 300, Valid: true}
+		}, 75, []string{"only one viable candidate master", "maximum replication lag is 300 seconds"}},
+		{"master down", simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 1 }), nil, 0, []string{"master is down"}},
+	}
+	for _, tt := range tests {
+		sims := simCluster(t, tt.specs)
+		current.master = current.findMaster(true)
+		if current.master == nil {
+			current.master = current.findMaster(false)
+		}
+		if tt.change != nil {
+			tt.change(sims)
+			for _, sl := range current.slaves {
+				sl.refresh()
+			}
+		}
+		h := current.clusterHealth()
+		if h.Score != tt.score || len(h.Issues) != len(tt.issues) {
+			t.Errorf("%s: score %d with issues %q, want %d with %q", tt.name, h.Score, h.Issues, tt.score, tt.issues)
+			continue
+		}
+		for i, want := range tt.issues {
+			if strings.HasPrefix(h.Issues[i], want) == false {
+				t.Errorf("%s: issue %q, want %q", tt.name, h.Issues[i], want)
+			}
+		}
+	}
+}
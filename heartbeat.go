@@ -0,0 +1,63 @@
+// heartbeat.go
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* Writes a heartbeat row on the master when the heartbeat interval has elapsed. It runs from the monitor loop, so no heartbeat can be written while a switchover or failover is in progress. */
+func (c *Cluster) heartbeatCheck() {
+	if *hbTable == "" || c.master == nil || c.master.State == STATE_FAILED || c.master.ReadOnly == "ON" || isStandby() || *dryRun {
+		return
+	}
+	if time.Since(c.lastHeartbeat) < time.Duration(*hbInterval)*time.Second {
+		return
+	}
+	c.lastHeartbeat = time.Now()
+	err := c.master.writeHeartbeat()
+	if err != nil {
+		alertLog("WARN : Could not write heartbeat on master %s: %s", c.master.URL, err)
+	}
+}
+
+/* Records the master's current time. The heartbeat table is only created when a write fails, typically the first one on a new master, and the write retried. The statements are replicated like any write. */
+func (sm *ServerMonitor) writeHeartbeat() error {
+	write := fmt.Sprintf("REPLACE INTO %s (server_id, ts) VALUES (%d, UTC_TIMESTAMP(6))", *hbTable, sm.ServerId)
+	err := sm.backend().Exec(write)
+	if err == nil {
+		return nil
+	}
+	if i := strings.Index(*hbTable, "."); i > 0 {
+		err = sm.backend().Exec("CREATE DATABASE IF NOT EXISTS " + (*hbTable)[:i])
+		if err != nil {
+			return err
+		}
+	}
+	err = sm.backend().Exec("CREATE TABLE IF NOT EXISTS " + *hbTable + " (server_id INT UNSIGNED NOT NULL PRIMARY KEY, ts DATETIME(6) NOT NULL)")
+	if err != nil {
+		return err
+	}
+	return sm.backend().Exec(write)
+}
+
+/* Returns the replication lag of a slave in seconds, measured as the age of the last heartbeat of the master it applied. Clocks of the master and slave must be synchronized. */
+func (sm *ServerMonitor) heartbeatLag() (int64, bool) {
+	if *hbTable == "" || sm.cluster.master == nil {
+		return 0, false
+	}
+	rows, err := sm.query("SELECT TIMESTAMPDIFF(MICROSECOND, ts, UTC_TIMESTAMP(6)) AS lag FROM "+*hbTable+" WHERE server_id = ?", sm.cluster.master.ServerId)
+	if err != nil || len(rows) == 0 {
+		return 0, false
+	}
+	us, err := strconv.ParseInt(rows[0]["lag"], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	if us < 0 {
+		us = 0
+	}
+	return us / 1000000, true
+}
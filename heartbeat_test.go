@@ -0,0 +1,76 @@
+// heartbeat_test.go
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const simLagQuery = "SELECT TIMESTAMPDIFF(MICROSECOND, ts, UTC_TIMESTAMP(6)) AS lag FROM repmgr.heartbeat WHERE server_id = ?"
+
+func TestHeartbeatWrite(t *testing.T) {
+	defer func(tb string) { *hbTable = tb }(*hbTable)
+	*hbTable = "repmgr.heartbeat"
+	write := "REPLACE INTO repmgr.heartbeat (server_id, ts) VALUES (1, UTC_TIMESTAMP(6))"
+	tests := []struct {
+		name     string
+		readOnly string
+		fail     string
+		execs    []string
+	}{
+		{"heartbeat", "OFF", "", []string{write}},
+		{"missing table", "OFF", "REPLACE", []string{write, "CREATE DATABASE IF NOT EXISTS repmgr", "CREATE TABLE IF NOT EXISTS repmgr.heartbeat (server_id INT UNSIGNED NOT NULL PRIMARY KEY, ts DATETIME(6) NOT NULL)", write}},
+		{"read-only master", "ON", "", nil},
+	}
+	for _, tt := range tests {
+		sims := simCluster(t, simTopology())
+		current.master = current.findMaster(true)
+		current.master.ReadOnly = tt.readOnly
+		sims["db1:3306"].fail = tt.fail
+		current.lastHeartbeat = time.Time{}
+		current.heartbeatCheck()
+		// Within the interval nothing more is written
+		current.heartbeatCheck()
+		if reflect.DeepEqual(sims["db1:3306"].execs, tt.execs) == false {
+			t.Errorf("%s: statements %q, want %q", tt.name, sims["db1:3306"].execs, tt.execs)
+		}
+	}
+}
+
+func TestHeartbeatLag(t *testing.T) {
+	defer func(tb string) { *hbTable = tb }(*hbTable)
+	*hbTable = "repmgr.heartbeat"
+	tests := []struct {
+		name string
+		lag  string // microseconds, empty without heartbeat row
+		want int64
+		ok   bool
+	}{
+		{"lagging", "2500000", 2, true},
+		{"clock skew", "-300", 0, true},
+		{"no heartbeat", "", 0, false},
+	}
+	for _, tt := range tests {
+		sims := simCluster(t, simTopology())
+		current.master = current.findMaster(true)
+		if tt.lag != "" {
+			sims["db2:3306"].rows = map[string][]map[string]string{simLagQuery: {{"lag": tt.lag}}}
+		}
+		lag, ok := simServerByURL("db2:3306").heartbeatLag()
+		if lag != tt.want || ok != tt.ok {
+			t.Errorf("%s: heartbeatLag() = %d, %v, want %d, %v", tt.name, lag, ok, tt.want, tt.ok)
+		}
+	}
+
+	// The heartbeat lag replaces Seconds_Behind_Master in the delays the monitor checks
+	sims := simCluster(t, simTopology())
+	current.master = current.findMaster(true)
+	current.master.State = STATE_MASTER
+	sims["db3:3306"].rows = map[string][]map[string]string{simLagQuery: {{"lag": "120000000"}}}
+	current.refreshTopology(context.Background())
+	if d2, d3 := simServerByURL("db2:3306").Delay.Int64, simServerByURL("db3:3306").Delay.Int64; d2 != 0 || d3 != 120 {
+		t.Errorf("delays %d and %d after the refresh, want 0 and 120", d2, d3)
+	}
+}
@@ -0,0 +1,162 @@
+// history.go
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+/* Monitoring measures of a server at a point in time, or averaged over one minute once downsampled */
+type Sample struct {
+	Time       time.Time
+	Count      int
+	Delay      float64
+	MaxDelay   int64
+	BinlogRate float64
+	ApplyRate  float64
+}
+
+/* Recent raw samples and older one minute aggregates of a server */
+type ServerHistory struct {
+	Raw     []Sample
+	Minutes []Sample
+}
+
+var (
+	history      = make(map[string]*ServerHistory)
+	historyLock  sync.Mutex
+	lastCompact  time.Time
+	historyDirty bool
+)
+
+/* Records the measures of the master and slaves refreshed by the last display */
+func (c *Cluster) recordSamples() {
+	recordSample(c.master)
+	for _, sl := range c.slaves {
+		recordSample(sl)
+	}
+}
+
+/* Records the current measures of a server in the sample store */
+func recordSample(sm *ServerMonitor) {
+	if *historyFile == "" || sm.State == STATE_FAILED {
+		return
+	}
+	historyLock.Lock()
+	defer historyLock.Unlock()
+	h, ok := history[sm.URL]
+	if ok == false {
+		h = new(ServerHistory)
+		history[sm.URL] = h
+	}
+	h.Raw = append(h.Raw, Sample{Time: time.Now(), Count: 1, Delay: float64(sm.Delay.Int64), MaxDelay: sm.Delay.Int64, BinlogRate: sm.BinlogRate, ApplyRate: sm.ApplyRate})
+	historyDirty = true
+}
+
+/* Compacts and saves the sample store once a minute */
+func historyCheck() {
+	if *historyFile == "" || time.Since(lastCompact) < time.Minute {
+		return
+	}
+	lastCompact = time.Now()
+	historyLock.Lock()
+	defer historyLock.Unlock()
+	compactHistory(time.Now())
+	if historyDirty {
+		saveHistory()
+		historyDirty = false
+	}
+}
+
+/* Downsamples the raw samples older than the raw retention into one minute aggregates, and drops the aggregates older than the aggregate retention */
+func compactHistory(now time.Time) {
+	rawLimit := now.Add(-time.Duration(*historyRaw) * time.Hour)
+	aggLimit := now.AddDate(0, 0, -int(*historyDays))
+	for url, h := range history {
+		i := 0
+		for i < len(h.Raw) && h.Raw[i].Time.Before(rawLimit) {
+			h.Minutes = addToMinute(h.Minutes, h.Raw[i])
+			i++
+		}
+		h.Raw = h.Raw[i:]
+		j := 0
+		for j < len(h.Minutes) && h.Minutes[j].Time.Before(aggLimit) {
+			j++
+		}
+		h.Minutes = h.Minutes[j:]
+		if len(h.Raw) == 0 && len(h.Minutes) == 0 {
+			delete(history, url)
+		}
+		if i > 0 || j > 0 {
+			historyDirty = true
+		}
+	}
+}
+
+/* Merges a sample into the aggregate of its minute, samples being added in time order */
+func addToMinute(l []Sample, s Sample) []Sample {
+	t := s.Time.Truncate(time.Minute)
+	n := len(l)
+	if n == 0 || l[n-1].Time.Equal(t) == false {
+		s.Time = t
+		return append(l, s)
+	}
+	m := &l[n-1]
+	total := m.Count + s.Count
+	m.Delay = (m.Delay*float64(m.Count) + s.Delay*float64(s.Count)) / float64(total)
+	m.BinlogRate = (m.BinlogRate*float64(m.Count) + s.BinlogRate*float64(s.Count)) / float64(total)
+	m.ApplyRate = (m.ApplyRate*float64(m.Count) + s.ApplyRate*float64(s.Count)) / float64(total)
+	if s.MaxDelay > m.MaxDelay {
+		m.MaxDelay = s.MaxDelay
+	}
+	m.Count = total
+	return l
+}
+
+/* Loads the sample store if it exists */
+func loadHistory() error {
+	if *historyFile == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(*historyFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, &history)
+}
+
+/* Writes the sample store, replacing the file atomically like the state file */
+func saveHistory() {
+	b, err := json.Marshal(history)
+	if err != nil {
+		alertLog("WARN : Could not encode monitoring history: %s", err)
+		return
+	}
+	tmp := *historyFile + ".tmp"
+	err = ioutil.WriteFile(tmp, b, 0640)
+	if err == nil {
+		err = os.Rename(tmp, *historyFile)
+	}
+	if err != nil {
+		alertLog("WARN : Could not write monitoring history: %s", err)
+	}
+}
+
+/* Returns a copy of the history of a server, aggregates first so that samples are in time order */
+func serverHistory(url string) []Sample {
+	historyLock.Lock()
+	defer historyLock.Unlock()
+	h, ok := history[url]
+	if ok == false {
+		return nil
+	}
+	l := make([]Sample, 0, len(h.Minutes)+len(h.Raw))
+	l = append(l, h.Minutes...)
+	return append(l, h.Raw...)
+}
@@ -0,0 +1,80 @@
+// history_test.go
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCompactHistory(t *testing.T) {
+	defer func(r, d int64) { *historyRaw, *historyDays, history = r, d, make(map[string]*ServerHistory) }(*historyRaw, *historyDays)
+	*historyRaw, *historyDays = 24, 30
+	now := time.Date(2016, 6, 30, 12, 0, 30, 0, time.UTC)
+	old := now.Add(-25 * time.Hour).Truncate(time.Minute)
+	history = map[string]*ServerHistory{
+		"db2:3306": {
+			Minutes: []Sample{{Time: now.AddDate(0, 0, -31), Count: 60, Delay: 1}},
+			Raw: []Sample{
+				{Time: old.Add(10 * time.Second), Count: 1, Delay: 10, MaxDelay: 10, BinlogRate: 100},
+				{Time: old.Add(40 * time.Second), Count: 1, Delay: 20, MaxDelay: 20, BinlogRate: 300},
+				{Time: old.Add(70 * time.Second), Count: 1, Delay: 5, MaxDelay: 5},
+				{Time: now.Add(-time.Hour), Count: 1, Delay: 2, MaxDelay: 2},
+			},
+		},
+		"db3:3306": {Minutes: []Sample{{Time: now.AddDate(0, 0, -40), Count: 60}}},
+	}
+	historyDirty = false
+	compactHistory(now)
+	if _, ok := history["db3:3306"]; ok {
+		t.Error("history of db3:3306 kept after all of its samples expired")
+	}
+	h := history["db2:3306"]
+	want := []Sample{
+		{Time: old, Count: 2, Delay: 15, MaxDelay: 20, BinlogRate: 200},
+		{Time: old.Add(time.Minute), Count: 1, Delay: 5, MaxDelay: 5},
+	}
+	if len(h.Minutes) != len(want) {
+		t.Fatalf("aggregates %+v, want %+v", h.Minutes, want)
+	}
+	for i := range want {
+		if h.Minutes[i] != want[i] {
+			t.Errorf("aggregate %d = %+v, want %+v", i, h.Minutes[i], want[i])
+		}
+	}
+	if len(h.Raw) != 1 || h.Raw[0].Delay != 2 {
+		t.Errorf("raw samples %+v, want the one of the last hour", h.Raw)
+	}
+	if historyDirty == false {
+		t.Error("compacted history not marked for saving")
+	}
+	if l := serverHistory("db2:3306"); len(l) != 3 || l[0].Time.Equal(old) == false || l[2].Delay != 2 {
+		t.Errorf("serverHistory() = %+v, want the aggregates then the raw sample", l)
+	}
+}
+
+func TestHistoryStore(t *testing.T) {
+	defer func(f string) { *historyFile, history, lastCompact = f, make(map[string]*ServerHistory), time.Time{} }(*historyFile)
+	*historyFile = filepath.Join(t.TempDir(), "history.json")
+	history, lastCompact = make(map[string]*ServerHistory), time.Time{}
+	simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 3 }))
+	current.master = current.findMaster(true)
+	simServerByURL("db2:3306").Delay.Int64 = 7
+	current.recordSamples()
+	if _, ok := history["db3:3306"]; ok {
+		t.Error("sample recorded for a failed server")
+	}
+	historyCheck()
+
+	// A restart reads back the samples
+	history = make(map[string]*ServerHistory)
+	if err := loadHistory(); err != nil {
+		t.Fatalf("loadHistory() = %s", err)
+	}
+	if l := serverHistory("db2:3306"); len(l) != 1 || l[0].Delay != 7 || l[0].MaxDelay != 7 {
+		t.Errorf("loaded history of db2:3306 %+v, want one sample of 7 seconds", l)
+	}
+	if len(serverHistory("db1:3306")) != 1 {
+		t.Error("no sample loaded for the master")
+	}
+}
@@ -0,0 +1,143 @@
+// hooks.go
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HOOK_PRE_FAILOVER     string = "pre-failover"
+	HOOK_POST_FAILOVER    string = "post-failover"
+	HOOK_PRE_SWITCHOVER   string = "pre-switchover"
+	HOOK_POST_SWITCHOVER  string = "post-switchover"
+	HOOK_SLAVE_FAIL       string = "on-slave-fail"
+	HOOK_MASTER_RECOVERED string = "on-master-recovered"
+)
+
+var hookEvents = []string{HOOK_PRE_FAILOVER, HOOK_POST_FAILOVER, HOOK_PRE_SWITCHOVER, HOOK_POST_SWITCHOVER, HOOK_SLAVE_FAIL, HOOK_MASTER_RECOVERED}
+
+/* A script run on an event. With the abort policy, a failing pre hook cancels the operation; other failures are only logged. */
+type Hook struct {
+	Event   string
+	Path    string
+	Timeout time.Duration
+	Abort   bool
+}
+
+/* Servers involved in a hook event */
+type hookContext struct {
+	Cluster   string
+	OldMaster *ServerMonitor
+	NewMaster *ServerMonitor
+	Server    *ServerMonitor
+}
+
+var hooks []Hook
+
+/* Loads hooks, one per line: <event> <path> [timeout=<seconds>] [on-failure=ignore|abort] */
+func loadHooks(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	n := 0
+	for scanner.Scan() {
+		n++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 || contains(hookEvents, fields[0]) == false {
+			return errors.New(fmt.Sprintf("%s line %d: expected <event> <path>, with event one of %s", file, n, strings.Join(hookEvents, ", ")))
+		}
+		h := Hook{Event: fields[0], Path: fields[1], Timeout: 30 * time.Second}
+		for _, opt := range fields[2:] {
+			switch {
+			case strings.HasPrefix(opt, "timeout="):
+				sec, err := strconv.Atoi(strings.TrimPrefix(opt, "timeout="))
+				if err != nil {
+					return errors.New(fmt.Sprintf("%s line %d: invalid timeout %s", file, n, opt))
+				}
+				h.Timeout = time.Duration(sec) * time.Second
+			case opt == "on-failure=abort":
+				h.Abort = true
+			case opt == "on-failure=ignore":
+			default:
+				return errors.New(fmt.Sprintf("%s line %d: unknown option %s", file, n, opt))
+			}
+		}
+		hooks = append(hooks, h)
+	}
+	return scanner.Err()
+}
+
+/* Registers the legacy pre and post failover scripts as hooks of both failover and switchover */
+func legacyHooks() {
+	if *preScript != "" {
+		hooks = append(hooks, Hook{Event: HOOK_PRE_FAILOVER, Path: *preScript, Timeout: 30 * time.Second}, Hook{Event: HOOK_PRE_SWITCHOVER, Path: *preScript, Timeout: 30 * time.Second})
+	}
+	if *postScript != "" {
+		hooks = append(hooks, Hook{Event: HOOK_POST_FAILOVER, Path: *postScript, Timeout: 30 * time.Second}, Hook{Event: HOOK_POST_SWITCHOVER, Path: *postScript, Timeout: 30 * time.Second})
+	}
+}
+
+/* Runs the hooks of an event in order. Returns an error if a hook with the abort policy failed. */
+func runHooks(event string, hc hookContext) error {
+	for _, h := range hooks {
+		if h.Event != event {
+			continue
+		}
+		if *dryRun {
+			alertLog("DRY-RUN: would run %s hook %s", event, h.Path)
+			continue
+		}
+		alertLog("INFO : Running %s hook %s", event, h.Path)
+		out, err := h.run(hc)
+		if err != nil {
+			alertLog("ERROR: %s hook %s failed: %s %s", event, h.Path, err, strings.TrimSpace(string(out)))
+			if h.Abort {
+				return errors.New(fmt.Sprintf("%s hook %s failed: %s", event, h.Path, err))
+			}
+			continue
+		}
+		alertLog("INFO : %s hook %s complete: %s", event, h.Path, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+/* Runs the hook with the old and new master hosts as arguments, like the legacy scripts, and the event details as environment variables */
+func (h Hook) run(hc hookContext) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.Timeout)
+	defer cancel()
+	var args []string
+	env := append(os.Environ(), "REPMGR_EVENT="+h.Event, "REPMGR_CLUSTER="+hc.Cluster)
+	if hc.OldMaster != nil {
+		args = append(args, hc.OldMaster.Host)
+		env = append(env, "REPMGR_OLD_MASTER_HOST="+hc.OldMaster.Host, "REPMGR_OLD_MASTER_PORT="+hc.OldMaster.Port, "REPMGR_OLD_MASTER_GTID="+hc.OldMaster.CurrentGtid)
+	}
+	if hc.NewMaster != nil {
+		args = append(args, hc.NewMaster.Host)
+		env = append(env, "REPMGR_NEW_MASTER_HOST="+hc.NewMaster.Host, "REPMGR_NEW_MASTER_PORT="+hc.NewMaster.Port, "REPMGR_NEW_MASTER_GTID="+hc.NewMaster.CurrentGtid)
+	}
+	if hc.Server != nil {
+		args = append(args, hc.Server.Host)
+		env = append(env, "REPMGR_SERVER_HOST="+hc.Server.Host, "REPMGR_SERVER_PORT="+hc.Server.Port, "REPMGR_SERVER_GTID="+hc.Server.CurrentGtid)
+	}
+	cmd := exec.CommandContext(ctx, h.Path, args...)
+	cmd.Env = env
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return out, errors.New(fmt.Sprintf("timeout after %s", h.Timeout))
+	}
+	return out, err
+}
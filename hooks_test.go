@@ -0,0 +1,113 @@
+// hooks_test.go
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+/* Writes a hook script appending its event, arguments and environment to out, then exiting with status */
+func simHook(t *testing.T, out string, status int) string {
+	file := filepath.Join(t.TempDir(), "hook.sh")
+	script := "#!/bin/sh\necho \"$REPMGR_EVENT [$*] $REPMGR_OLD_MASTER_HOST:$REPMGR_OLD_MASTER_PORT $REPMGR_NEW_MASTER_HOST:$REPMGR_NEW_MASTER_PORT\" >> " + out + "\nexit " + strconv.Itoa(status) + "\n"
+	if err := ioutil.WriteFile(file, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestLoadHooks(t *testing.T) {
+	defer func() { hooks = nil }()
+	tests := []struct {
+		name  string
+		conf  string
+		want  []Hook
+		error bool
+	}{
+		{"options", "# hooks\npre-failover /bin/fence timeout=5 on-failure=abort\n\npost-failover /bin/notify on-failure=ignore\n",
+			[]Hook{{HOOK_PRE_FAILOVER, "/bin/fence", 5 * time.Second, true}, {HOOK_POST_FAILOVER, "/bin/notify", 30 * time.Second, false}}, false},
+		{"unknown event", "pre-backup /bin/fence\n", nil, true},
+		{"missing path", "pre-failover\n", nil, true},
+		{"invalid timeout", "pre-failover /bin/fence timeout=soon\n", nil, true},
+		{"unknown option", "pre-failover /bin/fence retries=3\n", nil, true},
+	}
+	for _, tt := range tests {
+		file := filepath.Join(t.TempDir(), "hooks")
+		if err := ioutil.WriteFile(file, []byte(tt.conf), 0600); err != nil {
+			t.Fatal(err)
+		}
+		hooks = nil
+		err := loadHooks(file)
+		if tt.error {
+			if err == nil {
+				t.Errorf("%s: loadHooks() loaded %v, want an error", tt.name, hooks)
+			}
+			continue
+		}
+		if err != nil || len(hooks) != len(tt.want) {
+			t.Errorf("%s: loadHooks() = %v, %v, want %v", tt.name, hooks, err, tt.want)
+			continue
+		}
+		for i := range hooks {
+			if hooks[i] != tt.want[i] {
+				t.Errorf("%s: hook %d = %v, want %v", tt.name, i, hooks[i], tt.want[i])
+			}
+		}
+	}
+}
+
+func TestFailoverHooks(t *testing.T) {
+	defer func(f string) { *failover, hooks = f, nil }(*failover)
+	*failover = "force"
+	tests := []struct {
+		name     string
+		status   int
+		abort    bool
+		promoted string
+		want     []string
+	}{
+		{"hooks run", 0, false, "db3:3306", []string{
+			"pre-failover [db1 db3] db1:3306 db3:3306",
+			"post-failover [db1 db3] db1:3306 db3:3306"}},
+		{"failure ignored", 1, false, "db3:3306", []string{
+			"pre-failover [db1 db3] db1:3306 db3:3306",
+			"post-failover [db1 db3] db1:3306 db3:3306"}},
+		{"failure aborts", 1, true, "", []string{
+			"pre-failover [db1 db3] db1:3306 db3:3306"}},
+	}
+	for _, tt := range tests {
+		out := filepath.Join(t.TempDir(), "out")
+		path := simHook(t, out, tt.status)
+		hooks = []Hook{{HOOK_PRE_FAILOVER, path, time.Second, tt.abort}, {HOOK_POST_FAILOVER, path, time.Second, tt.abort}}
+		sims := simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 1 }))
+		current.master = current.findMaster(false)
+		nmUrl, _ := current.Failover(context.Background())
+		if nmUrl != tt.promoted {
+			t.Errorf("%s: Failover() promoted %q, want %q", tt.name, nmUrl, tt.promoted)
+		}
+		if tt.promoted == "" && sims["db2:3306"].ran("CHANGE MASTER") {
+			t.Errorf("%s: db2 repointed after the pre-failover hook aborted", tt.name)
+		}
+		b, _ := ioutil.ReadFile(out)
+		if got := strings.Split(strings.TrimSpace(string(b)), "\n"); strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("%s: hooks ran\n%s\nwant\n%s", tt.name, strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+		}
+	}
+}
+
+func TestHookTimeout(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "hook.sh")
+	if err := ioutil.WriteFile(file, []byte("#!/bin/sh\nexec sleep 5\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	_, err := Hook{HOOK_SLAVE_FAIL, file, 100 * time.Millisecond, false}.run(hookContext{})
+	if err == nil || strings.HasPrefix(err.Error(), "timeout") == false || time.Since(start) > 2*time.Second {
+		t.Errorf("run() = %v after %s, want a timeout after 100ms", err, time.Since(start))
+	}
+}
@@ -0,0 +1,53 @@
+// ignore.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+/* Role keywords of the ignore list, matched against the role label of the hosts */
+const (
+	ROLE_BACKUP  string = "backup"
+	ROLE_DELAYED string = "delayed"
+)
+
+/* Checks the entries of an ignore list, which are host:[port], wildcard patterns or role keywords */
+func checkIgnoreList(s string) error {
+	for _, e := range strings.Split(s, ",") {
+		_, err := path.Match(strings.TrimSpace(e), "")
+		if err != nil {
+			return errors.New(fmt.Sprintf("invalid ignored server pattern %s", e))
+		}
+	}
+	return nil
+}
+
+/* True when the server is excluded from promotion by an entry of the ignore list: its host:[port], a wildcard pattern of its host or host:port such as *.dr.example.com, or the backup and delayed role keywords matching its role label. Delayed replicas also match the delayed keyword through their MASTER_DELAY. */
+func (sm *ServerMonitor) ignored() bool {
+	for _, e := range sm.cluster.ignoreList {
+		if sm.matchesIgnore(strings.TrimSpace(e)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (sm *ServerMonitor) matchesIgnore(e string) bool {
+	switch e {
+	case "":
+		return false
+	case ROLE_BACKUP, ROLE_DELAYED:
+		return sm.labelValue("role") == e || (e == ROLE_DELAYED && sm.isDelayed())
+	}
+	if strings.ContainsAny(e, "*?[") == false {
+		return hostKey(e) == hostKey(sm.URL)
+	}
+	if ok, _ := path.Match(e, sm.URL); ok {
+		return true
+	}
+	ok, _ := path.Match(e, sm.Host)
+	return ok
+}
@@ -0,0 +1,364 @@
+// kubernetes.go
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* Files of the service account mounted in the pods */
+var kubeAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount/"
+
+/* Client of the Kubernetes API authenticated with the service account of the pod running replication-manager */
+type kubeClient struct {
+	base      string
+	namespace string
+	client    *http.Client
+}
+
+/* Pod fields used by the monitor */
+type kubePod struct {
+	Metadata struct {
+		Name            string
+		Labels          map[string]string
+		ResourceVersion string
+	}
+	Spec struct {
+		Hostname  string
+		Subdomain string
+	}
+	Status struct {
+		PodIP      string
+		Phase      string
+		Conditions []struct{ Type, Status string }
+	}
+}
+
+var (
+	kube     *kubeClient
+	kubePods = make(map[string]kubePod) // database pods seen by the watch, by name
+	kubeLock sync.Mutex
+)
+
+/* Returns a client of the API server of the cluster the pod runs in */
+func newKubeClient() (*kubeClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" {
+		return nil, errors.New("Not running in a Kubernetes pod, KUBERNETES_SERVICE_HOST is not set")
+	}
+	pem, err := ioutil.ReadFile(kubeAccountDir + "ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if pool.AppendCertsFromPEM(pem) == false {
+		return nil, errors.New("no certificate found in " + kubeAccountDir + "ca.crt")
+	}
+	namespace := *kubeNamespace
+	if namespace == "" {
+		ns, err := ioutil.ReadFile(kubeAccountDir + "namespace")
+		if err != nil {
+			return nil, err
+		}
+		namespace = strings.TrimSpace(string(ns))
+	}
+	return &kubeClient{
+		base:      "https://" + net.JoinHostPort(host, port),
+		namespace: namespace,
+		client:    &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}},
+	}, nil
+}
+
+/* Sends a request to the API server and decodes its JSON response into v. The token is read at each request since the kubelet rotates it. */
+func (k *kubeClient) call(method string, path string, contentType string, in interface{}, v interface{}) error {
+	resp, err := k.do(method, path, contentType, in, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (k *kubeClient) do(method string, path string, contentType string, in interface{}, timeout time.Duration) (*http.Response, error) {
+	token, err := ioutil.ReadFile(kubeAccountDir + "token")
+	if err != nil {
+		return nil, err
+	}
+	var body bytes.Buffer
+	if in != nil {
+		json.NewEncoder(&body).Encode(in)
+	}
+	req, err := http.NewRequest(method, k.base+path, &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	client := *k.client
+	client.Timeout = timeout
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		data, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, errors.New(fmt.Sprintf("%s %s returned %s: %s", method, path, resp.Status, strings.TrimSpace(string(data))))
+	}
+	return resp, nil
+}
+
+/* Returns the API path of the resources of a kind in the namespace */
+func (k *kubeClient) path(kind string) string {
+	return "/api/v1/namespaces/" + k.namespace + "/" + kind
+}
+
+/* Lists the database pods selected by the kube-selector option */
+func (k *kubeClient) listPods() ([]kubePod, string, error) {
+	var res struct {
+		Metadata struct{ ResourceVersion string }
+		Items    []kubePod
+	}
+	err := k.call("GET", k.path("pods")+"?labelSelector="+url.QueryEscape(*kubeSelector), "", nil, &res)
+	return res.Items, res.Metadata.ResourceVersion, err
+}
+
+/* Returns the address of the pod, its stable DNS name under the headless service of a StatefulSet, or else its IP */
+func (p kubePod) address() string {
+	if p.Spec.Hostname != "" && p.Spec.Subdomain != "" {
+		return p.Spec.Hostname + "." + p.Spec.Subdomain + "." + kube.namespace + ".svc"
+	}
+	return p.Status.PodIP
+}
+
+func (p kubePod) ready() bool {
+	for _, c := range p.Status.Conditions {
+		if c.Type == "Ready" {
+			return c.Status == "True"
+		}
+	}
+	return false
+}
+
+/* Returns the hosts option built from the database pods, for clusters whose hosts are not listed */
+func kubeHosts() (string, error) {
+	pods, _, err := kube.listPods()
+	if err != nil {
+		return "", err
+	}
+	var hosts []string
+	for _, p := range pods {
+		if p.address() != "" {
+			hosts = append(hosts, net.JoinHostPort(p.address(), *kubePort))
+		}
+	}
+	if len(hosts) == 0 {
+		return "", errors.New(fmt.Sprintf("no pod with an address matches %s in namespace %s", *kubeSelector, kube.namespace))
+	}
+	sort.Strings(hosts)
+	return strings.Join(hosts, ","), nil
+}
+
+/* Watches the database pods and logs their creation, deletion and readiness changes, keeping the pods seen for kubeCheck. It is meant to run in its own goroutine. */
+func kubeWatch() {
+	for {
+		pods, version, err := kube.listPods()
+		if err != nil {
+			log.Printf("WARN : Could not list the database pods: %s", err)
+			time.Sleep(10 * time.Second)
+			continue
+		}
+		kubeLock.Lock()
+		kubePods = make(map[string]kubePod)
+		for _, p := range pods {
+			kubePods[p.Metadata.Name] = p
+		}
+		kubeLock.Unlock()
+		err = kubeWatchFrom(version)
+		if err != nil {
+			log.Printf("WARN : Watch of the database pods interrupted: %s", err)
+			time.Sleep(time.Second)
+		}
+	}
+}
+
+/* Follows the pod events from the resource version until the API server closes the watch */
+func kubeWatchFrom(version string) error {
+	resp, err := kube.do("GET", kube.path("pods")+"?watch=true&labelSelector="+url.QueryEscape(*kubeSelector)+"&resourceVersion="+version, "", nil, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var ev struct {
+			Type   string
+			Object kubePod
+		}
+		err = dec.Decode(&ev)
+		if err != nil {
+			return err
+		}
+		if ev.Type == "ERROR" {
+			// The resource version expired, the pods are listed again
+			return nil
+		}
+		p := ev.Object
+		kubeLock.Lock()
+		old, seen := kubePods[p.Metadata.Name]
+		if ev.Type == "DELETED" {
+			delete(kubePods, p.Metadata.Name)
+		} else {
+			kubePods[p.Metadata.Name] = p
+		}
+		kubeLock.Unlock()
+		switch {
+		case ev.Type == "DELETED":
+			log.Printf("WARN : Database pod %s (%s) was deleted", p.Metadata.Name, p.address())
+		case seen == false:
+			log.Printf("INFO : Database pod %s (%s) was created", p.Metadata.Name, p.address())
+		case old.ready() && p.ready() == false:
+			log.Printf("WARN : Database pod %s (%s) is not ready", p.Metadata.Name, p.address())
+		case old.ready() == false && p.ready():
+			log.Printf("INFO : Database pod %s (%s) is ready", p.Metadata.Name, p.address())
+		}
+	}
+}
+
+/* Returns the pod of a server, matched by address or by IP */
+func kubePodOf(s *ServerMonitor, pods []kubePod) *kubePod {
+	for i, p := range pods {
+		if p.address() == s.Host || (p.Status.PodIP != "" && p.Status.PodIP == s.IP) {
+			return &pods[i]
+		}
+	}
+	return nil
+}
+
+/* Redirects the traffic of the writer service to the master when it changed since the last successful update. Pods are labeled with their role, which the selector of the service matches, and a service without selector gets its endpoints replaced by the master, the EndpointSlices being mirrored from them by Kubernetes. */
+func (c *Cluster) kubeCheck() {
+	if kube == nil || c.master == nil || c.master.State == STATE_FAILED || isStandby() || c.master.URL == c.kubePublished {
+		return
+	}
+	if *dryRun {
+		alertLog("DRY-RUN: would redirect Kubernetes traffic to %s", c.master.URL)
+		c.kubePublished = c.master.URL
+		return
+	}
+	err := c.kubePublish()
+	if err != nil {
+		alertLog("WARN : Could not redirect Kubernetes traffic to %s: %s", c.master.URL, err)
+		return
+	}
+	audit("Redirected Kubernetes traffic to %s", c.master.URL)
+	c.kubePublished = c.master.URL
+}
+
+func (c *Cluster) kubePublish() error {
+	var pods []kubePod
+	if *kubeSelector != "" {
+		kubeLock.Lock()
+		for _, p := range kubePods {
+			pods = append(pods, p)
+		}
+		kubeLock.Unlock()
+		if len(pods) == 0 {
+			var err error
+			pods, _, err = kube.listPods()
+			if err != nil {
+				return err
+			}
+		}
+		// The master label is set after the others are cleared, so that the service never selects two writers
+		mp := kubePodOf(c.master, pods)
+		if mp == nil {
+			return errors.New(fmt.Sprintf("no pod matches master %s", c.master.URL))
+		}
+		for _, p := range pods {
+			if p.Metadata.Name != mp.Metadata.Name && p.Metadata.Labels[*kubeLabel] != "slave" {
+				err := kubeLabelPod(p.Metadata.Name, "slave")
+				if err != nil {
+					return err
+				}
+			}
+		}
+		err := kubeLabelPod(mp.Metadata.Name, "master")
+		if err != nil {
+			return err
+		}
+		alertLog("INFO : Labeled pod %s %s=master", mp.Metadata.Name, *kubeLabel)
+	}
+	if *kubeService == "" {
+		return nil
+	}
+	var svc struct {
+		Spec struct {
+			Selector map[string]string
+			Ports    []struct {
+				Name       string
+				Port       int
+				Protocol   string
+				TargetPort interface{}
+			}
+		}
+	}
+	err := kube.call("GET", kube.path("services")+"/"+*kubeService, "", nil, &svc)
+	if err != nil {
+		return err
+	}
+	if len(svc.Spec.Selector) > 0 {
+		if svc.Spec.Selector[*kubeLabel] != "master" {
+			return errors.New(fmt.Sprintf("the selector of service %s does not include %s=master", *kubeService, *kubeLabel))
+		}
+		return nil
+	}
+	ports := []map[string]interface{}{}
+	for _, p := range svc.Spec.Ports {
+		port := p.Port
+		if tp, ok := p.TargetPort.(float64); ok {
+			port = int(tp)
+		}
+		ports = append(ports, map[string]interface{}{"name": p.Name, "port": port, "protocol": p.Protocol})
+	}
+	ep := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Endpoints",
+		"metadata":   map[string]interface{}{"name": *kubeService, "labels": map[string]string{"app.kubernetes.io/managed-by": "replication-manager"}},
+		"subsets":    []map[string]interface{}{{"addresses": []map[string]string{{"ip": c.master.IP}}, "ports": ports}},
+	}
+	err = kube.call("PUT", kube.path("endpoints")+"/"+*kubeService, "application/json", ep, nil)
+	if err != nil && strings.Contains(err.Error(), "404") {
+		err = kube.call("POST", kube.path("endpoints"), "application/json", ep, nil)
+	}
+	if err != nil {
+		return err
+	}
+	alertLog("INFO : Pointed the endpoints of service %s at %s", *kubeService, c.master.IP)
+	return nil
+}
+
+/* Sets the role label of a pod with a JSON merge patch */
+func kubeLabelPod(name string, role string) error {
+	patch := map[string]interface{}{"metadata": map[string]interface{}{"labels": map[string]string{*kubeLabel: role}}}
+	return kube.call("PATCH", kube.path("pods")+"/"+name, "application/merge-patch+json", patch, nil)
+}
@@ -0,0 +1,244 @@
+// kubernetes_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+/* Returns a database pod, with a stable name under the db headless service if host is set */
+func simPod(name string, ip string, host string, ready bool, role string) kubePod {
+	var p kubePod
+	p.Metadata.Name, p.Status.PodIP, p.Status.Phase = name, ip, "Running"
+	if host != "" {
+		p.Spec.Hostname, p.Spec.Subdomain = host, "db"
+	}
+	status := "False"
+	if ready {
+		status = "True"
+	}
+	p.Status.Conditions = append(p.Status.Conditions, struct{ Type, Status string }{"Ready", status})
+	if role != "" {
+		p.Metadata.Labels = map[string]string{*kubeLabel: role}
+	}
+	return p
+}
+
+/* Serves the Kubernetes API of namespace db with the handler, through a client authenticated with the token of a service account */
+func simKube(t *testing.T, h http.HandlerFunc) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer sa-token" {
+			http.Error(w, `{"reason":"Unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}))
+	dir := t.TempDir() + "/"
+	if err := ioutil.WriteFile(dir+"token", []byte("sa-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	accountDir := kubeAccountDir
+	kube, kubeAccountDir, kubePods = &kubeClient{base: srv.URL, namespace: "db", client: &http.Client{}}, dir, map[string]kubePod{}
+	t.Cleanup(func() {
+		kube, kubeAccountDir, kubePods = nil, accountDir, map[string]kubePod{}
+		srv.Close()
+	})
+}
+
+func TestNewKubeClient(t *testing.T) {
+	defer func(d, n string) { kubeAccountDir, *kubeNamespace = d, n }(kubeAccountDir, *kubeNamespace)
+	simEnv(t, map[string]string{"KUBERNETES_SERVICE_HOST": "", "KUBERNETES_SERVICE_PORT": "443"})
+	if _, err := newKubeClient(); err == nil || err.Error() != "Not running in a Kubernetes pod, KUBERNETES_SERVICE_HOST is not set" {
+		t.Errorf("newKubeClient() outside of a pod = %v", err)
+	}
+	os.Setenv("KUBERNETES_SERVICE_HOST", "fd00::1")
+	dir := t.TempDir()
+	kubeAccountDir, *kubeNamespace = dir+"/", ""
+	cert, _ := simCert(t, dir)
+	os.Rename(cert, filepath.Join(dir, "ca.crt"))
+	ioutil.WriteFile(filepath.Join(dir, "namespace"), []byte("db\n"), 0600)
+	k, err := newKubeClient()
+	if err != nil || k.base != "https://[fd00::1]:443" || k.namespace != "db" {
+		t.Fatalf("newKubeClient() = %+v, %v, want the API server in namespace db", k, err)
+	}
+	*kubeNamespace = "mysql"
+	if k, err = newKubeClient(); err != nil || k.namespace != "mysql" {
+		t.Errorf("newKubeClient() = %+v, %v, want the namespace option", k, err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "ca.crt"), []byte("none"), 0600)
+	if _, err = newKubeClient(); err == nil {
+		t.Error("newKubeClient() accepted a CA file without certificate")
+	}
+}
+
+func TestKubeHosts(t *testing.T) {
+	defer func(s string) { *kubeSelector = s }(*kubeSelector)
+	*kubeSelector = "app=mariadb"
+	pods := []kubePod{simPod("db-1", "10.1.0.11", "db-1", true, ""), simPod("db-0", "10.1.0.10", "db-0", true, ""), simPod("pending", "", "", false, ""), simPod("single", "10.1.0.20", "", true, "")}
+	simKube(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/db/pods" || r.URL.Query().Get("labelSelector") != "app=mariadb" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"metadata": map[string]string{"resourceVersion": "100"}, "items": pods})
+	})
+	hosts, err := kubeHosts()
+	if err != nil || hosts != "10.1.0.20:3306,db-0.db.db.svc:3306,db-1.db.db.svc:3306" {
+		t.Errorf("kubeHosts() = %q, %v", hosts, err)
+	}
+	pods = pods[2:3]
+	if _, err = kubeHosts(); err == nil || err.Error() != "no pod with an address matches app=mariadb in namespace db" {
+		t.Errorf("kubeHosts() without address = %v", err)
+	}
+	*kubeSelector = "app=mysql"
+	if _, err = kubeHosts(); err == nil || strings.Contains(err.Error(), "404 Not Found") == false {
+		t.Errorf("kubeHosts() = %v, want the API error", err)
+	}
+}
+
+func TestKubeWatchFrom(t *testing.T) {
+	defer func(s string) { *kubeSelector = s }(*kubeSelector)
+	*kubeSelector = "app=mariadb"
+	var version string
+	simKube(t, func(w http.ResponseWriter, r *http.Request) {
+		version = r.URL.Query().Get("resourceVersion")
+		enc := json.NewEncoder(w)
+		for _, ev := range []struct {
+			Type   string
+			Object kubePod
+		}{
+			{"ADDED", simPod("db-2", "10.1.0.12", "db-2", false, "")},
+			{"MODIFIED", simPod("db-2", "10.1.0.12", "db-2", true, "")},
+			{"MODIFIED", simPod("db-0", "10.1.0.10", "db-0", false, "")},
+			{"MODIFIED", simPod("db-0", "10.1.0.10", "db-0", false, "master")},
+			{"DELETED", simPod("db-1", "10.1.0.11", "db-1", true, "")},
+			{"ERROR", kubePod{}},
+			{"ADDED", simPod("db-3", "10.1.0.13", "db-3", true, "")},
+		} {
+			enc.Encode(ev)
+		}
+	})
+	kubePods = map[string]kubePod{"db-0": simPod("db-0", "10.1.0.10", "db-0", true, ""), "db-1": simPod("db-1", "10.1.0.11", "db-1", true, "")}
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	err := kubeWatchFrom("100")
+	log.SetOutput(ioutil.Discard)
+	if err != nil || version != "100" {
+		t.Errorf("kubeWatchFrom() = %v from version %q, want the watch stopped by the expired version 100", err, version)
+	}
+	want := "INFO : Database pod db-2 (db-2.db.db.svc) was created\n" +
+		"INFO : Database pod db-2 (db-2.db.db.svc) is ready\n" +
+		"WARN : Database pod db-0 (db-0.db.db.svc) is not ready\n" +
+		"WARN : Database pod db-1 (db-1.db.db.svc) was deleted\n"
+	var got []string
+	for _, l := range strings.SplitAfter(out.String(), "\n") {
+		if i := strings.Index(l, "INFO : "); i >= 0 {
+			l = l[i:]
+		} else if i := strings.Index(l, "WARN : "); i >= 0 {
+			l = l[i:]
+		}
+		got = append(got, l)
+	}
+	if strings.Join(got, "") != want {
+		t.Errorf("watch logged:\n%s\nwant:\n%s", out.String(), want)
+	}
+	var names []string
+	for n := range kubePods {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	if reflect.DeepEqual(names, []string{"db-0", "db-2"}) == false || kubePods["db-0"].Metadata.Labels[*kubeLabel] != "master" {
+		t.Errorf("pods %q, want db-0 with its new labels and db-2", names)
+	}
+}
+
+func TestKubeCheck(t *testing.T) {
+	defer func(s, svc string, d bool) { *kubeSelector, *kubeService, *dryRun = s, svc, d }(*kubeSelector, *kubeService, *dryRun)
+	tests := []struct {
+		name      string
+		selector  string
+		service   string
+		svc       string // JSON of the service
+		endpoints bool   // the endpoints of the service exist
+		dryRun    bool
+		calls     []string
+		published string
+	}{
+		{"labels", "app=mariadb", "", "", false, false, []string{
+			`PATCH /api/v1/namespaces/db/pods/db-2 {"metadata":{"labels":{"replication-manager/role":"slave"}}}`,
+			`PATCH /api/v1/namespaces/db/pods/db-1 {"metadata":{"labels":{"replication-manager/role":"master"}}}`,
+		}, "db1:3306"},
+		{"service selecting the master", "app=mariadb", "mysql-writer", `{"spec":{"selector":{"app":"mariadb","replication-manager/role":"master"}}}`, false, false, []string{
+			`PATCH /api/v1/namespaces/db/pods/db-2 {"metadata":{"labels":{"replication-manager/role":"slave"}}}`,
+			`PATCH /api/v1/namespaces/db/pods/db-1 {"metadata":{"labels":{"replication-manager/role":"master"}}}`,
+			`GET /api/v1/namespaces/db/services/mysql-writer `,
+		}, "db1:3306"},
+		{"service selecting all pods", "app=mariadb", "mysql-writer", `{"spec":{"selector":{"app":"mariadb"}}}`, false, false, []string{
+			`PATCH /api/v1/namespaces/db/pods/db-2 {"metadata":{"labels":{"replication-manager/role":"slave"}}}`,
+			`PATCH /api/v1/namespaces/db/pods/db-1 {"metadata":{"labels":{"replication-manager/role":"master"}}}`,
+			`GET /api/v1/namespaces/db/services/mysql-writer `,
+		}, ""},
+		{"endpoints", "", "mysql-writer", `{"spec":{"ports":[{"name":"mysql","port":3306,"protocol":"TCP","targetPort":3307}]}}`, true, false, []string{
+			`GET /api/v1/namespaces/db/services/mysql-writer `,
+			`PUT /api/v1/namespaces/db/endpoints/mysql-writer {"apiVersion":"v1","kind":"Endpoints","metadata":{"labels":{"app.kubernetes.io/managed-by":"replication-manager"},"name":"mysql-writer"},"subsets":[{"addresses":[{"ip":"10.1.0.11"}],"ports":[{"name":"mysql","port":3307,"protocol":"TCP"}]}]}`,
+		}, "db1:3306"},
+		{"new endpoints", "", "mysql-writer", `{"spec":{"ports":[{"name":"mysql","port":3306,"protocol":"TCP","targetPort":"mysql"}]}}`, false, false, []string{
+			`GET /api/v1/namespaces/db/services/mysql-writer `,
+			`PUT /api/v1/namespaces/db/endpoints/mysql-writer {"apiVersion":"v1","kind":"Endpoints","metadata":{"labels":{"app.kubernetes.io/managed-by":"replication-manager"},"name":"mysql-writer"},"subsets":[{"addresses":[{"ip":"10.1.0.11"}],"ports":[{"name":"mysql","port":3306,"protocol":"TCP"}]}]}`,
+			`POST /api/v1/namespaces/db/endpoints {"apiVersion":"v1","kind":"Endpoints","metadata":{"labels":{"app.kubernetes.io/managed-by":"replication-manager"},"name":"mysql-writer"},"subsets":[{"addresses":[{"ip":"10.1.0.11"}],"ports":[{"name":"mysql","port":3306,"protocol":"TCP"}]}]}`,
+		}, "db1:3306"},
+		{"dry run", "app=mariadb", "mysql-writer", "", false, true, nil, "db1:3306"},
+	}
+	for _, tt := range tests {
+		*kubeSelector, *kubeService, *dryRun = tt.selector, tt.service, tt.dryRun
+		var calls []string
+		simKube(t, func(w http.ResponseWriter, r *http.Request) {
+			b, _ := ioutil.ReadAll(r.Body)
+			calls = append(calls, r.Method+" "+r.URL.Path+" "+strings.TrimSpace(string(b)))
+			switch {
+			case r.Method == "GET" && r.URL.Path == "/api/v1/namespaces/db/services/mysql-writer":
+				w.Write([]byte(tt.svc))
+			case r.Method == "PUT" && tt.endpoints == false:
+				http.Error(w, `{"reason":"NotFound"}`, http.StatusNotFound)
+			default:
+				w.Write([]byte("{}"))
+			}
+		})
+		simCluster(t, simTopology())
+		current.master = current.findMaster(true)
+		current.master.State = STATE_MASTER
+		// The addresses of the servers are resolved to the IPs of their pods
+		for i, s := range current.servers {
+			s.IP = fmt.Sprintf("10.1.0.%d", 11+i)
+		}
+		kubePods = map[string]kubePod{
+			"db-1": simPod("db-1", "10.1.0.11", "", true, ""),
+			"db-2": simPod("db-2", "10.1.0.12", "", true, "master"),
+			"db-3": simPod("db-3", "10.1.0.13", "", true, "slave"),
+		}
+		current.kubeCheck()
+		if reflect.DeepEqual(calls, tt.calls) == false {
+			t.Errorf("%s: requests\n%q\nwant\n%q", tt.name, calls, tt.calls)
+		}
+		if current.kubePublished != tt.published {
+			t.Errorf("%s: published %q, want %q", tt.name, current.kubePublished, tt.published)
+		}
+		// The traffic is redirected once per master
+		calls = nil
+		current.kubeCheck()
+		if tt.published != "" && len(calls) != 0 {
+			t.Errorf("%s: requests %q for a published master", tt.name, calls)
+		}
+	}
+}
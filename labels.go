@@ -0,0 +1,58 @@
+// labels.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/* Parses a comma separated list of host:[port]=key:value;key:value labels, e.g. db1=dc:paris;rack:r1,db2=dc:lyon */
+func parseLabels(s string) (map[string]map[string]string, error) {
+	m := make(map[string]map[string]string)
+	for _, item := range strings.Split(s, ",") {
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, errors.New(fmt.Sprintf("invalid host labels %s, expected host:[port]=key:value;...", item))
+		}
+		labels := make(map[string]string)
+		for _, label := range strings.Split(kv[1], ";") {
+			k, v := splitPair(strings.TrimSpace(label))
+			if k == "" || v == "" {
+				return nil, errors.New(fmt.Sprintf("invalid label %s of host %s, expected key:value", label, kv[0]))
+			}
+			labels[k] = v
+		}
+		m[hostKey(strings.TrimSpace(kv[0]))] = labels
+	}
+	return m, nil
+}
+
+/* Returns the value of a label of the server, empty if it is not set */
+func (sm *ServerMonitor) labelValue(key string) string {
+	return hostLabels[hostKey(sm.URL)][key]
+}
+
+/* Returns the labels of the server as key:value pairs, sorted by key */
+func (sm *ServerMonitor) labelString() string {
+	var l []string
+	for k, v := range hostLabels[hostKey(sm.URL)] {
+		l = append(l, k+":"+v)
+	}
+	sort.Strings(l)
+	return strings.Join(l, " ")
+}
+
+/* Returns the datacenter candidates should be elected in according to the election datacenter policy, or an empty string for any */
+func (c *Cluster) electionDC() string {
+	switch *electionDCMode {
+	case "same":
+		if c.master != nil {
+			return c.master.labelValue("dc")
+		}
+	case "primary":
+		return *primaryDC
+	}
+	return ""
+}
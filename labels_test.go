@@ -0,0 +1,73 @@
+// labels_test.go
+package main
+
+import (
+	"bytes"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseLabels(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels string
+		want   map[string]map[string]string
+		ok     bool
+	}{
+		{"labels", "db1=dc:paris;rack:r1,db2:3307=dc:lyon", map[string]map[string]string{"db1:3306": {"dc": "paris", "rack": "r1"}, "db2:3307": {"dc": "lyon"}}, true},
+		{"spaces around labels", "db1:3306=dc:paris; zone:a", map[string]map[string]string{"db1:3306": {"dc": "paris", "zone": "a"}}, true},
+		{"host without labels", "db1=", nil, false},
+		{"label without value", "db1=dc", nil, false},
+		{"missing separator", "db1", nil, false},
+	}
+	for _, tt := range tests {
+		got, err := parseLabels(tt.labels)
+		if (err == nil) != tt.ok || reflect.DeepEqual(got, tt.want) == false {
+			t.Errorf("%s: parseLabels(%q) = %v, %v, want %v", tt.name, tt.labels, got, err, tt.want)
+		}
+	}
+}
+
+func TestDCElection(t *testing.T) {
+	defer func(h map[string]map[string]string, m, p string) { hostLabels, *electionDCMode, *primaryDC = h, m, p }(hostLabels, *electionDCMode, *primaryDC)
+	var err error
+	hostLabels, err = parseLabels("db1=dc:paris;rack:r1,db2=dc:paris,db3=dc:lyon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		mode    string
+		primary string
+		want    string
+		warning bool // no candidate in the datacenter
+	}{
+		{"any datacenter", "any", "", "db3:3306", false},
+		{"same datacenter as the master", "same", "", "db2:3306", false},
+		{"primary datacenter", "primary", "lyon", "db3:3306", false},
+		{"primary datacenter without candidate", "primary", "berlin", "db3:3306", true},
+	}
+	for _, tt := range tests {
+		simCluster(t, simTopology())
+		var out bytes.Buffer
+		log.SetOutput(&out)
+		logWriter.out = &out
+		current.master = current.findMaster(true)
+		*electionDCMode, *primaryDC = tt.mode, tt.primary
+		got := ""
+		if key := current.master.electCandidate(current.slaves); key >= 0 {
+			got = current.slaves[key].URL
+		}
+		if got != tt.want {
+			t.Errorf("%s: elected %q, want %q", tt.name, got, tt.want)
+		}
+		if w := strings.Contains(out.String(), "No eligible candidate in datacenter "+tt.primary); w != tt.warning {
+			t.Errorf("%s: warning %v, want %v:\n%s", tt.name, w, tt.warning, out.String())
+		}
+	}
+	if got := simServerByURL("db1:3306").labelString(); got != "dc:paris rack:r1" {
+		t.Errorf("labelString() = %q, want %q", got, "dc:paris rack:r1")
+	}
+}
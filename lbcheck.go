@@ -0,0 +1,162 @@
+// lbcheck.go
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+/* Roles load balancers can check a server for */
+var checkRoles = []string{"master", "slave-ok"}
+
+/* Signature of the binary PROXY protocol v2 header */
+var proxyV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+/* Networks of the load balancers allowed to send a PROXY protocol header */
+var proxyPeers []*net.IPNet
+
+/* Returns whether the server should receive the traffic of the role, with the reason. A master must be the running master of its cluster and a healthy slave must replicate with both threads running, within maxdelay if set. Servers in maintenance receive no traffic, nor does any server while a switchover or failover runs. */
+func roleCheck(role string, server string, cluster string) (bool, string) {
+	if contains(checkRoles, role) == false {
+		return false, "unknown role " + role
+	}
+	if atomic.LoadInt32(&inOperation) == 1 {
+		return false, "switchover or failover in progress"
+	}
+	c := clusters[0]
+	if cluster != "" || len(clusters) > 1 {
+		c = findCluster(clusters, cluster)
+	}
+	if c == nil {
+		return false, "unknown cluster " + cluster
+	}
+	ok, reason := false, ""
+	withCluster(c, func() {
+		if server == "" && role == "master" && c.master != nil {
+			server = c.master.URL
+		}
+		sm := c.findServer(server)
+		switch {
+		case sm == nil:
+			reason = "unknown server " + server
+		case sm.inMaintenance():
+			reason = sm.URL + " is in maintenance"
+		case sm.State == STATE_FAILED:
+			reason = sm.URL + " is failed"
+		case role == "master" && sm != c.master:
+			reason = sm.URL + " is not the master"
+		case role == "master":
+			ok, reason = true, sm.URL+" is the master"
+		case c.isSlave(sm.URL) == false && c.isChained(sm.URL) == false:
+			reason = sm.URL + " is not a slave"
+		case sm.IOThread != "Yes" || sm.SQLThread != "Yes":
+			reason = sm.URL + ": " + sm.healthCheck()
+		case *maxDelay > 0 && sm.lag() > *maxDelay:
+			reason = fmt.Sprintf("%s is %d seconds behind", sm.URL, sm.lag())
+		default:
+			ok, reason = true, sm.URL+" is a healthy slave"
+		}
+	})
+	return ok, reason
+}
+
+/* Serves the load balancer checks. It is meant to run in its own goroutine. */
+func checkServe() {
+	lis, err := net.Listen("tcp", *checkAddr)
+	if err != nil {
+		log.Printf("ERROR: Load balancer checks not started: %s", err)
+		return
+	}
+	log.Printf("INFO : Serving load balancer checks on %s", *checkAddr)
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			log.Printf("ERROR: Load balancer checks stopped: %s", err)
+			return
+		}
+		go checkConn(conn)
+	}
+}
+
+/* Answers a check, either an HTTP request or a line of the text protocol, preceded by a PROXY protocol header when it comes from a load balancer of -check-proxy-peers. The allowlist applies to the peer of the connection, the client address of a header is never trusted. */
+func checkConn(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	peer := conn.RemoteAddr().String()
+	if allowed(peer) == false {
+		return
+	}
+	br := bufio.NewReader(conn)
+	if inNets(proxyPeers, peer) {
+		if skipProxyHeader(br) != nil {
+			return
+		}
+	}
+	line, err := br.ReadString('\n')
+	if err != nil && line == "" {
+		return
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return
+	}
+	if contains([]string{"GET", "HEAD", "OPTIONS"}, fields[0]) {
+		req, err := http.ReadRequest(bufio.NewReader(io.MultiReader(strings.NewReader(line), br)))
+		if err != nil {
+			return
+		}
+		items := strings.SplitN(strings.Trim(req.URL.Path, "/"), "/", 2)
+		server := req.URL.Query().Get("server")
+		if len(items) == 2 {
+			server, _ = url.PathUnescape(items[1])
+		}
+		ok, reason := roleCheck(items[0], server, req.URL.Query().Get("cluster"))
+		status := http.StatusOK
+		if ok == false {
+			status = http.StatusServiceUnavailable
+		}
+		fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\nContent-Type: text/plain\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s\n", status, http.StatusText(status), len(reason)+1, reason)
+		return
+	}
+	// Text protocol: <role> [<host:port> [<cluster>]], answered like an HAProxy agent check by up or down followed by the reason
+	fields = append(fields, "", "")
+	ok, reason := roleCheck(fields[0], fields[1], fields[2])
+	if ok {
+		fmt.Fprintf(conn, "up #%s\n", reason)
+	} else {
+		fmt.Fprintf(conn, "down #%s\n", reason)
+	}
+}
+
+/* Consumes a PROXY protocol v1 or v2 header if the connection starts with one */
+func skipProxyHeader(br *bufio.Reader) error {
+	// Peeking the whole signature would block on a text check shorter than it
+	first, err := br.Peek(1)
+	if err != nil || (first[0] != 'P' && first[0] != '\r') {
+		return nil
+	}
+	sig, _ := br.Peek(len(proxyV2Sig))
+	if bytes.HasPrefix(sig, []byte("PROXY ")) {
+		_, err = br.ReadString('\n')
+		return err
+	}
+	if bytes.Equal(sig, proxyV2Sig) == false {
+		return nil
+	}
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(br, hdr); err != nil {
+		return err
+	}
+	_, err = io.ReadFull(br, make([]byte, binary.BigEndian.Uint16(hdr[14:16])))
+	return err
+}
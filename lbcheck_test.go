@@ -0,0 +1,137 @@
+// lbcheck_test.go
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+/* Builds the checked cluster, named default: db1 is the master, db2 a slave with stopped replication and db3 a healthy slave */
+func simChecked(t *testing.T) {
+	simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.stopped = sp.id == 2 }))
+	current.master = current.findMaster(true)
+	current.master.State = STATE_MASTER
+	current.Name = "default"
+	clusters = []*Cluster{current}
+}
+
+func TestRoleCheck(t *testing.T) {
+	defer func(l []*Cluster, d int64) { clusters, *maxDelay = l, d }(clusters, *maxDelay)
+	tests := []struct {
+		name    string
+		role    string
+		server  string
+		cluster string
+		prep    func()
+		ok      bool
+		reason  string
+	}{
+		{"master", "master", "", "", nil, true, "db1:3306 is the master"},
+		{"named master", "master", "db1:3306", "default", nil, true, "db1:3306 is the master"},
+		{"slave as master", "master", "db3:3306", "", nil, false, "db3:3306 is not the master"},
+		{"healthy slave", "slave-ok", "db3:3306", "", nil, true, "db3:3306 is a healthy slave"},
+		{"master as slave", "slave-ok", "db1:3306", "", nil, false, "db1:3306 is not a slave"},
+		{"stopped slave", "slave-ok", "db2:3306", "", nil, false, "db2:3306: "},
+		{"lagging slave", "slave-ok", "db3:3306", "", func() {
+			*maxDelay = 30
+			simServerByURL("db3:3306").Delay = sql.NullInt64{Int64: 45, Valid: true}
+		}, false, "db3:3306 is 45 seconds behind"},
+		{"slave in maintenance", "slave-ok", "db3:3306", "", func() { current.setMaintenance(simServerByURL("db3:3306"), true, "test") }, false, "db3:3306 is in maintenance"},
+		{"failed master", "master", "", "", func() { current.master.State = STATE_FAILED }, false, "db1:3306 is failed"},
+		{"operation in progress", "master", "", "", func() { atomic.StoreInt32(&inOperation, 1) }, false, "switchover or failover in progress"},
+		{"unknown role", "writer", "", "", nil, false, "unknown role writer"},
+		{"unknown server", "slave-ok", "db9:3306", "", nil, false, "unknown server db9:3306"},
+		{"unknown cluster", "master", "", "prod", nil, false, "unknown cluster prod"},
+	}
+	for _, tt := range tests {
+		simChecked(t)
+		*maxDelay = 0
+		if tt.prep != nil {
+			tt.prep()
+		}
+		ok, reason := roleCheck(tt.role, tt.server, tt.cluster)
+		atomic.StoreInt32(&inOperation, 0)
+		if ok != tt.ok || strings.HasPrefix(reason, tt.reason) == false {
+			t.Errorf("%s: roleCheck() = %v, %q, want %v, %q", tt.name, ok, reason, tt.ok, tt.reason)
+		}
+	}
+}
+
+/* Returns a PROXY protocol v2 header of a TCP over IPv4 connection */
+func simProxyV2() []byte {
+	hdr := append([]byte{}, proxyV2Sig...)
+	hdr = append(hdr, 0x21, 0x11, 0, 0)
+	binary.BigEndian.PutUint16(hdr[14:16], 12)
+	return append(hdr, 10, 0, 0, 5, 10, 0, 0, 1, 0xa1, 0x2a, 0x0c, 0xea)
+}
+
+/* Sends the request to a listener serving one check connection with the configuration set before, returns the answer once the connection is served */
+func simCheckConn(t *testing.T, request string) []byte {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn, err := lis.Accept()
+		if err == nil {
+			checkConn(conn)
+		}
+	}()
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte(request))
+	b, _ := ioutil.ReadAll(bufio.NewReader(conn))
+	conn.Close()
+	<-done
+	return b
+}
+
+func TestCheckConn(t *testing.T) {
+	defer func(l []*Cluster) { clusters, proxyPeers = l, nil }(clusters)
+	simChecked(t)
+	_, local, _ := net.ParseCIDR("127.0.0.0/8")
+	tests := []struct {
+		name    string
+		proxy   bool // the load balancer is a PROXY protocol peer
+		request string
+		want    string // first line of the answer
+	}{
+		{"HTTP master", false, "GET /master HTTP/1.1\r\nHost: lb\r\n\r\n", "HTTP/1.1 200 OK"},
+		{"HTTP slave", false, "GET /slave-ok/db3:3306 HTTP/1.1\r\nHost: lb\r\n\r\n", "HTTP/1.1 200 OK"},
+		{"HTTP server parameter", false, "HEAD /slave-ok?server=db2:3306 HTTP/1.1\r\nHost: lb\r\n\r\n", "HTTP/1.1 503 Service Unavailable"},
+		{"HTTP unknown cluster", false, "GET /master?cluster=prod HTTP/1.1\r\nHost: lb\r\n\r\n", "HTTP/1.1 503 Service Unavailable"},
+		{"text", false, "slave-ok db3:3306\n", "up #db3:3306 is a healthy slave"},
+		{"text down", false, "master db3:3306 default\n", "down #db3:3306 is not the master"},
+		{"PROXY v1", true, "PROXY TCP4 10.0.0.5 10.0.0.1 41258 3306\r\nmaster\n", "up #db1:3306 is the master"},
+		{"PROXY v2", true, string(simProxyV2()) + "GET /master HTTP/1.1\r\nHost: lb\r\n\r\n", "HTTP/1.1 200 OK"},
+		{"no PROXY header from a peer", true, "slave-ok db3:3306\n", "up #db3:3306 is a healthy slave"},
+		{"PROXY header from another load balancer", false, "PROXY TCP4 10.0.0.5 10.0.0.1 41258 3306\r\nmaster\n", "down #unknown role PROXY"},
+	}
+	for _, tt := range tests {
+		proxyPeers = nil
+		if tt.proxy {
+			proxyPeers = []*net.IPNet{local}
+		}
+		b := simCheckConn(t, tt.request)
+		if line := strings.SplitN(string(b), "\n", 2)[0]; strings.TrimSpace(line) != tt.want {
+			t.Errorf("%s: answer %q, want %q", tt.name, b, tt.want)
+		}
+	}
+	// The allowlist applies to the load balancer
+	defer func() { allowNets = nil }()
+	allowNets, _ = parseNets("10.0.0.0/8")
+	if b := simCheckConn(t, "master\n"); len(b) != 0 {
+		t.Errorf("answer %q to a load balancer outside of the allowed networks", b)
+	}
+}
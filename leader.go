@@ -0,0 +1,315 @@
+// leader.go
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* Lock electing the active instance among redundant replication-manager daemons. Each renewal, acquire takes or renews the lock for ttl and returns whether this instance holds it, and the name of the instance holding it. */
+type leaderLock interface {
+	acquire(id string, ttl time.Duration) (bool, string, error)
+}
+
+var (
+	leader        leaderLock
+	leaderMutex   sync.Mutex // guards the state below, written by the renewal goroutine
+	leaderActive  bool       // true while this instance holds the lock
+	leaderName    string     // instance holding the lock
+	leaderRenewed time.Time
+	leaderEvents  []func() // changes of the lock, reported by the monitor loop
+)
+
+/* Returns the lock of the leader election backend */
+func newLeaderLock(kind string) (leaderLock, error) {
+	addr := *leaderAddr
+	if addr == "" && kind != "mysql" {
+		addr = *registryAddr
+	}
+	switch kind {
+	case "consul":
+		return &consulLock{base: strings.TrimSuffix(addr, "/")}, nil
+	case "etcd":
+		return &etcdLock{base: strings.TrimSuffix(addr, "/")}, nil
+	case "mysql":
+		if addr == "" {
+			return nil, errors.New("the leader-address option must be the host:port of the MySQL server holding the lock")
+		}
+		cred, err := resolveCredentials(*leaderUser)
+		if err != nil {
+			return nil, err
+		}
+		return &mysqlLock{addr: addr, cred: cred}, nil
+	}
+	return nil, errors.New(fmt.Sprintf("incorrect leader election backend %s", kind))
+}
+
+/* Returns the name of this instance in the lock */
+func instanceID() string {
+	if *leaderID != "" {
+		return *leaderID
+	}
+	hostname, _ := os.Hostname()
+	if *httpAddr != "" {
+		return hostname + "/" + *httpAddr
+	}
+	return fmt.Sprintf("%s/%d", hostname, os.Getpid())
+}
+
+/* Returns true if another instance is active, in which case this one monitors but takes no automatic action. The active instance stands by once half of the TTL passed without renewal, well before another instance can take the expired lock over. */
+func isStandby() bool {
+	if leader == nil {
+		return false
+	}
+	leaderMutex.Lock()
+	defer leaderMutex.Unlock()
+	return leaderActive == false || time.Since(leaderRenewed) > time.Duration(*leaderTTL)*time.Second/2
+}
+
+/* Returns the name of the instance holding the lock */
+func leaderHolder() string {
+	leaderMutex.Lock()
+	defer leaderMutex.Unlock()
+	return leaderName
+}
+
+/* Renews the lock every third of its TTL from its own goroutine, so that neither the cluster lock held during a failover or switchover nor a slow monitor cycle delays the renewal */
+func leaderLoop() {
+	ttl := time.Duration(*leaderTTL) * time.Second
+	for {
+		leaderRenew(ttl)
+		time.Sleep(ttl / 3)
+	}
+}
+
+/* Takes or renews the lock. An instance that cannot reach the lock backend steps down once half of the TTL passed, since another instance may take the lock over when it expires. */
+func leaderRenew(ttl time.Duration) {
+	held, holder, err := leader.acquire(instanceID(), ttl)
+	leaderMutex.Lock()
+	defer leaderMutex.Unlock()
+	if err != nil {
+		if leaderActive && time.Since(leaderRenewed) > ttl/2 {
+			leaderEvents = append(leaderEvents, func() {
+				alertLog("WARN : Could not renew the leader lock: %s, stepping down to standby", err)
+			})
+			leaderActive, leaderName = false, ""
+		} else if leaderActive == false {
+			leaderEvents = append(leaderEvents, func() { logprintf("WARN : Could not check the leader lock: %s", err) })
+		}
+		return
+	}
+	if held {
+		if leaderActive == false {
+			leaderEvents = append(leaderEvents, func() {
+				alertLog("INFO : This instance is now the active replication-manager")
+				audit("Instance %s became active", instanceID())
+			})
+		}
+		leaderActive, leaderName, leaderRenewed = true, instanceID(), time.Now()
+		return
+	}
+	if leaderActive || holder != leaderName {
+		leaderEvents = append(leaderEvents, func() { alertLog("INFO : Standing by, the active replication-manager is %s", holder) })
+	}
+	leaderActive, leaderName = false, holder
+}
+
+/* Reports the changes of the lock seen by the renewal goroutine, once per monitor cycle under the cluster lock */
+func leaderCheck() {
+	leaderMutex.Lock()
+	events := leaderEvents
+	leaderEvents = nil
+	leaderMutex.Unlock()
+	for _, e := range events {
+		e()
+	}
+}
+
+func apiLeader(w http.ResponseWriter, r *http.Request) {
+	apiWrite(w, map[string]interface{}{"Instance": instanceID(), "Active": isStandby() == false, "Leader": leaderHolder()})
+}
+
+/* Adopts the master promoted by the active instance: a standby seeing the master failed follows the slaves once most of the others replicate from the same slave */
+func (c *Cluster) standbyFollow() {
+	if isStandby() == false || c.master == nil || c.master.State != STATE_FAILED {
+		return
+	}
+	votes := make(map[uint]int)
+	for _, sl := range c.slaves {
+		if sl.State != STATE_FAILED && sl.MasterServerId != c.master.ServerId && sl.IOThread == "Yes" {
+			votes[sl.MasterServerId]++
+		}
+	}
+	for k, sl := range c.slaves {
+		if sl.State != STATE_FAILED && votes[sl.ServerId] > 0 && votes[sl.ServerId]*2 >= len(c.slaves)-1 {
+			logprintf("INFO : Master %s was replaced by %s on the active instance", c.master.URL, sl.URL)
+			c.promoted(sl.URL, k)
+			return
+		}
+	}
+}
+
+/* Lock held by a Consul session, which the agent deletes when it is not renewed within its TTL */
+type consulLock struct {
+	base    string
+	session string
+}
+
+func (l *consulLock) acquire(id string, ttl time.Duration) (bool, string, error) {
+	if l.session != "" {
+		err := leaderRequest("PUT", l.base+"/v1/session/renew/"+l.session, nil, nil)
+		if err != nil {
+			// The session expired, a new one is created
+			l.session = ""
+		}
+	}
+	if l.session == "" {
+		var res struct{ ID string }
+		err := leaderRequest("PUT", l.base+"/v1/session/create", map[string]string{"Name": id, "TTL": ttl.String(), "Behavior": "delete", "LockDelay": "0s"}, &res)
+		if err != nil {
+			return false, "", err
+		}
+		l.session = res.ID
+	}
+	var held bool
+	err := leaderRequest("PUT", l.base+"/v1/kv/"+*leaderKey+"?acquire="+l.session, id, &held)
+	if err != nil || held {
+		return held, id, err
+	}
+	var kv []struct{ Value string }
+	err = leaderRequest("GET", l.base+"/v1/kv/"+*leaderKey, nil, &kv)
+	if err != nil || len(kv) == 0 {
+		return false, "", err
+	}
+	var holder string
+	b, _ := base64.StdEncoding.DecodeString(kv[0].Value)
+	json.Unmarshal(b, &holder)
+	return false, holder, nil
+}
+
+/* Lock held by a key attached to an etcd lease, deleted when the lease is not kept alive within its TTL */
+type etcdLock struct {
+	base  string
+	lease string
+}
+
+func (l *etcdLock) acquire(id string, ttl time.Duration) (bool, string, error) {
+	if l.lease != "" {
+		var res struct {
+			Result struct{ TTL string }
+		}
+		err := leaderRequest("POST", l.base+"/v3/lease/keepalive", map[string]string{"ID": l.lease}, &res)
+		if err != nil || res.Result.TTL == "" || res.Result.TTL == "0" {
+			l.lease = ""
+		}
+	}
+	if l.lease == "" {
+		var res struct{ ID string }
+		err := leaderRequest("POST", l.base+"/v3/lease/grant", map[string]int64{"TTL": int64(ttl.Seconds())}, &res)
+		if err != nil {
+			return false, "", err
+		}
+		l.lease = res.ID
+	}
+	key := base64.StdEncoding.EncodeToString([]byte(*leaderKey))
+	txn := map[string]interface{}{
+		"compare": []map[string]string{{"key": key, "result": "EQUAL", "target": "CREATE", "create_revision": "0"}},
+		"success": []map[string]interface{}{{"request_put": map[string]string{"key": key, "value": base64.StdEncoding.EncodeToString([]byte(id)), "lease": l.lease}}},
+		"failure": []map[string]interface{}{{"request_range": map[string]string{"key": key}}},
+	}
+	var res struct {
+		Succeeded bool
+		Responses []struct {
+			ResponseRange struct {
+				Kvs []struct{ Value, Lease string }
+			} `json:"response_range"`
+		}
+	}
+	err := leaderRequest("POST", l.base+"/v3/kv/txn", txn, &res)
+	if err != nil || res.Succeeded {
+		return res.Succeeded, id, err
+	}
+	if len(res.Responses) == 0 || len(res.Responses[0].ResponseRange.Kvs) == 0 {
+		return false, "", nil
+	}
+	kv := res.Responses[0].ResponseRange.Kvs[0]
+	holder, _ := base64.StdEncoding.DecodeString(kv.Value)
+	return kv.Lease == l.lease, string(holder), nil
+}
+
+/* Lock held by a row of a table on a MySQL server outside of the monitored clusters, expiring at the time of the server */
+type mysqlLock struct {
+	addr string
+	cred string
+	conn *sqlx.DB
+}
+
+func (l *mysqlLock) acquire(id string, ttl time.Duration) (bool, string, error) {
+	if l.conn == nil {
+		user, pass := splitCredentials(l.cred)
+		conn, err := dbConnectAs("tcp("+net.JoinHostPort(splitHostPort(l.addr))+")", user, pass)
+		if err != nil {
+			return false, "", err
+		}
+		for _, stmt := range []string{"CREATE DATABASE IF NOT EXISTS replication_manager",
+			"CREATE TABLE IF NOT EXISTS replication_manager.leader (name VARCHAR(255) PRIMARY KEY, holder VARCHAR(255) NOT NULL, expires DATETIME NOT NULL)"} {
+			_, err = conn.Exec(stmt)
+			if err != nil {
+				conn.Close()
+				return false, "", err
+			}
+		}
+		l.conn = conn
+	}
+	_, err := l.conn.Exec("INSERT IGNORE INTO replication_manager.leader (name, holder, expires) VALUES (?, '', NOW())", *leaderKey)
+	if err == nil {
+		_, err = l.conn.Exec("UPDATE replication_manager.leader SET holder = ?, expires = NOW() + INTERVAL ? SECOND WHERE name = ? AND (holder = ? OR expires < NOW())",
+			id, int64(ttl.Seconds()), *leaderKey, id)
+	}
+	var holder string
+	if err == nil {
+		err = l.conn.Get(&holder, "SELECT holder FROM replication_manager.leader WHERE name = ?", *leaderKey)
+	}
+	if err != nil {
+		l.conn.Close()
+		l.conn = nil
+		return false, "", err
+	}
+	return holder == id, holder, nil
+}
+
+/* Sends a JSON request to the lock backend and decodes its JSON response into v */
+func leaderRequest(method string, u string, in interface{}, v interface{}) error {
+	var body bytes.Buffer
+	if in != nil {
+		json.NewEncoder(&body).Encode(in)
+	}
+	req, err := http.NewRequest(method, u, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return errors.New(fmt.Sprintf("%s %s returned %s", method, u, resp.Status))
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
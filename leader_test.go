@@ -0,0 +1,277 @@
+// leader_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+/* Lock of a test, returning the configured result */
+type simLock struct {
+	held   bool
+	holder string
+	err    error
+}
+
+func (l *simLock) acquire(id string, ttl time.Duration) (bool, string, error) {
+	return l.held, l.holder, l.err
+}
+
+/* Resets the state of the leader election */
+func simLeader(l leaderLock) {
+	leader, leaderActive, leaderName, leaderRenewed, leaderEvents = l, false, "", time.Time{}, nil
+}
+
+func TestLeaderRenew(t *testing.T) {
+	defer func(id string, ttl int64) { *leaderID, *leaderTTL = id, ttl }(*leaderID, *leaderTTL)
+	defer simLeader(nil)
+	simCluster(t, simTopology())
+	current.master = current.findMaster(true)
+	*leaderID, *leaderTTL = "rm1", 15
+	ttl := 15 * time.Second
+	lock := &simLock{}
+	tests := []struct {
+		name    string
+		lock    simLock
+		renewed time.Duration // since the last renewal, before this one
+		standby bool
+		holder  string
+		event   string // reported by the next check, none if empty
+	}{
+		{"elected", simLock{held: true, holder: "rm1"}, 0, false, "rm1", "This instance is now the active replication-manager"},
+		{"renewed", simLock{held: true, holder: "rm1"}, 0, false, "rm1", ""},
+		{"backend down", simLock{err: errors.New("connection refused")}, 0, false, "rm1", ""},
+		{"backend down for half of the TTL", simLock{err: errors.New("connection refused")}, ttl, true, "", "Could not renew the leader lock: connection refused, stepping down to standby"},
+		{"backend down as standby", simLock{err: errors.New("connection refused")}, 0, true, "", "Could not check the leader lock: connection refused"},
+		{"taken over", simLock{holder: "rm2"}, 0, true, "rm2", "Standing by, the active replication-manager is rm2"},
+		{"still taken", simLock{holder: "rm2"}, 0, true, "rm2", ""},
+	}
+	simLeader(lock)
+	for _, tt := range tests {
+		*lock = tt.lock
+		leaderRenewed = leaderRenewed.Add(-tt.renewed)
+		leaderRenew(ttl)
+		var out bytes.Buffer
+		log.SetOutput(&out)
+		leaderCheck()
+		log.SetOutput(ioutil.Discard)
+		if isStandby() != tt.standby || leaderHolder() != tt.holder {
+			t.Errorf("%s: standby %v, holder %q, want %v, %q", tt.name, isStandby(), leaderHolder(), tt.standby, tt.holder)
+		}
+		if (tt.event == "" && out.Len() != 0) || strings.Contains(out.String(), tt.event) == false {
+			t.Errorf("%s: reported %q, want %q", tt.name, out.String(), tt.event)
+		}
+	}
+	// A standby takes no action
+	if _, conflict, err := current.controlAction("switchover", "db1:3306", "test"); conflict == false || err == nil || err.Error() != "This instance is a standby, the active instance is rm2" {
+		t.Errorf("controlAction() on a standby = %v, %v, want a conflict", conflict, err)
+	}
+	// The lock of an active instance that stopped renewing it is not trusted after half of the TTL
+	*lock = simLock{held: true, holder: "rm1"}
+	leaderRenew(ttl)
+	leaderRenewed = time.Now().Add(-ttl / 2)
+	if isStandby() == false {
+		t.Error("isStandby() = false for a lock renewed half of the TTL ago")
+	}
+	simLeader(nil)
+	if isStandby() {
+		t.Error("isStandby() = true without leader election")
+	}
+}
+
+/* Starts a Consul agent serving the sessions and keys of the lock, returns it and a function expiring a session */
+func simConsul() (*httptest.Server, func(session string)) {
+	var mu sync.Mutex
+	sessions, n := map[string]bool{}, 0
+	var holder string // session holding the key
+	var value []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Method == "PUT" && r.URL.Path == "/v1/session/create":
+			n++
+			id := "s" + strconv.Itoa(n)
+			sessions[id] = true
+			json.NewEncoder(w).Encode(map[string]string{"ID": id})
+		case r.Method == "PUT" && strings.HasPrefix(r.URL.Path, "/v1/session/renew/"):
+			if sessions[strings.TrimPrefix(r.URL.Path, "/v1/session/renew/")] == false {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte("[]"))
+		case r.Method == "PUT" && r.URL.Path == "/v1/kv/replication-manager/leader":
+			s := r.URL.Query().Get("acquire")
+			if holder == "" || holder == s {
+				holder = s
+				value, _ = ioutil.ReadAll(r.Body)
+			}
+			json.NewEncoder(w).Encode(holder == s)
+		case r.Method == "GET" && r.URL.Path == "/v1/kv/replication-manager/leader":
+			if holder == "" {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode([]map[string]string{{"Value": base64.StdEncoding.EncodeToString(value)}})
+		default:
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+		}
+	}))
+	// The key of an expired session is deleted
+	return srv, func(session string) {
+		mu.Lock()
+		defer mu.Unlock()
+		delete(sessions, session)
+		if holder == session {
+			holder = ""
+		}
+	}
+}
+
+/* Starts an etcd server serving the leases and keys of the lock, returns it and a function expiring a lease */
+func simEtcd() (*httptest.Server, func(lease string)) {
+	var mu sync.Mutex
+	leases, n := map[string]bool{}, 0
+	var holder, value string // lease holding the key and its value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		var req struct {
+			ID      string
+			Success []struct {
+				Put struct{ Value, Lease string } `json:"request_put"`
+			}
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		switch r.URL.Path {
+		case "/v3/lease/grant":
+			n++
+			id := strconv.Itoa(n)
+			leases[id] = true
+			json.NewEncoder(w).Encode(map[string]string{"ID": id, "TTL": "15"})
+		case "/v3/lease/keepalive":
+			res := map[string]string{"ID": req.ID}
+			if leases[req.ID] {
+				res["TTL"] = "15"
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"result": res})
+		case "/v3/kv/txn":
+			if holder == "" {
+				holder, value = req.Success[0].Put.Lease, req.Success[0].Put.Value
+				json.NewEncoder(w).Encode(map[string]interface{}{"succeeded": true})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"responses": []interface{}{
+				map[string]interface{}{"response_range": map[string]interface{}{"kvs": []map[string]string{{"value": value, "lease": holder}}}},
+			}})
+		default:
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+		}
+	}))
+	// The key attached to an expired lease is deleted
+	return srv, func(lease string) {
+		mu.Lock()
+		defer mu.Unlock()
+		delete(leases, lease)
+		if holder == lease {
+			holder = ""
+		}
+	}
+}
+
+func TestLeaderLocks(t *testing.T) {
+	defer func(a, r string) { *leaderAddr, *registryAddr = a, r }(*leaderAddr, *registryAddr)
+	consul, expireSession := simConsul()
+	defer consul.Close()
+	etcd, expireLease := simEtcd()
+	defer etcd.Close()
+	tests := []struct {
+		kind   string
+		addr   string
+		expire func(l leaderLock)
+	}{
+		{"consul", consul.URL + "/", func(l leaderLock) { expireSession(l.(*consulLock).session) }},
+		{"etcd", etcd.URL, func(l leaderLock) { expireLease(l.(*etcdLock).lease) }},
+	}
+	for _, tt := range tests {
+		// The address of the registry is the default one
+		*leaderAddr, *registryAddr = "", tt.addr
+		var locks []leaderLock
+		for i := 0; i < 2; i++ {
+			l, err := newLeaderLock(tt.kind)
+			if err != nil {
+				t.Fatalf("%s: newLeaderLock() = %s", tt.kind, err)
+			}
+			locks = append(locks, l)
+		}
+		steps := []struct {
+			lock   int
+			held   bool
+			holder string
+		}{
+			{0, true, "rm1"},
+			{1, false, "rm1"},
+			{0, true, "rm1"},
+			{-1, false, ""}, // rm1 stops renewing its lock, which expires
+			{1, true, "rm2"},
+			{0, false, "rm2"},
+			{1, true, "rm2"},
+		}
+		for i, st := range steps {
+			if st.lock < 0 {
+				tt.expire(locks[0])
+				continue
+			}
+			id := "rm" + strconv.Itoa(st.lock+1)
+			held, holder, err := locks[st.lock].acquire(id, 15*time.Second)
+			if err != nil || held != st.held || holder != st.holder {
+				t.Errorf("%s: step %d: acquire() by %s = %v, %q, %v, want %v, %q", tt.kind, i, id, held, holder, err, st.held, st.holder)
+			}
+		}
+	}
+	if _, err := newLeaderLock("zookeeper"); err == nil || err.Error() != "incorrect leader election backend zookeeper" {
+		t.Errorf("newLeaderLock() of an unknown backend = %v", err)
+	}
+	*leaderAddr = ""
+	if _, err := newLeaderLock("mysql"); err == nil {
+		t.Error("newLeaderLock() of MySQL without address succeeded")
+	}
+}
+
+func TestStandbyFollow(t *testing.T) {
+	defer func(s string) { *stateFile = s }(*stateFile)
+	defer simLeader(nil)
+	*stateFile = ""
+	for _, standby := range []bool{false, true} {
+		simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 1 }))
+		current.master = current.findMaster(false)
+		current.master.State, current.failCount = STATE_FAILED, *maxFail
+		// The active instance promoted db3, which db2 replicates from
+		db2 := simServerByURL("db2:3306")
+		db2.MasterServerId, db2.MasterHost = 3, "db3"
+		simLeader(nil)
+		if standby {
+			simLeader(&simLock{holder: "rm2"})
+			leaderActive, leaderName = false, "rm2"
+		}
+		current.standbyFollow()
+		want := "db1:3306"
+		if standby {
+			want = "db3:3306"
+		}
+		if current.master.URL != want || (standby && current.failCount != 0) {
+			t.Errorf("standby %v: master %s, failcount %d, want %s", standby, current.master.URL, current.failCount, want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+// lock.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+/* Returns the lock method used on the server, BACKUP STAGE requiring MariaDB 10.4 */
+func (sm *ServerMonitor) lockMethod() string {
+	if *swLock == "backup-stage" && (sm.Flavor != FLAVOR_MARIADB || compareVersion(parseVersion(sm.Version), [3]int{10, 4, 0}) < 0) {
+		logprintf("WARN : BACKUP STAGE is not supported by %s (%s), using FLUSH TABLES WITH READ LOCK", sm.URL, sm.Version)
+		return "ftwrl"
+	}
+	return *swLock
+}
+
+/* Blocks writes and commits on the server until unlockWrites, waiting at most the lock timeout for running statements to release their locks, or until the operation is aborted */
+func (sm *ServerMonitor) lockWrites(ctx context.Context) error {
+	var stmts []string
+	unlock := "UNLOCK TABLES"
+	switch sm.lockMethod() {
+	case "none":
+		return nil
+	case "backup-stage":
+		stmts = []string{"BACKUP STAGE START", "BACKUP STAGE BLOCK_COMMIT"}
+		unlock = "BACKUP STAGE END"
+	default:
+		stmts = []string{"FLUSH TABLES WITH READ LOCK"}
+	}
+	if *dryRun {
+		for _, stmt := range stmts {
+			alertLog("DRY-RUN: [%s] %s", sm.URL, stmt)
+		}
+		return nil
+	}
+	if sm.db != nil {
+		for _, stmt := range stmts {
+			err := sm.db.Exec(stmt)
+			if err != nil {
+				return errors.New(fmt.Sprintf("%s: %s", stmt, err))
+			}
+		}
+		sm.cluster.writeUnlock = unlock
+		return nil
+	}
+	conn, err := sm.Conn.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(ctx, fmt.Sprintf("SET SESSION lock_wait_timeout=%d", *swLockTimeout))
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	for _, stmt := range stmts {
+		_, err = conn.ExecContext(ctx, stmt)
+		if err != nil && ctx.Err() != nil {
+			err = sm.cluster.abortError(ctx)
+		}
+		if err != nil {
+			conn.Close()
+			return errors.New(fmt.Sprintf("%s: %s", stmt, err))
+		}
+	}
+	sm.cluster.writeLock, sm.cluster.writeUnlock = conn, unlock
+	return nil
+}
+
+/* Releases the lock taken by lockWrites. Closing the connection releases it as well if the statement fails. */
+func (sm *ServerMonitor) unlockWrites() error {
+	if *dryRun && *swLock != "none" {
+		alertLog("DRY-RUN: [%s] release write lock", sm.URL)
+		return nil
+	}
+	if sm.db != nil && sm.cluster.writeUnlock != "" {
+		err := sm.db.Exec(sm.cluster.writeUnlock)
+		sm.cluster.writeUnlock = ""
+		return err
+	}
+	if sm.cluster.writeLock == nil {
+		return nil
+	}
+	_, err := sm.cluster.writeLock.ExecContext(context.Background(), sm.cluster.writeUnlock)
+	sm.cluster.writeLock.Close()
+	sm.cluster.writeLock, sm.cluster.writeUnlock = nil, ""
+	return err
+}
@@ -0,0 +1,99 @@
+// lock_test.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLockMethod(t *testing.T) {
+	defer func(l string) { *swLock = l }(*swLock)
+	simCluster(t, simTopology())
+	tests := []struct {
+		name    string
+		lock    string
+		flavor  string
+		version string
+		want    string
+	}{
+		{"flush tables", "ftwrl", FLAVOR_MARIADB, "10.6.12-MariaDB", "ftwrl"},
+		{"backup stage", "backup-stage", FLAVOR_MARIADB, "10.6.12-MariaDB", "backup-stage"},
+		{"backup stage before 10.4", "backup-stage", FLAVOR_MARIADB, "10.3.39-MariaDB", "ftwrl"},
+		{"backup stage on MySQL", "backup-stage", FLAVOR_MYSQL, "8.0.36", "ftwrl"},
+		{"no lock", "none", FLAVOR_MYSQL, "8.0.36", "none"},
+	}
+	for _, tt := range tests {
+		*swLock = tt.lock
+		sm := simServerByURL("db1:3306")
+		sm.Flavor, sm.Version = tt.flavor, tt.version
+		if got := sm.lockMethod(); got != tt.want {
+			t.Errorf("%s: lockMethod() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSwitchoverLock(t *testing.T) {
+	defer func(f, l, s string, d bool) { *failover, *swLock, *stateFile, *dryRun = f, l, s, d }(*failover, *swLock, *stateFile, *dryRun)
+	*failover, *stateFile = "force", ""
+	tests := []struct {
+		name    string
+		lock    string
+		version string
+		fail    string
+		dryRun  bool
+		locks   []string // lock statements run on the old master, followed by the unlock one
+		master  string   // new master, empty when the switchover aborts
+		output  []string
+	}{
+		{"flush tables", "ftwrl", "10.6.12-MariaDB", "", false, []string{"FLUSH TABLES WITH READ LOCK", "UNLOCK TABLES"}, "db3:3306", nil},
+		{"backup stage", "backup-stage", "10.6.12-MariaDB", "", false, []string{"BACKUP STAGE START", "BACKUP STAGE BLOCK_COMMIT", "BACKUP STAGE END"}, "db3:3306", nil},
+		{"backup stage before 10.4", "backup-stage", "10.3.39-MariaDB", "", false, []string{"FLUSH TABLES WITH READ LOCK", "UNLOCK TABLES"}, "db3:3306", []string{"BACKUP STAGE is not supported by db1:3306"}},
+		{"no lock", "none", "10.6.12-MariaDB", "", false, nil, "db3:3306", nil},
+		{"lock failure", "ftwrl", "10.6.12-MariaDB", "FLUSH TABLES WITH READ LOCK", false, []string{"FLUSH TABLES WITH READ LOCK"}, "", []string{"Could not lock writes on db1:3306 (old master): FLUSH TABLES WITH READ LOCK: simulated statement failure"}},
+		{"dry run", "backup-stage", "10.6.12-MariaDB", "", true, nil, "", []string{"DRY-RUN: [db1:3306] BACKUP STAGE START", "DRY-RUN: [db1:3306] BACKUP STAGE BLOCK_COMMIT", "DRY-RUN: [db1:3306] release write lock"}},
+	}
+	for _, tt := range tests {
+		*swLock, *dryRun = tt.lock, tt.dryRun
+		sims := simCluster(t, simTopology())
+		var out bytes.Buffer
+		log.SetOutput(&out)
+		logWriter.out = &out
+		current.master = current.findMaster(true)
+		current.master.Flavor, current.master.Version = FLAVOR_MARIADB, tt.version
+		sims["db1:3306"].fail = tt.fail
+		nmUrl, _ := current.Switchover(context.Background())
+		if nmUrl != tt.master {
+			t.Errorf("%s: Switchover() = %q, want %q", tt.name, nmUrl, tt.master)
+		}
+		var locks []string
+		for _, stmt := range sims["db1:3306"].execs {
+			if strings.HasPrefix(stmt, "FLUSH TABLES WITH") || strings.HasPrefix(stmt, "BACKUP STAGE") || stmt == "UNLOCK TABLES" {
+				locks = append(locks, stmt)
+			}
+		}
+		if reflect.DeepEqual(locks, tt.locks) == false {
+			t.Errorf("%s: lock statements on the old master %q, want %q", tt.name, locks, tt.locks)
+		}
+		for _, want := range tt.output {
+			if strings.Contains(out.String(), want) == false {
+				t.Errorf("%s: output does not contain %q", tt.name, want)
+			}
+		}
+		if current.writeUnlock != "" {
+			t.Errorf("%s: write lock left held, released by %q", tt.name, current.writeUnlock)
+		}
+		// An aborted switchover leaves the old master writable and the candidate replicating
+		if tt.master == "" && tt.dryRun == false {
+			if sims["db1:3306"].vars["READ_ONLY"] != "OFF" {
+				t.Errorf("%s: old master left read-only", tt.name)
+			}
+			if sims["db3:3306"].ran("RESET SLAVE") {
+				t.Errorf("%s: candidate promoted after the lock failed", tt.name)
+			}
+		}
+	}
+}
@@ -0,0 +1,155 @@
+// logging.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* Log levels, in increasing order of severity */
+var logLevels = []string{"debug", "info", "warn", "error"}
+
+/* Prefixes of the log messages and the level they stand for, messages without prefix being informational */
+var logPrefixes = []struct {
+	prefix string
+	level  string
+}{
+	{"DEBUG:", "debug"},
+	{"INFO :", "info"},
+	{"INFO:", "info"},
+	{"WARN :", "warn"},
+	{"WARN:", "warn"},
+	{"ERROR:", "error"},
+	{"DRY-RUN:", "info"},
+}
+
+/* Destination of the log, filtering the messages by level and formatting them as text or JSON lines. It is the output of the log package and receives the console log with -log-file. */
+var logWriter = &levelWriter{out: os.Stderr, cluster: "default"}
+
+type levelWriter struct {
+	sync.Mutex
+	out     io.Writer
+	min     int
+	json    bool
+	file    bool
+	cluster string // cluster the monitor works on, which the log lines are attributed to
+}
+
+/* Attributes the next log lines to the named cluster. Returns the name of the cluster they were attributed to. */
+func (w *levelWriter) attribute(name string) string {
+	w.Lock()
+	defer w.Unlock()
+	prev := w.cluster
+	w.cluster = name
+	return prev
+}
+
+/* A log line in JSON format */
+type logEntry struct {
+	Time    string `json:"time"`
+	Level   string `json:"level"`
+	Cluster string `json:"cluster,omitempty"`
+	Msg     string `json:"msg"`
+}
+
+/* Returns the rank of a log level, -1 if it is unknown */
+func levelRank(level string) int {
+	for k, l := range logLevels {
+		if l == level {
+			return k
+		}
+	}
+	return -1
+}
+
+/* Returns the level of a message from its prefix, and the message without it */
+func parseLevel(s string) (string, string) {
+	for _, p := range logPrefixes {
+		if strings.HasPrefix(s, p.prefix) {
+			return p.level, strings.TrimSpace(s[len(p.prefix):])
+		}
+	}
+	return "info", s
+}
+
+/* Writes the messages of at least the minimum level, one line each */
+func (w *levelWriter) Write(p []byte) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+	msg := strings.TrimRight(string(p), "\n")
+	level, text := parseLevel(msg)
+	if levelRank(level) < w.min {
+		return len(p), nil
+	}
+	now := time.Now()
+	e := logEntry{Time: now.Format(time.RFC3339), Level: level, Cluster: w.cluster, Msg: text}
+	ringAdd(e)
+	var line []byte
+	if w.json {
+		line, _ = json.Marshal(e)
+		line = append(line, '\n')
+	} else {
+		line = []byte(now.Format("2006/01/02 15:04:05") + " " + msg + "\n")
+	}
+	_, err := w.out.Write(line)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+/* Sets up the log from the -log-level, -log-format and -log-file options. The timestamps are written by the log writer, in the format of the log lines. */
+func initLogging() error {
+	if *verbose && *logLevel == "info" {
+		*logLevel = "debug"
+	}
+	min := levelRank(*logLevel)
+	if min == -1 {
+		return errors.New(fmt.Sprintf("Incorrect log level %s, expected debug, info, warn or error", *logLevel))
+	}
+	if *logFormat != "text" && *logFormat != "json" {
+		return errors.New(fmt.Sprintf("Incorrect log format %s, expected text or json", *logFormat))
+	}
+	logWriter.min, logWriter.json = min, *logFormat == "json"
+	// Messages printed only in verbose mode are the debug level
+	*verbose = min == 0
+	if *logFile != "" {
+		f, err := os.OpenFile(*logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+		if err != nil {
+			return err
+		}
+		logWriter.out, logWriter.file = f, true
+	}
+	log.SetFlags(0)
+	log.SetOutput(logWriter)
+	if *logLines < 1 {
+		return errors.New(fmt.Sprintf("Incorrect console log lines %d, expected at least 1", *logLines))
+	}
+	if *ringFile != "" {
+		if *ringSize < 1 {
+			return errors.New(fmt.Sprintf("Incorrect log ring size %d, expected at least 1", *ringSize))
+		}
+		return loadLogRing()
+	}
+	return nil
+}
+
+/* Copies a line of the console log to the log file, since the console hides the log while it runs, and to the ring buffer */
+func logConsole(s string) {
+	if logWriter.file {
+		logWriter.Write([]byte(s))
+		return
+	}
+	level, text := parseLevel(s)
+	logWriter.Lock()
+	name := logWriter.cluster
+	logWriter.Unlock()
+	ringAdd(logEntry{Time: time.Now().Format(time.RFC3339), Level: level, Cluster: name, Msg: text})
+}
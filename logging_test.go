@@ -0,0 +1,123 @@
+// logging_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		msg   string
+		level string
+		text  string
+	}{
+		{"DEBUG: Refreshing db1:3306", "debug", "Refreshing db1:3306"},
+		{"INFO : Starting failover", "info", "Starting failover"},
+		{"INFO: Starting failover", "info", "Starting failover"},
+		{"WARN : Slave db2:3306 is late", "warn", "Slave db2:3306 is late"},
+		{"ERROR: Could not connect", "error", "Could not connect"},
+		{"DRY-RUN: [db1:3306] STOP SLAVE", "info", "[db1:3306] STOP SLAVE"},
+		{"Master Failure detected! Retry 1/3", "info", "Master Failure detected! Retry 1/3"},
+	}
+	for _, tt := range tests {
+		if level, text := parseLevel(tt.msg); level != tt.level || text != tt.text {
+			t.Errorf("parseLevel(%q) = %q, %q, want %q, %q", tt.msg, level, text, tt.level, tt.text)
+		}
+	}
+}
+
+func TestLevelWriter(t *testing.T) {
+	simCluster(t, simTopology())
+	tests := []struct {
+		name  string
+		level string
+		json  bool
+		want  []string // messages written, in order
+	}{
+		{"debug", "debug", false, []string{"DEBUG: d", "INFO : i", "WARN : w", "ERROR: e"}},
+		{"info", "info", false, []string{"INFO : i", "WARN : w", "ERROR: e"}},
+		{"error", "error", false, []string{"ERROR: e"}},
+		{"json", "warn", true, []string{"w", "e"}},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		w := &levelWriter{out: &out, min: levelRank(tt.level), json: tt.json}
+		w.attribute(current.clusterName())
+		for _, msg := range []string{"DEBUG: d", "INFO : i", "WARN : w", "ERROR: e"} {
+			w.Write([]byte(msg + "\n"))
+		}
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		if len(lines) != len(tt.want) {
+			t.Errorf("%s: lines %q, want %q", tt.name, lines, tt.want)
+			continue
+		}
+		for i, l := range lines {
+			if tt.json {
+				var e logEntry
+				err := json.Unmarshal([]byte(l), &e)
+				if err != nil || e.Msg != tt.want[i] || e.Cluster != current.clusterName() || e.Level == "" || e.Time == "" {
+					t.Errorf("%s: line %q, %v, want message %q", tt.name, l, err, tt.want[i])
+				}
+			} else if strings.HasSuffix(l, " "+tt.want[i]) == false {
+				t.Errorf("%s: line %q, want %q", tt.name, l, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestInitLogging(t *testing.T) {
+	defer func(l, f, file string, v bool, out io.Writer, min int, j, lf bool) {
+		*logLevel, *logFormat, *logFile, *verbose = l, f, file, v
+		logWriter.out, logWriter.min, logWriter.json, logWriter.file = out, min, j, lf
+		log.SetOutput(ioutil.Discard)
+	}(*logLevel, *logFormat, *logFile, *verbose, logWriter.out, logWriter.min, logWriter.json, logWriter.file)
+	file := filepath.Join(t.TempDir(), "repmgr.log")
+	tests := []struct {
+		name    string
+		level   string
+		format  string
+		verbose bool
+		min     int
+		err     string
+	}{
+		{"defaults", "info", "text", false, 1, ""},
+		{"verbose", "info", "text", true, 0, ""},
+		{"verbose with a level", "warn", "json", true, 2, ""},
+		{"unknown level", "trace", "text", false, 0, "Incorrect log level trace"},
+		{"unknown format", "info", "xml", false, 0, "Incorrect log format xml"},
+	}
+	for _, tt := range tests {
+		*logLevel, *logFormat, *verbose, *logFile = tt.level, tt.format, tt.verbose, ""
+		err := initLogging()
+		if tt.err != "" {
+			if err == nil || strings.HasPrefix(err.Error(), tt.err) == false {
+				t.Errorf("%s: initLogging() = %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || logWriter.min != tt.min || logWriter.json != (tt.format == "json") || *verbose != (tt.min == 0) {
+			t.Errorf("%s: initLogging() = %v, level %d, json %v, verbose %v", tt.name, err, logWriter.min, logWriter.json, *verbose)
+		}
+	}
+	// The log is appended to the log file, the console log included
+	*logLevel, *logFormat, *verbose, *logFile = "info", "text", false, file
+	err := initLogging()
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Println("INFO : Monitor started")
+	logConsole("WARN : Master Failure detected! Retry 1/3")
+	logWriter.out.(*os.File).Close()
+	b, _ := ioutil.ReadFile(file)
+	if strings.Contains(string(b), " INFO : Monitor started\n") == false || strings.Contains(string(b), " WARN : Master Failure detected! Retry 1/3\n") == false {
+		t.Errorf("log file %q, want both messages", b)
+	}
+}
@@ -0,0 +1,149 @@
+// logring.go
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/* Last log messages kept for the HTTP API with -log-ring-file, oldest first, and persisted to the file so that they survive restarts */
+var logRing struct {
+	sync.Mutex
+	entries []logEntry
+	written int
+	loaded  bool
+}
+
+/* Loads the messages persisted by a previous run */
+func loadLogRing() error {
+	logRing.Lock()
+	defer logRing.Unlock()
+	logRing.loaded = true
+	f, err := os.Open(*ringFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e logEntry
+		if json.Unmarshal(scanner.Bytes(), &e) == nil {
+			logRing.entries = append(logRing.entries, e)
+			logRing.written++
+		}
+	}
+	if len(logRing.entries) > *ringSize {
+		logRing.entries = logRing.entries[len(logRing.entries)-*ringSize:]
+	}
+	return scanner.Err()
+}
+
+/* Adds a message to the ring buffer and appends it to its file. The file is rewritten with the buffer once it holds twice as many messages, so that its size stays bounded. */
+func ringAdd(e logEntry) {
+	if *ringFile == "" {
+		return
+	}
+	logRing.Lock()
+	defer logRing.Unlock()
+	if logRing.loaded == false {
+		return
+	}
+	logRing.entries = append(logRing.entries, e)
+	if len(logRing.entries) > *ringSize {
+		logRing.entries = logRing.entries[1:]
+	}
+	if logRing.written >= 2**ringSize {
+		compactLogRing()
+		return
+	}
+	f, err := os.OpenFile(*ringFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	line, _ := json.Marshal(e)
+	f.Write(append(line, '\n'))
+	logRing.written++
+}
+
+/* Rewrites the ring buffer file with the messages of the buffer */
+func compactLogRing() {
+	tmp := *ringFile + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0640)
+	if err != nil {
+		return
+	}
+	w := bufio.NewWriter(f)
+	for _, e := range logRing.entries {
+		line, _ := json.Marshal(e)
+		w.Write(append(line, '\n'))
+	}
+	w.Flush()
+	f.Close()
+	if os.Rename(tmp, *ringFile) == nil {
+		logRing.written = len(logRing.entries)
+	}
+}
+
+/* Serves the last messages of the ring buffer, at most ?lines=<n> of them, of at least the ?level=<level> and of the ?cluster=<name> if given */
+func apiLog(w http.ResponseWriter, r *http.Request) {
+	if *ringFile == "" {
+		http.Error(w, "The log ring buffer is disabled, see -log-ring-file", http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	lines := 100
+	if s := q.Get("lines"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			http.Error(w, "Invalid lines "+s, http.StatusBadRequest)
+			return
+		}
+		lines = n
+	}
+	min := 0
+	if s := q.Get("level"); s != "" {
+		min = levelRank(s)
+		if min == -1 {
+			http.Error(w, "Invalid level "+s, http.StatusBadRequest)
+			return
+		}
+	}
+	res := []logEntry{}
+	logRing.Lock()
+	for k := len(logRing.entries) - 1; k >= 0 && len(res) < lines; k-- {
+		e := logRing.entries[k]
+		if levelRank(e.Level) >= min && (q.Get("cluster") == "" || e.Cluster == q.Get("cluster")) {
+			res = append([]logEntry{e}, res...)
+		}
+	}
+	logRing.Unlock()
+	apiWrite(w, res)
+}
+
+/* Writes the console log to a file of the -log-dump-dir directory, oldest line first */
+func dumpConsoleLog() {
+	path := filepath.Join(*logDump, "repmgr-console-"+time.Now().Format("20060102-150405")+".log")
+	f, err := os.Create(path)
+	if err != nil {
+		tlog.Add(fmt.Sprintf("ERROR: Could not dump the console log: %s", err))
+		return
+	}
+	defer f.Close()
+	for k := len(tlog) - 1; k >= 0; k-- {
+		if tlog[k] != "" {
+			fmt.Fprintln(f, tlog[k])
+		}
+	}
+	tlog.Add(fmt.Sprintf("INFO : Console log dumped to %s", path))
+}
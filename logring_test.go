@@ -0,0 +1,142 @@
+// logring_test.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/* Enables the ring buffer with the given size on a file of a temporary directory, empty or holding the given lines */
+func simLogRing(t *testing.T, size int, lines string) string {
+	file := filepath.Join(t.TempDir(), "repmgr.ring")
+	if lines != "" {
+		if err := ioutil.WriteFile(file, []byte(lines), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	*ringFile, *ringSize = file, size
+	logRing.entries, logRing.written, logRing.loaded = nil, 0, false
+	if err := loadLogRing(); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestLogRing(t *testing.T) {
+	defer func(f string, s int) {
+		*ringFile, *ringSize = f, s
+		logRing.entries, logRing.written, logRing.loaded = nil, 0, false
+	}(*ringFile, *ringSize)
+	// The messages of a previous run survive, the oldest beyond the size being dropped
+	file := simLogRing(t, 3, `{"level":"info","msg":"m1"}
+{"level":"info","msg":"m2"}
+not json
+{"level":"info","msg":"m3"}
+{"level":"info","msg":"m4"}
+`)
+	if len(logRing.entries) != 3 || logRing.entries[0].Msg != "m2" || logRing.written != 4 {
+		t.Errorf("loaded %+v, written %d, want m2 to m4", logRing.entries, logRing.written)
+	}
+	// The file is rewritten with the buffer once it holds twice as many messages: m7 compacts it to m5 to m7
+	for i := 5; i <= 8; i++ {
+		ringAdd(logEntry{Level: "info", Msg: fmt.Sprintf("m%d", i)})
+	}
+	b, _ := ioutil.ReadFile(file)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 4 || strings.Contains(lines[0], `"msg":"m5"`) == false || strings.Contains(lines[3], `"msg":"m8"`) == false {
+		t.Errorf("ring file after compaction %q, want m5 to m8", lines)
+	}
+	if len(logRing.entries) != 3 || logRing.entries[0].Msg != "m6" || logRing.written != 4 {
+		t.Errorf("buffer %+v, written %d, want m6 to m8", logRing.entries, logRing.written)
+	}
+	// The log writer and the console feed the buffer
+	simLogRing(t, 10, "")
+	w := &levelWriter{out: ioutil.Discard, min: 1}
+	w.Write([]byte("DEBUG: filtered\n"))
+	w.Write([]byte("WARN : from the log\n"))
+	logConsole("ERROR: from the console")
+	if len(logRing.entries) != 2 || logRing.entries[0].Msg != "from the log" || logRing.entries[1].Level != "error" {
+		t.Errorf("buffer %+v, want the log and console messages", logRing.entries)
+	}
+}
+
+func TestAPILog(t *testing.T) {
+	defer func(f string, s int) {
+		*ringFile, *ringSize = f, s
+		logRing.entries, logRing.written, logRing.loaded = nil, 0, false
+	}(*ringFile, *ringSize)
+	*ringFile = ""
+	w := httptest.NewRecorder()
+	apiLog(w, httptest.NewRequest("GET", "/api/log", nil))
+	if w.Code != 404 {
+		t.Errorf("log without ring buffer: %d, want 404", w.Code)
+	}
+	simLogRing(t, 10, "")
+	for _, e := range []logEntry{{Level: "info", Cluster: "eu", Msg: "a"}, {Level: "warn", Cluster: "us", Msg: "b"}, {Level: "error", Cluster: "eu", Msg: "c"}, {Level: "debug", Cluster: "eu", Msg: "d"}} {
+		ringAdd(e)
+	}
+	tests := []struct {
+		query string
+		code  int
+		want  string
+	}{
+		{"", 200, "abcd"},
+		{"?lines=2", 200, "cd"},
+		{"?level=warn", 200, "bc"},
+		{"?cluster=eu", 200, "acd"},
+		{"?cluster=eu&level=info&lines=1", 200, "c"},
+		{"?lines=0", 400, ""},
+		{"?level=trace", 400, ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		apiLog(w, httptest.NewRequest("GET", "/api/log"+tt.query, nil))
+		if w.Code != tt.code {
+			t.Errorf("%s: %d, want %d", tt.query, w.Code, tt.code)
+			continue
+		}
+		if tt.code != 200 {
+			continue
+		}
+		var res []logEntry
+		json.NewDecoder(w.Body).Decode(&res)
+		got := ""
+		for _, e := range res {
+			got += e.Msg
+		}
+		if got != tt.want {
+			t.Errorf("%s: messages %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestConsoleLog(t *testing.T) {
+	defer func(d string) { *logDump = d }(*logDump)
+	*logDump = t.TempDir()
+	tlog = NewTermLog(3)
+	for i := 1; i <= 4; i++ {
+		tlog.Add(fmt.Sprintf("INFO : line %d", i))
+	}
+	// The console keeps the last lines, newest first
+	if len(tlog) != 3 || strings.HasSuffix(tlog[0], "line 4") == false || strings.HasSuffix(tlog[2], "line 2") == false {
+		t.Errorf("console log %q, want lines 4 to 2", tlog)
+	}
+	dumpConsoleLog()
+	files, _ := filepath.Glob(filepath.Join(*logDump, "repmgr-console-*.log"))
+	if len(files) != 1 {
+		t.Fatalf("dumped files %q, want one", files)
+	}
+	b, _ := ioutil.ReadFile(files[0])
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 3 || strings.HasSuffix(lines[0], "line 2") == false || strings.HasSuffix(lines[2], "line 4") == false {
+		t.Errorf("dump %q, want lines 2 to 4, oldest first", lines)
+	}
+	if strings.Contains(tlog[0], "Console log dumped to "+files[0]) == false {
+		t.Errorf("console log %q, want the dump path", tlog[0])
+	}
+}
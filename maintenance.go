@@ -0,0 +1,70 @@
+// maintenance.go
+package main
+
+import (
+	"github.com/nsf/termbox-go"
+	"net/http"
+)
+
+/* Returns true if the server of the cluster is in maintenance */
+func (c *Cluster) inMaintenance(url string) bool {
+	return contains(c.stateData.Maintenance, url)
+}
+
+func (sm *ServerMonitor) inMaintenance() bool {
+	return sm.cluster.inMaintenance(sm.URL)
+}
+
+/* Puts a server in maintenance or takes it out. A server in maintenance is still displayed, but it is neither checked nor promoted, its failures raise no alert and a master in maintenance is not failed over. The list is kept in the state file. */
+func (c *Cluster) setMaintenance(sm *ServerMonitor, on bool, who string) {
+	if on == sm.inMaintenance() {
+		return
+	}
+	if on {
+		c.stateData.Maintenance = append(c.stateData.Maintenance, sm.URL)
+		logprintf("INFO : Server %s is now in maintenance", sm.label())
+		audit("Server %s put in maintenance by %s", sm.URL, who)
+	} else {
+		for k, url := range c.stateData.Maintenance {
+			if url == sm.URL {
+				c.stateData.Maintenance = append(c.stateData.Maintenance[:k], c.stateData.Maintenance[k+1:]...)
+				break
+			}
+		}
+		logprintf("INFO : Server %s is no longer in maintenance", sm.label())
+		audit("Server %s taken out of maintenance by %s", sm.URL, who)
+	}
+	c.saveState()
+}
+
+/* Puts the selected server in maintenance, or takes it out if it already is */
+func (c *Cluster) toggleMaintenance() {
+	sm := selectedServer()
+	c.setMaintenance(sm, !sm.inMaintenance(), "console")
+}
+
+/* Returns the attributes of a console server row, greyed out when the server is in maintenance */
+func serverAttr(row int, sm *ServerMonitor) termbox.Attribute {
+	if sm.inMaintenance() == false {
+		return rowAttr(row)
+	}
+	if row == selected {
+		return termbox.ColorBlack | termbox.AttrBold | termbox.AttrReverse
+	}
+	return termbox.ColorBlack | termbox.AttrBold
+}
+
+/* Serves /api/servers/<host:port>/maintenance: GET returns whether the server is in maintenance, POST puts it in maintenance and DELETE takes it out */
+func (c *Cluster) apiMaintenance(w http.ResponseWriter, r *http.Request, sm *ServerMonitor) {
+	switch r.Method {
+	case "GET":
+	case "POST":
+		c.setMaintenance(sm, true, "API client "+requestUser(r))
+	case "DELETE":
+		c.setMaintenance(sm, false, "API client "+requestUser(r))
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	apiWrite(w, map[string]bool{"Maintenance": sm.inMaintenance()})
+}
@@ -0,0 +1,106 @@
+// maintenance_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMaintenanceChecks(t *testing.T) {
+	tests := []struct {
+		name        string
+		down        string
+		maintenance bool
+		failCount   int
+		state       string // state of the down server after the checks
+		alerts      int
+	}{
+		{"master down", "db1:3306", false, 3, STATE_FAILED, 1},
+		{"master down in maintenance", "db1:3306", true, 0, STATE_MASTER, 0},
+		{"slave down", "db2:3306", false, 0, STATE_FAILED, 1},
+		{"slave down in maintenance", "db2:3306", true, 0, STATE_SLAVE, 0},
+	}
+	for _, tt := range tests {
+		sims := simCluster(t, simTopology())
+		current.master = current.findMaster(true)
+		current.master.State = STATE_MASTER
+		if tt.maintenance {
+			current.setMaintenance(simServerByURL(tt.down), true, "test")
+		}
+		sims[tt.down].down = true
+		events, stop := simWatch()
+		for i := 0; i < *maxFail; i++ {
+			current.refreshTopology(context.Background())
+		}
+		stop()
+		if current.failCount != tt.failCount || simServerByURL(tt.down).State != tt.state {
+			t.Errorf("%s: failcount %d, %s %s, want failcount %d, %s", tt.name, current.failCount, tt.down, simServerByURL(tt.down).State, tt.failCount, tt.state)
+		}
+		if len(events) != tt.alerts {
+			t.Errorf("%s: %d alerts, want %d", tt.name, len(events), tt.alerts)
+		}
+	}
+	// Only the alerts about the server itself are suppressed
+	simCluster(t, simTopology())
+	current.setMaintenance(simServerByURL("db2:3306"), true, "test")
+	events, stop := simWatch()
+	current.alert(ALERT_DELAY, "db2:3306", "Slave db2:3306 is late")
+	current.alert(ALERT_DELAY, "db3:3306", "Slave db3:3306 is late")
+	current.alert(ALERT_SWITCHOVER, "db2:3306", "Switchover started")
+	stop()
+	if len(events) != 2 {
+		t.Errorf("%d alerts, want the delay of db3:3306 and the switchover", len(events))
+	}
+}
+
+func TestAPIMaintenance(t *testing.T) {
+	simCluster(t, simTopology())
+	tests := []struct {
+		method string
+		code   int
+		want   bool
+	}{
+		{"GET", 200, false},
+		{"POST", 200, true},
+		{"POST", 200, true},
+		{"GET", 200, true},
+		{"PUT", 405, true},
+		{"DELETE", 200, false},
+		{"DELETE", 200, false},
+	}
+	for i, tt := range tests {
+		w := httptest.NewRecorder()
+		current.apiServerQuery(w, httptest.NewRequest(tt.method, "/api/servers/db2:3306/maintenance", nil))
+		if w.Code != tt.code {
+			t.Errorf("%d %s: %d, want %d", i, tt.method, w.Code, tt.code)
+		}
+		if tt.code == 200 {
+			var res map[string]bool
+			json.NewDecoder(w.Body).Decode(&res)
+			if res["Maintenance"] != tt.want {
+				t.Errorf("%d %s: %v, want %v", i, tt.method, res, tt.want)
+			}
+		}
+		// A server is listed once, however often it is put in maintenance
+		if n := len(current.stateData.Maintenance); (tt.want && n != 1) || (tt.want == false && n != 0) {
+			t.Errorf("%d %s: servers in maintenance %q", i, tt.method, current.stateData.Maintenance)
+		}
+	}
+	current.setMaintenance(simServerByURL("db3:3306"), true, "test")
+	w := httptest.NewRecorder()
+	current.apiServers(w, httptest.NewRequest("GET", "/api/servers", nil))
+	var servers []apiServer
+	json.NewDecoder(w.Body).Decode(&servers)
+	for _, s := range servers {
+		if s.Maintenance != (s.URL == "db3:3306") {
+			t.Errorf("%s: API maintenance %v", s.URL, s.Maintenance)
+		}
+	}
+	w = httptest.NewRecorder()
+	current.apiServerQuery(w, httptest.NewRequest("POST", "/api/servers/db9:3306/maintenance", nil))
+	if w.Code != 404 {
+		t.Errorf("maintenance of an unknown server: %d, want 404", w.Code)
+	}
+}
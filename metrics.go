@@ -0,0 +1,136 @@
+// metrics.go
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	metricMutex  sync.Mutex
+	metricCounts = map[string]int{} // events counted since the last flush, by metric path
+)
+
+/* Returns a metric path component, with the separators Graphite and StatsD give a meaning to replaced */
+func metricName(s string) string {
+	return strings.NewReplacer(".", "_", ":", "_", " ", "_", "/", "_", "|", "_", "@", "_").Replace(s)
+}
+
+/* Returns the metric path of a name of the cluster */
+func (c *Cluster) metricPath(name ...string) string {
+	return strings.Join(append([]string{*metricsPrefix, metricName(c.clusterName())}, name...), ".")
+}
+
+/* Sends gauges, in name value pairs, to Graphite over TCP or to StatsD over UDP */
+func sendMetrics(gauges [][2]string, statsdType string) error {
+	addr := *metricsAddr
+	if addr == "" {
+		addr = "127.0.0.1:2003"
+		if *metrics == "statsd" {
+			addr = "127.0.0.1:8125"
+		}
+	}
+	proto := "tcp"
+	if *metrics == "statsd" {
+		proto = "udp"
+	}
+	conn, err := net.DialTimeout(proto, addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	now := time.Now().Unix()
+	var buf bytes.Buffer
+	for _, g := range gauges {
+		if *metrics == "statsd" {
+			line := fmt.Sprintf("%s:%s|%s\n", g[0], g[1], statsdType)
+			// Keep datagrams under the usual network MTU
+			if buf.Len()+len(line) > 1400 {
+				if _, err = conn.Write(buf.Bytes()); err != nil {
+					return err
+				}
+				buf.Reset()
+			}
+			buf.WriteString(line)
+		} else {
+			fmt.Fprintf(&buf, "%s %s %d\n", g[0], g[1], now)
+		}
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+	_, err = conn.Write(buf.Bytes())
+	return err
+}
+
+/* Sends the replication delay, state and throughput of the servers of the cluster at each monitoring tick */
+func (c *Cluster) metricsCheck() {
+	if *metrics == "" || c.master == nil {
+		return
+	}
+	var gauges [][2]string
+	failed := 0
+	for _, s := range c.knownServers() {
+		up, isMaster := 1, 0
+		if s.State == STATE_FAILED {
+			up = 0
+			failed++
+		}
+		if s == c.master {
+			isMaster = 1
+		}
+		p := c.metricPath("servers", metricName(s.URL))
+		gauges = append(gauges,
+			[2]string{p + ".up", fmt.Sprint(up)},
+			[2]string{p + ".master", fmt.Sprint(isMaster)},
+		)
+		if up == 0 {
+			continue
+		}
+		if s != c.master && s.Delay.Valid {
+			gauges = append(gauges, [2]string{p + ".delay", fmt.Sprint(s.Delay.Int64)})
+		}
+		gauges = append(gauges,
+			[2]string{p + ".binlog_rate", fmt.Sprintf("%.0f", s.BinlogRate)},
+			[2]string{p + ".apply_rate", fmt.Sprintf("%.0f", s.ApplyRate)},
+		)
+	}
+	gauges = append(gauges,
+		[2]string{c.metricPath("slaves"), fmt.Sprint(len(c.slaves))},
+		[2]string{c.metricPath("failed"), fmt.Sprint(failed)},
+	)
+	err := sendMetrics(gauges, "g")
+	if err != nil {
+		alertLog("WARN : Could not send metrics to %s: %s", *metrics, err)
+	}
+}
+
+/* Counts an alert event, such as a failover. The events of a monitoring tick are sent together, as StatsD counters or Graphite points of their count. */
+func (c *Cluster) metricEvent(event string) {
+	if *metrics == "" {
+		return
+	}
+	metricMutex.Lock()
+	metricCounts[c.metricPath("events", metricName(event))]++
+	metricMutex.Unlock()
+}
+
+/* Queues the events counted since the last flush for the alert worker, in a single send */
+func metricEventsFlush() {
+	metricMutex.Lock()
+	var counters [][2]string
+	for p, n := range metricCounts {
+		counters = append(counters, [2]string{p, fmt.Sprint(n)})
+	}
+	metricCounts = map[string]int{}
+	metricMutex.Unlock()
+	if len(counters) == 0 {
+		return
+	}
+	deliver("metrics to "+*metrics, func() error { return sendMetrics(counters, "c") })
+}
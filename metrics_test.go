@@ -0,0 +1,94 @@
+// metrics_test.go
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricName(t *testing.T) {
+	if got := metricName("db1.example.com:3306"); got != "db1_example_com_3306" {
+		t.Errorf("metricName() = %q, want %q", got, "db1_example_com_3306")
+	}
+	if got := metricName("a/b|c@d e"); got != "a_b_c_d_e" {
+		t.Errorf("metricName() = %q, want %q", got, "a_b_c_d_e")
+	}
+}
+
+func TestGraphiteMetrics(t *testing.T) {
+	defer func(m, a string) { *metrics, *metricsAddr = m, a }(*metrics, *metricsAddr)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		b, _ := ioutil.ReadAll(conn)
+		conn.Close()
+		received <- string(b)
+	}()
+	*metrics, *metricsAddr = "graphite", l.Addr().String()
+	simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 2 }))
+	current.master = current.findMaster(true)
+	current.metricsCheck()
+	var out string
+	select {
+	case out = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no metrics received")
+	}
+	for _, want := range []string{
+		"replication-manager.default.servers.db1_3306.up 1 ",
+		"replication-manager.default.servers.db1_3306.master 1 ",
+		"replication-manager.default.servers.db2_3306.up 0 ",
+		"replication-manager.default.servers.db3_3306.master 0 ",
+		"replication-manager.default.servers.db3_3306.delay 0 ",
+		"replication-manager.default.slaves 2 ",
+		"replication-manager.default.failed 1 ",
+	} {
+		if strings.Contains(out, "\n"+want) == false && strings.HasPrefix(out, want) == false {
+			t.Errorf("metrics do not contain %q:\n%s", want, out)
+		}
+	}
+	// A failed server only reports its state
+	if strings.Contains(out, "db2_3306.delay") || strings.Contains(out, "db2_3306.apply_rate") {
+		t.Errorf("metrics of the failed server:\n%s", out)
+	}
+}
+
+func TestStatsDEvents(t *testing.T) {
+	defer func(m, a string) { *metrics, *metricsAddr = m, a }(*metrics, *metricsAddr)
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	*metrics, *metricsAddr = "statsd", conn.LocalAddr().String()
+	simCluster(t, simTopology())
+	current.metricEvent(ALERT_FAILOVER)
+	current.metricEvent(ALERT_FAILOVER)
+	metricEventsFlush()
+	alertPending.Wait()
+	b := make([]byte, 1500)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "replication-manager.default.events." + metricName(ALERT_FAILOVER) + ":2|c\n"
+	if string(b[:n]) != want {
+		t.Errorf("datagram %q, want %q", b[:n], want)
+	}
+	// Events are counted from zero after each flush
+	if len(metricCounts) != 0 {
+		t.Errorf("counts %v left after the flush", metricCounts)
+	}
+}
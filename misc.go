@@ -2,20 +2,47 @@
 package main
 
 import (
+	"github.com/tanji/mariadb-tools/dbhelper"
 	"log"
 	"net"
 	"strconv"
 	"strings"
 )
 
-/* Returns two host and port items from a pair, e.g. host:port */
+/* Returns two host and port items from a pair, e.g. host:port or [ipv6]:port. A bare IPv6 address has no port. */
 func splitHostPort(s string) (string, string) {
-	items := strings.Split(s, ":")
-	if len(items) == 1 {
-		return items[0], "3306"
+	host, rest := splitHost(s)
+	items := strings.Split(rest, ":")
+	if rest == "" || items[0] == "" {
+		return host, "3306"
 	} else {
-		return items[0], items[1]
+		return host, items[0]
+	}
+}
+
+/* Returns the host of a host:port[:...] item without IPv6 brackets, and the items after it */
+func splitHost(s string) (string, string) {
+	if strings.HasPrefix(s, "[") {
+		if i := strings.Index(s, "]"); i > 0 {
+			return s[1:i], strings.TrimPrefix(s[i+1:], ":")
+		}
+	}
+	if strings.Count(s, ":") > 1 && net.ParseIP(s) != nil {
+		return s, ""
+	}
+	items := strings.SplitN(s, ":", 2)
+	if len(items) == 1 {
+		return items[0], ""
+	}
+	return items[0], items[1]
+}
+
+/* Returns the driver TCP address of a host, with brackets around IPv6 addresses */
+func tcpAddress(host string, port string) string {
+	if strings.Contains(host, ":") {
+		return "tcp(" + net.JoinHostPort(host, port) + ")"
 	}
+	return dbhelper.GetAddress(host, port, *socket)
 }
 
 /* Returns generic items from a pair, e.g. user:pass */
@@ -60,7 +87,7 @@ func getSeqFromGtid(gtid string) uint64 {
 func shift(s []string, e string) []string {
 	ns := make([]string, 1)
 	ns[0] = e
-	ns = append(ns, s[0:20]...)
+	ns = append(ns, s[0:len(s)-1]...)
 	return ns
 }
 
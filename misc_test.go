@@ -0,0 +1,58 @@
+// misc_test.go
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSplitHostPort(t *testing.T) {
+	tests := []struct {
+		s    string
+		host string
+		port string
+		rest string // items after the host, as split by splitHost
+	}{
+		{"db1", "db1", "3306", ""},
+		{"db1:3307", "db1", "3307", "3307"},
+		{"db1:3307:50", "db1", "3307", "3307:50"},
+		{"192.168.0.1:3307", "192.168.0.1", "3307", "3307"},
+		{"[2001:db8::1]", "2001:db8::1", "3306", ""},
+		{"[2001:db8::1]:3307", "2001:db8::1", "3307", "3307"},
+		{"[2001:db8::1]:3307:50", "2001:db8::1", "3307", "3307:50"},
+		{"2001:db8::1", "2001:db8::1", "3306", ""},
+		{"::1", "::1", "3306", ""},
+	}
+	for _, tt := range tests {
+		if host, port := splitHostPort(tt.s); host != tt.host || port != tt.port {
+			t.Errorf("splitHostPort(%q) = %q, %q, want %q, %q", tt.s, host, port, tt.host, tt.port)
+		}
+		if host, rest := splitHost(tt.s); host != tt.host || rest != tt.rest {
+			t.Errorf("splitHost(%q) = %q, %q, want %q, %q", tt.s, host, rest, tt.host, tt.rest)
+		}
+	}
+	if got := tcpAddress("2001:db8::1", "3307"); got != "tcp([2001:db8::1]:3307)" {
+		t.Errorf("tcpAddress() = %q, want tcp([2001:db8::1]:3307)", got)
+	}
+}
+
+func TestIPv6Failover(t *testing.T) {
+	defer func(f, s string) { *failover, *stateFile = f, s }(*failover, *stateFile)
+	*failover, *stateFile = "force", ""
+	sims := simCluster(t, []simSpec{
+		{url: "[2001:db8::1]:3306", id: 1, gtid: "0-1-115", down: true},
+		{url: "[2001:db8::2]:3306", id: 2, master: 1, gtid: "0-1-110"},
+		{url: "[2001:db8::3]:3307", id: 3, master: 1, gtid: "0-1-115"},
+	})
+	current.master = current.findMaster(false)
+	if current.master == nil || current.master.Host != "2001:db8::1" {
+		t.Fatalf("master %v, want [2001:db8::1]:3306", current.master)
+	}
+	if nmUrl, err := current.Failover(context.Background()); nmUrl != "[2001:db8::3]:3307" {
+		t.Fatalf("Failover() = %q, %v, want [2001:db8::3]:3307", nmUrl, err)
+	}
+	// The server takes the address without brackets
+	if sims["[2001:db8::2]:3306"].ran("CHANGE MASTER TO master_host='2001:db8::3', master_port=3307") == false {
+		t.Errorf("slave not repointed to the IPv6 master: %q", sims["[2001:db8::2]:3306"].execs)
+	}
+}
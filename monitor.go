@@ -2,22 +2,26 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
+	"github.com/mariadb-corporation/replication-manager/pkg/cluster"
 	"github.com/tanji/mariadb-tools/dbhelper"
 	"log"
-	"os/exec"
 	"strconv"
+	"strings"
 	"time"
 )
 
 type ServerMonitor struct {
 	Conn           *sqlx.DB
 	URL            string
+	Name           string
 	Host           string
+	Socket         string
 	Port           string
 	IP             string
 	BinlogPos      string
@@ -29,39 +33,110 @@ type ServerMonitor struct {
 	UsingGtid      string
 	CurrentGtid    string
 	SlaveGtid      string
+	GtidDomain     string   // gtid_domain_id of the transactions written on the server
+	Domains        []uint32 // GTID domains of the transactions applied by the server
 	IOThread       string
 	SQLThread      string
+	IOErrno        uint
+	IOError        string
+	SQLErrno       uint
+	SQLError       string
+	Channel        string
+	Channels       []Channel
+	WsrepCluster   string
+	WsrepState     string
+	WsrepStatus    string
+	WsrepSize      int
+	GroupName      string
+	GroupRole      string
+	GroupState     string
+	GroupOnline    int
+	GroupSize      int
 	ReadOnly       string
+	SuperReadOnly  string
+	EventScheduler string
 	Delay          sql.NullInt64
+	SQLDelay       int64
 	State          string
+	Version        string
+	Flavor         string
+	BinlogSize     uint64
+	BinlogRate     float64
+	ExecFile       string
+	ReadFile       string
+	ReadPos        uint64
+	ExecPos        uint64
+	ApplyRate      float64
+	delayAlerted   bool
+	errorAlerted   uint
+	skipped        []time.Time
+	skipLimited    bool
+	binlogFiles    []binlogFile
+	binlogSampled  time.Time
+	applySampled   time.Time
+	readMoved      time.Time
+	retryAt        time.Time
+	retryDelay     time.Duration
+	db             Backend         // simulated backend replacing the connection, nil for real servers
+	refreshCtx     context.Context // bounds the queries of the refresh in progress, nil otherwise
+	cluster        *Cluster        // cluster whose topology the server belongs to
 }
 
 /* Initializes a server object */
-func newServerMonitor(url string) (*ServerMonitor, error) {
+func (c *Cluster) newServerMonitor(url string) (*ServerMonitor, error) {
 	server := new(ServerMonitor)
-	server.URL = url
-	server.Host, server.Port = splitHostPort(url)
+	server.URL, server.cluster = url, c
+	if name := aliasOf(url); name != url {
+		server.Name = name
+	}
+	if isSocket(url) {
+		server.Host, server.Port, server.Socket = "localhost", "3306", strings.TrimPrefix(url, "unix:")
+	} else {
+		server.Host, server.Port = splitHostPort(url)
+	}
+	if b, ok := simBackends[url]; ok {
+		server.IP, server.Flavor, server.State, server.db = server.Host, FLAVOR_MARIADB, STATE_UNCONN, b
+		if server.Socket != "" {
+			server.socketEndpoint()
+		}
+		return server, nil
+	}
 	var err error
-	server.IP, err = dbhelper.CheckHostAddr(server.Host)
-	if err != nil {
-		return server, errors.New(fmt.Sprintf("ERROR: DNS resolution error for host %s", server.Host))
+	if server.Socket == "" {
+		server.IP, err = dbhelper.CheckHostAddr(server.Host)
+		if err != nil {
+			return server, errors.New(fmt.Sprintf("ERROR: DNS resolution error for host %s", server.Host))
+		}
 	}
-	server.Conn, err = dbhelper.MySQLConnect(dbUser, dbPass, dbhelper.GetAddress(server.Host, server.Port, *socket))
+	server.Conn, err = dbConnectAs(server.address(), c.dbUser, c.dbPass)
 	if err != nil {
-		server.State = STATE_FAILED
+		server.setState(STATE_FAILED)
 		return server, errors.New(fmt.Sprintf("ERROR: could not connect to server %s: %s", url, err))
 	}
+	if server.Socket != "" {
+		server.socketEndpoint()
+	}
+	server.detectFlavor()
 	server.State = STATE_UNCONN
 	return server, nil
 }
 
 /* Refresh a server object */
 func (sm *ServerMonitor) refresh() error {
-	err := sm.Conn.Ping()
+	if sm.db == nil && (sm.State == STATE_FAILED || sm.Conn == nil) {
+		err := sm.probe()
+		if err != nil {
+			return err
+		}
+	}
+	err := sm.backend().Ping()
 	if err != nil {
 		return err
 	}
-	sv, err := dbhelper.GetVariables(sm.Conn)
+	if sm.Flavor == FLAVOR_MYSQL {
+		return sm.refreshMySQL()
+	}
+	sv, err := sm.backend().Variables()
 	if err != nil {
 		return err
 	}
@@ -69,25 +144,46 @@ func (sm *ServerMonitor) refresh() error {
 	sm.Strict = sv["GTID_STRICT_MODE"]
 	sm.LogBin = sv["LOG_BIN"]
 	sm.ReadOnly = sv["READ_ONLY"]
+	sm.SuperReadOnly = sv["SUPER_READ_ONLY"]
+	sm.EventScheduler = sv["EVENT_SCHEDULER"]
 	sm.CurrentGtid = sv["GTID_CURRENT_POS"]
 	sm.SlaveGtid = sv["GTID_SLAVE_POS"]
+	sm.GtidDomain = sv["GTID_DOMAIN_ID"]
+	sm.Domains = cluster.GTIDDomains(sm.CurrentGtid)
 	sid, _ := strconv.ParseUint(sv["SERVER_ID"], 10, 0)
 	sm.ServerId = uint(sid)
-	slaveStatus, err := dbhelper.GetSlaveStatus(sm.Conn)
+	sm.sampleBinlogRate()
+	sm.readWsrep(sv)
+	sm.readChannels()
+	slaveStatus, err := sm.slaveStatus()
 	if err != nil {
 		return err
 	}
 	sm.UsingGtid = slaveStatus.Using_Gtid
 	sm.IOThread = slaveStatus.Slave_IO_Running
 	sm.SQLThread = slaveStatus.Slave_SQL_Running
+	sm.IOErrno, sm.IOError = slaveStatus.Last_IO_Errno, slaveStatus.Last_IO_Error
+	sm.SQLErrno, sm.SQLError = slaveStatus.Last_SQL_Errno, slaveStatus.Last_SQL_Error
 	sm.Delay = slaveStatus.Seconds_Behind_Master
 	sm.MasterServerId = slaveStatus.Master_Server_Id
 	sm.MasterHost = slaveStatus.Master_Host
+	if slaveStatus.Master_Log_File != "" {
+		sm.trackRead(slaveStatus.Master_Log_File, uint64(slaveStatus.Read_Master_Log_Pos))
+	}
+	if sm.UsingGtid != "" {
+		sm.sampleApplyRate(slaveStatus.Relay_Master_Log_File, uint64(slaveStatus.Exec_Master_Log_Pos))
+	}
 	return err
 }
 
 /* Check replication health and return status string */
 func (sm *ServerMonitor) healthCheck() string {
+	if sm.SQLThread == "No" && sm.SQLErrno != 0 {
+		return fmt.Sprintf("NOT OK, SQL Err %d", sm.SQLErrno)
+	}
+	if sm.IOThread != "Yes" && sm.IOErrno != 0 {
+		return fmt.Sprintf("NOT OK, IO Err %d", sm.IOErrno)
+	}
 	if sm.Delay.Valid == false {
 		if sm.SQLThread == "Yes" && sm.IOThread == "No" {
 			return "NOT OK, IO Stopped"
@@ -97,263 +193,565 @@ func (sm *ServerMonitor) healthCheck() string {
 			return "NOT OK, ALL Stopped"
 		}
 	} else {
-		if sm.Delay.Int64 > 0 {
+		if sm.lag() > 0 {
 			return "Behind master"
 		}
 		return "Running OK"
 	}
 }
 
+/* Sets the server state and raises an alert when the server transitions to failed */
+func (sm *ServerMonitor) setState(state string) {
+	if state == STATE_FAILED && sm.State != STATE_FAILED {
+		sm.cluster.alert(ALERT_SERVER_FAILED, sm.URL, "Server %s is unreachable and has been marked as failed", sm.label())
+		if sm.State == STATE_SLAVE {
+			runHooks(HOOK_SLAVE_FAIL, hookContext{Cluster: sm.cluster.clusterName(), OldMaster: sm.cluster.master, Server: sm})
+		}
+	}
+	if sm.State == STATE_FAILED && state != STATE_FAILED && sm.URL == sm.cluster.failedMasterURL {
+		runHooks(HOOK_MASTER_RECOVERED, hookContext{Cluster: sm.cluster.clusterName(), OldMaster: sm, NewMaster: sm.cluster.master, Server: sm})
+	}
+	if state == STATE_SLAVE && sm.State == STATE_FAILED {
+		sm.cluster.alert(ALERT_REJOINED, sm.URL, "Slave %s is reachable again and has rejoined the topology", sm.label())
+	}
+	if sm.State != state {
+		sm.State = state
+		sm.cluster.saveState()
+	}
+}
+
+/* Raises an alert once when the replication delay crosses the alert threshold, and rearms it when the slave catches up */
+func (sm *ServerMonitor) checkDelay() {
+	if *alertDelay == 0 {
+		return
+	}
+	if sm.lag() > *alertDelay {
+		if sm.delayAlerted == false {
+			sm.cluster.alert(ALERT_DELAY, sm.URL, "Slave %s is %d seconds behind master (threshold %d)", sm.label(), sm.lag(), *alertDelay)
+			sm.delayAlerted = true
+		}
+	} else {
+		sm.delayAlerted = false
+	}
+}
+
+/* Returns the errors of the stopped replication threads of the slave, empty if none */
+func (sm *ServerMonitor) replError() string {
+	var l []string
+	if sm.IOThread != "Yes" && sm.IOErrno != 0 {
+		l = append(l, fmt.Sprintf("IO error %d: %s", sm.IOErrno, sm.IOError))
+	}
+	if sm.SQLThread != "Yes" && sm.SQLErrno != 0 {
+		l = append(l, fmt.Sprintf("SQL error %d: %s", sm.SQLErrno, sm.SQLError))
+	}
+	return strings.Join(l, " | ")
+}
+
+/* Raises an alert once when the SQL thread of the slave stops on an error, and rearms it when the error is cleared or changes */
+func (sm *ServerMonitor) checkErrors() {
+	if sm.SQLThread != "No" || sm.SQLErrno == 0 {
+		sm.errorAlerted = 0
+		return
+	}
+	if sm.errorAlerted != sm.SQLErrno {
+		sm.cluster.alert(ALERT_REPL_ERROR, sm.URL, "Slave %s SQL thread stopped on error %d: %s", sm.label(), sm.SQLErrno, sm.SQLError)
+		sm.errorAlerted = sm.SQLErrno
+	}
+}
+
 /* Triggers a master switchover. Returns the new master's URL */
-func (master *ServerMonitor) switchover() (string, int) {
+func (master *ServerMonitor) switchover(ctx context.Context) (string, int) {
+	defer operationStart()()
+	restore, err := master.cluster.adminSession()
+	defer restore()
+	// The statements of the switchover would run without the privileges of the administration user
+	if err != nil {
+		logprintf("ERROR: %s. Cannot switchover", err)
+		return "", -1
+	}
+	defer master.cluster.recordOperation("switchover")()
+	ctx, end := master.cluster.beginOperation(ctx)
+	defer end()
 	logprint("INFO : Starting switchover")
+	master.cluster.alert(ALERT_SWITCHOVER, master.URL, "Switchover started on master %s", master.label())
 	// Phase 1: Cleanup and election
+	if *swMaxQueryTime > 0 {
+		err := master.killLongQueries()
+		if err != nil {
+			logprintf("ERROR: %s on master. Cannot switchover", err)
+			return "", -1
+		}
+	}
 	logprintf("INFO : Flushing tables on %s (master)", master.URL)
-	err := dbhelper.FlushTablesNoLog(master.Conn)
+	err = master.execContext(ctx, "FLUSH NO_WRITE_TO_BINLOG TABLES")
 	if err != nil {
-		logprintf("WARN : Could not flush tables on master", err)
+		logprintf("WARN : Could not flush tables on master: %s", err)
+	}
+	if *binlogFlushSw {
+		logprintf("INFO : Flushing binary logs on %s (master)", master.URL)
+		err = master.execContext(ctx, "FLUSH BINARY LOGS")
+		if err != nil {
+			logprintf("WARN : Could not flush binary logs on master: %s", err)
+		}
 	}
 	logprint("INFO : Checking long running updates on master")
-	if dbhelper.CheckLongRunningWrites(master.Conn, 10) > 0 {
+	if n, err := master.longRunningWrites(10); err != nil {
+		logprintf("ERROR: Could not list running updates on master: %s. Cannot switchover", err)
+		return "", -1
+	} else if n > 0 {
 		logprint("ERROR: Long updates running on master. Cannot switchover")
 		return "", -1
 	}
 	logprint("INFO : Electing a new master")
 	var nmUrl string
-	key := master.electCandidate(slaves)
+	key := master.electCandidate(master.cluster.slaves)
 	if key == -1 {
+		if *compatCheck == "block" {
+			master.cluster.compatReport(master.cluster.slaves)
+		}
+		logprint("ERROR: No candidate can be promoted. Aborting switchover")
 		return "", -1
 	}
-	nmUrl = slaves[key].URL
+	nmUrl = master.cluster.slaves[key].URL
 	logprintf("INFO : Slave %s has been elected as a new master", nmUrl)
-	newMaster, err := newServerMonitor(nmUrl)
-	if *preScript != "" {
-		logprintf("INFO : Calling pre-failover script")
-		out, err := exec.Command(*preScript, master.Host, newMaster.Host).CombinedOutput()
+	if eta, ok := master.cluster.slaves[key].catchupEstimate(); ok {
+		logprintf("INFO : Estimated catch-up time for %s once writes are frozen: %s (apply rate %.1f kB/s)", nmUrl, eta, master.cluster.slaves[key].ApplyRate/1024)
+	} else {
+		logprintf("INFO : Catch-up time for %s cannot be estimated yet", nmUrl)
+	}
+	newMaster, err := master.cluster.newServerMonitor(nmUrl)
+	newMaster.UsingGtid = master.cluster.slaves[key].UsingGtid
+	defer master.cluster.beginCheckpoint("switchover", master, newMaster)()
+	err = runHooks(HOOK_PRE_SWITCHOVER, hookContext{Cluster: master.cluster.clusterName(), OldMaster: master, NewMaster: newMaster})
+	if err != nil {
+		logprintf("ERROR: %s. Aborting switchover", err)
+		return "", -1
+	}
+	if ctx.Err() != nil {
+		logprintf("ERROR: Switchover %s before writes were rejected. Aborting switchover", master.cluster.abortError(ctx))
+		return "", -1
+	}
+	// From here the old master is changed, a switchover interrupted before the promotion restores it
+	master.cluster.checkpoint(STEP_FROZEN)
+	if master.cluster.vip != nil {
+		logprintf("INFO : Removing virtual IP %s from %s (old master)", master.cluster.Vip, master.Host)
+		err = master.cluster.vip.Remove(master.Host)
 		if err != nil {
-			logprint("ERROR:", err)
+			logprintf("WARN : Could not remove virtual IP from old master: %s", err)
 		}
-		logprint("INFO : Pre-failover script complete:", string(out))
 	}
 	// Phase 2: Reject updates and sync slaves
-	master.freeze()
+	master.stopEvents(logprintf)
+	master.freeze(ctx)
 	logprintf("INFO : Rejecting updates on %s (old master)", master.URL)
-	err = dbhelper.FlushTablesWithReadLock(master.Conn)
+	err = master.lockWrites(ctx)
 	if err != nil {
-		logprintf("WARN : Could not lock tables on %s (old master) %s", master.URL, err)
+		logprintf("ERROR: Could not lock writes on %s (old master): %s. Aborting switchover", master.URL, err)
+		master.unfreeze()
+		return "", -1
 	}
 	logprint("INFO : Switching master")
 	logprint("INFO : Waiting for candidate master to synchronize")
-	masterGtid := dbhelper.GetVariableByName(master.Conn, "GTID_BINLOG_POS")
+	masterSync, err := master.syncPoint()
+	if err != nil {
+		logprintf("ERROR: Could not read position of %s (old master): %s. Aborting switchover", master.URL, err)
+		master.unfreeze()
+		return "", -1
+	}
 	if *verbose {
-		logprintf("DEBUG: Syncing on master GTID Current Pos [%s]", masterGtid)
+		logprintf("DEBUG: Syncing on master position [%s]", masterSync)
 		master.log()
 	}
-	dbhelper.MasterPosWait(newMaster.Conn, masterGtid)
+	wt, err := newMaster.waitSync(ctx, masterSync, *gtidWaitTimeout)
+	if err != nil {
+		logprintf("ERROR: Candidate master %s did not reach position %s after %s: %s. Aborting switchover", newMaster.URL, masterSync, wt, err)
+		master.unfreeze()
+		return "", -1
+	}
+	logprintf("INFO : Candidate master %s reached position %s in %s", newMaster.URL, masterSync, wt)
+	// Past this point the switchover cannot be aborted, the new master stops replicating
+	ctx, err = master.cluster.commitOperation(ctx)
+	if err != nil {
+		logprintf("ERROR: Switchover %s. Aborting switchover", err)
+		master.unfreeze()
+		return "", -1
+	}
 	if *verbose {
-		logprint("DEBUG: MASTER_POS_WAIT executed.")
 		newMaster.log()
 	}
 	// Phase 3: Prepare new master
 	logprint("INFO : Stopping slave thread on new master")
-	err = dbhelper.StopSlave(newMaster.Conn)
+	err = newMaster.execContext(ctx, "STOP SLAVE")
 	if err != nil {
 		logprint("WARN : Stopping slave failed on new master")
 	}
-	// Call post-failover script before unlocking the old master.
-	if *postScript != "" {
-		logprintf("INFO : Calling post-failover script")
-		out, err := exec.Command(*postScript, master.Host, newMaster.Host).CombinedOutput()
+	master.cluster.checkpoint(STEP_PROMOTED)
+	// A failed step now reverts the switchover, which repoints the servers already moved with GTID
+	var rb *switchoverRollback
+	if *swRollback && master.cluster.anyPositional() == false {
+		rb = &switchoverRollback{oldMaster: master, newMaster: newMaster}
+	}
+	var newPos binlogPos
+	if master.cluster.anyPositional() {
+		newPos, err = newMaster.masterStatus()
 		if err != nil {
-			logprint("ERROR:", err)
+			logprintf("ERROR: Could not read binlog coordinates of new master: %s", err)
 		}
-		logprint("INFO : Post-failover script complete", string(out))
 	}
+	// Call post-failover script before unlocking the old master.
+	runHooks(HOOK_POST_SWITCHOVER, hookContext{Cluster: master.cluster.clusterName(), OldMaster: master, NewMaster: newMaster})
 	logprint("INFO : Resetting slave on new master and set read/write mode on")
-	err = dbhelper.ResetSlave(newMaster.Conn, true)
-	if err != nil {
-		logprint("WARN : Reset slave failed on new master")
-	}
-	err = dbhelper.SetReadOnly(newMaster.Conn, false)
+	repl := master.cluster.replication(logprintf)
+	err = cluster.Promote(ctx, newMaster.node(), repl)
 	if err != nil {
 		logprint("ERROR: Could not set new master as read-write")
+		if rb.rollback(fmt.Sprintf("could not set new master %s read-write, %s", newMaster.URL, err)) {
+			return "", -1
+		}
 	}
-	newGtid := dbhelper.GetVariableByName(master.Conn, "GTID_BINLOG_POS")
+	newMaster.startEvents(master, logprintf)
+	newMaster.runPromotionSQL(master, logprintf)
+	if master.cluster.vip != nil {
+		logprintf("INFO : Adding virtual IP %s to %s (new master)", master.cluster.Vip, newMaster.Host)
+		err = master.cluster.vip.Add(newMaster.Host)
+		if err != nil {
+			logprintf("ERROR: Could not add virtual IP to new master: %s", err)
+		}
+	}
+	master.cluster.dnsMove(newMaster, logprintf)
+	newGtid := master.binlogGtid()
 	// Insert a bogus transaction in order to have a new GTID pos on master
-	err = dbhelper.FlushTables(newMaster.Conn)
+	err = newMaster.execContext(ctx, "FLUSH TABLES")
 	if err != nil {
 		logprint("WARN : Could not flush tables on new master", err)
 	}
 	// Phase 4: Demote old master to slave
-	cm := "CHANGE MASTER TO master_host='" + newMaster.IP + "', master_port=" + newMaster.Port + ", master_user='" + rplUser + "', master_password='" + rplPass + "'"
 	logprint("INFO : Switching old master as a slave")
-	err = dbhelper.UnlockTables(master.Conn)
+	err = master.unlockWrites()
 	if err != nil {
 		logprint("WARN : Could not unlock tables on old master", err)
 	}
-	dbhelper.StopSlave(master.Conn) // This is helpful because in some cases the old master can have an old configuration running
-	_, err = master.Conn.Exec("SET GLOBAL gtid_slave_pos='" + newGtid + "'")
-	if err != nil {
-		logprint("WARN : Could not set gtid_slave_pos on old master", err)
+	// Replication is stopped first because in some cases the old master can have an old configuration running
+	src := cluster.Source{MasterOpt: newPos.changeMasterOpt(), ReadOnly: *readonly}
+	if master.cluster.positional == false {
+		src = cluster.Source{GTIDPos: newGtid, MasterOpt: master.gtidMasterOpt("slave_pos"), ReadOnly: *readonly}
 	}
-	_, err = master.Conn.Exec(cm + ", master_use_gtid=slave_pos")
+	err = cluster.Repoint(ctx, master.node(), newMaster.node(), repl, src)
 	if err != nil {
-		logprint("WARN : Change master failed on old master", err)
+		logprint("WARN : Could not demote old master", err)
+		if rb.rollback(fmt.Sprintf("could not demote old master %s, %s", master.URL, err)) {
+			return "", -1
+		}
 	}
-	err = dbhelper.StartSlave(master.Conn)
-	if err != nil {
-		logprint("WARN : Start slave failed on old master", err)
+	if rb != nil {
+		rb.demoted = true
 	}
-	if *readonly {
-		err = dbhelper.SetReadOnly(master.Conn, true)
-		if err != nil {
-			logprintf("ERROR: Could not set old master as read-only, %s", err)
-		}
+	if *switchover == "kill" && *swSuperRO && *readonly == false {
+		master.setSuperReadOnly(false)
 	}
+	// The old master is now a slave, the applications may connect again
+	master.unblockUsers()
+	master.cluster.checkpoint(STEP_DEMOTED)
 	// Phase 5: Switch slaves to new master
 	logprint("INFO : Switching other slaves to the new master")
 	var oldMasterKey int
-	for k, sl := range slaves {
+	for k, sl := range master.cluster.slaves {
 		if sl.URL == newMaster.URL {
-			slaves[k].URL = master.URL
 			oldMasterKey = k
 			if *verbose {
 				logprintf("DEBUG: New master %s found in slave slice at key %d, reinstancing URL to %s", sl.URL, k, master.URL)
 			}
 			continue
 		}
-		logprintf("INFO : Waiting for slave %s to sync", sl.URL)
-		dbhelper.MasterPosWait(sl.Conn, masterGtid)
+		if sl.isDelayed() && sl.usesPositions() {
+			logprintf("ERROR: Delayed slave %s cannot be repointed without GTID before it applies its relay logs. It must be repointed manually", sl.URL)
+			continue
+		}
+		// A delayed slave never reaches the position in time, it resumes from its own GTID position instead
+		if sl.isDelayed() == false {
+			logprintf("INFO : Waiting for slave %s to sync", sl.URL)
+			wt, err = sl.waitSync(ctx, masterSync, *gtidWaitTimeout)
+			if err != nil {
+				logprintf("WARN : Slave %s did not reach position %s after %s: %s", sl.URL, masterSync, wt, err)
+			} else {
+				logprintf("INFO : Slave %s reached position %s in %s", sl.URL, masterSync, wt)
+			}
+		}
 		if *verbose {
 			sl.log()
 		}
 		logprintf("INFO : Change master on slave %s", sl.URL)
-		err := dbhelper.StopSlave(sl.Conn)
-		if err != nil {
-			logprintf("WARN : Could not stop slave on server %s, %s", sl.URL, err)
+		src := cluster.Source{ReadOnly: *readonly && sl.isGalera() == false}
+		if sl.usesPositions() {
+			src.MasterOpt = newPos.changeMasterOpt()
+		} else if sl.isDelayed() == false {
+			src.GTIDPos = newGtid
 		}
-		_, err = sl.Conn.Exec("SET GLOBAL gtid_slave_pos='" + newGtid + "'")
-		if err != nil {
-			logprintf("WARN : Could not set gtid_slave_pos on slave %s, %s", sl.URL, err)
-		}
-		_, err = sl.Conn.Exec(cm)
-		if err != nil {
-			logprintf("ERROR: Change master failed on slave %s, %s", sl.URL, err)
+		if rb != nil {
+			rb.moved = append(rb.moved, sl)
 		}
-		err = dbhelper.StartSlave(sl.Conn)
+		err := cluster.Repoint(ctx, sl.node(), newMaster.node(), repl, src)
 		if err != nil {
-			logprintf("ERROR: could not start slave on server %s, %s", sl.URL, err)
-		}
-		if *readonly {
-			err = dbhelper.SetReadOnly(sl.Conn, true)
-			if err != nil {
-				logprintf("ERROR: Could not set slave %s as read-only, %s", sl.URL, err)
+			logprintf("ERROR: Could not repoint slave %s, %s", sl.URL, err)
+			if rb.rollback(fmt.Sprintf("could not repoint slave %s, %s", sl.URL, err)) {
+				return "", -1
 			}
 		}
+		master.cluster.checkpointSlave(sl.URL)
+	}
+	// The new master takes the place of the old master among the slaves
+	if master.cluster.slaves[oldMasterKey].URL == newMaster.URL {
+		master.cluster.slaves[oldMasterKey].URL = master.URL
+	}
+	master.cluster.checkpoint(STEP_COMPLETED)
+	if *dryRun {
+		logprint("INFO : Dry run of switchover complete, nothing was changed")
+		return "", -1
 	}
 	logprint("INFO : Switchover complete")
+	opRecord.complete(newMaster)
+	master.cluster.alert(ALERT_SWITCHOVER_DONE, newMaster.URL, "Switchover complete, %s has been promoted to replace %s", newMaster.label(), master.label())
 	return newMaster.URL, oldMasterKey
 }
 
 /* Triggers a master failover. Returns the new master's URL and key */
-func (master *ServerMonitor) failover() (string, int) {
+func (master *ServerMonitor) failover(ctx context.Context) (string, int) {
+	defer operationStart()()
+	// A failover is not delayed, the servers that cannot be reached as the administration user keep the monitoring connection
+	restore, err := master.cluster.adminSession()
+	defer restore()
+	if err != nil {
+		log.Printf("WARN : %s, failing over with the monitoring user on them", err)
+	}
+	defer master.cluster.recordOperation("failover")()
+	ctx, end := master.cluster.beginOperation(ctx)
+	defer end()
 	log.Println("INFO : Starting failover and electing a new master")
+	master.cluster.alert(ALERT_FAILOVER, master.URL, "Failover started on master %s", master.label())
+	if peer := master.galeraPeer(); peer != nil {
+		return master.peerFailover(peer), -1
+	}
+	if peer := master.groupPeer(); peer != nil {
+		return master.peerFailover(peer), -1
+	} else if master.GroupName != "" {
+		log.Printf("WARN : No online primary found in the replication group of %s, electing an asynchronous replica", master.URL)
+	}
 	var nmUrl string
-	key := master.electCandidate(slaves)
+	key := master.electCandidate(master.cluster.slaves)
 	if key == -1 {
+		if *dryRun == false {
+			master.reportAbort("no viable candidate")
+		}
 		return "", -1
 	}
-	nmUrl = slaves[key].URL
+	nmUrl = master.cluster.slaves[key].URL
 	log.Printf("INFO : Slave %s has been elected as a new master", nmUrl)
-	newMaster, err := newServerMonitor(nmUrl)
-	if *preScript != "" {
-		log.Printf("INFO : Calling pre-failover script")
-		out, err := exec.Command(*preScript, master.Host, newMaster.Host).CombinedOutput()
+	newMaster, err := master.cluster.newServerMonitor(nmUrl)
+	newMaster.UsingGtid = master.cluster.slaves[key].UsingGtid
+	defer master.cluster.beginCheckpoint("failover", master, newMaster)()
+	err = runHooks(HOOK_PRE_FAILOVER, hookContext{Cluster: master.cluster.clusterName(), OldMaster: master, NewMaster: newMaster})
+	if err != nil {
+		log.Printf("ERROR: %s. Aborting failover", err)
+		return "", -1
+	}
+	err = master.fence()
+	if err != nil {
+		master.reportAbort(fmt.Sprintf("could not fence the failed master, %s", err))
+		return "", -1
+	}
+	if master.cluster.vip != nil {
+		log.Printf("INFO : Removing virtual IP %s from %s (failed master)", master.cluster.Vip, master.Host)
+		err = master.cluster.vip.Remove(master.Host)
 		if err != nil {
-			log.Println("ERROR:", err)
+			log.Printf("WARN : Could not remove virtual IP from failed master: %s", err)
 		}
-		log.Println("INFO : Post-failover script complete:", string(out))
 	}
 	log.Println("INFO : Switching master")
-	log.Println("INFO : Stopping slave thread on new master")
-	err = dbhelper.StopSlave(newMaster.Conn)
-	if err != nil {
-		log.Println("WARN : Stopping slave failed on new master")
+	// Without GTID, slaves can only be repointed if they executed exactly what the new master executed
+	var candidatePos, newPos binlogPos
+	if master.cluster.anyPositional() || *rescueBinlogs {
+		log.Println("INFO : Waiting for new master to apply its relay logs")
+		candidatePos, err = newMaster.waitRelayApplied(ctx, *gtidWaitTimeout)
+		if err != nil {
+			log.Printf("WARN : New master %s: %s", newMaster.URL, err)
+		}
+	} else if newMaster.Flavor == FLAVOR_MARIADB {
+		log.Println("INFO : Waiting for new master to apply the transactions the slaves received in every GTID domain")
+		pos, wt, err := newMaster.waitReceivedGtid(ctx, master.cluster.slaves, *gtidWaitTimeout)
+		if err != nil {
+			log.Printf("WARN : New master %s did not apply position %s after %s, transactions received by other slaves may be lost: %s", newMaster.URL, pos, wt, err)
+		} else if pos != "" {
+			log.Printf("INFO : New master %s applied position %s in %s", newMaster.URL, pos, wt)
+		}
 	}
-	cm := "CHANGE MASTER TO master_host='" + newMaster.IP + "', master_port=" + newMaster.Port + ", master_user='" + rplUser + "', master_password='" + rplPass + "'"
-	log.Println("INFO : Resetting slave on new master and set read/write mode on")
-	err = dbhelper.ResetSlave(newMaster.Conn, true)
+	// Past this point the failover cannot be aborted, the new master stops replicating
+	ctx, err = master.cluster.commitOperation(ctx)
 	if err != nil {
-		log.Println("WARN : Reset slave failed on new master")
+		log.Printf("ERROR: Failover %s, %s was not promoted. Aborting failover", err, newMaster.URL)
+		return "", -1
 	}
-	err = dbhelper.SetReadOnly(newMaster.Conn, false)
+	log.Println("INFO : Stopping slave thread on new master")
+	err = newMaster.execContext(ctx, "STOP SLAVE")
 	if err != nil {
-		log.Println("ERROR: Could not set new master as read-write")
+		log.Println("WARN : Stopping slave failed on new master")
 	}
-	log.Println("INFO : Switching other slaves to the new master")
-	for _, sl := range slaves {
-		log.Printf("INFO : Change master on slave %s", sl.URL)
-		err := dbhelper.StopSlave(sl.Conn)
+	master.cluster.checkpoint(STEP_PROMOTED)
+	// Read before the rescue, so that slaves without GTID also replicate the rescued events
+	if master.cluster.anyPositional() {
+		newPos, err = newMaster.masterStatus()
 		if err != nil {
-			log.Printf("WARN : Could not stop slave on server %s, %s", sl.URL, err)
+			log.Printf("ERROR: Could not read binlog coordinates of new master: %s", err)
 		}
-		_, err = sl.Conn.Exec(cm)
+	}
+	if *rescueBinlogs {
+		log.Printf("INFO : Rescuing binary logs of failed master %s", master.URL)
+		err = newMaster.rescueBinlogs(master)
 		if err != nil {
-			log.Printf("ERROR: Change master failed on slave %s, %s", sl.URL, err)
+			log.Printf("ERROR: Binary log rescue failed, transactions the new master did not receive are lost: %s", err)
+		} else {
+			log.Println("INFO : Binary logs of the failed master applied to the new master")
 		}
-		err = dbhelper.StartSlave(sl.Conn)
+	}
+	log.Println("INFO : Resetting slave on new master and set read/write mode on")
+	repl := master.cluster.replication(log.Printf)
+	err = cluster.Promote(ctx, newMaster.node(), repl)
+	if err != nil {
+		log.Printf("ERROR: Could not set new master as read-write, %s", err)
+	}
+	newMaster.startEvents(master, log.Printf)
+	newMaster.runPromotionSQL(master, log.Printf)
+	if master.cluster.vip != nil {
+		log.Printf("INFO : Adding virtual IP %s to %s (new master)", master.cluster.Vip, newMaster.Host)
+		err = master.cluster.vip.Add(newMaster.Host)
 		if err != nil {
-			log.Printf("ERROR: could not start slave on server %s, %s", sl.URL, err)
+			log.Printf("ERROR: Could not add virtual IP to new master: %s", err)
 		}
-		if *readonly {
-			err = dbhelper.SetReadOnly(sl.Conn, true)
+	}
+	master.cluster.dnsMove(newMaster, log.Printf)
+	log.Println("INFO : Switching other slaves to the new master")
+	for _, sl := range master.cluster.slaves {
+		if sl.URL == newMaster.URL {
+			continue
+		}
+		if sl.isDelayed() && sl.usesPositions() {
+			log.Printf("ERROR: Delayed slave %s cannot be repointed without GTID before it applies its relay logs. It must be repointed manually", sl.URL)
+			continue
+		}
+		if sl.usesPositions() {
+			pos, err := sl.waitRelayApplied(ctx, *gtidWaitTimeout)
 			if err != nil {
-				log.Printf("ERROR: Could not set slave %s as read-only, %s", sl.URL, err)
+				log.Printf("ERROR: Slave %s: %s. It must be repointed manually", sl.URL, err)
+				continue
+			}
+			if pos != candidatePos {
+				log.Printf("ERROR: Slave %s executed %s but the new master executed %s. It cannot be repointed without GTID and must be resynchronized", sl.URL, pos, candidatePos)
+				continue
 			}
 		}
-	}
-	if *postScript != "" {
-		log.Printf("INFO : Calling post-failover script")
-		out, err := exec.Command(*postScript, master.Host, newMaster.Host).CombinedOutput()
+		log.Printf("INFO : Change master on slave %s", sl.URL)
+		src := cluster.Source{ReadOnly: *readonly && sl.isGalera() == false}
+		if sl.usesPositions() {
+			src.MasterOpt = newPos.changeMasterOpt()
+		}
+		err := cluster.Repoint(ctx, sl.node(), newMaster.node(), repl, src)
 		if err != nil {
-			log.Println("ERROR:", err)
+			log.Printf("ERROR: Could not repoint slave %s, %s", sl.URL, err)
 		}
-		log.Println("INFO : Post-failover script complete", string(out))
+		master.cluster.checkpointSlave(sl.URL)
+	}
+	master.cluster.checkpoint(STEP_COMPLETED)
+	runHooks(HOOK_POST_FAILOVER, hookContext{Cluster: master.cluster.clusterName(), OldMaster: master, NewMaster: newMaster})
+	if *dryRun {
+		log.Println("INFO : Dry run of failover complete, nothing was changed")
+		return "", -1
 	}
 	log.Println("INFO : Failover complete")
+	opRecord.complete(newMaster)
+	master.cluster.alert(ALERT_FAILOVER_DONE, newMaster.URL, "Failover complete, %s has been promoted to replace %s", newMaster.label(), master.label())
 	return newMaster.URL, key
 }
 
 /* Handles write freeze and existing transactions on a server */
-func (server *ServerMonitor) freeze() bool {
-	err := dbhelper.SetReadOnly(server.Conn, true)
+func (server *ServerMonitor) freeze(ctx context.Context) bool {
+	if *drainTimeout > 0 {
+		server.drain(ctx)
+	}
+	if *switchover == "kill" {
+		server.blockUsers()
+	}
+	err := server.run("SET GLOBAL read_only=1", setReadOnly(true))
 	if err != nil {
 		logprintf("WARN : Could not set %s as read-only: %s", server.URL, err)
 		return false
 	}
-	for i := *waitKill; i > 0; i -= 500 {
-		threads := dbhelper.CheckLongRunningWrites(server.Conn, 0)
-		if threads == 0 {
+	for i := *waitKill; i > 0 && ctx.Err() == nil; i -= 500 {
+		threads, err := server.longRunningWrites(0)
+		if err != nil || threads == 0 {
 			break
 		}
 		logprintf("INFO : Waiting for %d write threads to complete on %s", threads, server.URL)
 		time.Sleep(500 * time.Millisecond)
 	}
 	logprintf("INFO : Terminating all threads on %s", server.URL)
-	dbhelper.KillThreads(server.Conn)
+	server.run("KILL client threads", killThreads)
+	if *switchover == "kill" {
+		if *swSuperRO {
+			server.setSuperReadOnly(true)
+		}
+		if *swKillAll {
+			server.killClients()
+		}
+	}
 	return true
 }
 
-/* Returns a candidate from a list of slaves. If there's only one slave it will be the de facto candidate. */
+/* Reverts the effects of freeze and read lock on a master when a switchover is aborted. Returns the error of the server remaining read-only. */
+func (server *ServerMonitor) unfreeze() error {
+	logprintf("INFO : Releasing locks and restoring writes on %s", server.URL)
+	err := server.unlockWrites()
+	if err != nil {
+		logprintf("WARN : Could not unlock tables on %s: %s", server.URL, err)
+	}
+	if *switchover == "kill" && *swSuperRO {
+		server.setSuperReadOnly(false)
+	}
+	server.unblockUsers()
+	err = server.run("SET GLOBAL read_only=0", setReadOnly(false))
+	if err != nil {
+		logprintf("ERROR: Could not set %s as read-write: %s", server.URL, err)
+	}
+	server.restoreEvents(logprintf)
+	if server.cluster.vip != nil {
+		err := server.cluster.vip.Add(server.Host)
+		if err != nil {
+			logprintf("ERROR: Could not add virtual IP back to %s: %s", server.URL, err)
+		}
+	}
+	return err
+}
+
+/* Returns a candidate from a list of slaves. If there's only one slave it will be the de facto candidate. In preferred mode the preferred master wins as soon as it is eligible, in most-advanced mode it only wins ties. */
 func (master *ServerMonitor) electCandidate(l []*ServerMonitor) int {
 	ll := len(l)
 	if *verbose {
 		logprintf("DEBUG: Processing %d candidates", ll)
 	}
-	seqList := make([]uint64, ll)
-	i := 0
-	hiseq := 0
-	for _, sl := range l {
+	var candidates []cluster.Candidate
+	var keys []int
+	dc := master.cluster.electionDC()
+	for k, sl := range l {
+		if sl.State == STATE_FAILED {
+			logprintf("WARN : Slave %s is in failed state. Skipping", sl.URL)
+			continue
+		}
+		if sl.isDelayed() {
+			logprintf("WARN : Slave %s is a delayed replica (MASTER_DELAY=%d). Skipping", sl.URL, sl.SQLDelay)
+			continue
+		}
+		if sl.isGalera() && sl.galeraSynced() == false {
+			logprintf("WARN : Slave %s is a Galera node out of the primary component (%s, %s). Skipping", sl.URL, sl.WsrepState, sl.WsrepStatus)
+			continue
+		}
 		if *failover == "" {
 			if *verbose {
 				logprintf("DEBUG: Checking eligibility of slave server %s", sl.URL)
@@ -369,13 +767,17 @@ func (master *ServerMonitor) electCandidate(l []*ServerMonitor) int {
 				logprintf("WARN : Replication filters differ on master and slave %s. Skipping", sl.URL)
 				continue
 			}
-			ss, _ := dbhelper.GetSlaveStatus(sl.Conn)
+			ss, _ := sl.slaveStatus()
 			if ss.Seconds_Behind_Master.Valid == false {
 				logprintf("WARN : Slave %s is stopped. Skipping", sl.URL)
 				continue
 			}
-			if ss.Seconds_Behind_Master.Int64 > *maxDelay {
-				logprintf("WARN : Slave %s has more than %d seconds of replication delay (%d). Skipping", sl.URL, *maxDelay, ss.Seconds_Behind_Master.Int64)
+			delay := ss.Seconds_Behind_Master.Int64
+			if lag, ok := sl.heartbeatLag(); ok {
+				delay = lag
+			}
+			if delay > *maxDelay {
+				logprintf("WARN : Slave %s has more than %d seconds of replication delay (%d). Skipping", sl.URL, *maxDelay, delay)
 				continue
 			}
 			if *gtidCheck && dbhelper.CheckSlaveSync(sl.Conn, master.Conn) == false {
@@ -383,37 +785,66 @@ func (master *ServerMonitor) electCandidate(l []*ServerMonitor) int {
 				continue
 			}
 		}
+		if sl.inMaintenance() {
+			logprintf("WARN : Slave %s is in maintenance. Skipping", sl.URL)
+			continue
+		}
 		/* If server is in the ignore list, do not elect it */
-		if contains(ignoreList, sl.URL) {
+		if sl.ignored() {
 			if *verbose {
 				logprintf("DEBUG: %s is in the ignore list. Skipping", sl.URL)
 			}
 			continue
 		}
-		/* Rig the election if the examined slave is preferred candidate master */
-		if sl.URL == *prefMaster {
-			if *verbose {
-				logprintf("DEBUG: Election rig: %s elected as preferred master", sl.URL)
+		/* A newer candidate may write binlog events that older slaves, including a demoted master, cannot apply */
+		if *compatCheck != "off" {
+			issues := sl.binlogCompatIssues(append([]*ServerMonitor{master}, l...))
+			for _, issue := range issues {
+				logprintf("WARN : %s", issue)
+			}
+			if len(issues) > 0 && *compatCheck == "block" {
+				logprintf("WARN : Slave %s has binlog compatibility issues. Skipping", sl.URL)
+				continue
+			}
+		}
+		if *durabilityCheck != "off" {
+			issues := sl.durabilityIssues()
+			for _, issue := range issues {
+				logprintf("WARN : %s", issue)
+			}
+			if len(issues) > 0 && *durabilityCheck == "block" && *forcePromote == false {
+				logprintf("WARN : Slave %s is not durable enough to be a master. Skipping, use -force to promote it anyway", sl.URL)
+				continue
 			}
-			return i
 		}
-		seqList[i] = getSeqFromGtid(dbhelper.GetVariableByName(sl.Conn, "GTID_CURRENT_POS"))
-		var max uint64
-		if i == 0 {
-			max = seqList[0]
-		} else if seqList[i] > max {
-			max = seqList[i]
-			hiseq = i
+		var seq uint64
+		if master.cluster.anyPositional() {
+			seq = sl.positionSeq()
+		} else {
+			seq = sl.gtidSeq()
 		}
-		i++
+		w := master.cluster.weights[sl.URL]
+		if *verbose {
+			logprintf("DEBUG: Slave %s is at sequence %d with weight %d", sl.URL, seq, w)
+		}
+		candidates = append(candidates, cluster.Candidate{URL: sl.URL, Seq: seq, Weight: w, Local: dc == "" || sl.labelValue("dc") == dc})
+		keys = append(keys, k)
 	}
-	if i > 0 {
-		/* Return key of slave with the highest seqno. */
-		return hiseq
-	} else {
+	/* Candidates in the election datacenter come first. Then in preferred mode the weight comes first, otherwise it only breaks ties. */
+	if len(candidates) > 0 && master.cluster.anyPositional() == false && multiDomain(l) {
+		candidates, keys = coveringCandidates(l, candidates, keys)
+	}
+	best := cluster.Elect(candidates, *electionMode)
+	if best == -1 {
 		log.Println("ERROR: No suitable candidates found.")
 		return -1
 	}
+	hikey := keys[best]
+	if candidates[best].Local == false {
+		logprintf("WARN : No eligible candidate in datacenter %s, electing %s in datacenter %s", dc, l[hikey].URL, l[hikey].labelValue("dc"))
+	}
+	/* Return key of slave with the highest seqno. */
+	return hikey
 }
 
 func (server *ServerMonitor) log() {
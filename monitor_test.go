@@ -0,0 +1,327 @@
+// monitor_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+/* Master and two slaves of a simulated cluster, the second slave being the most advanced */
+func simTopology() []simSpec {
+	return []simSpec{
+		{url: "db1:3306", id: 1, gtid: "0-1-120"},
+		{url: "db2:3306", id: 2, master: 1, gtid: "0-1-110"},
+		{url: "db3:3306", id: 3, master: 1, gtid: "0-1-115"},
+	}
+}
+
+/* Returns the topology with the changes applied to the servers, by URL */
+func simChange(specs []simSpec, change func(sp *simSpec)) []simSpec {
+	for i := range specs {
+		change(&specs[i])
+	}
+	return specs
+}
+
+func TestFindMaster(t *testing.T) {
+	tests := []struct {
+		name    string
+		specs   []simSpec
+		running bool
+		want    string
+	}{
+		{"running master by server id", simTopology(), true, "db1:3306"},
+		{"failed master by host", simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 1 }), false, "db1:3306"},
+		{"failed master ignored in monitor mode", simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 1 }), true, ""},
+		{"running master ignored in failover mode", simTopology(), false, ""},
+		{"master outside the cluster", simChange(simTopology(), func(sp *simSpec) {
+			if sp.master != 0 {
+				sp.master = 9
+			}
+		}), true, ""},
+		{"no slave", simTopology()[:1], true, ""},
+	}
+	for _, tt := range tests {
+		simCluster(t, tt.specs)
+		got := ""
+		if m := current.findMaster(tt.running); m != nil {
+			got = m.URL
+		}
+		if got != tt.want {
+			t.Errorf("%s: findMaster(%v) = %q, want %q", tt.name, tt.running, got, tt.want)
+		}
+	}
+}
+
+func TestElectCandidate(t *testing.T) {
+	defer func(f, m string) { *failover, *electionMode = f, m }(*failover, *electionMode)
+	*failover = "force"
+	tests := []struct {
+		name        string
+		specs       []simSpec
+		mode        string
+		weights     map[string]int
+		maintenance []string
+		ignore      []string
+		want        string
+	}{
+		{"most advanced slave", simTopology(), "most-advanced", nil, nil, nil, "db3:3306"},
+		{"most advanced slave without preference", simTopology(), "preferred", nil, nil, nil, "db3:3306"},
+		{"no slave ahead in every domain", simChange(simTopology(), func(sp *simSpec) {
+			if sp.id == 2 {
+				sp.gtid = "0-1-110,1-2-10"
+			}
+		}), "most-advanced", nil, nil, nil, ""},
+		{"slave ahead in every domain", simChange(simTopology(), func(sp *simSpec) {
+			if sp.id == 2 {
+				sp.gtid = "0-1-110,1-2-10"
+			}
+			if sp.id == 3 {
+				sp.gtid = "0-1-115,1-2-10"
+			}
+		}), "most-advanced", nil, nil, nil, "db3:3306"},
+		{"preferred slave behind in a domain", simChange(simTopology(), func(sp *simSpec) {
+			if sp.id == 2 {
+				sp.gtid = "0-1-110,1-2-10"
+			}
+			if sp.id == 3 {
+				sp.gtid = "0-1-115,1-2-10"
+			}
+		}), "preferred", map[string]int{"db2:3306": 10}, nil, nil, "db3:3306"},
+		{"failed slave", simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 3 }), "most-advanced", nil, nil, nil, "db2:3306"},
+		{"delayed slave", simChange(simTopology(), func(sp *simSpec) {
+			if sp.id == 3 {
+				sp.sqlDelay = 3600
+			}
+		}), "most-advanced", nil, nil, nil, "db2:3306"},
+		{"slave in maintenance", simTopology(), "most-advanced", nil, []string{"db3:3306"}, nil, "db2:3306"},
+		{"ignored slave", simTopology(), "most-advanced", nil, nil, []string{"db3:3306"}, "db2:3306"},
+		{"ignored slave by pattern", simTopology(), "most-advanced", nil, nil, []string{"db3*"}, "db2:3306"},
+		{"ignored slaves by pattern", simTopology(), "most-advanced", nil, nil, []string{"db[23]:*"}, ""},
+		{"ignored slave without port", simTopology(), "most-advanced", nil, nil, []string{"db3"}, "db2:3306"},
+		{"preferred slave behind", simTopology(), "preferred", map[string]int{"db2:3306": 10}, nil, nil, "db2:3306"},
+		{"preferred slave only breaks ties", simTopology(), "most-advanced", map[string]int{"db2:3306": 10}, nil, nil, "db3:3306"},
+		{"preferred slave wins a tie", simChange(simTopology(), func(sp *simSpec) { sp.gtid = "0-1-110" }), "most-advanced", map[string]int{"db2:3306": 10}, nil, nil, "db2:3306"},
+		{"preferred slave failed", simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 2 }), "preferred", map[string]int{"db2:3306": 10}, nil, nil, "db3:3306"},
+		{"no eligible slave", simChange(simTopology(), func(sp *simSpec) { sp.down = sp.master != 0 }), "most-advanced", nil, nil, nil, ""},
+	}
+	for _, tt := range tests {
+		simCluster(t, tt.specs)
+		current.master = current.findMaster(true)
+		*electionMode = tt.mode
+		if tt.weights != nil {
+			current.weights = tt.weights
+		}
+		current.stateData.Maintenance, current.ignoreList = tt.maintenance, tt.ignore
+		got := ""
+		if key := current.master.electCandidate(current.slaves); key >= 0 {
+			got = current.slaves[key].URL
+		}
+		if got != tt.want {
+			t.Errorf("%s: elected %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+/* State of the cluster expected after a check of the monitor */
+type simCheck struct {
+	masterDown bool // master down during the check
+	slaveDown  bool // db2 down during the check
+	failCount  int
+	master     string
+	slave      string
+}
+
+func TestFailureDetection(t *testing.T) {
+	tests := []struct {
+		name   string
+		checks []simCheck
+	}{
+		{"master answering", []simCheck{
+			{false, false, 0, STATE_MASTER, STATE_SLAVE},
+			{false, false, 0, STATE_MASTER, STATE_SLAVE},
+		}},
+		{"master failed after maxfail checks", []simCheck{
+			{true, false, 1, STATE_MASTER, STATE_SLAVE},
+			{true, false, 2, STATE_MASTER, STATE_SLAVE},
+			{true, false, 3, STATE_FAILED, STATE_SLAVE},
+			{true, false, 3, STATE_FAILED, STATE_SLAVE},
+		}},
+		{"only consecutive failed checks count", []simCheck{
+			{true, false, 1, STATE_MASTER, STATE_SLAVE},
+			{true, false, 2, STATE_MASTER, STATE_SLAVE},
+			{false, false, 0, STATE_MASTER, STATE_SLAVE},
+			{true, false, 1, STATE_MASTER, STATE_SLAVE},
+		}},
+		{"failed master stays failed", []simCheck{
+			{true, false, 1, STATE_MASTER, STATE_SLAVE},
+			{true, false, 2, STATE_MASTER, STATE_SLAVE},
+			{true, false, 3, STATE_FAILED, STATE_SLAVE},
+			{false, false, 3, STATE_FAILED, STATE_SLAVE},
+		}},
+		{"slave failed and back", []simCheck{
+			{false, true, 0, STATE_MASTER, STATE_FAILED},
+			{false, true, 0, STATE_MASTER, STATE_FAILED},
+			{false, false, 0, STATE_MASTER, STATE_SLAVE},
+		}},
+	}
+	for _, tt := range tests {
+		sims := simCluster(t, simTopology())
+		current.master = current.findMaster(true)
+		current.master.State = STATE_MASTER
+		for i, c := range tt.checks {
+			sims["db1:3306"].down, sims["db2:3306"].down = c.masterDown, c.slaveDown
+			current.refreshTopology(context.Background())
+			slave := simServerByURL("db2:3306")
+			if current.failCount != c.failCount || current.master.State != c.master || slave.State != c.slave {
+				t.Errorf("%s: check %d: failcount %d, master %s, slave %s, want failcount %d, master %s, slave %s", tt.name, i+1, current.failCount, current.master.State, slave.State, c.failCount, c.master, c.slave)
+			}
+		}
+	}
+}
+
+func TestFailoverElection(t *testing.T) {
+	defer func(f, m string) { *failover, *electionMode = f, m }(*failover, *electionMode)
+	*failover = "force"
+	tests := []struct {
+		mode     string
+		promoted string
+		repoint  string
+	}{
+		{"most-advanced", "db3:3306", "db2:3306"},
+		{"preferred", "db2:3306", "db3:3306"},
+	}
+	for _, tt := range tests {
+		sims := simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 1 }))
+		current.master = current.findMaster(false)
+		*electionMode = tt.mode
+		current.weights = map[string]int{"db2:3306": 10}
+		nmUrl, err := current.Failover(context.Background())
+		if err != nil {
+			t.Fatalf("%s: Failover() = %s", tt.mode, err)
+		}
+		if nmUrl != tt.promoted {
+			t.Errorf("%s: promoted %q, want %q", tt.mode, nmUrl, tt.promoted)
+		}
+		host, _, _ := net.SplitHostPort(tt.promoted)
+		if st := sims[tt.repoint].status; st == nil || st.Master_Host != host {
+			t.Errorf("%s: slave %s replicates from %v, want %s", tt.mode, tt.repoint, st, host)
+		}
+		if sims[tt.promoted].vars["READ_ONLY"] != "OFF" || sims[tt.promoted].status != nil {
+			t.Errorf("%s: new master %s is read only or still a slave", tt.mode, tt.promoted)
+		}
+	}
+}
+
+func TestPromotedMasterFailure(t *testing.T) {
+	defer func(f, s string) { *failover, *stateFile = f, s }(*failover, *stateFile)
+	*failover, *stateFile = "force", ""
+	sims := simCluster(t, simTopology())
+	current.master = current.findMaster(true)
+	current.master.State = STATE_MASTER
+	sims["db1:3306"].down = true
+	for i := 0; i < *maxFail; i++ {
+		current.refreshTopology(context.Background())
+	}
+	nmUrl, err := current.Failover(context.Background())
+	if err != nil || current.failCount != 0 {
+		t.Fatalf("Failover() = %q, %v, failcount %d, want it reset", nmUrl, err, current.failCount)
+	}
+	// The new master fails right away and is still counted down to a failure
+	sims[nmUrl].down = true
+	for i := 1; i <= *maxFail; i++ {
+		current.refreshTopology(context.Background())
+		if current.failCount != i {
+			t.Errorf("check %d: failcount %d, want %d", i, current.failCount, i)
+		}
+	}
+	if current.master.URL != nmUrl || current.master.State != STATE_FAILED {
+		t.Errorf("master %s %s, want %s failed", current.master.URL, current.master.State, nmUrl)
+	}
+}
+
+func TestReplErrors(t *testing.T) {
+	defer func(u string) { *webhookURL = u }(*webhookURL)
+	events := make(chan string, 8)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		events <- payload["text"].(string)
+	}))
+	defer srv.Close()
+	*webhookURL = srv.URL
+	tests := []struct {
+		name     string
+		io       string
+		sql      string
+		ioErrno  uint
+		sqlErrno uint
+		health   string
+		errors   string
+		alerts   int
+	}{
+		{"replicating", "Yes", "Yes", 0, 0, "Running OK", "", 0},
+		{"SQL thread stopped on error", "Yes", "No", 0, 1062, "NOT OK, SQL Err 1062", "SQL error 1062: Duplicate entry '4' for key 'PRIMARY'", 1},
+		{"IO thread stopped on error", "No", "Yes", 2003, 0, "NOT OK, IO Err 2003", "IO error 2003: error connecting to master", 0},
+		{"both threads stopped on error", "No", "No", 2003, 1062, "NOT OK, SQL Err 1062", "IO error 2003: error connecting to master | SQL error 1062: Duplicate entry '4' for key 'PRIMARY'", 1},
+		{"error of a running thread", "Yes", "Yes", 2003, 1062, "Running OK", "", 0},
+	}
+	for _, tt := range tests {
+		sims := simCluster(t, simTopology())
+		st := sims["db2:3306"].status
+		st.Slave_IO_Running, st.Slave_SQL_Running = tt.io, tt.sql
+		st.Last_IO_Errno, st.Last_SQL_Errno = tt.ioErrno, tt.sqlErrno
+		if tt.ioErrno != 0 {
+			st.Last_IO_Error = "error connecting to master"
+		}
+		if tt.sqlErrno != 0 {
+			st.Last_SQL_Error = "Duplicate entry '4' for key 'PRIMARY'"
+		}
+		current.master = current.findMaster(true)
+		sm := simServerByURL("db2:3306")
+		sm.refresh()
+		if got := sm.healthCheck(); got != tt.health {
+			t.Errorf("%s: healthCheck() = %q, want %q", tt.name, got, tt.health)
+		}
+		if got := sm.replError(); got != tt.errors {
+			t.Errorf("%s: replError() = %q, want %q", tt.name, got, tt.errors)
+		}
+		// The error is alerted once while the SQL thread stays stopped on it
+		sm.checkErrors()
+		sm.checkErrors()
+		alertFlush()
+		if len(events) != tt.alerts {
+			t.Errorf("%s: %d alerts, want %d", tt.name, len(events), tt.alerts)
+		}
+		for len(events) > 0 {
+			if e := <-events; strings.Contains(e, "Slave db2:3306 SQL thread stopped on error 1062: Duplicate entry") == false {
+				t.Errorf("%s: alert %q", tt.name, e)
+			}
+		}
+		w := httptest.NewRecorder()
+		current.apiServers(w, httptest.NewRequest("GET", "/api/servers", nil))
+		var servers []apiServer
+		json.NewDecoder(w.Body).Decode(&servers)
+		for _, s := range servers {
+			if s.URL == "db2:3306" && (s.IOError != st.Last_IO_Error || s.SQLError != st.Last_SQL_Error) {
+				t.Errorf("%s: API errors %q and %q, want %q and %q", tt.name, s.IOError, s.SQLError, st.Last_IO_Error, st.Last_SQL_Error)
+			}
+		}
+	}
+	// A new error is alerted again
+	simCluster(t, simTopology())
+	sm := simServerByURL("db2:3306")
+	sm.SQLThread, sm.SQLErrno = "No", 1062
+	sm.checkErrors()
+	sm.SQLErrno = 1032
+	sm.checkErrors()
+	alertFlush()
+	if len(events) != 2 {
+		t.Errorf("%d alerts for two errors, want 2", len(events))
+	}
+}
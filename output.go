@@ -0,0 +1,91 @@
+// output.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+)
+
+type serverReport struct {
+	URL           string
+	Name          string
+	State         string
+	Delay         *int64
+	UsingGtid     string
+	CurrentGtid   string
+	SlaveGtid     string
+	BinlogPos     string
+	IOThread      string
+	SQLThread     string
+	IOError       string    `json:",omitempty"`
+	SQLError      string    `json:",omitempty"`
+	Channels      []Channel `json:",omitempty"`
+	ReadOnly      string
+	SuperReadOnly string `json:",omitempty"`
+	ReadOnlyIssue string `json:",omitempty"`
+}
+
+/* Machine readable view of the cluster */
+type Report struct {
+	Time    time.Time
+	Master  string
+	Health  ClusterHealth
+	Servers []serverReport
+}
+
+func (c *Cluster) buildReport() Report {
+	r := Report{Time: time.Now(), Health: c.clusterHealth()}
+	if c.master != nil {
+		r.Master = c.master.URL
+	}
+	for _, s := range c.knownServers() {
+		sr := serverReport{URL: s.URL, Name: s.Name, State: s.State, UsingGtid: s.UsingGtid, CurrentGtid: s.CurrentGtid, SlaveGtid: s.SlaveGtid,
+			BinlogPos: s.BinlogPos, IOThread: s.IOThread, SQLThread: s.SQLThread, IOError: s.IOError, SQLError: s.SQLError, ReadOnly: s.ReadOnly,
+			SuperReadOnly: s.SuperReadOnly, ReadOnlyIssue: s.readonlyIssue(), Channels: s.sources()}
+		if s.Delay.Valid {
+			d := s.Delay.Int64
+			sr.Delay = &d
+		}
+		r.Servers = append(r.Servers, sr)
+	}
+	return r
+}
+
+/* Writes the report as a single JSON line to stdout */
+func (c *Cluster) writeReport() {
+	json.NewEncoder(os.Stdout).Encode(c.buildReport())
+}
+
+/* Monitor loop used instead of the console with JSON output. A report per cluster is written at each refresh, and logs go to stderr. */
+func monitorJSON() {
+	panicChan := newPanicChan()
+	if *watchdogCycles > 0 {
+		go shown.watchdog()
+	}
+	if leader != nil {
+		go leaderLoop()
+	}
+	ticker := time.NewTicker(monitorInterval)
+	for {
+		select {
+		case <-ticker.C:
+			clusterLock.Lock()
+			failing := monitorTick(func(c *Cluster) {
+				c.Refresh(context.Background())
+				c.writeReport()
+			})
+			if failing != nil {
+				failing.opTrigger = "automation"
+				failing.Failover(context.Background())
+			}
+			clusterLock.Unlock()
+			cycleDone()
+		case sig := <-panicChan:
+			clusterLock.Lock()
+			panicSignal(sig)
+			clusterLock.Unlock()
+		}
+	}
+}
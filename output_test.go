@@ -0,0 +1,61 @@
+// output_test.go
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+/* Returns what the function writes to stdout */
+func simStdout(t *testing.T, f func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(chan string)
+	go func() {
+		b, _ := ioutil.ReadAll(r)
+		out <- string(b)
+	}()
+	defer func(f *os.File) { os.Stdout = f }(os.Stdout)
+	os.Stdout = w
+	f()
+	w.Close()
+	return <-out
+}
+
+func TestWriteReport(t *testing.T) {
+	simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.stopped = sp.id == 3 }))
+	current.master = current.findMaster(true)
+	current.master.State = STATE_MASTER
+	out := simStdout(t, current.writeReport)
+	if strings.Count(out, "\n") != 1 {
+		t.Fatalf("report is not a single line:\n%s", out)
+	}
+	var rep struct {
+		Master  string
+		Health  ClusterHealth
+		Servers []map[string]interface{}
+	}
+	if err := json.Unmarshal([]byte(out), &rep); err != nil {
+		t.Fatalf("report is not JSON: %s\n%s", err, out)
+	}
+	if rep.Master != "db1:3306" || rep.Health.Score != 75 || len(rep.Servers) != 3 {
+		t.Fatalf("report of master %q, score %d, %d servers, want db1:3306, 75 and 3 servers", rep.Master, rep.Health.Score, len(rep.Servers))
+	}
+	want := []map[string]interface{}{
+		{"URL": "db1:3306", "State": STATE_MASTER, "CurrentGtid": "0-1-120", "Delay": nil},
+		{"URL": "db2:3306", "State": STATE_SLAVE, "CurrentGtid": "0-1-110", "Delay": 0.0, "SQLThread": "Yes"},
+		{"URL": "db3:3306", "State": STATE_SLAVE, "CurrentGtid": "0-1-115", "Delay": nil, "SQLThread": "No"},
+	}
+	for i, fields := range want {
+		for k, v := range fields {
+			if rep.Servers[i][k] != v {
+				t.Errorf("server %d %s = %v, want %v", i, k, rep.Servers[i][k], v)
+			}
+		}
+	}
+}
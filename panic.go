@@ -0,0 +1,100 @@
+// panic.go
+package main
+
+import (
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+/* Automatic actions are suspended until this time */
+var panicUntil time.Time
+
+/* Suspends all automatic actions for the given duration on behalf of who */
+func panicFreeze(d time.Duration, who string) {
+	panicUntil = time.Now().Add(d)
+	alertLog("PANIC: All automatic actions suspended until %s by %s", panicUntil.Format("15:04:05"), who)
+	audit("Automatic actions suspended until %s by %s", panicUntil.Format(time.RFC3339), who)
+}
+
+/* Lifts the suspension of automatic actions on behalf of who */
+func panicRelease(who string) {
+	if time.Now().Before(panicUntil) {
+		alertLog("PANIC: Automatic actions resumed by %s", who)
+		audit("Automatic actions resumed by %s", who)
+	}
+	panicUntil = time.Time{}
+}
+
+/* Returns true if automatic actions are suspended, by the panic button or because another instance is active */
+func automationFrozen() bool {
+	return time.Now().Before(panicUntil) || isStandby()
+}
+
+/* Returns the message shown when the master failed while automatic actions are suspended */
+func suspendedMessage() string {
+	if isStandby() {
+		return "INFO : Master failed, failover is left to the active instance " + leaderHolder()
+	}
+	return "PANIC: Master failed but automatic failover is suspended"
+}
+
+/* Returns the time left before automatic actions resume, rounded to the second */
+func panicRemaining() time.Duration {
+	return panicUntil.Sub(time.Now()).Round(time.Second)
+}
+
+/* Presses the panic button on SIGUSR1 and releases it on SIGUSR2 */
+func panicSignal(sig os.Signal) {
+	if sig == syscall.SIGUSR1 {
+		panicFreeze(time.Duration(*panicDuration)*time.Second, "signal SIGUSR1")
+	} else {
+		panicRelease("signal SIGUSR2")
+	}
+}
+
+/* SIGUSR1 triggers the panic button and SIGUSR2 releases it, so that it can be pressed from outside the console */
+func newPanicChan() chan os.Signal {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR1, syscall.SIGUSR2)
+	return c
+}
+
+/* State of the panic button served by the HTTP API */
+type panicState struct {
+	Suspended bool
+	Until     time.Time
+}
+
+/* Serves /api/panic: GET returns whether automatic actions are suspended on all clusters, POST suspends them for the duration parameter in seconds, -panic-duration by default, and DELETE resumes them */
+func apiPanic(w http.ResponseWriter, r *http.Request) {
+	clusterLock.Lock()
+	defer clusterLock.Unlock()
+	switch r.Method {
+	case "GET":
+	case "POST":
+		d := *panicDuration
+		if v := r.URL.Query().Get("duration"); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n <= 0 {
+				http.Error(w, "Invalid duration "+v+", expected a number of seconds", http.StatusBadRequest)
+				return
+			}
+			d = n
+		}
+		panicFreeze(time.Duration(d)*time.Second, "API client "+requestUser(r))
+	case "DELETE":
+		panicRelease("API client " + requestUser(r))
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	st := panicState{Suspended: time.Now().Before(panicUntil)}
+	if st.Suspended {
+		st.Until = panicUntil
+	}
+	apiWrite(w, st)
+}
@@ -0,0 +1,170 @@
+// panic_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPanicFreeze(t *testing.T) {
+	defer func(f bool) { *forceReadonly = f }(*forceReadonly)
+	defer panicRelease("test")
+	*forceReadonly = true
+	sims := simCluster(t, simTopology())
+	current.master = current.findMaster(true)
+	slave := simServerByURL("db2:3306")
+	slave.ReadOnly = "OFF"
+	panicFreeze(time.Minute, "test")
+	if automationFrozen() == false {
+		t.Fatal("automation not suspended by the panic button")
+	}
+	if d := panicRemaining(); d <= 0 || d > time.Minute {
+		t.Errorf("panicRemaining() = %s, want up to a minute", d)
+	}
+	current.readonlyCheck()
+	if sims["db2:3306"].ran("SET GLOBAL read_only=1") {
+		t.Error("read_only corrected while automation is suspended")
+	}
+	panicRelease("test")
+	if automationFrozen() {
+		t.Fatal("automation still suspended after the release")
+	}
+	current.readonlyCheck()
+	if sims["db2:3306"].ran("SET GLOBAL read_only=1") == false {
+		t.Error("read_only not corrected once automation resumed")
+	}
+}
+
+func TestPanicExpires(t *testing.T) {
+	defer panicRelease("test")
+	simCluster(t, simTopology())
+	panicFreeze(-time.Second, "test")
+	if automationFrozen() {
+		t.Error("automation suspended after the panic duration")
+	}
+}
+
+func TestMonitorTickSuspended(t *testing.T) {
+	defer func(l []*Cluster, i bool) { clusters, *interactive = l, i }(clusters, *interactive)
+	defer panicRelease("test")
+	*interactive = false
+	simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 1 }))
+	current.master = current.findMaster(false)
+	current.master.State = STATE_FAILED
+	clusters = []*Cluster{current}
+	refreshed := 0
+	refresh := func(c *Cluster) { refreshed++ }
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(ioutil.Discard)
+	// The failure is reported once while automation is suspended
+	panicFreeze(time.Minute, "test")
+	for i := 0; i < 2; i++ {
+		if c := monitorTick(refresh); c != nil {
+			t.Errorf("tick %d: monitorTick() = %s while suspended, want no failover", i+1, c.Name)
+		}
+	}
+	if n := strings.Count(out.String(), "automatic failover is suspended"); n != 1 || current.suspended == false {
+		t.Errorf("suspension reported %d times, suspended %v, want once:\n%s", n, current.suspended, out.String())
+	}
+	// Once released the cluster is failed over and the next suspension is reported again
+	panicRelease("test")
+	if c := monitorTick(refresh); c != current || current.suspended {
+		t.Errorf("monitorTick() = %v, suspended %v once released, want the cluster failed over", c, current.suspended)
+	}
+	if refreshed != 3 {
+		t.Errorf("cluster refreshed %d times in 3 ticks", refreshed)
+	}
+}
+
+func TestPanicAPI(t *testing.T) {
+	defer func(a string, d int64) { *auditFile, *panicDuration = a, d }(*auditFile, *panicDuration)
+	defer panicRelease("test")
+	*auditFile, *panicDuration = filepath.Join(t.TempDir(), "audit.log"), 60
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(ioutil.Discard)
+	tests := []struct {
+		method    string
+		query     string
+		code      int
+		suspended bool
+		remaining time.Duration
+	}{
+		{"GET", "", 200, false, 0},
+		{"POST", "", 200, true, time.Minute},
+		{"DELETE", "", 200, false, 0},
+		{"POST", "?duration=600", 200, true, 10 * time.Minute},
+		{"POST", "?duration=soon", 400, true, 10 * time.Minute},
+		{"POST", "?duration=-5", 400, true, 10 * time.Minute},
+		{"PUT", "", 405, true, 10 * time.Minute},
+		{"GET", "", 200, true, 10 * time.Minute},
+		{"DELETE", "", 200, false, 0},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		apiPanic(w, httptest.NewRequest(tt.method, "/api/panic"+tt.query, nil))
+		if w.Code != tt.code {
+			t.Fatalf("%s /api/panic%s = %d, want %d: %s", tt.method, tt.query, w.Code, tt.code, w.Body.String())
+		}
+		if d := panicRemaining(); automationFrozen() != tt.suspended || (tt.suspended && (d > tt.remaining || d < tt.remaining-time.Minute/2)) {
+			t.Errorf("after %s /api/panic%s: suspended %v for %s, want %v for %s", tt.method, tt.query, automationFrozen(), panicRemaining(), tt.suspended, tt.remaining)
+		}
+		if w.Code != 200 {
+			continue
+		}
+		var st panicState
+		if err := json.Unmarshal(w.Body.Bytes(), &st); err != nil || st.Suspended != tt.suspended || st.Until.IsZero() == st.Suspended {
+			t.Errorf("%s /api/panic%s answered %s", tt.method, tt.query, w.Body.String())
+		}
+	}
+	// The presses and releases are logged and audited without the console
+	b, _ := ioutil.ReadFile(*auditFile)
+	for _, want := range []string{"PANIC: All automatic actions suspended until", "PANIC: Automatic actions resumed by API client"} {
+		if n := strings.Count(out.String(), want); n != 2 {
+			t.Errorf("%q logged %d times, want 2:\n%s", want, n, out.String())
+		}
+	}
+	if n := strings.Count(string(b), "Automatic actions suspended until"); n != 2 || strings.Count(string(b), "Automatic actions resumed by API client") != 2 {
+		t.Errorf("audit file does not record the 2 presses and releases:\n%s", b)
+	}
+}
+
+func TestClientPanic(t *testing.T) {
+	defer func(l []*Cluster) { clusters = l }(clusters)
+	defer func() { apiTokens, apiUsers = map[string]apiClient{}, map[string]apiClient{} }()
+	defer panicRelease("test")
+	simCluster(t, simTopology())
+	apiUsers = map[string]apiClient{"ro:pass": {Name: "ro", Role: ROLE_VIEWER}, "ops:pass": {Name: "ops", Role: ROLE_OPERATOR}}
+	addr := simDaemon(t)
+	var err error
+	if err = runClient([]string{"panic", "-daemon", addr, "-api-user", "ro:pass", "on"}); err == nil || err.Error() != "403 Forbidden: The operator role is required" {
+		t.Errorf("panic on by a viewer = %v, want 403", err)
+	}
+	if automationFrozen() {
+		t.Fatal("automation suspended by a viewer")
+	}
+	out := simStdout(t, func() {
+		err = runClient([]string{"panic", "-daemon", addr, "-api-user", "ops:pass", "-duration", "120", "on"})
+	})
+	if err != nil || strings.HasPrefix(out, "Automatic actions suspended until") == false {
+		t.Errorf("panic on = %v:\n%s", err, out)
+	}
+	if d := panicRemaining(); d <= time.Minute || d > 2*time.Minute {
+		t.Errorf("automation suspended for %s, want 2 minutes", d)
+	}
+	out = simStdout(t, func() { err = runClient([]string{"panic", "-daemon", addr, "-api-user", "ops:pass", "-json", "off"}) })
+	if err != nil || strings.Contains(out, `"Suspended":false`) == false || automationFrozen() {
+		t.Errorf("panic off = %v, suspended %v:\n%s", err, automationFrozen(), out)
+	}
+	if err = runClient([]string{"panic", "-daemon", addr, "maybe"}); err == nil || err.Error() != "Usage: panic [options] on|off" {
+		t.Errorf("panic maybe = %v, want the usage", err)
+	}
+}
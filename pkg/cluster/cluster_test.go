@@ -0,0 +1,231 @@
+// cluster_test.go
+package cluster
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGTIDCount(t *testing.T) {
+	tests := []struct {
+		flavor string
+		pos    string
+		want   uint64
+	}{
+		{FlavorMariaDB, "0-1-120", 120},
+		{FlavorMariaDB, "0-1-120,1-2-30", 150},
+		{FlavorMariaDB, "", 0},
+		{FlavorMySQL, "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-100:105", 101},
+		{FlavorMySQL, "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5,4e11fa47-71ca-11e1-9e33-c80aa9429562:1-5", 10},
+		{FlavorMySQL, "", 0},
+	}
+	for _, tt := range tests {
+		if got := GTIDCount(tt.flavor, tt.pos); got != tt.want {
+			t.Errorf("GTIDCount(%s, %q) = %d, want %d", tt.flavor, tt.pos, got, tt.want)
+		}
+	}
+}
+
+func TestGTIDUnion(t *testing.T) {
+	tests := []struct {
+		positions []string
+		want      string
+	}{
+		{[]string{"0-1-120"}, "0-1-120"},
+		{[]string{"0-1-120", "0-2-110"}, "0-1-120"},
+		{[]string{"0-1-110", "0-2-120"}, "0-2-120"},
+		{[]string{"1-2-30,0-1-120", "0-1-100,2-3-5"}, "0-1-120,1-2-30,2-3-5"},
+		{[]string{"", "0-1-120"}, "0-1-120"},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		if got := GTIDUnion(tt.positions...); got != tt.want {
+			t.Errorf("GTIDUnion(%q) = %q, want %q", tt.positions, got, tt.want)
+		}
+	}
+}
+
+func TestGTIDCovers(t *testing.T) {
+	tests := []struct {
+		pos   string
+		other string
+		want  bool
+	}{
+		{"0-1-120", "0-1-110", true},
+		{"0-1-120", "0-2-120", true},
+		{"0-1-110", "0-1-120", false},
+		{"0-1-120", "0-1-110,1-2-10", false},
+		{"0-1-110,1-2-10", "0-1-115", false},
+		{"0-1-115,1-2-10", "0-1-110,1-2-10", true},
+		{"0-1-120", "", true},
+	}
+	for _, tt := range tests {
+		if got := GTIDCovers(tt.pos, tt.other); got != tt.want {
+			t.Errorf("GTIDCovers(%q, %q) = %v, want %v", tt.pos, tt.other, got, tt.want)
+		}
+	}
+}
+
+func TestElect(t *testing.T) {
+	tests := []struct {
+		name       string
+		candidates []Candidate
+		mode       string
+		want       int
+	}{
+		{"no candidate", nil, ModePreferred, -1},
+		{"most advanced", []Candidate{{Seq: 10, Local: true}, {Seq: 12, Local: true}}, ModeMostAdvanced, 1},
+		{"complete tie", []Candidate{{Seq: 10, Local: true}, {Seq: 10, Local: true}}, ModeMostAdvanced, 0},
+		{"weight breaks a tie", []Candidate{{Seq: 10, Local: true}, {Seq: 10, Weight: 5, Local: true}}, ModeMostAdvanced, 1},
+		{"weight only breaks ties", []Candidate{{Seq: 12, Local: true}, {Seq: 10, Weight: 5, Local: true}}, ModeMostAdvanced, 0},
+		{"preferred behind", []Candidate{{Seq: 12, Local: true}, {Seq: 10, Weight: 5, Local: true}}, ModePreferred, 1},
+		{"local first", []Candidate{{Seq: 12, Weight: 5}, {Seq: 10, Local: true}}, ModePreferred, 1},
+		{"no local candidate", []Candidate{{Seq: 10}, {Seq: 12}}, ModeMostAdvanced, 1},
+	}
+	for _, tt := range tests {
+		if got := Elect(tt.candidates, tt.mode); got != tt.want {
+			t.Errorf("%s: Elect = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFindMaster(t *testing.T) {
+	servers := []Server{
+		{URL: "db1:3306", Host: "db1", IP: "10.0.0.1", ServerID: 1},
+		{URL: "db2:3306", Host: "db2", IP: "10.0.0.2", ServerID: 2, Slave: true, MasterServerID: 1, MasterHost: "db1"},
+	}
+	failed := []Server{servers[0], servers[1]}
+	failed[0].Failed = true
+	tests := []struct {
+		name    string
+		servers []Server
+		slave   Server
+		running bool
+		want    int
+	}{
+		{"running master", servers, servers[1], true, 0},
+		{"running master in failover mode", servers, servers[1], false, -1},
+		{"failed master by host", failed, failed[1], false, 0},
+		{"failed master by IP", failed, Server{MasterHost: "10.0.0.1"}, false, 0},
+		{"failed master in monitor mode", failed, failed[1], true, -1},
+		{"unknown master", servers, Server{MasterServerID: 9}, true, -1},
+	}
+	for _, tt := range tests {
+		if got := FindMaster(tt.servers, tt.slave, tt.running); got != tt.want {
+			t.Errorf("%s: FindMaster = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+/* Node recording the statements run on it, failing those starting with fail and answering the GTID queries from pos */
+type fakeNode struct {
+	name  string
+	pos   string
+	fail  string
+	execs []string
+}
+
+func (n *fakeNode) Name() string   { return n.name + ":3306" }
+func (n *fakeNode) Host() string   { return n.name }
+func (n *fakeNode) Port() string   { return "3306" }
+func (n *fakeNode) Flavor() string { return FlavorMariaDB }
+
+func (n *fakeNode) Exec(ctx context.Context, stmt string) error {
+	n.execs = append(n.execs, stmt)
+	if n.fail != "" && strings.HasPrefix(stmt, n.fail) {
+		return errors.New("simulated failure")
+	}
+	return nil
+}
+
+func (n *fakeNode) Query(ctx context.Context, query string) ([]map[string]string, error) {
+	if strings.Contains(query, "MASTER_GTID_WAIT") {
+		return []map[string]string{{"res": "0"}}, nil
+	}
+	return []map[string]string{{"pos": n.pos}}, nil
+}
+
+func (n *fakeNode) ran(prefix string) bool {
+	for _, s := range n.execs {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRepoint(t *testing.T) {
+	r := Replication{User: "repl", Password: "secret"}
+	tests := []struct {
+		name    string
+		fail    string
+		src     Source
+		want    []string
+		wantErr string
+	}{
+		{"gtid", "", Source{GTIDPos: "0-1-10", MasterOpt: GTIDMasterOpt(FlavorMariaDB, "slave_pos"), ReadOnly: true},
+			[]string{"STOP SLAVE", "SET GLOBAL gtid_slave_pos='0-1-10'", "CHANGE MASTER TO master_host='db2', master_port=3306, master_user='repl', master_password='secret', master_use_gtid=slave_pos", "START SLAVE", "SET GLOBAL read_only=1"}, ""},
+		{"stop slave failure tolerated", "STOP SLAVE", Source{},
+			[]string{"STOP SLAVE", "CHANGE MASTER TO master_host='db2', master_port=3306, master_user='repl', master_password='secret'", "START SLAVE"}, ""},
+		{"change master failure", "CHANGE MASTER", Source{ReadOnly: true},
+			[]string{"STOP SLAVE", "CHANGE MASTER TO master_host='db2', master_port=3306, master_user='repl', master_password='secret'"}, "CHANGE MASTER failed on db3:3306"},
+		{"start slave failure", "START SLAVE", Source{ReadOnly: true},
+			[]string{"STOP SLAVE", "CHANGE MASTER TO master_host='db2', master_port=3306, master_user='repl', master_password='secret'", "START SLAVE"}, "START SLAVE failed on db3:3306"},
+	}
+	for _, tt := range tests {
+		n := &fakeNode{name: "db3", fail: tt.fail}
+		err := Repoint(context.Background(), n, &fakeNode{name: "db2"}, r, tt.src)
+		if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || strings.HasPrefix(err.Error(), tt.wantErr) == false) {
+			t.Errorf("%s: Repoint() = %v, want %q", tt.name, err, tt.wantErr)
+		}
+		if err != nil && strings.Contains(err.Error(), "secret") {
+			t.Errorf("%s: error %q holds the password", tt.name, err)
+		}
+		if strings.Join(n.execs, ";") != strings.Join(tt.want, ";") {
+			t.Errorf("%s: ran %q, want %q", tt.name, n.execs, tt.want)
+		}
+	}
+}
+
+func TestFailover(t *testing.T) {
+	newMaster, failing, other := &fakeNode{name: "db2"}, &fakeNode{name: "db3", fail: "START SLAVE"}, &fakeNode{name: "db4"}
+	err := Failover(context.Background(), newMaster, []Node{newMaster, failing, other}, Replication{User: "repl"})
+	if err == nil || strings.Contains(err.Error(), "db3:3306") == false {
+		t.Errorf("Failover() = %v, want the failure of db3:3306", err)
+	}
+	if newMaster.ran("RESET SLAVE ALL") == false || newMaster.ran("SET GLOBAL read_only=0") == false || newMaster.ran("CHANGE MASTER") {
+		t.Errorf("new master ran %q", newMaster.execs)
+	}
+	if other.ran("CHANGE MASTER TO master_host='db2'") == false || other.ran("SET GLOBAL read_only=1") == false {
+		t.Errorf("slave after the failed one ran %q", other.execs)
+	}
+	newMaster = &fakeNode{name: "db2", fail: "SET GLOBAL read_only=0"}
+	other = &fakeNode{name: "db4"}
+	err = Failover(context.Background(), newMaster, []Node{other}, Replication{})
+	if err == nil || len(other.execs) > 0 {
+		t.Errorf("Failover() with a failed promotion = %v, slave ran %q", err, other.execs)
+	}
+}
+
+func TestSwitchover(t *testing.T) {
+	oldMaster, newMaster, slave := &fakeNode{name: "db1", pos: "0-1-10"}, &fakeNode{name: "db2"}, &fakeNode{name: "db3"}
+	err := Switchover(context.Background(), oldMaster, newMaster, []Node{newMaster, slave}, Replication{}, time.Second)
+	if err != nil {
+		t.Fatalf("Switchover() = %s", err)
+	}
+	if oldMaster.ran("SET GLOBAL gtid_slave_pos='0-1-10'") == false || oldMaster.ran("CHANGE MASTER TO master_host='db2'") == false || oldMaster.ran("SET GLOBAL read_only=0") {
+		t.Errorf("old master ran %q", oldMaster.execs)
+	}
+	if newMaster.ran("SET GLOBAL read_only=0") == false || slave.ran("CHANGE MASTER TO master_host='db2'") == false {
+		t.Errorf("new master ran %q, slave ran %q", newMaster.execs, slave.execs)
+	}
+	// A failed promotion makes the old master writable again and leaves the slaves alone
+	oldMaster, newMaster, slave = &fakeNode{name: "db1", pos: "0-1-10"}, &fakeNode{name: "db2", fail: "SET GLOBAL read_only=0"}, &fakeNode{name: "db3"}
+	err = Switchover(context.Background(), oldMaster, newMaster, []Node{slave}, Replication{}, time.Second)
+	if err == nil || oldMaster.execs[len(oldMaster.execs)-1] != "SET GLOBAL read_only=0" || len(slave.execs) > 0 {
+		t.Errorf("Switchover() = %v, old master ran %q, slave ran %q", err, oldMaster.execs, slave.execs)
+	}
+}
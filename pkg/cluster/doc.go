@@ -0,0 +1,10 @@
+// doc.go
+
+/*
+Package cluster holds the replication logic of replication-manager that does not depend on the state of a running monitor, so that other Go programs, such as operators or custom tooling, can take the same decisions and run the same steps as the binary without shelling out to it.
+
+It finds the master of a topology from the replication status of its servers, compares GTID positions of MariaDB and MySQL servers, and ranks failover and switchover candidates.
+
+The servers are reached through the Node interface, which the caller implements over its own connections. Promote and Repoint are the steps changing the servers in a failover or a switchover, the replication-manager binary runs them between its own checks, hooks and checkpoints. Failover and Switchover compose them into complete GTID operations for callers that need nothing more: they do not elect the candidate, freeze the applications or move virtual IPs, which stay the job of the caller.
+*/
+package cluster
@@ -0,0 +1,36 @@
+// election.go
+package cluster
+
+/* Election modes */
+const (
+	ModePreferred    string = "preferred"     // the preferred master wins as soon as it is eligible
+	ModeMostAdvanced string = "most-advanced" // the most advanced candidate wins, preference only breaks ties
+)
+
+/* Candidate is a slave eligible for promotion, the checks excluding failed, delayed or lagging slaves being done by the caller */
+type Candidate struct {
+	URL    string
+	Seq    uint64 // progress of the slave, e.g. from GTIDCount
+	Weight int    // preference of the slave, 0 when not preferred
+	Local  bool   // in the datacenter elected masters should come from
+}
+
+/* Elect returns the index of the candidate to promote, or -1 when there is none. Local candidates come first. Then in preferred mode the weight comes first, otherwise it only breaks ties between candidates at the same position. The first candidate wins a complete tie. */
+func Elect(candidates []Candidate, mode string) int {
+	hikey := -1
+	for k, c := range candidates {
+		if hikey == -1 {
+			hikey = k
+			continue
+		}
+		hi := candidates[hikey]
+		better := c.Seq > hi.Seq || (c.Seq == hi.Seq && c.Weight > hi.Weight)
+		if mode == ModePreferred {
+			better = c.Weight > hi.Weight || (c.Weight == hi.Weight && c.Seq > hi.Seq)
+		}
+		if (c.Local && hi.Local == false) || (c.Local == hi.Local && better) {
+			hikey = k
+		}
+	}
+	return hikey
+}
@@ -0,0 +1,119 @@
+// gtid.go
+package cluster
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/* Flavors of servers, which implement GTID differently */
+const (
+	FlavorMariaDB string = "MariaDB"
+	FlavorMySQL   string = "MySQL"
+)
+
+/* GTIDCount returns a number that grows with a GTID position: the sum of the sequence numbers of each domain of a MariaDB position, the number of transactions of a MySQL set. It is meant to compare the positions of the servers of one cluster. */
+func GTIDCount(flavor string, pos string) uint64 {
+	if flavor == FlavorMySQL {
+		return GTIDSetCount(pos)
+	}
+	var seq uint64
+	for _, gtid := range strings.Split(pos, ",") {
+		e := strings.Split(strings.TrimSpace(gtid), "-")
+		if len(e) == 3 {
+			n, _ := strconv.ParseUint(e[2], 10, 64)
+			seq += n
+		}
+	}
+	return seq
+}
+
+/* GTIDSetCount counts the transactions of a MySQL GTID set, e.g. uuid1:1-100:105,uuid2:1-5 */
+func GTIDSetCount(set string) uint64 {
+	var n uint64
+	for _, uuidSet := range strings.Split(set, ",") {
+		items := strings.Split(strings.TrimSpace(uuidSet), ":")
+		for _, interval := range items[1:] {
+			bounds := strings.Split(interval, "-")
+			lo, _ := strconv.ParseUint(bounds[0], 10, 64)
+			hi := lo
+			if len(bounds) == 2 {
+				hi, _ = strconv.ParseUint(bounds[1], 10, 64)
+			}
+			if hi >= lo {
+				n += hi - lo + 1
+			}
+		}
+	}
+	return n
+}
+
+/* GTIDDomains returns the domains of a MariaDB GTID position, in increasing order */
+func GTIDDomains(pos string) []uint32 {
+	return domains(gtidMap(pos))
+}
+
+/* GTIDUnion returns the MariaDB GTID position holding, for each domain of the positions, the GTID of the highest sequence number. Waiting for it with MASTER_GTID_WAIT waits for every domain. */
+func GTIDUnion(positions ...string) string {
+	union := make(map[uint32]string)
+	for _, pos := range positions {
+		for d, gtid := range gtidMap(pos) {
+			if cur, ok := union[d]; ok == false || gtidSeq(gtid) > gtidSeq(cur) {
+				union[d] = gtid
+			}
+		}
+	}
+	var l []string
+	for _, d := range domains(union) {
+		l = append(l, union[d])
+	}
+	return strings.Join(l, ",")
+}
+
+/* Returns the domains of GTIDs by domain, in increasing order */
+func domains(m map[uint32]string) []uint32 {
+	var l []uint32
+	for d := range m {
+		l = append(l, d)
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+	return l
+}
+
+/* GTIDCovers tells whether the MariaDB position pos is at or ahead of other in every domain of other. A server whose position does not cover the position of another misses transactions of that server. */
+func GTIDCovers(pos string, other string) bool {
+	m := gtidMap(pos)
+	for d, gtid := range gtidMap(other) {
+		cur, ok := m[d]
+		if ok == false || gtidSeq(cur) < gtidSeq(gtid) {
+			return false
+		}
+	}
+	return true
+}
+
+/* Returns the GTIDs of a MariaDB position by domain */
+func gtidMap(pos string) map[uint32]string {
+	m := make(map[uint32]string)
+	for _, gtid := range strings.Split(pos, ",") {
+		gtid = strings.TrimSpace(gtid)
+		e := strings.Split(gtid, "-")
+		if len(e) != 3 {
+			continue
+		}
+		d, err := strconv.ParseUint(e[0], 10, 32)
+		if err != nil {
+			continue
+		}
+		m[uint32(d)] = gtid
+	}
+	return m
+}
+
+/* Returns the sequence number of a single MariaDB GTID */
+func gtidSeq(gtid string) uint64 {
+	e := strings.Split(gtid, "-")
+	n, _ := strconv.ParseUint(e[len(e)-1], 10, 64)
+	return n
+}
@@ -0,0 +1,207 @@
+// operation.go
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+/* Node is a database server changed by the steps of a failover or switchover. Exec runs a state changing statement and Query returns rows as column name to value maps. The replication-manager binary implements it over its monitor connections, with its dry-run mode and step timeouts. */
+type Node interface {
+	Name() string // name of the server in logs and errors
+	Host() string // address the other servers replicate from
+	Port() string
+	Flavor() string // FlavorMariaDB or FlavorMySQL
+	Exec(ctx context.Context, stmt string) error
+	Query(ctx context.Context, query string) ([]map[string]string, error)
+}
+
+/* Replication account the repointed servers connect with, and the logger of the step failures that do not fail the operation, which may be nil */
+type Replication struct {
+	User     string
+	Password string
+	Logf     func(format string, args ...interface{})
+}
+
+/* Source is how a server repointed by Repoint resumes replicating from the master */
+type Source struct {
+	GTIDPos   string // gtid_slave_pos set on a MariaDB server before the change, kept if empty
+	MasterOpt string // options appended to CHANGE MASTER, e.g. binlog coordinates or GTIDMasterOpt
+	ReadOnly  bool   // set read_only once the server replicates
+}
+
+/* StepError is a statement of a step that failed on a server */
+type StepError struct {
+	Node string
+	Stmt string
+	Err  error
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("%s failed on %s: %s", e.Stmt, e.Node, e.Err)
+}
+
+func (r Replication) logf(format string, args ...interface{}) {
+	if r.Logf != nil {
+		r.Logf(format, args...)
+	}
+}
+
+/* ChangeMaster returns the CHANGE MASTER statement pointing a server at the master, without options */
+func (r Replication) ChangeMaster(master Node) string {
+	return "CHANGE MASTER TO master_host='" + master.Host() + "', master_port=" + master.Port() + ", master_user='" + r.User + "', master_password='" + r.Password + "'"
+}
+
+/* GTIDMasterOpt returns the CHANGE MASTER option making a server of the flavor replicate with GTID, from the MariaDB position pos, either current_pos or slave_pos */
+func GTIDMasterOpt(flavor string, pos string) string {
+	if flavor == FlavorMySQL {
+		return ", master_auto_position=1"
+	}
+	return ", master_use_gtid=" + pos
+}
+
+/* Promote makes a slave, whose replication is stopped, the master: its replication configuration is removed and it accepts writes. A failed reset is only logged. */
+func Promote(ctx context.Context, n Node, r Replication) error {
+	err := n.Exec(ctx, "RESET SLAVE ALL")
+	if err != nil {
+		r.logf("WARN : Reset slave failed on new master %s: %s", n.Name(), err)
+	}
+	err = n.Exec(ctx, "SET GLOBAL read_only=0")
+	if err != nil {
+		return &StepError{Node: n.Name(), Stmt: "SET GLOBAL read_only=0", Err: err}
+	}
+	return nil
+}
+
+/* Repoint makes a server replicate from the master: replication is stopped, pointed at the master from the source and started again. It stops at the first failed CHANGE MASTER or START SLAVE. Failures to stop replication, to set the GTID position or to set read_only are only logged. */
+func Repoint(ctx context.Context, n Node, master Node, r Replication, src Source) error {
+	err := n.Exec(ctx, "STOP SLAVE")
+	if err != nil {
+		r.logf("WARN : Could not stop slave on %s: %s", n.Name(), err)
+	}
+	if src.GTIDPos != "" && n.Flavor() == FlavorMariaDB {
+		err = n.Exec(ctx, "SET GLOBAL gtid_slave_pos='"+src.GTIDPos+"'")
+		if err != nil {
+			r.logf("WARN : Could not set gtid_slave_pos on %s: %s", n.Name(), err)
+		}
+	}
+	// The statement holds the password, the error only names it
+	err = n.Exec(ctx, r.ChangeMaster(master)+src.MasterOpt)
+	if err != nil {
+		return &StepError{Node: n.Name(), Stmt: "CHANGE MASTER", Err: err}
+	}
+	err = n.Exec(ctx, "START SLAVE")
+	if err != nil {
+		return &StepError{Node: n.Name(), Stmt: "START SLAVE", Err: err}
+	}
+	if src.ReadOnly {
+		err = n.Exec(ctx, "SET GLOBAL read_only=1")
+		if err != nil {
+			r.logf("ERROR: Could not set %s read-only: %s", n.Name(), err)
+		}
+	}
+	return nil
+}
+
+/* GTIDPosition returns the GTID position of the transactions written to the binary log of the server */
+func GTIDPosition(ctx context.Context, n Node) (string, error) {
+	query := "SELECT @@GLOBAL.gtid_binlog_pos AS pos"
+	if n.Flavor() == FlavorMySQL {
+		query = "SELECT @@GLOBAL.gtid_executed AS pos"
+	}
+	rows, err := n.Query(ctx, query)
+	if err != nil {
+		return "", err
+	}
+	if len(rows) == 0 {
+		return "", errors.New("no GTID position on " + n.Name())
+	}
+	return strings.Replace(rows[0]["pos"], "\n", "", -1), nil
+}
+
+/* WaitGTID waits until the server applied the GTID position, at most for the timeout */
+func WaitGTID(ctx context.Context, n Node, pos string, timeout time.Duration) error {
+	query, done := fmt.Sprintf("SELECT MASTER_GTID_WAIT('%s', %d) AS res", pos, int64(timeout.Seconds())), "0"
+	if n.Flavor() == FlavorMySQL {
+		query = fmt.Sprintf("SELECT WAIT_FOR_EXECUTED_GTID_SET('%s', %d) AS res", pos, int64(timeout.Seconds()))
+	}
+	rows, err := n.Query(ctx, query)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 || rows[0]["res"] != done {
+		return errors.New(fmt.Sprintf("%s did not reach position %s within %s", n.Name(), pos, timeout))
+	}
+	return nil
+}
+
+/* Failover promotes the new master and repoints the other slaves to it with GTID. The caller elected the new master, which applied the transactions it received, and stopped its replication. Every slave is handled, the failures of those that could not be repointed being returned together. */
+func Failover(ctx context.Context, newMaster Node, slaves []Node, r Replication) error {
+	err := Promote(ctx, newMaster, r)
+	if err != nil {
+		return err
+	}
+	var failed []string
+	for _, sl := range slaves {
+		if sl.Name() == newMaster.Name() {
+			continue
+		}
+		err = Repoint(ctx, sl, newMaster, r, Source{ReadOnly: true})
+		if err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+/* Switchover makes a slave the master in place of the running master, with GTID. The old master rejects writes with read_only, the new master applies its position within the timeout, then it is promoted and the old master and the other slaves replicate from it. Until the promotion a failure makes the old master writable again; after it, the failures of the servers that could not be repointed are returned together. */
+func Switchover(ctx context.Context, oldMaster Node, newMaster Node, slaves []Node, r Replication, timeout time.Duration) error {
+	err := oldMaster.Exec(ctx, "SET GLOBAL read_only=1")
+	if err != nil {
+		return &StepError{Node: oldMaster.Name(), Stmt: "SET GLOBAL read_only=1", Err: err}
+	}
+	pos, err := GTIDPosition(ctx, oldMaster)
+	if err == nil {
+		err = WaitGTID(ctx, newMaster, pos, timeout)
+	}
+	if err == nil {
+		err = newMaster.Exec(ctx, "STOP SLAVE")
+	}
+	if err == nil {
+		err = Promote(ctx, newMaster, r)
+	}
+	if err != nil {
+		if rerr := oldMaster.Exec(ctx, "SET GLOBAL read_only=0"); rerr != nil {
+			r.logf("ERROR: Could not set %s read-write again: %s", oldMaster.Name(), rerr)
+		}
+		return err
+	}
+	var failed []string
+	err = Repoint(ctx, oldMaster, newMaster, r, Source{GTIDPos: pos, MasterOpt: GTIDMasterOpt(oldMaster.Flavor(), "slave_pos"), ReadOnly: true})
+	if err != nil {
+		failed = append(failed, err.Error())
+	}
+	for _, sl := range slaves {
+		if sl.Name() == newMaster.Name() || sl.Name() == oldMaster.Name() {
+			continue
+		}
+		err = WaitGTID(ctx, sl, pos, timeout)
+		if err != nil {
+			r.logf("WARN : %s", err)
+		}
+		err = Repoint(ctx, sl, newMaster, r, Source{ReadOnly: true})
+		if err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+	return nil
+}
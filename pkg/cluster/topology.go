@@ -0,0 +1,27 @@
+// topology.go
+package cluster
+
+/* Server is the replication state of a server of a cluster, as read by the caller */
+type Server struct {
+	URL            string
+	Host           string
+	IP             string
+	ServerID       uint
+	Failed         bool // unreachable
+	Slave          bool // replicating from another server
+	MasterServerID uint // Master_Server_Id of a slave
+	MasterHost     string
+}
+
+/* FindMaster returns the index of the server the slave replicates from, or -1. A running master is a reachable server that is not a slave and whose server id the slave reports. A failed master is the unreachable server its master host points to, since a slave that lost its master no longer reports the server id. */
+func FindMaster(servers []Server, slave Server, running bool) int {
+	for k, s := range servers {
+		if running && s.Failed == false && s.Slave == false && s.ServerID == slave.MasterServerID {
+			return k
+		}
+		if running == false && s.Failed && (s.Host == slave.MasterHost || s.IP == slave.MasterHost) {
+			return k
+		}
+	}
+	return -1
+}
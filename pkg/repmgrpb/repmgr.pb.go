@@ -0,0 +1,882 @@
+// repmgr.proto
+//
+// gRPC control service of replication-manager. The Go code of this package is
+// generated from this file with protoc-gen-go and protoc-gen-go-grpc:
+//
+//   protoc --go_out=. --go_opt=paths=source_relative \
+//     --go-grpc_out=. --go-grpc_opt=paths=source_relative repmgr.proto
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: repmgr.proto
+
+package repmgrpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// The cluster may be empty when a single cluster is monitored.
+type ClusterRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Cluster       string                 `protobuf:"bytes,1,opt,name=cluster,proto3" json:"cluster,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ClusterRequest) Reset() {
+	*x = ClusterRequest{}
+	mi := &file_repmgr_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ClusterRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClusterRequest) ProtoMessage() {}
+
+func (x *ClusterRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_repmgr_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClusterRequest.ProtoReflect.Descriptor instead.
+func (*ClusterRequest) Descriptor() ([]byte, []int) {
+	return file_repmgr_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *ClusterRequest) GetCluster() string {
+	if x != nil {
+		return x.Cluster
+	}
+	return ""
+}
+
+type ActionRequest struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Cluster string                 `protobuf:"bytes,1,opt,name=cluster,proto3" json:"cluster,omitempty"`
+	// URL of the current master, the action fails with FailedPrecondition if it changed.
+	Confirm       string `protobuf:"bytes,2,opt,name=confirm,proto3" json:"confirm,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ActionRequest) Reset() {
+	*x = ActionRequest{}
+	mi := &file_repmgr_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ActionRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ActionRequest) ProtoMessage() {}
+
+func (x *ActionRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_repmgr_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ActionRequest.ProtoReflect.Descriptor instead.
+func (*ActionRequest) Descriptor() ([]byte, []int) {
+	return file_repmgr_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ActionRequest) GetCluster() string {
+	if x != nil {
+		return x.Cluster
+	}
+	return ""
+}
+
+func (x *ActionRequest) GetConfirm() string {
+	if x != nil {
+		return x.Confirm
+	}
+	return ""
+}
+
+type ActionReply struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// URL of the new master
+	Master        string `protobuf:"bytes,1,opt,name=master,proto3" json:"master,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ActionReply) Reset() {
+	*x = ActionReply{}
+	mi := &file_repmgr_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ActionReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ActionReply) ProtoMessage() {}
+
+func (x *ActionReply) ProtoReflect() protoreflect.Message {
+	mi := &file_repmgr_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ActionReply.ProtoReflect.Descriptor instead.
+func (*ActionReply) Descriptor() ([]byte, []int) {
+	return file_repmgr_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ActionReply) GetMaster() string {
+	if x != nil {
+		return x.Master
+	}
+	return ""
+}
+
+type MaintenanceRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Cluster       string                 `protobuf:"bytes,1,opt,name=cluster,proto3" json:"cluster,omitempty"`
+	Server        string                 `protobuf:"bytes,2,opt,name=server,proto3" json:"server,omitempty"`
+	Maintenance   bool                   `protobuf:"varint,3,opt,name=maintenance,proto3" json:"maintenance,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MaintenanceRequest) Reset() {
+	*x = MaintenanceRequest{}
+	mi := &file_repmgr_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MaintenanceRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MaintenanceRequest) ProtoMessage() {}
+
+func (x *MaintenanceRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_repmgr_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MaintenanceRequest.ProtoReflect.Descriptor instead.
+func (*MaintenanceRequest) Descriptor() ([]byte, []int) {
+	return file_repmgr_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *MaintenanceRequest) GetCluster() string {
+	if x != nil {
+		return x.Cluster
+	}
+	return ""
+}
+
+func (x *MaintenanceRequest) GetServer() string {
+	if x != nil {
+		return x.Server
+	}
+	return ""
+}
+
+func (x *MaintenanceRequest) GetMaintenance() bool {
+	if x != nil {
+		return x.Maintenance
+	}
+	return false
+}
+
+type Topology struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Time          *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=time,proto3" json:"time,omitempty"`
+	Master        string                 `protobuf:"bytes,2,opt,name=master,proto3" json:"master,omitempty"`
+	Health        *Health                `protobuf:"bytes,3,opt,name=health,proto3" json:"health,omitempty"`
+	Servers       []*Server              `protobuf:"bytes,4,rep,name=servers,proto3" json:"servers,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Topology) Reset() {
+	*x = Topology{}
+	mi := &file_repmgr_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Topology) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Topology) ProtoMessage() {}
+
+func (x *Topology) ProtoReflect() protoreflect.Message {
+	mi := &file_repmgr_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Topology.ProtoReflect.Descriptor instead.
+func (*Topology) Descriptor() ([]byte, []int) {
+	return file_repmgr_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Topology) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *Topology) GetMaster() string {
+	if x != nil {
+		return x.Master
+	}
+	return ""
+}
+
+func (x *Topology) GetHealth() *Health {
+	if x != nil {
+		return x.Health
+	}
+	return nil
+}
+
+func (x *Topology) GetServers() []*Server {
+	if x != nil {
+		return x.Servers
+	}
+	return nil
+}
+
+type Health struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Master        string                 `protobuf:"bytes,2,opt,name=master,proto3" json:"master,omitempty"`
+	Score         int32                  `protobuf:"varint,3,opt,name=score,proto3" json:"score,omitempty"`
+	Slaves        int32                  `protobuf:"varint,4,opt,name=slaves,proto3" json:"slaves,omitempty"`
+	Candidates    int32                  `protobuf:"varint,5,opt,name=candidates,proto3" json:"candidates,omitempty"`
+	Issues        []string               `protobuf:"bytes,6,rep,name=issues,proto3" json:"issues,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Health) Reset() {
+	*x = Health{}
+	mi := &file_repmgr_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Health) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Health) ProtoMessage() {}
+
+func (x *Health) ProtoReflect() protoreflect.Message {
+	mi := &file_repmgr_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Health.ProtoReflect.Descriptor instead.
+func (*Health) Descriptor() ([]byte, []int) {
+	return file_repmgr_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Health) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Health) GetMaster() string {
+	if x != nil {
+		return x.Master
+	}
+	return ""
+}
+
+func (x *Health) GetScore() int32 {
+	if x != nil {
+		return x.Score
+	}
+	return 0
+}
+
+func (x *Health) GetSlaves() int32 {
+	if x != nil {
+		return x.Slaves
+	}
+	return 0
+}
+
+func (x *Health) GetCandidates() int32 {
+	if x != nil {
+		return x.Candidates
+	}
+	return 0
+}
+
+func (x *Health) GetIssues() []string {
+	if x != nil {
+		return x.Issues
+	}
+	return nil
+}
+
+type Server struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Url   string                 `protobuf:"bytes,1,opt,name=url,proto3" json:"url,omitempty"`
+	Name  string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	State string                 `protobuf:"bytes,3,opt,name=state,proto3" json:"state,omitempty"`
+	// Seconds behind master, unset when unknown
+	Delay       *int64 `protobuf:"varint,4,opt,name=delay,proto3,oneof" json:"delay,omitempty"`
+	UsingGtid   string `protobuf:"bytes,5,opt,name=using_gtid,json=usingGtid,proto3" json:"using_gtid,omitempty"`
+	CurrentGtid string `protobuf:"bytes,6,opt,name=current_gtid,json=currentGtid,proto3" json:"current_gtid,omitempty"`
+	SlaveGtid   string `protobuf:"bytes,7,opt,name=slave_gtid,json=slaveGtid,proto3" json:"slave_gtid,omitempty"`
+	BinlogPos   string `protobuf:"bytes,8,opt,name=binlog_pos,json=binlogPos,proto3" json:"binlog_pos,omitempty"`
+	IoThread    string `protobuf:"bytes,9,opt,name=io_thread,json=ioThread,proto3" json:"io_thread,omitempty"`
+	SqlThread   string `protobuf:"bytes,10,opt,name=sql_thread,json=sqlThread,proto3" json:"sql_thread,omitempty"`
+	IoError     string `protobuf:"bytes,11,opt,name=io_error,json=ioError,proto3" json:"io_error,omitempty"`
+	SqlError    string `protobuf:"bytes,12,opt,name=sql_error,json=sqlError,proto3" json:"sql_error,omitempty"`
+	// Replication channels of a multi-source slave
+	Channels      []*Channel `protobuf:"bytes,13,rep,name=channels,proto3" json:"channels,omitempty"`
+	ReadOnly      string     `protobuf:"bytes,14,opt,name=read_only,json=readOnly,proto3" json:"read_only,omitempty"`
+	SuperReadOnly string     `protobuf:"bytes,15,opt,name=super_read_only,json=superReadOnly,proto3" json:"super_read_only,omitempty"`
+	ReadOnlyIssue string     `protobuf:"bytes,16,opt,name=read_only_issue,json=readOnlyIssue,proto3" json:"read_only_issue,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Server) Reset() {
+	*x = Server{}
+	mi := &file_repmgr_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Server) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Server) ProtoMessage() {}
+
+func (x *Server) ProtoReflect() protoreflect.Message {
+	mi := &file_repmgr_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Server.ProtoReflect.Descriptor instead.
+func (*Server) Descriptor() ([]byte, []int) {
+	return file_repmgr_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *Server) GetUrl() string {
+	if x != nil {
+		return x.Url
+	}
+	return ""
+}
+
+func (x *Server) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Server) GetState() string {
+	if x != nil {
+		return x.State
+	}
+	return ""
+}
+
+func (x *Server) GetDelay() int64 {
+	if x != nil && x.Delay != nil {
+		return *x.Delay
+	}
+	return 0
+}
+
+func (x *Server) GetUsingGtid() string {
+	if x != nil {
+		return x.UsingGtid
+	}
+	return ""
+}
+
+func (x *Server) GetCurrentGtid() string {
+	if x != nil {
+		return x.CurrentGtid
+	}
+	return ""
+}
+
+func (x *Server) GetSlaveGtid() string {
+	if x != nil {
+		return x.SlaveGtid
+	}
+	return ""
+}
+
+func (x *Server) GetBinlogPos() string {
+	if x != nil {
+		return x.BinlogPos
+	}
+	return ""
+}
+
+func (x *Server) GetIoThread() string {
+	if x != nil {
+		return x.IoThread
+	}
+	return ""
+}
+
+func (x *Server) GetSqlThread() string {
+	if x != nil {
+		return x.SqlThread
+	}
+	return ""
+}
+
+func (x *Server) GetIoError() string {
+	if x != nil {
+		return x.IoError
+	}
+	return ""
+}
+
+func (x *Server) GetSqlError() string {
+	if x != nil {
+		return x.SqlError
+	}
+	return ""
+}
+
+func (x *Server) GetChannels() []*Channel {
+	if x != nil {
+		return x.Channels
+	}
+	return nil
+}
+
+func (x *Server) GetReadOnly() string {
+	if x != nil {
+		return x.ReadOnly
+	}
+	return ""
+}
+
+func (x *Server) GetSuperReadOnly() string {
+	if x != nil {
+		return x.SuperReadOnly
+	}
+	return ""
+}
+
+func (x *Server) GetReadOnlyIssue() string {
+	if x != nil {
+		return x.ReadOnlyIssue
+	}
+	return ""
+}
+
+type Channel struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Master        string                 `protobuf:"bytes,2,opt,name=master,proto3" json:"master,omitempty"`
+	IoThread      string                 `protobuf:"bytes,3,opt,name=io_thread,json=ioThread,proto3" json:"io_thread,omitempty"`
+	SqlThread     string                 `protobuf:"bytes,4,opt,name=sql_thread,json=sqlThread,proto3" json:"sql_thread,omitempty"`
+	Delay         string                 `protobuf:"bytes,5,opt,name=delay,proto3" json:"delay,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Channel) Reset() {
+	*x = Channel{}
+	mi := &file_repmgr_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Channel) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Channel) ProtoMessage() {}
+
+func (x *Channel) ProtoReflect() protoreflect.Message {
+	mi := &file_repmgr_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Channel.ProtoReflect.Descriptor instead.
+func (*Channel) Descriptor() ([]byte, []int) {
+	return file_repmgr_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *Channel) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Channel) GetMaster() string {
+	if x != nil {
+		return x.Master
+	}
+	return ""
+}
+
+func (x *Channel) GetIoThread() string {
+	if x != nil {
+		return x.IoThread
+	}
+	return ""
+}
+
+func (x *Channel) GetSqlThread() string {
+	if x != nil {
+		return x.SqlThread
+	}
+	return ""
+}
+
+func (x *Channel) GetDelay() string {
+	if x != nil {
+		return x.Delay
+	}
+	return ""
+}
+
+type WatchEvent struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Cluster       string                 `protobuf:"bytes,1,opt,name=cluster,proto3" json:"cluster,omitempty"`
+	Event         string                 `protobuf:"bytes,2,opt,name=event,proto3" json:"event,omitempty"`
+	Severity      string                 `protobuf:"bytes,3,opt,name=severity,proto3" json:"severity,omitempty"`
+	Server        string                 `protobuf:"bytes,4,opt,name=server,proto3" json:"server,omitempty"`
+	Name          string                 `protobuf:"bytes,5,opt,name=name,proto3" json:"name,omitempty"`
+	Message       string                 `protobuf:"bytes,6,opt,name=message,proto3" json:"message,omitempty"`
+	Tags          []string               `protobuf:"bytes,7,rep,name=tags,proto3" json:"tags,omitempty"`
+	Time          *timestamppb.Timestamp `protobuf:"bytes,8,opt,name=time,proto3" json:"time,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchEvent) Reset() {
+	*x = WatchEvent{}
+	mi := &file_repmgr_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchEvent) ProtoMessage() {}
+
+func (x *WatchEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_repmgr_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchEvent.ProtoReflect.Descriptor instead.
+func (*WatchEvent) Descriptor() ([]byte, []int) {
+	return file_repmgr_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *WatchEvent) GetCluster() string {
+	if x != nil {
+		return x.Cluster
+	}
+	return ""
+}
+
+func (x *WatchEvent) GetEvent() string {
+	if x != nil {
+		return x.Event
+	}
+	return ""
+}
+
+func (x *WatchEvent) GetSeverity() string {
+	if x != nil {
+		return x.Severity
+	}
+	return ""
+}
+
+func (x *WatchEvent) GetServer() string {
+	if x != nil {
+		return x.Server
+	}
+	return ""
+}
+
+func (x *WatchEvent) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *WatchEvent) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *WatchEvent) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *WatchEvent) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+var File_repmgr_proto protoreflect.FileDescriptor
+
+const file_repmgr_proto_rawDesc = "" +
+	"\n" +
+	"\frepmgr.proto\x12\x06repmgr\x1a\x1fgoogle/protobuf/timestamp.proto\"*\n" +
+	"\x0eClusterRequest\x12\x18\n" +
+	"\acluster\x18\x01 \x01(\tR\acluster\"C\n" +
+	"\rActionRequest\x12\x18\n" +
+	"\acluster\x18\x01 \x01(\tR\acluster\x12\x18\n" +
+	"\aconfirm\x18\x02 \x01(\tR\aconfirm\"%\n" +
+	"\vActionReply\x12\x16\n" +
+	"\x06master\x18\x01 \x01(\tR\x06master\"h\n" +
+	"\x12MaintenanceRequest\x12\x18\n" +
+	"\acluster\x18\x01 \x01(\tR\acluster\x12\x16\n" +
+	"\x06server\x18\x02 \x01(\tR\x06server\x12 \n" +
+	"\vmaintenance\x18\x03 \x01(\bR\vmaintenance\"\xa4\x01\n" +
+	"\bTopology\x12.\n" +
+	"\x04time\x18\x01 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\x12\x16\n" +
+	"\x06master\x18\x02 \x01(\tR\x06master\x12&\n" +
+	"\x06health\x18\x03 \x01(\v2\x0e.repmgr.HealthR\x06health\x12(\n" +
+	"\aservers\x18\x04 \x03(\v2\x0e.repmgr.ServerR\aservers\"\x9a\x01\n" +
+	"\x06Health\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x16\n" +
+	"\x06master\x18\x02 \x01(\tR\x06master\x12\x14\n" +
+	"\x05score\x18\x03 \x01(\x05R\x05score\x12\x16\n" +
+	"\x06slaves\x18\x04 \x01(\x05R\x06slaves\x12\x1e\n" +
+	"\n" +
+	"candidates\x18\x05 \x01(\x05R\n" +
+	"candidates\x12\x16\n" +
+	"\x06issues\x18\x06 \x03(\tR\x06issues\"\xf7\x03\n" +
+	"\x06Server\x12\x10\n" +
+	"\x03url\x18\x01 \x01(\tR\x03url\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12\x14\n" +
+	"\x05state\x18\x03 \x01(\tR\x05state\x12\x19\n" +
+	"\x05delay\x18\x04 \x01(\x03H\x00R\x05delay\x88\x01\x01\x12\x1d\n" +
+	"\n" +
+	"using_gtid\x18\x05 \x01(\tR\tusingGtid\x12!\n" +
+	"\fcurrent_gtid\x18\x06 \x01(\tR\vcurrentGtid\x12\x1d\n" +
+	"\n" +
+	"slave_gtid\x18\a \x01(\tR\tslaveGtid\x12\x1d\n" +
+	"\n" +
+	"binlog_pos\x18\b \x01(\tR\tbinlogPos\x12\x1b\n" +
+	"\tio_thread\x18\t \x01(\tR\bioThread\x12\x1d\n" +
+	"\n" +
+	"sql_thread\x18\n" +
+	" \x01(\tR\tsqlThread\x12\x19\n" +
+	"\bio_error\x18\v \x01(\tR\aioError\x12\x1b\n" +
+	"\tsql_error\x18\f \x01(\tR\bsqlError\x12+\n" +
+	"\bchannels\x18\r \x03(\v2\x0f.repmgr.ChannelR\bchannels\x12\x1b\n" +
+	"\tread_only\x18\x0e \x01(\tR\breadOnly\x12&\n" +
+	"\x0fsuper_read_only\x18\x0f \x01(\tR\rsuperReadOnly\x12&\n" +
+	"\x0fread_only_issue\x18\x10 \x01(\tR\rreadOnlyIssueB\b\n" +
+	"\x06_delay\"\x87\x01\n" +
+	"\aChannel\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x16\n" +
+	"\x06master\x18\x02 \x01(\tR\x06master\x12\x1b\n" +
+	"\tio_thread\x18\x03 \x01(\tR\bioThread\x12\x1d\n" +
+	"\n" +
+	"sql_thread\x18\x04 \x01(\tR\tsqlThread\x12\x14\n" +
+	"\x05delay\x18\x05 \x01(\tR\x05delay\"\xe2\x01\n" +
+	"\n" +
+	"WatchEvent\x12\x18\n" +
+	"\acluster\x18\x01 \x01(\tR\acluster\x12\x14\n" +
+	"\x05event\x18\x02 \x01(\tR\x05event\x12\x1a\n" +
+	"\bseverity\x18\x03 \x01(\tR\bseverity\x12\x16\n" +
+	"\x06server\x18\x04 \x01(\tR\x06server\x12\x12\n" +
+	"\x04name\x18\x05 \x01(\tR\x04name\x12\x18\n" +
+	"\amessage\x18\x06 \x01(\tR\amessage\x12\x12\n" +
+	"\x04tags\x18\a \x03(\tR\x04tags\x12.\n" +
+	"\x04time\x18\b \x01(\v2\x1a.google.protobuf.TimestampR\x04time2\xf6\x02\n" +
+	"\x12ReplicationManager\x127\n" +
+	"\vGetTopology\x12\x16.repmgr.ClusterRequest\x1a\x10.repmgr.Topology\x128\n" +
+	"\n" +
+	"Switchover\x12\x15.repmgr.ActionRequest\x1a\x13.repmgr.ActionReply\x126\n" +
+	"\bFailover\x12\x15.repmgr.ActionRequest\x1a\x13.repmgr.ActionReply\x124\n" +
+	"\x05Abort\x12\x16.repmgr.ClusterRequest\x1a\x13.repmgr.ActionReply\x12H\n" +
+	"\x0eSetMaintenance\x12\x1a.repmgr.MaintenanceRequest\x1a\x1a.repmgr.MaintenanceRequest\x125\n" +
+	"\x05Watch\x12\x16.repmgr.ClusterRequest\x1a\x12.repmgr.WatchEvent0\x01BAZ?github.com/mariadb-corporation/replication-manager/pkg/repmgrpbb\x06proto3"
+
+var (
+	file_repmgr_proto_rawDescOnce sync.Once
+	file_repmgr_proto_rawDescData []byte
+)
+
+func file_repmgr_proto_rawDescGZIP() []byte {
+	file_repmgr_proto_rawDescOnce.Do(func() {
+		file_repmgr_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_repmgr_proto_rawDesc), len(file_repmgr_proto_rawDesc)))
+	})
+	return file_repmgr_proto_rawDescData
+}
+
+var file_repmgr_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
+var file_repmgr_proto_goTypes = []any{
+	(*ClusterRequest)(nil),        // 0: repmgr.ClusterRequest
+	(*ActionRequest)(nil),         // 1: repmgr.ActionRequest
+	(*ActionReply)(nil),           // 2: repmgr.ActionReply
+	(*MaintenanceRequest)(nil),    // 3: repmgr.MaintenanceRequest
+	(*Topology)(nil),              // 4: repmgr.Topology
+	(*Health)(nil),                // 5: repmgr.Health
+	(*Server)(nil),                // 6: repmgr.Server
+	(*Channel)(nil),               // 7: repmgr.Channel
+	(*WatchEvent)(nil),            // 8: repmgr.WatchEvent
+	(*timestamppb.Timestamp)(nil), // 9: google.protobuf.Timestamp
+}
+var file_repmgr_proto_depIdxs = []int32{
+	9,  // 0: repmgr.Topology.time:type_name -> google.protobuf.Timestamp
+	5,  // 1: repmgr.Topology.health:type_name -> repmgr.Health
+	6,  // 2: repmgr.Topology.servers:type_name -> repmgr.Server
+	7,  // 3: repmgr.Server.channels:type_name -> repmgr.Channel
+	9,  // 4: repmgr.WatchEvent.time:type_name -> google.protobuf.Timestamp
+	0,  // 5: repmgr.ReplicationManager.GetTopology:input_type -> repmgr.ClusterRequest
+	1,  // 6: repmgr.ReplicationManager.Switchover:input_type -> repmgr.ActionRequest
+	1,  // 7: repmgr.ReplicationManager.Failover:input_type -> repmgr.ActionRequest
+	0,  // 8: repmgr.ReplicationManager.Abort:input_type -> repmgr.ClusterRequest
+	3,  // 9: repmgr.ReplicationManager.SetMaintenance:input_type -> repmgr.MaintenanceRequest
+	0,  // 10: repmgr.ReplicationManager.Watch:input_type -> repmgr.ClusterRequest
+	4,  // 11: repmgr.ReplicationManager.GetTopology:output_type -> repmgr.Topology
+	2,  // 12: repmgr.ReplicationManager.Switchover:output_type -> repmgr.ActionReply
+	2,  // 13: repmgr.ReplicationManager.Failover:output_type -> repmgr.ActionReply
+	2,  // 14: repmgr.ReplicationManager.Abort:output_type -> repmgr.ActionReply
+	3,  // 15: repmgr.ReplicationManager.SetMaintenance:output_type -> repmgr.MaintenanceRequest
+	8,  // 16: repmgr.ReplicationManager.Watch:output_type -> repmgr.WatchEvent
+	11, // [11:17] is the sub-list for method output_type
+	5,  // [5:11] is the sub-list for method input_type
+	5,  // [5:5] is the sub-list for extension type_name
+	5,  // [5:5] is the sub-list for extension extendee
+	0,  // [0:5] is the sub-list for field type_name
+}
+
+func init() { file_repmgr_proto_init() }
+func file_repmgr_proto_init() {
+	if File_repmgr_proto != nil {
+		return
+	}
+	file_repmgr_proto_msgTypes[6].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_repmgr_proto_rawDesc), len(file_repmgr_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   9,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_repmgr_proto_goTypes,
+		DependencyIndexes: file_repmgr_proto_depIdxs,
+		MessageInfos:      file_repmgr_proto_msgTypes,
+	}.Build()
+	File_repmgr_proto = out.File
+	file_repmgr_proto_goTypes = nil
+	file_repmgr_proto_depIdxs = nil
+}
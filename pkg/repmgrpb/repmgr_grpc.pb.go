@@ -0,0 +1,335 @@
+// repmgr.proto
+//
+// gRPC control service of replication-manager. The Go code of this package is
+// generated from this file with protoc-gen-go and protoc-gen-go-grpc:
+//
+//   protoc --go_out=. --go_opt=paths=source_relative \
+//     --go-grpc_out=. --go-grpc_opt=paths=source_relative repmgr.proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: repmgr.proto
+
+package repmgrpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	ReplicationManager_GetTopology_FullMethodName    = "/repmgr.ReplicationManager/GetTopology"
+	ReplicationManager_Switchover_FullMethodName     = "/repmgr.ReplicationManager/Switchover"
+	ReplicationManager_Failover_FullMethodName       = "/repmgr.ReplicationManager/Failover"
+	ReplicationManager_Abort_FullMethodName          = "/repmgr.ReplicationManager/Abort"
+	ReplicationManager_SetMaintenance_FullMethodName = "/repmgr.ReplicationManager/SetMaintenance"
+	ReplicationManager_Watch_FullMethodName          = "/repmgr.ReplicationManager/Watch"
+)
+
+// ReplicationManagerClient is the client API for ReplicationManager service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ReplicationManagerClient interface {
+	// Returns the same report as GET /api/status. Requires the viewer role.
+	GetTopology(ctx context.Context, in *ClusterRequest, opts ...grpc.CallOption) (*Topology, error)
+	// Switches the master over to the best candidate. Requires the operator role.
+	Switchover(ctx context.Context, in *ActionRequest, opts ...grpc.CallOption) (*ActionReply, error)
+	// Fails the master over to the best candidate. Requires the operator role.
+	Failover(ctx context.Context, in *ActionRequest, opts ...grpc.CallOption) (*ActionReply, error)
+	// Aborts the switchover or failover in progress. Requires the operator role.
+	Abort(ctx context.Context, in *ClusterRequest, opts ...grpc.CallOption) (*ActionReply, error)
+	// Puts a server in or out of maintenance. Requires the operator role.
+	SetMaintenance(ctx context.Context, in *MaintenanceRequest, opts ...grpc.CallOption) (*MaintenanceRequest, error)
+	// Streams the alerts of the cluster, or of all clusters if it is empty. Requires the viewer role.
+	Watch(ctx context.Context, in *ClusterRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[WatchEvent], error)
+}
+
+type replicationManagerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewReplicationManagerClient(cc grpc.ClientConnInterface) ReplicationManagerClient {
+	return &replicationManagerClient{cc}
+}
+
+func (c *replicationManagerClient) GetTopology(ctx context.Context, in *ClusterRequest, opts ...grpc.CallOption) (*Topology, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Topology)
+	err := c.cc.Invoke(ctx, ReplicationManager_GetTopology_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *replicationManagerClient) Switchover(ctx context.Context, in *ActionRequest, opts ...grpc.CallOption) (*ActionReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ActionReply)
+	err := c.cc.Invoke(ctx, ReplicationManager_Switchover_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *replicationManagerClient) Failover(ctx context.Context, in *ActionRequest, opts ...grpc.CallOption) (*ActionReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ActionReply)
+	err := c.cc.Invoke(ctx, ReplicationManager_Failover_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *replicationManagerClient) Abort(ctx context.Context, in *ClusterRequest, opts ...grpc.CallOption) (*ActionReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ActionReply)
+	err := c.cc.Invoke(ctx, ReplicationManager_Abort_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *replicationManagerClient) SetMaintenance(ctx context.Context, in *MaintenanceRequest, opts ...grpc.CallOption) (*MaintenanceRequest, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(MaintenanceRequest)
+	err := c.cc.Invoke(ctx, ReplicationManager_SetMaintenance_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *replicationManagerClient) Watch(ctx context.Context, in *ClusterRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[WatchEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ReplicationManager_ServiceDesc.Streams[0], ReplicationManager_Watch_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ClusterRequest, WatchEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ReplicationManager_WatchClient = grpc.ServerStreamingClient[WatchEvent]
+
+// ReplicationManagerServer is the server API for ReplicationManager service.
+// All implementations must embed UnimplementedReplicationManagerServer
+// for forward compatibility.
+type ReplicationManagerServer interface {
+	// Returns the same report as GET /api/status. Requires the viewer role.
+	GetTopology(context.Context, *ClusterRequest) (*Topology, error)
+	// Switches the master over to the best candidate. Requires the operator role.
+	Switchover(context.Context, *ActionRequest) (*ActionReply, error)
+	// Fails the master over to the best candidate. Requires the operator role.
+	Failover(context.Context, *ActionRequest) (*ActionReply, error)
+	// Aborts the switchover or failover in progress. Requires the operator role.
+	Abort(context.Context, *ClusterRequest) (*ActionReply, error)
+	// Puts a server in or out of maintenance. Requires the operator role.
+	SetMaintenance(context.Context, *MaintenanceRequest) (*MaintenanceRequest, error)
+	// Streams the alerts of the cluster, or of all clusters if it is empty. Requires the viewer role.
+	Watch(*ClusterRequest, grpc.ServerStreamingServer[WatchEvent]) error
+	mustEmbedUnimplementedReplicationManagerServer()
+}
+
+// UnimplementedReplicationManagerServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedReplicationManagerServer struct{}
+
+func (UnimplementedReplicationManagerServer) GetTopology(context.Context, *ClusterRequest) (*Topology, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetTopology not implemented")
+}
+func (UnimplementedReplicationManagerServer) Switchover(context.Context, *ActionRequest) (*ActionReply, error) {
+	return nil, status.Error(codes.Unimplemented, "method Switchover not implemented")
+}
+func (UnimplementedReplicationManagerServer) Failover(context.Context, *ActionRequest) (*ActionReply, error) {
+	return nil, status.Error(codes.Unimplemented, "method Failover not implemented")
+}
+func (UnimplementedReplicationManagerServer) Abort(context.Context, *ClusterRequest) (*ActionReply, error) {
+	return nil, status.Error(codes.Unimplemented, "method Abort not implemented")
+}
+func (UnimplementedReplicationManagerServer) SetMaintenance(context.Context, *MaintenanceRequest) (*MaintenanceRequest, error) {
+	return nil, status.Error(codes.Unimplemented, "method SetMaintenance not implemented")
+}
+func (UnimplementedReplicationManagerServer) Watch(*ClusterRequest, grpc.ServerStreamingServer[WatchEvent]) error {
+	return status.Error(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedReplicationManagerServer) mustEmbedUnimplementedReplicationManagerServer() {}
+func (UnimplementedReplicationManagerServer) testEmbeddedByValue()                            {}
+
+// UnsafeReplicationManagerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ReplicationManagerServer will
+// result in compilation errors.
+type UnsafeReplicationManagerServer interface {
+	mustEmbedUnimplementedReplicationManagerServer()
+}
+
+func RegisterReplicationManagerServer(s grpc.ServiceRegistrar, srv ReplicationManagerServer) {
+	// If the following call panics, it indicates UnimplementedReplicationManagerServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&ReplicationManager_ServiceDesc, srv)
+}
+
+func _ReplicationManager_GetTopology_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ClusterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReplicationManagerServer).GetTopology(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ReplicationManager_GetTopology_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReplicationManagerServer).GetTopology(ctx, req.(*ClusterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ReplicationManager_Switchover_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ActionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReplicationManagerServer).Switchover(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ReplicationManager_Switchover_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReplicationManagerServer).Switchover(ctx, req.(*ActionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ReplicationManager_Failover_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ActionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReplicationManagerServer).Failover(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ReplicationManager_Failover_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReplicationManagerServer).Failover(ctx, req.(*ActionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ReplicationManager_Abort_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ClusterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReplicationManagerServer).Abort(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ReplicationManager_Abort_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReplicationManagerServer).Abort(ctx, req.(*ClusterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ReplicationManager_SetMaintenance_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MaintenanceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReplicationManagerServer).SetMaintenance(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ReplicationManager_SetMaintenance_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReplicationManagerServer).SetMaintenance(ctx, req.(*MaintenanceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ReplicationManager_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ClusterRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ReplicationManagerServer).Watch(m, &grpc.GenericServerStream[ClusterRequest, WatchEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ReplicationManager_WatchServer = grpc.ServerStreamingServer[WatchEvent]
+
+// ReplicationManager_ServiceDesc is the grpc.ServiceDesc for ReplicationManager service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ReplicationManager_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "repmgr.ReplicationManager",
+	HandlerType: (*ReplicationManagerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetTopology",
+			Handler:    _ReplicationManager_GetTopology_Handler,
+		},
+		{
+			MethodName: "Switchover",
+			Handler:    _ReplicationManager_Switchover_Handler,
+		},
+		{
+			MethodName: "Failover",
+			Handler:    _ReplicationManager_Failover_Handler,
+		},
+		{
+			MethodName: "Abort",
+			Handler:    _ReplicationManager_Abort_Handler,
+		},
+		{
+			MethodName: "SetMaintenance",
+			Handler:    _ReplicationManager_SetMaintenance_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       _ReplicationManager_Watch_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "repmgr.proto",
+}
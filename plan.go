@@ -0,0 +1,99 @@
+// plan.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+/* Writes a runbook of the switchover or failover that would run on the current topology. The operation is rehearsed in dry-run mode and its log is turned into ordered steps, so the plan cannot drift from what the code actually does. */
+func (c *Cluster) writePlan(kind string) error {
+	if kind != "failover" && kind != "switchover" {
+		return errors.New("plan expects failover or switchover, got '" + kind + "'")
+	}
+	c.refreshTopology(context.Background())
+	topology := c.planTopology()
+	*dryRun = true
+	if c.vip != nil {
+		c.vip = dryVIP{}
+	}
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	flags := log.Flags()
+	log.SetFlags(0)
+	start := time.Now()
+	var nmUrl string
+	if kind == "failover" {
+		nmUrl, _ = c.master.failover(context.Background())
+	} else {
+		nmUrl, _ = c.master.switchover(context.Background())
+	}
+	elapsed := time.Since(start)
+	log.SetOutput(logWriter)
+	log.SetFlags(flags)
+
+	fmt.Printf("# %s plan for cluster %s\n\n", strings.Title(kind), c.clusterName())
+	fmt.Printf("Generated on %s by replication-manager %s. Current master: %s.\n\n", time.Now().Format("2006-01-02 15:04:05"), repmgrVersion, c.master.label())
+	fmt.Print("## Topology\n\n")
+	fmt.Print(topology)
+	fmt.Print("\n## Steps\n\n")
+	n := 0
+	var notes, elected []string
+	for _, line := range strings.Split(buf.String(), "\n") {
+		switch {
+		case strings.HasPrefix(line, "DRY-RUN: "):
+			n++
+			// The plan is meant to be shared, a password logged by any step stays out of it
+			fmt.Printf("%d. %s\n", n, redactStmt(strings.TrimPrefix(line, "DRY-RUN: ")))
+		case strings.Contains(line, "has been elected"):
+			elected = append(elected, line)
+		case strings.HasPrefix(line, "WARN :"), strings.HasPrefix(line, "ERROR:"), strings.Contains(line, "Estimated"), strings.Contains(line, "cannot be estimated"):
+			notes = append(notes, line)
+		}
+	}
+	if n == 0 {
+		fmt.Println("None, the operation would be aborted.")
+	}
+	fmt.Print("\n## Candidate\n\n")
+	if len(elected) == 0 {
+		fmt.Println("No candidate would be elected.")
+	}
+	for _, l := range elected {
+		fmt.Println(strings.TrimSpace(strings.TrimPrefix(l, "INFO :")))
+	}
+	fmt.Print("\n## Estimated duration\n\n")
+	fmt.Printf("  * Checks and election: %s (measured during the rehearsal)\n", elapsed.Round(time.Millisecond))
+	if kind == "switchover" {
+		fmt.Printf("  * Write freeze on the old master: up to %s waiting for running writes before killing them\n", time.Duration(*waitKill)*time.Millisecond)
+	}
+	fmt.Printf("  * Each synchronization wait: up to %d seconds\n", *gtidWaitTimeout)
+	if *preScript != "" || *postScript != "" {
+		fmt.Println("  * Hook scripts: not bounded by replication-manager")
+	}
+	if len(notes) > 0 {
+		fmt.Print("\n## Notes\n\n")
+		for _, l := range notes {
+			fmt.Println("  * " + l)
+		}
+	}
+	if nmUrl != "" {
+		return errors.New("rehearsal unexpectedly returned a new master")
+	}
+	return nil
+}
+
+/* Returns the topology table of the plan */
+func (c *Cluster) planTopology() string {
+	var b bytes.Buffer
+	b.WriteString("| Server | Name | Role | Delay | Replication | GTID |\n|---|---|---|---|---|---|\n")
+	fmt.Fprintf(&b, "| %s | %s | master | | | %s |\n", c.master.URL, c.master.Name, c.master.BinlogPos)
+	for _, sl := range c.slaves {
+		fmt.Fprintf(&b, "| %s | %s | slave | %d | %s | %s |\n", sl.URL, sl.Name, sl.Delay.Int64, sl.healthCheck(), sl.CurrentGtid)
+	}
+	return b.String()
+}
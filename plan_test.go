@@ -0,0 +1,55 @@
+// plan_test.go
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFailoverPlan(t *testing.T) {
+	defer func(f string, d bool) { *failover, *dryRun = f, d }(*failover, *dryRun)
+	*failover = "force"
+	sims := simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 1 }))
+	current.rplUser, current.rplPass = "repl", "s3cret"
+	current.master = current.findMaster(false)
+	var err error
+	out := simStdout(t, func() { err = current.writePlan("failover") })
+	if err != nil {
+		t.Fatalf("writePlan() = %s", err)
+	}
+	for url, sim := range sims {
+		if len(sim.execs) > 0 {
+			t.Errorf("statements run on %s by the plan: %q", url, sim.execs)
+		}
+	}
+	// Sections and steps in order
+	want := []string{
+		"# Failover plan for cluster",
+		"| db3:3306 |  | slave | 0 | Running OK | 0-1-115 |",
+		"## Steps",
+		"1. would send failover-started alert",
+		"2. [db3:3306] STOP SLAVE",
+		"3. [db3:3306] RESET SLAVE ALL",
+		"4. [db3:3306] SET GLOBAL read_only=0",
+		"6. [db2:3306] CHANGE MASTER TO master_host='db3', master_port=3306",
+		"7. [db2:3306] START SLAVE",
+		"## Candidate",
+		"Slave db3:3306 has been elected as a new master",
+		"## Estimated duration",
+		"Each synchronization wait: up to 30 seconds",
+	}
+	rest := out
+	for _, w := range want {
+		i := strings.Index(rest, w)
+		if i < 0 {
+			t.Fatalf("plan does not contain %q after the previous parts:\n%s", w, out)
+		}
+		rest = rest[i+len(w):]
+	}
+	if strings.Contains(out, "master_password='s3cret'") || strings.Contains(out, "master_password='****'") == false {
+		t.Errorf("plan does not mask the replication password:\n%s", out)
+	}
+	if err := current.writePlan("rotate"); err == nil {
+		t.Error("writePlan() accepted an unknown operation")
+	}
+}
@@ -0,0 +1,222 @@
+// positional.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/mariadb-corporation/replication-manager/pkg/cluster"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* Longest single wait for a sync point, so that progress is reported and no query outlasts the read timeout */
+const syncStep int64 = 2
+
+var errSyncTimeout = errors.New("timeout")
+
+/* True when the slave replicates with binlog file and position, either in a cluster without GTID or as a non-GTID slave of a mixed topology */
+func (sm *ServerMonitor) usesPositions() bool {
+	return sm.cluster.positional || sm.UsingGtid == "No"
+}
+
+/* True when at least one slave needs binlog coordinates to be repointed */
+func (c *Cluster) anyPositional() bool {
+	for _, sl := range c.slaves {
+		if sl.usesPositions() {
+			return true
+		}
+	}
+	return c.positional
+}
+
+type binlogPos struct {
+	File string
+	Pos  uint64
+}
+
+/* Point in the master history slaves must reach to be in sync, as a GTID position or binlog coordinates */
+type syncPoint struct {
+	Gtid string
+	Pos  binlogPos
+}
+
+func (p binlogPos) String() string {
+	return fmt.Sprintf("%s:%d", p.File, p.Pos)
+}
+
+/* Returns the CHANGE MASTER options pointing a slave at these coordinates */
+func (p binlogPos) changeMasterOpt() string {
+	return fmt.Sprintf(", master_log_file='%s', master_log_pos=%d", p.File, p.Pos)
+}
+
+/* Returns a number that grows with the binlog coordinates, assuming files share the same base name */
+func (p binlogPos) seq() uint64 {
+	idx := strings.LastIndex(p.File, ".")
+	n, _ := strconv.ParseUint(p.File[idx+1:], 10, 32)
+	return n<<32 | p.Pos
+}
+
+/* Returns the current binlog coordinates of the server */
+func (sm *ServerMonitor) masterStatus() (binlogPos, error) {
+	rows, err := sm.query("SHOW MASTER STATUS")
+	if err != nil {
+		return binlogPos{}, err
+	}
+	if len(rows) == 0 {
+		return binlogPos{}, errors.New(fmt.Sprintf("binary logging is disabled on %s", sm.URL))
+	}
+	pos, _ := strconv.ParseUint(rows[0]["Position"], 10, 64)
+	return binlogPos{File: rows[0]["File"], Pos: pos}, nil
+}
+
+/* Returns the master coordinates received by the IO thread and executed by the SQL thread of a slave */
+func (sm *ServerMonitor) slavePositions() (binlogPos, binlogPos, error) {
+	rows, err := sm.query("SHOW SLAVE STATUS")
+	if err != nil {
+		return binlogPos{}, binlogPos{}, err
+	}
+	if len(rows) == 0 {
+		return binlogPos{}, binlogPos{}, sql.ErrNoRows
+	}
+	r := rows[0]
+	rpos, _ := strconv.ParseUint(r["Read_Master_Log_Pos"], 10, 64)
+	epos, _ := strconv.ParseUint(r["Exec_Master_Log_Pos"], 10, 64)
+	return binlogPos{File: r["Master_Log_File"], Pos: rpos}, binlogPos{File: r["Relay_Master_Log_File"], Pos: epos}, nil
+}
+
+/* Returns the master coordinates received by a slave, used to compare candidates when GTID is not available */
+func (sm *ServerMonitor) positionSeq() uint64 {
+	read, _, err := sm.slavePositions()
+	if err != nil {
+		return 0
+	}
+	return read.seq()
+}
+
+/* Waits until the slave SQL thread has executed everything its IO thread received from a dead master. Returns the executed coordinates. */
+func (sm *ServerMonitor) waitRelayApplied(ctx context.Context, timeout int64) (binlogPos, error) {
+	deadline := time.Now().Add(time.Duration(timeout) * time.Second)
+	for {
+		if ctx.Err() != nil {
+			return binlogPos{}, sm.cluster.abortError(ctx)
+		}
+		read, exec, err := sm.slavePositions()
+		if err != nil {
+			return exec, err
+		}
+		if read == exec {
+			return exec, nil
+		}
+		if time.Now().After(deadline) {
+			return exec, errors.New(fmt.Sprintf("relay log not applied after %d seconds, executed %s of %s", timeout, exec, read))
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+/* Returns the current sync point of a master. Binlog coordinates are only read when a slave needs them. */
+func (sm *ServerMonitor) syncPoint() (syncPoint, error) {
+	if sm.cluster.positional {
+		pos, err := sm.masterStatus()
+		return syncPoint{Pos: pos}, err
+	}
+	sp := syncPoint{Gtid: sm.binlogGtid()}
+	if sm.cluster.anyPositional() {
+		var err error
+		sp.Pos, err = sm.masterStatus()
+		if err != nil {
+			return sp, err
+		}
+	}
+	return sp, nil
+}
+
+/* Returns the current GTID position of a server, or its binlog coordinates without GTID */
+func (sm *ServerMonitor) position() string {
+	sp, err := sm.syncPoint()
+	if err != nil {
+		return ""
+	}
+	return sp.String()
+}
+
+/* Returns the GTID position, or the binlog coordinates of a cluster replicating without GTID */
+func (sp syncPoint) String() string {
+	if sp.Gtid == "" && sp.Pos.File != "" {
+		return sp.Pos.String()
+	}
+	return sp.Gtid
+}
+
+/* Waits until the slave reaches the sync point. Returns the time spent waiting. */
+func (sm *ServerMonitor) waitSync(ctx context.Context, sp syncPoint, timeout int64) (time.Duration, error) {
+	if *dryRun {
+		alertLog("DRY-RUN: [%s] would wait up to %d seconds for position %s", sm.URL, timeout, sp)
+		return 0, nil
+	}
+	start := time.Now()
+	for {
+		step := timeout - int64(time.Since(start)/time.Second)
+		if step > syncStep {
+			step = syncStep
+		}
+		if step < 1 {
+			step = 1
+		}
+		var err error
+		if sm.usesPositions() {
+			err = sm.waitPos(ctx, sp.Pos, step)
+		} else {
+			_, err = sm.waitGtid(ctx, sp.Gtid, step)
+		}
+		wt := time.Since(start).Round(time.Millisecond)
+		if ctx.Err() != nil {
+			return wt, sm.cluster.abortError(ctx)
+		}
+		if err != errSyncTimeout {
+			return wt, err
+		}
+		if wt >= time.Duration(timeout)*time.Second {
+			return wt, errors.New(fmt.Sprintf("timeout after %d seconds, %s", timeout, sm.syncProgress(sp)))
+		}
+		logprintf("INFO : Waiting for %s to reach position %s: %s after %s", sm.URL, sp, sm.syncProgress(sp), wt)
+	}
+}
+
+/* Waits at most timeout seconds for the slave SQL thread to reach the binlog coordinates */
+func (sm *ServerMonitor) waitPos(ctx context.Context, pos binlogPos, timeout int64) error {
+	rows, err := sm.node().Query(ctx, fmt.Sprintf("SELECT MASTER_POS_WAIT('%s', %d, %d) AS res", pos.File, pos.Pos, timeout))
+	if err != nil {
+		return err
+	}
+	// NULL when the SQL thread is not running, negative on timeout
+	if len(rows) == 0 || rows[0]["res"] == "" {
+		return errors.New("slave SQL thread is not running")
+	}
+	if strings.HasPrefix(rows[0]["res"], "-") {
+		return errSyncTimeout
+	}
+	return nil
+}
+
+/* Describes how far the slave is from the sync point, in transactions with GTID or in bytes of the master binlog otherwise */
+func (sm *ServerMonitor) syncProgress(sp syncPoint) string {
+	if sm.usesPositions() {
+		_, exec, err := sm.slavePositions()
+		if err != nil {
+			return "position unknown"
+		}
+		if exec.File != sp.Pos.File {
+			return fmt.Sprintf("executed %s", exec)
+		}
+		return fmt.Sprintf("%d bytes behind", int64(sp.Pos.Pos)-int64(exec.Pos))
+	}
+	applied := sm.backend().Variable("GTID_CURRENT_POS")
+	if sm.Flavor == FLAVOR_MYSQL {
+		applied = sm.binlogGtid()
+	}
+	return fmt.Sprintf("%d transactions behind", int64(cluster.GTIDCount(sm.Flavor, sp.Gtid))-int64(cluster.GTIDCount(sm.Flavor, applied)))
+}
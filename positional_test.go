@@ -0,0 +1,129 @@
+// positional_test.go
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+/* Makes a simulated slave replicate with binlog coordinates, having received and executed the positions of the file of its master */
+func simPositions(sim *simServer, file string, read uint, exec uint) {
+	sim.status.Using_Gtid = "No"
+	sim.status.Master_Log_File, sim.status.Read_Master_Log_Pos = file, read
+	sim.status.Relay_Master_Log_File, sim.status.Exec_Master_Log_Pos = file, exec
+}
+
+func TestPositionalFailover(t *testing.T) {
+	defer func(f string) { *failover = f }(*failover)
+	*failover = "force"
+	tests := []struct {
+		name     string
+		db2      uint // coordinates received by db2, which executed 600
+		db3      uint
+		promoted string
+		repoint  string // binlog coordinates the other slave replicates from, empty if it cannot be repointed
+	}{
+		{"slaves at the same position", 900, 900, "db2:3306", "master_log_file='db2-bin.000001', master_log_pos=4000"},
+		{"slave behind the new master", 800, 900, "db3:3306", ""},
+	}
+	for _, tt := range tests {
+		sims := simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 1 }))
+		simPositions(sims["db2:3306"], "mysql-bin.000003", tt.db2, 600)
+		simPositions(sims["db3:3306"], "mysql-bin.000003", tt.db3, 600)
+		for _, url := range []string{"db2:3306", "db3:3306"} {
+			sims[url].rows = map[string][]map[string]string{"SHOW MASTER STATUS": {{"File": strings.Split(url, ":")[0] + "-bin.000001", "Position": "4000"}}}
+			simServerByURL(url).refresh()
+		}
+		current.positional = true
+		current.master = current.findMaster(false)
+		nmUrl, err := current.Failover(context.Background())
+		if err != nil || nmUrl != tt.promoted {
+			t.Errorf("%s: Failover() = %q, %v, want %s", tt.name, nmUrl, err, tt.promoted)
+			continue
+		}
+		other := "db2:3306"
+		if nmUrl == other {
+			other = "db3:3306"
+		}
+		repointed := false
+		for _, e := range sims[other].execs {
+			if strings.HasPrefix(e, "CHANGE MASTER TO") {
+				repointed = true
+				if strings.Contains(e, tt.repoint) == false {
+					t.Errorf("%s: slave %s repointed with %q, want %s", tt.name, other, e, tt.repoint)
+				}
+			}
+		}
+		if repointed != (tt.repoint != "") {
+			t.Errorf("%s: slave %s repointed %v, want %v", tt.name, other, repointed, tt.repoint != "")
+		}
+	}
+}
+
+func TestWaitSyncPositional(t *testing.T) {
+	tests := []struct {
+		name    string
+		stopped bool
+		pos     uint
+		err     string // part of the error, empty for success
+	}{
+		{"slave applying its relay log", false, 900, ""},
+		{"slave that did not receive the position", false, 1000, "timeout after 1 seconds, 100 bytes behind"},
+		{"slave with replication stopped", true, 900, "slave SQL thread is not running"},
+	}
+	for _, tt := range tests {
+		sims := simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.stopped = tt.stopped && sp.id == 2 }))
+		simPositions(sims["db2:3306"], "mysql-bin.000003", 900, 600)
+		current.positional = true
+		_, err := simServerByURL("db2:3306").waitSync(context.Background(), syncPoint{Pos: binlogPos{File: "mysql-bin.000003", Pos: uint64(tt.pos)}}, 1)
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || strings.Contains(err.Error(), tt.err) == false) {
+			t.Errorf("%s: waitSync() = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestMixedFailover(t *testing.T) {
+	defer func(f string) { *failover = f }(*failover)
+	*failover = "force"
+	specs := append(simTopology(), simSpec{url: "db4:3306", id: 4, master: 1, gtid: "0-1-100"})
+	sims := simCluster(t, simChange(specs, func(sp *simSpec) { sp.down = sp.id == 1 }))
+	// db3 replicates with binlog coordinates and executed what db2 executed, the other slaves replicate with GTID
+	for url, read := range map[string]uint{"db2:3306": 900, "db3:3306": 900, "db4:3306": 700} {
+		gtid := sims[url].status.Using_Gtid
+		simPositions(sims[url], "mysql-bin.000003", read, read)
+		if url != "db3:3306" {
+			sims[url].status.Using_Gtid = gtid
+		}
+		sims[url].rows = map[string][]map[string]string{"SHOW MASTER STATUS": {{"File": strings.Split(url, ":")[0] + "-bin.000001", "Position": "4000"}}}
+		simServerByURL(url).refresh()
+	}
+	if current.anyPositional() == false || simServerByURL("db2:3306").usesPositions() || simServerByURL("db3:3306").usesPositions() == false {
+		t.Fatal("replication modes of the slaves not detected")
+	}
+	current.master = current.findMaster(false)
+	nmUrl, err := current.Failover(context.Background())
+	if err != nil || nmUrl != "db2:3306" {
+		t.Fatalf("Failover() = %q, %v, want db2:3306", nmUrl, err)
+	}
+	tests := []struct {
+		url        string
+		positional bool
+	}{
+		{"db3:3306", true},
+		{"db4:3306", false},
+	}
+	for _, tt := range tests {
+		var change string
+		for _, e := range sims[tt.url].execs {
+			if strings.HasPrefix(e, "CHANGE MASTER TO master_host='db2'") {
+				change = e
+			}
+		}
+		if change == "" {
+			t.Errorf("%s not repointed to db2", tt.url)
+		} else if strings.Contains(change, "master_log_file='db2-bin.000001', master_log_pos=4000") != tt.positional {
+			t.Errorf("%s repointed with %q, want binlog coordinates %t", tt.url, change, tt.positional)
+		}
+	}
+}
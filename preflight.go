@@ -0,0 +1,116 @@
+// preflight.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+/* Result of a switchover pre-flight check */
+type preflightCheck struct {
+	Cluster string
+	Check   string
+	Server  string
+	Passed  bool
+	Detail  string
+}
+
+/* Runs the checks a clean switchover depends on, without changing anything */
+func (c *Cluster) preflightChecks() []preflightCheck {
+	var res []preflightCheck
+	add := func(check string, sm *ServerMonitor, passed bool, format string, args ...interface{}) {
+		res = append(res, preflightCheck{Cluster: c.clusterName(), Check: check, Server: sm.URL, Passed: passed, Detail: fmt.Sprintf(format, args...)})
+	}
+	if c.master.State == STATE_FAILED {
+		add("master", c.master, false, "master is unreachable")
+		return res
+	}
+	c.refreshTopology(context.Background())
+	if n, err := c.master.longRunningWrites(10); err != nil {
+		add("long-running-writes", c.master, false, "could not list running writes: %s", err)
+	} else {
+		add("long-running-writes", c.master, n == 0, "%d writes running for more than 10 seconds", n)
+	}
+	// A variable that cannot be read fails its check
+	get := func(check string, sm *ServerMonitor, name string) (string, bool) {
+		rows, err := sm.query("SELECT @@GLOBAL." + name + " AS value")
+		if err != nil || len(rows) == 0 {
+			add(check, sm, false, "could not read %s: %v", name, err)
+			return "", false
+		}
+		return rows[0]["value"], true
+	}
+	format, formatOk := get("binlog-format", c.master, "binlog_format")
+	add("read-only", c.master, c.master.ReadOnly == "OFF", "read_only is %s", c.master.ReadOnly)
+	// The enabled events of the master replicate disabled, they only run on the new master when migrated
+	if scheduler, ok := get("event-scheduler", c.master, "event_scheduler"); ok {
+		if scheduler != "ON" || *migrateEvents {
+			add("event-scheduler", c.master, true, "event_scheduler is %s", scheduler)
+		} else if events, err := c.master.listEvents("ENABLED"); err != nil {
+			add("event-scheduler", c.master, false, "could not list events: %s", err)
+		} else {
+			add("event-scheduler", c.master, len(events) == 0, "event_scheduler is ON with %d enabled events, which do not run on the new master without -migrate-events", len(events))
+		}
+	}
+	for _, sl := range c.slaves {
+		if sl.State == STATE_FAILED {
+			add("replication", sl, false, "slave is unreachable")
+			continue
+		}
+		running := sl.IOThread == "Yes" && sl.SQLThread == "Yes"
+		add("replication", sl, running && sl.Delay.Valid && sl.Delay.Int64 <= *maxDelay, "IO thread %s, SQL thread %s, %d seconds behind master", sl.IOThread, sl.SQLThread, sl.Delay.Int64)
+		if f, ok := get("binlog-format", sl, "binlog_format"); ok && formatOk {
+			add("binlog-format", sl, f == format, "binlog_format is %s, %s on master", f, format)
+		}
+		if s, ok := get("event-scheduler", sl, "event_scheduler"); ok {
+			add("event-scheduler", sl, s != "ON", "event_scheduler is %s", s)
+		}
+		add("read-only", sl, sl.ReadOnly == "ON", "read_only is %s", sl.ReadOnly)
+	}
+	key := c.master.electCandidate(c.slaves)
+	if key == -1 {
+		add("candidate", c.master, false, "no slave can be promoted")
+	} else {
+		add("candidate", c.slaves[key], true, "would be promoted")
+		issues := c.slaves[key].durabilityIssues()
+		detail := "sync_binlog, innodb_flush_log_at_trx_commit and log_slave_updates are safe"
+		if len(issues) > 0 {
+			detail = strings.Join(issues, "; ")
+		}
+		add("durability", c.slaves[key], len(issues) == 0, "%s", detail)
+	}
+	return res
+}
+
+/* Prints the pre-flight checks of all clusters and returns false if any failed */
+func checkSwitchover() bool {
+	var res []preflightCheck
+	for _, c := range clusters {
+		logWriter.attribute(c.clusterName())
+		res = append(res, c.preflightChecks()...)
+	}
+	ok := true
+	for _, r := range res {
+		ok = ok && r.Passed
+	}
+	if *output == "json" {
+		json.NewEncoder(os.Stdout).Encode(res)
+		return ok
+	}
+	for _, r := range res {
+		status := "OK"
+		if r.Passed == false {
+			status = "FAIL"
+		}
+		fmt.Printf("%-4s %-10s %-20s %-21s %s\n", status, r.Cluster, r.Check, r.Server, r.Detail)
+	}
+	if ok {
+		fmt.Println("All checks passed, switchover can proceed")
+	} else {
+		fmt.Println("Some checks failed, switchover is not safe")
+	}
+	return ok
+}
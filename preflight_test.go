@@ -0,0 +1,106 @@
+// preflight_test.go
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+/* Sets the settings a switchover checks to safe values on every server */
+func simSwitchoverSettings(sims map[string]*simServer) {
+	for _, sim := range sims {
+		sim.vars["BINLOG_FORMAT"], sim.vars["EVENT_SCHEDULER"] = "ROW", "OFF"
+		sim.vars["SYNC_BINLOG"], sim.vars["INNODB_FLUSH_LOG_AT_TRX_COMMIT"], sim.vars["LOG_SLAVE_UPDATES"] = "1", "1", "ON"
+	}
+}
+
+func TestPreflightChecks(t *testing.T) {
+	defer func(f string) { *failover = f }(*failover)
+	*failover = "force"
+	tests := []struct {
+		name   string
+		specs  []simSpec
+		change func(sims map[string]*simServer)
+		failed []string // failed checks, as check and server
+	}{
+		{"ready", simTopology(), nil, nil},
+		{"master down", simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 1 }), nil, []string{"master db1:3306"}},
+		{"long running writes", simTopology(), func(sims map[string]*simServer) {
+			sims["db1:3306"].rows = map[string][]map[string]string{"SELECT SUM(ct) AS n": {{"n": "2"}}}
+		}, []string{"long-running-writes db1:3306"}},
+		{"binlog format mismatch", simTopology(), func(sims map[string]*simServer) {
+			sims["db2:3306"].vars["BINLOG_FORMAT"] = "STATEMENT"
+		}, []string{"binlog-format db2:3306"}},
+		{"unreadable setting", simTopology(), func(sims map[string]*simServer) {
+			delete(sims["db3:3306"].vars, "EVENT_SCHEDULER")
+		}, []string{"event-scheduler db3:3306"}},
+		{"enabled events on master", simTopology(), func(sims map[string]*simServer) {
+			sims["db1:3306"].vars["EVENT_SCHEDULER"] = "ON"
+			sims["db1:3306"].rows = map[string][]map[string]string{"SELECT EVENT_SCHEMA, EVENT_NAME FROM information_schema.EVENTS": {{"EVENT_SCHEMA": "app", "EVENT_NAME": "purge_sessions"}}}
+		}, []string{"event-scheduler db1:3306"}},
+		{"event scheduler on slave", simTopology(), func(sims map[string]*simServer) {
+			sims["db2:3306"].vars["EVENT_SCHEDULER"] = "ON"
+		}, []string{"event-scheduler db2:3306"}},
+		{"writable slave and read-only master", simTopology(), func(sims map[string]*simServer) {
+			sims["db1:3306"].vars["READ_ONLY"], sims["db2:3306"].vars["READ_ONLY"] = "ON", "OFF"
+		}, []string{"read-only db1:3306", "read-only db2:3306"}},
+		{"replication stopped", simChange(simTopology(), func(sp *simSpec) { sp.stopped = sp.id == 2 }), nil, []string{"replication db2:3306"}},
+		{"unsafe candidate", simTopology(), func(sims map[string]*simServer) {
+			sims["db3:3306"].vars["SYNC_BINLOG"] = "0"
+		}, []string{"durability db3:3306"}},
+		{"no candidate", simChange(simTopology(), func(sp *simSpec) { sp.down = sp.master != 0 }), nil,
+			[]string{"candidate db1:3306", "replication db2:3306", "replication db3:3306"}},
+	}
+	for _, tt := range tests {
+		sims := simCluster(t, tt.specs)
+		simSwitchoverSettings(sims)
+		if tt.change != nil {
+			tt.change(sims)
+		}
+		current.master = current.findMaster(true)
+		if current.master == nil {
+			current.master = current.findMaster(false)
+		}
+		var failed []string
+		for _, c := range current.preflightChecks() {
+			if c.Passed == false {
+				failed = append(failed, c.Check+" "+c.Server)
+			}
+		}
+		sort.Strings(failed)
+		if strings.Join(failed, ", ") != strings.Join(tt.failed, ", ") {
+			t.Errorf("%s: failed checks %q, want %q", tt.name, failed, tt.failed)
+		}
+	}
+}
+
+func TestCheckSwitchover(t *testing.T) {
+	defer func(f, o string) { *failover, *output, clusters = f, o, nil }(*failover, *output)
+	*failover, *output = "force", "text"
+	tests := []struct {
+		name string
+		safe bool
+		want string
+	}{
+		{"safe", true, "All checks passed, switchover can proceed"},
+		{"unsafe", false, "Some checks failed, switchover is not safe"},
+	}
+	for _, tt := range tests {
+		sims := simCluster(t, simTopology())
+		simSwitchoverSettings(sims)
+		if tt.safe == false {
+			sims["db2:3306"].vars["BINLOG_FORMAT"] = "MIXED"
+		}
+		current.master = current.findMaster(true)
+		clusters = []*Cluster{current}
+		var ok bool
+		out := simStdout(t, func() { ok = checkSwitchover() })
+		if ok != tt.safe || strings.HasSuffix(strings.TrimSpace(out), tt.want) == false {
+			t.Errorf("%s: checkSwitchover() = %t, printed\n%s\nwant %t and %q", tt.name, ok, out, tt.safe, tt.want)
+		}
+		if tt.safe == false && strings.Contains(out, "FAIL default    binlog-format        db2:3306              binlog_format is MIXED, ROW on master") == false {
+			t.Errorf("%s: failed check of db2 not printed:\n%s", tt.name, out)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// promote.go
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"text/template"
+)
+
+/* Fields available to the post-promotion SQL template */
+type promotionData struct {
+	NewMaster string
+	OldMaster string
+	Cluster   string
+	Host      string
+	Port      string
+	Tags      []string
+}
+
+/* Runs the post-promotion SQL file of the cluster on the new master. The file is a text/template rendered with the promotion data, holding statements terminated by semicolons or by the delimiter of a DELIMITER line, like a mysql client script. Failed statements are logged but do not interrupt the promotion. */
+func (newMaster *ServerMonitor) runPromotionSQL(oldMaster *ServerMonitor, logf func(string, ...interface{})) {
+	file := newMaster.cluster.PromotionSQL
+	if file == "" {
+		return
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		logf("ERROR: Could not read post-promotion SQL file: %s", err)
+		return
+	}
+	tmpl, err := template.New("promotion").Parse(string(b))
+	if err != nil {
+		logf("ERROR: Could not parse post-promotion SQL template: %s", err)
+		return
+	}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, promotionData{NewMaster: newMaster.URL, OldMaster: oldMaster.URL, Cluster: newMaster.cluster.clusterName(), Host: newMaster.Host, Port: newMaster.Port, Tags: newMaster.cluster.clusterTags})
+	if err != nil {
+		logf("ERROR: Could not render post-promotion SQL template: %s", err)
+		return
+	}
+	logf("INFO : Running post-promotion SQL on %s", newMaster.URL)
+	for _, stmt := range splitSQL(buf.String()) {
+		err = newMaster.exec(stmt)
+		if err != nil {
+			logf("WARN : Post-promotion statement failed on %s: %s: %s", newMaster.URL, stmt, err)
+		} else if *verbose {
+			logf("DEBUG: Post-promotion statement executed: %s", stmt)
+		}
+	}
+}
+
+/* Splits a SQL script into statements the way the mysql client does. A statement ends with the delimiter outside of quotes and comments, so that strings holding semicolons stay whole. The delimiter is a semicolon until a DELIMITER line changes it, e.g. to define a stored routine whose body holds semicolons. Line comments are dropped. */
+func splitSQL(script string) []string {
+	var stmts []string
+	var cur strings.Builder
+	delim := ";"
+	end := func() {
+		if s := strings.TrimSpace(cur.String()); s != "" {
+			stmts = append(stmts, s)
+		}
+		cur.Reset()
+	}
+	for i := 0; i < len(script); {
+		rest := script[i:]
+		c := script[i]
+		switch {
+		case strings.TrimSpace(cur.String()) == "" && len(rest) > 10 && strings.EqualFold(rest[:9], "DELIMITER") && (rest[9] == ' ' || rest[9] == '\t'):
+			line := rest
+			if n := strings.IndexByte(rest, '\n'); n >= 0 {
+				line = rest[:n]
+			}
+			if d := strings.TrimSpace(line[10:]); d != "" {
+				delim = d
+			}
+			cur.Reset()
+			i += len(line)
+		case strings.HasPrefix(rest, delim):
+			end()
+			i += len(delim)
+		case c == '#' || strings.HasPrefix(rest, "-- ") || strings.HasPrefix(rest, "--\n") || rest == "--":
+			n := strings.IndexByte(rest, '\n')
+			if n < 0 {
+				n = len(rest)
+			}
+			i += n
+		case strings.HasPrefix(rest, "/*"):
+			n := strings.Index(rest[2:], "*/")
+			if n < 0 {
+				n = len(rest)
+			} else {
+				n += 4
+			}
+			cur.WriteString(rest[:n])
+			i += n
+		case c == '\'' || c == '"' || c == '`':
+			n := 1
+			for n < len(rest) && rest[n] != c {
+				if rest[n] == '\\' && c != '`' {
+					n++
+				}
+				n++
+			}
+			if n < len(rest) {
+				n++
+			} else {
+				n = len(rest)
+			}
+			cur.WriteString(rest[:n])
+			i += n
+		default:
+			cur.WriteByte(c)
+			i++
+		}
+	}
+	end()
+	return stmts
+}
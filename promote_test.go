@@ -0,0 +1,89 @@
+// promote_test.go
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSplitSQL(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string
+	}{
+		{"statements", "ANALYZE TABLE app.orders;\nSET GLOBAL event_scheduler=ON;\n", []string{"ANALYZE TABLE app.orders", "SET GLOBAL event_scheduler=ON"}},
+		{"last statement without delimiter", "SELECT 1;SELECT 2", []string{"SELECT 1", "SELECT 2"}},
+		{"semicolons in strings", "INSERT INTO t VALUES ('a;b', \"c;d\", 'it\\'s;');\nSELECT `x;y` FROM t;", []string{"INSERT INTO t VALUES ('a;b', \"c;d\", 'it\\'s;')", "SELECT `x;y` FROM t"}},
+		{"comments", "-- warm up; the cache\nSELECT 1; # done; really\n/* a; b */ SELECT 2;", []string{"SELECT 1", "/* a; b */ SELECT 2"}},
+		{"delimiter", "DELIMITER //\nCREATE PROCEDURE p() BEGIN SELECT 1; SELECT 2; END//\nDELIMITER ;\nCALL p();", []string{"CREATE PROCEDURE p() BEGIN SELECT 1; SELECT 2; END", "CALL p()"}},
+		{"empty", " ;\n; ", nil},
+	}
+	for _, tt := range tests {
+		got := splitSQL(tt.script)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") || len(got) != len(tt.want) {
+			t.Errorf("%s: splitSQL() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFailoverPromotionSQL(t *testing.T) {
+	defer func(f string) { *failover = f }(*failover)
+	*failover = "force"
+	file := filepath.Join(t.TempDir(), "promotion.sql")
+	script := "ANALYZE TABLE app.orders;\nINSERT INTO ops.promotions VALUES ('{{.Cluster}}', '{{.NewMaster}}', '{{.OldMaster}}', '{{.Port}}');\nSET GLOBAL event_scheduler=ON;\n"
+	if err := ioutil.WriteFile(file, []byte(script), 0600); err != nil {
+		t.Fatal(err)
+	}
+	sims := simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 1 }))
+	current.PromotionSQL, current.clusterTags = file, []string{"eu"}
+	// A failed statement is logged, the next ones still run and the promotion completes
+	sims["db3:3306"].fail = "ANALYZE"
+	current.master = current.findMaster(false)
+	nmUrl, err := current.Failover(context.Background())
+	if err != nil || nmUrl != "db3:3306" {
+		t.Fatalf("Failover() = %q, %v, want db3:3306", nmUrl, err)
+	}
+	want := []string{"ANALYZE TABLE app.orders", "INSERT INTO ops.promotions VALUES ('eu', 'db3:3306', 'db1:3306', '3306')", "SET GLOBAL event_scheduler=ON"}
+	var got []string
+	for _, e := range sims["db3:3306"].execs {
+		for _, w := range want {
+			if e == w {
+				got = append(got, e)
+			}
+		}
+	}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("post-promotion statements %q, want %q", got, want)
+	}
+	if sims["db3:3306"].vars["EVENT_SCHEDULER"] != "ON" {
+		t.Errorf("event scheduler %q on the new master, want ON", sims["db3:3306"].vars["EVENT_SCHEDULER"])
+	}
+	if sims["db2:3306"].ran("ANALYZE") {
+		t.Error("post-promotion SQL run on a slave")
+	}
+}
+
+func TestPromotionSQLPerCluster(t *testing.T) {
+	defer func(f string) { *failover = f }(*failover)
+	*failover = "force"
+	file := filepath.Join(t.TempDir(), "promotion.sql")
+	if err := ioutil.WriteFile(file, []byte("SET GLOBAL event_scheduler=ON;\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// Only the cluster with a post-promotion SQL file runs it
+	for _, promotion := range []string{"", file} {
+		sims := simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 1 }))
+		current.PromotionSQL = promotion
+		current.master = current.findMaster(false)
+		if nmUrl, err := current.Failover(context.Background()); err != nil || nmUrl != "db3:3306" {
+			t.Fatalf("Failover() = %q, %v, want db3:3306", nmUrl, err)
+		}
+		if sims["db3:3306"].ran("SET GLOBAL event_scheduler=ON") != (promotion != "") {
+			t.Errorf("post-promotion SQL %q: statements %q", promotion, sims["db3:3306"].execs)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// promotion_test.go
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTogglePromotion(t *testing.T) {
+	defer func(f, m, a string) {
+		*failover, *electionMode, *auditFile, selected = f, m, a, 0
+	}(*failover, *electionMode, *auditFile)
+	*failover, *electionMode = "force", "preferred"
+	*auditFile = filepath.Join(t.TempDir(), "audit.log")
+	tests := []struct {
+		name    string
+		ignore  []string
+		row     int
+		toggles string // keys pressed on the row, i to ignore and p to prefer
+		marks   string
+		elected string
+		audit   string
+	}{
+		{"master row", nil, 0, "ip", "", "db3:3306", ""},
+		{"ignored slave", nil, 2, "i", "I", "db2:3306", "Slave db3:3306 ignored in promotion"},
+		{"ignored slave restored", nil, 2, "ii", "", "db3:3306", "Slave db3:3306 no longer ignored in promotion"},
+		{"ignored through a pattern", []string{"db3*"}, 2, "i", "I", "db2:3306", ""},
+		{"preferred slave", nil, 1, "p", "P", "db2:3306", "Preferred master set to db2:3306 with weight 1"},
+		{"preference cleared", nil, 1, "pp", "", "db3:3306", "Preferred master db2:3306 cleared"},
+	}
+	for _, tt := range tests {
+		ioutil.WriteFile(*auditFile, nil, 0600)
+		simCluster(t, simTopology())
+		current.master = current.findMaster(true)
+		current.ignoreList, selected = tt.ignore, tt.row
+		sm := selectedServer()
+		for _, k := range tt.toggles {
+			if k == 'i' {
+				toggleIgnored()
+			} else {
+				togglePreferred()
+			}
+		}
+		if got := promotionMarks(sm); sm != current.master && got != tt.marks {
+			t.Errorf("%s: marks of %s %q, want %q", tt.name, sm.URL, got, tt.marks)
+		}
+		if tt.ignore == nil && current.IgnoreServers != strings.Join(current.ignoreList, ",") {
+			t.Errorf("%s: ignore-servers of the cluster %q, want %q", tt.name, current.IgnoreServers, strings.Join(current.ignoreList, ","))
+		}
+		got := ""
+		if key := current.master.electCandidate(current.slaves); key >= 0 {
+			got = current.slaves[key].URL
+		}
+		if got != tt.elected {
+			t.Errorf("%s: elected %q, want %q", tt.name, got, tt.elected)
+		}
+		b, _ := ioutil.ReadFile(*auditFile)
+		lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+		if last := lines[len(lines)-1]; tt.audit == "" && last != "" || strings.HasSuffix(last, tt.audit) == false {
+			t.Errorf("%s: last audit line %q, want %q", tt.name, last, tt.audit)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+// provision.go
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/tanji/mariadb-tools/dbhelper"
+	"log"
+	"net"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+/* Time a provisioned server is given to start and to begin replicating */
+const provisionWait = 120 * time.Second
+
+/* Returns an ssh command running a shell command on a host as the provisioning user */
+func provisionSSH(host string, cmd string) *exec.Cmd {
+	return exec.Command("ssh", "-o", "BatchMode=yes", "-o", "ConnectTimeout=5", *provSSHUser+"@"+host, cmd)
+}
+
+/* Runs a shell command on a host over ssh, or only logs it in dry-run mode */
+func provisionRun(host string, cmd string) (string, error) {
+	if *dryRun {
+		alertLog("DRY-RUN: [%s] %s", host, cmd)
+		return "", nil
+	}
+	out, err := provisionSSH(host, cmd).CombinedOutput()
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("%s on %s: %s: %s", strings.Fields(cmd)[0], host, err, strings.TrimSpace(string(out))))
+	}
+	return string(out), nil
+}
+
+/* Creates a slave on an empty node from a physical backup of the donor, the master unless -provision-donor is set. The backup is streamed from the donor to the node over ssh through the monitor host, prepared and started, then the node replicates from the master with GTID from the position of the backup. The server of the node must be stopped and its data directory empty. */
+func (c *Cluster) provision(url string) error {
+	if url == "" {
+		return errors.New("The server to provision must be given as host:port")
+	}
+	host, port := splitHostPort(url)
+	url = net.JoinHostPort(host, port)
+	if c.master.State == STATE_FAILED {
+		return errors.New("The master is down, cannot provision a slave")
+	}
+	donor := c.master
+	if *provDonor != "" {
+		donor = nil
+		for _, s := range c.servers {
+			if s.URL == *provDonor {
+				donor = s
+			}
+		}
+		if donor == nil || donor.State == STATE_FAILED {
+			return errors.New(fmt.Sprintf("Donor %s is not a reachable monitored server", *provDonor))
+		}
+	}
+	for _, s := range c.servers {
+		if s.Host == host && s.Port == port && s.State != STATE_FAILED {
+			return errors.New(fmt.Sprintf("Server %s is running, it must be stopped with an empty data directory", url))
+		}
+	}
+	stream := "mbstream"
+	if *provTool == "xtrabackup" {
+		stream = "xbstream"
+	}
+	dir := *provDatadir
+	log.Printf("INFO : Provisioning %s from a backup of %s", url, donor.URL)
+	audit("Provisioning %s from donor %s", url, donor.URL)
+	_, err := provisionRun(host, fmt.Sprintf("test -z \"$(ls -A %s 2>/dev/null)\" || { echo data directory %s is not empty; exit 1; }", dir, dir))
+	if err != nil {
+		return err
+	}
+	// The credentials reach the backup tool through a temporary option file of mode 0600, created by mktemp and written from the standard input, never on a command line
+	backup := fmt.Sprintf("f=$(mktemp) && trap 'rm -f $f' EXIT && cat > $f && %s --defaults-extra-file=$f --backup --stream=xbstream --target-dir=/tmp", *provTool)
+	restore := fmt.Sprintf("mkdir -p %s && %s -x -C %s", dir, stream, dir)
+	if *dryRun {
+		alertLog("DRY-RUN: would stream '%s' on %s to '%s' on %s", backup, donor.Host, restore, host)
+	} else {
+		err = provisionStream(donor.Host, backup, backupOptions(c.dbUser, c.dbPass), host, restore)
+		if err != nil {
+			audit("Provisioning of %s failed: %s", url, err)
+			return err
+		}
+	}
+	log.Printf("INFO : Backup of %s copied to %s, preparing it", donor.URL, url)
+	_, err = provisionRun(host, fmt.Sprintf("%s --prepare --target-dir=%s && chown -R mysql:mysql %s", *provTool, dir, dir))
+	if err != nil {
+		audit("Provisioning of %s failed: %s", url, err)
+		return err
+	}
+	info, err := provisionRun(host, "cat "+dir+"/xtrabackup_binlog_info")
+	if err != nil {
+		audit("Provisioning of %s failed: %s", url, err)
+		return err
+	}
+	// File, position and GTID position of the backup, the GTID set spanning several lines on MySQL
+	gtid := ""
+	if f := strings.Fields(info); len(f) >= 3 {
+		gtid = strings.Join(f[2:], "")
+	}
+	if gtid == "" && *dryRun == false {
+		return errors.New(fmt.Sprintf("No GTID position in the backup binlog info: %s", strings.TrimSpace(info)))
+	}
+	_, err = provisionRun(host, *provStart)
+	if err != nil {
+		audit("Provisioning of %s failed: %s", url, err)
+		return err
+	}
+	if *dryRun {
+		return nil
+	}
+	var sm *ServerMonitor
+	for deadline := time.Now().Add(provisionWait); ; {
+		sm, err = c.newServerMonitor(url)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			return errors.New(fmt.Sprintf("Server %s did not start: %s", url, err))
+		}
+		time.Sleep(2 * time.Second)
+	}
+	err = sm.attachAt(gtid)
+	if err != nil {
+		audit("Provisioning of %s failed: %s", url, err)
+		return errors.New(fmt.Sprintf("Could not attach %s to master %s: %s", url, c.master.URL, err))
+	}
+	log.Printf("INFO : Server %s provisioned and replicating from %s", url, c.master.URL)
+	audit("Provisioned %s from donor %s, replicating from master %s at %s", url, donor.URL, c.master.URL, gtid)
+	if contains(c.hostList, url) == false && contains(c.hostList, host) == false {
+		log.Printf("INFO : Add %s to the hosts option to monitor it", url)
+	}
+	return nil
+}
+
+/* Returns the option file passing the credentials to the backup tool, which reads the xtrabackup group */
+func backupOptions(user string, pass string) string {
+	quote := strings.NewReplacer("\\", "\\\\", "\"", "\\\"")
+	return fmt.Sprintf("[xtrabackup]\nuser=\"%s\"\npassword=\"%s\"\n", quote.Replace(user), quote.Replace(pass))
+}
+
+/* Pipes the output of a command run on one host, fed with the input, to a command run on another host */
+func provisionStream(from string, send string, input string, to string, receive string) error {
+	src := provisionSSH(from, send)
+	src.Stdin = strings.NewReader(input)
+	dst := provisionSSH(to, receive)
+	var srcErr, dstErr bytes.Buffer
+	src.Stderr = &srcErr
+	dst.Stderr = &dstErr
+	var err error
+	dst.Stdin, err = src.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	err = src.Start()
+	if err != nil {
+		return err
+	}
+	err = dst.Run()
+	werr := src.Wait()
+	if werr != nil {
+		return errors.New(fmt.Sprintf("streaming backup from %s: %s: %s", from, werr, lastLine(srcErr.String())))
+	}
+	if err != nil {
+		return errors.New(fmt.Sprintf("extracting backup on %s: %s: %s", to, err, lastLine(dstErr.String())))
+	}
+	return nil
+}
+
+/* Returns the last non empty line of a command output, backup tools being verbose */
+func lastLine(s string) string {
+	l := strings.Split(strings.TrimSpace(s), "\n")
+	return l[len(l)-1]
+}
+
+/* Points a server restored from a backup at the master, from the GTID position of the backup, starts replication and sets it read-only */
+func (sm *ServerMonitor) attachAt(gtid string) error {
+	sm.run("STOP SLAVE", dbhelper.StopSlave)
+	var stmts []string
+	if sm.Flavor == FLAVOR_MYSQL {
+		stmts = []string{"RESET MASTER", "SET GLOBAL gtid_purged='" + gtid + "'"}
+	} else {
+		stmts = []string{"SET GLOBAL gtid_slave_pos='" + gtid + "'"}
+	}
+	stmts = append(stmts, "CHANGE MASTER TO master_host='"+sm.cluster.master.IP+"', master_port="+sm.cluster.master.Port+", master_user='"+sm.cluster.rplUser+"', master_password='"+sm.cluster.rplPass+"'"+sm.gtidMasterOpt("slave_pos"))
+	for _, stmt := range stmts {
+		err := sm.exec(stmt)
+		if err != nil {
+			return err
+		}
+	}
+	err := sm.run("START SLAVE", dbhelper.StartSlave)
+	if err == nil {
+		err = sm.run("SET GLOBAL read_only=1", setReadOnly(true))
+	}
+	if err == nil {
+		err = sm.waitReplicating(provisionWait)
+	}
+	return err
+}
@@ -0,0 +1,102 @@
+// provision_test.go
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/* Puts on the path an ssh command playing the donor and the new node: it records the commands, keeps the credentials and the stream it receives, answers the binlog info of the backup and fails the commands containing the failure pattern */
+func simProvisionSSH(t *testing.T, fail string, binlogInfo string) string {
+	dir := t.TempDir()
+	script := `#!/bin/sh
+echo "$5 $6" >> ` + dir + `/ssh.log
+if [ -n "` + fail + `" ]; then
+	case "$6" in *"` + fail + `"*) echo "simulated failure"; exit 1;; esac
+fi
+case "$6" in
+*--backup*) cat > ` + dir + `/creds; echo BACKUP;;
+*" -x -C "*) cat > ` + dir + `/stream;;
+*xtrabackup_binlog_info) echo "` + binlogInfo + `";;
+esac
+exit 0
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "ssh"), []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return dir
+}
+
+func TestProvision(t *testing.T) {
+	defer func(p, d string, dr bool) {
+		os.Setenv("PATH", p)
+		*provDonor, *dryRun = d, dr
+	}(os.Getenv("PATH"), *provDonor, *dryRun)
+	tests := []struct {
+		name   string
+		url    string
+		donor  string
+		fail   string
+		info   string
+		dryRun bool
+		error  string
+		ssh    []string // commands run over ssh, by prefix, in any order as the donor and the new node stream concurrently
+	}{
+		{"from the master", "db4", "", "", "mysql-bin.000003 1024 0-1-118", false, "", []string{"root@db4 test -z", "root@db1 f=$(mktemp)", "root@db4 mkdir -p /var/lib/mysql && mbstream -x -C /var/lib/mysql", "root@db4 mariabackup --prepare", "root@db4 cat /var/lib/mysql/xtrabackup_binlog_info", "root@db4 systemctl start mariadb"}},
+		{"from a donor", "db4:3306", "db3:3306", "", "mysql-bin.000003 1024 0-1-118", false, "", []string{"root@db4 test -z", "root@db3 f=$(mktemp)"}},
+		{"unknown donor", "db4:3306", "db9:3306", "", "", false, "Donor db9:3306 is not a reachable monitored server", nil},
+		{"running server", "db2:3306", "", "", "", false, "Server db2:3306 is running, it must be stopped with an empty data directory", nil},
+		{"data directory not empty", "db4:3306", "", "test -z", "", false, "test on db4: exit status 1: simulated failure", []string{"root@db4 test -z"}},
+		{"backup failing", "db4:3306", "", "--backup", "", false, "streaming backup from db1: exit status 1", []string{"root@db4 test -z", "root@db1 f=$(mktemp)"}},
+		{"no GTID position", "db4:3306", "", "", "mysql-bin.000003 1024", false, "No GTID position in the backup binlog info: mysql-bin.000003 1024", nil},
+		{"dry run", "db4:3306", "", "", "", true, "", nil},
+	}
+	for _, tt := range tests {
+		dir := simProvisionSSH(t, tt.fail, tt.info)
+		*provDonor, *dryRun = tt.donor, tt.dryRun
+		simCluster(t, simTopology())
+		current.dbUser, current.dbPass = "repmgr", `pa"ss`
+		current.master = current.findMaster(true)
+		node := &simServer{vars: map[string]string{"SERVER_ID": "4", "READ_ONLY": "OFF"}}
+		simBackends["db4:3306"] = node
+		err := current.provision(tt.url)
+		if (err == nil && tt.error != "") || (err != nil && (tt.error == "" || strings.HasPrefix(err.Error(), tt.error) == false)) {
+			t.Errorf("%s: provision() = %v, want %q", tt.name, err, tt.error)
+		}
+		b, _ := ioutil.ReadFile(filepath.Join(dir, "ssh.log"))
+		cmds := strings.Split(strings.TrimSpace(string(b)), "\n")
+		for _, want := range tt.ssh {
+			found := false
+			for _, cmd := range cmds {
+				found = found || strings.HasPrefix(cmd, want)
+			}
+			if found == false {
+				t.Errorf("%s: ssh commands %q, want %q", tt.name, cmds, want)
+			}
+		}
+		if tt.dryRun && len(b) > 0 {
+			t.Errorf("%s: ssh commands run in dry-run mode: %q", tt.name, cmds)
+		}
+		if tt.error != "" || tt.dryRun {
+			if len(node.execs) > 0 {
+				t.Errorf("%s: node attached: %q", tt.name, node.execs)
+			}
+			continue
+		}
+		// The credentials reach the backup tool through its standard input only
+		creds, _ := ioutil.ReadFile(filepath.Join(dir, "creds"))
+		if string(creds) != "[xtrabackup]\nuser=\"repmgr\"\npassword=\"pa\\\"ss\"\n" || strings.Contains(string(b), "pa\"ss") {
+			t.Errorf("%s: credentials %q, ssh commands %q", tt.name, creds, cmds)
+		}
+		if stream, _ := ioutil.ReadFile(filepath.Join(dir, "stream")); string(stream) != "BACKUP\n" {
+			t.Errorf("%s: stream received %q, want the backup", tt.name, stream)
+		}
+		if node.vars["GTID_SLAVE_POS"] != "0-1-118" || node.status == nil || node.status.Master_Host != "db1" || node.status.Slave_SQL_Running != "Yes" || node.vars["READ_ONLY"] != "ON" {
+			t.Errorf("%s: node not attached at the backup position: %q", tt.name, node.execs)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+// query.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"github.com/jmoiron/sqlx"
+)
+
+/* Runs a query and returns every row as a column name to value map. Used for SHOW statements whose columns vary across server versions. */
+func queryRows(db *sqlx.DB, query string, args ...interface{}) ([]map[string]string, error) {
+	return queryRowsContext(context.Background(), db, query, args...)
+}
+
+/* Runs a query cancelled when the context ends and returns every row as a column name to value map */
+func queryRowsContext(ctx context.Context, db *sqlx.DB, query string, args ...interface{}) ([]map[string]string, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var res []map[string]string
+	for rows.Next() {
+		vals := make([]sql.NullString, len(cols))
+		ptrs := make([]interface{}, len(cols))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		err = rows.Scan(ptrs...)
+		if err != nil {
+			return nil, err
+		}
+		row := make(map[string]string, len(cols))
+		for i, c := range cols {
+			row[c] = vals[i].String
+		}
+		res = append(res, row)
+	}
+	return res, rows.Err()
+}
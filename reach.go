@@ -0,0 +1,72 @@
+// reach.go
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+/* Records the position the IO thread of the slave received up to, and when it last moved */
+func (sm *ServerMonitor) trackRead(file string, pos uint64) {
+	if file != sm.ReadFile || pos != sm.ReadPos {
+		sm.readMoved = time.Now()
+	}
+	sm.ReadFile, sm.ReadPos = file, pos
+}
+
+/* Returns the seconds since the slave received the last heartbeat of its master and the heartbeat period, read from the status variables on MariaDB and performance_schema on MySQL */
+func (sm *ServerMonitor) heartbeatAge() (int64, float64, bool) {
+	q := "SELECT TIMESTAMPDIFF(SECOND, (SELECT VARIABLE_VALUE FROM information_schema.GLOBAL_STATUS WHERE VARIABLE_NAME = 'SLAVE_LAST_HEARTBEAT'), NOW()) AS age, " +
+		"(SELECT VARIABLE_VALUE FROM information_schema.GLOBAL_STATUS WHERE VARIABLE_NAME = 'SLAVE_HEARTBEAT_PERIOD') AS period"
+	if sm.Flavor == FLAVOR_MYSQL {
+		q = "SELECT TIMESTAMPDIFF(SECOND, s.LAST_HEARTBEAT_TIMESTAMP, NOW()) AS age, c.HEARTBEAT_INTERVAL AS period " +
+			"FROM performance_schema.replication_connection_status s JOIN performance_schema.replication_connection_configuration c USING (CHANNEL_NAME) " +
+			"WHERE s.LAST_HEARTBEAT_TIMESTAMP > '1970-01-02' LIMIT 1"
+	}
+	rows, err := sm.query(q)
+	if err != nil || len(rows) == 0 {
+		return 0, 0, false
+	}
+	// NULL values, before the first heartbeat, do not parse
+	age, err := strconv.ParseInt(rows[0]["age"], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	period, err := strconv.ParseFloat(rows[0]["period"], 64)
+	if err != nil || period <= 0 {
+		return 0, 0, false
+	}
+	return age, period, true
+}
+
+/* Returns true if the slave still replicates from the master: its IO thread is running and it received events, or a heartbeat when the master is idle, recently enough */
+func (sm *ServerMonitor) seesMaster() bool {
+	if sm.IOThread != "Yes" {
+		return false
+	}
+	window := 2 * monitorInterval
+	if sm.readMoved.IsZero() == false && time.Since(sm.readMoved) <= window {
+		return true
+	}
+	age, period, ok := sm.heartbeatAge()
+	return ok && time.Duration(age)*time.Second <= time.Duration(period*float64(time.Second))+window
+}
+
+/* Asks the slaves reachable from the monitor whether they still replicate from the master. When a majority of them does, the master failure is most likely a network issue between the monitor and the master, and failover is denied. */
+func (c *Cluster) slavesSeeMaster() (bool, string) {
+	seen, total := 0, 0
+	for _, sl := range c.slaves {
+		if sl.State == STATE_FAILED || sl.inMaintenance() || sl.MasterServerId != c.master.ServerId {
+			continue
+		}
+		total++
+		if sl.seesMaster() {
+			seen++
+		}
+	}
+	if total > 0 && seen*2 > total {
+		return true, fmt.Sprintf("master still replicates to %d of %d slaves, the failure looks local to the monitor", seen, total)
+	}
+	return false, ""
+}
@@ -0,0 +1,87 @@
+// reach_test.go
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+const simHeartbeat = "SELECT TIMESTAMPDIFF(SECOND, "
+
+func TestSeesMaster(t *testing.T) {
+	tests := []struct {
+		name      string
+		flavor    string
+		io        string
+		moved     time.Duration // since the IO thread last received events, 0 if never
+		heartbeat map[string]string
+		want      bool
+	}{
+		{"receiving events", FLAVOR_MARIADB, "Yes", time.Second, nil, true},
+		{"IO thread stopped", FLAVOR_MARIADB, "No", time.Second, nil, false},
+		{"IO thread connecting", FLAVOR_MARIADB, "Connecting", time.Second, nil, false},
+		{"no events nor heartbeat", FLAVOR_MARIADB, "Yes", time.Minute, nil, false},
+		{"idle master heartbeat", FLAVOR_MARIADB, "Yes", time.Minute, map[string]string{"age": "3", "period": "5.000"}, true},
+		{"idle master heartbeat on MySQL", FLAVOR_MYSQL, "Yes", 0, map[string]string{"age": "3", "period": "5.000"}, true},
+		{"heartbeat late", FLAVOR_MARIADB, "Yes", time.Minute, map[string]string{"age": "30", "period": "5.000"}, false},
+		{"no heartbeat received", FLAVOR_MARIADB, "Yes", time.Minute, map[string]string{"age": "", "period": "5.000"}, false},
+		{"heartbeat disabled", FLAVOR_MARIADB, "Yes", time.Minute, map[string]string{"age": "3", "period": "0.000"}, false},
+	}
+	for _, tt := range tests {
+		sims := simCluster(t, simTopology())
+		if tt.heartbeat != nil {
+			sims["db2:3306"].rows = map[string][]map[string]string{simHeartbeat: {tt.heartbeat}}
+		}
+		sm := simServerByURL("db2:3306")
+		sm.Flavor, sm.IOThread, sm.readMoved = tt.flavor, tt.io, time.Time{}
+		if tt.moved != 0 {
+			sm.readMoved = time.Now().Add(-tt.moved)
+		}
+		if got := sm.seesMaster(); got != tt.want {
+			t.Errorf("%s: seesMaster() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	// The position received by the IO thread moves at the refresh
+	sims := simCluster(t, simTopology())
+	sm := simServerByURL("db2:3306")
+	sm.readMoved = time.Time{}
+	sims["db2:3306"].status.Master_Log_File, sims["db2:3306"].status.Read_Master_Log_Pos = "mysql-bin.000002", 4096
+	sm.refresh()
+	if sm.seesMaster() == false {
+		t.Error("seesMaster() = false after the IO thread received events")
+	}
+}
+
+func TestFailoverCheckSlaves(t *testing.T) {
+	defer func(c bool) { *checkSlaves = c }(*checkSlaves)
+	tests := []struct {
+		name   string
+		check  bool
+		seeing []string // slaves still receiving events from the master
+		down   string   // slave unreachable from the monitor
+		want   bool
+	}{
+		{"all slaves replicating", true, []string{"db2:3306", "db3:3306"}, "", false},
+		{"half of the slaves replicating", true, []string{"db2:3306"}, "", true},
+		{"no slave replicating", true, nil, "", true},
+		{"only reachable slave replicating", true, []string{"db2:3306"}, "db3:3306", false},
+		{"check disabled", false, []string{"db2:3306", "db3:3306"}, "", true},
+	}
+	for _, tt := range tests {
+		*checkSlaves = tt.check
+		simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 1 || sp.url == tt.down }))
+		current.master = current.findMaster(false)
+		// The server id of the failed master is known from its last refresh
+		current.master.ServerId = 1
+		for _, sl := range current.slaves {
+			sl.IOThread, sl.readMoved = "Connecting", time.Time{}
+		}
+		for _, url := range tt.seeing {
+			sm := simServerByURL(url)
+			sm.IOThread, sm.readMoved = "Yes", time.Now()
+		}
+		if got := current.arbitrate(); got != tt.want || current.arbitrationDenied == tt.want {
+			t.Errorf("%s: arbitrate() = %v, denied %v, want %v", tt.name, got, current.arbitrationDenied, tt.want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+// readonly.go
+package main
+
+/* Sets read_only on slaves that lost it and clears it on the master, e.g. after a server restarted with the configuration file defaults */
+func (c *Cluster) readonlyCheck() {
+	if *forceReadonly == false || c.master == nil || automationFrozen() || *dryRun {
+		return
+	}
+	if c.master.State != STATE_FAILED && c.master.ReadOnly == "ON" && c.master.inMaintenance() == false {
+		alertLog("WARN : Master %s is read-only, clearing read_only", c.master.label())
+		err := c.master.run("SET GLOBAL read_only=0", setReadOnly(false))
+		if err != nil {
+			alertLog("ERROR: Could not clear read_only on master %s: %s", c.master.URL, err)
+		}
+	}
+	for _, sl := range c.slaves {
+		if sl.State == STATE_FAILED || sl.URL == c.master.URL || sl.ReadOnly != "OFF" || sl.inMaintenance() || sl.isGalera() {
+			continue
+		}
+		alertLog("WARN : Slave %s is writable, setting read_only", sl.label())
+		err := sl.run("SET GLOBAL read_only=1", setReadOnly(true))
+		if err != nil {
+			alertLog("ERROR: Could not set read_only on slave %s: %s", sl.URL, err)
+		}
+	}
+}
+
+/* Returns the read_only misconfiguration of the server for its role, empty if none: a read-only master rejects the application writes, and a writable slave accepts writes diverging from the master */
+func (sm *ServerMonitor) readonlyIssue() string {
+	if sm.State == STATE_FAILED || sm.ReadOnly == "" {
+		return ""
+	}
+	if sm == sm.cluster.master {
+		if sm.SuperReadOnly == "ON" {
+			return "master has super_read_only set"
+		}
+		if sm.ReadOnly == "ON" {
+			return "master is read-only"
+		}
+		return ""
+	}
+	// Galera nodes take writes from their own clients even when they replicate from a master
+	if sm.UsingGtid != "" && sm.ReadOnly == "OFF" && sm.isGalera() == false {
+		return "slave is writable"
+	}
+	return ""
+}
+
+/* Returns the read-only column of the console, SRO when super_read_only is set */
+func (sm *ServerMonitor) readonlyLabel() string {
+	if sm.SuperReadOnly == "ON" {
+		return "SRO"
+	}
+	return sm.ReadOnly
+}
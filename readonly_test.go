@@ -0,0 +1,140 @@
+// readonly_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadonlyCheck(t *testing.T) {
+	defer func(f, d bool) { *forceReadonly, *dryRun = f, d }(*forceReadonly, *dryRun)
+	tests := []struct {
+		name        string
+		force       bool
+		dryRun      bool
+		maintenance string
+		specs       []simSpec
+		want        map[string]string // statement expected on each server, empty for none
+	}{
+		{"drift corrected", true, false, "", simTopology(),
+			map[string]string{"db1:3306": "SET GLOBAL read_only=0", "db2:3306": "SET GLOBAL read_only=1", "db3:3306": ""}},
+		{"guardian disabled", false, false, "", simTopology(),
+			map[string]string{"db1:3306": "", "db2:3306": "", "db3:3306": ""}},
+		{"dry run", true, true, "", simTopology(),
+			map[string]string{"db1:3306": "", "db2:3306": "", "db3:3306": ""}},
+		{"slave in maintenance", true, false, "db2:3306", simTopology(),
+			map[string]string{"db1:3306": "SET GLOBAL read_only=0", "db2:3306": "", "db3:3306": ""}},
+		{"slave down", true, false, "", simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 2 }),
+			map[string]string{"db1:3306": "SET GLOBAL read_only=0", "db2:3306": "", "db3:3306": ""}},
+	}
+	for _, tt := range tests {
+		*forceReadonly, *dryRun = tt.force, false
+		sims := simCluster(t, tt.specs)
+		// db1 restarted read-only and db2 writable, with the defaults of their configuration files
+		sims["db1:3306"].vars["READ_ONLY"], sims["db2:3306"].vars["READ_ONLY"] = "ON", "OFF"
+		for _, s := range current.servers {
+			s.refresh()
+		}
+		current.master = current.findMaster(true)
+		if tt.maintenance != "" {
+			current.stateData.Maintenance = []string{tt.maintenance}
+		}
+		*dryRun = tt.dryRun
+		current.readonlyCheck()
+		for url, stmt := range tt.want {
+			if stmt == "" && len(sims[url].execs) > 0 {
+				t.Errorf("%s: %s ran %q, want nothing", tt.name, url, sims[url].execs)
+			} else if stmt != "" && sims[url].ran(stmt) == false {
+				t.Errorf("%s: %s ran %q, want %s", tt.name, url, sims[url].execs, stmt)
+			}
+		}
+	}
+}
+
+func TestReadonlyIssue(t *testing.T) {
+	tests := []struct {
+		name   string
+		url    string
+		ro     string
+		sro    string
+		galera bool
+		want   string
+	}{
+		{"master writable", "db1:3306", "OFF", "OFF", false, ""},
+		{"master read-only", "db1:3306", "ON", "OFF", false, "master is read-only"},
+		{"master super read-only", "db1:3306", "ON", "ON", false, "master has super_read_only set"},
+		{"slave read-only", "db2:3306", "ON", "OFF", false, ""},
+		{"slave writable", "db2:3306", "OFF", "OFF", false, "slave is writable"},
+		{"galera node writable", "db2:3306", "OFF", "OFF", true, ""},
+	}
+	for _, tt := range tests {
+		sims := simCluster(t, simTopology())
+		sims[tt.url].vars["READ_ONLY"], sims[tt.url].vars["SUPER_READ_ONLY"] = tt.ro, tt.sro
+		if tt.galera {
+			sims[tt.url].vars["WSREP_ON"] = "ON"
+			sims[tt.url].rows = map[string][]map[string]string{"SELECT UPPER(VARIABLE_NAME) AS name, VARIABLE_VALUE AS value FROM information_schema.GLOBAL_STATUS": {
+				{"name": "WSREP_CLUSTER_STATE_UUID", "value": "6c5e1c8a-0f3e-11ef-9a4b-0242ac120002"}}}
+		}
+		sm := simServerByURL(tt.url)
+		sm.refresh()
+		current.master = simServerByURL("db1:3306")
+		if got := sm.readonlyIssue(); got != tt.want {
+			t.Errorf("%s: readonlyIssue() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReadonlyMismatch(t *testing.T) {
+	sims := simCluster(t, simTopology())
+	current.master = current.findMaster(true)
+	current.master.State = STATE_MASTER
+	// The master restarted with super_read_only and db2 writable, both picked up by the next refresh
+	sims["db1:3306"].vars["READ_ONLY"], sims["db1:3306"].vars["SUPER_READ_ONLY"] = "ON", "ON"
+	sims["db2:3306"].vars["READ_ONLY"], sims["db2:3306"].vars["SUPER_READ_ONLY"] = "OFF", "OFF"
+	for _, s := range current.servers {
+		s.refresh()
+	}
+	want := map[string]struct{ ro, sro, label, issue string }{
+		"db1:3306": {"ON", "ON", "SRO", "master has super_read_only set"},
+		"db2:3306": {"OFF", "OFF", "OFF", "slave is writable"},
+		"db3:3306": {"ON", "", "ON", ""},
+	}
+	for url, w := range want {
+		sm := simServerByURL(url)
+		if sm.ReadOnly != w.ro || sm.SuperReadOnly != w.sro || sm.readonlyLabel() != w.label || sm.readonlyIssue() != w.issue {
+			t.Errorf("%s: read_only %q, super_read_only %q, label %q, issue %q, want %+v", url, sm.ReadOnly, sm.SuperReadOnly, sm.readonlyLabel(), sm.readonlyIssue(), w)
+		}
+	}
+	w := httptest.NewRecorder()
+	current.apiServers(w, httptest.NewRequest("GET", "/api/servers", nil))
+	var servers []apiServer
+	json.NewDecoder(w.Body).Decode(&servers)
+	if len(servers) != len(want) {
+		t.Fatalf("API servers %+v, want %d", servers, len(want))
+	}
+	for _, s := range servers {
+		if s.ReadOnly != want[s.URL].ro || s.SuperReadOnly != want[s.URL].sro || s.ReadOnlyIssue != want[s.URL].issue {
+			t.Errorf("%s: API read_only %q, super_read_only %q, issue %q, want %+v", s.URL, s.ReadOnly, s.SuperReadOnly, s.ReadOnlyIssue, want[s.URL])
+		}
+	}
+	for _, s := range current.buildReport().Servers {
+		if s.ReadOnlyIssue != want[s.URL].issue {
+			t.Errorf("%s: report issue %q, want %q", s.URL, s.ReadOnlyIssue, want[s.URL].issue)
+		}
+	}
+	issues := strings.Join(current.clusterHealth().Issues, "\n")
+	if strings.Contains(issues, "master has super_read_only set") == false {
+		t.Errorf("health issues %q, want the read-only master", issues)
+	}
+	// Clearing the settings clears the issues at the next refresh
+	sims["db1:3306"].vars["READ_ONLY"], sims["db1:3306"].vars["SUPER_READ_ONLY"] = "OFF", "OFF"
+	sims["db2:3306"].vars["READ_ONLY"] = "ON"
+	for _, s := range current.servers {
+		s.refresh()
+		if e := s.readonlyIssue(); e != "" {
+			t.Errorf("%s: issue %q left after the fix", s.URL, e)
+		}
+	}
+}
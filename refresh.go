@@ -0,0 +1,54 @@
+// refresh.go
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+var errRefreshTimeout = errors.New("server did not answer within the monitor interval")
+
+type refreshResult struct {
+	key int
+	err error
+}
+
+/* Refreshes servers concurrently and returns their errors in the same order. The queries of each refresh are cancelled when the monitor interval elapses or the context is cancelled, and every refresh has returned when this returns, so that a hung server never stalls the monitor loop and no refresh outlives its cycle. A server cut off by the deadline gets a timeout error for this cycle. */
+func refreshAll(ctx context.Context, l []*ServerMonitor) []error {
+	errs := make([]error, len(l))
+	results := make(chan refreshResult, len(l))
+	first := make(map[*ServerMonitor]int)
+	ctx, cancel := context.WithTimeout(ctx, monitorInterval)
+	defer cancel()
+	pending := 0
+	for k, sm := range l {
+		if _, ok := first[sm]; ok {
+			continue
+		}
+		first[sm] = k
+		pending++
+		go func(k int, sm *ServerMonitor) {
+			results <- refreshResult{k, sm.refreshContext(ctx)}
+		}(k, sm)
+	}
+	for ; pending > 0; pending-- {
+		r := <-results
+		errs[r.key] = r.err
+	}
+	// A server listed twice shares the result of its first refresh
+	for k, sm := range l {
+		errs[k] = errs[first[sm]]
+	}
+	return errs
+}
+
+/* Refreshes the server with its queries bounded by the context */
+func (sm *ServerMonitor) refreshContext(ctx context.Context) error {
+	sm.refreshCtx = ctx
+	defer func() { sm.refreshCtx = nil }()
+	err := sm.refresh()
+	if err != nil && ctx.Err() != nil {
+		return errRefreshTimeout
+	}
+	return err
+}
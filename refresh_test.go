@@ -0,0 +1,52 @@
+// refresh_test.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestRefreshAll(t *testing.T) {
+	tests := []struct {
+		name  string
+		wait  map[string]time.Duration
+		down  string
+		urls  []string
+		want  []error // the master is not a slave
+		limit time.Duration
+	}{
+		{"all answer", nil, "", []string{"db1:3306", "db2:3306", "db3:3306"}, []error{sql.ErrNoRows, nil, nil}, 100 * time.Millisecond},
+		{"slow servers refreshed concurrently", map[string]time.Duration{"db2:3306": 150 * time.Millisecond, "db3:3306": 150 * time.Millisecond},
+			"", []string{"db1:3306", "db2:3306", "db3:3306"}, []error{sql.ErrNoRows, nil, nil}, 250 * time.Millisecond},
+		{"server down", nil, "db2:3306", []string{"db1:3306", "db2:3306", "db3:3306"}, []error{sql.ErrNoRows, errSimDown, nil}, 100 * time.Millisecond},
+		{"hung server cut off", map[string]time.Duration{"db3:3306": 400 * time.Millisecond}, "db3:3306",
+			[]string{"db1:3306", "db2:3306", "db3:3306"}, []error{sql.ErrNoRows, nil, errRefreshTimeout}, 500 * time.Millisecond},
+		{"server listed twice", nil, "db2:3306", []string{"db2:3306", "db1:3306", "db2:3306"}, []error{errSimDown, sql.ErrNoRows, errSimDown}, 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		sims := simCluster(t, simTopology())
+		monitorInterval = 200 * time.Millisecond
+		for url, d := range tt.wait {
+			sims[url].wait = d
+		}
+		if tt.down != "" {
+			sims[tt.down].down = true
+		}
+		var l []*ServerMonitor
+		for _, url := range tt.urls {
+			l = append(l, simServerByURL(url))
+		}
+		start := time.Now()
+		errs := refreshAll(context.Background(), l)
+		if d := time.Since(start); d > tt.limit {
+			t.Errorf("%s: refreshAll() took %s, want less than %s", tt.name, d, tt.limit)
+		}
+		for k := range errs {
+			if errs[k] != tt.want[k] {
+				t.Errorf("%s: refresh of %s = %v, want %v", tt.name, tt.urls[k], errs[k], tt.want[k])
+			}
+		}
+	}
+}
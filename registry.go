@@ -0,0 +1,119 @@
+// registry.go
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* Publishes the master and slave endpoints to the service registry when the topology changed since the last successful publication */
+func (c *Cluster) registryCheck() {
+	if *registry == "" || c.master == nil || isStandby() || *dryRun {
+		return
+	}
+	var sl []string
+	for _, s := range c.slaves {
+		if s.State != STATE_FAILED && s.URL != c.master.URL {
+			sl = append(sl, s.URL)
+		}
+	}
+	sort.Strings(sl)
+	m := c.master.URL
+	if c.master.State == STATE_FAILED {
+		m = ""
+	}
+	topo := m + " " + strings.Join(sl, ",")
+	if topo == c.published {
+		return
+	}
+	var err error
+	if *registry == "consul" {
+		err = c.consulPublish(m, sl)
+	} else {
+		err = c.etcdPublish(m, sl)
+	}
+	if err != nil {
+		alertLog("WARN : Could not publish topology to %s: %s", *registry, err)
+		return
+	}
+	alertLog("INFO : Published topology to %s, master %s", *registry, m)
+	c.published = topo
+}
+
+/* Registers each server as an instance of the service in the local Consul agent, tagged with its role. Servers that are down are registered without role tag so that health-aware clients skip them. */
+func (c *Cluster) consulPublish(m string, sl []string) error {
+	for _, s := range c.knownServers() {
+		var tags []string
+		switch {
+		case s.URL == m:
+			tags = []string{"master"}
+		case contains(sl, s.URL):
+			tags = []string{"slave"}
+		}
+		tags = append(tags, c.clusterTags...)
+		port, _ := strconv.Atoi(s.Port)
+		svc := map[string]interface{}{
+			"ID":      *registryName + "-" + strings.Replace(s.URL, ":", "-", -1),
+			"Name":    *registryName,
+			"Tags":    tags,
+			"Address": s.Host,
+			"Port":    port,
+			"Meta":    map[string]string{"cluster": c.clusterName()},
+		}
+		err := registryRequest("PUT", "/v1/agent/service/register", svc)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/* Writes the master endpoint and the comma separated slave endpoints under the key prefix through the etcd v3 JSON gateway */
+func (c *Cluster) etcdPublish(m string, sl []string) error {
+	prefix := strings.TrimSuffix(*registryName, "/")
+	if *clustersFile != "" {
+		prefix += "/" + c.clusterName()
+	}
+	err := etcdPut(prefix+"/master", m)
+	if err != nil {
+		return err
+	}
+	return etcdPut(prefix+"/slaves", strings.Join(sl, ","))
+}
+
+func etcdPut(key string, value string) error {
+	return registryRequest("POST", "/v3/kv/put", map[string]string{
+		"key":   base64.StdEncoding.EncodeToString([]byte(key)),
+		"value": base64.StdEncoding.EncodeToString([]byte(value)),
+	})
+}
+
+func registryRequest(method string, path string, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(*registryAddr, "/")+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return errors.New(fmt.Sprintf("%s %s returned %s", method, path, resp.Status))
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+// registry_test.go
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+/* Service registry recording the Consul registrations as id=tags and the etcd keys as key=value */
+type simRegistry struct {
+	sync.Mutex
+	entries map[string]string
+	calls   int
+	fail    bool
+}
+
+func (sr *simRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	sr.Lock()
+	defer sr.Unlock()
+	sr.calls++
+	if sr.fail {
+		http.Error(w, "agent unavailable", http.StatusInternalServerError)
+		return
+	}
+	switch r.URL.Path {
+	case "/v1/agent/service/register":
+		var svc struct {
+			ID   string
+			Tags []string
+		}
+		json.NewDecoder(r.Body).Decode(&svc)
+		sr.entries[svc.ID] = strings.Join(svc.Tags, ",")
+	case "/v3/kv/put":
+		var kv map[string]string
+		json.NewDecoder(r.Body).Decode(&kv)
+		k, _ := base64.StdEncoding.DecodeString(kv["key"])
+		v, _ := base64.StdEncoding.DecodeString(kv["value"])
+		sr.entries[string(k)] = string(v)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestRegistryConsul(t *testing.T) {
+	defer func(r, a, f string) { *registry, *registryAddr, *failover = r, a, f }(*registry, *registryAddr, *failover)
+	sr := &simRegistry{entries: make(map[string]string)}
+	srv := httptest.NewServer(sr)
+	defer srv.Close()
+	*registry, *registryAddr, *failover = "consul", srv.URL, "force"
+	sims := simCluster(t, simTopology())
+	current.master = current.findMaster(true)
+	current.master.State = STATE_MASTER
+	current.registryCheck()
+	want := map[string]string{"mariadb-db1-3306": "master", "mariadb-db2-3306": "slave", "mariadb-db3-3306": "slave"}
+	if reflect.DeepEqual(sr.entries, want) == false {
+		t.Errorf("registrations %v, want %v", sr.entries, want)
+	}
+	// Nothing is published while the topology does not change
+	calls := sr.calls
+	current.registryCheck()
+	if sr.calls != calls {
+		t.Errorf("%d requests for an unchanged topology", sr.calls-calls)
+	}
+
+	// The promoted master is published, the failed one loses its role
+	sims["db1:3306"].down = true
+	current.master.State = STATE_FAILED
+	if _, err := current.Failover(context.Background()); err != nil {
+		t.Fatalf("Failover() = %s", err)
+	}
+	sr.fail = true
+	current.registryCheck()
+	sr.fail = false
+	// A failed publication is retried at the next check
+	current.registryCheck()
+	want = map[string]string{"mariadb-db1-3306": "", "mariadb-db2-3306": "slave", "mariadb-db3-3306": "master"}
+	if reflect.DeepEqual(sr.entries, want) == false {
+		t.Errorf("registrations %v after failover, want %v", sr.entries, want)
+	}
+}
+
+func TestRegistryEtcd(t *testing.T) {
+	defer func(r, a, n string) { *registry, *registryAddr, *registryName = r, a, n }(*registry, *registryAddr, *registryName)
+	sr := &simRegistry{entries: make(map[string]string)}
+	srv := httptest.NewServer(sr)
+	defer srv.Close()
+	*registry, *registryAddr, *registryName = "etcd", srv.URL, "/db/sim/"
+	simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 2 }))
+	current.master = current.findMaster(true)
+	current.registryCheck()
+	want := map[string]string{"/db/sim/master": "db1:3306", "/db/sim/slaves": "db3:3306"}
+	if reflect.DeepEqual(sr.entries, want) == false {
+		t.Errorf("keys %v, want %v", sr.entries, want)
+	}
+}
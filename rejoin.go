@@ -0,0 +1,86 @@
+// rejoin.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/tanji/mariadb-tools/dbhelper"
+)
+
+/* Reconnects a server object in place, without touching its state */
+func (server *ServerMonitor) reconnect() error {
+	// A simulated server has no connection to open again
+	if server.db != nil {
+		return server.db.Ping()
+	}
+	var err error
+	if server.Socket == "" {
+		server.IP, err = dbhelper.CheckHostAddr(server.Host)
+		if err != nil {
+			return errors.New(fmt.Sprintf("ERROR: DNS resolution error for host %s", server.Host))
+		}
+	}
+	conn, err := dbConnectAs(server.address(), server.cluster.dbUser, server.cluster.dbPass)
+	if err != nil {
+		return err
+	}
+	if server.Conn != nil {
+		server.Conn.Close()
+	}
+	server.Conn = conn
+	if server.Socket != "" {
+		server.socketEndpoint()
+	}
+	return server.detectFlavor()
+}
+
+/* Checks whether the master lost in the last failover is back online, and rejoins it as a slave of the current master */
+func (c *Cluster) rejoinCheck() {
+	if c.failedMasterURL == "" || automationFrozen() {
+		return
+	}
+	for _, s := range c.servers {
+		if s.URL != c.failedMasterURL || s.State != STATE_FAILED {
+			continue
+		}
+		if s.probe() != nil {
+			return
+		}
+		logprintf("INFO : Old master %s is back online", s.URL)
+		// The position of a failed master in the new master binlogs is unknown without GTID
+		if *autorejoin == false || c.positional {
+			s.setState(STATE_UNCONN)
+		} else if err := s.rejoin(); err != nil {
+			logprintf("ERROR: Could not rejoin old master %s as a slave: %s", s.URL, err)
+			s.setState(STATE_UNCONN)
+		}
+		c.failedMasterURL = ""
+		c.saveState()
+		return
+	}
+}
+
+/* Configures the old master as a GTID slave of the current master. Replication starts from the server's own GTID position, so an old master holding transactions that never reached the new master will fail to connect instead of silently diverging. */
+func (server *ServerMonitor) rejoin() error {
+	logprintf("INFO : Rejoining %s as a slave of %s", server.URL, server.cluster.master.URL)
+	err := server.run("SET GLOBAL read_only=1", setReadOnly(true))
+	if err != nil {
+		return err
+	}
+	server.run("STOP SLAVE", dbhelper.StopSlave)
+	cm := "CHANGE MASTER TO master_host='" + server.cluster.master.IP + "', master_port=" + server.cluster.master.Port + ", master_user='" + server.cluster.rplUser + "', master_password='" + server.cluster.rplPass + "'" + server.gtidMasterOpt("current_pos")
+	err = server.exec(cm)
+	if err != nil {
+		return err
+	}
+	err = server.run("START SLAVE", dbhelper.StartSlave)
+	if err != nil {
+		return err
+	}
+	server.refresh()
+	server.setState(STATE_SLAVE)
+	if server.cluster.isSlave(server.URL) == false {
+		server.cluster.slaves = append(server.cluster.slaves, server)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+// rejoin_test.go
+package main
+
+import (
+	"testing"
+)
+
+func TestRejoinCheck(t *testing.T) {
+	defer func(r bool) { *autorejoin = r }(*autorejoin)
+	tests := []struct {
+		name       string
+		autorejoin bool
+		positional bool
+		back       bool
+		state      string // state of the old master after the check
+		rejoined   bool
+	}{
+		{"old master back", true, false, true, STATE_SLAVE, true},
+		{"old master still down", true, false, false, STATE_FAILED, false},
+		{"autorejoin disabled", false, false, true, STATE_UNCONN, false},
+		{"without GTID", true, true, true, STATE_UNCONN, false},
+	}
+	for _, tt := range tests {
+		// db2 was promoted by a failover of db1
+		sims := simCluster(t, []simSpec{
+			{url: "db1:3306", id: 1, gtid: "0-1-120", down: true},
+			{url: "db2:3306", id: 2, gtid: "0-2-130"},
+			{url: "db3:3306", id: 3, master: 2, gtid: "0-2-130"},
+		})
+		current.master = current.findMaster(true)
+		old := simServerByURL("db1:3306")
+		current.failedMasterURL, *autorejoin, current.positional = "db1:3306", tt.autorejoin, tt.positional
+		sims["db1:3306"].down = tt.back == false
+		current.rejoinCheck()
+		if old.State != tt.state {
+			t.Errorf("%s: old master %s, want %s", tt.name, old.State, tt.state)
+		}
+		if sims["db1:3306"].ran("CHANGE MASTER TO master_host='db2', master_port=3306") != tt.rejoined || current.isSlave("db1:3306") != tt.rejoined {
+			t.Errorf("%s: old master rejoined %v, want %v, ran %q", tt.name, current.isSlave("db1:3306"), tt.rejoined, sims["db1:3306"].execs)
+		}
+		if tt.rejoined && (sims["db1:3306"].vars["READ_ONLY"] != "ON" || sims["db1:3306"].ran("START SLAVE") == false) {
+			t.Errorf("%s: old master not replicating read-only, ran %q", tt.name, sims["db1:3306"].execs)
+		}
+		if (current.failedMasterURL == "") != tt.back {
+			t.Errorf("%s: failed master %q after the check", tt.name, current.failedMasterURL)
+		}
+	}
+}
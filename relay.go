@@ -0,0 +1,174 @@
+// relay.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/tanji/mariadb-tools/dbhelper"
+	"log"
+)
+
+/* Moves the slaves replicating from another monitored slave from the slave list to the chained list, so that only direct slaves of the master take part in elections */
+func (c *Cluster) splitChained() {
+	ids := make(map[uint]bool)
+	for _, sl := range c.slaves {
+		ids[sl.ServerId] = true
+	}
+	for k := 0; k < len(c.slaves); k++ {
+		sl := c.slaves[k]
+		if ids[sl.MasterServerId] && sl.MasterServerId != sl.ServerId {
+			log.Printf("INFO : Server %s replicates from an intermediate master", sl.URL)
+			c.chained = append(c.chained, sl)
+			c.slaves = append(c.slaves[:k], c.slaves[k+1:]...)
+			k--
+		}
+	}
+}
+
+/* Returns the monitored server a chained slave replicates from */
+func (sm *ServerMonitor) upstream() *ServerMonitor {
+	for _, s := range append(append([]*ServerMonitor{}, sm.cluster.slaves...), sm.cluster.chained...) {
+		if s.ServerId == sm.MasterServerId && s != sm {
+			return s
+		}
+	}
+	return nil
+}
+
+/* Returns the chained slaves replicating from the server */
+func (sm *ServerMonitor) downstream() []*ServerMonitor {
+	var l []*ServerMonitor
+	for _, s := range sm.cluster.chained {
+		if s.MasterServerId == sm.ServerId && s != sm {
+			l = append(l, s)
+		}
+	}
+	return l
+}
+
+/* Returns true if the server is a chained slave */
+func (c *Cluster) isChained(url string) bool {
+	for _, s := range c.chained {
+		if s.URL == url {
+			return true
+		}
+	}
+	return false
+}
+
+/* Refreshes the chained slaves and, with -relay-failover, reattaches the slaves of a failed intermediate master */
+func (c *Cluster) relayCheck() {
+	if len(c.chained) == 0 {
+		return
+	}
+	errs := refreshAll(context.Background(), c.chained)
+	for k := 0; k < len(c.chained); k++ {
+		s := c.chained[k]
+		if s.inMaintenance() {
+			continue
+		}
+		switch err := errs[k]; {
+		case err == sql.ErrNoRows:
+			logprintf("INFO : Server %s no longer replicates, removing it from the chained slaves", s.label())
+			s.UsingGtid = ""
+			s.setState(STATE_UNCONN)
+			c.chained = append(c.chained[:k], c.chained[k+1:]...)
+			errs = append(errs[:k], errs[k+1:]...)
+			k--
+			continue
+		case err != nil:
+			s.setState(STATE_FAILED)
+		case s.State == STATE_FAILED:
+			s.setState(STATE_SLAVE)
+		}
+		s.checkDelay()
+		s.checkErrors()
+		// The intermediate master was promoted, its slaves are now direct slaves
+		if c.master != nil && s.MasterServerId == c.master.ServerId {
+			logprintf("INFO : Server %s now replicates from master, adding it to the slaves", s.label())
+			c.chained = append(c.chained[:k], c.chained[k+1:]...)
+			errs = append(errs[:k], errs[k+1:]...)
+			c.slaves = append(c.slaves, s)
+			k--
+		}
+	}
+	if *relayFailover == "off" || c.master == nil || c.master.State == STATE_FAILED || automationFrozen() || *dryRun {
+		return
+	}
+	if c.reattached == nil {
+		c.reattached = make(map[string]bool)
+	}
+	for _, s := range append(append([]*ServerMonitor{}, c.slaves...), c.chained...) {
+		if s.State != STATE_FAILED {
+			delete(c.reattached, s.URL)
+			continue
+		}
+		if c.reattached[s.URL] {
+			continue
+		}
+		var orphans []*ServerMonitor
+		for _, d := range s.downstream() {
+			if d.State != STATE_FAILED && d.IOThread != "Yes" {
+				orphans = append(orphans, d)
+			}
+		}
+		if len(orphans) > 0 {
+			c.reattach(s, orphans)
+			c.reattached[s.URL] = true
+		}
+	}
+}
+
+/* Repoints the slaves of a failed intermediate master, either all to the master, or the most advanced one to the master and the others to it */
+func (c *Cluster) reattach(failed *ServerMonitor, orphans []*ServerMonitor) {
+	c.alert(ALERT_REATTACHED, failed.URL, "Intermediate master %s failed, reattaching its %d slaves", failed.label(), len(orphans))
+	top := c.master
+	if *relayFailover == "sibling" && len(orphans) > 1 {
+		best := 0
+		for k, d := range orphans {
+			if d.gtidSeq() > orphans[best].gtidSeq() {
+				best = k
+			}
+		}
+		sibling := orphans[best]
+		orphans = append(orphans[:best], orphans[best+1:]...)
+		if c.relink(sibling, c.master) == nil {
+			logprintf("INFO : Slave %s is the new intermediate master replacing %s", sibling.label(), failed.label())
+			top = sibling
+		}
+	}
+	for _, d := range orphans {
+		c.relink(d, top)
+	}
+}
+
+/* Points a chained slave at a new upstream server from its own GTID position */
+func (c *Cluster) relink(sm *ServerMonitor, to *ServerMonitor) error {
+	if sm.usesPositions() {
+		logprintf("ERROR: Slave %s does not use GTID and must be reattached manually", sm.label())
+		return errors.New("GTID is required")
+	}
+	logprintf("INFO : Reattaching %s to %s", sm.label(), to.label())
+	sm.run("STOP SLAVE", dbhelper.StopSlave)
+	err := sm.exec("CHANGE MASTER TO master_host='" + to.IP + "', master_port=" + to.Port + ", master_user='" + c.rplUser + "', master_password='" + c.rplPass + "'" + sm.gtidMasterOpt("slave_pos"))
+	if err == nil {
+		err = sm.run("START SLAVE", dbhelper.StartSlave)
+	}
+	if err != nil {
+		logprintf("ERROR: Could not reattach %s to %s: %s", sm.label(), to.label(), err)
+		return err
+	}
+	audit("Reattached %s to %s", sm.URL, to.URL)
+	sm.MasterServerId = to.ServerId
+	if to == c.master {
+		for k, s := range c.chained {
+			if s == sm {
+				c.chained = append(c.chained[:k], c.chained[k+1:]...)
+				break
+			}
+		}
+		c.slaves = append(c.slaves, sm)
+	}
+	return nil
+}
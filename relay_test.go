@@ -0,0 +1,87 @@
+// relay_test.go
+package main
+
+import (
+	"testing"
+)
+
+/* Builds a relay topology: db4 and db5 replicate from the intermediate master db3, db5 being the most advanced of them */
+func simRelay(t *testing.T) map[string]*simServer {
+	specs := append(simTopology(), simSpec{url: "db4:3306", id: 4, master: 3, gtid: "0-1-112"}, simSpec{url: "db5:3306", id: 5, master: 3, gtid: "0-1-114"})
+	sims := simCluster(t, specs)
+	current.master = current.findMaster(true)
+	current.master.State = STATE_MASTER
+	current.splitChained()
+	return sims
+}
+
+/* Returns the URLs of the servers */
+func simURLs(l []*ServerMonitor) []string {
+	var u []string
+	for _, s := range l {
+		u = append(u, s.URL)
+	}
+	return u
+}
+
+func TestSplitChained(t *testing.T) {
+	simRelay(t)
+	if got := simURLs(current.slaves); len(got) != 2 || got[0] != "db2:3306" || got[1] != "db3:3306" {
+		t.Errorf("slaves %q, want db2:3306 and db3:3306", got)
+	}
+	if got := simURLs(current.chained); len(got) != 2 || got[0] != "db4:3306" || got[1] != "db5:3306" {
+		t.Errorf("chained slaves %q, want db4:3306 and db5:3306", got)
+	}
+	if up := simServerByURL("db4:3306").upstream(); up == nil || up.URL != "db3:3306" {
+		t.Errorf("upstream of db4:3306 = %v, want db3:3306", up)
+	}
+	if got := simURLs(simServerByURL("db3:3306").downstream()); len(got) != 2 {
+		t.Errorf("downstream of db3:3306 = %q, want db4:3306 and db5:3306", got)
+	}
+	if current.isChained("db4:3306") == false || current.isChained("db2:3306") {
+		t.Error("isChained() does not match the chained slaves")
+	}
+}
+
+func TestRelayFailover(t *testing.T) {
+	defer func(r string, d bool) { *relayFailover, *dryRun = r, d }(*relayFailover, *dryRun)
+	tests := []struct {
+		name   string
+		mode   string
+		dryRun bool
+		db4    string // master host of db4 after the check
+		db5    string
+		slaves int // direct slaves of the master after the check
+	}{
+		{"off", "off", false, "db3", "db3", 2},
+		{"to the master", "master", false, "db1", "db1", 4},
+		{"to a sibling", "sibling", false, "db5", "db1", 3},
+		{"dry run", "master", true, "db3", "db3", 2},
+	}
+	for _, tt := range tests {
+		*relayFailover, *dryRun = tt.mode, tt.dryRun
+		sims := simRelay(t)
+		// The intermediate master fails, its slaves lose their IO thread
+		sims["db3:3306"].down = true
+		simServerByURL("db3:3306").State = STATE_FAILED
+		for _, url := range []string{"db4:3306", "db5:3306"} {
+			sims[url].status.Slave_IO_Running = "No"
+		}
+		current.relayCheck()
+		if got := sims["db4:3306"].status.Master_Host; got != tt.db4 {
+			t.Errorf("%s: db4:3306 replicates from %s, want %s", tt.name, got, tt.db4)
+		}
+		if got := sims["db5:3306"].status.Master_Host; got != tt.db5 {
+			t.Errorf("%s: db5:3306 replicates from %s, want %s", tt.name, got, tt.db5)
+		}
+		if len(current.slaves) != tt.slaves {
+			t.Errorf("%s: slaves %q, want %d", tt.name, simURLs(current.slaves), tt.slaves)
+		}
+		// The slaves are reattached once per failure of the intermediate master
+		n := len(sims["db4:3306"].execs)
+		current.relayCheck()
+		if len(sims["db4:3306"].execs) != n {
+			t.Errorf("%s: db4:3306 reattached again: %q", tt.name, sims["db4:3306"].execs[n:])
+		}
+	}
+}
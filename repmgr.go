@@ -6,54 +6,302 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"github.com/mariadb-corporation/replication-manager/pkg/cluster"
 	"github.com/nsf/termbox-go"
 	"github.com/tanji/mariadb-tools/dbhelper"
 	"log"
+	"os"
+	"runtime"
 	"strings"
 	"time"
 )
 
-const repmgrVersion string = "0.5.0-dev"
+// Build metadata, set at link time by the Makefile
+var (
+	repmgrVersion   = "0.5.0-dev"
+	repmgrCommit    = "unknown"
+	repmgrBuildDate = "unknown"
+)
 
 var (
-	hostList      []string
-	servers       []*ServerMonitor
-	slaves        []*ServerMonitor
-	master        *ServerMonitor
 	exit          bool
 	vy            int
-	dbUser        string
-	dbPass        string
-	rplUser       string
-	rplPass       string
-	switchOptions     = []string{"keep", "kill"}
-	failOptions       = []string{"monitor", "force", "check"}
-	failCount     int = 0
+	switchOptions = []string{"keep", "kill"}
+	failOptions   = []string{"monitor", "force", "check"}
+	electOptions  = []string{"preferred", "most-advanced"}
+	compatOptions = []string{"off", "warn", "block"}
+	lockOptions   = []string{"ftwrl", "backup-stage", "none"}
 	tlog          TermLog
-	ignoreList    []string
+	hostAliases   map[string]string
+	hostLabels    map[string]map[string]string
 )
 
 // Command specific options
 var (
 	version     = flag.Bool("version", false, "Return version")
-	user        = flag.String("user", "", "User for MariaDB login, specified in the [user]:[password] format")
+	user        = flag.String("user", "", "User for MariaDB login, specified in the [user]:[password] format, the password possibly referencing a secret as env:<variable>, file:<path> or vault:<path>[#<key>]")
 	hosts       = flag.String("hosts", "", "List of MariaDB hosts IP and port (optional), specified in the host:[port] format and separated by commas")
+	aliases     = flag.String("host-aliases", "", "Display names of the hosts, specified in the host:[port]=name format and separated by commas")
+	labels      = flag.String("host-labels", "", "Labels of the hosts such as dc, rack or zone, specified in the host:[port]=key:value;key:value format and separated by commas")
 	socket      = flag.String("socket", "/var/run/mysqld/mysqld.sock", "Path of MariaDB unix socket")
-	rpluser     = flag.String("rpluser", "", "Replication user in the [user]:[password] format")
+	rpluser     = flag.String("rpluser", "", "Replication user in the [user]:[password] format, the password possibly referencing a secret like the user option")
+	admin       = flag.String("admin-user", "", "Administration user in the [user]:[password] format, used only during failover, switchover and administrative commands so that the monitoring user can do without privileges such as SUPER")
+	credsFile   = flag.String("credentials-file", "", "Path of a .cnf style file holding the user and password of the [client], [admin] and [replication] sections, used when the user, admin-user and rpluser options are not set")
 	interactive = flag.Bool("interactive", true, "Ask for user interaction when failures are detected")
 	verbose     = flag.Bool("verbose", false, "Print detailed execution info")
-	preScript   = flag.String("pre-failover-script", "", "Path of pre-failover script")
-	postScript  = flag.String("post-failover-script", "", "Path of post-failover script")
+	preScript   = flag.String("pre-failover-script", "", "Path of pre-failover script, run as pre-failover and pre-switchover hook")
+	postScript  = flag.String("post-failover-script", "", "Path of post-failover script, run as post-failover and post-switchover hook")
+	hooksFile   = flag.String("hooks", "", "Path of a file listing the scripts run on failover, switchover and server events")
 	maxDelay    = flag.Int64("maxdelay", 0, "Maximum replication delay before initiating failover")
 	gtidCheck   = flag.Bool("gtidcheck", false, "Check that GTID sequence numbers are identical before initiating failover")
-	prefMaster  = flag.String("prefmaster", "", "Preferred candidate server for master failover, in host:[port] format")
-	ignoreSrv   = flag.String("ignore-servers", "", "List of servers to ignore in slave promotion operations")
+	prefMaster  = flag.String("prefmaster", "", "Preferred candidate servers for master failover, in host:[port][:weight],... format, higher weights being preferred")
+	ignoreSrv   = flag.String("ignore-servers", "", "List of servers to ignore in slave promotion operations, as host:[port], wildcard patterns such as *.dr.example.com or the backup and delayed role keywords")
 	waitKill    = flag.Int64("wait-kill", 5000, "Wait this many milliseconds before killing threads on demoted master")
 	readonly    = flag.Bool("readonly", true, "Set slaves as read-only after switchover")
 	failover    = flag.String("failover", "", "Failover mode, either 'monitor', 'force' or 'check'")
 	switchover  = flag.String("switchover", "", "Switchover mode, either 'keep' or 'kill' the old master.")
+	checkSwitch = flag.Bool("check-switchover", false, "Check that a switchover can proceed cleanly and exit with a non-zero status if not")
+	output      = flag.String("output", "text", "Output format of the check and monitor modes, either 'text' or 'json'")
+	dryRun      = flag.Bool("dry-run", false, "Print the statements switchover and failover would execute on each server without changing anything")
+)
+
+// Switchover options
+var (
+	gtidWaitTimeout = flag.Int64("gtid-wait-timeout", 30, "Seconds to wait for the candidate master to apply the old master GTID position during switchover")
+	promotionSQL    = flag.String("post-promotion-sql", "", "Path of a SQL template file run on the new master after promotion, the default of the post-promotion-sql option of the clusters file")
+	compatCheck     = flag.String("compat-check", "warn", "Binlog compatibility check of newer candidates with older slaves, either 'off', 'warn' or 'block'")
+	durabilityCheck = flag.String("durability-check", "warn", "Check of the sync_binlog, innodb_flush_log_at_trx_commit and log_slave_updates settings of candidates, either 'off', 'warn' or 'block'")
+	forcePromote    = flag.Bool("force", false, "Promote candidates failing the durability check in block mode")
+	drainTimeout    = flag.Int64("drain-timeout", 0, "Seconds to wait for client connections to drain on the old master before rejecting writes, 0 to disable")
+	drainThreshold  = flag.Int("drain-threshold", 0, "Number of remaining client connections considered drained")
+	electionMode    = flag.String("election-mode", "preferred", "Candidate election strategy, either 'preferred' (prefmaster wins when eligible) or 'most-advanced' (highest GTID wins, prefmaster breaks ties)")
+	electionDCMode  = flag.String("election-dc", "any", "Datacenter candidates are preferably elected in, either 'any', 'same' (as the master) or 'primary' (the primary-dc option)")
+	primaryDC       = flag.String("primary-dc", "", "Datacenter preferred for the master with election-dc set to 'primary', matched against the dc host label")
+	swLock          = flag.String("switchover-lock", "ftwrl", "Lock blocking writes on the old master during switchover, either 'ftwrl', 'backup-stage' (MariaDB 10.4+) or 'none'")
+	swLockTimeout   = flag.Int64("switchover-lock-timeout", 10, "Seconds to wait for the switchover lock before aborting the switchover")
+	swRollback      = flag.Bool("switchover-rollback", true, "Revert a switchover failing after the promotion of the new master, with GTID, and restore the old master")
+	stepTimeout     = flag.Int64("step-timeout", 60, "Seconds each statement of a failover or switchover may run before it is killed, 0 to disable")
+	swMaxQueryTime  = flag.Int64("switchover-max-query-time", 0, "Seconds a query may run on the master before switchover handles it with the switchover-long-query action, 0 to disable")
+	swLongQuery     = flag.String("switchover-long-query", "kill", "Action on queries exceeding switchover-max-query-time, either 'kill' or 'abort' the switchover")
+	swAt            = flag.String("switchover-at", "", "Local time, in YYYY-MM-DDTHH:MM[:SS] format, at which the monitor performs a switchover")
+	swWindow        = flag.Int64("switchover-window", 60, "Minutes after switchover-at during which the scheduled switchover may still start")
+	swWaitDelay     = flag.Bool("switchover-wait-delay", false, "Delay the scheduled switchover within its window until all slaves are within maxdelay")
+	swSuperRO       = flag.Bool("switchover-super-readonly", false, "In kill switchover mode, also set super_read_only on the old master (MySQL), so that accounts with the SUPER privilege cannot write")
+	swKillAll       = flag.Bool("switchover-kill-all", false, "In kill switchover mode, kill all client threads of the old master, idle ones included, until none is left")
+	swBlockUsers    = flag.String("switchover-block-users", "", "In kill switchover mode, comma separated list of application accounts, user or user@host, locked on the old master until it is demoted")
+	migrateEvents   = flag.Bool("migrate-events", false, "Disable the enabled events of the old master on slave side and enable the slave side disabled events of the new master on switchover and failover")
+)
+
+// Virtual IP options
+var (
+	failoverVip = flag.String("failover-vip", "", "Virtual IP address in CIDR notation (e.g. 10.0.0.100/24) to move to the new master on failover or switchover, or the Elastic IP or floating IP of the aws and openstack providers")
+	vipProvider = flag.String("vip-provider", "ip", "Virtual IP provider, either 'ip' (ip addr over ssh with gratuitous ARP), 'script', 'aws' (Elastic IP), 'gcp' (alias IP range) or 'openstack' (floating IP)")
+	vipIface    = flag.String("vip-interface", "eth0", "Network interface holding the virtual IP on database hosts")
+	vipSSHUser  = flag.String("vip-ssh-user", "root", "SSH user allowed to run ip and arping on database hosts")
+	vipScript   = flag.String("vip-script", "", "Path of script called as '<script> add|del <host> <vip>' by the script VIP provider")
+	vipHosts    = flag.String("vip-cloud-instances", "", "Cloud instances of the database hosts, in host=instance,... format: EC2 instance ids, GCE [zone/]instance names or Neutron port ids. Looked up by address if empty")
+	vipRegion   = flag.String("vip-cloud-region", "", "AWS region, GCE zone or OpenStack region of the database instances, the one of the environment or of the monitor instance if empty")
+	vipProject  = flag.String("vip-cloud-project", "", "GCP project of the database instances, the one of the monitor instance if empty")
+)
+
+// DNS options
+var (
+	dnsRecName = flag.String("dns-record", "", "Name of the DNS record pointed at the new master on failover or switchover, e.g. writer.db.example.com (disabled if empty)")
+	dnsKind    = flag.String("dns-provider", "nsupdate", "DNS provider, either 'nsupdate' (RFC 2136 dynamic update) or 'route53'")
+	dnsServer  = flag.String("dns-server", "", "Name server receiving the dynamic updates, and queried to verify the record after it is updated")
+	dnsKey     = flag.String("dns-key", "", "Path of the TSIG key file signing the dynamic updates, passed to nsupdate -k")
+	dnsZone    = flag.String("dns-zone", "", "Route53 hosted zone id of the record")
+	dnsTTL     = flag.Int64("dns-ttl", 30, "TTL of the DNS record, in seconds")
+	dnsVerify  = flag.Int64("dns-verify-timeout", 60, "Seconds to wait for the Route53 change to be in sync and the name server to answer the new master")
+)
+
+// Kubernetes options
+var (
+	kubeSelector  = flag.String("kube-selector", "", "Label selector of the database pods, watched through the Kubernetes API and labeled with their role; the hosts are discovered from them if the hosts option is empty")
+	kubeNamespace = flag.String("kube-namespace", "", "Namespace of the database pods and writer service, the one of the replication-manager pod if empty")
+	kubeService   = flag.String("kube-writer-service", "", "Kubernetes service sending the writes to the master, e.g. mysql-writer, either selecting the pods by role label or without selector, its endpoints then being set to the master")
+	kubeLabel     = flag.String("kube-role-label", "replication-manager/role", "Label set to master or slave on the database pods")
+	kubePort      = flag.String("kube-port", "3306", "Database port of the pods discovered with kube-selector")
+)
+
+// Alerting options
+var (
+	mailTo     = flag.String("mail-to", "", "Comma separated list of email addresses to send alerts to")
+	mailFrom   = flag.String("mail-from", "repmgr@localhost", "Sender address of alert emails")
+	mailSMTP   = flag.String("mail-smtp-addr", "localhost:25", "SMTP relay used to send alert emails, in host:port format")
+	alertDelay = flag.Int64("alert-delay", 0, "Raise an alert when a slave replication delay exceeds this many seconds (0 disables)")
+	webhookURL = flag.String("webhook-url", "", "URL receiving alerts as Slack compatible JSON payloads")
+	alertRules = flag.String("alert-routes", "", "Path of the file holding alert routing rules")
+	tags       = flag.String("cluster-tags", "", "Comma separated list of tags identifying the cluster in alerts")
+)
+
+// Automation options
+var (
+	panicDuration = flag.Int64("panic-duration", 1800, "Number of seconds automatic actions stay suspended after the panic button is pressed")
+	autorejoin    = flag.Bool("autorejoin", false, "Automatically rejoin a failed master as a slave of the new master when it comes back online")
+	stateFile     = flag.String("state-file", "", "Path of the JSON file where the cluster state and failover history are persisted")
+	discInterval  = flag.Int64("discovery-interval", 60, "Seconds between topology discoveries in monitor mode, 0 to disable")
+	forceReadonly = flag.Bool("force-slave-readonly", false, "Keep read_only set on slaves and cleared on the master in monitor mode, correcting drift")
+	autoskip      = flag.String("autoskip-errors", "", "Comma separated list of replication errors skipped automatically on slaves in monitor mode, optionally followed by a rate limit such as 1062,1032:max=5/hour")
+	maxFail       = flag.Int("failcount", 3, "Number of consecutive failed checks of the master before it is declared failed")
+	failoverLimit = flag.Int("failover-limit", 0, "Maximum number of automatic failovers performed by the process, 0 for no limit")
+	failoverTime  = flag.Int64("failover-time-limit", 0, "Minimum seconds since the last failover before an automatic failover, 0 to disable")
+	relayFailover = flag.String("relay-failover", "off", "Reattach the slaves of a failed intermediate master, either 'off', 'master' (to the master) or 'sibling' (most advanced one to the master, others to it)")
+)
+
+// Multiple cluster options
+var (
+	clustersFile = flag.String("clusters", "", "Path of a file defining the clusters monitored by this process, replacing the hosts, user, rpluser, prefmaster, ignore-servers, cluster-tags and failover-vip options")
+	clusterSel   = flag.String("cluster", "", "Name of the cluster to operate on when the clusters file defines several ones")
+)
+
+// Binary log options
+var (
+	binlogFlush   = flag.Int64("binlog-flush-interval", 0, "Seconds between binary log rotations on the master in monitor mode, 0 to disable")
+	binlogFlushSw = flag.Bool("binlog-flush-switchover", false, "Rotate the binary logs of the master before switchover")
+	binlogKeep    = flag.Int("binlog-keep-files", 0, "Number of binary log files kept on the master in monitor mode, older files being purged unless a slave still reads them, 0 to disable")
+	rescueBinlogs = flag.Bool("rescue-binlogs", false, "On failover, apply to the new master the binary log events of the failed master it did not receive, read over ssh")
+	rescueDir     = flag.String("rescue-binlog-dir", "/var/lib/mysql", "Directory holding the binary logs on the database hosts")
+	rescueSSHUser = flag.String("rescue-ssh-user", "root", "SSH user allowed to run mysqlbinlog on database hosts")
+)
+
+// Monitoring options
+var (
+	monInterval    = flag.Int64("monitor-interval", 3, "Seconds between two refreshes of the servers in monitor mode")
+	connectTimeout = flag.Int64("connect-timeout", 5, "Seconds to wait for a server connection, 0 for the system default")
+	maxRetry       = flag.Int64("max-retry-interval", 60, "Maximum seconds between two connection attempts to a failed server, attempts backing off exponentially from the monitor interval")
+	readTimeout    = flag.Int64("read-timeout", 0, "Seconds to wait for a server answer before the connection is considered broken, 0 to disable")
+	groupRepl      = flag.Bool("group-replication", false, "Read the MySQL Group Replication membership of the servers, and move the replicas of a failed group primary to the new primary elected by the group")
+)
+
+// Logging options
+var (
+	logLevel  = flag.String("log-level", "info", "Minimum level of the logged messages, either 'debug', 'info', 'warn' or 'error'. The verbose option sets the debug level")
+	logFormat = flag.String("log-format", "text", "Format of the log, either 'text' or 'json' for one JSON object per line")
+	logFile   = flag.String("log-file", "", "Path of the file the log is appended to, including the console log, instead of the standard error")
+	logLines  = flag.Int("log-lines", 20, "Number of lines of the console log")
+	logDump   = flag.String("log-dump-dir", os.TempDir(), "Directory the console log is dumped to with the d key")
+	ringFile  = flag.String("log-ring-file", "", "Path of the file persisting the last log messages served by the /api/log endpoint (disabled if empty)")
+	ringSize  = flag.Int("log-ring-size", 10000, "Number of log messages kept in the log ring buffer")
+)
+
+// Watchdog options
+var (
+	watchdogCycles = flag.Int64("watchdog-cycles", 20, "Number of monitor refresh intervals without a completed cycle before the monitor is declared stalled, 0 to disable")
+	watchdogAction = flag.String("watchdog-action", "alert", "Action when the monitor is stalled, either 'alert' or 'exit' to let a supervisor restart it")
+)
+
+// Service registry options
+var (
+	registry     = flag.String("registry", "", "Service registry where the master and slave endpoints are published, either 'consul' or 'etcd' (disabled if empty)")
+	registryAddr = flag.String("registry-address", "http://127.0.0.1:8500", "URL of the Consul agent or etcd gateway")
+	registryName = flag.String("registry-name", "mariadb", "Consul service name, or etcd key prefix")
+)
+
+// Leader election options
+var (
+	leaderMode = flag.String("leader-election", "", "Backend of the lock electing the active instance among redundant daemons, either 'consul', 'etcd' or 'mysql' (disabled if empty)")
+	leaderAddr = flag.String("leader-address", "", "URL of the Consul agent or etcd gateway holding the lock, the registry address if empty, or host:port of the MySQL server holding it")
+	leaderUser = flag.String("leader-user", "", "User and password of the MySQL server holding the lock, possibly referencing a secret like the user option")
+	leaderKey  = flag.String("leader-key", "replication-manager/leader", "Key of the lock, shared by the instances managing the same clusters")
+	leaderTTL  = flag.Int64("leader-ttl", 15, "Seconds after which the lock of an active instance that stopped renewing it expires")
+	leaderID   = flag.String("leader-id", "", "Name of this instance in the lock, the hostname followed by the HTTP address or the process id if empty")
+)
+
+// Probing options
+var (
+	probeInterval = flag.Int64("probe-interval", 300, "Minimum seconds between two probes of the same unknown server, 0 to disable probing")
+	probeAllowed  = flag.String("probe-allow", "", "Comma separated list of networks in CIDR format where unknown servers may be probed (all if empty)")
+	probeDenied   = flag.String("probe-deny", "", "Comma separated list of networks in CIDR format where unknown servers are never probed")
+)
+
+// Monitoring history options
+var (
+	historyFile = flag.String("history-file", "", "Path of the file storing the replication lag and throughput history (disabled if empty)")
+	historyRaw  = flag.Int64("history-raw-hours", 24, "Hours raw monitoring samples are kept before being downsampled to one minute aggregates")
+	historyDays = flag.Int64("history-days", 30, "Days one minute aggregates of monitoring samples are kept")
+)
+
+// Metrics options
+var (
+	metrics       = flag.String("metrics", "", "Send replication delay, server states and failover events at each monitoring tick, either to 'graphite' or 'statsd' (disabled if empty)")
+	metricsAddr   = flag.String("metrics-address", "", "Address of the Graphite plaintext receiver or StatsD daemon in host:port format, defaults to port 2003 or 8125 on localhost")
+	metricsPrefix = flag.String("metrics-prefix", "replication-manager", "Prefix of the metric paths, followed by the cluster name")
+)
+
+// Provisioning options
+var (
+	provTool    = flag.String("provision-tool", "mariabackup", "Physical backup tool used by the provision command, either 'mariabackup' or 'xtrabackup'")
+	provDonor   = flag.String("provision-donor", "", "Server in host:port format the provision command takes the backup from, the master if empty")
+	provSSHUser = flag.String("provision-ssh-user", "root", "SSH user allowed to run the backup tool on the donor and to restore the backup on the new node")
+	provDatadir = flag.String("provision-datadir", "/var/lib/mysql", "Data directory of the new node, which must be empty")
+	provStart   = flag.String("provision-start-command", "systemctl start mariadb", "Command starting the server of the new node once the backup is prepared")
+)
+
+// Test options
+var (
+	testStop    = flag.String("test-stop-command", "", "Command stopping a database server of the sandbox in the stop-master test scenario, run with the host as last argument, e.g. 'docker stop'")
+	testStart   = flag.String("test-start-command", "", "Command starting a database server of the sandbox again after the stop-master test scenario, run with the host as last argument")
+	testTimeout = flag.Int64("test-timeout", 60, "Seconds each check of a test scenario may take before the scenario fails")
+)
+
+// Heartbeat options
+var (
+	hbTable    = flag.String("heartbeat-table", "", "Table in db.table format where heartbeats are written on the master to measure replication lag (disabled if empty)")
+	hbInterval = flag.Int64("heartbeat-interval", 1, "Minimum seconds between heartbeats, bounded by the monitor refresh interval")
+)
+
+// Arbitration options
+var (
+	arbPeers     = flag.String("arbitration-peers", "", "Comma separated list of HTTP API URLs of the other replication-manager instances that must confirm a master failure")
+	arbURL       = flag.String("arbitrator-url", "", "URL of an external arbitrator that must grant automatic failover")
+	arbToken     = flag.String("arbitration-token", "", "Bearer token presented to the HTTP API of the arbitration peers, possibly referencing a secret like the user option")
+	checkSlaves  = flag.Bool("failover-check-slaves", false, "Deny automatic failover while a majority of slaves still replicate from the master, the failure being local to the monitor")
+	checkScript  = flag.String("failover-check-script", "", "Path of a script run before automatic failover, a non-zero exit status vetoing the failover")
+	checkTimeout = flag.Int64("failover-check-timeout", 10, "Seconds after which the failover check script is killed, vetoing the failover")
+)
+
+// Fencing options
+var (
+	fenceScript  = flag.String("fence-script", "", "Path of a script run with the failed master host as argument before failover, e.g. an ssh shutdown or a power-off, that must succeed for the failover to proceed")
+	fenceTimeout = flag.Int64("fence-timeout", 60, "Seconds the fence script has to complete and the failed master to stop answering, after which the failover is aborted")
+)
+
+// Administration options
+var (
+	setVariable = flag.String("set-variable", "", "Set a replication related global variable on all servers, specified in the name=value format")
+	auditFile   = flag.String("audit-file", "", "Path of the file recording administrative operations")
+	maintList   = flag.String("maintenance", "", "Comma separated list of servers put in maintenance at startup, excluded from checks, alerts and promotion until taken out from the console or the HTTP API")
+	rotatePass  = flag.String("rotate-rpl-password", "", "Change the password of the replication user on the master and point every slave at it")
+)
+
+// TLS options
+var (
+	sslCA   = flag.String("ssl-ca", "", "Path of the CA certificate used to verify the servers, enables TLS")
+	sslCert = flag.String("ssl-cert", "", "Path of the client certificate presented to the servers, enables TLS")
+	sslKey  = flag.String("ssl-key", "", "Path of the client certificate private key")
+)
+
+// HTTP API options
+var (
+	httpAddr     = flag.String("http-address", "", "Address the HTTP API listens on, in host:port format (disabled if empty)")
+	httpAllow    = flag.String("http-allow", "", "Comma separated list of networks in CIDR format allowed to reach the HTTP API (all if empty)")
+	httpCert     = flag.String("http-tls-cert", "", "Path of the certificate served by the HTTP API, enables HTTPS")
+	httpKey      = flag.String("http-tls-key", "", "Path of the private key of the HTTP API certificate")
+	httpClientCA = flag.String("http-client-ca", "", "Path of the CA certificate HTTP API clients must present a certificate from")
+	grpcAddr     = flag.String("grpc-address", "", "Address the gRPC service listens on, in host:port format, with the TLS, network and authentication settings of the HTTP API (disabled if empty)")
+	httpUI       = flag.Bool("http-ui", false, "Serve a web dashboard at the root of the HTTP API, with switchover and failover buttons")
+	checkAddr    = flag.String("check-address", "", "Address the load balancer checks listen on, in host:port format, answering over HTTP or plain TCP whether a server is the master or a healthy slave (disabled if empty)")
+	checkProxy   = flag.String("check-proxy-peers", "", "Comma separated list of networks in CIDR format of the load balancers whose PROXY protocol headers are accepted by the load balancer checks (none if empty)")
+	httpControl  = flag.Bool("http-control", false, "Serve the switchover and failover endpoints of the HTTP API used by the client commands, also enabled by the http-ui option")
+	httpAuthFile = flag.String("http-auth-file", "", "Path of the file listing the tokens and users of the HTTP API with their viewer or operator role, enables authentication")
+	oidcIssuer   = flag.String("http-oidc-issuer", "", "URL of the OIDC provider whose bearer tokens are accepted by the HTTP API, enables authentication")
+	oidcAudience = flag.String("http-oidc-audience", "", "Audience the OIDC tokens must be issued for, any if empty")
+	oidcOperator = flag.String("http-oidc-operator-group", "", "Group of the groups claim of the OIDC tokens granting the operator role, the other clients being viewers")
 )
 
 const (
@@ -67,30 +315,164 @@ func main() {
 	flag.Parse()
 	if *version == true {
 		fmt.Println("MariaDB Replication Manager version", repmgrVersion)
+		fmt.Println("Commit", repmgrCommit, "built", repmgrBuildDate, "with", runtime.Version(), "for", runtime.GOOS+"/"+runtime.GOARCH)
+		return
 	}
-	// if slaves option has been supplied, split into a slice.
-	if *hosts != "" {
-		hostList = strings.Split(*hosts, ",")
-	} else {
-		log.Fatal("ERROR: No hosts list specified.")
+	if err := initLogging(); err != nil {
+		log.Fatalln("ERROR:", err)
+	}
+	defer alertFlush()
+	if contains(clientCommands, flag.Arg(0)) {
+		if err := runClient(flag.Args()); err != nil {
+			log.Fatalln("ERROR:", err)
+		}
+		return
+	}
+	if *aliases != "" {
+		var err error
+		hostAliases, err = parseAliases(*aliases)
+		if err != nil {
+			log.Fatalln("ERROR:", err)
+		}
+	}
+	if *labels != "" {
+		var err error
+		hostLabels, err = parseLabels(*labels)
+		if err != nil {
+			log.Fatalln("ERROR:", err)
+		}
+	}
+	if *autoskip != "" {
+		var err error
+		skipPolicy, err = parseSkipPolicy(*autoskip)
+		if err != nil {
+			log.Fatalln("ERROR:", err)
+		}
 	}
-	// validate users.
 	if *user == "" {
-		log.Fatal("ERROR: No master user/pair specified.")
+		*user = os.Getenv("REPMGR_USER")
 	}
-	dbUser, dbPass = splitPair(*user)
 	if *rpluser == "" {
-		log.Fatal("ERROR: No replication user/pair specified.")
+		*rpluser = os.Getenv("REPMGR_RPLUSER")
+	}
+	if *admin == "" {
+		*admin = os.Getenv("REPMGR_ADMIN_USER")
+	}
+	if *credsFile != "" && (*user == "" || *rpluser == "" || *admin == "") {
+		creds, err := loadCredentials(*credsFile)
+		if err != nil {
+			log.Fatalln("ERROR: Could not load credentials:", err)
+		}
+		if *user == "" {
+			*user = creds["client"]
+		}
+		if *rpluser == "" {
+			*rpluser = creds["replication"]
+		}
+		if *admin == "" {
+			*admin = creds["admin"]
+		}
+	}
+	var err error
+	if *kubeSelector != "" || *kubeService != "" {
+		if *clustersFile != "" {
+			log.Fatalln("ERROR: The Kubernetes options apply to a single cluster and cannot be used with a clusters file")
+		}
+		kube, err = newKubeClient()
+		if err != nil {
+			log.Fatalln("ERROR:", err)
+		}
+	}
+	if *clustersFile != "" {
+		clusters, err = loadClusters(*clustersFile)
+		if err != nil {
+			log.Fatalln("ERROR: Could not load clusters:", err)
+		}
+		if *clusterSel != "" {
+			c := findCluster(clusters, *clusterSel)
+			if c == nil {
+				log.Fatalf("ERROR: Unknown cluster: %s", *clusterSel)
+			}
+			clusters = []*Cluster{c}
+		}
+	} else {
+		if *hosts == "" && kube != nil && *kubeSelector != "" {
+			*hosts, err = kubeHosts()
+			if err != nil {
+				log.Fatalln("ERROR: Could not discover the database pods:", err)
+			}
+			log.Printf("INFO : Discovered database pods %s", *hosts)
+		}
+		// validate hosts and users.
+		if *hosts == "" {
+			log.Fatal("ERROR: No hosts list specified.")
+		}
+		if *user == "" {
+			log.Fatal("ERROR: No master user/pair specified.")
+		}
+		if *rpluser == "" {
+			log.Fatal("ERROR: No replication user/pair specified.")
+		}
+		c := &Cluster{Name: "default", Hosts: *hosts, User: *user, AdminUser: *admin, RplUser: *rpluser, PrefMaster: *prefMaster, IgnoreServers: *ignoreSrv, Tags: *tags, Vip: *failoverVip, DNS: *dnsRecName, PromotionSQL: *promotionSQL}
+		if *tags != "" {
+			c.Name = strings.Split(*tags, ",")[0]
+		}
+		clusters = []*Cluster{c}
+	}
+	for _, c := range clusters {
+		c.User, err = resolveCredentials(c.User)
+		if err == nil {
+			c.AdminUser, err = resolveCredentials(c.AdminUser)
+		}
+		if err == nil {
+			c.RplUser, err = resolveCredentials(c.RplUser)
+		}
+		if err != nil {
+			log.Fatalln("ERROR:", err)
+		}
+		c.hostList = strings.Split(c.Hosts, ",")
+		c.weights, err = parseWeights(c.PrefMaster)
+		if err != nil {
+			log.Fatalln("ERROR:", err)
+		}
+		if c.Vip != "" {
+			c.vip, err = newVIPProvider(c.Vip)
+			if err != nil {
+				log.Fatalln("ERROR:", err)
+			}
+		}
+		if c.DNS != "" && dnsProvider == nil {
+			dnsProvider, err = newDNSProvider()
+			if err != nil {
+				log.Fatalln("ERROR:", err)
+			}
+		}
+	}
+	shown = clusters[0]
+	adminOnly = *setVariable != "" || *rotatePass != "" || *failover == "force" || (*switchover != "" && *interactive == false) ||
+		flag.Arg(0) == "bootstrap" || flag.Arg(0) == "provision" || flag.Arg(0) == "reseed" || flag.Arg(0) == "test"
+	for _, c := range clusters {
+		c.configure()
+	}
+	err = loadHistory()
+	if err != nil {
+		log.Fatalln("ERROR: Could not load monitoring history:", err)
+	}
+	err = registerTLS()
+	if err != nil {
+		log.Fatalln("ERROR: Could not load TLS settings:", err)
 	}
-	rplUser, rplPass = splitPair(*rpluser)
 
 	// Check that failover and switchover modes are set correctly.
-	if *switchover == "" && *failover == "" {
+	if *switchover == "" && *failover == "" && *setVariable == "" && *rotatePass == "" && *checkSwitch == false && flag.Arg(0) != "plan" && flag.Arg(0) != "topology" && flag.Arg(0) != "bootstrap" && flag.Arg(0) != "provision" && flag.Arg(0) != "reseed" && flag.Arg(0) != "test" {
 		log.Fatal("ERROR: None of the switchover or failover modes are set.")
 	}
 	if *switchover != "" && *failover != "" {
 		log.Fatal("ERROR: Both switchover and failover modes are set.")
 	}
+	if len(clusters) > 1 && (*failover == "force" || (*switchover != "" && *interactive == false) || *rotatePass != "" || flag.Arg(0) == "plan" || flag.Arg(0) == "bootstrap" || flag.Arg(0) == "provision" || flag.Arg(0) == "reseed" || flag.Arg(0) == "test") {
+		log.Fatal("ERROR: Several clusters are defined, select the one to operate on with the cluster option.")
+	}
 	if !contains(failOptions, *failover) && *failover != "" {
 		log.Fatalf("ERROR: Incorrect failover mode: %s", *failover)
 	}
@@ -98,188 +480,596 @@ func main() {
 		log.Fatalf("ERROR: Incorrect switchover mode: %s", *switchover)
 	}
 
-	if *ignoreSrv != "" {
-		ignoreList = strings.Split(*ignoreSrv, ",")
+	if !contains(electOptions, *electionMode) {
+		log.Fatalf("ERROR: Incorrect election mode: %s", *electionMode)
 	}
 
-	// Create a connection to each host and build list of slaves.
-	hostCount := len(hostList)
-	servers = make([]*ServerMonitor, hostCount)
+	if err := checkIgnoreList(*ignoreSrv); err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+
+	if !contains(compatOptions, *compatCheck) {
+		log.Fatalf("ERROR: Incorrect compatibility check mode: %s", *compatCheck)
+	}
+
+	if !contains(compatOptions, *durabilityCheck) {
+		log.Fatalf("ERROR: Incorrect durability check mode: %s", *durabilityCheck)
+	}
+
+	if *relayFailover != "off" && *relayFailover != "master" && *relayFailover != "sibling" {
+		log.Fatalf("ERROR: Incorrect relay failover mode: %s", *relayFailover)
+	}
+
+	if *swLongQuery != "kill" && *swLongQuery != "abort" {
+		log.Fatalf("ERROR: Incorrect switchover long query action: %s", *swLongQuery)
+	}
+
+	if *electionDCMode != "any" && *electionDCMode != "same" && *electionDCMode != "primary" {
+		log.Fatalf("ERROR: Incorrect election datacenter policy: %s", *electionDCMode)
+	}
+	if *electionDCMode == "primary" && *primaryDC == "" {
+		log.Fatal("ERROR: The primary datacenter is required with the primary election datacenter policy.")
+	}
+
+	if *swAt != "" {
+		clusters[0].scheduledAt, err = parseSchedule(*swAt)
+		if err != nil {
+			log.Fatalln("ERROR:", err)
+		}
+		if len(clusters) > 1 {
+			log.Fatal("ERROR: Several clusters are defined, select the one to switch over with the cluster option.")
+		}
+	}
+
+	if !contains(lockOptions, *swLock) {
+		log.Fatalf("ERROR: Incorrect switchover lock: %s", *swLock)
+	}
+
+	if *metrics != "" && *metrics != "graphite" && *metrics != "statsd" {
+		log.Fatalf("ERROR: Incorrect metrics backend: %s", *metrics)
+	}
+
+	if *provTool != "mariabackup" && *provTool != "xtrabackup" {
+		log.Fatalf("ERROR: Incorrect provisioning tool: %s", *provTool)
+	}
+
+	if *registry != "" && *registry != "consul" && *registry != "etcd" {
+		log.Fatalf("ERROR: Incorrect service registry: %s", *registry)
+	}
+
+	if *leaderMode != "" {
+		leader, err = newLeaderLock(*leaderMode)
+		if err != nil {
+			log.Fatalln("ERROR:", err)
+		}
+	}
+
+	if *watchdogAction != "alert" && *watchdogAction != "exit" {
+		log.Fatalf("ERROR: Incorrect watchdog action: %s", *watchdogAction)
+	}
+
+	if *output != "text" && *output != "json" {
+		log.Fatalf("ERROR: Incorrect output format: %s", *output)
+	}
+
+	if *monInterval < 1 {
+		log.Fatal("ERROR: Monitor interval must be at least 1 second.")
+	}
+	monitorInterval = time.Duration(*monInterval) * time.Second
+	// Waits for slaves to reach a position are split in queries of syncStep seconds, they must not be cut by the read timeout
+	if *readTimeout > 0 && *readTimeout <= syncStep {
+		log.Fatalf("ERROR: Read timeout must be greater than %d seconds.", syncStep)
+	}
+
+	if *alertRules != "" {
+		err := loadAlertRoutes(*alertRules)
+		if err != nil {
+			log.Fatalln("ERROR: Could not load alert routes:", err)
+		}
+	}
+
+	if *hooksFile != "" {
+		err = loadHooks(*hooksFile)
+		if err != nil {
+			log.Fatalln("ERROR: Could not load hooks:", err)
+		}
+	}
+	legacyHooks()
+
+	if *probeAllowed != "" {
+		probeAllow, err = parseNets(*probeAllowed)
+		if err != nil {
+			log.Fatalln("ERROR: Invalid probe allow list:", err)
+		}
+	}
+	if *probeDenied != "" {
+		probeDeny, err = parseNets(*probeDenied)
+		if err != nil {
+			log.Fatalln("ERROR: Invalid probe deny list:", err)
+		}
+	}
+
+	for _, c := range clusters {
+		logWriter.attribute(c.clusterName())
+		if len(clusters) > 1 {
+			log.Printf("INFO : Initializing cluster %s", c.Name)
+		}
+		err = c.loadState()
+		if err != nil {
+			log.Fatalln("ERROR: Could not load state file:", err)
+		}
+		if *maintList != "" {
+			for _, item := range strings.Split(*maintList, ",") {
+				// Servers are matched by host and port, however the list and the hosts option write them
+				for _, url := range c.hostList {
+					if hostKey(url) == hostKey(strings.TrimSpace(item)) && c.inMaintenance(url) == false {
+						c.stateData.Maintenance = append(c.stateData.Maintenance, url)
+						audit("Server %s put in maintenance from the command line", url)
+					}
+				}
+			}
+		}
+		if *setVariable != "" {
+			c.connectServers(context.Background())
+			err = c.pushVariable(*setVariable)
+			if err != nil {
+				log.Fatalln("ERROR:", err)
+			}
+			continue
+		}
+		if flag.Arg(0) == "bootstrap" {
+			c.connectServers(context.Background())
+			err = c.bootstrap()
+			if err != nil {
+				log.Fatalln("ERROR:", err)
+			}
+			return
+		}
+		err = c.Discover(context.Background())
+		if err != nil {
+			log.Fatalln("ERROR:", err)
+		}
+	}
+	if *setVariable != "" {
+		return
+	}
+
+	if *httpAddr != "" || *grpcAddr != "" || *checkAddr != "" {
+		if *httpAllow != "" {
+			allowNets, err = parseNets(*httpAllow)
+			if err != nil {
+				log.Fatalln("ERROR: Invalid HTTP allow list:", err)
+			}
+		}
+		if *httpAuthFile != "" {
+			err = loadAPIAuth(*httpAuthFile)
+			if err != nil {
+				log.Fatalf("ERROR: Invalid HTTP auth file %s: %s", *httpAuthFile, err)
+			}
+		}
+	}
+	if *httpAddr != "" {
+		go apiServe()
+	}
+	if *grpcAddr != "" {
+		go grpcServe()
+	}
+	if *checkAddr != "" {
+		if *checkProxy != "" {
+			proxyPeers, err = parseNets(*checkProxy)
+			if err != nil {
+				log.Fatalln("ERROR: Invalid check proxy peers:", err)
+			}
+		}
+		go checkServe()
+	}
+	if kube != nil && *kubeSelector != "" && *failover == "monitor" {
+		go kubeWatch()
+	}
+
+	// Do failover or switchover manually, or start the interactive monitor.
+
+	if flag.Arg(0) == "plan" {
+		err = shown.writePlan(flag.Arg(1))
+		if err != nil {
+			log.Fatalln("ERROR:", err)
+		}
+	} else if flag.Arg(0) == "provision" {
+		err = shown.provision(flag.Arg(1))
+		if err != nil {
+			log.Fatalln("ERROR:", err)
+		}
+	} else if flag.Arg(0) == "test" {
+		err = shown.runChaos(flag.Args()[1:])
+		if err != nil {
+			log.Fatalln("ERROR:", err)
+		}
+	} else if flag.Arg(0) == "reseed" {
+		err = shown.reseed(flag.Arg(1))
+		if err != nil {
+			log.Fatalln("ERROR:", err)
+		}
+	} else if flag.Arg(0) == "topology" {
+		clusterLock.Lock()
+		writeDOT(os.Stdout)
+		clusterLock.Unlock()
+	} else if *rotatePass != "" {
+		err = shown.rotateRplPassword(*rotatePass)
+		if err != nil {
+			log.Fatalln("ERROR:", err)
+		}
+	} else if *checkSwitch {
+		clusterLock.Lock()
+		ok := checkSwitchover()
+		clusterLock.Unlock()
+		if ok == false {
+			os.Exit(1)
+		}
+	} else if *output == "json" && *failover == "check" {
+		clusterLock.Lock()
+		for _, c := range clusters {
+			c.Refresh(context.Background())
+			c.writeReport()
+		}
+		clusterLock.Unlock()
+	} else if *output == "json" && *failover == "monitor" {
+		monitorJSON()
+	} else if *failover == "force" {
+		_, err = shown.Failover(context.Background())
+		if err == nil {
+			shown.registryCheck()
+			shown.kubeCheck()
+		}
+		dnsWait()
+	} else if *switchover != "" && *interactive == false {
+		_, err = shown.Switchover(context.Background())
+		if err == nil {
+			shown.registryCheck()
+			shown.kubeCheck()
+		}
+		dnsWait()
+	} else {
+		monitorConsole()
+	}
+}
+
+/* Creates a connection to each host of the cluster and builds the list of slaves, until the context is cancelled */
+func (c *Cluster) connectServers(ctx context.Context) error {
+	hostCount := len(c.hostList)
+	c.servers = make([]*ServerMonitor, hostCount)
+	c.slaves, c.chained = nil, nil
 	slaveCount := 0
-	for k, url := range hostList {
+	for k, url := range c.hostList {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		var err error
-		servers[k], err = newServerMonitor(url)
+		c.servers[k], err = c.newServerMonitor(url)
 		if *verbose {
-			log.Printf("DEBUG: Creating new server: %v", servers[k].URL)
+			log.Printf("DEBUG: Creating new server: %v", c.servers[k].URL)
 		}
 		if err != nil {
-			log.Printf("INFO : Server %s is dead.", servers[k].URL)
-			servers[k].State = STATE_FAILED
+			log.Printf("INFO : Server %s is dead.", c.servers[k].URL)
+			c.servers[k].setState(STATE_FAILED)
 			continue
 		}
-		defer servers[k].Conn.Close()
 		if *verbose {
-			log.Printf("DEBUG: Checking if server %s is slave", servers[k].URL)
+			log.Printf("DEBUG: Checking if server %s is slave", c.servers[k].URL)
 		}
 
-		servers[k].refresh()
-		if servers[k].UsingGtid != "" {
+		c.servers[k].refresh()
+		if c.servers[k].UsingGtid != "" {
 			if *verbose {
-				log.Printf("DEBUG: Server %s is configured as a slave", servers[k].URL)
+				log.Printf("DEBUG: Server %s is configured as a slave", c.servers[k].URL)
 			}
-			servers[k].State = STATE_SLAVE
-			slaves = append(slaves, servers[k])
+			c.servers[k].State = STATE_SLAVE
+			c.slaves = append(c.slaves, c.servers[k])
 			slaveCount++
 		} else {
 			if *verbose {
-				log.Printf("DEBUG: Server %s is not a slave. Setting aside", servers[k].URL)
+				log.Printf("DEBUG: Server %s is not a slave. Setting aside", c.servers[k].URL)
 			}
 		}
 	}
+	return nil
+}
 
-	// Check that all slave servers have the same master.
-	for _, sl := range slaves {
-		if sl.hasSiblings(slaves) == false {
-			log.Fatalln("ERROR: Multi-master topologies are not yet supported.")
+/* Checks the topology of the cluster and finds its master */
+func (c *Cluster) detectMaster() error {
+	// Check that all slave servers have the same master, once the slaves of intermediate masters are set aside.
+	c.splitChained()
+	for _, sl := range c.slaves {
+		if sl.hasSiblings(c.slaves) == false {
+			return errors.New("Multi-master topologies are not yet supported.")
 		}
 	}
 
-	// Depending if we are doing a failover or a switchover, we will find the master in the list of
-	// dead hosts or unconnected hosts.
-	if *switchover != "" || *failover == "monitor" {
-		// First of all, get a server id from the slaves slice, they should be all the same
-		sid := slaves[0].MasterServerId
-		for k, s := range servers {
-			if s.State == STATE_UNCONN {
-				if s.ServerId == sid {
-					master = servers[k]
-					master.State = STATE_MASTER
-					if *verbose {
-						log.Printf("DEBUG: Server %s was autodetected as a master", s.URL)
-					}
-					break
-				}
-			}
+	// Fall back to binlog file and position when no slave replicates with GTID.
+	c.positional = len(c.slaves) > 0
+	for _, sl := range c.slaves {
+		if sl.UsingGtid != "No" {
+			c.positional = false
 		}
+	}
+	if c.positional {
+		log.Println("INFO : Slaves do not use GTID, failover will rely on binlog file and position")
+	} else if c.anyPositional() {
+		log.Println("INFO : Some slaves do not use GTID, they will be repointed with binlog file and position")
+	}
+
+	// A master recorded in the state file takes precedence over autodetection. Otherwise, depending
+	// if we are doing a failover or a switchover, we will find the master in the list of
+	// dead hosts or unconnected hosts.
+	if m := c.stateMaster(); m != nil {
+		c.master = m
+		c.master.State = STATE_MASTER
+		c.failedMasterURL = c.stateData.FailedMaster
+		log.Printf("INFO : Using master %s from state file", c.master.URL)
 	} else {
-		// Slave master_host variable must point to dead master
-		smh := slaves[0].MasterHost
-		for k, s := range servers {
-			if s.State == STATE_FAILED {
-				if s.Host == smh || s.IP == smh {
-					master = servers[k]
-					master.State = STATE_MASTER
-					if *verbose {
-						log.Printf("DEBUG: Server %s was autodetected as a master", s.URL)
-					}
-					break
-				}
+		c.master = c.findMaster(*switchover != "" || *failover == "monitor" || *checkSwitch || *rotatePass != "" || flag.Arg(0) == "plan" || flag.Arg(0) == "topology" || flag.Arg(0) == "provision" || flag.Arg(0) == "reseed" || flag.Arg(0) == "test")
+		if c.master != nil {
+			c.master.State = STATE_MASTER
+			if *verbose {
+				log.Printf("DEBUG: Server %s was autodetected as a master", c.master.URL)
 			}
 		}
 	}
 	// Final check if master has been found
-	if master == nil {
-		log.Fatalln("ERROR: Could not autodetect a master!")
+	if c.master == nil {
+		return errors.New("Could not autodetect a master!")
 	}
+	c.saveState()
 
-	for _, sl := range slaves {
+	for _, sl := range c.slaves {
 		if *verbose {
-			log.Printf("DEBUG: Checking if server %s is a slave of server %s", sl.Host, master.Host)
+			log.Printf("DEBUG: Checking if server %s is a slave of server %s", sl.Host, c.master.Host)
 		}
-		if dbhelper.IsSlaveof(sl.Conn, sl.Host, master.IP) == false {
-			log.Printf("WARN : Server %s is not a slave of declared master %s", master.URL, master.Host)
+		if dbhelper.IsSlaveof(sl.Conn, sl.Host, c.master.IP) == false {
+			log.Printf("WARN : Server %s is not a slave of declared master %s", c.master.URL, c.master.Host)
 		}
 	}
+	c.domainCheck()
 
-	// Check if preferred master is included in Host List
-	ret := func() bool {
-		for _, v := range hostList {
-			if v == *prefMaster {
-				return true
-			}
+	// Check if preferred masters are included in Host List
+	for url := range c.weights {
+		if contains(c.hostList, url) == false {
+			return errors.New(fmt.Sprintf("Preferred master %s is not included in the hosts option", url))
 		}
-		return false
 	}
-	if ret() == false && *prefMaster != "" {
-		log.Fatal("ERROR: Preferred master is not included in the hosts option")
+	return nil
+}
+
+/* Returns the server the slaves replicate from. A running master is the connected server, neither slave nor failed, whose server id the slaves report; a failed master is the failed server their master host points to. */
+func (c *Cluster) findMaster(running bool) *ServerMonitor {
+	if len(c.slaves) == 0 {
+		return nil
+	}
+	l := make([]cluster.Server, len(c.servers))
+	for k, s := range c.servers {
+		l[k] = s.replicationState()
+	}
+	k := cluster.FindMaster(l, c.slaves[0].replicationState(), running)
+	if k < 0 {
+		return nil
 	}
+	return c.servers[k]
+}
 
-	// Do failover or switchover manually, or start the interactive monitor.
+/* Returns the replication state of the server used by the cluster library. Only unconnected servers may be detected as a running master. */
+func (sm *ServerMonitor) replicationState() cluster.Server {
+	return cluster.Server{URL: sm.URL, Host: sm.Host, IP: sm.IP, ServerID: sm.ServerId, Failed: sm.State == STATE_FAILED, Slave: sm.State != STATE_UNCONN && sm.State != STATE_FAILED, MasterServerID: sm.MasterServerId, MasterHost: sm.MasterHost}
+}
 
-	if *failover == "force" {
-		master.failover()
-	} else if *switchover != "" && *interactive == false {
-		master.switchover()
-	} else {
-	MainLoop:
-		err := termbox.Init()
-		if err != nil {
-			log.Fatalln("Termbox initialization error", err)
+/* Runs the checks of a monitor cycle on every cluster, refreshed with refresh, under the cluster lock. Returns the first cluster whose failed master is to be failed over automatically, nil if none. */
+func monitorTick(refresh func(c *Cluster)) *Cluster {
+	var failing *Cluster
+	leaderCheck()
+	dnsCheck()
+	alertCheck()
+	for _, c := range clusters {
+		logWriter.attribute(c.clusterName())
+		c.discoveryCheck()
+		c.rejoinCheck()
+		c.recoveryCheck()
+		c.heartbeatCheck()
+		c.relayCheck()
+		refresh(c)
+		c.skipCheck()
+		c.standbyFollow()
+		c.readonlyCheck()
+		c.binlogCheck()
+		c.recordSamples()
+		historyCheck()
+		c.registryCheck()
+		c.kubeCheck()
+		c.metricsCheck()
+		if c.scheduledSwitchover() {
+			c.opTrigger = "schedule"
+			c.Switchover(context.Background())
 		}
-		tlog = NewTermLog(20)
-		if *failover != "" {
-			tlog.Add("Monitor started in failover mode")
-		} else {
-			tlog.Add("Monitor started in switchover mode")
-		}
-		termboxChan := new_tb_chan()
-		interval := time.Second
-		ticker := time.NewTicker(interval * 3)
-		var command string
-		for exit == false {
-			select {
-			case <-ticker.C:
-				display()
-			case event := <-termboxChan:
-				switch event.Type {
-				case termbox.EventKey:
-					if event.Key == termbox.KeyCtrlS {
-						nmUrl, nsKey := master.switchover()
-						if nmUrl != "" && nsKey >= 0 {
-							if *verbose {
-								logprintf("DEBUG: Reinstancing new master: %s and new slave: %s [%d]", nmUrl, slaves[nsKey].URL, nsKey)
-							}
-							master, err = newServerMonitor(nmUrl)
-							slaves[nsKey], err = newServerMonitor(slaves[nsKey].URL)
-						}
-					}
-					if event.Key == termbox.KeyCtrlF {
-						command = "failover"
-						exit = true
-					}
-					if event.Key == termbox.KeyCtrlQ {
-						exit = true
-					}
-				}
-				switch event.Ch {
-				case 's':
-					termbox.Sync()
-				}
+		if c.master.State != STATE_FAILED || *interactive || failing != nil {
+			continue
+		}
+		if automationFrozen() {
+			if c.suspended == false {
+				alertLog("%s", suspendedMessage())
+				c.suspended = true
 			}
-			if master.State == STATE_FAILED && *interactive == false {
+			continue
+		}
+		c.suspended = false
+		if c.arbitrate() {
+			failing = c
+		}
+	}
+	return failing
+}
+
+/* Runs the interactive monitor console on all clusters, the Tab key switching the cluster displayed */
+func monitorConsole() {
+	panicChan := newPanicChan()
+	if *watchdogCycles > 0 {
+		go shown.watchdog()
+	}
+	if leader != nil {
+		go leaderLoop()
+	}
+MainLoop:
+	err := termbox.Init()
+	if err != nil {
+		log.Fatalln("Termbox initialization error", err)
+	}
+	tlog = NewTermLog(*logLines)
+	if *failover != "" {
+		tlog.Add("Monitor started in failover mode")
+	} else {
+		tlog.Add("Monitor started in switchover mode")
+	}
+	termboxChan := new_tb_chan()
+	ticker := time.NewTicker(monitorInterval)
+	var command string
+	var failing *Cluster
+	for exit == false {
+		select {
+		case <-ticker.C:
+			clusterLock.Lock()
+			failing = monitorTick(func(c *Cluster) {
+				if c == shown {
+					display()
+				} else {
+					c.refreshTopology(context.Background())
+				}
+			})
+			if failing != nil {
 				command = "failover"
+				failing.opTrigger = "automation"
 				exit = true
 			}
-		}
-		switch command {
-		case "failover":
-			termbox.Close()
-			nmUrl, nmKey := master.failover()
-			if nmUrl != "" {
-				if *verbose {
-					log.Printf("DEBUG: Reinstancing new master: %s", nmUrl)
+			cycleDone()
+		case sig := <-panicChan:
+			clusterLock.Lock()
+			panicSignal(sig)
+			display()
+		case event := <-termboxChan:
+			clusterLock.Lock()
+			switch event.Type {
+			case termbox.EventKey:
+				if event.Key == termbox.KeyCtrlS {
+					shown.opTrigger = "console"
+					shown.Switchover(context.Background())
+				}
+				if event.Key == termbox.KeyCtrlF {
+					command = "failover"
+					shown.opTrigger = "console"
+					failing = shown
+					exit = true
+				}
+				if event.Key == termbox.KeyCtrlQ {
+					exit = true
+				}
+				if event.Key == termbox.KeyCtrlP {
+					if automationFrozen() {
+						panicRelease("console")
+					} else {
+						panicFreeze(time.Duration(*panicDuration)*time.Second, "console")
+					}
+					display()
+				}
+				if event.Key == termbox.KeyTab && len(clusters) > 1 {
+					showNext()
+					display()
+				}
+				switch event.Key {
+				case termbox.KeyArrowUp:
+					selectMove(-1)
+					display()
+				case termbox.KeyArrowDown:
+					selectMove(1)
+					display()
+				case termbox.KeyPgup:
+					selectMove(-slaveWindow())
+					display()
+				case termbox.KeyPgdn:
+					selectMove(slaveWindow())
+					display()
+				case termbox.KeyEnter:
+					detailed = true
+					display()
+				case termbox.KeyEsc:
+					detailed = false
+					display()
 				}
-				master, err = newServerMonitor(nmUrl)
-				// Remove new master from slave slice
-				slaves = append(slaves[:nmKey], slaves[nmKey+1:]...)
 			}
-			log.Println("###### Restarting monitor console in 5 seconds. Press Ctrl-C to exit")
-			time.Sleep(5 * time.Second)
-			exit = false
-			goto MainLoop
+			switch event.Ch {
+			case 's':
+				termbox.Sync()
+			case 'i':
+				toggleIgnored()
+				display()
+			case 'p':
+				togglePreferred()
+				display()
+			case 'm':
+				shown.toggleMaintenance()
+				display()
+			case 'h':
+				showHistory = !showHistory
+				display()
+			case 'd':
+				dumpConsoleLog()
+				display()
+			case 'o':
+				sortNext()
+				display()
+			case '?':
+				showHelp = !showHelp
+				display()
+			}
 		}
+		clusterLock.Unlock()
+	}
+	switch command {
+	case "failover":
 		termbox.Close()
+		clusterLock.Lock()
+		failing.Failover(context.Background())
+		clusterLock.Unlock()
+		log.Println("###### Restarting monitor console in 5 seconds. Press Ctrl-C to exit")
+		time.Sleep(5 * time.Second)
+		exit = false
+		goto MainLoop
+	}
+	termbox.Close()
+}
+
+/* Switches over the master of the cluster, then reinstances the new master and the old master, now a slave. Returns the URL of the new master, empty if the switchover did not complete. */
+func (c *Cluster) runSwitchover(ctx context.Context) string {
+	nmUrl, nsKey := c.master.switchover(ctx)
+	if nmUrl != "" && nsKey >= 0 {
+		if *verbose {
+			logprintf("DEBUG: Reinstancing new master: %s and new slave: %s [%d]", nmUrl, c.slaves[nsKey].URL, nsKey)
+		}
+		c.master, _ = c.newServerMonitor(nmUrl)
+		c.slaves[nsKey], _ = c.newServerMonitor(c.slaves[nsKey].URL)
+		c.saveState()
+	}
+	return nmUrl
+}
+
+/* Reinstances the master after a failover and removes it from the slave slice */
+func (c *Cluster) promoted(nmUrl string, nmKey int) {
+	if *verbose {
+		log.Printf("DEBUG: Reinstancing new master: %s", nmUrl)
+	}
+	c.failedMasterURL = c.master.URL
+	c.master, _ = c.newServerMonitor(nmUrl)
+	// The failed checks counted the old master
+	c.failCount = 0
+	// A Galera or Group Replication peer replacing a failed node was not a slave
+	if nmKey >= 0 {
+		c.slaves = append(c.slaves[:nmKey], c.slaves[nmKey+1:]...)
 	}
+	c.saveState()
 }
 
 func new_tb_chan() chan termbox.Event {
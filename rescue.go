@@ -0,0 +1,55 @@
+// rescue.go
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+/* Applies to the new master the events the failed master logged after the position its IO thread received. They are read from the binary logs of the failed master over ssh with mysqlbinlog, and piped to the mysql client. The relay log of the new master must be applied and its slave threads stopped. */
+func (sm *ServerMonitor) rescueBinlogs(failed *ServerMonitor) error {
+	read, applied, err := sm.slavePositions()
+	if err != nil {
+		return err
+	}
+	if read != applied {
+		return errors.New(fmt.Sprintf("relay log not applied, executed %s of %s", applied, read))
+	}
+	i := strings.LastIndex(read.File, ".")
+	if i < 1 {
+		return errors.New(fmt.Sprintf("unexpected binary log file name %q received from the failed master", read.File))
+	}
+	base := read.File[:i]
+	remote := fmt.Sprintf("cd %s && mysqlbinlog --start-position=%d %s $(ls %s.[0-9]* | awk '$0 > \"%s\"')", *rescueDir, read.Pos, read.File, base, read.File)
+	if *dryRun {
+		alertLog("DRY-RUN: would apply to %s the output of '%s' on %s", sm.URL, remote, failed.Host)
+		return nil
+	}
+	ssh := exec.Command("ssh", "-o", "BatchMode=yes", "-o", "ConnectTimeout=5", *rescueSSHUser+"@"+failed.Host, remote)
+	client := exec.Command("mysql", "--protocol=tcp", "-h", sm.Host, "-P", sm.Port, "-u", sm.cluster.dbUser)
+	client.Env = append(os.Environ(), "MYSQL_PWD="+sm.cluster.dbPass)
+	var sshErr, clientErr bytes.Buffer
+	ssh.Stderr = &sshErr
+	client.Stderr = &clientErr
+	client.Stdin, err = ssh.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	err = ssh.Start()
+	if err != nil {
+		return err
+	}
+	err = client.Run()
+	werr := ssh.Wait()
+	if werr != nil {
+		return errors.New(fmt.Sprintf("reading binary logs on %s: %s: %s", failed.Host, werr, strings.TrimSpace(sshErr.String())))
+	}
+	if err != nil {
+		return errors.New(fmt.Sprintf("applying binary logs on %s: %s: %s", sm.URL, err, strings.TrimSpace(clientErr.String())))
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+// rescue_test.go
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+/* Puts on the PATH an ssh printing binary log events and a mysql client recording them, exiting with the given statuses. Returns the directory where the arguments and the input of the tools are recorded. */
+func simRescueTools(t *testing.T, sshStatus int, mysqlStatus int) string {
+	dir := t.TempDir()
+	tools := map[string]string{
+		"ssh":   "#!/bin/sh\necho \"$@\" > " + dir + "/ssh.args\n[ " + strconv.Itoa(sshStatus) + " -eq 0 ] && echo 'BINLOG EVENTS'\necho 'Permission denied' >&2\nexit " + strconv.Itoa(sshStatus) + "\n",
+		"mysql": "#!/bin/sh\necho \"$@\" > " + dir + "/mysql.args\ncat > " + dir + "/mysql.in\necho 'ERROR 1062' >&2\nexit " + strconv.Itoa(mysqlStatus) + "\n",
+	}
+	for name, script := range tools {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(script), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return dir
+}
+
+func TestRescueBinlogs(t *testing.T) {
+	defer func(p string, d bool) { os.Setenv("PATH", p); *dryRun = d }(os.Getenv("PATH"), *dryRun)
+	tests := []struct {
+		name    string
+		file    string
+		exec    uint
+		ssh     int
+		mysql   int
+		dryRun  bool
+		error   string
+		applied bool
+	}{
+		{"events applied", "mysql-bin.000042", 1024, 0, 0, false, "", true},
+		{"relay log not applied", "mysql-bin.000042", 512, 0, 0, false, "relay log not applied", false},
+		{"unexpected file name", "mysqlbin", 1024, 0, 0, false, "unexpected binary log file name", false},
+		{"ssh failed", "mysql-bin.000042", 1024, 255, 0, false, "reading binary logs on db1: exit status 255: Permission denied", false},
+		{"apply failed", "mysql-bin.000042", 1024, 0, 1, false, "applying binary logs on db3:3306: exit status 1: ERROR 1062", true},
+		{"dry run", "mysql-bin.000042", 1024, 0, 0, true, "", false},
+	}
+	for _, tt := range tests {
+		dir := simRescueTools(t, tt.ssh, tt.mysql)
+		*dryRun = false
+		sims := simCluster(t, simTopology())
+		current.dbUser = "repmgr"
+		simPositions(sims["db3:3306"], tt.file, 1024, tt.exec)
+		sims["db3:3306"].status.Slave_SQL_Running = "No"
+		*dryRun = tt.dryRun
+		err := simServerByURL("db3:3306").rescueBinlogs(simServerByURL("db1:3306"))
+		if tt.error == "" && err != nil || tt.error != "" && (err == nil || strings.HasPrefix(err.Error(), tt.error) == false) {
+			t.Errorf("%s: rescueBinlogs() = %v, want %q", tt.name, err, tt.error)
+		}
+		in, _ := ioutil.ReadFile(filepath.Join(dir, "mysql.in"))
+		if applied := strings.TrimSpace(string(in)) == "BINLOG EVENTS"; applied != tt.applied {
+			t.Errorf("%s: events applied %t, want %t", tt.name, applied, tt.applied)
+		}
+		if tt.applied == false {
+			continue
+		}
+		ssh, _ := ioutil.ReadFile(filepath.Join(dir, "ssh.args"))
+		want := "-o BatchMode=yes -o ConnectTimeout=5 root@db1 cd /var/lib/mysql && mysqlbinlog --start-position=1024 mysql-bin.000042 $(ls mysql-bin.[0-9]* | awk '$0 > \"mysql-bin.000042\"')"
+		if strings.TrimSpace(string(ssh)) != want {
+			t.Errorf("%s: ssh %s, want %s", tt.name, ssh, want)
+		}
+		client, _ := ioutil.ReadFile(filepath.Join(dir, "mysql.args"))
+		if want := "--protocol=tcp -h db3 -P 3306 -u " + current.dbUser; strings.TrimSpace(string(client)) != want {
+			t.Errorf("%s: mysql %s, want %s", tt.name, client, want)
+		}
+	}
+}
+
+func TestFailoverRescue(t *testing.T) {
+	defer func(p string, f string, r bool) {
+		os.Setenv("PATH", p)
+		*failover, *rescueBinlogs = f, r
+	}(os.Getenv("PATH"), *failover, *rescueBinlogs)
+	dir := simRescueTools(t, 0, 0)
+	*failover, *rescueBinlogs = "force", true
+	sims := simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 1 }))
+	simPositions(sims["db2:3306"], "mysql-bin.000042", 600, 600)
+	simPositions(sims["db3:3306"], "mysql-bin.000042", 900, 700)
+	for _, sl := range current.slaves {
+		sl.refresh()
+	}
+	current.master = current.findMaster(false)
+	if nmUrl, err := current.Failover(context.Background()); nmUrl != "db3:3306" {
+		t.Fatalf("Failover() = %q, %v, want db3:3306", nmUrl, err)
+	}
+	ssh, _ := ioutil.ReadFile(filepath.Join(dir, "ssh.args"))
+	in, _ := ioutil.ReadFile(filepath.Join(dir, "mysql.in"))
+	if strings.Contains(string(ssh), "--start-position=900 mysql-bin.000042") == false || strings.TrimSpace(string(in)) != "BINLOG EVENTS" {
+		t.Errorf("binary logs of db1 not rescued from the position received by db3: ssh %s, applied %q", ssh, in)
+	}
+}
@@ -0,0 +1,110 @@
+// reseed.go
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/tanji/mariadb-tools/dbhelper"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+/* Time a reseeded slave is given to begin replicating */
+const reseedWait = 60 * time.Second
+
+/* Reloads a broken slave from a logical dump of the master taken with mysqldump, then points it at the master from the GTID position of the dump. The dump is piped through the monitor host into the slave without being written to its binary log. */
+func (c *Cluster) reseed(url string) error {
+	var sm *ServerMonitor
+	for _, s := range c.slaves {
+		if hostKey(s.URL) == hostKey(url) {
+			sm = s
+		}
+	}
+	if sm == nil {
+		return errors.New(fmt.Sprintf("Server %s is not a slave of the cluster", url))
+	}
+	if sm.State == STATE_FAILED {
+		return errors.New(fmt.Sprintf("Slave %s is not reachable", url))
+	}
+	if c.master.State == STATE_FAILED {
+		return errors.New("The master is down, cannot reseed a slave")
+	}
+	dump := []string{"--protocol=tcp", "-h", c.master.Host, "-P", c.master.Port, "-u", c.dbUser, "--all-databases", "--single-transaction", "--routines", "--events", "--triggers", "--master-data=1"}
+	if c.master.Flavor != FLAVOR_MYSQL {
+		dump = append(dump, "--gtid")
+	}
+	load := []string{"--protocol=tcp", "-h", sm.Host, "-P", sm.Port, "-u", c.dbUser, "--init-command=SET SESSION sql_log_bin=0"}
+	log.Printf("INFO : Reseeding %s from a dump of master %s", sm.URL, c.master.URL)
+	audit("Reseeding %s from master %s", sm.URL, c.master.URL)
+	err := sm.run("STOP SLAVE", dbhelper.StopSlave)
+	if err == nil && sm.Flavor == FLAVOR_MYSQL {
+		// The dump sets gtid_purged, which requires an empty GTID history
+		err = sm.exec("RESET MASTER")
+	}
+	if err != nil {
+		audit("Reseeding of %s failed: %s", sm.URL, err)
+		return err
+	}
+	if *dryRun {
+		alertLog("DRY-RUN: would pipe 'mysqldump %s' into 'mysql %s'", strings.Join(dump, " "), strings.Join(load, " "))
+	} else {
+		start := time.Now()
+		err = c.reseedPipe(dump, load)
+		if err != nil {
+			audit("Reseeding of %s failed: %s", sm.URL, err)
+			return err
+		}
+		log.Printf("INFO : Dump of %s loaded into %s in %s", c.master.URL, sm.URL, time.Since(start).Round(time.Second))
+	}
+	// The dump set the GTID position, only the master connection remains to be set
+	err = sm.exec("CHANGE MASTER TO master_host='" + c.master.IP + "', master_port=" + c.master.Port + ", master_user='" + c.rplUser + "', master_password='" + c.rplPass + "'" + sm.gtidMasterOpt("slave_pos"))
+	if err == nil {
+		err = sm.run("START SLAVE", dbhelper.StartSlave)
+	}
+	if err == nil {
+		err = sm.run("SET GLOBAL read_only=1", setReadOnly(true))
+	}
+	if err == nil && *dryRun == false {
+		err = sm.waitReplicating(reseedWait)
+	}
+	if err != nil {
+		audit("Reseeding of %s failed: %s", sm.URL, err)
+		return errors.New(fmt.Sprintf("Could not restart replication on %s: %s", sm.URL, err))
+	}
+	log.Printf("INFO : Slave %s reseeded and replicating from %s", sm.URL, c.master.URL)
+	audit("Reseeded %s from master %s", sm.URL, c.master.URL)
+	return nil
+}
+
+/* Pipes mysqldump into the mysql client, both run with the monitoring credentials */
+func (c *Cluster) reseedPipe(dump []string, load []string) error {
+	src := exec.Command("mysqldump", dump...)
+	dst := exec.Command("mysql", load...)
+	src.Env = append(os.Environ(), "MYSQL_PWD="+c.dbPass)
+	dst.Env = src.Env
+	var srcErr, dstErr bytes.Buffer
+	src.Stderr = &srcErr
+	dst.Stderr = &dstErr
+	var err error
+	dst.Stdin, err = src.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	err = src.Start()
+	if err != nil {
+		return err
+	}
+	err = dst.Run()
+	werr := src.Wait()
+	if werr != nil {
+		return errors.New(fmt.Sprintf("dumping master: %s: %s", werr, strings.TrimSpace(srcErr.String())))
+	}
+	if err != nil {
+		return errors.New(fmt.Sprintf("loading dump: %s: %s", err, strings.TrimSpace(dstErr.String())))
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+// reseed_test.go
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+/* Puts on the path mysqldump and mysql commands recording their arguments and password, the client keeping the dump it loads */
+func simReseedTools(t *testing.T, dumpStatus int, loadStatus int) string {
+	dir := t.TempDir()
+	tools := map[string]string{
+		"mysqldump": "#!/bin/sh\necho \"$@\" > " + dir + "/mysqldump.args\necho \"$MYSQL_PWD\" > " + dir + "/mysqldump.pwd\necho '-- dump of the master'\necho 'mysqldump: Got error: 1045' >&2\nexit " + strconv.Itoa(dumpStatus) + "\n",
+		"mysql":     "#!/bin/sh\necho \"$@\" > " + dir + "/mysql.args\ncat > " + dir + "/mysql.in\necho 'ERROR 1146' >&2\nexit " + strconv.Itoa(loadStatus) + "\n",
+	}
+	for name, script := range tools {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(script), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return dir
+}
+
+func TestReseed(t *testing.T) {
+	defer func(p string, d bool) {
+		os.Setenv("PATH", p)
+		*dryRun = d
+	}(os.Getenv("PATH"), *dryRun)
+	tests := []struct {
+		name   string
+		url    string
+		flavor string
+		dump   int
+		load   int
+		dryRun bool
+		error  string
+		execs  []string // statements run on the slave, by prefix
+	}{
+		{"MariaDB slave", "db2", FLAVOR_MARIADB, 0, 0, false, "", []string{"STOP SLAVE", "CHANGE MASTER TO master_host='db1', master_port=3306", "START SLAVE", "SET GLOBAL read_only=1"}},
+		{"MySQL slave", "db2:3306", FLAVOR_MYSQL, 0, 0, false, "", []string{"STOP SLAVE", "RESET MASTER", "CHANGE MASTER TO master_host='db1'", "START SLAVE", "SET GLOBAL read_only=1"}},
+		{"not a slave", "db9:3306", FLAVOR_MARIADB, 0, 0, false, "Server db9:3306 is not a slave of the cluster", nil},
+		{"dump failing", "db2:3306", FLAVOR_MARIADB, 2, 0, false, "dumping master: exit status 2: mysqldump: Got error: 1045", []string{"STOP SLAVE"}},
+		{"load failing", "db2:3306", FLAVOR_MARIADB, 0, 1, false, "loading dump: exit status 1: ERROR 1146", []string{"STOP SLAVE"}},
+		{"dry run", "db2:3306", FLAVOR_MARIADB, 0, 0, true, "", nil},
+	}
+	for _, tt := range tests {
+		dir := simReseedTools(t, tt.dump, tt.load)
+		*dryRun = tt.dryRun
+		sims := simCluster(t, simTopology())
+		current.dbUser, current.dbPass = "repmgr", "s3cret"
+		current.master = current.findMaster(true)
+		current.master.Flavor = tt.flavor
+		if sm := simServerByURL("db2:3306"); sm != nil {
+			sm.Flavor = tt.flavor
+		}
+		err := current.reseed(tt.url)
+		if (err == nil && tt.error != "") || (err != nil && err.Error() != tt.error) {
+			t.Errorf("%s: reseed() = %v, want %q", tt.name, err, tt.error)
+		}
+		execs := sims["db2:3306"].execs
+		if len(execs) != len(tt.execs) {
+			t.Errorf("%s: statements %q, want %q", tt.name, execs, tt.execs)
+		} else {
+			for i, want := range tt.execs {
+				if strings.HasPrefix(execs[i], want) == false {
+					t.Errorf("%s: statement %q, want %q", tt.name, execs[i], want)
+				}
+			}
+		}
+		if tt.error != "" || tt.dryRun {
+			continue
+		}
+		dump, _ := ioutil.ReadFile(filepath.Join(dir, "mysqldump.args"))
+		wantDump := "--protocol=tcp -h db1 -P 3306 -u repmgr --all-databases --single-transaction --routines --events --triggers --master-data=1"
+		if tt.flavor == FLAVOR_MARIADB {
+			wantDump += " --gtid"
+		}
+		if strings.TrimSpace(string(dump)) != wantDump {
+			t.Errorf("%s: mysqldump %s, want %s", tt.name, dump, wantDump)
+		}
+		// The password is passed through the environment, the dump is loaded without binary logging
+		pwd, _ := ioutil.ReadFile(filepath.Join(dir, "mysqldump.pwd"))
+		load, _ := ioutil.ReadFile(filepath.Join(dir, "mysql.args"))
+		in, _ := ioutil.ReadFile(filepath.Join(dir, "mysql.in"))
+		if string(pwd) != "s3cret\n" || strings.TrimSpace(string(load)) != "--protocol=tcp -h db2 -P 3306 -u repmgr --init-command=SET SESSION sql_log_bin=0" || string(in) != "-- dump of the master\n" {
+			t.Errorf("%s: password %q, mysql %s loading %q", tt.name, pwd, load, in)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// rollback.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/tanji/mariadb-tools/dbhelper"
+	"strings"
+)
+
+/* Switchover past its point of no return, reverted to the old master if one of its steps fails. Only topologies replicating with GTID are reverted, the positions of the old master are unknown to the moved slaves otherwise. */
+type switchoverRollback struct {
+	oldMaster *ServerMonitor
+	newMaster *ServerMonitor
+	demoted   bool             // the old master replicates from the new master
+	moved     []*ServerMonitor // slaves already replicating from the new master
+	report    []string
+}
+
+/* Logs a rollback action and adds it to the report */
+func (rb *switchoverRollback) step(format string, args ...interface{}) {
+	s := fmt.Sprintf(format, args...)
+	logprintf("INFO : Rollback: %s", s)
+	rb.report = append(rb.report, s)
+}
+
+/* Logs a failed rollback action and adds it to the report */
+func (rb *switchoverRollback) fail(format string, args ...interface{}) {
+	s := fmt.Sprintf(format, args...)
+	logprintf("ERROR: Rollback: %s", s)
+	rb.report = append(rb.report, "FAILED: "+s)
+}
+
+/* Reverts a switchover whose step failed: the new master rejects writes, the old master releases its write lock, applies what the new master wrote meanwhile and takes writes, the virtual IP and DNS record back, then the new master and the slaves already moved replicate from it again with GTID. */
+func (rb *switchoverRollback) revert(reason string) {
+	oldMaster, newMaster := rb.oldMaster, rb.newMaster
+	logprintf("ERROR: Switchover failed: %s. Rolling back to %s", reason, oldMaster.URL)
+	err := newMaster.run("SET GLOBAL read_only=1", setReadOnly(true))
+	if err != nil {
+		rb.fail("could not set %s read-only: %s", newMaster.URL, err)
+	} else {
+		rb.step("set %s read-only", newMaster.URL)
+	}
+	// The write lock of the freeze would block the old master applying the transactions of the new master, it stays read-only until unfrozen
+	err = oldMaster.unlockWrites()
+	if err != nil {
+		rb.fail("could not release the write lock on %s: %s", oldMaster.URL, err)
+	}
+	// Transactions written on the new master before the failure must not be lost
+	if rb.demoted == false {
+		err = oldMaster.replicateFrom(newMaster)
+		if err != nil {
+			rb.fail("could not make %s replicate from %s: %s", oldMaster.URL, newMaster.URL, err)
+		}
+	}
+	sp, err := newMaster.syncPoint()
+	if err == nil {
+		_, err = oldMaster.waitSync(context.Background(), sp, *gtidWaitTimeout)
+	}
+	if err != nil {
+		rb.fail("%s did not catch up with %s, transactions written on %s may be missing: %s", oldMaster.URL, newMaster.URL, newMaster.URL, err)
+	} else {
+		rb.step("%s caught up with %s at %s", oldMaster.URL, newMaster.URL, sp)
+	}
+	oldMaster.run("STOP SLAVE", dbhelper.StopSlave)
+	err = oldMaster.run("RESET SLAVE ALL", resetSlave(true))
+	if err != nil {
+		rb.fail("could not reset slave on %s: %s", oldMaster.URL, err)
+	}
+	// The events migrated to the new master are disabled there again, those of the old master restored by unfreeze
+	events := oldMaster.cluster.stoppedEvents
+	newMaster.stopEvents(logprintf)
+	oldMaster.cluster.stoppedEvents = events
+	if oldMaster.cluster.vip != nil {
+		err = oldMaster.cluster.vip.Remove(newMaster.Host)
+		if err != nil {
+			rb.fail("could not remove virtual IP from %s: %s", newMaster.Host, err)
+		}
+	}
+	err = oldMaster.unfreeze()
+	if err != nil {
+		rb.fail("could not set %s read-write: %s", oldMaster.URL, err)
+	} else {
+		rb.step("restored writes on %s", oldMaster.URL)
+	}
+	oldMaster.cluster.dnsMove(oldMaster, logprintf)
+	for _, sl := range append([]*ServerMonitor{newMaster}, rb.moved...) {
+		err = sl.replicateFrom(oldMaster)
+		if err != nil {
+			rb.fail("could not repoint %s to %s: %s", sl.URL, oldMaster.URL, err)
+			continue
+		}
+		rb.step("repointed %s to %s", sl.URL, oldMaster.URL)
+	}
+	rb.alert(reason)
+}
+
+/* Reverts the switchover if rollback is enabled. Returns true when it was reverted. */
+func (rb *switchoverRollback) rollback(reason string) bool {
+	if rb == nil {
+		return false
+	}
+	rb.revert(reason)
+	return true
+}
+
+/* Sends the report of the rollback */
+func (rb *switchoverRollback) alert(reason string) {
+	if opRecord != nil {
+		opRecord.Result = "rolled back"
+	}
+	rb.oldMaster.cluster.alert(ALERT_ROLLBACK, rb.oldMaster.URL, "Switchover of %s to %s failed: %s. Rollback report:\n%s", rb.oldMaster.label(), rb.newMaster.label(), reason, strings.Join(rb.report, "\n"))
+}
+
+/* Makes the server a read-only slave of the master from its own GTID position */
+func (sm *ServerMonitor) replicateFrom(m *ServerMonitor) error {
+	sm.run("STOP SLAVE", dbhelper.StopSlave)
+	cm := "CHANGE MASTER TO master_host='" + m.IP + "', master_port=" + m.Port + ", master_user='" + sm.cluster.rplUser + "', master_password='" + sm.cluster.rplPass + "'" + sm.gtidMasterOpt("current_pos")
+	err := sm.exec(cm)
+	if err != nil {
+		return err
+	}
+	err = sm.run("START SLAVE", dbhelper.StartSlave)
+	if err != nil {
+		return err
+	}
+	if *readonly {
+		return sm.run("SET GLOBAL read_only=1", setReadOnly(true))
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+// rollback_test.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestSwitchoverRollback(t *testing.T) {
+	defer func(f, s string, rb bool) { *failover, *stateFile, *swRollback = f, s, rb }(*failover, *stateFile, *swRollback)
+	*failover, *stateFile = "force", ""
+	tests := []struct {
+		name     string
+		rollback bool
+		server   string // whose statement fails
+		fail     string
+		master   string // after the switchover, none if rolled back
+		report   []string
+	}{
+		{"promotion failed", true, "db3:3306", "SET GLOBAL read_only=0", "",
+			[]string{"Rollback: set db3:3306 read-only", "Rollback: db1:3306 caught up with db3:3306", "Rollback: restored writes on db1:3306", "Rollback: repointed db3:3306 to db1:3306"}},
+		{"demotion failed", true, "db1:3306", "CHANGE MASTER", "",
+			[]string{"Switchover failed: could not demote old master db1:3306", "Rollback: could not make db1:3306 replicate from db3:3306", "Rollback: restored writes on db1:3306", "Rollback: repointed db3:3306 to db1:3306"}},
+		{"repoint failed", true, "db2:3306", "START SLAVE", "",
+			[]string{"Switchover failed: could not repoint slave db2:3306", "Rollback: restored writes on db1:3306", "Rollback: repointed db3:3306 to db1:3306", "Rollback: could not repoint db2:3306 to db1:3306"}},
+		{"rollback disabled", false, "db1:3306", "CHANGE MASTER", "db3:3306", []string{"Could not demote old master"}},
+	}
+	for _, tt := range tests {
+		*swRollback = tt.rollback
+		sims := simCluster(t, simTopology())
+		sims[tt.server].fail = tt.fail
+		current.master = current.findMaster(true)
+		var out bytes.Buffer
+		log.SetOutput(&out)
+		logWriter.out = &out
+		nmUrl, _ := current.Switchover(context.Background())
+		log.SetOutput(ioutil.Discard)
+		logWriter.out = ioutil.Discard
+		if nmUrl != tt.master {
+			t.Errorf("%s: Switchover() = %q, want %q", tt.name, nmUrl, tt.master)
+		}
+		for _, want := range tt.report {
+			if strings.Contains(out.String(), want) == false {
+				t.Errorf("%s: log does not contain %q:\n%s", tt.name, want, out.String())
+			}
+		}
+		if tt.rollback == false {
+			continue
+		}
+		// The write lock is released before the old master replicates from the new master
+		if i := sims["db1:3306"].ranAt("UNLOCK TABLES"); i < 0 || i > sims["db1:3306"].ranAt("CHANGE MASTER") {
+			t.Errorf("%s: old master ran %q, want the write lock released before it replicates", tt.name, sims["db1:3306"].execs)
+		}
+		// The old master takes writes again, the new master and the moved slave replicate from it
+		if sims["db1:3306"].vars["READ_ONLY"] != "OFF" || sims["db1:3306"].status != nil {
+			t.Errorf("%s: old master read_only %s, slave status %+v, want a writable master", tt.name, sims["db1:3306"].vars["READ_ONLY"], sims["db1:3306"].status)
+		}
+		for _, url := range []string{"db2:3306", "db3:3306"} {
+			if s := sims[url]; s.vars["READ_ONLY"] != "ON" || s.status == nil || s.status.Master_Host != "db1" {
+				t.Errorf("%s: %s read_only %s, slave status %+v, want a read-only slave of db1", tt.name, url, s.vars["READ_ONLY"], s.status)
+			}
+		}
+		if len(current.stateData.History) != 1 || current.stateData.History[0].Result != "rolled back" {
+			t.Errorf("%s: history %+v, want the switchover rolled back", tt.name, current.stateData.History)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// rotate.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/tanji/mariadb-tools/dbhelper"
+	"log"
+	"strings"
+	"time"
+)
+
+/* Time a slave is given to reconnect with the new replication password */
+const rotateWait = 30 * time.Second
+
+/* Changes the replication password on the master, then points the slaves at it one by one, checking that each one resumes replicating before moving to the next. The password change is replicated, so the slaves accounts follow and a later failover keeps working. If a slave does not resume, the old password is restored on the master and on the slaves already changed. */
+func (c *Cluster) rotateRplPassword(pass string) error {
+	if strings.ContainsAny(pass, "'\\") {
+		return errors.New("The replication password cannot contain quotes or backslashes")
+	}
+	if c.master.State == STATE_FAILED {
+		return errors.New("The master is down, cannot rotate the replication password")
+	}
+	rows, err := c.master.query("SELECT Host FROM mysql.user WHERE User = ?", c.rplUser)
+	if err != nil {
+		return err
+	}
+	var hosts []string
+	for _, r := range rows {
+		hosts = append(hosts, r["Host"])
+	}
+	if len(hosts) == 0 {
+		return errors.New(fmt.Sprintf("Replication user %s does not exist on master %s", c.rplUser, c.master.URL))
+	}
+	audit("Rotating password of replication user %s", c.rplUser)
+	oldPass := c.rplPass
+	err = c.setRplPassword(hosts, pass)
+	if err != nil {
+		// Accounts already changed get the old password back
+		c.setRplPassword(hosts, oldPass)
+		return err
+	}
+	audit("Password of replication user %s changed on master %s", c.rplUser, c.master.URL)
+	c.rplPass = pass
+	var changed []*ServerMonitor
+	for _, sl := range c.slaves {
+		if sl.State == STATE_FAILED {
+			log.Printf("WARN : %-21s is down, it must be pointed at the new password manually", sl.URL)
+			audit("Slave %s down, replication password not updated", sl.URL)
+			continue
+		}
+		err = sl.pointRplPassword(pass)
+		if err != nil {
+			audit("Replication password update failed on %s: %s", sl.URL, err)
+			return c.rollbackRplPassword(hosts, oldPass, append(changed, sl), errors.New(fmt.Sprintf("Slave %s did not resume replication with the new password: %s", sl.URL, err)))
+		}
+		changed = append(changed, sl)
+		log.Printf("INFO : %-21s replicating with the new password", sl.URL)
+		audit("Slave %s replicating with the new replication password", sl.URL)
+	}
+	log.Println("INFO : Replication password rotated, update the rpluser option of this and the other replication-manager instances")
+	return nil
+}
+
+/* Sets the password of the replication user accounts on the master */
+func (c *Cluster) setRplPassword(hosts []string, pass string) error {
+	for _, h := range hosts {
+		err := c.master.exec(fmt.Sprintf("ALTER USER '%s'@'%s' IDENTIFIED BY '%s'", c.rplUser, h, pass))
+		if err != nil {
+			audit("Password change of %s@%s failed on master %s: %s", c.rplUser, h, c.master.URL, err)
+			return errors.New(fmt.Sprintf("Could not change password of %s@%s on master: %s", c.rplUser, h, err))
+		}
+		log.Printf("INFO : %-21s password of %s@%s changed", c.master.URL, c.rplUser, h)
+	}
+	return nil
+}
+
+/* Restarts replication on the slave with the password and waits until it replicates */
+func (sm *ServerMonitor) pointRplPassword(pass string) error {
+	err := sm.run("STOP SLAVE", dbhelper.StopSlave)
+	if err == nil {
+		err = sm.exec("CHANGE MASTER TO master_password='" + pass + "'")
+	}
+	if err == nil {
+		err = sm.run("START SLAVE", dbhelper.StartSlave)
+	}
+	if err == nil && *dryRun == false {
+		err = sm.waitReplicating(rotateWait)
+	}
+	return err
+}
+
+/* Restores the old replication password on the master and on the slaves already pointed at the new one after a slave failed to resume. Returns the failure, with the slaves still needing a manual change if the rollback failed too. */
+func (c *Cluster) rollbackRplPassword(hosts []string, oldPass string, changed []*ServerMonitor, cause error) error {
+	log.Printf("ERROR: %s, restoring the old replication password", cause)
+	err := c.setRplPassword(hosts, oldPass)
+	if err != nil {
+		audit("Replication password rollback failed on master %s: %s", c.master.URL, err)
+		done := make(map[*ServerMonitor]bool)
+		for _, sl := range changed {
+			done[sl] = true
+		}
+		var left []string
+		for _, sl := range c.slaves {
+			if sl.State != STATE_FAILED && done[sl] == false {
+				left = append(left, sl.URL)
+			}
+		}
+		return errors.New(fmt.Sprintf("%s. The old password could not be restored on the master (%s), these slaves still use the old password and must be changed manually: %s", cause, err, strings.Join(left, ", ")))
+	}
+	c.rplPass = oldPass
+	var failed []string
+	for _, sl := range changed {
+		err = sl.pointRplPassword(oldPass)
+		if err != nil {
+			log.Printf("ERROR: %-21s did not resume replication with the old password: %s", sl.URL, err)
+			failed = append(failed, sl.URL)
+		}
+	}
+	audit("Password of replication user %s restored on master %s", c.rplUser, c.master.URL)
+	if len(failed) > 0 {
+		return errors.New(fmt.Sprintf("%s. The old password was restored on the master, these slaves must be pointed back at it manually: %s", cause, strings.Join(failed, ", ")))
+	}
+	return errors.New(fmt.Sprintf("%s. The old password was restored on the master and the slaves", cause))
+}
+
+/* Waits until both slave threads run */
+func (sm *ServerMonitor) waitReplicating(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		ss, err := sm.slaveStatus()
+		if err != nil {
+			return err
+		}
+		if ss.Slave_IO_Running == "Yes" && ss.Slave_SQL_Running == "Yes" {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New(fmt.Sprintf("IO thread %s, SQL thread %s after %s: %s", ss.Slave_IO_Running, ss.Slave_SQL_Running, timeout, ss.Last_IO_Error))
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
@@ -0,0 +1,87 @@
+// rotate_test.go
+package main
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestRotateRplPassword(t *testing.T) {
+	tests := []struct {
+		name  string
+		specs []simSpec
+		pass  string
+		hosts []map[string]string
+		fail  map[string]string // statements failing on each server
+		error string
+		want  map[string][]string // statements run on each server
+		after string              // replication password in use afterwards
+	}{
+		{"rotated", simTopology(), "n3w", []map[string]string{{"Host": "%"}}, nil, "", map[string][]string{
+			"db1:3306": {"ALTER USER 'repl'@'%' IDENTIFIED BY 'n3w'"},
+			"db2:3306": {"STOP SLAVE", "CHANGE MASTER TO master_password='n3w'", "START SLAVE"},
+			"db3:3306": {"STOP SLAVE", "CHANGE MASTER TO master_password='n3w'", "START SLAVE"}}, "n3w"},
+		{"slave down skipped", simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 2 }), "n3w", []map[string]string{{"Host": "10.0.0.%"}}, nil, "", map[string][]string{
+			"db1:3306": {"ALTER USER 'repl'@'10.0.0.%' IDENTIFIED BY 'n3w'"},
+			"db2:3306": nil,
+			"db3:3306": {"STOP SLAVE", "CHANGE MASTER TO master_password='n3w'", "START SLAVE"}}, "n3w"},
+		{"slave does not resume", simTopology(), "n3w", []map[string]string{{"Host": "%"}}, map[string]string{"db3:3306": "START SLAVE"},
+			"Slave db3:3306 did not resume replication with the new password: simulated statement failure. The old password was restored on the master, these slaves must be pointed back at it manually: db3:3306", map[string][]string{
+				"db1:3306": {"ALTER USER 'repl'@'%' IDENTIFIED BY 'n3w'", "ALTER USER 'repl'@'%' IDENTIFIED BY 'old'"},
+				"db2:3306": {"STOP SLAVE", "CHANGE MASTER TO master_password='n3w'", "START SLAVE", "STOP SLAVE", "CHANGE MASTER TO master_password='old'", "START SLAVE"},
+				"db3:3306": {"STOP SLAVE", "CHANGE MASTER TO master_password='n3w'", "START SLAVE", "STOP SLAVE", "CHANGE MASTER TO master_password='old'", "START SLAVE"}}, "old"},
+		{"master refuses the change", simTopology(), "n3w", []map[string]string{{"Host": "%"}}, map[string]string{"db1:3306": "ALTER USER"},
+			"Could not change password of repl@% on master: simulated statement failure", map[string][]string{
+				"db1:3306": {"ALTER USER 'repl'@'%' IDENTIFIED BY 'n3w'", "ALTER USER 'repl'@'%' IDENTIFIED BY 'old'"},
+				"db2:3306": nil, "db3:3306": nil}, "old"},
+		{"quote in password", simTopology(), "n3w'", []map[string]string{{"Host": "%"}}, nil, "The replication password cannot contain quotes", nil, "old"},
+		{"missing account", simTopology(), "n3w", nil, nil, "Replication user repl does not exist on master db1:3306", nil, "old"},
+		{"master down", simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 1 }), "n3w", nil, nil, "The master is down", nil, "old"},
+	}
+	for _, tt := range tests {
+		sims := simCluster(t, tt.specs)
+		current.rplUser, current.rplPass = "repl", "old"
+		current.master = simServerByURL("db1:3306")
+		sims["db1:3306"].rows = map[string][]map[string]string{"SELECT Host FROM mysql.user": tt.hosts}
+		for url, stmt := range tt.fail {
+			sims[url].fail = stmt
+		}
+		err := current.rotateRplPassword(tt.pass)
+		if tt.error == "" && err != nil || tt.error != "" && (err == nil || strings.HasPrefix(err.Error(), tt.error) == false) {
+			t.Errorf("%s: rotateRplPassword() = %v, want %q", tt.name, err, tt.error)
+		}
+		for url, want := range tt.want {
+			if got := sims[url].execs; strings.Join(got, "; ") != strings.Join(want, "; ") {
+				t.Errorf("%s: %s ran %q, want %q", tt.name, url, got, want)
+			}
+		}
+		if current.rplPass != tt.after {
+			t.Errorf("%s: replication password %q afterwards, want %q", tt.name, current.rplPass, tt.after)
+		}
+	}
+}
+
+func TestRotateRplPasswordDryRun(t *testing.T) {
+	defer func(d bool) { *dryRun = d }(*dryRun)
+	sims := simCluster(t, simTopology())
+	current.rplUser, current.rplPass = "repl", "old"
+	current.master = simServerByURL("db1:3306")
+	sims["db1:3306"].rows = map[string][]map[string]string{"SELECT Host FROM mysql.user": {{"Host": "%"}}}
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	logWriter.out = &out
+	*dryRun = true
+	if err := current.rotateRplPassword("n3w-s3cret"); err != nil {
+		t.Fatalf("rotateRplPassword() = %s", err)
+	}
+	for _, want := range []string{"DRY-RUN: [db1:3306] ALTER USER 'repl'@'%' IDENTIFIED BY '****'", "DRY-RUN: [db2:3306] CHANGE MASTER TO master_password='****'"} {
+		if strings.Contains(out.String(), want) == false {
+			t.Errorf("dry-run output does not contain %q", want)
+		}
+	}
+	if strings.Contains(out.String(), "n3w-s3cret") {
+		t.Errorf("dry-run output contains the new password:\n%s", out.String())
+	}
+}
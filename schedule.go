@@ -0,0 +1,59 @@
+// schedule.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+/* Parses the time of the scheduled switchover, in the local time zone unless it has an offset */
+func parseSchedule(s string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02T15:04", "2006-01-02 15:04"} {
+		t, err := time.ParseInLocation(layout, s, time.Local)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New(fmt.Sprintf("invalid switchover time %s, expected YYYY-MM-DDTHH:MM[:SS]", s))
+}
+
+/* Returns true once when the scheduled switchover is due: inside its window, with a healthy master, automation not suspended and, with -switchover-wait-delay, every slave within -maxdelay. A window ending before these conditions are met cancels the switchover. */
+func (c *Cluster) scheduledSwitchover() bool {
+	if c.scheduledAt.IsZero() || c.scheduleDone || time.Now().Before(c.scheduledAt) {
+		return false
+	}
+	end := c.scheduledAt.Add(time.Duration(*swWindow) * time.Minute)
+	if time.Now().After(end) {
+		logprintf("WARN : Scheduled switchover window ended at %s, switchover not performed", end.Format("2006-01-02 15:04"))
+		audit("Scheduled switchover of %s cancelled, window ended", c.master.URL)
+		c.scheduleDone = true
+		return false
+	}
+	reason := ""
+	switch {
+	case c.master.State == STATE_FAILED:
+		reason = "master is down"
+	case c.master.inMaintenance():
+		reason = "master is in maintenance"
+	case automationFrozen():
+		reason = "automation is suspended"
+	case *swWaitDelay && *maxDelay > 0:
+		for _, sl := range c.slaves {
+			if sl.State != STATE_FAILED && sl.inMaintenance() == false && sl.isDelayed() == false && sl.lag() > *maxDelay {
+				reason = fmt.Sprintf("slave %s is %d seconds behind master", sl.label(), sl.lag())
+				break
+			}
+		}
+	}
+	if reason != "" {
+		if c.scheduleLate == false {
+			logprintf("INFO : Scheduled switchover delayed, %s", reason)
+			c.scheduleLate = true
+		}
+		return false
+	}
+	logprintf("INFO : Starting scheduled switchover")
+	c.scheduleDone = true
+	return true
+}
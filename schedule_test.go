@@ -0,0 +1,79 @@
+// schedule_test.go
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		s    string
+		want time.Time
+		ok   bool
+	}{
+		{"2024-05-01T02:00:00", time.Date(2024, 5, 1, 2, 0, 0, 0, time.Local), true},
+		{"2024-05-01 02:00:30", time.Date(2024, 5, 1, 2, 0, 30, 0, time.Local), true},
+		{"2024-05-01T02:00", time.Date(2024, 5, 1, 2, 0, 0, 0, time.Local), true},
+		{"2024-05-01 02:00", time.Date(2024, 5, 1, 2, 0, 0, 0, time.Local), true},
+		{"2024-05-01T02:00:00Z", time.Date(2024, 5, 1, 2, 0, 0, 0, time.UTC), true},
+		{"2024-05-01T02:00:00+02:00", time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), true},
+		{"2024-05-01", time.Time{}, false},
+		{"tomorrow 2am", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		got, err := parseSchedule(tt.s)
+		if (err == nil) != tt.ok || got.Equal(tt.want) == false {
+			t.Errorf("parseSchedule(%q) = %v, %v, want %v", tt.s, got, err, tt.want)
+		}
+	}
+}
+
+func TestScheduledSwitchover(t *testing.T) {
+	defer func(w int64, d bool, m int64, p time.Time) {
+		*swWindow, *swWaitDelay, *maxDelay, panicUntil = w, d, m, p
+	}(*swWindow, *swWaitDelay, *maxDelay, panicUntil)
+	*swWindow, *maxDelay = 60, 30
+	tests := []struct {
+		name      string
+		at        time.Duration // start of the window from now
+		waitDelay bool
+		lag       int64 // delay of db2
+		setup     func()
+		want      bool
+		done      bool
+	}{
+		{"no schedule", 0, false, 0, nil, false, false},
+		{"before the window", time.Hour, false, 0, nil, false, false},
+		{"inside the window", -time.Minute, false, 0, nil, true, true},
+		{"after the window", -2 * time.Hour, false, 0, nil, false, true},
+		{"slave lagging", -time.Minute, true, 60, nil, false, false},
+		{"slave lagging without waiting", -time.Minute, false, 60, nil, true, true},
+		{"slave within maxdelay", -time.Minute, true, 10, nil, true, true},
+		{"master down", -time.Minute, false, 0, func() { current.master.State = STATE_FAILED }, false, false},
+		{"master in maintenance", -time.Minute, false, 0, func() { current.stateData.Maintenance = []string{"db1:3306"} }, false, false},
+		{"automation suspended", -time.Minute, false, 0, func() { panicUntil = time.Now().Add(time.Minute) }, false, false},
+	}
+	for _, tt := range tests {
+		simCluster(t, simTopology())
+		current.master = current.findMaster(true)
+		current.master.State = STATE_MASTER
+		panicUntil = time.Time{}
+		current.scheduledAt, current.scheduleDone, current.scheduleLate = time.Time{}, false, false
+		if tt.at != 0 {
+			current.scheduledAt = time.Now().Add(tt.at)
+		}
+		*swWaitDelay = tt.waitDelay
+		simServerByURL("db2:3306").Delay.Int64 = tt.lag
+		if tt.setup != nil {
+			tt.setup()
+		}
+		if got := current.scheduledSwitchover(); got != tt.want || current.scheduleDone != tt.done {
+			t.Errorf("%s: scheduledSwitchover() = %v, done %v, want %v, done %v", tt.name, got, current.scheduleDone, tt.want, tt.done)
+		}
+		// The switchover runs once
+		if tt.want && current.scheduledSwitchover() {
+			t.Errorf("%s: scheduled switchover due twice", tt.name)
+		}
+	}
+}
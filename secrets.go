@@ -0,0 +1,160 @@
+// secrets.go
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+/* Secret backends, keyed by the prefix of a password reference such as env:DB_PASSWORD */
+var secretBackends = map[string]func(ref string) (string, error){
+	"env":   envSecret,
+	"file":  fileSecret,
+	"vault": vaultSecret,
+}
+
+/* Returns a user and password pair, the password possibly containing colons */
+func splitCredentials(s string) (string, string) {
+	i := strings.Index(s, ":")
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], s[i+1:]
+}
+
+/* Resolves the password of a user:password pair when it references a secret, as <backend>:<reference> */
+func resolveCredentials(pair string) (string, error) {
+	u, p := splitCredentials(pair)
+	secret, err := resolveSecret(p)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("could not read password of %s: %s", u, err))
+	}
+	return u + ":" + secret, nil
+}
+
+/* Resolves a value referencing a secret, as <backend>:<reference>, other values being returned unchanged */
+func resolveSecret(v string) (string, error) {
+	i := strings.Index(v, ":")
+	if i <= 0 {
+		return v, nil
+	}
+	fn, ok := secretBackends[v[:i]]
+	if ok == false {
+		return v, nil
+	}
+	secret, err := fn(v[i+1:])
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("could not read secret from %s: %s", v[:i], err))
+	}
+	return secret, nil
+}
+
+/* Reads a secret from an environment variable */
+func envSecret(name string) (string, error) {
+	v, ok := os.LookupEnv(name)
+	if ok == false {
+		return "", errors.New(fmt.Sprintf("environment variable %s is not set", name))
+	}
+	return v, nil
+}
+
+/* Reads a secret from a file holding only it, such as a Docker or Kubernetes secret */
+func fileSecret(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+/* Reads a secret from HashiCorp Vault, referenced as <path>[#<key>], the key defaulting to password. The address and token are read from the VAULT_ADDR and VAULT_TOKEN environment variables, and both KV version 1 and 2 engines are supported. */
+func vaultSecret(ref string) (string, error) {
+	path, key := ref, "password"
+	if i := strings.Index(ref, "#"); i >= 0 {
+		path, key = ref[:i], ref[i+1:]
+	}
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return "", errors.New("VAULT_ADDR is not set")
+	}
+	req, err := http.NewRequest("GET", strings.TrimSuffix(addr, "/")+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", os.Getenv("VAULT_TOKEN"))
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New(fmt.Sprintf("GET %s returned %s", path, resp.Status))
+	}
+	var res struct {
+		Data map[string]interface{}
+	}
+	err = json.NewDecoder(resp.Body).Decode(&res)
+	if err != nil {
+		return "", err
+	}
+	data := res.Data
+	// KV version 2 nests the secret in a second data object
+	if inner, ok := data["data"].(map[string]interface{}); ok {
+		data = inner
+	}
+	v, ok := data[key].(string)
+	if ok == false {
+		return "", errors.New(fmt.Sprintf("no %s key in secret %s", key, path))
+	}
+	return v, nil
+}
+
+/* Reads the user:password pairs of the [client], [admin] and [replication] sections of a .cnf style credentials file */
+func loadCredentials(file string) (map[string]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	users := make(map[string]string)
+	pass := make(map[string]string)
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		k, v := strings.TrimSpace(kv[0]), strings.Trim(strings.TrimSpace(kv[1]), `"'`)
+		switch k {
+		case "user":
+			users[section] = v
+		case "password":
+			pass[section] = v
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	creds := make(map[string]string)
+	for section, u := range users {
+		creds[section] = u + ":" + pass[section]
+	}
+	return creds, nil
+}
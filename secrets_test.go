@@ -0,0 +1,139 @@
+// secrets_test.go
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveCredentials(t *testing.T) {
+	defer func(v string, ok bool) {
+		if ok {
+			os.Setenv("REPMGR_TEST_PASSWORD", v)
+		} else {
+			os.Unsetenv("REPMGR_TEST_PASSWORD")
+		}
+	}(os.LookupEnv("REPMGR_TEST_PASSWORD"))
+	os.Setenv("REPMGR_TEST_PASSWORD", "s3cr:et")
+	file := filepath.Join(t.TempDir(), "password")
+	err := ioutil.WriteFile(file, []byte("fr0m:file\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		pair string
+		want string
+		err  string
+	}{
+		{"plain password", "repmgr:plain", "repmgr:plain", ""},
+		{"password with colons", "repmgr:pa:ss", "repmgr:pa:ss", ""},
+		{"no password", "repmgr", "repmgr:", ""},
+		{"unknown backend", "repmgr:aws:secret", "repmgr:aws:secret", ""},
+		{"environment", "repmgr:env:REPMGR_TEST_PASSWORD", "repmgr:s3cr:et", ""},
+		{"unset environment", "repmgr:env:REPMGR_TEST_UNSET", "", "could not read password of repmgr: could not read secret from env: environment variable REPMGR_TEST_UNSET is not set"},
+		{"file", "repmgr:file:" + file, "repmgr:fr0m:file", ""},
+		{"missing file", "repmgr:file:" + file + ".missing", "", "could not read password of repmgr: could not read secret from file"},
+	}
+	for _, tt := range tests {
+		got, err := resolveCredentials(tt.pair)
+		if tt.err != "" {
+			if err == nil || strings.HasPrefix(err.Error(), tt.err) == false {
+				t.Errorf("%s: resolveCredentials() error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s: resolveCredentials() = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestVaultSecret(t *testing.T) {
+	defer func(a, tok string) { os.Setenv("VAULT_ADDR", a); os.Setenv("VAULT_TOKEN", tok) }(os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN"))
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "t0ken" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/repmgr":
+			w.Write([]byte(`{"data":{"password":"kv1","admin":"adm1"}}`))
+		case "/v1/kv/data/repmgr":
+			w.Write([]byte(`{"data":{"data":{"password":"kv2"},"metadata":{"version":3}}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	tests := []struct {
+		name  string
+		addr  string
+		token string
+		ref   string
+		want  string
+		err   string
+	}{
+		{"kv version 1", ts.URL, "t0ken", "secret/repmgr", "kv1", ""},
+		{"kv version 1 key", ts.URL + "/", "t0ken", "/secret/repmgr#admin", "adm1", ""},
+		{"kv version 2", ts.URL, "t0ken", "kv/data/repmgr", "kv2", ""},
+		{"missing key", ts.URL, "t0ken", "secret/repmgr#replication", "", "no replication key in secret secret/repmgr"},
+		{"missing secret", ts.URL, "t0ken", "secret/other", "", "GET secret/other returned 404 Not Found"},
+		{"bad token", ts.URL, "wrong", "secret/repmgr", "", "GET secret/repmgr returned 403 Forbidden"},
+		{"no address", "", "t0ken", "secret/repmgr", "", "VAULT_ADDR is not set"},
+	}
+	for _, tt := range tests {
+		os.Setenv("VAULT_ADDR", tt.addr)
+		os.Setenv("VAULT_TOKEN", tt.token)
+		got, err := resolveSecret("vault:" + tt.ref)
+		if tt.err != "" {
+			if err == nil || err.Error() != "could not read secret from vault: "+tt.err {
+				t.Errorf("%s: resolveSecret() error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s: resolveSecret() = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestLoadCredentials(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "repmgr.cnf")
+	err := ioutil.WriteFile(file, []byte(`# replication-manager credentials
+[client]
+user = repmgr
+password = "p@ss=word"
+
+; admin commands
+[admin]
+user=root
+password='env:ROOT_PASSWORD'
+
+[replication]
+user = repl
+
+[mysqld]
+user = mysql
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, err := loadCredentials(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for section, want := range map[string]string{"client": "repmgr:p@ss=word", "admin": "root:env:ROOT_PASSWORD", "replication": "repl:", "mysqld": "mysql:"} {
+		if creds[section] != want {
+			t.Errorf("[%s] credentials %q, want %q", section, creds[section], want)
+		}
+	}
+	if _, err := loadCredentials(file + ".missing"); err == nil {
+		t.Error("loadCredentials() of a missing file succeeded")
+	}
+}
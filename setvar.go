@@ -0,0 +1,96 @@
+// setvar.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/* Only replication related variables can be pushed cluster-wide */
+var replVarPrefixes = []string{"slave_", "rpl_", "binlog_", "gtid_strict_mode", "gtid_ignore_duplicates", "relay_log_", "master_", "sync_", "expire_logs_days", "max_binlog_", "max_relay_log_"}
+
+var (
+	varNameRe  = regexp.MustCompile(`^[a-z0-9_]+$`)
+	varValueRe = regexp.MustCompile(`^[A-Za-z0-9_.,:-]*$`)
+)
+
+/* Parses a name=value pair and checks that it designates a replication variable */
+func parseVariable(s string) (string, string, error) {
+	items := strings.SplitN(s, "=", 2)
+	if len(items) != 2 {
+		return "", "", errors.New("Variable must be specified in the name=value format")
+	}
+	name := strings.ToLower(strings.TrimSpace(items[0]))
+	value := strings.TrimSpace(items[1])
+	if varNameRe.MatchString(name) == false || varValueRe.MatchString(value) == false {
+		return "", "", errors.New(fmt.Sprintf("Invalid variable name or value: %s", s))
+	}
+	for _, p := range replVarPrefixes {
+		if strings.HasPrefix(name, p) {
+			return name, value, nil
+		}
+	}
+	return "", "", errors.New(fmt.Sprintf("Variable %s is not a replication variable", name))
+}
+
+/* Returns a value suitable for a SET GLOBAL statement */
+func sqlValue(v string) string {
+	if _, err := strconv.ParseFloat(v, 64); err == nil {
+		return v
+	}
+	return "'" + v + "'"
+}
+
+/* Sets a global variable on every server of the cluster. If any server fails, the servers already changed are restored to their previous value. */
+func (c *Cluster) pushVariable(s string) error {
+	name, value, err := parseVariable(s)
+	if err != nil {
+		return err
+	}
+	for _, sv := range c.servers {
+		if sv.State == STATE_FAILED {
+			return errors.New(fmt.Sprintf("Server %s is down, cannot set %s consistently", sv.URL, name))
+		}
+	}
+	audit("Setting %s=%s on all servers", name, value)
+	old := make(map[*ServerMonitor]string)
+	for _, sv := range c.servers {
+		var cur string
+		var rows []map[string]string
+		rows, err = sv.query("SELECT @@GLOBAL." + name + " AS value")
+		if err == nil && len(rows) == 0 {
+			err = errors.New("unknown variable")
+		}
+		if err == nil {
+			cur = rows[0]["value"]
+			err = sv.backend().Exec("SET GLOBAL " + name + " = " + sqlValue(value))
+		}
+		if err != nil {
+			log.Printf("ERROR: %-21s %s=%s failed: %s", sv.URL, name, value, err)
+			audit("Setting %s=%s failed on %s: %s", name, value, sv.URL, err)
+			rollbackVariable(name, old)
+			return errors.New(fmt.Sprintf("Could not set %s on %s, changes rolled back", name, sv.URL))
+		}
+		old[sv] = cur
+		log.Printf("INFO : %-21s %s changed from %s to %s", sv.URL, name, cur, value)
+		audit("Set %s on %s, was %s, now %s", name, sv.URL, cur, value)
+	}
+	return nil
+}
+
+func rollbackVariable(name string, old map[*ServerMonitor]string) {
+	for sv, v := range old {
+		err := sv.backend().Exec("SET GLOBAL " + name + " = " + sqlValue(v))
+		if err != nil {
+			log.Printf("ERROR: %-21s could not restore %s=%s: %s", sv.URL, name, v, err)
+			audit("Rollback of %s=%s failed on %s: %s", name, v, sv.URL, err)
+			continue
+		}
+		log.Printf("INFO : %-21s %s restored to %s", sv.URL, name, v)
+		audit("Rolled back %s on %s to %s", name, sv.URL, v)
+	}
+}
@@ -0,0 +1,60 @@
+// setvar_test.go
+package main
+
+import (
+	"testing"
+)
+
+func TestParseVariable(t *testing.T) {
+	tests := []struct {
+		in    string
+		name  string
+		value string
+		ok    bool
+	}{
+		{"slave_parallel_threads=4", "slave_parallel_threads", "4", true},
+		{" SYNC_BINLOG = 1 ", "sync_binlog", "1", true},
+		{"binlog_row_image=MINIMAL", "binlog_row_image", "MINIMAL", true},
+		{"max_connections=1000", "", "", false},
+		{"slave_parallel_threads", "", "", false},
+		{"slave_parallel_mode=x'; DROP TABLE t", "", "", false},
+	}
+	for _, tt := range tests {
+		name, value, err := parseVariable(tt.in)
+		if name != tt.name || value != tt.value || (err == nil) != tt.ok {
+			t.Errorf("parseVariable(%q) = %q, %q, %v, want %q, %q, success %v", tt.in, name, value, err, tt.name, tt.value, tt.ok)
+		}
+	}
+}
+
+func TestPushVariable(t *testing.T) {
+	tests := []struct {
+		name string
+		down uint   // server down
+		fail uint   // server refusing the change
+		want string // value on every server afterwards
+		ok   bool
+	}{
+		{"all servers changed", 0, 0, "4", true},
+		{"change rolled back", 0, 3, "0", false},
+		{"server down", 3, 0, "0", false},
+	}
+	for _, tt := range tests {
+		sims := simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == tt.down }))
+		for _, s := range sims {
+			s.vars["SLAVE_PARALLEL_THREADS"] = "0"
+		}
+		if tt.fail != 0 {
+			sims[simTopology()[tt.fail-1].url].fail = "SET GLOBAL slave_parallel_threads"
+		}
+		err := current.pushVariable("slave_parallel_threads=4")
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: pushVariable() = %v, want success %v", tt.name, err, tt.ok)
+		}
+		for url, s := range sims {
+			if s.down == false && s.vars["SLAVE_PARALLEL_THREADS"] != tt.want {
+				t.Errorf("%s: slave_parallel_threads = %s on %s, want %s", tt.name, s.vars["SLAVE_PARALLEL_THREADS"], url, tt.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,42 @@
+// socket.go
+package main
+
+import (
+	"github.com/tanji/mariadb-tools/dbhelper"
+	"net"
+	"strings"
+)
+
+/* Returns true if the host option entry is a unix socket, in the unix:<path> format */
+func isSocket(url string) bool {
+	return strings.HasPrefix(url, "unix:")
+}
+
+/* Returns the key of a host option entry in the alias and label maps, host:port or the socket entry itself */
+func hostKey(url string) string {
+	if isSocket(url) {
+		return url
+	}
+	return net.JoinHostPort(splitHostPort(url))
+}
+
+/* Returns the driver address of the server, its own unix socket or TCP */
+func (sm *ServerMonitor) address() string {
+	if sm.Socket != "" {
+		return "unix(" + sm.Socket + ")"
+	}
+	return tcpAddress(sm.Host, sm.Port)
+}
+
+/* Resolves the TCP endpoint of a server monitored through its unix socket, which slaves need to replicate from it: the port it listens on and the address of its host name, the loopback address if it cannot be resolved */
+func (sm *ServerMonitor) socketEndpoint() {
+	hostname, port := sm.backend().Variable("HOSTNAME"), sm.backend().Variable("PORT")
+	if port != "" {
+		sm.Port = port
+	}
+	ip, err := dbhelper.CheckHostAddr(hostname)
+	if hostname == "" || err != nil {
+		ip = "127.0.0.1"
+	}
+	sm.IP = ip
+}
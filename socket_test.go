@@ -0,0 +1,78 @@
+// socket_test.go
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestHostKey(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"db1", "db1:3306"},
+		{"db1:3307", "db1:3307"},
+		{"[2001:db8::1]", "[2001:db8::1]:3306"},
+		{"[2001:db8::1]:3307", "[2001:db8::1]:3307"},
+		{"unix:/run/mysqld/mysqld.sock", "unix:/run/mysqld/mysqld.sock"},
+	}
+	for _, tt := range tests {
+		if got := hostKey(tt.url); got != tt.want {
+			t.Errorf("hostKey(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestServerAddress(t *testing.T) {
+	defer func(u bool) { useTLS = u }(useTLS)
+	useTLS = true
+	tests := []struct {
+		sm      ServerMonitor
+		address string
+		tls     bool
+	}{
+		{ServerMonitor{Host: "localhost", Port: "3306", Socket: "/run/mysqld/mysqld.sock"}, "unix(/run/mysqld/mysqld.sock)", false},
+		{ServerMonitor{Host: "2001:db8::1", Port: "3307"}, "tcp([2001:db8::1]:3307)", true},
+	}
+	for _, tt := range tests {
+		address := tt.sm.address()
+		if address != tt.address {
+			t.Errorf("address() = %q, want %q", address, tt.address)
+		}
+		// Socket connections are local and not encrypted
+		if got := strings.Contains(dsn(address, "repmgr", "pw"), "tls=repmgr"); got != tt.tls {
+			t.Errorf("dsn(%q) TLS %v, want %v", address, got, tt.tls)
+		}
+	}
+}
+
+func TestSocketServer(t *testing.T) {
+	defer func(f, s string) { *failover, *stateFile = f, s }(*failover, *stateFile)
+	*failover, *stateFile = "force", ""
+	const sock = "unix:/run/mysqld/db3.sock"
+	sims := simCluster(t, simChange(simTopology(), func(sp *simSpec) {
+		if sp.id == 3 {
+			sp.url = sock
+		}
+		sp.down = sp.id == 1
+	}))
+	sm := simServerByURL(sock)
+	if sm.Host != "localhost" || sm.Port != "3306" || sm.Socket != "/run/mysqld/db3.sock" || sm.IP != "127.0.0.1" {
+		t.Errorf("server %s: host %s, port %s, socket %s, IP %s", sock, sm.Host, sm.Port, sm.Socket, sm.IP)
+	}
+	// Slaves reach the server through the port it listens on
+	sims[sock].vars["PORT"] = "3307"
+	sm.socketEndpoint()
+	if sm.Port != "3307" || sm.IP != "127.0.0.1" {
+		t.Errorf("endpoint of %s %s:%s, want 127.0.0.1:3307", sock, sm.IP, sm.Port)
+	}
+	current.master = current.findMaster(false)
+	if nmUrl, err := current.Failover(context.Background()); nmUrl != sock {
+		t.Fatalf("Failover() = %q, %v, want %s", nmUrl, err, sock)
+	}
+	if sims["db2:3306"].ran("CHANGE MASTER TO master_host='127.0.0.1', master_port=3307") == false {
+		t.Errorf("db2:3306 not repointed to the socket server endpoint: %q", sims["db2:3306"].execs)
+	}
+}
@@ -0,0 +1,102 @@
+// state.go
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+/* A failover or switchover, with the GTID positions (or binlog coordinates) of the masters and the logged steps */
+type FailoverEvent struct {
+	Time      time.Time
+	Type      string
+	OldMaster string
+	NewMaster string
+	OldPos    string
+	NewPos    string
+	Trigger   string
+	User      string
+	Result    string
+	Steps     []string
+}
+
+/* Cluster view persisted across restarts */
+type StateFile struct {
+	Master       string
+	FailedMaster string
+	Servers      map[string]string
+	Maintenance  []string
+	History      []FailoverEvent
+	Operation    *Checkpoint // failover or switchover in progress
+}
+
+/* Loads the state file if it exists. A missing file is not an error, it will be created on the first save. */
+func (c *Cluster) loadState() error {
+	if *stateFile == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(c.statePath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, &c.stateData)
+}
+
+/* Writes the current topology view to the state file. The file is replaced atomically so that a crash while saving cannot leave it truncated. Nothing is written in dry-run mode, which must not leave state for the next run. */
+func (c *Cluster) saveState() {
+	if *stateFile == "" || *dryRun {
+		return
+	}
+	if c.master != nil {
+		c.stateData.Master = c.master.URL
+	}
+	c.stateData.FailedMaster = c.failedMasterURL
+	c.stateData.Servers = make(map[string]string)
+	for _, s := range c.servers {
+		if s != nil {
+			c.stateData.Servers[s.URL] = s.State
+		}
+	}
+	b, err := json.MarshalIndent(c.stateData, "", "  ")
+	if err != nil {
+		alertLog("WARN : Could not encode state: %s", err)
+		return
+	}
+	tmp := c.statePath() + ".tmp"
+	err = ioutil.WriteFile(tmp, b, 0640)
+	if err == nil {
+		err = os.Rename(tmp, c.statePath())
+	}
+	if err != nil {
+		alertLog("WARN : Could not write state file: %s", err)
+	}
+}
+
+/* Returns the state file of the cluster. Each cluster of a clusters file has its own, suffixed with the cluster name. */
+func (c *Cluster) statePath() string {
+	if *clustersFile != "" {
+		return *stateFile + "." + c.Name
+	}
+	return *stateFile
+}
+
+/* Returns the master recorded in the state file, if it is still part of the topology and is not configured as a slave */
+func (c *Cluster) stateMaster() *ServerMonitor {
+	if c.stateData.Master == "" {
+		return nil
+	}
+	for _, s := range c.servers {
+		if s.URL == c.stateData.Master {
+			if s.UsingGtid != "" {
+				return nil
+			}
+			return s
+		}
+	}
+	return nil
+}
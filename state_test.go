@@ -0,0 +1,75 @@
+// state_test.go
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	defer func(f string, d bool) { *stateFile, *dryRun = f, d }(*stateFile, *dryRun)
+	*stateFile, *dryRun = filepath.Join(t.TempDir(), "repmgr.state"), false
+	simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 1 }))
+	current.master = simServerByURL("db2:3306")
+	current.master.State = STATE_MASTER
+	current.failedMasterURL = "db1:3306"
+	current.saveState()
+	if _, err := os.Stat(*stateFile + ".tmp"); os.IsNotExist(err) == false {
+		t.Errorf("temporary file left after the save: %v", err)
+	}
+
+	// A restart starts from an empty view and reads it back
+	current.stateData = StateFile{}
+	if err := current.loadState(); err != nil {
+		t.Fatalf("loadState() = %s", err)
+	}
+	if current.stateData.Master != "db2:3306" || current.stateData.FailedMaster != "db1:3306" {
+		t.Errorf("loaded master %q, failed master %q, want db2:3306 and db1:3306", current.stateData.Master, current.stateData.FailedMaster)
+	}
+	want := map[string]string{"db1:3306": STATE_FAILED, "db2:3306": STATE_MASTER, "db3:3306": STATE_SLAVE}
+	for url, st := range want {
+		if current.stateData.Servers[url] != st {
+			t.Errorf("loaded state of %s = %q, want %q", url, current.stateData.Servers[url], st)
+		}
+	}
+}
+
+func TestStateNotSaved(t *testing.T) {
+	defer func(f string, d bool) { *stateFile, *dryRun = f, d }(*stateFile, *dryRun)
+	*stateFile, *dryRun = filepath.Join(t.TempDir(), "repmgr.state"), true
+	simCluster(t, simTopology())
+	current.master = simServerByURL("db1:3306")
+	current.saveState()
+	if _, err := os.Stat(*stateFile); os.IsNotExist(err) == false {
+		t.Errorf("state file written in dry-run mode: %v", err)
+	}
+	// A missing file is the first run, not an error
+	if err := current.loadState(); err != nil || current.stateData.Master != "" {
+		t.Errorf("loadState() = %v with master %q, want no error and no master", err, current.stateData.Master)
+	}
+}
+
+func TestStateMaster(t *testing.T) {
+	tests := []struct {
+		name   string
+		master string
+		want   string
+	}{
+		{"recorded master", "db1:3306", "db1:3306"},
+		{"recorded master now a slave", "db2:3306", ""},
+		{"recorded master removed from the hosts", "db9:3306", ""},
+		{"nothing recorded", "", ""},
+	}
+	for _, tt := range tests {
+		simCluster(t, simTopology())
+		current.stateData.Master = tt.master
+		got := ""
+		if m := current.stateMaster(); m != nil {
+			got = m.URL
+		}
+		if got != tt.want {
+			t.Errorf("%s: stateMaster() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -14,13 +14,19 @@ func NewTermLog(sz int) TermLog {
 }
 
 func (tl *TermLog) Add(s string) {
+	recordStep(s)
+	logConsole(s)
 	ts := time.Now().Format("2006-01-02 15:04:05")
 	s = " " + ts + " " + s
 	*tl = shift(*tl, s)
 }
 
 func (tl TermLog) Print() {
+	_, h := termbox.Size()
 	for _, line := range tl {
+		if vy >= h {
+			break
+		}
 		printTb(0, vy, termbox.ColorWhite, termbox.ColorBlack, line)
 		vy++
 	}
@@ -0,0 +1,92 @@
+// throughput.go
+package main
+
+import (
+	"strconv"
+	"time"
+)
+
+type binlogFile struct {
+	Name string
+	Size uint64
+}
+
+/* Samples binary log growth on servers with log_bin enabled. The rate is computed from the total size of the binary logs so that file rotation is accounted for. */
+func (sm *ServerMonitor) sampleBinlogRate() {
+	if sm.LogBin != "ON" {
+		return
+	}
+	rows, err := sm.query("SHOW BINARY LOGS")
+	if err != nil {
+		return
+	}
+	var size uint64
+	files := make([]binlogFile, len(rows))
+	for i, r := range rows {
+		files[i].Name = r["Log_name"]
+		files[i].Size, _ = strconv.ParseUint(r["File_size"], 10, 64)
+		size += files[i].Size
+	}
+	now := time.Now()
+	// A purge shrinks the total, skip this sample in that case
+	if sm.binlogSampled.IsZero() == false && size >= sm.BinlogSize {
+		sm.BinlogRate = float64(size-sm.BinlogSize) / now.Sub(sm.binlogSampled).Seconds()
+	}
+	sm.BinlogSize = size
+	sm.binlogFiles = files
+	sm.binlogSampled = now
+}
+
+/* Samples the rate at which the slave SQL thread applies the master binary logs, in master binlog bytes per second */
+func (sm *ServerMonitor) sampleApplyRate(file string, pos uint64) {
+	now := time.Now()
+	if sm.applySampled.IsZero() == false && sm.cluster.master != nil {
+		prev, ok1 := sm.cluster.master.binlogOffset(sm.ExecFile, sm.ExecPos)
+		cur, ok2 := sm.cluster.master.binlogOffset(file, pos)
+		if ok1 && ok2 && cur >= prev {
+			sm.ApplyRate = float64(cur-prev) / now.Sub(sm.applySampled).Seconds()
+		}
+	}
+	sm.ExecFile = file
+	sm.ExecPos = pos
+	sm.applySampled = now
+}
+
+/* Converts binlog coordinates into an absolute offset in the whole binary log sequence of the server */
+func (sm *ServerMonitor) binlogOffset(file string, pos uint64) (uint64, bool) {
+	var offset uint64
+	for _, f := range sm.binlogFiles {
+		if f.Name == file {
+			return offset + pos, true
+		}
+		offset += f.Size
+	}
+	return 0, false
+}
+
+/* Returns the number of master binlog bytes the slave still has to apply */
+func (sm *ServerMonitor) applyBacklog() (uint64, bool) {
+	if sm.cluster.master == nil {
+		return 0, false
+	}
+	off, ok := sm.cluster.master.binlogOffset(sm.ExecFile, sm.ExecPos)
+	if ok == false || off > sm.cluster.master.BinlogSize {
+		return 0, false
+	}
+	return sm.cluster.master.BinlogSize - off, true
+}
+
+/* Predicts how long the slave needs to catch up with the master once writes are frozen, based on its recent apply rate */
+func (sm *ServerMonitor) catchupEstimate() (time.Duration, bool) {
+	backlog, ok := sm.applyBacklog()
+	if ok == false {
+		return 0, false
+	}
+	if backlog == 0 {
+		return 0, true
+	}
+	if sm.ApplyRate <= 0 {
+		return 0, false
+	}
+	return time.Duration(float64(backlog) / sm.ApplyRate * float64(time.Second)).Round(time.Second), true
+}
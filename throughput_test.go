@@ -0,0 +1,73 @@
+// throughput_test.go
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+/* Returns the SHOW BINARY LOGS rows of files with the sizes */
+func simBinlogs(sizes ...string) []map[string]string {
+	var rows []map[string]string
+	for i, s := range sizes {
+		rows = append(rows, map[string]string{"Log_name": fmt.Sprintf("mysql-bin.%06d", i+1), "File_size": s})
+	}
+	return rows
+}
+
+func TestBinlogRate(t *testing.T) {
+	tests := []struct {
+		name   string
+		before []map[string]string
+		after  []map[string]string
+		rate   float64
+	}{
+		{"growth", simBinlogs("1000"), simBinlogs("3000"), 200},
+		{"rotation", simBinlogs("1000"), simBinlogs("2000", "1000"), 200},
+		{"purge", simBinlogs("1000", "2000"), simBinlogs("2500"), 0},
+	}
+	for _, tt := range tests {
+		sims := simCluster(t, simTopology())
+		master := simServerByURL("db1:3306")
+		master.LogBin = "ON"
+		sims["db1:3306"].rows = map[string][]map[string]string{"SHOW BINARY LOGS": tt.before}
+		master.sampleBinlogRate()
+		master.binlogSampled = master.binlogSampled.Add(-10 * time.Second)
+		sims["db1:3306"].rows["SHOW BINARY LOGS"] = tt.after
+		master.sampleBinlogRate()
+		if master.BinlogRate < tt.rate*0.99 || master.BinlogRate > tt.rate*1.01 {
+			t.Errorf("%s: binlog rate %.1f, want %.1f", tt.name, master.BinlogRate, tt.rate)
+		}
+	}
+}
+
+func TestCatchupEstimate(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		pos  uint64
+		rate float64
+		want time.Duration
+		ok   bool
+	}{
+		{"behind in the current file", "mysql-bin.000002", 500, 100, 5 * time.Second, true},
+		{"behind in the previous file", "mysql-bin.000001", 800, 100, 12 * time.Second, true},
+		{"caught up", "mysql-bin.000002", 1000, 0, 0, true},
+		{"no apply rate yet", "mysql-bin.000002", 500, 0, 0, false},
+		{"file purged from the master", "mysql-bin.000000", 500, 100, 0, false},
+	}
+	for _, tt := range tests {
+		sims := simCluster(t, simTopology())
+		current.master = current.findMaster(true)
+		current.master.LogBin = "ON"
+		sims["db1:3306"].rows = map[string][]map[string]string{"SHOW BINARY LOGS": simBinlogs("1000", "1000")}
+		current.master.sampleBinlogRate()
+		slave := simServerByURL("db2:3306")
+		slave.ExecFile, slave.ExecPos, slave.ApplyRate = tt.file, tt.pos, tt.rate
+		got, ok := slave.catchupEstimate()
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%s: catchupEstimate() = %s, %v, want %s, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
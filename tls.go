@@ -0,0 +1,75 @@
+// tls.go
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"io/ioutil"
+	"strings"
+)
+
+/* True when client connections to the servers are encrypted */
+var useTLS bool
+
+/* Registers the TLS configuration built from the ssl options with the MySQL driver. The server certificate is verified against the CA when one is given, and the client certificate is presented to servers requiring X509 authentication. */
+func registerTLS() error {
+	if *sslCA == "" && *sslCert == "" && *sslKey == "" {
+		return nil
+	}
+	cfg := &tls.Config{}
+	if *sslCA != "" {
+		pem, err := ioutil.ReadFile(*sslCA)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if pool.AppendCertsFromPEM(pem) == false {
+			return errors.New("no certificate found in " + *sslCA)
+		}
+		cfg.RootCAs = pool
+	} else {
+		cfg.InsecureSkipVerify = true
+	}
+	if *sslCert != "" || *sslKey != "" {
+		cert, err := tls.LoadX509KeyPair(*sslCert, *sslKey)
+		if err != nil {
+			return err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	err := mysql.RegisterTLSConfig("repmgr", cfg)
+	if err != nil {
+		return err
+	}
+	useTLS = true
+	return nil
+}
+
+/* Opens a connection to a server with the connect and read timeouts, encrypted if TLS is configured */
+func (c *Cluster) dbConnect(host string, port string) (*sqlx.DB, error) {
+	return dbConnectAs(tcpAddress(host, port), c.dbUser, c.dbPass)
+}
+
+/* Connects to a server address, in the driver tcp(host:port) or unix(path) format, with the given credentials */
+func dbConnectAs(address string, user string, pass string) (*sqlx.DB, error) {
+	return sqlx.Connect("mysql", dsn(address, user, pass))
+}
+
+/* Returns the driver data source name of a server address, with the timeouts and the TLS configuration of TCP connections */
+func dsn(address string, user string, pass string) string {
+	params := []string{}
+	if *connectTimeout > 0 {
+		params = append(params, fmt.Sprintf("timeout=%ds", *connectTimeout))
+	}
+	if *readTimeout > 0 {
+		params = append(params, fmt.Sprintf("readTimeout=%ds", *readTimeout), fmt.Sprintf("writeTimeout=%ds", *readTimeout))
+	}
+	if useTLS && strings.HasPrefix(address, "tcp(") {
+		params = append(params, "tls=repmgr")
+	}
+	return user + ":" + pass + "@" + address + "/?" + strings.Join(params, "&")
+}
@@ -0,0 +1,92 @@
+// tls_test.go
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+/* Writes a self-signed certificate and its key in the directory, returning their paths */
+func simCert(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "repmgr"}, NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour), IsCA: true, BasicConstraintsValid: true}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kder, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, kfile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	ioutil.WriteFile(cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(kfile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder}), 0600)
+	return cert, kfile
+}
+
+func TestRegisterTLS(t *testing.T) {
+	defer func(ca, cert, key string) { *sslCA, *sslCert, *sslKey, useTLS = ca, cert, key, false }(*sslCA, *sslCert, *sslKey)
+	dir := t.TempDir()
+	cert, key := simCert(t, dir)
+	empty := filepath.Join(dir, "empty.pem")
+	ioutil.WriteFile(empty, []byte("not a certificate\n"), 0600)
+	tests := []struct {
+		name    string
+		ca      string
+		cert    string
+		key     string
+		ok      bool
+		enabled bool
+	}{
+		{"no ssl options", "", "", "", true, false},
+		{"verified servers", cert, "", "", true, true},
+		{"client certificate", cert, cert, key, true, true},
+		{"client certificate without CA", "", cert, key, true, true},
+		{"CA without certificate", empty, "", "", false, false},
+		{"missing CA", filepath.Join(dir, "missing.pem"), "", "", false, false},
+		{"certificate without key", cert, cert, "", false, false},
+	}
+	for _, tt := range tests {
+		*sslCA, *sslCert, *sslKey, useTLS = tt.ca, tt.cert, tt.key, false
+		err := registerTLS()
+		if (err == nil) != tt.ok || useTLS != tt.enabled {
+			t.Errorf("%s: registerTLS() = %v with TLS %v, want success %v and TLS %v", tt.name, err, useTLS, tt.ok, tt.enabled)
+		}
+	}
+}
+
+func TestDSN(t *testing.T) {
+	defer func(c, r int64) { *connectTimeout, *readTimeout, useTLS = c, r, false }(*connectTimeout, *readTimeout)
+	tests := []struct {
+		name    string
+		address string
+		tls     bool
+		connect int64
+		read    int64
+		want    string
+	}{
+		{"plain", "tcp(db1:3306)", false, 5, 10, "repmgr:secret@tcp(db1:3306)/?timeout=5s&readTimeout=10s&writeTimeout=10s"},
+		{"encrypted", "tcp(db1:3306)", true, 5, 10, "repmgr:secret@tcp(db1:3306)/?timeout=5s&readTimeout=10s&writeTimeout=10s&tls=repmgr"},
+		{"unix socket", "unix(/run/mysqld/mysqld.sock)", true, 5, 10, "repmgr:secret@unix(/run/mysqld/mysqld.sock)/?timeout=5s&readTimeout=10s&writeTimeout=10s"},
+		{"connect timeout only", "tcp(db1:3306)", false, 30, 0, "repmgr:secret@tcp(db1:3306)/?timeout=30s"},
+		{"driver defaults", "tcp(db1:3306)", false, 0, 0, "repmgr:secret@tcp(db1:3306)/?"},
+	}
+	for _, tt := range tests {
+		useTLS, *connectTimeout, *readTimeout = tt.tls, tt.connect, tt.read
+		if got := dsn(tt.address, "repmgr", "secret"); got != tt.want {
+			t.Errorf("%s: dsn() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
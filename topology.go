@@ -0,0 +1,89 @@
+// topology.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strings"
+)
+
+/* Writes the replication tree of the cluster as a Graphviz subgraph. Slaves are linked to the server whose id they replicate from, so chains through intermediate masters appear as such; a master outside of the monitored servers is drawn dashed. */
+func (c *Cluster) writeDOTCluster(w io.Writer) {
+	fmt.Fprintf(w, "  subgraph %s {\n    label=%s;\n", dotQuote("cluster_"+c.clusterName()), dotQuote(c.clusterName()))
+	l := c.knownServers()
+	ids := make(map[uint]*ServerMonitor)
+	for _, s := range l {
+		if s.ServerId != 0 {
+			ids[s.ServerId] = s
+		}
+	}
+	for _, s := range l {
+		attrs := ""
+		switch {
+		case s.State == STATE_FAILED:
+			attrs = ", color=red, fontcolor=red"
+		case s == c.master:
+			attrs = ", style=bold"
+		case s.State == STATE_UNCONN:
+			attrs = ", color=gray"
+		}
+		fmt.Fprintf(w, "    %s [label=%s%s];\n", dotQuote(s.URL), dotQuote(s.label()+"\n"+s.State+"\n"+s.Version), attrs)
+	}
+	for _, s := range l {
+		if s.UsingGtid == "" || s.State == STATE_FAILED {
+			continue
+		}
+		edge := fmt.Sprintf("%s, %ds", s.healthCheck(), s.Delay.Int64)
+		if p, ok := ids[s.MasterServerId]; ok {
+			fmt.Fprintf(w, "    %s -> %s [label=%s];\n", dotQuote(p.URL), dotQuote(s.URL), dotQuote(edge))
+		} else if s.MasterHost != "" {
+			fmt.Fprintf(w, "    %s [style=dashed];\n    %s -> %s [label=%s];\n", dotQuote(s.MasterHost), dotQuote(s.MasterHost), dotQuote(s.URL), dotQuote(edge))
+		}
+	}
+	fmt.Fprint(w, "  }\n")
+}
+
+/* Returns a quoted DOT identifier, with line breaks turned into DOT escapes */
+func dotQuote(s string) string {
+	s = strings.Replace(s, `"`, `\"`, -1)
+	return `"` + strings.Replace(s, "\n", `\n`, -1) + `"`
+}
+
+/* Writes the replication trees of all clusters as a Graphviz digraph */
+func writeDOT(w io.Writer) {
+	fmt.Fprint(w, "digraph replication {\n  node [shape=box];\n")
+	for _, c := range clusters {
+		logWriter.attribute(c.clusterName())
+		c.refreshTopology(context.Background())
+		c.writeDOTCluster(w)
+	}
+	fmt.Fprint(w, "}\n")
+}
+
+/* Serves the replication tree in DOT, or as SVG with ?format=svg when Graphviz is installed */
+func (c *Cluster) apiTopology(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	buf.WriteString("digraph replication {\n  node [shape=box];\n")
+	c.writeDOTCluster(&buf)
+	buf.WriteString("}\n")
+	if r.URL.Query().Get("format") != "svg" {
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		buf.WriteTo(w)
+		return
+	}
+	cmd := exec.Command("dot", "-Tsvg")
+	cmd.Stdin = &buf
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	svg, err := cmd.Output()
+	if err != nil {
+		http.Error(w, "Could not render SVG with Graphviz: "+strings.TrimSpace(err.Error()+" "+stderr.String()), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Write(svg)
+}
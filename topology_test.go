@@ -0,0 +1,75 @@
+// topology_test.go
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/* Builds a chained topology: db4 replicates from the intermediate master db3, db5 from a master outside of the monitored servers and db2 is down */
+func simChain(t *testing.T) {
+	specs := append(simTopology(), simSpec{url: "db4:3306", id: 4, master: 3, gtid: "0-1-115"}, simSpec{url: "db5:3306", id: 5, master: 9, gtid: "0-1-100"})
+	simCluster(t, simChange(specs, func(sp *simSpec) { sp.down = sp.id == 2 }))
+	current.master = current.findMaster(true)
+	current.master.State = STATE_MASTER
+	simServerByURL("db5:3306").MasterHost = "ext1"
+}
+
+func TestWriteDOTCluster(t *testing.T) {
+	simChain(t)
+	var buf bytes.Buffer
+	current.writeDOTCluster(&buf)
+	out := buf.String()
+	for _, want := range []string{
+		`subgraph "cluster_default" {`,
+		`"db1:3306" [label="db1:3306\nMaster\n", style=bold];`,
+		`"db2:3306" [label="db2:3306\nFailed\n", color=red, fontcolor=red];`,
+		`"db1:3306" -> "db3:3306" [label="Running OK, 0s"];`,
+		`"db3:3306" -> "db4:3306" [label="Running OK, 0s"];`,
+		`"ext1" [style=dashed];`,
+		`"ext1" -> "db5:3306" [label="Running OK, 0s"];`,
+	} {
+		if strings.Contains(out, want) == false {
+			t.Errorf("DOT output does not contain %q:\n%s", want, out)
+		}
+	}
+	// A failed slave has no replication edge
+	if strings.Contains(out, `-> "db2:3306"`) {
+		t.Errorf("DOT output links the failed slave:\n%s", out)
+	}
+}
+
+func TestAPITopology(t *testing.T) {
+	defer func(p string) { os.Setenv("PATH", p) }(os.Getenv("PATH"))
+	simChain(t)
+	dir := t.TempDir()
+	err := ioutil.WriteFile(filepath.Join(dir, "dot"), []byte("#!/bin/sh\nread l\n[ \"$l\" = 'digraph replication {' ] && echo '<svg/>'\n"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		query  string
+		path   string
+		code   int
+		ctype  string
+		prefix string
+	}{
+		{"dot", "", dir, 200, "text/vnd.graphviz", "digraph replication {"},
+		{"svg", "?format=svg", dir, 200, "image/svg+xml", "<svg/>"},
+		{"svg without graphviz", "?format=svg", t.TempDir(), 500, "text/plain; charset=utf-8", "Could not render SVG with Graphviz"},
+	}
+	for _, tt := range tests {
+		os.Setenv("PATH", tt.path)
+		w := httptest.NewRecorder()
+		current.apiTopology(w, httptest.NewRequest("GET", "/api/topology"+tt.query, nil))
+		if w.Code != tt.code || w.Header().Get("Content-Type") != tt.ctype || strings.HasPrefix(w.Body.String(), tt.prefix) == false {
+			t.Errorf("%s: %d %s %q, want %d %s %q", tt.name, w.Code, w.Header().Get("Content-Type"), w.Body.String(), tt.code, tt.ctype, tt.prefix)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+// users.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+/* True when the process only runs an administrative command, so that all connections use the administration user */
+var adminOnly bool
+
+/* Switches the connections of the reachable servers to the administration user for the duration of a failover or switchover, and returns the function switching them back to the monitoring user. The servers that cannot be connected to as the administration user keep the monitoring connection and are returned in the error, which is alerted. Nothing is done without -admin-user, or when the connections already use it. */
+func (c *Cluster) adminSession() (func(), error) {
+	if c.adminUser == "" || c.adminUser == c.dbUser {
+		return func() {}, nil
+	}
+	swap := func(user string, pass string) []string {
+		var failed []string
+		for _, s := range c.servers {
+			if (s.Conn == nil && s.db == nil) || s.State == STATE_FAILED {
+				continue
+			}
+			err := s.connectAs(user, pass)
+			if err != nil {
+				logprintf("WARN : Could not connect to %s as %s: %s", s.URL, user, err)
+				failed = append(failed, s.URL)
+			}
+		}
+		return failed
+	}
+	restore := func() {
+		swap(c.dbUser, c.dbPass)
+	}
+	failed := swap(c.adminUser, c.adminPass)
+	if len(failed) > 0 {
+		err := errors.New(fmt.Sprintf("Could not connect to %s as administration user %s", strings.Join(failed, ", "), c.adminUser))
+		c.alert(ALERT_ADMIN_FAILED, failed[0], "%s", err)
+		return restore, err
+	}
+	return restore, nil
+}
+
+/* Replaces the connection of the server with one as the given user */
+func (sm *ServerMonitor) connectAs(user string, pass string) error {
+	if sm.db != nil {
+		if b, ok := sm.db.(userBackend); ok {
+			return b.SwitchUser(user, pass)
+		}
+		return nil
+	}
+	conn, err := dbConnectAs(sm.address(), user, pass)
+	if err != nil {
+		return err
+	}
+	sm.Conn.Close()
+	sm.Conn = conn
+	return nil
+}
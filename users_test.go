@@ -0,0 +1,139 @@
+// users_test.go
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+/* Subscribes to the alerts, as a Watch client does, until the returned function is called */
+func simWatch() (chan WatchEvent, func()) {
+	ch := make(chan WatchEvent, 64)
+	watchers.Lock()
+	watchers.chans[ch] = ""
+	watchers.Unlock()
+	return ch, func() {
+		watchers.Lock()
+		delete(watchers.chans, ch)
+		watchers.Unlock()
+	}
+}
+
+func TestAdminSession(t *testing.T) {
+	tests := []struct {
+		name    string
+		admin   string
+		denied  string   // server refusing the administration user
+		session []string // users db3 was switched to by the session, then by its restore
+		db2     []string
+		err     string
+	}{
+		{"no administration user", "", "", nil, nil, ""},
+		{"same user", "repmgr", "", nil, nil, ""},
+		{"administration user", "root", "", []string{"root", "repmgr"}, []string{"root", "repmgr"}, ""},
+		{"refused", "root", "db2:3306", []string{"root", "repmgr"}, []string{"repmgr"}, "Could not connect to db2:3306 as administration user root"},
+	}
+	for _, tt := range tests {
+		sims := simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 1 }))
+		current.dbUser, current.dbPass, current.adminUser, current.adminPass = "repmgr", "pw", tt.admin, "rootpw"
+		for url, sim := range sims {
+			sim.users = map[string]string{"repmgr": "pw", "root": "rootpw"}
+			if url == tt.denied {
+				delete(sim.users, "root")
+			}
+		}
+		events, stop := simWatch()
+		restore, err := current.adminSession()
+		stop()
+		if (err == nil && tt.err != "") || (err != nil && err.Error() != tt.err) {
+			t.Errorf("%s: adminSession() error %v, want %q", tt.name, err, tt.err)
+		}
+		if tt.err != "" {
+			select {
+			case e := <-events:
+				if e.Alert.Event != ALERT_ADMIN_FAILED || e.Alert.Server != tt.denied {
+					t.Errorf("%s: alert %s on %s, want %s on %s", tt.name, e.Alert.Event, e.Alert.Server, ALERT_ADMIN_FAILED, tt.denied)
+				}
+			default:
+				t.Errorf("%s: no alert of the refused connection", tt.name)
+			}
+		}
+		restore()
+		if reflect.DeepEqual(sims["db3:3306"].logins, tt.session) == false {
+			t.Errorf("%s: db3:3306 logins %q, want %q", tt.name, sims["db3:3306"].logins, tt.session)
+		}
+		if reflect.DeepEqual(sims["db2:3306"].logins, tt.db2) == false {
+			t.Errorf("%s: db2:3306 logins %q, want %q", tt.name, sims["db2:3306"].logins, tt.db2)
+		}
+		// The failed master is not connected to
+		if len(sims["db1:3306"].logins) != 0 {
+			t.Errorf("%s: failed master logins %q", tt.name, sims["db1:3306"].logins)
+		}
+	}
+}
+
+func TestAdminOperations(t *testing.T) {
+	defer func(f, s string) { *failover, *stateFile = f, s }(*failover, *stateFile)
+	*failover, *stateFile = "force", ""
+	tests := []struct {
+		name   string
+		failed bool // master down, failing over instead of switching over
+		denied bool // db2 refuses the administration user
+		want   string
+	}{
+		{"switchover", false, false, "db3:3306"},
+		{"switchover refused", false, true, ""},
+		{"failover", true, false, "db3:3306"},
+		{"failover refused", true, true, "db3:3306"},
+	}
+	for _, tt := range tests {
+		sims := simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.down = tt.failed && sp.id == 1 }))
+		current.dbUser, current.dbPass, current.adminUser, current.adminPass = "repmgr", "pw", "root", "rootpw"
+		for _, sim := range sims {
+			sim.users = map[string]string{"repmgr": "pw", "root": "rootpw"}
+		}
+		if tt.denied {
+			delete(sims["db2:3306"].users, "root")
+		}
+		current.master = current.findMaster(tt.failed == false)
+		var nmUrl string
+		if tt.failed {
+			nmUrl, _ = current.Failover(context.Background())
+		} else {
+			nmUrl, _ = current.Switchover(context.Background())
+		}
+		if nmUrl != tt.want {
+			t.Errorf("%s: new master %q, want %q", tt.name, nmUrl, tt.want)
+		}
+		// The operation ran as the administration user and the monitoring connections are back
+		if got := sims["db3:3306"].logins; len(got) != 2 || got[0] != "root" || got[1] != "repmgr" {
+			t.Errorf("%s: db3:3306 logins %q, want root then repmgr", tt.name, got)
+		}
+		if tt.want == "" && sims["db3:3306"].ran("RESET SLAVE") {
+			t.Errorf("%s: candidate promoted without the administration user on all servers", tt.name)
+		}
+	}
+}
+
+func TestAdminOnly(t *testing.T) {
+	defer func(o bool) { adminOnly = o }(adminOnly)
+	tests := []struct {
+		name      string
+		adminOnly bool
+		admin     string
+		user      string
+	}{
+		{"monitor", false, "root:rootpw", "repmgr"},
+		{"command", true, "root:rootpw", "root"},
+		{"command without administration user", true, "", "repmgr"},
+	}
+	for _, tt := range tests {
+		adminOnly = tt.adminOnly
+		c := &Cluster{Name: "default", User: "repmgr:pw", AdminUser: tt.admin}
+		c.configure()
+		if c.dbUser != tt.user {
+			t.Errorf("%s: connections as %s, want %s", tt.name, c.dbUser, tt.user)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+// vip.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+/* A VIPProvider plumbs or unplumbs the floating IP on a database host */
+type VIPProvider interface {
+	Add(host string) error
+	Remove(host string) error
+}
+
+/* Moves the address with ip(8) over ssh and announces it with a gratuitous ARP */
+type ipProvider struct {
+	vip   string
+	iface string
+	user  string
+}
+
+/* Delegates the move to an external script */
+type scriptProvider struct {
+	vip    string
+	script string
+}
+
+/* Returns the provider selected by the vip-provider option for the address */
+func newVIPProvider(addr string) (VIPProvider, error) {
+	cloud, err := newCloudVIP(addr)
+	if err != nil {
+		return nil, err
+	}
+	if cloud == nil && strings.Contains(addr, "/") == false {
+		return nil, errors.New("Virtual IP must be specified in CIDR notation, e.g. 10.0.0.100/24")
+	}
+	if *dryRun {
+		return dryVIP{vip: addr}, nil
+	}
+	if cloud != nil {
+		return cloud, nil
+	}
+	switch *vipProvider {
+	case "ip":
+		return &ipProvider{vip: addr, iface: *vipIface, user: *vipSSHUser}, nil
+	case "script":
+		if *vipScript == "" {
+			return nil, errors.New("The script VIP provider requires the vip-script option")
+		}
+		return &scriptProvider{vip: addr, script: *vipScript}, nil
+	}
+	return nil, errors.New(fmt.Sprintf("Unknown VIP provider: %s", *vipProvider))
+}
+
+func (p *ipProvider) Add(host string) error {
+	addr := strings.Split(p.vip, "/")[0]
+	return p.ssh(host, fmt.Sprintf("ip addr add %s dev %s && arping -q -c 3 -U -I %s %s", p.vip, p.iface, p.iface, addr))
+}
+
+func (p *ipProvider) Remove(host string) error {
+	return p.ssh(host, fmt.Sprintf("ip addr del %s dev %s", p.vip, p.iface))
+}
+
+func (p *ipProvider) ssh(host string, cmd string) error {
+	out, err := exec.Command("ssh", "-o", "BatchMode=yes", "-o", "ConnectTimeout=5", p.user+"@"+host, cmd).CombinedOutput()
+	if err != nil {
+		return errors.New(fmt.Sprintf("%s: %s", err, strings.TrimSpace(string(out))))
+	}
+	return nil
+}
+
+func (p *scriptProvider) Add(host string) error {
+	return p.run("add", host)
+}
+
+func (p *scriptProvider) Remove(host string) error {
+	return p.run("del", host)
+}
+
+func (p *scriptProvider) run(action string, host string) error {
+	out, err := exec.Command(p.script, action, host, p.vip).CombinedOutput()
+	if err != nil {
+		return errors.New(fmt.Sprintf("%s: %s", err, strings.TrimSpace(string(out))))
+	}
+	return nil
+}
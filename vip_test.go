@@ -0,0 +1,72 @@
+// vip_test.go
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+/* Virtual IP provider recording the hosts the address is added to and removed from */
+type simVIP struct {
+	moves []string
+}
+
+func (p *simVIP) Add(host string) error {
+	p.moves = append(p.moves, "add "+host)
+	return nil
+}
+
+func (p *simVIP) Remove(host string) error {
+	p.moves = append(p.moves, "del "+host)
+	return nil
+}
+
+func TestFailoverMovesVIP(t *testing.T) {
+	defer func(f string) { *failover = f }(*failover)
+	*failover = "force"
+	simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.down = sp.id == 1 }))
+	current.master = current.findMaster(false)
+	p := &simVIP{}
+	current.vip = p
+	nmUrl, err := current.Failover(context.Background())
+	if err != nil {
+		t.Fatalf("Failover() = %s", err)
+	}
+	if nmUrl != "db3:3306" {
+		t.Errorf("promoted %q, want db3:3306", nmUrl)
+	}
+	if want := []string{"del db1", "add db3"}; reflect.DeepEqual(p.moves, want) == false {
+		t.Errorf("virtual IP moves %q, want %q", p.moves, want)
+	}
+}
+
+func TestNewVIPProvider(t *testing.T) {
+	defer func(p, s string, d bool) { *vipProvider, *vipScript, *dryRun = p, s, d }(*vipProvider, *vipScript, *dryRun)
+	tests := []struct {
+		name     string
+		addr     string
+		provider string
+		script   string
+		dry      bool
+		want     string // type of the provider, empty for an error
+	}{
+		{"ip", "10.0.0.100/24", "ip", "", false, "*main.ipProvider"},
+		{"script", "10.0.0.100/24", "script", "/bin/vip", false, "*main.scriptProvider"},
+		{"script without script", "10.0.0.100/24", "script", "", false, ""},
+		{"address without prefix", "10.0.0.100", "ip", "", false, ""},
+		{"unknown provider", "10.0.0.100/24", "carp", "", false, ""},
+		{"dry run", "10.0.0.100/24", "ip", "", true, "main.dryVIP"},
+	}
+	for _, tt := range tests {
+		*vipProvider, *vipScript, *dryRun = tt.provider, tt.script, tt.dry
+		p, err := newVIPProvider(tt.addr)
+		got := ""
+		if err == nil {
+			got = reflect.TypeOf(p).String()
+		}
+		if got != tt.want {
+			t.Errorf("%s: newVIPProvider(%q) = %q (%v), want %q", tt.name, tt.addr, got, err, tt.want)
+		}
+	}
+}
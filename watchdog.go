@@ -0,0 +1,101 @@
+// watchdog.go
+package main
+
+import (
+	"fmt"
+	"github.com/nsf/termbox-go"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+/* Time between two refreshes of the servers, set by the monitor-interval option */
+var monitorInterval = 3 * time.Second
+
+var (
+	lastCycle   int64 // unix nanoseconds of the last completed monitor cycle
+	inOperation int32 // set while a switchover or failover runs inside the loop
+)
+
+/* Marks the completion of a monitor cycle */
+func cycleDone() {
+	atomic.StoreInt64(&lastCycle, time.Now().UnixNano())
+}
+
+/* Marks the start of an operation that legitimately blocks the monitor loop, and returns the function marking its end */
+func operationStart() func() {
+	atomic.StoreInt32(&inOperation, 1)
+	return func() {
+		atomic.StoreInt32(&inOperation, 0)
+		cycleDone()
+	}
+}
+
+/* Checks that the monitor loop keeps completing cycles. It is meant to run in its own goroutine. When the loop is stuck, typically on a blocking database call, a dump of all goroutines is written to a file and an alert is raised; with the exit action the process then exits so that its supervisor restarts it. */
+func (c *Cluster) watchdog() {
+	limit := time.Duration(*watchdogCycles) * monitorInterval
+	cycleDone()
+	stalled := false
+	for range time.Tick(monitorInterval) {
+		stalled = c.stallCheck(limit, stalled)
+	}
+}
+
+/* Reports a stall once when the monitor loop has not completed a cycle within the limit, outside of an operation. Returns whether the loop is stalled. */
+func (c *Cluster) stallCheck(limit time.Duration, stalled bool) bool {
+	if atomic.LoadInt32(&inOperation) == 1 {
+		return stalled
+	}
+	since := time.Since(time.Unix(0, atomic.LoadInt64(&lastCycle)))
+	if since < limit {
+		return false
+	}
+	if stalled {
+		return true
+	}
+	dump := dumpGoroutines()
+	msg := fmt.Sprintf("Monitor loop has not completed a cycle for %s, goroutine dump written to %s", since.Round(time.Second), dump)
+	log.Printf("ERROR: %s", msg)
+	c.stallAlert(msg)
+	if *watchdogAction == "exit" {
+		if termbox.IsInit {
+			termbox.Close()
+		}
+		alertFlush()
+		log.Println("ERROR: Exiting after monitor loop stall")
+		os.Exit(3)
+	}
+	return true
+}
+
+/* Raises the stall alert for the cluster under the cluster lock. The stuck loop usually holds the lock; when it is not released within a monitor interval, the alert is sent to the routed or default channels without the state of a cluster. */
+func (c *Cluster) stallAlert(msg string) {
+	deadline := time.Now().Add(monitorInterval)
+	for clusterLock.TryLock() == false {
+		if time.Now().After(deadline) {
+			if *dryRun == false {
+				Alert{Event: ALERT_STALLED, Severity: alertSeverity[ALERT_STALLED], Message: msg, Time: time.Now()}.dispatch()
+			}
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	c.alert(ALERT_STALLED, c.clusterName(), "%s", msg)
+	clusterLock.Unlock()
+}
+
+/* Writes the stacks of all goroutines to a temporary file and returns its path */
+func dumpGoroutines() string {
+	buf := make([]byte, 1<<20)
+	n := runtime.Stack(buf, true)
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("repmgr-stall-%d.txt", time.Now().Unix()))
+	err := ioutil.WriteFile(path, buf[:n], 0640)
+	if err != nil {
+		return "nowhere: " + err.Error()
+	}
+	return path
+}
@@ -0,0 +1,104 @@
+// watchdog_test.go
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var dumpRe = regexp.MustCompile(`goroutine dump written to (\S+)$`)
+
+func TestStallCheck(t *testing.T) {
+	defer func(u string) { *webhookURL = u }(*webhookURL)
+	posts := make(chan map[string]interface{}, 8)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		posts <- payload
+	}))
+	defer srv.Close()
+	*webhookURL = srv.URL
+	simCluster(t, simTopology())
+	stall := func() { atomic.StoreInt64(&lastCycle, time.Now().Add(-time.Minute).UnixNano()) }
+	tests := []struct {
+		name      string
+		prepare   func()
+		stalled   bool
+		operation bool
+		want      bool
+		alert     bool
+	}{
+		{"cycle completed", cycleDone, false, false, false, false},
+		{"loop stalled", stall, false, false, true, true},
+		{"stall already reported", stall, true, false, true, false},
+		{"operation running", stall, false, true, false, false},
+		{"loop resumed", cycleDone, true, false, false, false},
+	}
+	for _, tt := range tests {
+		end := func() {}
+		if tt.operation {
+			end = operationStart()
+		}
+		tt.prepare()
+		if got := current.stallCheck(10*time.Second, tt.stalled); got != tt.want {
+			t.Errorf("%s: stallCheck() = %t, want %t", tt.name, got, tt.want)
+		}
+		end()
+		alertFlush()
+		var alerts []map[string]interface{}
+		for len(posts) > 0 {
+			alerts = append(alerts, <-posts)
+		}
+		if tt.alert == false {
+			if len(alerts) > 0 {
+				t.Errorf("%s: alerts %v sent, want none", tt.name, alerts)
+			}
+			continue
+		}
+		if len(alerts) != 1 || alerts[0]["event"] != ALERT_STALLED || alerts[0]["server"] != "default" {
+			t.Errorf("%s: alerts %v, want a monitor-stalled alert for the cluster", tt.name, alerts)
+			continue
+		}
+		m := dumpRe.FindStringSubmatch(alerts[0]["text"].(string))
+		if m == nil {
+			t.Errorf("%s: alert %q does not name the goroutine dump", tt.name, alerts[0]["text"])
+			continue
+		}
+		dump, err := ioutil.ReadFile(m[1])
+		os.Remove(m[1])
+		if err != nil || strings.Contains(string(dump), "TestStallCheck") == false {
+			t.Errorf("%s: goroutine dump %s does not hold the stack of the test: %v", tt.name, m[1], err)
+		}
+	}
+}
+
+func TestStallAlertLocked(t *testing.T) {
+	defer func(u string, d time.Duration) { *webhookURL, monitorInterval = u, d }(*webhookURL, monitorInterval)
+	posts := make(chan map[string]interface{}, 8)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		posts <- payload
+	}))
+	defer srv.Close()
+	*webhookURL, monitorInterval = srv.URL, 200*time.Millisecond
+	simCluster(t, simTopology())
+	clusterLock.Lock()
+	current.stallAlert("Monitor loop has not completed a cycle")
+	clusterLock.Unlock()
+	alertFlush()
+	if len(posts) != 1 {
+		t.Fatalf("%d alerts sent while the loop holds the lock, want 1", len(posts))
+	}
+	if p := <-posts; p["event"] != ALERT_STALLED || p["server"] != "" {
+		t.Errorf("alert %v, want a monitor-stalled alert without a cluster", p)
+	}
+}
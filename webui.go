@@ -0,0 +1,188 @@
+// webui.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+/* Serves the web dashboard */
+func webDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, dashboardHTML)
+}
+
+/* Serves the machine readable view of the cluster, as written by the JSON output mode */
+func (c *Cluster) apiStatus(w http.ResponseWriter, r *http.Request) {
+	apiWrite(w, c.buildReport())
+}
+
+/* Runs a switchover or failover of the cluster requested by a remote client. The confirmation must name the current master, so that a client showing an outdated topology cannot act on it. Returns the URL of the new master, or an error which is a conflict when the request does not match the state of the cluster. */
+func (c *Cluster) controlAction(kind string, confirm string, trigger string) (string, bool, error) {
+	if isStandby() {
+		return "", true, errors.New("This instance is a standby, the active instance is " + leaderHolder())
+	}
+	if c.master == nil || confirm != c.master.URL {
+		return "", true, errors.New("The confirmation must be the URL of the current master")
+	}
+	if kind == "switchover" && c.master.State == STATE_FAILED {
+		return "", true, errors.New("The master is failed, fail over instead")
+	}
+	if kind == "failover" && c.master.State != STATE_FAILED {
+		return "", true, errors.New("The master is not failed, switch over instead")
+	}
+	c.opTrigger = trigger
+	var nmUrl string
+	var err error
+	if kind == "switchover" {
+		nmUrl, err = c.Switchover(context.Background())
+	} else {
+		nmUrl, err = c.Failover(context.Background())
+	}
+	return nmUrl, false, err
+}
+
+/* Serves POST /api/switchover and /api/failover for the web dashboard, confirmed with the confirm parameter */
+func apiAction(kind string) func(c *Cluster, w http.ResponseWriter, r *http.Request) {
+	return func(c *Cluster, w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		nmUrl, conflict, err := c.controlAction(kind, r.FormValue("confirm"), "web "+requestUser(r))
+		if conflict {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		apiWrite(w, map[string]string{"master": nmUrl})
+	}
+}
+
+/* Serves POST /api/abort, which aborts the switchover or failover in progress on the cluster */
+func apiAbort(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	c := requestCluster(w, r)
+	if c == nil {
+		return
+	}
+	err := c.abortOperation("web " + requestUser(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	apiWrite(w, map[string]bool{"aborted": true})
+}
+
+const dashboardHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Replication Manager</title>
+<style>
+body { font-family: sans-serif; margin: 1em 2em; background: #fafafa; color: #222; }
+h1 { font-size: 1.3em; }
+.server { display: inline-block; vertical-align: top; border: 1px solid #bbb; border-radius: 4px; padding: .5em .8em; margin: .3em; background: #fff; min-width: 16em; }
+.master { border: 2px solid #2a6; }
+.failed { border-color: #c33; background: #fee; }
+.issue { color: #c33; font-size: .85em; }
+.muted { color: #888; font-size: .85em; }
+button { margin-right: .5em; padding: .3em 1em; }
+svg { display: block; margin-top: .3em; }
+</style>
+</head>
+<body>
+<h1>Replication Manager <select id="cluster"></select> <span id="health" class="muted"></span></h1>
+<div>
+<button id="switchover">Switchover</button>
+<button id="failover">Failover</button>
+<button id="abort" style="display: none">Abort</button>
+<span id="result"></span>
+</div>
+<h2>Master</h2>
+<div id="master"></div>
+<h2>Slaves</h2>
+<div id="slaves"></div>
+<script>
+var last = null;
+function q(path, cluster) {
+  var c = cluster || document.getElementById("cluster").value;
+  return path + (c ? (path.indexOf("?") < 0 ? "?" : "&") + "cluster=" + encodeURIComponent(c) : "");
+}
+function esc(s) {
+  return String(s == null ? "" : s).replace(/[&<>"]/g, function(c) { return {"&": "&amp;", "<": "&lt;", ">": "&gt;", '"': "&quot;"}[c]; });
+}
+function graph(url, el) {
+  fetch(q("/api/servers/" + encodeURIComponent(url) + "/history")).then(function(r) { return r.json(); }).then(function(samples) {
+    if (!samples || samples.length < 2) { el.innerHTML = '<span class="muted">No lag history, see -history-file</span>'; return; }
+    samples = samples.slice(-120);
+    var max = 1;
+    samples.forEach(function(s) { max = Math.max(max, s.MaxDelay); });
+    var pts = samples.map(function(s, i) { return (i * 200 / (samples.length - 1)).toFixed(1) + "," + (40 - s.Delay * 40 / max).toFixed(1); });
+    el.innerHTML = '<svg width="200" height="42"><polyline fill="none" stroke="#36c" points="' + pts.join(" ") + '"/></svg><span class="muted">lag, max ' + max + 's</span>';
+  }).catch(function() {});
+}
+function card(s, isMaster) {
+  var cls = "server" + (isMaster ? " master" : "") + (s.State == "Failed" ? " failed" : "");
+  var h = '<div class="' + cls + '"><b>' + esc(s.Name || s.URL) + '</b> <span class="muted">' + esc(s.URL) + '</span><br>' + esc(s.State);
+  if (!isMaster) {
+    h += ', delay ' + (s.Delay == null ? "n/a" : s.Delay + "s") + ', IO ' + esc(s.IOThread) + ', SQL ' + esc(s.SQLThread);
+  }
+  h += '<br><span class="muted">GTID ' + esc(s.CurrentGtid) + '</span>';
+  [s.IOError, s.SQLError, s.ReadOnlyIssue].forEach(function(e) { if (e) { h += '<div class="issue">' + esc(e) + '</div>'; } });
+  return h + '<div class="graph" data-url="' + esc(s.URL) + '"></div></div>';
+}
+function refresh() {
+  fetch(q("/api/status")).then(function(r) { return r.json(); }).then(function(st) {
+    last = st;
+    var m = null, slaves = [];
+    (st.Servers || []).forEach(function(s) { if (s.URL == st.Master) { m = s; } else if (s.State != "Unconnected") { slaves.push(s); } });
+    document.getElementById("health").textContent = "health " + st.Health.Score + "/100" + (st.Health.Issues ? ", " + st.Health.Issues.join(", ") : "");
+    document.getElementById("master").innerHTML = m ? card(m, true) : "No master";
+    document.getElementById("slaves").innerHTML = slaves.map(function(s) { return card(s, false); }).join("") || "No slaves";
+    document.getElementById("switchover").disabled = !m || m.State == "Failed";
+    document.getElementById("failover").disabled = !m || m.State != "Failed";
+    Array.prototype.forEach.call(document.querySelectorAll(".graph"), function(el) { graph(el.getAttribute("data-url"), el); });
+  });
+}
+function act(kind) {
+  if (!last || !confirm("Run a " + kind + " of master " + last.Master + " on cluster " + (last.Health.Name || "") + "?")) { return; }
+  var res = document.getElementById("result");
+  var abort = document.getElementById("abort");
+  res.textContent = kind + " running...";
+  abort.style.display = "";
+  fetch(q("/api/" + kind), {method: "POST", headers: {"Content-Type": "application/x-www-form-urlencoded", "X-Replication-Manager": "dashboard"}, body: "confirm=" + encodeURIComponent(last.Master)})
+    .then(function(r) { return r.text().then(function(t) { res.textContent = r.ok ? kind + " complete, new master " + JSON.parse(t).master : t; }); })
+    .then(function() { abort.style.display = "none"; refresh(); });
+}
+document.getElementById("switchover").onclick = function() { act("switchover"); };
+document.getElementById("failover").onclick = function() { act("failover"); };
+document.getElementById("abort").onclick = function() {
+  fetch("/api/abort", {method: "POST", headers: {"X-Replication-Manager": "dashboard"}}).then(function(r) { return r.text(); }).then(function(t) {
+    if (t.indexOf("aborted") < 0) { document.getElementById("result").textContent = t; }
+  });
+};
+document.getElementById("cluster").onchange = refresh;
+fetch("/api/clusters").then(function(r) { return r.json(); }).then(function(l) {
+  var sel = document.getElementById("cluster");
+  l.forEach(function(c) { var o = document.createElement("option"); o.value = o.textContent = c.Name; sel.appendChild(o); });
+  sel.style.display = l.length > 1 ? "" : "none";
+  refresh();
+  setInterval(refresh, 5000);
+});
+</script>
+</body>
+</html>
+`
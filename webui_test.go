@@ -0,0 +1,83 @@
+// webui_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestWebDashboard(t *testing.T) {
+	tests := []struct {
+		path  string
+		code  int
+		ctype string
+	}{
+		{"/", 200, "text/html; charset=utf-8"},
+		{"/index.html", 404, "text/plain; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		webDashboard(w, httptest.NewRequest("GET", tt.path, nil))
+		if w.Code != tt.code || w.Header().Get("Content-Type") != tt.ctype {
+			t.Errorf("%s: %d %s, want %d %s", tt.path, w.Code, w.Header().Get("Content-Type"), tt.code, tt.ctype)
+		}
+	}
+	simCluster(t, simTopology())
+	current.master = current.findMaster(true)
+	w := httptest.NewRecorder()
+	current.apiStatus(w, httptest.NewRequest("GET", "/api/status", nil))
+	var rep Report
+	if err := json.NewDecoder(w.Body).Decode(&rep); err != nil || rep.Master != "db1:3306" || len(rep.Servers) != 3 {
+		t.Errorf("status %+v, %v, want db1:3306 and three servers", rep, err)
+	}
+}
+
+func TestAPIAction(t *testing.T) {
+	defer func(f, s string) { *failover, *stateFile = f, s }(*failover, *stateFile)
+	*failover, *stateFile = "force", ""
+	tests := []struct {
+		name    string
+		kind    string
+		method  string
+		failed  bool // master down
+		confirm string
+		code    int
+		body    string
+	}{
+		{"switchover", "switchover", "POST", false, "db1:3306", 200, `{"master":"db3:3306"}`},
+		{"failover", "failover", "POST", true, "db1:3306", 200, `{"master":"db3:3306"}`},
+		{"GET", "switchover", "GET", false, "db1:3306", 405, "Method not allowed"},
+		{"no confirmation", "switchover", "POST", false, "", 409, "The confirmation must be the URL of the current master"},
+		{"outdated confirmation", "switchover", "POST", false, "db2:3306", 409, "The confirmation must be the URL of the current master"},
+		{"switchover of a failed master", "switchover", "POST", true, "db1:3306", 409, "The master is failed, fail over instead"},
+		{"failover of a running master", "failover", "POST", false, "db1:3306", 409, "The master is not failed, switch over instead"},
+	}
+	for _, tt := range tests {
+		simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.down = tt.failed && sp.id == 1 }))
+		current.master = current.findMaster(tt.failed == false)
+		if tt.failed {
+			current.master.State = STATE_FAILED
+		}
+		r := httptest.NewRequest(tt.method, "/api/"+tt.kind, strings.NewReader(url.Values{"confirm": {tt.confirm}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		apiAction(tt.kind)(current, w, r)
+		if w.Code != tt.code || strings.TrimSpace(w.Body.String()) != tt.body {
+			t.Errorf("%s: %d %q, want %d %q", tt.name, w.Code, strings.TrimSpace(w.Body.String()), tt.code, tt.body)
+		}
+		n := len(current.stateData.History)
+		if tt.code != 200 {
+			if n != 0 {
+				t.Errorf("%s: operation recorded after a refused request", tt.name)
+			}
+			continue
+		}
+		// The operation is recorded as triggered from the web dashboard
+		if n != 1 || current.stateData.History[0].Type != tt.kind || strings.HasPrefix(current.stateData.History[0].Trigger, "web ") == false {
+			t.Errorf("%s: history %+v, want a %s triggered from the web", tt.name, current.stateData.History, tt.kind)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+// weights.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+/* Parses a preferred master list, host:[port][:weight],... where a server without weight has a weight of 1 */
+func parseWeights(s string) (map[string]int, error) {
+	w := make(map[string]int)
+	if s == "" {
+		return w, nil
+	}
+	for _, item := range strings.Split(s, ",") {
+		host, rest := splitHost(item)
+		items := strings.Split(rest, ":")
+		url, weight := item, 1
+		if len(items) == 2 {
+			n, err := strconv.Atoi(items[1])
+			if err != nil || n < 1 {
+				return nil, errors.New(fmt.Sprintf("invalid weight in preferred master %s", item))
+			}
+			url, weight = net.JoinHostPort(host, items[0]), n
+		}
+		w[url] = weight
+	}
+	return w, nil
+}
+
+/* Returns the highest promotion weight */
+func (c *Cluster) maxWeight() int {
+	max := 0
+	for _, w := range c.weights {
+		if w > max {
+			max = w
+		}
+	}
+	return max
+}
@@ -0,0 +1,59 @@
+// weights_test.go
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseWeights(t *testing.T) {
+	tests := []struct {
+		name string
+		list string
+		want map[string]int
+		ok   bool
+	}{
+		{"empty", "", map[string]int{}, true},
+		{"without weights", "db1:3306,db2:3306", map[string]int{"db1:3306": 1, "db2:3306": 1}, true},
+		{"with weights", "db1:3306:100,db2:3306:50,db3:3306", map[string]int{"db1:3306": 100, "db2:3306": 50, "db3:3306": 1}, true},
+		{"IPv6", "[fd00::2]:3306:20", map[string]int{"[fd00::2]:3306": 20}, true},
+		{"zero weight", "db1:3306:0", nil, false},
+		{"invalid weight", "db1:3306:high", nil, false},
+	}
+	for _, tt := range tests {
+		got, err := parseWeights(tt.list)
+		if (err == nil) != tt.ok || reflect.DeepEqual(got, tt.want) == false {
+			t.Errorf("%s: parseWeights(%q) = %v, %v, want %v", tt.name, tt.list, got, err, tt.want)
+		}
+	}
+}
+
+func TestWeightedElection(t *testing.T) {
+	defer func(m string) { *electionMode = m }(*electionMode)
+	*electionMode = "most-advanced"
+	tests := []struct {
+		name    string
+		weights map[string]int
+		want    string
+	}{
+		{"no weights", nil, "db2:3306"},
+		{"heaviest wins", map[string]int{"db2:3306": 50, "db3:3306": 100}, "db3:3306"},
+		{"heaviest wins again", map[string]int{"db2:3306": 100, "db3:3306": 50}, "db2:3306"},
+		{"weighted over unweighted", map[string]int{"db3:3306": 1}, "db3:3306"},
+	}
+	for _, tt := range tests {
+		// Both slaves are equally caught up
+		simCluster(t, simChange(simTopology(), func(sp *simSpec) { sp.gtid = "0-1-120" }))
+		current.master = current.findMaster(true)
+		if tt.weights != nil {
+			current.weights = tt.weights
+		}
+		got := ""
+		if key := current.master.electCandidate(current.slaves); key >= 0 {
+			got = current.slaves[key].URL
+		}
+		if got != tt.want {
+			t.Errorf("%s: elected %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}